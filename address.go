@@ -0,0 +1,192 @@
+package virtuakube
+
+import (
+	"fmt"
+	"net"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// AddressConfig pins a VM's addresses on one of its networks, instead
+// of having virtuakube allocate them. Unset fields are allocated as
+// usual. Pinned addresses must not be in use by anything else in the
+// universe, and allocated addresses skip them.
+type AddressConfig struct {
+	// MAC is the unicast MAC address of the VM's NIC, e.g.
+	// 52:54:00:12:34:56.
+	MAC string
+	// IPv4 must be a host address of the network's IPv4 subnet,
+	// 10.248.N.1 to 10.248.N.254.
+	IPv4 net.IP
+	// IPv6 must be in the network's IPv6 subnet, fd00:N::/64, other
+	// than its subnet-router anycast address fd00:N::.
+	IPv6 net.IP
+}
+
+// validate checks the parts of the pinned addresses that don't depend
+// on the network.
+func (a *AddressConfig) validate() error {
+	if a.MAC != "" {
+		if _, err := parseMAC(a.MAC); err != nil {
+			return err
+		}
+	}
+	if a.IPv4 != nil && a.IPv4.To4() == nil {
+		return fmt.Errorf("%s is not an IPv4 address", a.IPv4)
+	}
+	if a.IPv6 != nil && (a.IPv6.To16() == nil || a.IPv6.To4() != nil) {
+		return fmt.Errorf("%s is not an IPv6 address", a.IPv6)
+	}
+	return nil
+}
+
+// addrs returns the normalized pinned addresses, as claimed on
+// networks.
+func (a *AddressConfig) addrs() []string {
+	var ret []string
+	if mac, err := parseMAC(a.MAC); err == nil {
+		ret = append(ret, mac)
+	}
+	if a.IPv4 != nil {
+		ret = append(ret, a.IPv4.String())
+	}
+	if a.IPv6 != nil {
+		ret = append(ret, a.IPv6.String())
+	}
+	return ret
+}
+
+// parseMAC returns the normalized form of the unicast MAC address
+// mac.
+func parseMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("invalid MAC address %q", mac)
+	}
+	if hw[0]&1 != 0 {
+		return "", fmt.Errorf("MAC address %s is multicast", hw)
+	}
+	return hw.String(), nil
+}
+
+// validateAddresses checks the pinned addresses of a VM attached to
+// networks.
+func validateAddresses(addrs map[string]AddressConfig, networks []string) error {
+	attached := map[string]bool{}
+	for _, net := range networks {
+		attached[net] = true
+	}
+	for net, a := range addrs {
+		if !attached[net] {
+			return fmt.Errorf("Addresses pins addresses on network %q, which the VM isn't attached to", net)
+		}
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("addresses on network %q: %v", net, err)
+		}
+	}
+	return nil
+}
+
+// addresses returns the MAC, IPv4 and IPv6 addresses of a new VM on
+// nw: those pinned by pin, and newly allocated ones for the rest.
+// Pinned addresses are claimed by owner.
+func (nw *Network) addresses(owner string, pin AddressConfig) (string, net.IP, net.IP, error) {
+	mac, ip4, ip6 := randomMAC(), pin.IPv4, pin.IPv6
+	if pin.MAC != "" {
+		var err error
+		if mac, err = parseMAC(pin.MAC); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if ip4 != nil {
+		ip4 = ip4.To4()
+		subnet := nw.IPv4Subnet()
+		if !subnet.Contains(ip4) || ip4[3] == 0 || ip4[3] == 255 {
+			return "", nil, nil, fmt.Errorf("%s isn't a host address of network %q's subnet %s", ip4, nw.Name(), subnet)
+		}
+	}
+	if ip6 != nil {
+		subnet := nw.IPv6Subnet()
+		if !subnet.Contains(ip6) || ip6.Equal(subnet.IP) {
+			return "", nil, nil, fmt.Errorf("%s isn't a host address of network %q's subnet %s", ip6, nw.Name(), subnet)
+		}
+	}
+	if err := nw.claim(owner, pin.addrs()...); err != nil {
+		return "", nil, nil, err
+	}
+
+	if ip4 == nil || ip6 == nil {
+		a4, a6, err := nw.ip()
+		if err != nil {
+			nw.unclaim(pin.addrs()...)
+			return "", nil, nil, err
+		}
+		if ip4 == nil {
+			ip4 = a4
+		}
+		if ip6 == nil {
+			ip6 = a6
+		}
+	}
+	return mac, ip4, ip6, nil
+}
+
+// vmAddresses returns the addresses that the VM in cfg uses, by
+// network name.
+func vmAddresses(cfg *config.VM) map[string][]string {
+	ret := map[string][]string{}
+	add := func(net, mac string, ip4, ip6 net.IP) {
+		if mac != "" {
+			ret[net] = append(ret[net], mac)
+		}
+		if ip4 != nil {
+			ret[net] = append(ret[net], ip4.String())
+		}
+		if ip6 != nil {
+			ret[net] = append(ret[net], ip6.String())
+		}
+	}
+	for _, net := range cfg.Networks {
+		add(net, cfg.MAC[net], cfg.IPv4[net], cfg.IPv6[net])
+	}
+	for _, nic := range cfg.NICs {
+		add(nic.Network, nic.MAC, nic.IPv4, nic.IPv6)
+	}
+	return ret
+}
+
+// claimAddressesWithLock claims the addresses of the VM in cfg on the
+// universe's networks.
+func (u *Universe) claimAddressesWithLock(cfg *config.VM) error {
+	var claimed []func()
+	for net, addrs := range vmAddresses(cfg) {
+		nw := u.networks[net]
+		if nw == nil {
+			continue
+		}
+		// Claims on a network are all or nothing.
+		if err := nw.claim(vmOwner(cfg.Name), addrs...); err != nil {
+			for _, unclaim := range claimed {
+				unclaim()
+			}
+			return err
+		}
+		claimed = append(claimed, func() { nw.unclaim(addrs...) })
+	}
+	return nil
+}
+
+// unclaimAddressesWithLock releases the addresses of the VM in cfg on
+// the universe's networks.
+func (u *Universe) unclaimAddressesWithLock(cfg *config.VM) {
+	for net, addrs := range vmAddresses(cfg) {
+		if nw := u.networks[net]; nw != nil {
+			nw.unclaim(addrs...)
+		}
+	}
+}
+
+// vmOwner describes the named VM, as an owner of network addresses.
+func vmOwner(name string) string {
+	return fmt.Sprintf("VM %q", name)
+}
@@ -0,0 +1,411 @@
+package virtuakube
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The qemu-guest-agent in a VM's guest talks to QEMU over a virtio
+// serial port rather than the network, so it can run commands, move
+// files and report addresses when the guest's networking is broken,
+// which is when diagnostics are most needed. virtuakube connects to
+// the port's host end directly, speaking the agent's QMP-like JSON
+// protocol.
+
+const (
+	// agentPortName is the name of the virtio serial port that the
+	// guest agent listens on.
+	agentPortName = "org.qemu.guest_agent.0"
+	// agentSyncTimeout is how long to wait for the agent to answer
+	// before deciding that the guest doesn't run one.
+	agentSyncTimeout = 5 * time.Second
+	// agentReadSize is how much of a file each guest-file-read
+	// transfers.
+	agentReadSize = 48 << 10
+)
+
+// errNoAgent means that the VM's guest agent doesn't answer, e.g.
+// because its image predates guest agent support.
+var errNoAgent = errors.New("guest agent isn't answering")
+
+// agentSocket returns the path of the host end of the VM's guest
+// agent port.
+func (v *VM) agentSocket() string {
+	return filepath.Join(v.universe.tmpdir, "qga-"+v.cfg.Name)
+}
+
+// agentArgs returns the qemu arguments for the VM's guest agent port,
+// if it has one.
+func agentArgs(v *VM) ([]string, error) {
+	if !v.cfg.GuestAgent {
+		return nil, nil
+	}
+	// Like the console, qemu gets a path relative to the universe.
+	sock, err := filepath.Rel(v.universe.dir, v.agentSocket())
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"-chardev", fmt.Sprintf("socket,id=qga,path=%s,server,nowait", sock),
+		"-device", "virtserialport,chardev=qga,name=" + agentPortName,
+	}, nil
+}
+
+// agentConn is a connection to a VM's guest agent.
+type agentConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	stop func() bool
+}
+
+// agent connects to the VM's guest agent, and synchronizes with it.
+// The agent's port takes one client at a time, so callers hold
+// v.agentMu for as long as the connection is open.
+func (v *VM) agent(ctx context.Context) (*agentConn, error) {
+	if !v.cfg.GuestAgent {
+		return nil, errNoAgent
+	}
+	conn, err := net.Dial("unix", v.agentSocket())
+	if err != nil {
+		return nil, errNoAgent
+	}
+	a := &agentConn{conn: conn, r: bufio.NewReader(conn)}
+
+	conn.SetDeadline(time.Now().Add(agentSyncTimeout))
+	if err := a.sync(); err != nil {
+		conn.Close()
+		return nil, errNoAgent
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Canceling ctx interrupts whatever call is in flight.
+	a.stop = context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	return a, nil
+}
+
+func (a *agentConn) Close() error {
+	a.stop()
+	return a.conn.Close()
+}
+
+// sync discards whatever a previous client left unread, by asking the
+// agent to echo a random ID preceded by a 0xFF byte, and skipping to
+// it.
+func (a *agentConn) sync() error {
+	id := rand.Int63()
+	// A delimiter byte also makes the agent drop any partial command
+	// a previous client left.
+	if _, err := a.conn.Write([]byte{0xff}); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(a.conn).Encode(map[string]interface{}{
+		"execute":   "guest-sync-delimited",
+		"arguments": map[string]interface{}{"id": id},
+	}); err != nil {
+		return err
+	}
+	for {
+		if _, err := a.r.ReadBytes(0xff); err != nil {
+			return err
+		}
+		line, err := a.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		var resp struct {
+			Return int64
+		}
+		if json.Unmarshal([]byte(line), &resp) == nil && resp.Return == id {
+			return nil
+		}
+	}
+}
+
+// call runs the agent command cmd with args, and decodes its return
+// value into ret, if non-nil.
+func (a *agentConn) call(cmd string, args interface{}, ret interface{}) error {
+	req := map[string]interface{}{"execute": cmd}
+	if args != nil {
+		req["arguments"] = args
+	}
+	if err := json.NewEncoder(a.conn).Encode(req); err != nil {
+		return fmt.Errorf("guest agent %s: %v", cmd, err)
+	}
+	for {
+		line, err := a.r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("guest agent %s: %v", cmd, err)
+		}
+		line = strings.TrimLeft(line, "\xff")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var resp struct {
+			Return json.RawMessage
+			Error  *struct {
+				Class string
+				Desc  string
+			}
+		}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			return fmt.Errorf("guest agent %s: decoding response: %v", cmd, err)
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("guest agent %s: %s", cmd, resp.Error.Desc)
+		}
+		if ret == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Return, ret)
+	}
+}
+
+// exec runs command with sh in the guest, and returns its stdout, or
+// an error with its stderr if it fails.
+func (a *agentConn) exec(ctx context.Context, command string) ([]byte, error) {
+	var started struct {
+		PID int
+	}
+	err := a.call("guest-exec", map[string]interface{}{
+		"path":           "/bin/sh",
+		"arg":            []string{"-c", command},
+		"capture-output": true,
+	}, &started)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var st struct {
+			Exited   bool
+			ExitCode int    `json:"exitcode"`
+			Signal   int    `json:"signal"`
+			OutData  []byte `json:"out-data"`
+			ErrData  []byte `json:"err-data"`
+		}
+		if err := a.call("guest-exec-status", map[string]interface{}{"pid": started.PID}, &st); err != nil {
+			return nil, err
+		}
+		if st.Exited {
+			switch {
+			case st.Signal != 0:
+				return st.OutData, fmt.Errorf("command killed by signal %d: %s", st.Signal, strings.TrimSpace(string(st.ErrData)))
+			case st.ExitCode != 0:
+				return st.OutData, fmt.Errorf("command exited with status %d: %s", st.ExitCode, strings.TrimSpace(string(st.ErrData)))
+			}
+			return st.OutData, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (a *agentConn) readFile(path string) ([]byte, error) {
+	var handle int
+	if err := a.call("guest-file-open", map[string]interface{}{"path": path, "mode": "r"}, &handle); err != nil {
+		return nil, err
+	}
+	defer a.call("guest-file-close", map[string]interface{}{"handle": handle}, nil)
+
+	var ret []byte
+	for {
+		var chunk struct {
+			Buf []byte `json:"buf-b64"`
+			EOF bool   `json:"eof"`
+		}
+		if err := a.call("guest-file-read", map[string]interface{}{"handle": handle, "count": agentReadSize}, &chunk); err != nil {
+			return nil, err
+		}
+		ret = append(ret, chunk.Buf...)
+		if chunk.EOF || len(chunk.Buf) == 0 {
+			return ret, nil
+		}
+	}
+}
+
+func (a *agentConn) writeFile(path string, bs []byte) error {
+	var handle int
+	if err := a.call("guest-file-open", map[string]interface{}{"path": path, "mode": "w"}, &handle); err != nil {
+		return err
+	}
+	for len(bs) > 0 {
+		n := len(bs)
+		if n > agentReadSize {
+			n = agentReadSize
+		}
+		err := a.call("guest-file-write", map[string]interface{}{
+			"handle":  handle,
+			"buf-b64": base64.StdEncoding.EncodeToString(bs[:n]),
+		}, nil)
+		if err != nil {
+			a.call("guest-file-close", map[string]interface{}{"handle": handle}, nil)
+			return err
+		}
+		bs = bs[n:]
+	}
+	return a.call("guest-file-close", map[string]interface{}{"handle": handle}, nil)
+}
+
+// GuestInterface is a network interface of a VM's guest.
+type GuestInterface struct {
+	Name string
+	// MAC is the interface's hardware address. It's only known when
+	// the guest agent reports the interfaces.
+	MAC string
+	// Addrs are the interface's addresses, with their prefix
+	// lengths, e.g. "10.0.2.15/24".
+	Addrs []string
+}
+
+func (a *agentConn) interfaces() ([]GuestInterface, error) {
+	var ifs []struct {
+		Name  string
+		MAC   string `json:"hardware-address"`
+		Addrs []struct {
+			IP     string `json:"ip-address"`
+			Prefix int    `json:"prefix"`
+		} `json:"ip-addresses"`
+	}
+	if err := a.call("guest-network-get-interfaces", nil, &ifs); err != nil {
+		return nil, err
+	}
+	var ret []GuestInterface
+	for _, i := range ifs {
+		gi := GuestInterface{Name: i.Name, MAC: i.MAC}
+		for _, addr := range i.Addrs {
+			gi.Addrs = append(gi.Addrs, fmt.Sprintf("%s/%d", addr.IP, addr.Prefix))
+		}
+		ret = append(ret, gi)
+	}
+	return ret, nil
+}
+
+// freezeFS freezes the guest's filesystems, flushing their pending
+// writes, or thaws them if freeze is false.
+func (a *agentConn) freezeFS(freeze bool) error {
+	cmd := "guest-fsfreeze-thaw"
+	if freeze {
+		cmd = "guest-fsfreeze-freeze"
+	}
+	var n int
+	return a.call(cmd, nil, &n)
+}
+
+// withAgent calls do with a connection to the VM's guest agent, or
+// returns errNoAgent if the agent isn't answering.
+func (v *VM) withAgent(ctx context.Context, do func(a *agentConn) error) error {
+	v.agentMu.Lock()
+	defer v.agentMu.Unlock()
+	a, err := v.agent(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return do(a)
+}
+
+// GuestExec runs the given shell command as root on the VM through
+// its guest agent, and returns its output. The agent doesn't need the
+// guest's network, so GuestExec works when SSH doesn't, e.g. to
+// diagnose broken guest networking. If the VM has no guest agent
+// (VMs created before guest agent support, or from images without
+// qemu-guest-agent), GuestExec falls back to SSH, as Run does.
+//
+// Output only comes back once the command exits, and only stdout is
+// returned: stderr is included in the error of failed commands.
+func (v *VM) GuestExec(ctx context.Context, command string) ([]byte, error) {
+	v.log.Info(logCommand, "command", command, "transport", "agent")
+	var out []byte
+	err := v.withAgent(ctx, func(a *agentConn) error {
+		var err error
+		out, err = a.exec(ctx, command)
+		return err
+	})
+	if err == errNoAgent {
+		return v.Run(command)
+	}
+	return out, err
+}
+
+// GuestReadFile reads the file at path on the VM through its guest
+// agent, falling back to SSH like GuestExec.
+func (v *VM) GuestReadFile(ctx context.Context, path string) ([]byte, error) {
+	var ret []byte
+	err := v.withAgent(ctx, func(a *agentConn) error {
+		var err error
+		ret, err = a.readFile(path)
+		return err
+	})
+	if err == errNoAgent {
+		return v.ReadFile(path)
+	}
+	return ret, err
+}
+
+// GuestWriteFile writes bs to path on the VM through its guest agent,
+// falling back to SSH like GuestExec.
+func (v *VM) GuestWriteFile(ctx context.Context, path string, bs []byte) error {
+	err := v.withAgent(ctx, func(a *agentConn) error {
+		return a.writeFile(path, bs)
+	})
+	if err == errNoAgent {
+		return v.WriteFile(path, bs)
+	}
+	return err
+}
+
+// GuestInterfaces returns the guest's network interfaces and their
+// addresses, as the guest sees them, including addresses that
+// virtuakube didn't assign, e.g. from DHCP or a CNI. It asks the
+// guest agent, falling back to SSH like GuestExec.
+func (v *VM) GuestInterfaces(ctx context.Context) ([]GuestInterface, error) {
+	var ret []GuestInterface
+	err := v.withAgent(ctx, func(a *agentConn) error {
+		var err error
+		ret, err = a.interfaces()
+		return err
+	})
+	if err != errNoAgent {
+		return ret, err
+	}
+
+	out, err := v.Run("ip -o addr show")
+	if err != nil {
+		return nil, err
+	}
+	return parseIPAddrs(string(out)), nil
+}
+
+// parseIPAddrs parses the output of "ip -o addr show", whose lines
+// look like "2: enp0s2    inet 10.0.2.15/24 brd ...".
+func parseIPAddrs(out string) []GuestInterface {
+	var ret []GuestInterface
+	index := map[string]int{}
+	for _, line := range strings.Split(out, "\n") {
+		fs := strings.Fields(line)
+		if len(fs) < 4 || (fs[2] != "inet" && fs[2] != "inet6") {
+			continue
+		}
+		name := strings.TrimSuffix(fs[1], ":")
+		i, ok := index[name]
+		if !ok {
+			i = len(ret)
+			index[name] = i
+			ret = append(ret, GuestInterface{Name: name})
+		}
+		ret[i].Addrs = append(ret[i].Addrs, fs[3])
+	}
+	return ret
+}
@@ -0,0 +1,5 @@
+// Package api contains the gRPC API of vkube serve, generated from
+// virtuakube.proto. See virtuakube.Server.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative virtuakube.proto
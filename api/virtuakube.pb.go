@@ -0,0 +1,1761 @@
+// The gRPC API of vkube serve, see virtuakube.Server. Its operations
+// are the same as the server's JSON API over HTTP.
+//
+// VM and cluster configurations are passed as the JSON encoding of
+// virtuakube.VMConfig and virtuakube.ClusterConfig, as in the JSON
+// API, rather than mirrored here: they have dozens of options, which
+// grow with every release.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: virtuakube.proto
+
+package api
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ListUniversesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUniversesRequest) Reset() {
+	*x = ListUniversesRequest{}
+	mi := &file_virtuakube_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUniversesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUniversesRequest) ProtoMessage() {}
+
+func (x *ListUniversesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUniversesRequest.ProtoReflect.Descriptor instead.
+func (*ListUniversesRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{0}
+}
+
+type ListUniversesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Universes     []*UniverseStatus      `protobuf:"bytes,1,rep,name=universes,proto3" json:"universes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUniversesResponse) Reset() {
+	*x = ListUniversesResponse{}
+	mi := &file_virtuakube_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUniversesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUniversesResponse) ProtoMessage() {}
+
+func (x *ListUniversesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUniversesResponse.ProtoReflect.Descriptor instead.
+func (*ListUniversesResponse) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListUniversesResponse) GetUniverses() []*UniverseStatus {
+	if x != nil {
+		return x.Universes
+	}
+	return nil
+}
+
+type OpenUniverseRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// The snapshot to resume, for existing universes.
+	Snapshot      string `protobuf:"bytes,2,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OpenUniverseRequest) Reset() {
+	*x = OpenUniverseRequest{}
+	mi := &file_virtuakube_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OpenUniverseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OpenUniverseRequest) ProtoMessage() {}
+
+func (x *OpenUniverseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OpenUniverseRequest.ProtoReflect.Descriptor instead.
+func (*OpenUniverseRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *OpenUniverseRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *OpenUniverseRequest) GetSnapshot() string {
+	if x != nil {
+		return x.Snapshot
+	}
+	return ""
+}
+
+type GetUniverseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUniverseRequest) Reset() {
+	*x = GetUniverseRequest{}
+	mi := &file_virtuakube_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUniverseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUniverseRequest) ProtoMessage() {}
+
+func (x *GetUniverseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUniverseRequest.ProtoReflect.Descriptor instead.
+func (*GetUniverseRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetUniverseRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type CloseUniverseRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Whether to save the universe to save_snapshot before closing it,
+	// rather than discard its changes.
+	Save          bool   `protobuf:"varint,2,opt,name=save,proto3" json:"save,omitempty"`
+	SaveSnapshot  string `protobuf:"bytes,3,opt,name=save_snapshot,json=saveSnapshot,proto3" json:"save_snapshot,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CloseUniverseRequest) Reset() {
+	*x = CloseUniverseRequest{}
+	mi := &file_virtuakube_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CloseUniverseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CloseUniverseRequest) ProtoMessage() {}
+
+func (x *CloseUniverseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CloseUniverseRequest.ProtoReflect.Descriptor instead.
+func (*CloseUniverseRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CloseUniverseRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CloseUniverseRequest) GetSave() bool {
+	if x != nil {
+		return x.Save
+	}
+	return false
+}
+
+func (x *CloseUniverseRequest) GetSaveSnapshot() string {
+	if x != nil {
+		return x.SaveSnapshot
+	}
+	return ""
+}
+
+type CloseUniverseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CloseUniverseResponse) Reset() {
+	*x = CloseUniverseResponse{}
+	mi := &file_virtuakube_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CloseUniverseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CloseUniverseResponse) ProtoMessage() {}
+
+func (x *CloseUniverseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CloseUniverseResponse.ProtoReflect.Descriptor instead.
+func (*CloseUniverseResponse) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{5}
+}
+
+type CheckpointRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Universe string                 `protobuf:"bytes,1,opt,name=universe,proto3" json:"universe,omitempty"`
+	// The snapshot to save to.
+	Name          string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckpointRequest) Reset() {
+	*x = CheckpointRequest{}
+	mi := &file_virtuakube_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckpointRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckpointRequest) ProtoMessage() {}
+
+func (x *CheckpointRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckpointRequest.ProtoReflect.Descriptor instead.
+func (*CheckpointRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CheckpointRequest) GetUniverse() string {
+	if x != nil {
+		return x.Universe
+	}
+	return ""
+}
+
+func (x *CheckpointRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type CheckpointResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckpointResponse) Reset() {
+	*x = CheckpointResponse{}
+	mi := &file_virtuakube_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckpointResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckpointResponse) ProtoMessage() {}
+
+func (x *CheckpointResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckpointResponse.ProtoReflect.Descriptor instead.
+func (*CheckpointResponse) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{7}
+}
+
+type CreateVMRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Universe string                 `protobuf:"bytes,1,opt,name=universe,proto3" json:"universe,omitempty"`
+	// The JSON encoding of a virtuakube.VMConfig.
+	ConfigJson    []byte `protobuf:"bytes,2,opt,name=config_json,json=configJson,proto3" json:"config_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateVMRequest) Reset() {
+	*x = CreateVMRequest{}
+	mi := &file_virtuakube_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateVMRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateVMRequest) ProtoMessage() {}
+
+func (x *CreateVMRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateVMRequest.ProtoReflect.Descriptor instead.
+func (*CreateVMRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CreateVMRequest) GetUniverse() string {
+	if x != nil {
+		return x.Universe
+	}
+	return ""
+}
+
+func (x *CreateVMRequest) GetConfigJson() []byte {
+	if x != nil {
+		return x.ConfigJson
+	}
+	return nil
+}
+
+type CreateClusterRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Universe string                 `protobuf:"bytes,1,opt,name=universe,proto3" json:"universe,omitempty"`
+	// The JSON encoding of a virtuakube.ClusterConfig.
+	ConfigJson    []byte `protobuf:"bytes,2,opt,name=config_json,json=configJson,proto3" json:"config_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateClusterRequest) Reset() {
+	*x = CreateClusterRequest{}
+	mi := &file_virtuakube_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateClusterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateClusterRequest) ProtoMessage() {}
+
+func (x *CreateClusterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateClusterRequest.ProtoReflect.Descriptor instead.
+func (*CreateClusterRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CreateClusterRequest) GetUniverse() string {
+	if x != nil {
+		return x.Universe
+	}
+	return ""
+}
+
+func (x *CreateClusterRequest) GetConfigJson() []byte {
+	if x != nil {
+		return x.ConfigJson
+	}
+	return nil
+}
+
+type RunRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Universe      string                 `protobuf:"bytes,1,opt,name=universe,proto3" json:"universe,omitempty"`
+	Vm            string                 `protobuf:"bytes,2,opt,name=vm,proto3" json:"vm,omitempty"`
+	Command       string                 `protobuf:"bytes,3,opt,name=command,proto3" json:"command,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RunRequest) Reset() {
+	*x = RunRequest{}
+	mi := &file_virtuakube_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RunRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RunRequest) ProtoMessage() {}
+
+func (x *RunRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RunRequest.ProtoReflect.Descriptor instead.
+func (*RunRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RunRequest) GetUniverse() string {
+	if x != nil {
+		return x.Universe
+	}
+	return ""
+}
+
+func (x *RunRequest) GetVm() string {
+	if x != nil {
+		return x.Vm
+	}
+	return ""
+}
+
+func (x *RunRequest) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+type RunResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The command's stdout and stderr, interleaved.
+	Output        []byte `protobuf:"bytes,1,opt,name=output,proto3" json:"output,omitempty"`
+	ExitStatus    int32  `protobuf:"varint,2,opt,name=exit_status,json=exitStatus,proto3" json:"exit_status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RunResponse) Reset() {
+	*x = RunResponse{}
+	mi := &file_virtuakube_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RunResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RunResponse) ProtoMessage() {}
+
+func (x *RunResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RunResponse.ProtoReflect.Descriptor instead.
+func (*RunResponse) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *RunResponse) GetOutput() []byte {
+	if x != nil {
+		return x.Output
+	}
+	return nil
+}
+
+func (x *RunResponse) GetExitStatus() int32 {
+	if x != nil {
+		return x.ExitStatus
+	}
+	return 0
+}
+
+type ForwardPortRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Universe      string                 `protobuf:"bytes,1,opt,name=universe,proto3" json:"universe,omitempty"`
+	Vm            string                 `protobuf:"bytes,2,opt,name=vm,proto3" json:"vm,omitempty"`
+	Port          int32                  `protobuf:"varint,3,opt,name=port,proto3" json:"port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardPortRequest) Reset() {
+	*x = ForwardPortRequest{}
+	mi := &file_virtuakube_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardPortRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardPortRequest) ProtoMessage() {}
+
+func (x *ForwardPortRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardPortRequest.ProtoReflect.Descriptor instead.
+func (*ForwardPortRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ForwardPortRequest) GetUniverse() string {
+	if x != nil {
+		return x.Universe
+	}
+	return ""
+}
+
+func (x *ForwardPortRequest) GetVm() string {
+	if x != nil {
+		return x.Vm
+	}
+	return ""
+}
+
+func (x *ForwardPortRequest) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+type ForwardPortResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Port  int32                  `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	// The localhost port that forwards to port.
+	HostPort      int32 `protobuf:"varint,2,opt,name=host_port,json=hostPort,proto3" json:"host_port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardPortResponse) Reset() {
+	*x = ForwardPortResponse{}
+	mi := &file_virtuakube_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardPortResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardPortResponse) ProtoMessage() {}
+
+func (x *ForwardPortResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardPortResponse.ProtoReflect.Descriptor instead.
+func (*ForwardPortResponse) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ForwardPortResponse) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *ForwardPortResponse) GetHostPort() int32 {
+	if x != nil {
+		return x.HostPort
+	}
+	return 0
+}
+
+type StopForwardRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Universe      string                 `protobuf:"bytes,1,opt,name=universe,proto3" json:"universe,omitempty"`
+	Vm            string                 `protobuf:"bytes,2,opt,name=vm,proto3" json:"vm,omitempty"`
+	Port          int32                  `protobuf:"varint,3,opt,name=port,proto3" json:"port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StopForwardRequest) Reset() {
+	*x = StopForwardRequest{}
+	mi := &file_virtuakube_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StopForwardRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StopForwardRequest) ProtoMessage() {}
+
+func (x *StopForwardRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StopForwardRequest.ProtoReflect.Descriptor instead.
+func (*StopForwardRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *StopForwardRequest) GetUniverse() string {
+	if x != nil {
+		return x.Universe
+	}
+	return ""
+}
+
+func (x *StopForwardRequest) GetVm() string {
+	if x != nil {
+		return x.Vm
+	}
+	return ""
+}
+
+func (x *StopForwardRequest) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+type StopForwardResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StopForwardResponse) Reset() {
+	*x = StopForwardResponse{}
+	mi := &file_virtuakube_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StopForwardResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StopForwardResponse) ProtoMessage() {}
+
+func (x *StopForwardResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StopForwardResponse.ProtoReflect.Descriptor instead.
+func (*StopForwardResponse) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{15}
+}
+
+type StreamEventsRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Universe string                 `protobuf:"bytes,1,opt,name=universe,proto3" json:"universe,omitempty"`
+	// Whether to start with the events that happened before the call.
+	Replay        bool `protobuf:"varint,2,opt,name=replay,proto3" json:"replay,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEventsRequest) Reset() {
+	*x = StreamEventsRequest{}
+	mi := &file_virtuakube_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEventsRequest) ProtoMessage() {}
+
+func (x *StreamEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEventsRequest.ProtoReflect.Descriptor instead.
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *StreamEventsRequest) GetUniverse() string {
+	if x != nil {
+		return x.Universe
+	}
+	return ""
+}
+
+func (x *StreamEventsRequest) GetReplay() bool {
+	if x != nil {
+		return x.Replay
+	}
+	return false
+}
+
+type StreamLogsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Universe      string                 `protobuf:"bytes,1,opt,name=universe,proto3" json:"universe,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamLogsRequest) Reset() {
+	*x = StreamLogsRequest{}
+	mi := &file_virtuakube_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamLogsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamLogsRequest) ProtoMessage() {}
+
+func (x *StreamLogsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamLogsRequest.ProtoReflect.Descriptor instead.
+func (*StreamLogsRequest) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *StreamLogsRequest) GetUniverse() string {
+	if x != nil {
+		return x.Universe
+	}
+	return ""
+}
+
+// UniverseStatus mirrors virtuakube.UniverseStatus.
+type UniverseStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dir           string                 `protobuf:"bytes,1,opt,name=dir,proto3" json:"dir,omitempty"`
+	Pid           int64                  `protobuf:"varint,2,opt,name=pid,proto3" json:"pid,omitempty"`
+	Snapshot      string                 `protobuf:"bytes,3,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	Started       *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=started,proto3" json:"started,omitempty"`
+	Dns           string                 `protobuf:"bytes,5,opt,name=dns,proto3" json:"dns,omitempty"`
+	Metrics       string                 `protobuf:"bytes,6,opt,name=metrics,proto3" json:"metrics,omitempty"`
+	Networks      []*NetworkStatus       `protobuf:"bytes,7,rep,name=networks,proto3" json:"networks,omitempty"`
+	Vms           []*VMStatus            `protobuf:"bytes,8,rep,name=vms,proto3" json:"vms,omitempty"`
+	Clusters      []*ClusterStatus       `protobuf:"bytes,9,rep,name=clusters,proto3" json:"clusters,omitempty"`
+	Events        []*Event               `protobuf:"bytes,10,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UniverseStatus) Reset() {
+	*x = UniverseStatus{}
+	mi := &file_virtuakube_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UniverseStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UniverseStatus) ProtoMessage() {}
+
+func (x *UniverseStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UniverseStatus.ProtoReflect.Descriptor instead.
+func (*UniverseStatus) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *UniverseStatus) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+func (x *UniverseStatus) GetPid() int64 {
+	if x != nil {
+		return x.Pid
+	}
+	return 0
+}
+
+func (x *UniverseStatus) GetSnapshot() string {
+	if x != nil {
+		return x.Snapshot
+	}
+	return ""
+}
+
+func (x *UniverseStatus) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *UniverseStatus) GetDns() string {
+	if x != nil {
+		return x.Dns
+	}
+	return ""
+}
+
+func (x *UniverseStatus) GetMetrics() string {
+	if x != nil {
+		return x.Metrics
+	}
+	return ""
+}
+
+func (x *UniverseStatus) GetNetworks() []*NetworkStatus {
+	if x != nil {
+		return x.Networks
+	}
+	return nil
+}
+
+func (x *UniverseStatus) GetVms() []*VMStatus {
+	if x != nil {
+		return x.Vms
+	}
+	return nil
+}
+
+func (x *UniverseStatus) GetClusters() []*ClusterStatus {
+	if x != nil {
+		return x.Clusters
+	}
+	return nil
+}
+
+func (x *UniverseStatus) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+// NetworkStatus mirrors virtuakube.NetworkStatus.
+type NetworkStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Ipv4Subnet    string                 `protobuf:"bytes,2,opt,name=ipv4_subnet,json=ipv4Subnet,proto3" json:"ipv4_subnet,omitempty"`
+	Ipv6Subnet    string                 `protobuf:"bytes,3,opt,name=ipv6_subnet,json=ipv6Subnet,proto3" json:"ipv6_subnet,omitempty"`
+	Mtu           int32                  `protobuf:"varint,4,opt,name=mtu,proto3" json:"mtu,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NetworkStatus) Reset() {
+	*x = NetworkStatus{}
+	mi := &file_virtuakube_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NetworkStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NetworkStatus) ProtoMessage() {}
+
+func (x *NetworkStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NetworkStatus.ProtoReflect.Descriptor instead.
+func (*NetworkStatus) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *NetworkStatus) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *NetworkStatus) GetIpv4Subnet() string {
+	if x != nil {
+		return x.Ipv4Subnet
+	}
+	return ""
+}
+
+func (x *NetworkStatus) GetIpv6Subnet() string {
+	if x != nil {
+		return x.Ipv6Subnet
+	}
+	return ""
+}
+
+func (x *NetworkStatus) GetMtu() int32 {
+	if x != nil {
+		return x.Mtu
+	}
+	return 0
+}
+
+// VMStatus mirrors virtuakube.VMStatus.
+type VMStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Networks      []string               `protobuf:"bytes,2,rep,name=networks,proto3" json:"networks,omitempty"`
+	Ipv4          map[string]string      `protobuf:"bytes,3,rep,name=ipv4,proto3" json:"ipv4,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Ipv6          map[string]string      `protobuf:"bytes,4,rep,name=ipv6,proto3" json:"ipv6,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Lan           string                 `protobuf:"bytes,5,opt,name=lan,proto3" json:"lan,omitempty"`
+	Ports         map[int32]int32        `protobuf:"bytes,6,rep,name=ports,proto3" json:"ports,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	ConsoleSocket string                 `protobuf:"bytes,7,opt,name=console_socket,json=consoleSocket,proto3" json:"console_socket,omitempty"`
+	ConsoleLog    string                 `protobuf:"bytes,8,opt,name=console_log,json=consoleLog,proto3" json:"console_log,omitempty"`
+	MemoryMib     int32                  `protobuf:"varint,9,opt,name=memory_mib,json=memoryMib,proto3" json:"memory_mib,omitempty"`
+	RssBytes      int64                  `protobuf:"varint,10,opt,name=rss_bytes,json=rssBytes,proto3" json:"rss_bytes,omitempty"`
+	Cpus          int32                  `protobuf:"varint,11,opt,name=cpus,proto3" json:"cpus,omitempty"`
+	CpuTime       *durationpb.Duration   `protobuf:"bytes,12,opt,name=cpu_time,json=cpuTime,proto3" json:"cpu_time,omitempty"`
+	Paused        bool                   `protobuf:"varint,13,opt,name=paused,proto3" json:"paused,omitempty"`
+	BalloonMib    int32                  `protobuf:"varint,14,opt,name=balloon_mib,json=balloonMib,proto3" json:"balloon_mib,omitempty"`
+	ClockOffset   *durationpb.Duration   `protobuf:"bytes,15,opt,name=clock_offset,json=clockOffset,proto3" json:"clock_offset,omitempty"`
+	Display       string                 `protobuf:"bytes,16,opt,name=display,proto3" json:"display,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VMStatus) Reset() {
+	*x = VMStatus{}
+	mi := &file_virtuakube_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VMStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VMStatus) ProtoMessage() {}
+
+func (x *VMStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VMStatus.ProtoReflect.Descriptor instead.
+func (*VMStatus) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *VMStatus) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *VMStatus) GetNetworks() []string {
+	if x != nil {
+		return x.Networks
+	}
+	return nil
+}
+
+func (x *VMStatus) GetIpv4() map[string]string {
+	if x != nil {
+		return x.Ipv4
+	}
+	return nil
+}
+
+func (x *VMStatus) GetIpv6() map[string]string {
+	if x != nil {
+		return x.Ipv6
+	}
+	return nil
+}
+
+func (x *VMStatus) GetLan() string {
+	if x != nil {
+		return x.Lan
+	}
+	return ""
+}
+
+func (x *VMStatus) GetPorts() map[int32]int32 {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+func (x *VMStatus) GetConsoleSocket() string {
+	if x != nil {
+		return x.ConsoleSocket
+	}
+	return ""
+}
+
+func (x *VMStatus) GetConsoleLog() string {
+	if x != nil {
+		return x.ConsoleLog
+	}
+	return ""
+}
+
+func (x *VMStatus) GetMemoryMib() int32 {
+	if x != nil {
+		return x.MemoryMib
+	}
+	return 0
+}
+
+func (x *VMStatus) GetRssBytes() int64 {
+	if x != nil {
+		return x.RssBytes
+	}
+	return 0
+}
+
+func (x *VMStatus) GetCpus() int32 {
+	if x != nil {
+		return x.Cpus
+	}
+	return 0
+}
+
+func (x *VMStatus) GetCpuTime() *durationpb.Duration {
+	if x != nil {
+		return x.CpuTime
+	}
+	return nil
+}
+
+func (x *VMStatus) GetPaused() bool {
+	if x != nil {
+		return x.Paused
+	}
+	return false
+}
+
+func (x *VMStatus) GetBalloonMib() int32 {
+	if x != nil {
+		return x.BalloonMib
+	}
+	return 0
+}
+
+func (x *VMStatus) GetClockOffset() *durationpb.Duration {
+	if x != nil {
+		return x.ClockOffset
+	}
+	return nil
+}
+
+func (x *VMStatus) GetDisplay() string {
+	if x != nil {
+		return x.Display
+	}
+	return ""
+}
+
+// ClusterStatus mirrors virtuakube.ClusterStatus.
+type ClusterStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Kubeconfig    string                 `protobuf:"bytes,2,opt,name=kubeconfig,proto3" json:"kubeconfig,omitempty"`
+	Controller    string                 `protobuf:"bytes,3,opt,name=controller,proto3" json:"controller,omitempty"`
+	Nodes         []string               `protobuf:"bytes,4,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	ControlPlanes []string               `protobuf:"bytes,5,rep,name=control_planes,json=controlPlanes,proto3" json:"control_planes,omitempty"`
+	LoadBalancer  string                 `protobuf:"bytes,6,opt,name=load_balancer,json=loadBalancer,proto3" json:"load_balancer,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClusterStatus) Reset() {
+	*x = ClusterStatus{}
+	mi := &file_virtuakube_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClusterStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClusterStatus) ProtoMessage() {}
+
+func (x *ClusterStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClusterStatus.ProtoReflect.Descriptor instead.
+func (*ClusterStatus) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *ClusterStatus) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ClusterStatus) GetKubeconfig() string {
+	if x != nil {
+		return x.Kubeconfig
+	}
+	return ""
+}
+
+func (x *ClusterStatus) GetController() string {
+	if x != nil {
+		return x.Controller
+	}
+	return ""
+}
+
+func (x *ClusterStatus) GetNodes() []string {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *ClusterStatus) GetControlPlanes() []string {
+	if x != nil {
+		return x.ControlPlanes
+	}
+	return nil
+}
+
+func (x *ClusterStatus) GetLoadBalancer() string {
+	if x != nil {
+		return x.LoadBalancer
+	}
+	return ""
+}
+
+// Event mirrors virtuakube.Event.
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Kind          string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	Target        string                 `protobuf:"bytes,3,opt,name=target,proto3" json:"target,omitempty"`
+	Message       string                 `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	Phase         string                 `protobuf:"bytes,5,opt,name=phase,proto3" json:"phase,omitempty"`
+	Current       int64                  `protobuf:"varint,6,opt,name=current,proto3" json:"current,omitempty"`
+	Total         int64                  `protobuf:"varint,7,opt,name=total,proto3" json:"total,omitempty"`
+	Duration      *durationpb.Duration   `protobuf:"bytes,8,opt,name=duration,proto3" json:"duration,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_virtuakube_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Event) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Event) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Event) GetPhase() string {
+	if x != nil {
+		return x.Phase
+	}
+	return ""
+}
+
+func (x *Event) GetCurrent() int64 {
+	if x != nil {
+		return x.Current
+	}
+	return 0
+}
+
+func (x *Event) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *Event) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+// LogRecord is a log record of a universe.
+type LogRecord struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The record, as a JSON object from log/slog's JSON handler.
+	Json          []byte `protobuf:"bytes,1,opt,name=json,proto3" json:"json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogRecord) Reset() {
+	*x = LogRecord{}
+	mi := &file_virtuakube_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogRecord) ProtoMessage() {}
+
+func (x *LogRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_virtuakube_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogRecord.ProtoReflect.Descriptor instead.
+func (*LogRecord) Descriptor() ([]byte, []int) {
+	return file_virtuakube_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *LogRecord) GetJson() []byte {
+	if x != nil {
+		return x.Json
+	}
+	return nil
+}
+
+var File_virtuakube_proto protoreflect.FileDescriptor
+
+const file_virtuakube_proto_rawDesc = "" +
+	"\n" +
+	"\x10virtuakube.proto\x12\rvirtuakube.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x16\n" +
+	"\x14ListUniversesRequest\"T\n" +
+	"\x15ListUniversesResponse\x12;\n" +
+	"\tuniverses\x18\x01 \x03(\v2\x1d.virtuakube.v1.UniverseStatusR\tuniverses\"E\n" +
+	"\x13OpenUniverseRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\bsnapshot\x18\x02 \x01(\tR\bsnapshot\"(\n" +
+	"\x12GetUniverseRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\"c\n" +
+	"\x14CloseUniverseRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04save\x18\x02 \x01(\bR\x04save\x12#\n" +
+	"\rsave_snapshot\x18\x03 \x01(\tR\fsaveSnapshot\"\x17\n" +
+	"\x15CloseUniverseResponse\"C\n" +
+	"\x11CheckpointRequest\x12\x1a\n" +
+	"\buniverse\x18\x01 \x01(\tR\buniverse\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\"\x14\n" +
+	"\x12CheckpointResponse\"N\n" +
+	"\x0fCreateVMRequest\x12\x1a\n" +
+	"\buniverse\x18\x01 \x01(\tR\buniverse\x12\x1f\n" +
+	"\vconfig_json\x18\x02 \x01(\fR\n" +
+	"configJson\"S\n" +
+	"\x14CreateClusterRequest\x12\x1a\n" +
+	"\buniverse\x18\x01 \x01(\tR\buniverse\x12\x1f\n" +
+	"\vconfig_json\x18\x02 \x01(\fR\n" +
+	"configJson\"R\n" +
+	"\n" +
+	"RunRequest\x12\x1a\n" +
+	"\buniverse\x18\x01 \x01(\tR\buniverse\x12\x0e\n" +
+	"\x02vm\x18\x02 \x01(\tR\x02vm\x12\x18\n" +
+	"\acommand\x18\x03 \x01(\tR\acommand\"F\n" +
+	"\vRunResponse\x12\x16\n" +
+	"\x06output\x18\x01 \x01(\fR\x06output\x12\x1f\n" +
+	"\vexit_status\x18\x02 \x01(\x05R\n" +
+	"exitStatus\"T\n" +
+	"\x12ForwardPortRequest\x12\x1a\n" +
+	"\buniverse\x18\x01 \x01(\tR\buniverse\x12\x0e\n" +
+	"\x02vm\x18\x02 \x01(\tR\x02vm\x12\x12\n" +
+	"\x04port\x18\x03 \x01(\x05R\x04port\"F\n" +
+	"\x13ForwardPortResponse\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\x05R\x04port\x12\x1b\n" +
+	"\thost_port\x18\x02 \x01(\x05R\bhostPort\"T\n" +
+	"\x12StopForwardRequest\x12\x1a\n" +
+	"\buniverse\x18\x01 \x01(\tR\buniverse\x12\x0e\n" +
+	"\x02vm\x18\x02 \x01(\tR\x02vm\x12\x12\n" +
+	"\x04port\x18\x03 \x01(\x05R\x04port\"\x15\n" +
+	"\x13StopForwardResponse\"I\n" +
+	"\x13StreamEventsRequest\x12\x1a\n" +
+	"\buniverse\x18\x01 \x01(\tR\buniverse\x12\x16\n" +
+	"\x06replay\x18\x02 \x01(\bR\x06replay\"/\n" +
+	"\x11StreamLogsRequest\x12\x1a\n" +
+	"\buniverse\x18\x01 \x01(\tR\buniverse\"\xff\x02\n" +
+	"\x0eUniverseStatus\x12\x10\n" +
+	"\x03dir\x18\x01 \x01(\tR\x03dir\x12\x10\n" +
+	"\x03pid\x18\x02 \x01(\x03R\x03pid\x12\x1a\n" +
+	"\bsnapshot\x18\x03 \x01(\tR\bsnapshot\x124\n" +
+	"\astarted\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\astarted\x12\x10\n" +
+	"\x03dns\x18\x05 \x01(\tR\x03dns\x12\x18\n" +
+	"\ametrics\x18\x06 \x01(\tR\ametrics\x128\n" +
+	"\bnetworks\x18\a \x03(\v2\x1c.virtuakube.v1.NetworkStatusR\bnetworks\x12)\n" +
+	"\x03vms\x18\b \x03(\v2\x17.virtuakube.v1.VMStatusR\x03vms\x128\n" +
+	"\bclusters\x18\t \x03(\v2\x1c.virtuakube.v1.ClusterStatusR\bclusters\x12,\n" +
+	"\x06events\x18\n" +
+	" \x03(\v2\x14.virtuakube.v1.EventR\x06events\"w\n" +
+	"\rNetworkStatus\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1f\n" +
+	"\vipv4_subnet\x18\x02 \x01(\tR\n" +
+	"ipv4Subnet\x12\x1f\n" +
+	"\vipv6_subnet\x18\x03 \x01(\tR\n" +
+	"ipv6Subnet\x12\x10\n" +
+	"\x03mtu\x18\x04 \x01(\x05R\x03mtu\"\xff\x05\n" +
+	"\bVMStatus\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\bnetworks\x18\x02 \x03(\tR\bnetworks\x125\n" +
+	"\x04ipv4\x18\x03 \x03(\v2!.virtuakube.v1.VMStatus.Ipv4EntryR\x04ipv4\x125\n" +
+	"\x04ipv6\x18\x04 \x03(\v2!.virtuakube.v1.VMStatus.Ipv6EntryR\x04ipv6\x12\x10\n" +
+	"\x03lan\x18\x05 \x01(\tR\x03lan\x128\n" +
+	"\x05ports\x18\x06 \x03(\v2\".virtuakube.v1.VMStatus.PortsEntryR\x05ports\x12%\n" +
+	"\x0econsole_socket\x18\a \x01(\tR\rconsoleSocket\x12\x1f\n" +
+	"\vconsole_log\x18\b \x01(\tR\n" +
+	"consoleLog\x12\x1d\n" +
+	"\n" +
+	"memory_mib\x18\t \x01(\x05R\tmemoryMib\x12\x1b\n" +
+	"\trss_bytes\x18\n" +
+	" \x01(\x03R\brssBytes\x12\x12\n" +
+	"\x04cpus\x18\v \x01(\x05R\x04cpus\x124\n" +
+	"\bcpu_time\x18\f \x01(\v2\x19.google.protobuf.DurationR\acpuTime\x12\x16\n" +
+	"\x06paused\x18\r \x01(\bR\x06paused\x12\x1f\n" +
+	"\vballoon_mib\x18\x0e \x01(\x05R\n" +
+	"balloonMib\x12<\n" +
+	"\fclock_offset\x18\x0f \x01(\v2\x19.google.protobuf.DurationR\vclockOffset\x12\x18\n" +
+	"\adisplay\x18\x10 \x01(\tR\adisplay\x1a7\n" +
+	"\tIpv4Entry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a7\n" +
+	"\tIpv6Entry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a8\n" +
+	"\n" +
+	"PortsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\x05R\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x05R\x05value:\x028\x01\"\xc5\x01\n" +
+	"\rClusterStatus\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1e\n" +
+	"\n" +
+	"kubeconfig\x18\x02 \x01(\tR\n" +
+	"kubeconfig\x12\x1e\n" +
+	"\n" +
+	"controller\x18\x03 \x01(\tR\n" +
+	"controller\x12\x14\n" +
+	"\x05nodes\x18\x04 \x03(\tR\x05nodes\x12%\n" +
+	"\x0econtrol_planes\x18\x05 \x03(\tR\rcontrolPlanes\x12#\n" +
+	"\rload_balancer\x18\x06 \x01(\tR\floadBalancer\"\xfa\x01\n" +
+	"\x05Event\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x16\n" +
+	"\x06target\x18\x03 \x01(\tR\x06target\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\x12\x14\n" +
+	"\x05phase\x18\x05 \x01(\tR\x05phase\x12\x18\n" +
+	"\acurrent\x18\x06 \x01(\x03R\acurrent\x12\x14\n" +
+	"\x05total\x18\a \x01(\x03R\x05total\x125\n" +
+	"\bduration\x18\b \x01(\v2\x19.google.protobuf.DurationR\bduration\"\x1f\n" +
+	"\tLogRecord\x12\x12\n" +
+	"\x04json\x18\x01 \x01(\fR\x04json2\xd5\a\n" +
+	"\tUniverses\x12Z\n" +
+	"\rListUniverses\x12#.virtuakube.v1.ListUniversesRequest\x1a$.virtuakube.v1.ListUniversesResponse\x12Q\n" +
+	"\fOpenUniverse\x12\".virtuakube.v1.OpenUniverseRequest\x1a\x1d.virtuakube.v1.UniverseStatus\x12O\n" +
+	"\vGetUniverse\x12!.virtuakube.v1.GetUniverseRequest\x1a\x1d.virtuakube.v1.UniverseStatus\x12Z\n" +
+	"\rCloseUniverse\x12#.virtuakube.v1.CloseUniverseRequest\x1a$.virtuakube.v1.CloseUniverseResponse\x12Q\n" +
+	"\n" +
+	"Checkpoint\x12 .virtuakube.v1.CheckpointRequest\x1a!.virtuakube.v1.CheckpointResponse\x12C\n" +
+	"\bCreateVM\x12\x1e.virtuakube.v1.CreateVMRequest\x1a\x17.virtuakube.v1.VMStatus\x12R\n" +
+	"\rCreateCluster\x12#.virtuakube.v1.CreateClusterRequest\x1a\x1c.virtuakube.v1.ClusterStatus\x12<\n" +
+	"\x03Run\x12\x19.virtuakube.v1.RunRequest\x1a\x1a.virtuakube.v1.RunResponse\x12T\n" +
+	"\vForwardPort\x12!.virtuakube.v1.ForwardPortRequest\x1a\".virtuakube.v1.ForwardPortResponse\x12T\n" +
+	"\vStopForward\x12!.virtuakube.v1.StopForwardRequest\x1a\".virtuakube.v1.StopForwardResponse\x12J\n" +
+	"\fStreamEvents\x12\".virtuakube.v1.StreamEventsRequest\x1a\x14.virtuakube.v1.Event0\x01\x12J\n" +
+	"\n" +
+	"StreamLogs\x12 .virtuakube.v1.StreamLogsRequest\x1a\x18.virtuakube.v1.LogRecord0\x01B\x1fZ\x1dgo.universe.tf/virtuakube/apib\x06proto3"
+
+var (
+	file_virtuakube_proto_rawDescOnce sync.Once
+	file_virtuakube_proto_rawDescData []byte
+)
+
+func file_virtuakube_proto_rawDescGZIP() []byte {
+	file_virtuakube_proto_rawDescOnce.Do(func() {
+		file_virtuakube_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_virtuakube_proto_rawDesc), len(file_virtuakube_proto_rawDesc)))
+	})
+	return file_virtuakube_proto_rawDescData
+}
+
+var file_virtuakube_proto_msgTypes = make([]protoimpl.MessageInfo, 27)
+var file_virtuakube_proto_goTypes = []any{
+	(*ListUniversesRequest)(nil),  // 0: virtuakube.v1.ListUniversesRequest
+	(*ListUniversesResponse)(nil), // 1: virtuakube.v1.ListUniversesResponse
+	(*OpenUniverseRequest)(nil),   // 2: virtuakube.v1.OpenUniverseRequest
+	(*GetUniverseRequest)(nil),    // 3: virtuakube.v1.GetUniverseRequest
+	(*CloseUniverseRequest)(nil),  // 4: virtuakube.v1.CloseUniverseRequest
+	(*CloseUniverseResponse)(nil), // 5: virtuakube.v1.CloseUniverseResponse
+	(*CheckpointRequest)(nil),     // 6: virtuakube.v1.CheckpointRequest
+	(*CheckpointResponse)(nil),    // 7: virtuakube.v1.CheckpointResponse
+	(*CreateVMRequest)(nil),       // 8: virtuakube.v1.CreateVMRequest
+	(*CreateClusterRequest)(nil),  // 9: virtuakube.v1.CreateClusterRequest
+	(*RunRequest)(nil),            // 10: virtuakube.v1.RunRequest
+	(*RunResponse)(nil),           // 11: virtuakube.v1.RunResponse
+	(*ForwardPortRequest)(nil),    // 12: virtuakube.v1.ForwardPortRequest
+	(*ForwardPortResponse)(nil),   // 13: virtuakube.v1.ForwardPortResponse
+	(*StopForwardRequest)(nil),    // 14: virtuakube.v1.StopForwardRequest
+	(*StopForwardResponse)(nil),   // 15: virtuakube.v1.StopForwardResponse
+	(*StreamEventsRequest)(nil),   // 16: virtuakube.v1.StreamEventsRequest
+	(*StreamLogsRequest)(nil),     // 17: virtuakube.v1.StreamLogsRequest
+	(*UniverseStatus)(nil),        // 18: virtuakube.v1.UniverseStatus
+	(*NetworkStatus)(nil),         // 19: virtuakube.v1.NetworkStatus
+	(*VMStatus)(nil),              // 20: virtuakube.v1.VMStatus
+	(*ClusterStatus)(nil),         // 21: virtuakube.v1.ClusterStatus
+	(*Event)(nil),                 // 22: virtuakube.v1.Event
+	(*LogRecord)(nil),             // 23: virtuakube.v1.LogRecord
+	nil,                           // 24: virtuakube.v1.VMStatus.Ipv4Entry
+	nil,                           // 25: virtuakube.v1.VMStatus.Ipv6Entry
+	nil,                           // 26: virtuakube.v1.VMStatus.PortsEntry
+	(*timestamppb.Timestamp)(nil), // 27: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 28: google.protobuf.Duration
+}
+var file_virtuakube_proto_depIdxs = []int32{
+	18, // 0: virtuakube.v1.ListUniversesResponse.universes:type_name -> virtuakube.v1.UniverseStatus
+	27, // 1: virtuakube.v1.UniverseStatus.started:type_name -> google.protobuf.Timestamp
+	19, // 2: virtuakube.v1.UniverseStatus.networks:type_name -> virtuakube.v1.NetworkStatus
+	20, // 3: virtuakube.v1.UniverseStatus.vms:type_name -> virtuakube.v1.VMStatus
+	21, // 4: virtuakube.v1.UniverseStatus.clusters:type_name -> virtuakube.v1.ClusterStatus
+	22, // 5: virtuakube.v1.UniverseStatus.events:type_name -> virtuakube.v1.Event
+	24, // 6: virtuakube.v1.VMStatus.ipv4:type_name -> virtuakube.v1.VMStatus.Ipv4Entry
+	25, // 7: virtuakube.v1.VMStatus.ipv6:type_name -> virtuakube.v1.VMStatus.Ipv6Entry
+	26, // 8: virtuakube.v1.VMStatus.ports:type_name -> virtuakube.v1.VMStatus.PortsEntry
+	28, // 9: virtuakube.v1.VMStatus.cpu_time:type_name -> google.protobuf.Duration
+	28, // 10: virtuakube.v1.VMStatus.clock_offset:type_name -> google.protobuf.Duration
+	27, // 11: virtuakube.v1.Event.time:type_name -> google.protobuf.Timestamp
+	28, // 12: virtuakube.v1.Event.duration:type_name -> google.protobuf.Duration
+	0,  // 13: virtuakube.v1.Universes.ListUniverses:input_type -> virtuakube.v1.ListUniversesRequest
+	2,  // 14: virtuakube.v1.Universes.OpenUniverse:input_type -> virtuakube.v1.OpenUniverseRequest
+	3,  // 15: virtuakube.v1.Universes.GetUniverse:input_type -> virtuakube.v1.GetUniverseRequest
+	4,  // 16: virtuakube.v1.Universes.CloseUniverse:input_type -> virtuakube.v1.CloseUniverseRequest
+	6,  // 17: virtuakube.v1.Universes.Checkpoint:input_type -> virtuakube.v1.CheckpointRequest
+	8,  // 18: virtuakube.v1.Universes.CreateVM:input_type -> virtuakube.v1.CreateVMRequest
+	9,  // 19: virtuakube.v1.Universes.CreateCluster:input_type -> virtuakube.v1.CreateClusterRequest
+	10, // 20: virtuakube.v1.Universes.Run:input_type -> virtuakube.v1.RunRequest
+	12, // 21: virtuakube.v1.Universes.ForwardPort:input_type -> virtuakube.v1.ForwardPortRequest
+	14, // 22: virtuakube.v1.Universes.StopForward:input_type -> virtuakube.v1.StopForwardRequest
+	16, // 23: virtuakube.v1.Universes.StreamEvents:input_type -> virtuakube.v1.StreamEventsRequest
+	17, // 24: virtuakube.v1.Universes.StreamLogs:input_type -> virtuakube.v1.StreamLogsRequest
+	1,  // 25: virtuakube.v1.Universes.ListUniverses:output_type -> virtuakube.v1.ListUniversesResponse
+	18, // 26: virtuakube.v1.Universes.OpenUniverse:output_type -> virtuakube.v1.UniverseStatus
+	18, // 27: virtuakube.v1.Universes.GetUniverse:output_type -> virtuakube.v1.UniverseStatus
+	5,  // 28: virtuakube.v1.Universes.CloseUniverse:output_type -> virtuakube.v1.CloseUniverseResponse
+	7,  // 29: virtuakube.v1.Universes.Checkpoint:output_type -> virtuakube.v1.CheckpointResponse
+	20, // 30: virtuakube.v1.Universes.CreateVM:output_type -> virtuakube.v1.VMStatus
+	21, // 31: virtuakube.v1.Universes.CreateCluster:output_type -> virtuakube.v1.ClusterStatus
+	11, // 32: virtuakube.v1.Universes.Run:output_type -> virtuakube.v1.RunResponse
+	13, // 33: virtuakube.v1.Universes.ForwardPort:output_type -> virtuakube.v1.ForwardPortResponse
+	15, // 34: virtuakube.v1.Universes.StopForward:output_type -> virtuakube.v1.StopForwardResponse
+	22, // 35: virtuakube.v1.Universes.StreamEvents:output_type -> virtuakube.v1.Event
+	23, // 36: virtuakube.v1.Universes.StreamLogs:output_type -> virtuakube.v1.LogRecord
+	25, // [25:37] is the sub-list for method output_type
+	13, // [13:25] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_virtuakube_proto_init() }
+func file_virtuakube_proto_init() {
+	if File_virtuakube_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_virtuakube_proto_rawDesc), len(file_virtuakube_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   27,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_virtuakube_proto_goTypes,
+		DependencyIndexes: file_virtuakube_proto_depIdxs,
+		MessageInfos:      file_virtuakube_proto_msgTypes,
+	}.Build()
+	File_virtuakube_proto = out.File
+	file_virtuakube_proto_goTypes = nil
+	file_virtuakube_proto_depIdxs = nil
+}
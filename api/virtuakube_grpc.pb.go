@@ -0,0 +1,590 @@
+// The gRPC API of vkube serve, see virtuakube.Server. Its operations
+// are the same as the server's JSON API over HTTP.
+//
+// VM and cluster configurations are passed as the JSON encoding of
+// virtuakube.VMConfig and virtuakube.ClusterConfig, as in the JSON
+// API, rather than mirrored here: they have dozens of options, which
+// grow with every release.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: virtuakube.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Universes_ListUniverses_FullMethodName = "/virtuakube.v1.Universes/ListUniverses"
+	Universes_OpenUniverse_FullMethodName  = "/virtuakube.v1.Universes/OpenUniverse"
+	Universes_GetUniverse_FullMethodName   = "/virtuakube.v1.Universes/GetUniverse"
+	Universes_CloseUniverse_FullMethodName = "/virtuakube.v1.Universes/CloseUniverse"
+	Universes_Checkpoint_FullMethodName    = "/virtuakube.v1.Universes/Checkpoint"
+	Universes_CreateVM_FullMethodName      = "/virtuakube.v1.Universes/CreateVM"
+	Universes_CreateCluster_FullMethodName = "/virtuakube.v1.Universes/CreateCluster"
+	Universes_Run_FullMethodName           = "/virtuakube.v1.Universes/Run"
+	Universes_ForwardPort_FullMethodName   = "/virtuakube.v1.Universes/ForwardPort"
+	Universes_StopForward_FullMethodName   = "/virtuakube.v1.Universes/StopForward"
+	Universes_StreamEvents_FullMethodName  = "/virtuakube.v1.Universes/StreamEvents"
+	Universes_StreamLogs_FullMethodName    = "/virtuakube.v1.Universes/StreamLogs"
+)
+
+// UniversesClient is the client API for Universes service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Universes drives the universes in a server's root directory, which
+// are named by their directory there. Every call must carry the
+// server's token, as an "authorization: Bearer <token>" metadata
+// entry.
+type UniversesClient interface {
+	// ListUniverses lists the open universes.
+	ListUniverses(ctx context.Context, in *ListUniversesRequest, opts ...grpc.CallOption) (*ListUniversesResponse, error)
+	// OpenUniverse opens a universe, or creates it if it doesn't exist.
+	OpenUniverse(ctx context.Context, in *OpenUniverseRequest, opts ...grpc.CallOption) (*UniverseStatus, error)
+	// GetUniverse returns the status of an open universe.
+	GetUniverse(ctx context.Context, in *GetUniverseRequest, opts ...grpc.CallOption) (*UniverseStatus, error)
+	// CloseUniverse closes a universe, optionally saving it first.
+	CloseUniverse(ctx context.Context, in *CloseUniverseRequest, opts ...grpc.CallOption) (*CloseUniverseResponse, error)
+	// Checkpoint saves a snapshot of a universe without closing it.
+	Checkpoint(ctx context.Context, in *CheckpointRequest, opts ...grpc.CallOption) (*CheckpointResponse, error)
+	// CreateVM creates and starts a VM.
+	CreateVM(ctx context.Context, in *CreateVMRequest, opts ...grpc.CallOption) (*VMStatus, error)
+	// CreateCluster creates and starts a cluster.
+	CreateCluster(ctx context.Context, in *CreateClusterRequest, opts ...grpc.CallOption) (*ClusterStatus, error)
+	// Run runs a shell command as root on a VM. A command that runs and
+	// fails isn't an error, its exit status is returned as usual.
+	Run(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*RunResponse, error)
+	// ForwardPort forwards a localhost port to a VM port.
+	ForwardPort(ctx context.Context, in *ForwardPortRequest, opts ...grpc.CallOption) (*ForwardPortResponse, error)
+	// StopForward removes the forward of a VM port.
+	StopForward(ctx context.Context, in *StopForwardRequest, opts ...grpc.CallOption) (*StopForwardResponse, error)
+	// StreamEvents streams a universe's events until it closes.
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// StreamLogs streams a universe's log records until it closes.
+	StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogRecord], error)
+}
+
+type universesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUniversesClient(cc grpc.ClientConnInterface) UniversesClient {
+	return &universesClient{cc}
+}
+
+func (c *universesClient) ListUniverses(ctx context.Context, in *ListUniversesRequest, opts ...grpc.CallOption) (*ListUniversesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUniversesResponse)
+	err := c.cc.Invoke(ctx, Universes_ListUniverses_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) OpenUniverse(ctx context.Context, in *OpenUniverseRequest, opts ...grpc.CallOption) (*UniverseStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UniverseStatus)
+	err := c.cc.Invoke(ctx, Universes_OpenUniverse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) GetUniverse(ctx context.Context, in *GetUniverseRequest, opts ...grpc.CallOption) (*UniverseStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UniverseStatus)
+	err := c.cc.Invoke(ctx, Universes_GetUniverse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) CloseUniverse(ctx context.Context, in *CloseUniverseRequest, opts ...grpc.CallOption) (*CloseUniverseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CloseUniverseResponse)
+	err := c.cc.Invoke(ctx, Universes_CloseUniverse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) Checkpoint(ctx context.Context, in *CheckpointRequest, opts ...grpc.CallOption) (*CheckpointResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CheckpointResponse)
+	err := c.cc.Invoke(ctx, Universes_Checkpoint_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) CreateVM(ctx context.Context, in *CreateVMRequest, opts ...grpc.CallOption) (*VMStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VMStatus)
+	err := c.cc.Invoke(ctx, Universes_CreateVM_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) CreateCluster(ctx context.Context, in *CreateClusterRequest, opts ...grpc.CallOption) (*ClusterStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterStatus)
+	err := c.cc.Invoke(ctx, Universes_CreateCluster_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) Run(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*RunResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RunResponse)
+	err := c.cc.Invoke(ctx, Universes_Run_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) ForwardPort(ctx context.Context, in *ForwardPortRequest, opts ...grpc.CallOption) (*ForwardPortResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ForwardPortResponse)
+	err := c.cc.Invoke(ctx, Universes_ForwardPort_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) StopForward(ctx context.Context, in *StopForwardRequest, opts ...grpc.CallOption) (*StopForwardResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StopForwardResponse)
+	err := c.cc.Invoke(ctx, Universes_StopForward_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *universesClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Universes_ServiceDesc.Streams[0], Universes_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Universes_StreamEventsClient = grpc.ServerStreamingClient[Event]
+
+func (c *universesClient) StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogRecord], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Universes_ServiceDesc.Streams[1], Universes_StreamLogs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamLogsRequest, LogRecord]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Universes_StreamLogsClient = grpc.ServerStreamingClient[LogRecord]
+
+// UniversesServer is the server API for Universes service.
+// All implementations must embed UnimplementedUniversesServer
+// for forward compatibility.
+//
+// Universes drives the universes in a server's root directory, which
+// are named by their directory there. Every call must carry the
+// server's token, as an "authorization: Bearer <token>" metadata
+// entry.
+type UniversesServer interface {
+	// ListUniverses lists the open universes.
+	ListUniverses(context.Context, *ListUniversesRequest) (*ListUniversesResponse, error)
+	// OpenUniverse opens a universe, or creates it if it doesn't exist.
+	OpenUniverse(context.Context, *OpenUniverseRequest) (*UniverseStatus, error)
+	// GetUniverse returns the status of an open universe.
+	GetUniverse(context.Context, *GetUniverseRequest) (*UniverseStatus, error)
+	// CloseUniverse closes a universe, optionally saving it first.
+	CloseUniverse(context.Context, *CloseUniverseRequest) (*CloseUniverseResponse, error)
+	// Checkpoint saves a snapshot of a universe without closing it.
+	Checkpoint(context.Context, *CheckpointRequest) (*CheckpointResponse, error)
+	// CreateVM creates and starts a VM.
+	CreateVM(context.Context, *CreateVMRequest) (*VMStatus, error)
+	// CreateCluster creates and starts a cluster.
+	CreateCluster(context.Context, *CreateClusterRequest) (*ClusterStatus, error)
+	// Run runs a shell command as root on a VM. A command that runs and
+	// fails isn't an error, its exit status is returned as usual.
+	Run(context.Context, *RunRequest) (*RunResponse, error)
+	// ForwardPort forwards a localhost port to a VM port.
+	ForwardPort(context.Context, *ForwardPortRequest) (*ForwardPortResponse, error)
+	// StopForward removes the forward of a VM port.
+	StopForward(context.Context, *StopForwardRequest) (*StopForwardResponse, error)
+	// StreamEvents streams a universe's events until it closes.
+	StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[Event]) error
+	// StreamLogs streams a universe's log records until it closes.
+	StreamLogs(*StreamLogsRequest, grpc.ServerStreamingServer[LogRecord]) error
+	mustEmbedUnimplementedUniversesServer()
+}
+
+// UnimplementedUniversesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedUniversesServer struct{}
+
+func (UnimplementedUniversesServer) ListUniverses(context.Context, *ListUniversesRequest) (*ListUniversesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListUniverses not implemented")
+}
+func (UnimplementedUniversesServer) OpenUniverse(context.Context, *OpenUniverseRequest) (*UniverseStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method OpenUniverse not implemented")
+}
+func (UnimplementedUniversesServer) GetUniverse(context.Context, *GetUniverseRequest) (*UniverseStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetUniverse not implemented")
+}
+func (UnimplementedUniversesServer) CloseUniverse(context.Context, *CloseUniverseRequest) (*CloseUniverseResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method CloseUniverse not implemented")
+}
+func (UnimplementedUniversesServer) Checkpoint(context.Context, *CheckpointRequest) (*CheckpointResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Checkpoint not implemented")
+}
+func (UnimplementedUniversesServer) CreateVM(context.Context, *CreateVMRequest) (*VMStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateVM not implemented")
+}
+func (UnimplementedUniversesServer) CreateCluster(context.Context, *CreateClusterRequest) (*ClusterStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateCluster not implemented")
+}
+func (UnimplementedUniversesServer) Run(context.Context, *RunRequest) (*RunResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Run not implemented")
+}
+func (UnimplementedUniversesServer) ForwardPort(context.Context, *ForwardPortRequest) (*ForwardPortResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ForwardPort not implemented")
+}
+func (UnimplementedUniversesServer) StopForward(context.Context, *StopForwardRequest) (*StopForwardResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method StopForward not implemented")
+}
+func (UnimplementedUniversesServer) StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Error(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedUniversesServer) StreamLogs(*StreamLogsRequest, grpc.ServerStreamingServer[LogRecord]) error {
+	return status.Error(codes.Unimplemented, "method StreamLogs not implemented")
+}
+func (UnimplementedUniversesServer) mustEmbedUnimplementedUniversesServer() {}
+func (UnimplementedUniversesServer) testEmbeddedByValue()                   {}
+
+// UnsafeUniversesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UniversesServer will
+// result in compilation errors.
+type UnsafeUniversesServer interface {
+	mustEmbedUnimplementedUniversesServer()
+}
+
+func RegisterUniversesServer(s grpc.ServiceRegistrar, srv UniversesServer) {
+	// If the following call panics, it indicates UnimplementedUniversesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Universes_ServiceDesc, srv)
+}
+
+func _Universes_ListUniverses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUniversesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).ListUniverses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_ListUniverses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).ListUniverses(ctx, req.(*ListUniversesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_OpenUniverse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OpenUniverseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).OpenUniverse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_OpenUniverse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).OpenUniverse(ctx, req.(*OpenUniverseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_GetUniverse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUniverseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).GetUniverse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_GetUniverse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).GetUniverse(ctx, req.(*GetUniverseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_CloseUniverse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseUniverseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).CloseUniverse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_CloseUniverse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).CloseUniverse(ctx, req.(*CloseUniverseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_Checkpoint_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckpointRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).Checkpoint(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_Checkpoint_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).Checkpoint(ctx, req.(*CheckpointRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_CreateVM_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateVMRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).CreateVM(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_CreateVM_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).CreateVM(ctx, req.(*CreateVMRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_CreateCluster_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateClusterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).CreateCluster(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_CreateCluster_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).CreateCluster(ctx, req.(*CreateClusterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_Run_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).Run(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_Run_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).Run(ctx, req.(*RunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_ForwardPort_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ForwardPortRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).ForwardPort(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_ForwardPort_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).ForwardPort(ctx, req.(*ForwardPortRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_StopForward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopForwardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UniversesServer).StopForward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Universes_StopForward_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UniversesServer).StopForward(ctx, req.(*StopForwardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Universes_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UniversesServer).StreamEvents(m, &grpc.GenericServerStream[StreamEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Universes_StreamEventsServer = grpc.ServerStreamingServer[Event]
+
+func _Universes_StreamLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UniversesServer).StreamLogs(m, &grpc.GenericServerStream[StreamLogsRequest, LogRecord]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Universes_StreamLogsServer = grpc.ServerStreamingServer[LogRecord]
+
+// Universes_ServiceDesc is the grpc.ServiceDesc for Universes service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Universes_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "virtuakube.v1.Universes",
+	HandlerType: (*UniversesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListUniverses",
+			Handler:    _Universes_ListUniverses_Handler,
+		},
+		{
+			MethodName: "OpenUniverse",
+			Handler:    _Universes_OpenUniverse_Handler,
+		},
+		{
+			MethodName: "GetUniverse",
+			Handler:    _Universes_GetUniverse_Handler,
+		},
+		{
+			MethodName: "CloseUniverse",
+			Handler:    _Universes_CloseUniverse_Handler,
+		},
+		{
+			MethodName: "Checkpoint",
+			Handler:    _Universes_Checkpoint_Handler,
+		},
+		{
+			MethodName: "CreateVM",
+			Handler:    _Universes_CreateVM_Handler,
+		},
+		{
+			MethodName: "CreateCluster",
+			Handler:    _Universes_CreateCluster_Handler,
+		},
+		{
+			MethodName: "Run",
+			Handler:    _Universes_Run_Handler,
+		},
+		{
+			MethodName: "ForwardPort",
+			Handler:    _Universes_ForwardPort_Handler,
+		},
+		{
+			MethodName: "StopForward",
+			Handler:    _Universes_StopForward_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _Universes_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamLogs",
+			Handler:       _Universes_StreamLogs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "virtuakube.proto",
+}
@@ -0,0 +1,74 @@
+package virtuakube
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// CPU architectures of images and VMs.
+const (
+	ArchAMD64 = "amd64"
+	ArchARM64 = "arm64"
+)
+
+// normalizeArch returns arch, or ArchAMD64 if arch is empty.
+func normalizeArch(arch string) string {
+	if arch == "" {
+		return ArchAMD64
+	}
+	return arch
+}
+
+func validateArch(arch string) error {
+	switch normalizeArch(arch) {
+	case ArchAMD64, ArchARM64:
+		return nil
+	default:
+		return fmt.Errorf("unknown architecture %q, must be %q or %q", arch, ArchAMD64, ArchARM64)
+	}
+}
+
+// qemuBinary returns the qemu-system binary that runs arch VMs.
+func qemuBinary(arch string) string {
+	if normalizeArch(arch) == ArchARM64 {
+		return "qemu-system-aarch64"
+	}
+	return "qemu-system-x86_64"
+}
+
+// defaultMachineTypeFor returns the QEMU machine type for arch VMs
+// that don't pick one.
+func defaultMachineTypeFor(arch string) string {
+	if normalizeArch(arch) == ArchARM64 {
+		return "virt"
+	}
+	return defaultMachineType
+}
+
+// consoleDevice returns the guest's name for the serial console of
+// arch VMs.
+func consoleDevice(arch string) string {
+	if normalizeArch(arch) == ArchARM64 {
+		return "ttyAMA0"
+	}
+	return "ttyS0"
+}
+
+// canAccelerate returns true if KVM can run arch VMs on this host.
+// Foreign architectures are fully emulated, which is much slower.
+func canAccelerate(arch string) bool {
+	return normalizeArch(arch) == runtime.GOARCH
+}
+
+// Arch returns the VM's CPU architecture.
+func (v *VM) Arch() string {
+	return normalizeArch(v.cfg.Arch)
+}
+
+// qemuFor returns the QEMU installation that runs arch VMs.
+func (u *Universe) qemuFor(arch string) (*QEMUInfo, error) {
+	if normalizeArch(arch) == ArchAMD64 {
+		return u.qemu, nil
+	}
+	return DetectQEMUArch(arch)
+}
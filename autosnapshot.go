@@ -0,0 +1,234 @@
+package virtuakube
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+const autoSnapshotPrefix = "auto-"
+
+// fsfreezeTimeout bounds how long checkpoints wait for a guest agent
+// to freeze or thaw its filesystems.
+const fsfreezeTimeout = 30 * time.Second
+
+// autoSnapshot takes a checkpoint of the universe every interval,
+// until the universe is closed.
+func (u *Universe) autoSnapshot(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-u.closedCh:
+			return
+		case <-t.C:
+		}
+
+		name := autoSnapshotPrefix + time.Now().Format("20060102-150405")
+		if err := u.checkpoint(name); err != nil {
+			u.log.Warn("auto snapshot failed", "snapshot", name, "error", err)
+		}
+	}
+}
+
+// checkpoint saves the universe's current state as snapshotName,
+// without stopping the universe, then prunes old auto snapshots.
+//
+// The guests' filesystems are frozen through their guest agents, and
+// all VMs are stopped before any is saved, so that the snapshot is of
+// a single moment across the universe, with clean disks. VMs without
+// a guest agent are saved as if they lost power. The universe's lock
+// is only held while the snapshot's config is taken and recorded, so
+// the universe stays usable while the VMs save, in parallel.
+func (u *Universe) checkpoint(snapshotName string) error {
+	u.checkpointMu.Lock()
+	defer u.checkpointMu.Unlock()
+
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return nil
+	}
+	if err := u.checkSnapshottableWithLock(); err != nil {
+		u.mu.Unlock()
+		return err
+	}
+	vms := make(map[string]*VM, len(u.vms))
+	for name, vm := range u.vms {
+		vms[name] = vm
+	}
+	u.mu.Unlock()
+	start := time.Now()
+
+	snap, err := u.checkpointVMs(snapshotName, vms)
+	if err != nil || snap == nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return nil
+	}
+	u.cfg.Snapshots[snapshotName] = snap
+
+	if err := u.pruneAutoSnapshotsWithLock(); err != nil {
+		return err
+	}
+
+	if err := config.Write(filepath.Join(u.dir, "config.json"), u.cfg); err != nil {
+		return err
+	}
+	u.timings.recordSave(time.Since(start))
+	u.emitEvent(Event{Kind: EventSnapshotSaved, Target: snapshotName, Duration: time.Since(start)})
+	return nil
+}
+
+// checkpointVMs freezes and stops vms, takes the config of snapshot
+// snapshotName, saves the VMs to it, and restarts them. It returns a
+// nil config if the universe closed in the meantime.
+func (u *Universe) checkpointVMs(snapshotName string, vms map[string]*VM) (*config.Snapshot, error) {
+	thaw := freezeFilesystems(vms)
+	defer thaw()
+
+	var stopped []*VM
+	defer func() {
+		for _, vm := range stopped {
+			if err := vm.startAfterCheckpoint(); err != nil {
+				vm.log.Warn("restarting VM after checkpoint failed", "error", err)
+			}
+		}
+	}()
+	for name, vm := range vms {
+		ok, err := vm.stopForCheckpoint()
+		if err != nil {
+			return nil, fmt.Errorf("stopping %q: %v", name, err)
+		}
+		if ok {
+			stopped = append(stopped, vm)
+		}
+	}
+
+	// Everything is stopped, so the configs match the VMs' state.
+	// The running universe keeps mutating its configs, so the
+	// snapshot needs its own copy.
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return nil, nil
+	}
+	for name := range u.vms {
+		if vms[name] == nil {
+			u.mu.Unlock()
+			return nil, fmt.Errorf("VM %q was created during the checkpoint", name)
+		}
+	}
+	snap, err := config.Copy(u.snapshotConfigWithLock(snapshotName))
+	u.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// VM saving is slow, so parallelize it. VMs destroyed since
+	// they were stopped aren't in the snapshot.
+	errs := make(chan error, len(vms))
+	for name, vm := range vms {
+		go func(name string, vm *VM) {
+			if snap.VMs[name] == nil {
+				errs <- nil
+				return
+			}
+			if err := vm.checkpoint(snap.ID); err != nil {
+				errs <- fmt.Errorf("checkpointing %q: %v", name, err)
+				return
+			}
+			errs <- nil
+		}(name, vm)
+	}
+	var retErr error
+	for range vms {
+		if err := <-errs; err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	if retErr != nil {
+		return nil, retErr
+	}
+	return snap, nil
+}
+
+// freezeFilesystems freezes the guest filesystems of the vms that
+// have a guest agent, and returns a function that thaws them. Freeze
+// failures are logged, the VM is then saved without freezing.
+func freezeFilesystems(vms map[string]*VM) func() {
+	var (
+		mu     sync.Mutex
+		frozen []*VM
+		wg     sync.WaitGroup
+	)
+	for _, vm := range vms {
+		wg.Add(1)
+		go func(vm *VM) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), fsfreezeTimeout)
+			defer cancel()
+			err := vm.withAgent(ctx, func(a *agentConn) error { return a.freezeFS(true) })
+			if err == errNoAgent {
+				return
+			} else if err != nil {
+				vm.log.Warn("freezing guest filesystems failed, checkpointing without", "error", err)
+			}
+			// A failed freeze can leave some filesystems frozen.
+			mu.Lock()
+			frozen = append(frozen, vm)
+			mu.Unlock()
+		}(vm)
+	}
+	wg.Wait()
+
+	return func() {
+		for _, vm := range frozen {
+			ctx, cancel := context.WithTimeout(context.Background(), fsfreezeTimeout)
+			if err := vm.withAgent(ctx, func(a *agentConn) error { return a.freezeFS(false) }); err != nil {
+				vm.log.Warn("thawing guest filesystems failed", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// pruneAutoSnapshotsWithLock deletes the oldest auto snapshots, so
+// that at most AutoSnapshotKeep remain.
+func (u *Universe) pruneAutoSnapshotsWithLock() error {
+	keep := u.runtimecfg.AutoSnapshotKeep
+	if keep <= 0 {
+		keep = 3
+	}
+
+	var autos []string
+	for name := range u.cfg.Snapshots {
+		if strings.HasPrefix(name, autoSnapshotPrefix) && name != u.activeSnapshot {
+			autos = append(autos, name)
+		}
+	}
+	if len(autos) <= keep {
+		return nil
+	}
+	// Timestamped names sort chronologically.
+	sort.Strings(autos)
+
+	for _, name := range autos[:len(autos)-keep] {
+		if err := u.deleteSnapshotWithLock(name); err != nil {
+			return err
+		}
+		u.log.Info("pruned auto snapshot", "snapshot", name)
+	}
+
+	return nil
+}
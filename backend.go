@@ -0,0 +1,92 @@
+package virtuakube
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// A backend is a hypervisor that runs a universe's VMs and networks.
+// VMs and networks drive their processes through it, so that
+// hypervisors other than QEMU can implement it alongside qemuBackend.
+//
+// Methods with a VM argument are called with the VM's lock held.
+type backend interface {
+	// tools returns the host commands that the backend needs.
+	tools() []string
+	// vmCommand returns the command that runs v. The VM starts with
+	// its CPUs stopped, and is controlled through the command's
+	// stdin and stdout.
+	vmCommand(u *Universe, v *VM, kernel *kernelConfig, resume bool) (*exec.Cmd, error)
+	// waitStarted waits until the freshly started v accepts control
+	// commands.
+	waitStarted(v *VM) error
+	// stopCPUs and startCPUs stop and restart v's CPUs.
+	stopCPUs(v *VM) error
+	startCPUs(v *VM) error
+	// saveVM saves v's disk and memory state to snapshot, which
+	// vmCommand resumes from when resume is set.
+	saveVM(v *VM, snapshot string) error
+	// deleteSnapshot deletes snapshot from v's disk.
+	deleteSnapshot(v *VM, snapshot string) error
+	// quit makes v's process exit, without waiting for it to.
+	quit(v *VM) error
+	// networkCommand returns the command that runs a network
+	// switch listening on the Unix socket sock.
+	networkCommand(sock string) *exec.Cmd
+}
+
+// backend returns the backend that runs the universe's VMs and
+// networks. QEMU is the only one so far.
+func (cfg *UniverseConfig) backend() backend {
+	return qemuBackend{}
+}
+
+// qemuBackend runs VMs with QEMU, and networks with vde_switch. VMs
+// are controlled through the human monitor on the qemu process's
+// stdio.
+type qemuBackend struct{}
+
+func (qemuBackend) tools() []string {
+	return universeTools
+}
+
+func (qemuBackend) vmCommand(u *Universe, v *VM, kernel *kernelConfig, resume bool) (*exec.Cmd, error) {
+	return u.qemuCommandWithLock(v, kernel, resume)
+}
+
+func (qemuBackend) waitStarted(v *VM) error {
+	if _, err := readToPrompt(v.monOut); err != nil {
+		return fmt.Errorf("reading qemu monitor prompt: %v", err)
+	}
+	return nil
+}
+
+func (qemuBackend) stopCPUs(v *VM) error {
+	_, err := v.monitorWithLock("stop")
+	return err
+}
+
+func (qemuBackend) startCPUs(v *VM) error {
+	_, err := v.monitorWithLock("cont")
+	return err
+}
+
+func (qemuBackend) saveVM(v *VM, snapshot string) error {
+	_, err := v.monitorWithLock("savevm " + snapshot)
+	return err
+}
+
+func (qemuBackend) deleteSnapshot(v *VM, snapshot string) error {
+	_, err := v.monitorWithLock("delvm " + snapshot)
+	return err
+}
+
+func (qemuBackend) quit(v *VM) error {
+	// There's no monitor response to wait for, qemu exits.
+	_, err := fmt.Fprintf(v.monIn, "quit\n")
+	return err
+}
+
+func (qemuBackend) networkCommand(sock string) *exec.Cmd {
+	return exec.Command("vde_switch", "--sock", sock, "-m", "0600")
+}
@@ -0,0 +1,232 @@
+package virtuakube
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// balloonPCISlot is the PCI slot of the memory balloon device, below
+// the extra disks'.
+const balloonPCISlot = 20
+
+const (
+	// minBalloonMiB is the least memory that a VM can be ballooned
+	// down to. Less than this and the guest kernel starts OOM
+	// killing its own system services.
+	minBalloonMiB = 128
+	// autoBalloonStepMiB is the smallest change that automatic
+	// ballooning bothers making, to avoid churning guest memory for
+	// no real gain.
+	autoBalloonStepMiB = 64
+)
+
+var balloonActualRe = regexp.MustCompile(`actual=(\d+)`)
+
+// balloonArgs returns the qemu arguments for the VM's memory balloon,
+// if it has one. VMs saved before ballooning was supported have
+// none, since adding the device would break loading their snapshots.
+func balloonArgs(v *VM) []string {
+	if !v.cfg.Balloon {
+		return nil
+	}
+	// With deflate-on-oom, the guest takes memory back from the
+	// balloon rather than OOM killing processes when it runs short.
+	return []string{"-device", fmt.Sprintf("virtio-balloon-pci,id=balloon0,addr=0x%x,deflate-on-oom=on", balloonPCISlot)}
+}
+
+// SetMemory changes the memory available to the running VM's guest
+// to size bytes, by inflating or deflating its memory balloon. Memory
+// given up by the guest is returned to the host. size is rounded
+// down to a whole MiB, and can't be more than the VM's configured
+// MemoryMiB, which is the most it can ever have.
+//
+// The guest's memory is ballooned for as long as the VM runs, and
+// stays ballooned across Save. Only VMs created by a virtuakube that
+// supports ballooning can be resized.
+func (v *VM) SetMemory(size int64) error {
+	mib := int(size >> 20)
+	if mib < minBalloonMiB {
+		return fmt.Errorf("memory must be at least %dMiB", minBalloonMiB)
+	}
+	if mib > v.cfg.MemoryMiB {
+		return fmt.Errorf("memory can't be more than the VM's configured %dMiB", v.cfg.MemoryMiB)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.setMemoryWithLock(mib)
+}
+
+func (v *VM) setMemoryWithLock(mib int) error {
+	if v.closed {
+		return errors.New("VM is closed")
+	}
+	if !v.cfg.Balloon {
+		return fmt.Errorf("VM %q has no memory balloon, it was created before ballooning was supported", v.cfg.Name)
+	}
+	if _, err := v.monitorWithLock(fmt.Sprintf("balloon %d", mib)); err != nil {
+		return err
+	}
+	if mib == v.cfg.MemoryMiB {
+		v.cfg.BalloonMiB = 0
+	} else {
+		v.cfg.BalloonMiB = mib
+	}
+	return nil
+}
+
+// Memory returns the memory currently available to the VM's guest, in
+// bytes. It's the VM's configured memory, less what's held by its
+// balloon. The guest may lag behind a SetMemory for a few seconds,
+// as it frees memory to give back.
+func (v *VM) Memory() (int64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return 0, errors.New("VM is closed")
+	}
+	if !v.cfg.Balloon {
+		return int64(v.cfg.MemoryMiB) << 20, nil
+	}
+	mib, err := v.balloonActualWithLock()
+	if err != nil {
+		return 0, err
+	}
+	return int64(mib) << 20, nil
+}
+
+// balloonMiB returns the memory the VM was last ballooned to, or zero
+// if it isn't ballooned.
+func (v *VM) balloonMiB() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.cfg.BalloonMiB
+}
+
+// balloonActualWithLock returns the guest's current memory in MiB, as
+// reported by the balloon device.
+func (v *VM) balloonActualWithLock() (int, error) {
+	out, err := v.monitorWithLock("info balloon")
+	if err != nil {
+		return 0, err
+	}
+	m := balloonActualRe.FindStringSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("can't parse balloon size from %q", strings.TrimSpace(out))
+	}
+	return strconv.Atoi(m[1])
+}
+
+// autoBalloon shrinks or grows each VM's memory to fit what its guest
+// is using every interval, until the universe is closed.
+func (u *Universe) autoBalloon(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-u.closedCh:
+			return
+		case <-t.C:
+		}
+
+		for _, vm := range u.VMs() {
+			if !vm.cfg.Balloon {
+				continue
+			}
+			if err := vm.fitMemory(); err != nil {
+				u.log.Warn("auto ballooning failed", "vm", vm.Hostname(), "error", err)
+			}
+		}
+	}
+}
+
+// fitMemory balloons the VM to the memory its guest is using, plus
+// headroom for it to grow into before the next adjustment.
+func (v *VM) fitMemory() error {
+	v.mu.Lock()
+	closed, paused, client := v.closed, v.paused, v.ssh
+	v.mu.Unlock()
+	if closed || paused || client == nil {
+		return nil
+	}
+
+	// Not v.Run, ballooning every few seconds would flood the
+	// command log.
+	sess, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	out, err := sess.Output("cat /proc/meminfo")
+	sess.Close()
+	if err != nil {
+		return err
+	}
+	availMiB, err := parseMemAvailable(out)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed || v.paused {
+		return nil
+	}
+	actual, err := v.balloonActualWithLock()
+	if err != nil {
+		return err
+	}
+	want := balloonTarget(actual, availMiB, v.cfg.MemoryMiB)
+	if want == actual {
+		return nil
+	}
+	// Small changes aren't worth it, unless the guest needs all
+	// the memory it can get.
+	if d := want - actual; d > -autoBalloonStepMiB && d < autoBalloonStepMiB && want != v.cfg.MemoryMiB {
+		return nil
+	}
+	return v.setMemoryWithLock(want)
+}
+
+// balloonTarget returns the memory in MiB to give a guest that has
+// actualMiB, of which availMiB is available, capped at maxMiB.
+func balloonTarget(actualMiB, availMiB, maxMiB int) int {
+	// Depending on the guest kernel, ballooned memory either shrinks
+	// MemTotal or counts as used, but it's never available, so this
+	// is right either way.
+	used := actualMiB - availMiB
+	// Page cache gets squeezed out as the balloon inflates, so leave
+	// some slack for it.
+	headroom := used / 4
+	if headroom < 256 {
+		headroom = 256
+	}
+	want := used + headroom
+	if want < minBalloonMiB {
+		want = minBalloonMiB
+	}
+	if want > maxMiB {
+		want = maxMiB
+	}
+	return want
+}
+
+// parseMemAvailable returns MemAvailable from /proc/meminfo, in MiB.
+func parseMemAvailable(meminfo []byte) (int, error) {
+	for _, line := range bytes.Split(meminfo, []byte("\n")) {
+		fs := strings.Fields(string(line))
+		if len(fs) < 2 || fs[0] != "MemAvailable:" {
+			continue
+		}
+		kib, err := strconv.Atoi(fs[1])
+		if err != nil {
+			return 0, fmt.Errorf("parsing MemAvailable: %v", err)
+		}
+		return kib >> 10, nil
+	}
+	return 0, errors.New("no MemAvailable in /proc/meminfo")
+}
@@ -0,0 +1,254 @@
+package virtuakube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DiskBenchSpec describes a disk benchmark, run with fio in the
+// guest. Zero values get sensible defaults.
+type DiskBenchSpec struct {
+	// Dir is the guest directory in which to create the test
+	// file. Defaults to /var/tmp.
+	Dir string
+	// Pattern is the fio I/O pattern: read, write, randread,
+	// randwrite, rw or randrw. Defaults to randread.
+	Pattern string
+	// BlockSize is the size of each I/O in bytes. Defaults to 4KiB.
+	BlockSize int
+	// Size is the size of the test file in bytes. Defaults to
+	// 256MiB.
+	Size int64
+	// QueueDepth is the number of I/Os in flight. Defaults to 32.
+	QueueDepth int
+	// Duration is how long to run the benchmark. Defaults to 30s.
+	Duration time.Duration
+	// Fsync issues an fsync after every write, like etcd's WAL does.
+	Fsync bool
+}
+
+// DiskBenchResult is the outcome of a disk benchmark. Only the
+// directions that Pattern exercises are non-zero.
+type DiskBenchResult struct {
+	Read  DiskBenchStats
+	Write DiskBenchStats
+}
+
+// DiskBenchStats are the results of a disk benchmark in one
+// direction.
+type DiskBenchStats struct {
+	IOPS           float64
+	BytesPerSecond float64
+	LatencyMean    time.Duration
+	LatencyP50     time.Duration
+	LatencyP99     time.Duration
+	LatencyP999    time.Duration
+}
+
+// NetBenchResult is the outcome of a network benchmark.
+type NetBenchResult struct {
+	// Network is the universe network that the benchmark ran over.
+	Network               string
+	SentBitsPerSecond     float64
+	ReceivedBitsPerSecond float64
+	Retransmits           int
+}
+
+var fioPatterns = map[string]bool{
+	"read":      true,
+	"write":     true,
+	"randread":  true,
+	"randwrite": true,
+	"rw":        true,
+	"randrw":    true,
+}
+
+// BenchDisk runs a disk benchmark in the VM, with fio. The VM's image
+// must have fio installed, e.g. by CustomizeInstallBenchTools.
+func (v *VM) BenchDisk(ctx context.Context, spec DiskBenchSpec) (*DiskBenchResult, error) {
+	if spec.Dir == "" {
+		spec.Dir = "/var/tmp"
+	}
+	if spec.Pattern == "" {
+		spec.Pattern = "randread"
+	}
+	if !fioPatterns[spec.Pattern] {
+		return nil, fmt.Errorf("unknown disk benchmark pattern %q", spec.Pattern)
+	}
+	if spec.BlockSize == 0 {
+		spec.BlockSize = 4096
+	}
+	if spec.Size == 0 {
+		spec.Size = 256 << 20
+	}
+	if spec.QueueDepth == 0 {
+		spec.QueueDepth = 32
+	}
+	if spec.Duration == 0 {
+		spec.Duration = 30 * time.Second
+	}
+
+	file := spec.Dir + "/virtuakube-bench"
+	args := []string{
+		"fio",
+		"--name=virtuakube",
+		"--filename=" + file,
+		"--rw=" + spec.Pattern,
+		fmt.Sprintf("--bs=%d", spec.BlockSize),
+		fmt.Sprintf("--size=%d", spec.Size),
+		fmt.Sprintf("--iodepth=%d", spec.QueueDepth),
+		fmt.Sprintf("--runtime=%d", int(spec.Duration.Seconds())),
+		"--time_based",
+		"--ioengine=libaio",
+		"--direct=1",
+		"--output-format=json",
+	}
+	if spec.Fsync {
+		args = append(args, "--fsync=1")
+	}
+
+	out, err := v.runContext(ctx, strings.Join(args, " ")+"; ret=$?; rm -f "+file+"; exit $ret")
+	if err != nil {
+		return nil, fmt.Errorf("running fio: %v", err)
+	}
+
+	var res struct {
+		Jobs []struct {
+			Read  fioStats `json:"read"`
+			Write fioStats `json:"write"`
+		} `json:"jobs"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("parsing fio output: %v", err)
+	}
+	if len(res.Jobs) != 1 {
+		return nil, fmt.Errorf("expected 1 fio job result, got %d", len(res.Jobs))
+	}
+
+	return &DiskBenchResult{
+		Read:  res.Jobs[0].Read.stats(),
+		Write: res.Jobs[0].Write.stats(),
+	}, nil
+}
+
+// fioStats is the per-direction section of fio's JSON output.
+type fioStats struct {
+	IOPS float64 `json:"iops"`
+	// In KiB/s.
+	BW    float64 `json:"bw"`
+	LatNS struct {
+		Mean float64 `json:"mean"`
+	} `json:"lat_ns"`
+	ClatNS struct {
+		Percentile map[string]float64 `json:"percentile"`
+	} `json:"clat_ns"`
+}
+
+func (f fioStats) stats() DiskBenchStats {
+	return DiskBenchStats{
+		IOPS:           f.IOPS,
+		BytesPerSecond: f.BW * 1024,
+		LatencyMean:    time.Duration(f.LatNS.Mean),
+		LatencyP50:     time.Duration(f.ClatNS.Percentile["50.000000"]),
+		LatencyP99:     time.Duration(f.ClatNS.Percentile["99.000000"]),
+		LatencyP999:    time.Duration(f.ClatNS.Percentile["99.900000"]),
+	}
+}
+
+// BenchNet runs a 10 second TCP throughput benchmark from the VM to
+// target, with iperf3, over the first network they share. Both VMs'
+// images must have iperf3 installed, e.g. by
+// CustomizeInstallBenchTools.
+func (v *VM) BenchNet(ctx context.Context, target *VM) (*NetBenchResult, error) {
+	network := ""
+	for _, mine := range v.Networks() {
+		for _, theirs := range target.Networks() {
+			if mine == theirs && network == "" {
+				network = mine
+			}
+		}
+	}
+	if network == "" {
+		return nil, fmt.Errorf("VMs %q and %q don't share a network", v.Hostname(), target.Hostname())
+	}
+
+	// One-off server, which exits after the benchmark.
+	if _, err := target.runContext(ctx, "iperf3 --server --one-off --daemon"); err != nil {
+		return nil, fmt.Errorf("starting iperf3 server on %q: %v", target.Hostname(), err)
+	}
+
+	var (
+		out []byte
+		err error
+	)
+	// The daemonized server might not be listening just yet.
+	for i := 0; i < 10; i++ {
+		out, err = v.runContext(ctx, fmt.Sprintf("iperf3 --client %s --time 10 --json", target.IPv4(network)))
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("running iperf3: %v", err)
+	}
+
+	var res struct {
+		End struct {
+			SumSent struct {
+				BitsPerSecond float64 `json:"bits_per_second"`
+				Retransmits   int     `json:"retransmits"`
+			} `json:"sum_sent"`
+			SumReceived struct {
+				BitsPerSecond float64 `json:"bits_per_second"`
+			} `json:"sum_received"`
+		} `json:"end"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("parsing iperf3 output: %v", err)
+	}
+
+	return &NetBenchResult{
+		Network:               network,
+		SentBitsPerSecond:     res.End.SumSent.BitsPerSecond,
+		ReceivedBitsPerSecond: res.End.SumReceived.BitsPerSecond,
+		Retransmits:           res.End.SumSent.Retransmits,
+	}, nil
+}
+
+// runContext is like Run, but kills the command if ctx is canceled.
+func (v *VM) runContext(ctx context.Context, command string) ([]byte, error) {
+	v.mu.Lock()
+	if v.ssh == nil {
+		v.mu.Unlock()
+		return nil, errors.New("VM not started")
+	}
+	sess, err := v.ssh.NewSession()
+	v.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sess.Signal(ssh.SIGKILL)
+			sess.Close()
+		case <-done:
+		}
+	}()
+
+	out, err := v.runWithSession(sess, command, nil)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return out, err
+}
@@ -0,0 +1,122 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// Networking modes, for UniverseConfig.Network.
+const (
+	// NetworkUser makes VMs reachable from the host only, through
+	// localhost port forwards.
+	NetworkUser = "user"
+	// NetworkBridged additionally attaches new VMs to a host bridge,
+	// so that they get addresses from the physical LAN over DHCP,
+	// and other machines can reach them directly.
+	NetworkBridged = "bridged"
+)
+
+// lanPCISlot is the PCI slot of the NIC of bridged VMs. Its interface
+// in the guest is enp0s30.
+const lanPCISlot = 30
+
+// qemuBridgeHelpers are where distros install qemu-bridge-helper,
+// which creates and bridges tap devices for unprivileged qemu.
+var qemuBridgeHelpers = []string{"/usr/lib/qemu/qemu-bridge-helper", "/usr/libexec/qemu-bridge-helper"}
+
+// validateNetwork checks the networking mode of cfg against the host.
+func (cfg *UniverseConfig) validateNetwork() error {
+	switch cfg.Network {
+	case "", NetworkUser:
+		if cfg.Bridge != "" {
+			return errors.New("UniverseConfig.Bridge requires the bridged network mode")
+		}
+		return nil
+	case NetworkBridged:
+	default:
+		return fmt.Errorf("unknown network mode %q", cfg.Network)
+	}
+
+	if cfg.Bridge == "" {
+		return errors.New("bridged network mode requires a UniverseConfig.Bridge")
+	}
+	if _, err := net.InterfaceByName(cfg.Bridge); err != nil {
+		return fmt.Errorf("host bridge %q: %v", cfg.Bridge, err)
+	}
+	for _, path := range qemuBridgeHelpers {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+	return errors.New("bridged network mode requires qemu-bridge-helper, install your distro's qemu bridge helper package")
+}
+
+// lanArgs returns the qemu arguments that attach the VM to its host
+// bridge, if any.
+func lanArgs(cfg *config.VM) []string {
+	if cfg.Bridge == "" {
+		return nil
+	}
+	return []string{
+		"-netdev", fmt.Sprintf("bridge,id=lan,br=%s", cfg.Bridge),
+		"-device", fmt.Sprintf("virtio-net,netdev=lan,addr=0x%x,mac=%s", lanPCISlot, cfg.BridgeMAC),
+	}
+}
+
+// configureLAN gets the VM a LAN address over DHCP. The slirp network
+// stays the default route, so that the VM's internet access doesn't
+// depend on the LAN.
+func (v *VM) configureLAN() error {
+	dev := fmt.Sprintf("enp0s%d", lanPCISlot)
+	err := v.RunMultiple(
+		fmt.Sprintf("ip link set dev %s up", dev),
+		fmt.Sprintf("dhclient -1 -4 %s", dev),
+	)
+	if err != nil {
+		return fmt.Errorf("getting a LAN address: %v", err)
+	}
+	v.mu.Lock()
+	v.lanIP = nil
+	v.mu.Unlock()
+	return nil
+}
+
+// LANAddress returns the IPv4 address that the VM has on the host's
+// LAN, or nil if the VM isn't bridged or doesn't have an address
+// (yet).
+func (v *VM) LANAddress() net.IP {
+	if v.cfg.Bridge == "" {
+		return nil
+	}
+	v.mu.Lock()
+	ip := v.lanIP
+	v.mu.Unlock()
+	if ip != nil {
+		return ip
+	}
+
+	out, err := v.Run(fmt.Sprintf("ip -4 -o addr show dev enp0s%d scope global", lanPCISlot))
+	if err != nil {
+		return nil
+	}
+	// Lines look like: "4: enp0s30    inet 192.168.1.23/24 brd ..."
+	fs := strings.Fields(string(out))
+	for i := 0; i+1 < len(fs); i++ {
+		if fs[i] == "inet" {
+			ip, _, err := net.ParseCIDR(fs[i+1])
+			if err != nil {
+				return nil
+			}
+			v.mu.Lock()
+			v.lanIP = ip
+			v.mu.Unlock()
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package virtuakube
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// trustedCADir is where nodes keep the extra CAs they trust, for
+// update-ca-certificates.
+const trustedCADir = "/usr/local/share/ca-certificates"
+
+// CertificateAuthority is a pre-generated cluster CA.
+type CertificateAuthority struct {
+	// Cert is the PEM-encoded CA certificate.
+	Cert []byte
+	// Key is the PEM-encoded private key of Cert, RSA or ECDSA.
+	Key []byte
+}
+
+func (ca *CertificateAuthority) validate() error {
+	pair, err := tls.X509KeyPair(ca.Cert, ca.Key)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if !cert.IsCA {
+		return errors.New("certificate isn't a CA")
+	}
+	return nil
+}
+
+// validateSAN checks that san is an IP address or DNS name.
+func validateSAN(san string) error {
+	if net.ParseIP(san) != nil {
+		return nil
+	}
+	if errs := validation.IsDNS1123Subdomain(san); len(errs) > 0 {
+		return fmt.Errorf("invalid certificate SAN %q: %s", san, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// validateCACerts checks that pems are all PEM-encoded certificates.
+func validateCACerts(pems [][]byte) error {
+	for i, bs := range pems {
+		block, rest := pem.Decode(bs)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return fmt.Errorf("CA %d isn't a PEM certificate", i)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("CA %d: %v", i, err)
+		}
+		if len(strings.TrimSpace(string(rest))) > 0 {
+			return fmt.Errorf("CA %d has more than one certificate", i)
+		}
+	}
+	return nil
+}
+
+// installClusterCA makes the cluster's CA the one that kubeadm init
+// uses on the controller, instead of generating one.
+func (c *Cluster) installClusterCA() error {
+	if c.ca == nil {
+		return nil
+	}
+	if _, err := c.controller.Run("mkdir -p /etc/kubernetes/pki"); err != nil {
+		return err
+	}
+	if err := c.controller.WriteFile("/etc/kubernetes/pki/ca.crt", c.ca.Cert); err != nil {
+		return err
+	}
+	if err := c.controller.WriteFile("/etc/kubernetes/pki/ca.key", c.ca.Key); err != nil {
+		return err
+	}
+	_, err := c.controller.Run("chmod 600 /etc/kubernetes/pki/ca.key")
+	return err
+}
+
+// TrustCA adds the PEM-encoded CA certificate to the trust store of
+// all of the cluster's nodes, and of nodes added later, e.g. so that
+// they can pull from a registry with a private CA. Each node's
+// container runtime is restarted to pick up the new CA. With
+// RuntimeDocker, that restarts the node's containers too.
+//
+// Pods don't use the nodes' trust store. A webhook's CA goes in its
+// webhook configuration's caBundle instead.
+func (c *Cluster) TrustCA(cert []byte) error {
+	if err := validateCACerts([][]byte{cert}); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started {
+		return errors.New("cluster isn't started")
+	}
+	for _, vm := range c.podVMsWithLock() {
+		if err := c.installTrustedCAs(vm, [][]byte{cert}); err != nil {
+			return err
+		}
+	}
+	// Nodes being added concurrently may be reading the old list.
+	cas := append([][]byte{}, c.cfg.TrustedCAs...)
+	c.cfg.TrustedCAs = append(cas, cert)
+	return nil
+}
+
+// installTrustedCAs adds cas to vm's trust store, and restarts its
+// container runtime if it's running.
+func (c *Cluster) installTrustedCAs(vm *VM, cas [][]byte) error {
+	if len(cas) == 0 {
+		return nil
+	}
+	for _, ca := range cas {
+		sum := sha256.Sum256(ca)
+		path := fmt.Sprintf("%s/virtuakube-%x.crt", trustedCADir, sum[:4])
+		if err := vm.WriteFile(path, ca); err != nil {
+			return fmt.Errorf("installing CA on %q: %v", vm.Hostname(), err)
+		}
+	}
+	cmd := fmt.Sprintf("update-ca-certificates && if systemctl -q is-active %[1]s; then systemctl restart %[1]s; fi", runtimeService(c.Runtime()))
+	if _, err := vm.Run(cmd); err != nil {
+		return fmt.Errorf("updating trust store on %q: %v", vm.Hostname(), err)
+	}
+	return nil
+}
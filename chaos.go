@@ -0,0 +1,142 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Fault injection: the failure modes that Kubernetes controllers must
+// cope with, on demand. Stress and disk fills run inside the guest,
+// as transient systemd units named vkube-chaos-*, so that they can be
+// stopped and time out without virtuakube keeping track of them.
+
+const (
+	// chaosFillFile is the file that FillDisk fills the root
+	// filesystem with.
+	chaosFillFile = "/var/lib/vkube-chaos-fill"
+	// chaosMemoryDir is the tmpfs that StressMemory fills.
+	chaosMemoryDir = "/run/vkube-chaos-memory"
+)
+
+// Kill kills the VM's QEMU process with SIGKILL, as if the host it
+// ran on lost power. The VM's disk keeps whatever writes reached it,
+// and the universe emits EventVMCrashed. A killed VM can't be
+// restarted, and the universe can no longer be saved.
+func (v *VM) Kill() error {
+	v.mu.Lock()
+	closed := v.closed
+	v.mu.Unlock()
+	if closed {
+		return errors.New("VM is closed")
+	}
+	// Without v.mu, so that the kill interrupts whatever operation
+	// holds it, like a real crash.
+	if err := v.cmd.Process.Kill(); err != nil {
+		return fmt.Errorf("killing %q: %v", v.cfg.Name, err)
+	}
+	<-v.stopped
+	return nil
+}
+
+// FillDisk fills the VM's root filesystem with a file, until percent
+// of its space is used, e.g. to test disk pressure evictions. Filling
+// to 0 percent removes the file.
+func (v *VM) FillDisk(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("disk fill must be between 0 and 100 percent, not %d", percent)
+	}
+	cmd := "rm -f " + chaosFillFile
+	if percent > 0 {
+		cmd += fmt.Sprintf(" && set -- $(df --output=size,used -B1 / | tail -n1) && n=$(($1 * %d / 100 - $2)) && if [ $n -gt 0 ]; then fallocate -l $n %s; fi", percent, chaosFillFile)
+	}
+	if _, err := v.Run(cmd); err != nil {
+		return fmt.Errorf("filling disk of %q: %v", v.cfg.Name, err)
+	}
+	return nil
+}
+
+// StressCPU keeps workers of the VM's vCPUs busy for d, or until
+// StopStress if d is zero. Zero workers means all of the VM's vCPUs.
+func (v *VM) StressCPU(workers int, d time.Duration) error {
+	if workers < 0 {
+		return fmt.Errorf("invalid number of CPU stress workers %d", workers)
+	}
+	if workers == 0 {
+		workers = v.CPUs()
+	}
+	for i := 0; i < workers; i++ {
+		unit := "vkube-chaos-cpu-" + strconv.Itoa(i)
+		if err := v.runChaosUnit(unit, d, "", "while :; do :; done"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StressMemory takes size bytes of the VM's memory away from its
+// workloads for d, or until StopStress if d is zero, e.g. to test
+// memory pressure evictions and the OOM killer. The memory is held in
+// a tmpfs, which the guest can't reclaim without swap.
+func (v *VM) StressMemory(size int64, d time.Duration) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid memory stress size %d", size)
+	}
+	script := fmt.Sprintf("mkdir -p %[1]s && mount -t tmpfs -o size=%[2]d tmpfs %[1]s && head -c %[2]d /dev/zero >%[1]s/fill; exec sleep infinity", chaosMemoryDir, size)
+	return v.runChaosUnit("vkube-chaos-memory", d, "umount "+chaosMemoryDir, script)
+}
+
+// StopStress stops the VM's CPU and memory stress early.
+func (v *VM) StopStress() error {
+	if _, err := v.Run("systemctl stop 'vkube-chaos-*' && systemctl reset-failed 'vkube-chaos-*' 2>/dev/null || true"); err != nil {
+		return fmt.Errorf("stopping stress on %q: %v", v.cfg.Name, err)
+	}
+	return nil
+}
+
+// runChaosUnit runs script in the VM as the transient systemd service
+// unit, for d or until it's stopped, then runs stop.
+func (v *VM) runChaosUnit(unit string, d time.Duration, stop, script string) error {
+	max := "infinity"
+	if d > 0 {
+		max = strconv.Itoa(int(d.Seconds() + 0.5))
+	}
+	// Stretch's systemd predates systemd-run --collect, so failed
+	// runs of the unit linger until the next run resets them.
+	cmd := fmt.Sprintf("systemctl stop %[1]s 2>/dev/null; systemctl reset-failed %[1]s 2>/dev/null; systemd-run -q --unit %[1]s -p RuntimeMaxSec=%[2]s", unit, max)
+	if stop != "" {
+		cmd += fmt.Sprintf(" -p %q", "ExecStopPost=/bin/sh -c '"+stop+"'")
+	}
+	cmd += fmt.Sprintf(" /bin/sh -c %q", script)
+	if _, err := v.Run(cmd); err != nil {
+		return fmt.Errorf("starting %s on %q: %v", unit, v.cfg.Name, err)
+	}
+	return nil
+}
+
+// KillRandomNode kills a random worker node of the cluster that's
+// still running, as VM.Kill does, and returns its name. The node stays
+// in the cluster, NotReady, like a node whose machine died.
+func (c *Cluster) KillRandomNode() (string, error) {
+	c.mu.Lock()
+	var alive []*VM
+	for _, node := range c.nodes {
+		select {
+		case <-node.stopped:
+		default:
+			alive = append(alive, node)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(alive) == 0 {
+		return "", fmt.Errorf("cluster %q has no running worker nodes", c.Name())
+	}
+	node := alive[rand.Intn(len(alive))]
+	if err := node.Kill(); err != nil {
+		return "", err
+	}
+	return node.Hostname(), nil
+}
@@ -0,0 +1,73 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SetClockOffset sets the VM's clock to run d ahead of the universe's
+// clock, or behind it if d is negative, e.g. to test certificate
+// expiry, token TTLs or lease renewal under clock drift. Zero puts
+// the clock back in sync with the universe.
+//
+// virtuakube disables NTP in guests, so the clock keeps its offset
+// until it's changed again. The offset is saved with the universe,
+// and reapplied when the VM resumes.
+func (v *VM) SetClockOffset(d time.Duration) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("VM is closed")
+	}
+	if v.ssh == nil {
+		return errors.New("VM isn't started")
+	}
+	if v.paused {
+		return errors.New("VM is paused")
+	}
+
+	old := v.cfg.ClockOffset
+	v.cfg.ClockOffset = d
+	if err := v.syncClockWithLock(); err != nil {
+		v.cfg.ClockOffset = old
+		return fmt.Errorf("setting clock of %q: %v", v.cfg.Name, err)
+	}
+	return nil
+}
+
+// ClockOffset returns how far ahead of the universe's clock the VM's
+// clock runs, see SetClockOffset.
+func (v *VM) ClockOffset() time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.cfg.ClockOffset
+}
+
+// syncClockWithLock sets the guest's clock to the universe's current
+// time, plus the VM's clock offset.
+func (v *VM) syncClockWithLock() error {
+	now := v.universeStartTime.Add(time.Since(v.universeOpenTime)).Add(v.cfg.ClockOffset)
+	sess, err := v.ssh.NewSession()
+	if err != nil {
+		return err
+	}
+	_, err = v.runWithSession(sess, fmt.Sprintf("timedatectl set-time %q", now.Format("2006-01-02 15:04:05")), nil)
+	return err
+}
+
+// SkewNode sets the clock of the cluster's node name to run d ahead
+// of the universe's clock, as VM.SetClockOffset does. Control plane
+// nodes can be skewed too, e.g. to make the API server see client
+// certificates as expired.
+func (c *Cluster) SkewNode(name string, d time.Duration) error {
+	c.mu.Lock()
+	vms := c.vmsWithLock()
+	c.mu.Unlock()
+	for _, vm := range vms {
+		if vm.Hostname() == name {
+			return vm.SetClockOffset(d)
+		}
+	}
+	return fmt.Errorf("cluster %q doesn't have a node named %q", c.Name(), name)
+}
@@ -0,0 +1,249 @@
+package virtuakube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// CloneVM creates a copy of src named newName, and boots it. The
+// copy's disk is a snapshot of src's disk, taken with src paused if
+// it's running, that shares src's base image copy-on-write, so only
+// what src has written since it was created is copied. Preparing one
+// VM and cloning it is much faster than booting many VMs from the
+// base image and preparing each.
+//
+// The copy has src's configuration, except that it gets its own
+// hostname, MACs, addresses and forwarded ports, and regenerates its
+// SSH host keys and machine ID. Its extra disks and UEFI variables are
+// copies of src's, and its TPM starts out empty. Link conditions,
+// partitions, hot-plugged NICs and cloud-init data aren't copied. VMs
+// that are cluster members or have passed-through devices can't be
+// cloned. If newName is empty, the copy gets a random name.
+func (u *Universe) CloneVM(ctx context.Context, src *VM, newName string) (*VM, error) {
+	vm, err := u.cloneVM(ctx, src, newName)
+	if err != nil {
+		return nil, err
+	}
+	if err := vm.Start(ctx); err != nil {
+		u.destroyVM(vm.Hostname())
+		return nil, fmt.Errorf("starting clone: %v", err)
+	}
+	err = vm.RunMultiple(
+		"rm -f /etc/ssh/ssh_host_*",
+		"ssh-keygen -A",
+		"systemctl restart ssh",
+		// Images' machine ID is empty and immutable, so that systemd
+		// makes up a new one at each boot. Imported disks may have a
+		// real one.
+		"if [ -s /etc/machine-id ] && rm -f /etc/machine-id 2>/dev/null; then rm -f /var/lib/dbus/machine-id && systemd-machine-id-setup; fi",
+	)
+	if err != nil {
+		u.destroyVM(vm.Hostname())
+		return nil, fmt.Errorf("regenerating identity of clone: %v", err)
+	}
+	return vm, nil
+}
+
+// cloneVM creates the unstarted copy of src for CloneVM.
+func (u *Universe) cloneVM(ctx context.Context, src *VM, newName string) (*VM, error) {
+	if src == nil || src.universe != u {
+		return nil, errors.New("source VM isn't in this universe")
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.vms[src.cfg.Name] != src {
+		return nil, fmt.Errorf("VM %q was destroyed", src.cfg.Name)
+	}
+	if newName == "" {
+		newName = randomHostname()
+	}
+	if u.vms[newName] != nil {
+		return nil, fmt.Errorf("universe already has a VM named %q", newName)
+	}
+	if len(src.cfg.Devices) > 0 {
+		return nil, fmt.Errorf("VM %q has passed-through devices, which can't be shared with a clone", src.cfg.Name)
+	}
+	if c := u.clusterOfWithLock(src); c != nil {
+		return nil, fmt.Errorf("VM %q is a member of cluster %q, add nodes with Cluster.AddNode instead", src.cfg.Name, c.Name())
+	}
+
+	vmcfg := &config.VM{
+		Name:         newName,
+		DiskFile:     randomDiskName(),
+		MemoryMiB:    src.cfg.MemoryMiB,
+		CPUs:         src.cfg.CPUs,
+		CPUModel:     src.cfg.CPUModel,
+		PortForwards: map[int]int{},
+		Networks:     src.cfg.Networks,
+		MAC:          map[string]string{},
+		IPv4:         map[string]net.IP{},
+		IPv6:         map[string]net.IP{},
+		MTU:          map[string]int{},
+		DiskLimits:   src.cfg.DiskLimits,
+		MachineType:  src.cfg.MachineType,
+		TmpfsDisk:    src.cfg.TmpfsDisk,
+		Firmware:     src.cfg.Firmware,
+		SecureBoot:   src.cfg.SecureBoot,
+		TPM:          src.cfg.TPM,
+		NestedVirt:   src.cfg.NestedVirt,
+		HotplugNICs:  src.cfg.HotplugNICs,
+		Balloon:      src.cfg.Balloon,
+		GuestAgent:   src.cfg.GuestAgent,
+		Arch:         src.cfg.Arch,
+		// External kernels are image files, which are shared.
+		Kernel:         src.cfg.Kernel,
+		Initrd:         src.cfg.Initrd,
+		Mounts:         src.cfg.Mounts,
+		AuthorizedKeys: src.cfg.AuthorizedKeys,
+		ClockOffset:    src.cfg.ClockOffset,
+	}
+	if src.cfg.Bridge != "" {
+		vmcfg.Bridge, vmcfg.BridgeMAC = src.cfg.Bridge, randomMAC()
+	}
+	for _, net := range vmcfg.Networks {
+		nw := u.networks[net]
+		if nw == nil {
+			return nil, fmt.Errorf("universe doesn't have a network named %q", net)
+		}
+		ip4, ip6, err := nw.ip()
+		if err != nil {
+			return nil, err
+		}
+		vmcfg.MAC[net] = randomMAC()
+		vmcfg.IPv4[net] = ip4
+		vmcfg.IPv6[net] = ip6
+		vmcfg.MTU[net] = src.cfg.MTU[net]
+	}
+	u.allocPortsWithLock(vmcfg, vmForwards(src.cfg))
+	if vmcfg.TmpfsDisk {
+		disk, err := u.tmpfsDiskWithLock()
+		if err != nil {
+			return nil, err
+		}
+		vmcfg.DiskFile = disk
+	}
+
+	// Files the clone got before failing to be created.
+	var files []string
+	cleanup := func() {
+		for _, f := range files {
+			os.Remove(u.diskPath(f))
+		}
+	}
+	err := src.whilePaused(func() error {
+		files = append(files, vmcfg.DiskFile)
+		if err := u.copyDisk(ctx, src.cfg.DiskFile, vmcfg.DiskFile, true); err != nil {
+			return fmt.Errorf("copying disk: %v", err)
+		}
+		for i, d := range src.cfg.Disks {
+			d.File = randomDiskName()
+			files = append(files, d.File)
+			if err := u.copyDisk(ctx, src.cfg.Disks[i].File, d.File, false); err != nil {
+				return fmt.Errorf("copying disk %d: %v", i+1, err)
+			}
+			vmcfg.Disks = append(vmcfg.Disks, d)
+		}
+		if src.cfg.VarsFile != "" {
+			vmcfg.VarsFile = randomDiskName()
+			files = append(files, vmcfg.VarsFile)
+			if err := u.copyDisk(ctx, src.cfg.VarsFile, vmcfg.VarsFile, false); err != nil {
+				return fmt.Errorf("copying UEFI variable store: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("cloning VM %q: %v", src.cfg.Name, err)
+	}
+
+	vm, err := u.mkVM(vmcfg, nil, false)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("creating VM: %v", err)
+	}
+	return vm, nil
+}
+
+// whilePaused runs f with the VM's CPUs stopped, if it's running, so
+// that its disks don't change under f. Stopping the CPUs also makes
+// qemu flush the disks.
+func (v *VM) whilePaused(f func() error) error {
+	// Booted VMs have an SSH connection.
+	v.mu.Lock()
+	running := v.ssh != nil && !v.closed && !v.paused
+	v.mu.Unlock()
+	if running {
+		// Best effort at getting the guest's dirty pages onto the
+		// disk.
+		v.Run("sync")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.ssh == nil || v.closed || v.paused {
+		return f()
+	}
+	if err := v.universe.backend.stopCPUs(v); err != nil {
+		return err
+	}
+	err := f()
+	if cerr := v.universe.backend.startCPUs(v); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// copyDisk copies the disk file src to dst, skipping src's internal
+// snapshots. If keepBacking is set, dst shares src's backing file, so
+// only what src has on top of it is copied.
+func (u *Universe) copyDisk(ctx context.Context, src, dst string, keepBacking bool) error {
+	// src may be open in a running qemu, which locks it.
+	out, err := exec.CommandContext(ctx, "qemu-img", "info", "-U", "--output=json", u.diskPath(src)).Output()
+	if err != nil {
+		return fmt.Errorf("inspecting %q: %v", src, err)
+	}
+	var info struct {
+		Format        string `json:"format"`
+		Backing       string `json:"full-backing-filename"`
+		BackingFormat string `json:"backing-filename-format"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return fmt.Errorf("parsing qemu-img info of %q: %v", src, err)
+	}
+
+	args := []string{"convert", "-U", "-f", info.Format, "-O", info.Format}
+	if keepBacking && info.Backing != "" {
+		args = append(args, "-B", info.Backing)
+		if info.BackingFormat != "" {
+			args = append(args, "-F", info.BackingFormat)
+		}
+	}
+	args = append(args, u.diskPath(src), u.diskPath(dst))
+	if out, err := exec.CommandContext(ctx, "qemu-img", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v\n%s", err, string(out))
+	}
+	return nil
+}
+
+// clusterOfWithLock returns the cluster that vm is a member of, or
+// nil.
+func (u *Universe) clusterOfWithLock(vm *VM) *Cluster {
+	for _, c := range u.clusters {
+		for _, m := range c.members() {
+			if m == vm {
+				return c
+			}
+		}
+	}
+	return nil
+}
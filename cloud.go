@@ -0,0 +1,283 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+const (
+	// providerIDPrefix is the scheme of node provider IDs in the fake
+	// cloud.
+	providerIDPrefix = "virtuakube://"
+	// uninitializedTaint is set by kubelets running with an external
+	// cloud provider, until the cloud controller has initialized the
+	// node.
+	uninitializedTaint = "node.cloudprovider.kubernetes.io/uninitialized"
+)
+
+// FakeCloud is a simulated cloud provider for a cluster. It plays the
+// part of an external cloud-controller-manager: it initializes nodes
+// with provider IDs and addresses, gives LoadBalancer Services an
+// address on the cluster network, and deletes Node objects when their
+// cloud instance goes away.
+//
+// LoadBalancer addresses are reachable from the cluster's nodes,
+// courtesy of kube-proxy, but nothing else announces them. See
+// ClusterConfig.ExposeServices to reach them from the host.
+type FakeCloud struct {
+	cluster *Cluster
+	network *Network
+
+	// cfg is saved along with the universe, so changes to it hold
+	// the universe's lock as well as mu.
+	mu  sync.Mutex
+	cfg *config.Cloud
+}
+
+func newFakeCloud(c *Cluster, cfg *config.Cloud) *FakeCloud {
+	if cfg.LoadBalancers == nil {
+		cfg.LoadBalancers = map[string]string{}
+	}
+	if cfg.Deleted == nil {
+		cfg.Deleted = map[string]bool{}
+	}
+	ret := &FakeCloud{
+		cluster: c,
+		network: c.universe.networks[c.controller.Networks()[0]],
+		cfg:     cfg,
+	}
+	for key, ip := range cfg.LoadBalancers {
+		ret.network.claim(ret.lbOwner(key), ip)
+	}
+	return ret
+}
+
+// lbOwner describes the load balancer of the service key, as an owner
+// of network addresses.
+func (f *FakeCloud) lbOwner(key string) string {
+	return fmt.Sprintf("load balancer %q of cluster %q", key, f.cluster.Name())
+}
+
+// ProviderID returns the provider ID of the instance running node.
+func (f *FakeCloud) ProviderID(node string) string {
+	return providerIDPrefix + f.cluster.Name() + "/" + node
+}
+
+// InstanceExists reports whether the cloud instance with providerID
+// exists.
+func (f *FakeCloud) InstanceExists(providerID string) bool {
+	node := strings.TrimPrefix(providerID, providerIDPrefix+f.cluster.Name()+"/")
+	if node == providerID {
+		return false
+	}
+
+	f.mu.Lock()
+	deleted := f.cfg.Deleted[node]
+	f.mu.Unlock()
+	return !deleted && f.cluster.universe.VM(node) != nil
+}
+
+// DeleteInstance simulates the deletion of node's instance in the
+// cloud, behind Kubernetes' back: the node's VM is destroyed, and the
+// cloud controller then removes the Node object, as a real
+// cloud-controller-manager would when a cloud instance vanishes.
+func (f *FakeCloud) DeleteInstance(node string) error {
+	c := f.cluster
+	if node == c.controller.Hostname() {
+		return errors.New("can't delete the cluster controller's instance")
+	}
+
+	c.mu.Lock()
+	idx := -1
+	for i, n := range c.nodes {
+		if n.Hostname() == node {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		c.mu.Unlock()
+		return fmt.Errorf("cluster %q doesn't have a node named %q", c.Name(), node)
+	}
+	c.forgetNodeWithLock(node)
+	c.mu.Unlock()
+
+	f.mu.Lock()
+	c.universe.mu.Lock()
+	f.cfg.Deleted[node] = true
+	c.universe.mu.Unlock()
+	f.mu.Unlock()
+
+	return c.universe.destroyVM(node)
+}
+
+// LoadBalancers returns the addresses assigned to LoadBalancer
+// Services, keyed by "namespace/name".
+func (f *FakeCloud) LoadBalancers() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ret := map[string]string{}
+	for k, v := range f.cfg.LoadBalancers {
+		ret[k] = v
+	}
+	return ret
+}
+
+// run reconciles the cluster against the fake cloud until the
+// universe closes.
+func (f *FakeCloud) run() {
+	for {
+		select {
+		case <-f.cluster.universe.closedCh:
+			return
+		case <-time.After(2 * time.Second):
+		}
+		if err := f.reconcile(); err != nil {
+			f.cluster.universe.log.Warn("fake cloud reconcile failed", "cluster", f.cluster.Name(), "error", err)
+		}
+	}
+}
+
+func (f *FakeCloud) reconcile() error {
+	if err := f.reconcileNodes(); err != nil {
+		return err
+	}
+	return f.reconcileServices()
+}
+
+// nodesInitialized reports whether every node has been initialized
+// by the cloud controller.
+func (f *FakeCloud) nodesInitialized() (bool, error) {
+	nodes, err := f.cluster.client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, node := range nodes.Items {
+		if node.Spec.ProviderID == "" || hasTaint(&node, uninitializedTaint) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (f *FakeCloud) reconcileNodes() error {
+	client := f.cluster.client.CoreV1().Nodes()
+	nodes, err := client.List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+
+		if node.Spec.ProviderID != "" && !f.InstanceExists(node.Spec.ProviderID) {
+			if err := client.Delete(node.Name, &metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("deleting node %q: %v", node.Name, err)
+			}
+			continue
+		}
+
+		if node.Spec.ProviderID != "" && !hasTaint(node, uninitializedTaint) {
+			continue
+		}
+		vm := f.cluster.universe.VM(node.Name)
+		if vm == nil {
+			continue
+		}
+
+		node.Status.Addresses = []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: vm.IPv4(vm.Networks()[0]).String()},
+			{Type: corev1.NodeHostName, Address: node.Name},
+		}
+		node, err = client.UpdateStatus(node)
+		if err != nil {
+			return fmt.Errorf("setting addresses of node %q: %v", nodes.Items[i].Name, err)
+		}
+
+		node.Spec.ProviderID = f.ProviderID(node.Name)
+		var taints []corev1.Taint
+		for _, t := range node.Spec.Taints {
+			if t.Key != uninitializedTaint {
+				taints = append(taints, t)
+			}
+		}
+		node.Spec.Taints = taints
+		if _, err := client.Update(node); err != nil {
+			return fmt.Errorf("initializing node %q: %v", node.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *FakeCloud) reconcileServices() error {
+	u := f.cluster.universe
+	client := f.cluster.client.CoreV1()
+	svcs, err := client.Services("").List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seen := map[string]bool{}
+	for i := range svcs.Items {
+		svc := &svcs.Items[i]
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		key := svc.Namespace + "/" + svc.Name
+		seen[key] = true
+
+		ip := f.cfg.LoadBalancers[key]
+		if ip == "" {
+			ip4, _, err := f.network.ip()
+			if err != nil {
+				return fmt.Errorf("allocating address for service %q: %v", key, err)
+			}
+			ip = ip4.String()
+			if err := f.network.claim(f.lbOwner(key), ip); err != nil {
+				return fmt.Errorf("allocating address for service %q: %v", key, err)
+			}
+			u.mu.Lock()
+			f.cfg.LoadBalancers[key] = ip
+			u.mu.Unlock()
+		}
+		if len(svc.Status.LoadBalancer.Ingress) == 1 && svc.Status.LoadBalancer.Ingress[0].IP == ip {
+			continue
+		}
+		svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: ip}}
+		if _, err := client.Services(svc.Namespace).UpdateStatus(svc); err != nil {
+			return fmt.Errorf("setting load balancer address of service %q: %v", key, err)
+		}
+	}
+
+	// Release the addresses of load balancers that are gone.
+	u.mu.Lock()
+	for key, ip := range f.cfg.LoadBalancers {
+		if !seen[key] {
+			f.network.unclaim(ip)
+			delete(f.cfg.LoadBalancers, key)
+		}
+	}
+	u.mu.Unlock()
+
+	return nil
+}
+
+func hasTaint(node *corev1.Node, key string) bool {
+	for _, t := range node.Spec.Taints {
+		if t.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package virtuakube
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// cloudInitTools are the host tools needed to boot VMs with a
+// CloudInitConfig.
+var cloudInitTools = []string{"genisoimage"}
+
+// cloudInitCfg configures cloud-init in images. Only the NoCloud
+// seed that virtuakube attaches is consulted, and cloud-init leaves
+// networking and root's SSH access, which virtuakube manages, alone.
+const cloudInitCfg = `
+datasource_list: [ NoCloud, None ]
+network:
+  config: disabled
+disable_root: false
+ssh_pwauth: true
+`
+
+// CloudInitConfig is cloud-init configuration for a VM, passed to
+// the guest on a NoCloud seed disk at boot. The VM's image must have
+// cloud-init installed, see CustomizeInstallCloudInit.
+type CloudInitConfig struct {
+	// UserData is the cloud-init user-data, typically a
+	// "#cloud-config" YAML document or a shell script.
+	UserData string
+	// MetaData is the cloud-init meta-data. It defaults to an
+	// instance-id and local-hostname of the VM's name.
+	MetaData string
+}
+
+func (c *CloudInitConfig) toConfig(name string) *config.CloudInit {
+	if c == nil {
+		return nil
+	}
+	ret := &config.CloudInit{
+		UserData: c.UserData,
+		MetaData: c.MetaData,
+	}
+	if ret.MetaData == "" {
+		ret.MetaData = fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", name, name)
+	}
+	return ret
+}
+
+// CustomizeInstallCloudInit is a build customization function that
+// installs cloud-init, as required by VMConfig.CloudInit.
+func CustomizeInstallCloudInit(v *VM) error {
+	if _, err := v.Run("mkdir -p /etc/cloud/cloud.cfg.d"); err != nil {
+		return err
+	}
+	if err := v.WriteFile("/etc/cloud/cloud.cfg.d/90_virtuakube.cfg", []byte(cloudInitCfg)); err != nil {
+		return err
+	}
+	if err := v.aptGet("update", "install --no-install-recommends -o Dpkg::Options::=--force-confold cloud-init"); err != nil {
+		return err
+	}
+	// Every VM cut from the image must look like a new instance to
+	// cloud-init.
+	_, err := v.Run("rm -rf /var/lib/cloud")
+	return err
+}
+
+// writeSeedISO writes a NoCloud seed ISO for ci to path.
+func writeSeedISO(path string, ci *config.CloudInit) error {
+	tmp, err := ioutil.TempDir(filepath.Dir(path), "seed")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := ioutil.WriteFile(filepath.Join(tmp, "user-data"), []byte(ci.UserData), 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "meta-data"), []byte(ci.MetaData), 0600); err != nil {
+		return err
+	}
+
+	// cloud-init finds the seed by its "cidata" volume label.
+	cmd := exec.Command("genisoimage", "-output", path, "-volid", "cidata", "-joliet", "-rock", "user-data", "meta-data")
+	cmd.Dir = tmp
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("creating cloud-init seed: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// waitCloudInit waits for cloud-init to finish applying the VM's
+// configuration, and returns an error if any of it failed.
+func (v *VM) waitCloudInit() error {
+	if _, err := v.Run("command -v cloud-init"); err != nil {
+		return errors.New("VM has cloud-init configuration, but its image doesn't have cloud-init")
+	}
+	for {
+		if _, err := v.Run("test -e /var/lib/cloud/instance/boot-finished"); err == nil {
+			break
+		}
+		select {
+		case <-v.stopped:
+			return errors.New("VM stopped before cloud-init finished")
+		case <-time.After(time.Second):
+		}
+	}
+
+	out, err := v.ReadFile("/run/cloud-init/result.json")
+	if err != nil {
+		return fmt.Errorf("reading cloud-init result: %v", err)
+	}
+	var result struct {
+		V1 struct {
+			Errors []string `json:"errors"`
+		} `json:"v1"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fmt.Errorf("parsing cloud-init result: %v", err)
+	}
+	if len(result.V1.Errors) > 0 {
+		return fmt.Errorf("cloud-init failed: %s", strings.Join(result.V1.Errors, "; "))
+	}
+	return nil
+}
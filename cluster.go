@@ -9,8 +9,6 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -39,16 +37,219 @@ type ClusterConfig struct {
 	Name string
 	// NumNodes is the number of Kubernetes worker nodes to run.
 	NumNodes int
+	// KubernetesVersion is the version of Kubernetes to run, e.g.
+	// "1.14.3". Nodes install and pin that version of the kubelet,
+	// kubeadm and kubectl before bring-up, unless their image
+	// already has it: from pkgs.k8s.io for 1.24 and later, and from
+	// the release binaries for older versions, which pkgs.k8s.io
+	// doesn't have. Defaults to 1.14.0. Versions 1.13 and later are
+	// supported, up to 1.23 with the Docker runtime, and the bundled
+	// CNIs and metrics-server only work up to 1.15.
+	KubernetesVersion string
+	// ControlPlaneNodes is the number of control plane nodes to
+	// run. If more than one, the cluster is highly available: the
+	// control planes run stacked etcd members, and sit behind an
+	// haproxy load balancer VM that's the cluster's API server
+	// endpoint. Use an odd number to keep etcd quorum through
+	// failures. Zero means one.
+	ControlPlaneNodes int
 	// The VMConfig template to use when creating cluster VMs. The
 	// first configured VM network will be used for Kubernetes control
 	// traffic.
 	VMConfig *VMConfig
+	// CNI is the network addon to install once the cluster is up,
+	// one of the CNI* constants. If empty or CNINone, no network
+	// addon is installed and nodes stay NotReady until you apply
+	// one yourself. The addon's pod MTU is derived from the MTU of
+	// the cluster network.
+	CNI string
+	// CNIManifest is a network addon manifest to apply once the
+	// cluster is up, instead of one of the CNI* addons. It must
+	// configure its own pod MTU, and can't be combined with CNI.
+	CNIManifest []byte
+	// CNIOptions tunes the CNI addon's dataplane. Unknown keys are
+	// an error. Calico understands:
+	//
+	//   encapsulation: "ipip" (the default), "cross-subnet" (only
+	//   encapsulate between subnets) or "none" (plain BGP
+	//   routing). The bundled Calico predates VXLAN support.
+	//
+	// Flannel understands:
+	//
+	//   backend: "vxlan" (the default) or "host-gw" (route over
+	//   the cluster network without encapsulation).
+	//
+	// Weave has no options. The pod MTU accounts for the chosen
+	// encapsulation.
+	CNIOptions map[string]string
+	// InstallMetricsServer installs metrics-server once the cluster
+	// is up, so that `kubectl top`, the HPA, and TopNodes/TopPods
+	// work. Requires a CNI.
+	InstallMetricsServer bool
+	// MinReadyNodes is the minimum number of worker nodes that must
+	// successfully join for Start to succeed. Zero means all
+	// NumNodes must join. Nodes that fail to join are reported by
+	// FailedNodes, and are left running for inspection unless
+	// DeleteFailedNodes is set.
+	MinReadyNodes int
+	// DeleteFailedNodes destroys the VMs of worker nodes that fail
+	// to join, instead of leaving them in the universe.
+	DeleteFailedNodes bool
+	// FakeCloud runs the cluster with an external cloud provider,
+	// simulated by virtuakube. See FakeCloud for details.
+	FakeCloud bool
+	// CoreDNSConfig is a Corefile fragment of extra server blocks
+	// (stub domains, rewrites...) to add to the cluster's CoreDNS
+	// configuration, e.g.:
+	//
+	//   example.internal {
+	//       forward . 10.248.1.5
+	//   }
+	//
+	// If empty, kubeadm's stock CoreDNS configuration is used.
+	CoreDNSConfig string
+	// KubeContextName is the name of the cluster's context, cluster
+	// and user in its kubeconfig. It defaults to the cluster name
+	// plus a universe ID, so that kubeconfigs from different
+	// universes can be merged.
+	KubeContextName string
+	// ContainerdConfigSnippet is TOML merged into each node's
+	// containerd config.toml before the node joins the cluster, e.g.
+	// to add runtime handlers for gVisor or Kata. Tables are merged,
+	// other values replace the stock ones. It requires Runtime to be
+	// RuntimeContainerd.
+	ContainerdConfigSnippet string
+	// RuntimeClasses maps RuntimeClass names to the containerd
+	// runtime handlers they select, e.g. "gvisor": "runsc". The
+	// RuntimeClasses are created once the cluster is up.
+	RuntimeClasses map[string]string
+	// KubeletConfig tunes eviction and resource reservations on all
+	// of the cluster's kubelets.
+	KubeletConfig *KubeletConfig
+	// KubeadmConfigPatches are YAML documents merged into the config
+	// that virtuakube gives kubeadm, for settings it has no option
+	// for, e.g.:
+	//
+	//   kind: ClusterConfiguration
+	//   apiServer:
+	//     extraArgs:
+	//       feature-gates: "EphemeralContainers=true"
+	//       enable-admission-plugins: "NodeRestriction,PodSecurityPolicy"
+	//   ---
+	//   kind: KubeProxyConfiguration
+	//   mode: ipvs
+	//
+	// Each document patches the config document of its kind:
+	// InitConfiguration, ClusterConfiguration, JoinConfiguration,
+	// KubeletConfiguration or KubeProxyConfiguration. Maps are
+	// merged, other values (including lists) replace virtuakube's.
+	// apiVersion can be left out, and must match the cluster's
+	// Kubernetes version if given.
+	KubeadmConfigPatches string
+	// SecretEncryption enables encryption at rest of Secrets in
+	// etcd. If nil, secrets are stored in plaintext (kubeadm's
+	// default).
+	SecretEncryption *SecretEncryption
+	// Registries are the names of universe registries that the
+	// cluster's nodes pull from over plain HTTP. They must be on
+	// the cluster network.
+	Registries []string
+	// IPFamily is the IP family of pod and service networking, one
+	// of the IPFamily* constants. Defaults to IPFamilyIPv4. The
+	// bundled CNIs are IPv4-only, so other families need a
+	// CNIManifest that supports them.
+	IPFamily string
+	// Runtime is the container runtime of the cluster's nodes, one
+	// of the Runtime* constants. Defaults to RuntimeDocker. Nodes
+	// install it before bring-up, unless their image already has
+	// it, see ImageConfig.Runtime.
+	Runtime string
+	// NodeParallelism is how many worker nodes Start brings up at
+	// once. Zero means all of them. Nodes boot and install
+	// Kubernetes while the control plane comes up, and join once
+	// it's ready.
+	NodeParallelism int
+	// ControlPlaneSize sizes the control plane VMs, instead of
+	// VMConfig.
+	ControlPlaneSize VMSize
+	// NodeSizes sizes worker nodes individually, e.g. to test how
+	// the scheduler packs pods onto uneven nodes: the i-th worker
+	// gets NodeSizes[i] instead of VMConfig's size. Workers past the
+	// end of the list get VMConfig's.
+	NodeSizes []VMSize
+	// NodeRoles labels and taints worker nodes individually, e.g. to
+	// give scheduler tests dedicated or tainted nodes: the i-th
+	// worker gets NodeRoles[i]. Workers past the end of the list
+	// get neither. Repeat a role for a group of nodes.
+	NodeRoles []NodeRole
+	// NodeDevices passes host devices through to worker nodes
+	// individually, e.g. to test GPU device plugins and scheduling:
+	// the i-th worker gets NodeDevices[i]. Workers past the end of
+	// the list get none.
+	NodeDevices [][]DeviceConfig
+	// ConnectPodNetwork routes between the cluster's pods and the
+	// pods of the other clusters that set it on the same cluster
+	// network, e.g. to test multi-cluster services. Connected
+	// clusters get a /16 of the usual pod network each, so that
+	// their pod addresses don't overlap. Pod traffic to other
+	// clusters is masqueraded to the sending node's address. Up to
+	// 16 clusters per universe can connect. Requires IPFamilyIPv4,
+	// and a CNI that allocates pod addresses from the nodes' pod
+	// CIDRs: Calico, Flannel, or a CNIManifest that does.
+	ConnectPodNetwork bool
+	// CA is the cluster's certificate authority, which signs the API
+	// server's serving certificate, the kubelets' client
+	// certificates and the kubeconfig's admin certificate. If nil,
+	// kubeadm generates one.
+	CA *CertificateAuthority
+	// APIServerCertSANs are extra DNS names and IP addresses for the
+	// API server's serving certificate, e.g. to reach the cluster
+	// through a proxy or tunnel.
+	APIServerCertSANs []string
+	// TrustedCAs are PEM-encoded CA certificates that the nodes
+	// trust, in addition to the system CAs, e.g. for registries
+	// with a private CA. See also Cluster.TrustCA.
+	TrustedCAs [][]byte
+	// ExposeServices forwards localhost ports to the cluster's
+	// NodePort and LoadBalancer Services as they come and go, see
+	// Cluster.ServiceURL. LoadBalancer Services need an address,
+	// e.g. from FakeCloud, to be reached at it rather than at their
+	// NodePorts.
+	ExposeServices bool
+}
+
+// VMSize is the CPU and memory sizing of a cluster VM. Zero fields
+// leave the VMConfig template's setting.
+type VMSize struct {
+	CPUs      int
+	MemoryMiB int
+}
+
+// apply returns a copy of cfg resized by s.
+func (s VMSize) apply(cfg *VMConfig) *VMConfig {
+	ret := *cfg
+	if s.CPUs != 0 {
+		ret.CPUs = s.CPUs
+	}
+	if s.MemoryMiB != 0 {
+		ret.MemoryMiB = s.MemoryMiB
+	}
+	return &ret
+}
+
+func (s VMSize) validate() error {
+	if s.CPUs < 0 || s.MemoryMiB < 0 {
+		return errors.New("VM sizes can't be negative")
+	}
+	return nil
 }
 
 // Cluster is a virtual Kubernetes cluster.
 type Cluster struct {
 	mu sync.Mutex
 
+	universe *Universe
+
 	tmpdir string
 
 	cfg *config.Cluster
@@ -59,6 +260,36 @@ type Cluster struct {
 	// Cluster VMs.
 	controller *VM
 	nodes      []*VM
+	// Additional control planes and their load balancer, for HA
+	// clusters.
+	controlPlanes []*VM
+	lb            *VM
+
+	// Join policy for Start.
+	minNodes          int
+	deleteFailedNodes bool
+	parallelism       int
+	// Worker nodes that failed to join during Start.
+	failed map[string]error
+
+	// Extra configuration to install during Start.
+	corednsConfig    string
+	containerdConfig string
+	runtimeClasses   map[string]string
+	kubeletConfig    *KubeletConfig
+	cniRaw           []byte
+	// Roles of worker nodes, by VM name.
+	nodeRoles map[string]NodeRole
+	ca        *CertificateAuthority
+	certSANs  []string
+
+	// Simulated cloud provider, if enabled.
+	cloud *FakeCloud
+	// Forwards to Services, if enabled.
+	services *serviceExposer
+	// Relay of the API server forward to the universe's
+	// KubeconfigHost, if it isn't 127.0.0.1.
+	apiRelay net.Listener
 
 	started bool
 }
@@ -71,9 +302,165 @@ func randomClusterName() string {
 	return fmt.Sprintf("cluster%x", rnd)
 }
 
+// validate checks the parts of cfg that don't depend on the
+// universe it's created in.
+func (cfg *ClusterConfig) validate() error {
+	if cfg.VMConfig == nil {
+		return errors.New("ClusterConfig is missing VMConfig")
+	}
+
+	if len(cfg.VMConfig.Networks) == 0 {
+		return errors.New("ClusterConfig's VMConfig does not specify any networks")
+	}
+	if len(cfg.VMConfig.Addresses) > 0 {
+		return errors.New("ClusterConfig's VMConfig can't pin Addresses, which all of the cluster's VMs would share")
+	}
+
+	if _, ok := cniOverhead[cfg.CNI]; cfg.CNI != "" && cfg.CNI != CNINone && (!ok || cfg.CNI == cniCustom) {
+		return fmt.Errorf("unknown CNI %q", cfg.CNI)
+	}
+
+	if len(cfg.CNIManifest) > 0 && cfg.CNI != "" && cfg.CNI != CNINone {
+		return errors.New("CNIManifest can't be combined with CNI")
+	}
+
+	if len(cfg.CNIOptions) > 0 {
+		if cfg.CNI == "" || cfg.CNI == CNINone {
+			return errors.New("CNIOptions requires a CNI")
+		}
+		if err := validateCNIOptions(cfg.CNI, cfg.CNIOptions); err != nil {
+			return fmt.Errorf("invalid CNIOptions: %v", err)
+		}
+	}
+
+	if err := cfg.validateKubeVersion(); err != nil {
+		return err
+	}
+
+	if err := cfg.validateIPFamily(); err != nil {
+		return err
+	}
+
+	if err := cfg.validateRuntime(); err != nil {
+		return err
+	}
+
+	if err := cfg.validatePodNetwork(); err != nil {
+		return err
+	}
+
+	if cfg.ControlPlaneNodes < 0 {
+		return errors.New("ControlPlaneNodes can't be negative")
+	}
+
+	if err := cfg.ControlPlaneSize.validate(); err != nil {
+		return fmt.Errorf("invalid ControlPlaneSize: %v", err)
+	}
+	if len(cfg.NodeSizes) > cfg.NumNodes {
+		return fmt.Errorf("NodeSizes has %d entries, but the cluster only has %d nodes", len(cfg.NodeSizes), cfg.NumNodes)
+	}
+	for i, s := range cfg.NodeSizes {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("invalid NodeSizes[%d]: %v", i, err)
+		}
+	}
+	if len(cfg.NodeRoles) > cfg.NumNodes {
+		return fmt.Errorf("NodeRoles has %d entries, but the cluster only has %d nodes", len(cfg.NodeRoles), cfg.NumNodes)
+	}
+	for i := range cfg.NodeRoles {
+		if err := cfg.NodeRoles[i].validate(); err != nil {
+			return fmt.Errorf("invalid NodeRoles[%d]: %v", i, err)
+		}
+	}
+
+	if len(cfg.NodeDevices) > cfg.NumNodes {
+		return fmt.Errorf("NodeDevices has %d entries, but the cluster only has %d nodes", len(cfg.NodeDevices), cfg.NumNodes)
+	}
+
+	if cfg.MinReadyNodes < 0 || cfg.MinReadyNodes > cfg.NumNodes {
+		return fmt.Errorf("MinReadyNodes must be between 0 and NumNodes (%d)", cfg.NumNodes)
+	}
+
+	if cfg.NodeParallelism < 0 {
+		return errors.New("NodeParallelism can't be negative")
+	}
+
+	if cfg.InstallMetricsServer && cfg.cni() == "" {
+		return errors.New("InstallMetricsServer requires a CNI, metrics-server can't run without a pod network")
+	}
+
+	if cfg.CoreDNSConfig != "" {
+		if err := validateCorefile(cfg.CoreDNSConfig); err != nil {
+			return fmt.Errorf("invalid CoreDNSConfig: %v", err)
+		}
+	}
+
+	if cfg.ContainerdConfigSnippet != "" {
+		if err := validateContainerdSnippet(cfg.ContainerdConfigSnippet); err != nil {
+			return fmt.Errorf("invalid ContainerdConfigSnippet: %v", err)
+		}
+	}
+
+	if cfg.KubeadmConfigPatches != "" {
+		if _, err := parseKubeadmPatches(cfg.KubeadmConfigPatches); err != nil {
+			return fmt.Errorf("invalid KubeadmConfigPatches: %v", err)
+		}
+	}
+
+	if cfg.KubeletConfig != nil {
+		if err := cfg.KubeletConfig.validate(); err != nil {
+			return fmt.Errorf("invalid KubeletConfig: %v", err)
+		}
+	}
+
+	if cfg.SecretEncryption != nil {
+		if err := cfg.SecretEncryption.validate(); err != nil {
+			return fmt.Errorf("invalid SecretEncryption: %v", err)
+		}
+	}
+
+	if cfg.CA != nil {
+		if err := cfg.CA.validate(); err != nil {
+			return fmt.Errorf("invalid CA: %v", err)
+		}
+	}
+	for _, san := range cfg.APIServerCertSANs {
+		if err := validateSAN(san); err != nil {
+			return err
+		}
+	}
+	if err := validateCACerts(cfg.TrustedCAs); err != nil {
+		return fmt.Errorf("invalid TrustedCAs: %v", err)
+	}
+
+	for name, handler := range cfg.RuntimeClasses {
+		if !runtimeClassNameRe.MatchString(name) {
+			return fmt.Errorf("invalid RuntimeClass name %q", name)
+		}
+		if !runtimeClassNameRe.MatchString(handler) {
+			return fmt.Errorf("invalid handler %q for RuntimeClass %q", handler, name)
+		}
+	}
+
+	return nil
+}
+
+// cni returns the name of the network addon cfg installs, or "" for
+// none.
+func (cfg *ClusterConfig) cni() string {
+	switch {
+	case len(cfg.CNIManifest) > 0:
+		return cniCustom
+	case cfg.CNI == CNINone:
+		return ""
+	default:
+		return cfg.CNI
+	}
+}
+
 // NewCluster creates an unstarted Kubernetes cluster with the given
 // configuration.
-func (u *Universe) NewCluster(cfg *ClusterConfig) (*Cluster, error) {
+func (u *Universe) NewCluster(ctx context.Context, cfg *ClusterConfig) (*Cluster, error) {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
@@ -81,63 +468,188 @@ func (u *Universe) NewCluster(cfg *ClusterConfig) (*Cluster, error) {
 		return nil, errors.New("no ClusterConfig specified")
 	}
 
-	if cfg.VMConfig == nil {
-		return nil, errors.New("ClusterConfig is missing VMConfig")
-	}
-
-	if len(cfg.VMConfig.Networks) == 0 {
-		return nil, errors.New("ClusterConfig's VMConfig does not specify any networks")
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
 
 	if u.clusters[cfg.Name] != nil {
 		return nil, fmt.Errorf("universe already has a cluster named %q", cfg.Name)
 	}
 
+	clusterNet := u.networks[cfg.VMConfig.Networks[0]]
+	if clusterNet == nil {
+		return nil, fmt.Errorf("universe doesn't have a network named %q", cfg.VMConfig.Networks[0])
+	}
+
 	tmp, err := ioutil.TempDir(u.tmpdir, cfg.Name)
 	if err != nil {
 		return nil, fmt.Errorf("creating temporary directory: %v", err)
 	}
 
 	ret := &Cluster{
-		tmpdir: tmp,
+		universe:          u,
+		tmpdir:            tmp,
+		minNodes:          cfg.MinReadyNodes,
+		deleteFailedNodes: cfg.DeleteFailedNodes,
+		parallelism:       cfg.NodeParallelism,
+		corednsConfig:     cfg.CoreDNSConfig,
+		containerdConfig:  cfg.ContainerdConfigSnippet,
+		runtimeClasses:    cfg.RuntimeClasses,
+		kubeletConfig:     cfg.KubeletConfig,
+		cniRaw:            cfg.CNIManifest,
+		nodeRoles:         map[string]NodeRole{},
+		ca:                cfg.CA,
+		certSANs:          cfg.APIServerCertSANs,
+		failed:            map[string]error{},
 		cfg: &config.Cluster{
 			Name:     cfg.Name,
 			NumNodes: cfg.NumNodes,
+			CNI:      cfg.cni(),
+			MTU:      clusterNet.MTU(),
+
+			CNIOptions:        cfg.CNIOptions,
+			KubernetesVersion: cfg.KubernetesVersion,
+
+			MetricsServer:  cfg.InstallMetricsServer,
+			KubeContext:    cfg.KubeContextName,
+			IPFamily:       cfg.IPFamily,
+			Runtime:        cfg.Runtime,
+			KubeadmPatches: cfg.KubeadmConfigPatches,
+			TrustedCAs:     cfg.TrustedCAs,
+			ExposeServices: cfg.ExposeServices,
+
+			NodeTemplate: nodeTemplate(cfg.VMConfig),
 		},
 	}
+	if ret.cfg.KubeContext == "" {
+		ret.cfg.KubeContext = cfg.Name + "-" + u.cfg.ID
+	}
+	if len(cfg.Registries) > 0 {
+		if ret.cfg.Registries, err = u.registryAddrsWithLock(cfg.Registries, clusterNet.cfg.Name); err != nil {
+			return nil, err
+		}
+		// CRI-O has no containerd config, trustRegistries sets it
+		// up instead.
+		if ret.Runtime() != RuntimeCRIO {
+			if ret.containerdConfig, err = withRegistryMirrors(ret.containerdConfig, ret.cfg.Registries); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.ConnectPodNetwork {
+		if ret.cfg.PodNetwork, err = u.allocPodNetworkWithLock(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SecretEncryption != nil {
+		if ret.cfg.SecretEncryption, err = cfg.SecretEncryption.toConfig(); err != nil {
+			return nil, err
+		}
+	}
 
-	controllerCfg := &VMConfig{
+	controllerCfg := cfg.ControlPlaneSize.apply(&VMConfig{
 		Name:      fmt.Sprintf("%s-controller", cfg.Name),
 		Image:     cfg.VMConfig.Image,
 		MemoryMiB: cfg.VMConfig.MemoryMiB,
+		CPUs:      cfg.VMConfig.CPUs,
+		CPUModel:  cfg.VMConfig.CPUModel,
 		Networks:  cfg.VMConfig.Networks,
 		PortForwards: map[int]bool{
 			30000: true,
 			6443:  true,
 		},
-	}
+		Disk:           cfg.VMConfig.Disk,
+		MachineType:    cfg.VMConfig.MachineType,
+		Firmware:       cfg.VMConfig.Firmware,
+		SecureBoot:     cfg.VMConfig.SecureBoot,
+		TPM:            cfg.VMConfig.TPM,
+		NestedVirt:     cfg.VMConfig.NestedVirt,
+		HotplugNICs:    cfg.VMConfig.HotplugNICs,
+		AuthorizedKeys: cfg.VMConfig.AuthorizedKeys,
+		Disks:          cfg.VMConfig.Disks,
+		Mounts:         cfg.VMConfig.Mounts,
+	})
 	for fwd := range cfg.VMConfig.PortForwards {
 		controllerCfg.PortForwards[fwd] = true
 	}
-	ctrl, err := u.newVMWithLock(controllerCfg)
+	ctrl, err := u.newVMWithLock(ctx, controllerCfg)
 	if err != nil {
 		return nil, fmt.Errorf("creating controller VM: %v", err)
 	}
 	ret.controller = ctrl
 
-	for i := 0; i < cfg.NumNodes; i++ {
-		nodeCfg := &VMConfig{
-			Name:         fmt.Sprintf("%s-node%d", cfg.Name, i+1),
+	if cfg.ControlPlaneNodes > 1 {
+		lb, err := u.newVMWithLock(ctx, &VMConfig{
+			Name:         fmt.Sprintf("%s-lb", cfg.Name),
 			Image:        cfg.VMConfig.Image,
-			MemoryMiB:    cfg.VMConfig.MemoryMiB,
+			MemoryMiB:    512,
+			CPUModel:     cfg.VMConfig.CPUModel,
 			Networks:     cfg.VMConfig.Networks,
-			PortForwards: cfg.VMConfig.PortForwards,
+			PortForwards: map[int]bool{6443: true},
+			MachineType:  cfg.VMConfig.MachineType,
+			Firmware:     cfg.VMConfig.Firmware,
+			SecureBoot:   cfg.VMConfig.SecureBoot,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating load balancer VM: %v", err)
 		}
-		node, err := u.newVMWithLock(nodeCfg)
+		ret.lb = lb
+		ret.cfg.LoadBalancer = lb.Hostname()
+
+		for i := 2; i <= cfg.ControlPlaneNodes; i++ {
+			cpCfg := *controllerCfg
+			cpCfg.Name = fmt.Sprintf("%s-controller%d", cfg.Name, i)
+			cp, err := u.newVMWithLock(ctx, &cpCfg)
+			if err != nil {
+				return nil, fmt.Errorf("creating controller VM %d: %v", i, err)
+			}
+			ret.controlPlanes = append(ret.controlPlanes, cp)
+			ret.cfg.ControlPlanes = append(ret.cfg.ControlPlanes, cp.Hostname())
+		}
+	}
+	if cfg.FakeCloud {
+		ret.cfg.Cloud = &config.Cloud{}
+		ret.cloud = newFakeCloud(ret, ret.cfg.Cloud)
+	}
+
+	for i := 0; i < cfg.NumNodes; i++ {
+		nodeCfg := &VMConfig{
+			Name:           fmt.Sprintf("%s-node%d", cfg.Name, i+1),
+			Image:          cfg.VMConfig.Image,
+			MemoryMiB:      cfg.VMConfig.MemoryMiB,
+			CPUs:           cfg.VMConfig.CPUs,
+			CPUModel:       cfg.VMConfig.CPUModel,
+			Networks:       cfg.VMConfig.Networks,
+			PortForwards:   cfg.VMConfig.PortForwards,
+			Disk:           cfg.VMConfig.Disk,
+			MachineType:    cfg.VMConfig.MachineType,
+			Firmware:       cfg.VMConfig.Firmware,
+			SecureBoot:     cfg.VMConfig.SecureBoot,
+			TPM:            cfg.VMConfig.TPM,
+			NestedVirt:     cfg.VMConfig.NestedVirt,
+			HotplugNICs:    cfg.VMConfig.HotplugNICs,
+			AuthorizedKeys: cfg.VMConfig.AuthorizedKeys,
+			Disks:          cfg.VMConfig.Disks,
+			Mounts:         cfg.VMConfig.Mounts,
+		}
+		if i < len(cfg.NodeSizes) {
+			nodeCfg = cfg.NodeSizes[i].apply(nodeCfg)
+		}
+		if i < len(cfg.NodeDevices) {
+			nodeCfg.Devices = cfg.NodeDevices[i]
+		}
+		node, err := u.newVMWithLock(ctx, nodeCfg)
 		if err != nil {
 			return nil, fmt.Errorf("creating node %d: %v", i+1, err)
 		}
 		ret.nodes = append(ret.nodes, node)
+		ret.cfg.Nodes = append(ret.cfg.Nodes, node.Hostname())
+		if i < len(cfg.NodeRoles) {
+			ret.nodeRoles[node.Hostname()] = cfg.NodeRoles[i]
+		}
+	}
+	if ret.minNodes == 0 {
+		ret.minNodes = cfg.NumNodes
 	}
 
 	u.clusters[cfg.Name] = ret
@@ -151,52 +663,190 @@ func (u *Universe) resumeCluster(cfg *config.Cluster) error {
 	}
 
 	ret := &Cluster{
+		universe:   u,
 		tmpdir:     tmp,
 		cfg:        cfg,
 		controller: u.vms[fmt.Sprintf("%s-controller", cfg.Name)],
+		failed:     map[string]error{},
 		started:    true,
 	}
-	for i := 0; i < ret.cfg.NumNodes; i++ {
-		ret.nodes = append(ret.nodes, u.vms[fmt.Sprintf("%s-node%d", cfg.Name, i+1)])
+	// Clusters saved before node names were recorded always had
+	// contiguously numbered nodes.
+	if len(cfg.Nodes) == 0 {
+		for i := 0; i < cfg.NumNodes; i++ {
+			cfg.Nodes = append(cfg.Nodes, fmt.Sprintf("%s-node%d", cfg.Name, i+1))
+		}
+	}
+	for _, name := range cfg.Nodes {
+		ret.nodes = append(ret.nodes, u.vms[name])
+	}
+	for _, name := range cfg.ControlPlanes {
+		ret.controlPlanes = append(ret.controlPlanes, u.vms[name])
+	}
+	if cfg.LoadBalancer != "" {
+		ret.lb = u.vms[cfg.LoadBalancer]
 	}
 
 	if err := ret.mkKubeClient(); err != nil {
 		return err
 	}
+	if cfg.KubeContext == "" {
+		kcfg, err := clientcmd.Load(cfg.Kubeconfig)
+		if err != nil {
+			return fmt.Errorf("parsing kubeconfig: %v", err)
+		}
+		cfg.KubeContext = kcfg.CurrentContext
+	}
+
+	if cfg.Cloud != nil {
+		ret.cloud = newFakeCloud(ret, cfg.Cloud)
+		go ret.cloud.run()
+	}
+	if cfg.ExposeServices {
+		ret.services = newServiceExposer(ret)
+		go ret.services.run()
+	}
+
+	// The guests' routes were saved with them.
+	if cfg.PodNetwork != "" {
+		u.podPeers[cfg.Name] = &podPeer{
+			network: ret.controller.Networks()[0],
+			vms:     ret.podVMsWithLock(),
+			routes:  cfg.PodRoutes,
+		}
+	}
 
 	u.clusters[cfg.Name] = ret
 	return nil
 }
 
 // Start starts the virtual cluster and waits for it to finish
-// initializing.
-func (c *Cluster) Start() error {
+// initializing. If ctx is canceled first, the cluster's VMs are
+// killed and closed, and Start returns ctx.Err(). The cluster can't
+// be used after that.
+func (c *Cluster) Start(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Killing the cluster's VMs makes whatever step of setup is in
+	// progress fail.
+	vms := c.vmsWithLock()
+	stop := context.AfterFunc(ctx, func() {
+		for _, vm := range vms {
+			vm.abort()
+		}
+	})
+	err := c.startWithLock(ctx)
+	if !stop() {
+		for _, vm := range vms {
+			vm.Close()
+		}
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.relayAPIServer(); err != nil {
+		return err
+	}
+	return c.universe.mergeKubeconfig(c)
+}
+
+func (c *Cluster) startWithLock(ctx context.Context) error {
 	if c.started {
 		return errors.New("already started")
 	}
 	c.started = true
+	start := time.Now()
+
+	// Worker nodes don't need the control plane until they join, so
+	// they boot and install Kubernetes in the meantime.
+	prepared := make(chan []error, 1)
+	go func() {
+		prog := c.universe.progress(c.Name(), "preparing nodes", int64(len(c.nodes)))
+		errs := c.forEachNode(ctx, c.nodes, c.prepareNode, prog)
+		prog.done(nil)
+		prepared <- errs
+	}()
+	// Nodes must be left alone before returning, even on failure.
+	var prepErrs []error
+	waitPrepared := func() []error {
+		if prepared != nil {
+			prepErrs = <-prepared
+			prepared = nil
+		}
+		return prepErrs
+	}
+	defer waitPrepared()
 
-	if err := c.startController(); err != nil {
+	if c.lb != nil {
+		prog := c.universe.progress(c.Name(), "starting load balancer", 0)
+		if err := prog.done(c.startLoadBalancer(ctx)); err != nil {
+			return err
+		}
+	}
+
+	prog := c.universe.progress(c.Name(), "starting controller", 0)
+	if err := prog.done(c.startController(ctx)); err != nil {
 		return err
 	}
 
+	if len(c.controlPlanes) > 0 {
+		prog = c.universe.progress(c.Name(), "joining control planes", int64(len(c.controlPlanes)))
+		for i, vm := range c.controlPlanes {
+			if err := c.joinControlPlane(ctx, vm); err != nil {
+				return prog.done(fmt.Errorf("joining control plane %s: %v", vm.Hostname(), err))
+			}
+			prog.update(int64(i + 1))
+		}
+		prog.done(nil)
+	}
+
+	var (
+		toJoin []*VM
+		errs   = map[*VM]error{}
+	)
+	for i, err := range waitPrepared() {
+		if err != nil {
+			errs[c.nodes[i]] = err
+		} else {
+			toJoin = append(toJoin, c.nodes[i])
+		}
+	}
+	prog = c.universe.progress(c.Name(), "joining nodes", int64(len(toJoin)))
+	for i, err := range c.forEachNode(ctx, toJoin, c.joinNode, prog) {
+		if err != nil {
+			errs[toJoin[i]] = err
+		}
+	}
+	var joined []*VM
 	for _, node := range c.nodes {
-		// TODO: scatter-gather startup
-		if err := c.startNode(node); err != nil {
+		if err := errs[node]; err != nil {
+			if c.minNodes == len(c.nodes) {
+				return prog.done(err)
+			}
+			c.failed[node.Hostname()] = err
+			continue
+		}
+		joined = append(joined, node)
+	}
+	if len(joined) < c.minNodes {
+		return prog.done(fmt.Errorf("only %d of %d nodes joined the cluster, wanted at least %d: %v", len(joined), len(c.nodes), c.minNodes, c.failedNodesWithLock()))
+	}
+	prog.done(nil)
+	if len(c.failed) > 0 {
+		if err := c.dropFailedNodesWithLock(joined); err != nil {
 			return err
 		}
 	}
 
-	err := c.WaitFor(context.Background(), func() (bool, error) {
+	err := c.WaitFor(ctx, func() (bool, error) {
 		nodes, err := c.client.CoreV1().Nodes().List(metav1.ListOptions{})
 		if err != nil {
 			return false, err
 		}
 
-		if len(nodes.Items) != c.cfg.NumNodes+1 {
+		if len(nodes.Items) != c.cfg.NumNodes+c.numControlPlanes() {
 			return false, nil
 		}
 
@@ -206,9 +856,131 @@ func (c *Cluster) Start() error {
 		return err
 	}
 
+	if len(c.nodeRoles) > 0 {
+		if err := c.labelNodes(); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.PodNetwork != "" {
+		prog := c.universe.progress(c.Name(), "connecting pod network", 0)
+		routes, err := c.connectPodNetwork(ctx, c.podVMsWithLock())
+		if err := prog.done(err); err != nil {
+			return err
+		}
+		c.cfg.PodRoutes = routes
+	}
+
+	if c.cloud != nil {
+		go c.cloud.run()
+		if err := c.WaitFor(ctx, c.cloud.nodesInitialized); err != nil {
+			return fmt.Errorf("initializing nodes in fake cloud: %v", err)
+		}
+	}
+	if c.cfg.ExposeServices {
+		c.services = newServiceExposer(c)
+		go c.services.run()
+	}
+
+	if len(c.runtimeClasses) > 0 {
+		if err := c.installRuntimeClasses(c.runtimeClasses); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.CNI != "" {
+		bs, err := c.networkAddonManifest()
+		if err != nil {
+			return err
+		}
+		prog := c.universe.progress(c.Name(), "installing CNI", 0)
+		if err := prog.done(c.applyManifest(bs)); err != nil {
+			return fmt.Errorf("installing CNI %q: %v", c.cfg.CNI, err)
+		}
+	}
+
+	if c.corednsConfig != "" {
+		prog := c.universe.progress(c.Name(), "configuring CoreDNS", 0)
+		if err := prog.done(c.installCoreDNSConfig(c.corednsConfig)); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.MetricsServer {
+		prog := c.universe.progress(c.Name(), "installing metrics-server", 0)
+		if err := prog.done(c.installMetricsServer()); err != nil {
+			return err
+		}
+	}
+
+	c.universe.timings.recordClusterReady(c.Name(), time.Since(start))
+	c.universe.emitEvent(Event{Kind: EventClusterReady, Target: c.Name(), Duration: time.Since(start)})
+	return nil
+}
+
+// vmsWithLock returns all of the cluster's VMs: control planes, load
+// balancer and worker nodes.
+func (c *Cluster) vmsWithLock() []*VM {
+	ret := append([]*VM{c.controller}, c.controlPlanes...)
+	if c.lb != nil {
+		ret = append(ret, c.lb)
+	}
+	return append(ret, c.nodes...)
+}
+
+// FailedNodes returns the worker nodes that failed to join the
+// cluster during Start, and the reason they failed. It's only
+// non-empty for clusters created with MinReadyNodes.
+func (c *Cluster) FailedNodes() map[string]error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failedNodesWithLock()
+}
+
+func (c *Cluster) failedNodesWithLock() map[string]error {
+	ret := map[string]error{}
+	for name, err := range c.failed {
+		ret[name] = err
+	}
+	return ret
+}
+
+// dropFailedNodesWithLock removes nodes that failed to join from the
+// cluster, leaving only joined.
+func (c *Cluster) dropFailedNodesWithLock(joined []*VM) error {
+	c.nodes = joined
+	c.cfg.NumNodes = len(joined)
+	c.cfg.Nodes = nil
+	for _, node := range joined {
+		c.cfg.Nodes = append(c.cfg.Nodes, node.Hostname())
+	}
+
+	if !c.deleteFailedNodes {
+		return nil
+	}
+	for name := range c.failed {
+		if err := c.universe.destroyVM(name); err != nil {
+			return fmt.Errorf("deleting failed node %q: %v", name, err)
+		}
+	}
 	return nil
 }
 
+// PodMTU returns the MTU that pod interfaces should use, given the
+// cluster network's MTU and the encapsulation overhead of the
+// cluster's CNI.
+func (c *Cluster) PodMTU() int {
+	return podMTU(c.cfg.CNI, c.cfg.CNIOptions, c.linkMTU())
+}
+
+// linkMTU returns the MTU of the cluster's network links.
+func (c *Cluster) linkMTU() int {
+	if c.cfg.MTU == 0 {
+		return DefaultMTU
+	}
+	return c.cfg.MTU
+}
+
 func (c *Cluster) mkKubeClient() error {
 	if err := ioutil.WriteFile(filepath.Join(c.tmpdir, "kubeconfig"), c.cfg.Kubeconfig, 0600); err != nil {
 		return fmt.Errorf("writing kubeconfig to tmpdir: %v", err)
@@ -234,13 +1006,38 @@ func (c *Cluster) mkKubeClient() error {
 
 var addrRe = regexp.MustCompile("https://.*:6443")
 
-func (c *Cluster) startController() error {
-	if err := c.controller.Start(); err != nil {
+func (c *Cluster) startController(ctx context.Context) error {
+	if err := c.controller.Start(ctx); err != nil {
 		return err
 	}
 
+	if err := c.checkOffline(c.controller); err != nil {
+		return err
+	}
+	if err := installRuntime(c.controller, c.Runtime(), c.KubernetesVersion()); err != nil {
+		return err
+	}
+	if c.containerdConfig != "" {
+		if err := c.installContainerdConfig(c.controller, c.containerdConfig); err != nil {
+			return err
+		}
+	}
+	if err := c.trustRegistries(c.controller); err != nil {
+		return err
+	}
+	if err := c.installTrustedCAs(c.controller, c.cfg.TrustedCAs); err != nil {
+		return err
+	}
+	if err := c.setupIPFamily(c.controller); err != nil {
+		return err
+	}
+	if err := installKubernetesVersion(c.controller, c.KubernetesVersion()); err != nil {
+		return err
+	}
+
+	api := kubeadmAPIVersion(c.KubernetesVersion())
 	controllerConfig := fmt.Sprintf(`
-apiVersion: kubeadm.k8s.io/v1beta1
+apiVersion: kubeadm.k8s.io/%s
 kind: InitConfiguration
 bootstrapTokens:
 - token: "000000.0000000000000000"
@@ -249,23 +1046,34 @@ localAPIEndpoint:
   advertiseAddress: %s
 nodeRegistration:
   kubeletExtraArgs:
-    node-ip: %s
+    node-ip: %s%s%s
 ---
-apiVersion: kubeadm.k8s.io/v1beta1
+apiVersion: kubeadm.k8s.io/%s
 kind: ClusterConfiguration
-networking:
-  podSubnet: "10.32.0.0/12"
-kubernetesVersion: "1.14.0"
-clusterName: "virtuakube"
+%s
+kubernetesVersion: %q
+clusterName: "virtuakube"%s
 apiServer:
   certSANs:
-  - "127.0.0.1"
-`, c.controller.IPv4(c.controller.Networks()[0]), c.controller.IPv4(c.controller.Networks()[0]))
+  - "127.0.0.1"%s%s
+%s`, api, c.clusterIP(c.controller), c.nodeIPs(c.controller), c.kubeletCloudArgs(), c.kubeadmCRISocket(), api, c.kubeadmNetworking(), c.KubernetesVersion(), c.controlPlaneEndpoint(), c.extraCertSANs(), c.apiServerEncryptionArgs(), c.kubeletConfig.kubeadmDocument())
+	controllerConfig, err := c.kubeadmConfig(controllerConfig)
+	if err != nil {
+		return err
+	}
 	if err := c.controller.WriteFile("/tmp/k8s.conf", []byte(controllerConfig)); err != nil {
 		return err
 	}
+	if c.cfg.SecretEncryption != nil {
+		if err := c.writeEncryptionConfigTo(c.controller); err != nil {
+			return err
+		}
+	}
+	if err := c.installClusterCA(); err != nil {
+		return fmt.Errorf("installing cluster CA: %v", err)
+	}
 
-	err := c.controller.RunMultiple(
+	err = c.controller.RunMultiple(
 		"kubeadm init --config=/tmp/k8s.conf --ignore-preflight-errors=NumCPU",
 		"KUBECONFIG=/etc/kubernetes/admin.conf kubectl taint nodes --all node-role.kubernetes.io/master-",
 	)
@@ -277,22 +1085,91 @@ apiServer:
 	if err != nil {
 		return err
 	}
-	c.cfg.Kubeconfig = addrRe.ReplaceAll(kubeconfig, []byte("https://127.0.0.1:"+strconv.Itoa(c.controller.ForwardedPort(6443))))
+	kubeconfig = addrRe.ReplaceAll(kubeconfig, []byte("https://127.0.0.1:"+strconv.Itoa(c.apiServerVM().ForwardedPort(6443))))
+	if c.cfg.Kubeconfig, err = renameKubeconfig(kubeconfig, c.cfg.KubeContext); err != nil {
+		return err
+	}
 
 	return c.mkKubeClient()
 }
 
-func (c *Cluster) startNode(node *VM) error {
-	if err := node.Start(); err != nil {
+func (c *Cluster) startNode(ctx context.Context, node *VM) error {
+	if err := c.prepareNode(ctx, node); err != nil {
 		return err
 	}
+	return c.joinNode(ctx, node)
+}
+
+// forEachNode runs do on each of nodes, c.parallelism at a time, and
+// returns the errors in the same order as nodes. prog is updated as
+// nodes finish.
+func (c *Cluster) forEachNode(ctx context.Context, nodes []*VM, do func(context.Context, *VM) error, prog *progress) []error {
+	parallelism := c.parallelism
+	if parallelism <= 0 || parallelism > len(nodes) {
+		parallelism = len(nodes)
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		done  int64
+		errs  = make([]error, len(nodes))
+		slots = make(chan bool, parallelism)
+	)
+	for i, node := range nodes {
+		wg.Add(1)
+		slots <- true
+		go func(i int, node *VM) {
+			defer wg.Done()
+			errs[i] = do(ctx, node)
+			<-slots
+			mu.Lock()
+			done++
+			prog.update(done)
+			mu.Unlock()
+		}(i, node)
+	}
+	wg.Wait()
+	return errs
+}
 
+// prepareNode boots node and installs Kubernetes on it, ready to join
+// the cluster.
+func (c *Cluster) prepareNode(ctx context.Context, node *VM) error {
+	if err := node.Start(ctx); err != nil {
+		return err
+	}
+
+	if err := c.checkOffline(node); err != nil {
+		return err
+	}
+	if err := installRuntime(node, c.Runtime(), c.KubernetesVersion()); err != nil {
+		return err
+	}
+	if c.containerdConfig != "" {
+		if err := c.installContainerdConfig(node, c.containerdConfig); err != nil {
+			return err
+		}
+	}
+	if err := c.trustRegistries(node); err != nil {
+		return err
+	}
+	if err := c.installTrustedCAs(node, c.cfg.TrustedCAs); err != nil {
+		return err
+	}
+	if err := c.setupIPFamily(node); err != nil {
+		return err
+	}
+	return installKubernetesVersion(node, c.KubernetesVersion())
+}
+
+// joinNode joins the prepared node to the cluster.
+func (c *Cluster) joinNode(ctx context.Context, node *VM) error {
 	controllerAddr := &net.TCPAddr{
-		IP:   c.controller.IPv4(c.controller.Networks()[0]),
+		IP:   c.apiServerIP(),
 		Port: 6443,
 	}
 	nodeConfig := fmt.Sprintf(`
-apiVersion: kubeadm.k8s.io/v1beta1
+apiVersion: kubeadm.k8s.io/%s
 kind: JoinConfiguration
 discovery:
   bootstrapToken:
@@ -301,8 +1178,12 @@ discovery:
     apiServerEndpoint: %s
 nodeRegistration:
   kubeletExtraArgs:
-    node-ip: %s
-`, controllerAddr, node.IPv4(node.Networks()[0]))
+    node-ip: %s%s%s%s
+`, kubeadmAPIVersion(c.KubernetesVersion()), controllerAddr, c.nodeIPs(node), c.kubeletCloudArgs(), c.kubeadmCRISocket(), c.kubeadmTaints(node))
+	nodeConfig, err := c.kubeadmConfig(nodeConfig)
+	if err != nil {
+		return err
+	}
 	if err := node.WriteFile("/tmp/k8s.conf", []byte(nodeConfig)); err != nil {
 		return err
 	}
@@ -314,6 +1195,34 @@ nodeRegistration:
 	return nil
 }
 
+// kubeletCloudArgs returns extra kubelet arguments for kubeadm's
+// nodeRegistration, to enable the fake cloud.
+func (c *Cluster) kubeletCloudArgs() string {
+	if c.cfg.Cloud == nil {
+		return ""
+	}
+	return "\n    cloud-provider: external"
+}
+
+// extraCertSANs returns additional API server certificate SANs for
+// kubeadm's ClusterConfiguration.
+func (c *Cluster) extraCertSANs() string {
+	var b strings.Builder
+	if host := c.universe.runtimecfg.KubeconfigHost; host != "" && host != "127.0.0.1" {
+		fmt.Fprintf(&b, "\n  - %q", host)
+	}
+	for _, san := range c.certSANs {
+		fmt.Fprintf(&b, "\n  - %q", san)
+	}
+	return b.String()
+}
+
+// Cloud returns the cluster's simulated cloud provider, or nil if the
+// cluster wasn't created with FakeCloud.
+func (c *Cluster) Cloud() *FakeCloud {
+	return c.cloud
+}
+
 func (c *Cluster) Name() string {
 	return c.cfg.Name
 }
@@ -363,6 +1272,10 @@ func (c *Cluster) ApplyManifest(bs []byte) error {
 		return errors.New("cluster not started yet")
 	}
 
+	return c.applyManifest(bs)
+}
+
+func (c *Cluster) applyManifest(bs []byte) error {
 	deployNames, daemonNames, err := getDeploymentsAndDaemonsets(bs)
 	if err != nil {
 		return err
@@ -436,50 +1349,12 @@ func (c *Cluster) WaitFor(ctx context.Context, test func() (bool, error)) error
 // PushImages extracts the named images from the host's docker daemon,
 // and pushes them to the docker daemons on all nodes in the cluster.
 func (c *Cluster) PushImages(images ...string) error {
-	nodes := append(c.Nodes(), c.Controller())
-	errs := make(chan error, len(nodes)*len(images))
-
 	for _, image := range images {
-		for _, node := range nodes {
-			go func(node *VM, image string) {
-				pr, pw, err := os.Pipe()
-				if err != nil {
-					errs <- err
-					return
-				}
-				defer pr.Close()
-				defer pw.Close()
-
-				pusher := exec.Command("docker", "save", image)
-				pusher.Stdout = pw
-				pusher.Stderr = os.Stderr
-				if err := pusher.Start(); err != nil {
-					errs <- fmt.Errorf("running %q: %v", strings.Join(pusher.Args, " "), err)
-					return
-				}
-				go func() {
-					pusher.Wait()
-					pw.Close()
-				}()
-
-				if _, err := node.RunWithInput("docker load", pr); err != nil {
-					errs <- err
-					return
-				}
-
-				errs <- nil
-			}(node, image)
-		}
-	}
-
-	var retErr error
-	for i := 0; i < len(nodes); i++ {
-		if err := <-errs; err != nil {
-			retErr = err
+		if err := c.LoadImage(image); err != nil {
+			return err
 		}
 	}
-
-	return retErr
+	return nil
 }
 
 // Controller returns the VM for the cluster controller node.
@@ -496,6 +1371,17 @@ func (c *Cluster) Nodes() []*VM {
 	return c.nodes
 }
 
+// members returns all of the cluster's VMs.
+func (c *Cluster) members() []*VM {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := append([]*VM{c.controller}, c.controlPlanes...)
+	if c.lb != nil {
+		ret = append(ret, c.lb)
+	}
+	return append(ret, c.nodes...)
+}
+
 // NodesReady returns true if all nodes in the cluster are in the
 // Ready state.
 func (c *Cluster) NodesReady() (bool, error) {
@@ -504,7 +1390,7 @@ func (c *Cluster) NodesReady() (bool, error) {
 		return false, err
 	}
 
-	if len(nodes.Items) != c.cfg.NumNodes+1 {
+	if len(nodes.Items) != c.cfg.NumNodes+c.numControlPlanes() {
 		return false, nil
 	}
 	for _, node := range nodes.Items {
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var addNodeCmd = &cobra.Command{
+	Use:   "add-node",
+	Short: "Add a worker node to a running cluster",
+	Long: `Create a worker node VM and join it to a cluster.
+
+Unset flags default to the VM template the cluster was created with.
+If the universe is already running in another vkube process, the node
+joins that universe's cluster. Otherwise, the universe is opened, the
+node is added, and the universe is saved.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := addNode(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var addNodeFlags = struct {
+	universe universeFlags
+	cluster  string
+	name     string
+	image    string
+	memory   int
+	cpus     int
+}{}
+
+func init() {
+	rootCmd.AddCommand(addNodeCmd)
+	addUniverseFlags(addNodeCmd, &addNodeFlags.universe, false, true)
+	addNodeCmd.Flags().StringVar(&addNodeFlags.cluster, "cluster", "", "cluster to add the node to")
+	addNodeCmd.Flags().StringVar(&addNodeFlags.name, "name", "", "name for the node (default <cluster>-node<N>)")
+	addNodeCmd.Flags().StringVar(&addNodeFlags.image, "image", "", "base disk image to use (default the cluster's)")
+	addNodeCmd.Flags().IntVar(&addNodeFlags.memory, "memory", 0, "amount of memory to give the node in MiB (default the cluster's)")
+	addNodeCmd.Flags().IntVar(&addNodeFlags.cpus, "cpus", 0, "number of vCPUs to give the node (default the cluster's)")
+	addNodeCmd.MarkFlagRequired("cluster")
+}
+
+func addNode() error {
+	if r, err := virtuakube.Attach(addNodeFlags.universe.dir); err == nil {
+		name, err := r.AddNode(addNodeFlags.cluster, addNodeFlags.name, addNodeFlags.image, addNodeFlags.memory, addNodeFlags.cpus)
+		if err != nil {
+			return fmt.Errorf("Adding node: %v", err)
+		}
+		fmt.Printf("Added node %q to cluster %q\n", name, addNodeFlags.cluster)
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&addNodeFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		cluster := u.Cluster(addNodeFlags.cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", addNodeFlags.cluster)
+		}
+		node, err := cluster.AddNode(ctx, &virtuakube.VMConfig{
+			Name:      addNodeFlags.name,
+			Image:     addNodeFlags.image,
+			MemoryMiB: addNodeFlags.memory,
+			CPUs:      addNodeFlags.cpus,
+		})
+		if err != nil {
+			return fmt.Errorf("Adding node: %v", err)
+		}
+		fmt.Printf("Added node %q to cluster %q\n", node.Hostname(), addNodeFlags.cluster)
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply -f <spec>",
+	Short: "Reconcile a universe with a universe spec file",
+	Long: `Create the images, networks, VMs and clusters described by a YAML
+or TOML universe spec file, which the universe doesn't have yet.
+
+Resources that the universe already has are left alone, except that
+clusters get more worker nodes if the spec has more. With --prune,
+VMs that the spec doesn't describe are destroyed, and clusters lose
+worker nodes the spec doesn't have, newest first. Extra images,
+networks and clusters can't be deleted.
+
+The universe is the one given by --universe, or the spec's dir if
+--universe isn't set. If it's already running in another vkube
+process, that universe is reconciled. Otherwise it's opened, or
+created if it doesn't exist yet, and saved. The whole spec is checked
+against the universe before anything is changed.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := apply(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var applyFlags = struct {
+	universe universeFlags
+	file     string
+	prune    bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	addUniverseFlags(applyCmd, &applyFlags.universe, false, true)
+	// The universe can come from the spec instead.
+	delete(applyCmd.Flags().Lookup("universe").Annotations, cobra.BashCompOneRequiredFlag)
+	applyCmd.Flags().StringVarP(&applyFlags.file, "file", "f", "", "universe spec file to apply")
+	applyCmd.Flags().BoolVar(&applyFlags.prune, "prune", false, "destroy VMs and worker nodes that the spec doesn't describe")
+	applyCmd.MarkFlagRequired("file")
+}
+
+func apply() error {
+	spec, err := virtuakube.ReadSpec(applyFlags.file)
+	if err != nil {
+		return fmt.Errorf("Reading spec: %v", err)
+	}
+	if applyFlags.universe.dir == "" {
+		applyFlags.universe.dir = spec.Dir
+	}
+
+	if r, err := virtuakube.Attach(applyFlags.universe.dir); err == nil {
+		fmt.Printf("Applying %q...\n", applyFlags.file)
+		if err := r.Reconcile(spec, applyFlags.prune); err != nil {
+			return fmt.Errorf("Applying spec: %v", err)
+		}
+		fmt.Printf("Applied %q\n", applyFlags.file)
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&applyFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		fmt.Printf("Applying %q...\n", applyFlags.file)
+		if err := u.Reconcile(ctx, spec, applyFlags.prune); err != nil {
+			return fmt.Errorf("Applying spec: %v", err)
+		}
+		fmt.Printf("Applied %q\n", applyFlags.file)
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var attachNICCmd = &cobra.Command{
+	Use:   "attach-nic <vm> <network>",
+	Short: "Hot-plug a NIC into a running VM",
+	Long: `Hot-plug a new NIC, attached to a universe network, into a running
+VM, and print the NIC's ID for detach-nic.
+
+The VM must have been created with a free hot-plug slot, see newvm's
+--hotplug-nics. If the universe is already running in another vkube
+process, the NIC is plugged into that universe's VM. Otherwise, the
+universe is opened, the NIC is plugged in, and the universe is saved.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := attachNIC(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var attachNICFlags = struct {
+	universe universeFlags
+}{}
+
+func init() {
+	rootCmd.AddCommand(attachNICCmd)
+	addUniverseFlags(attachNICCmd, &attachNICFlags.universe, false, true)
+}
+
+func attachNIC(vm, network string) error {
+	if r, err := virtuakube.Attach(attachNICFlags.universe.dir); err == nil {
+		id, err := r.AttachNIC(vm, network)
+		if err != nil {
+			return fmt.Errorf("Attaching NIC: %v", err)
+		}
+		fmt.Printf("Attached NIC %q to VM %q\n", id, vm)
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&attachNICFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		nic, err := v.AttachNIC(network)
+		if err != nil {
+			return fmt.Errorf("Attaching NIC: %v", err)
+		}
+		fmt.Printf("Attached NIC %q to VM %q\n", nic.ID, vm)
+		return nil
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var chaosCmd = &cobra.Command{
+	Use:   "chaos",
+	Short: "Inject faults into a running universe",
+	Long: `Inject faults into the VMs of a universe that's running in another
+vkube process, to test how workloads and controllers cope with them.
+
+Killed VMs can't be restarted, and a universe with killed VMs can't be
+saved. Disk fills and stress don't prevent saving, but are saved with
+the universe if they're still in effect.`,
+}
+
+var chaosKillCmd = &cobra.Command{
+	Use:   "kill <vm>",
+	Short: "Kill a VM, as if its host lost power",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		withChaosUniverse(func(r *virtuakube.RemoteUniverse) error {
+			return r.KillVM(args[0])
+		})
+	},
+}
+
+var chaosFillDiskCmd = &cobra.Command{
+	Use:   "fill-disk <vm> <percent>",
+	Short: "Fill a VM's root filesystem, 0 to undo",
+	Args:  cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		withChaosUniverse(func(r *virtuakube.RemoteUniverse) error {
+			percent, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("Invalid disk fill percentage %q", args[1])
+			}
+			return r.FillDisk(args[0], percent)
+		})
+	},
+}
+
+var chaosStressCPUCmd = &cobra.Command{
+	Use:   "stress-cpu <vm>",
+	Short: "Keep a VM's CPUs busy",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		withChaosUniverse(func(r *virtuakube.RemoteUniverse) error {
+			return r.StressCPU(args[0], chaosFlags.workers, chaosFlags.duration)
+		})
+	},
+}
+
+var chaosStressMemoryCmd = &cobra.Command{
+	Use:   "stress-memory <vm> <MiB>",
+	Short: "Take memory away from a VM's workloads",
+	Args:  cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		withChaosUniverse(func(r *virtuakube.RemoteUniverse) error {
+			mib, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid memory size %q", args[1])
+			}
+			return r.StressMemory(args[0], mib<<20, chaosFlags.duration)
+		})
+	},
+}
+
+var chaosStopStressCmd = &cobra.Command{
+	Use:   "stop-stress <vm>",
+	Short: "Stop a VM's CPU and memory stress early",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		withChaosUniverse(func(r *virtuakube.RemoteUniverse) error {
+			return r.StopStress(args[0])
+		})
+	},
+}
+
+var chaosKillRandomNodeCmd = &cobra.Command{
+	Use:   "kill-random-node <cluster>",
+	Short: "Kill a random worker node of a cluster",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		withChaosUniverse(func(r *virtuakube.RemoteUniverse) error {
+			node, err := r.KillRandomNode(args[0])
+			if err != nil {
+				return err
+			}
+			return printResult(struct{ Node string }{node}, func() {
+				fmt.Printf("Killed node %q\n", node)
+			})
+		})
+	},
+}
+
+var chaosFlags = struct {
+	dir      string
+	workers  int
+	duration time.Duration
+}{}
+
+func init() {
+	rootCmd.AddCommand(chaosCmd)
+	chaosCmd.AddCommand(chaosKillCmd, chaosFillDiskCmd, chaosStressCPUCmd, chaosStressMemoryCmd, chaosStopStressCmd, chaosKillRandomNodeCmd)
+	chaosCmd.PersistentFlags().StringVarP(&chaosFlags.dir, "universe", "u", "", "directory containing the universe")
+	chaosCmd.MarkPersistentFlagRequired("universe")
+	chaosStressCPUCmd.Flags().IntVar(&chaosFlags.workers, "workers", 0, "number of CPUs to keep busy (default all)")
+	chaosStressCPUCmd.Flags().DurationVar(&chaosFlags.duration, "duration", 0, "how long to stress for (default until stop-stress)")
+	chaosStressMemoryCmd.Flags().DurationVar(&chaosFlags.duration, "duration", 0, "how long to stress for (default until stop-stress)")
+}
+
+// withChaosUniverse attaches to the running universe and calls do,
+// exiting on errors.
+func withChaosUniverse(do func(r *virtuakube.RemoteUniverse) error) {
+	r, err := virtuakube.Attach(chaosFlags.dir)
+	if err == virtuakube.ErrNotRunning {
+		err = fmt.Errorf("Universe %q isn't running, chaos commands need a universe running in another vkube process", chaosFlags.dir)
+	} else if err == nil {
+		err = do(r)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
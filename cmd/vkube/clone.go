@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone <vm> <name>...",
+	Short: "Create copies of a VM",
+	Long: `Create and boot copies of a VM, one for each name.
+
+Each copy's disk is a copy-on-write snapshot of the VM's disk, so
+preparing one VM and cloning it is much faster than creating many VMs
+from a base image. Copies get their own MACs, addresses and SSH host
+keys. Cluster members can't be cloned.
+
+If the universe is already running in another vkube process, the
+copies are created in that universe. Otherwise, the universe is
+opened, the copies are created, and the universe is saved.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := clone(args[0], args[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var cloneFlags = struct {
+	universe universeFlags
+}{}
+
+func init() {
+	rootCmd.AddCommand(cloneCmd)
+	addUniverseFlags(cloneCmd, &cloneFlags.universe, false, true)
+}
+
+func clone(src string, names []string) error {
+	if r, err := virtuakube.Attach(cloneFlags.universe.dir); err == nil {
+		for _, name := range names {
+			name, err := r.CloneVM(src, name)
+			if err != nil {
+				return fmt.Errorf("Cloning VM %q: %v", src, err)
+			}
+			fmt.Printf("Cloned VM %q to %q\n", src, name)
+		}
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&cloneFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		vm := u.VM(src)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", src)
+		}
+		for _, name := range names {
+			cloned, err := u.CloneVM(ctx, vm, name)
+			if err != nil {
+				return fmt.Errorf("Cloning VM %q: %v", src, err)
+			}
+			fmt.Printf("Cloned VM %q to %q\n", src, cloned.Hostname())
+		}
+		return nil
+	})
+}
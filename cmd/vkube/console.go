@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var consoleCmd = &cobra.Command{
+	Use:   "console <vm>",
+	Short: "Attach to a VM's serial console, or show its log",
+	Long: `Attach to the serial console of a VM in a running universe.
+
+The console works even when the VM's network is broken and SSH is
+unreachable. Log in as root/root. Hit ctrl+] to detach, which leaves
+the VM running.
+
+Everything a VM writes to its console, including boot messages and
+kernel panics, is also captured in console-<vm>.log in the universe
+directory. --log prints that log instead of attaching, and works
+whether or not the universe is running. --follow keeps printing the
+log as it grows, like tail -f.
+
+With --host, the universe runs on a remote host (see vkube tunnel),
+and the console or its log is reached over SSH. vkube must be in the
+remote host's PATH.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		run := console
+		if consoleFlags.host != "" {
+			run = remoteConsole
+		} else if consoleFlags.log || consoleFlags.follow {
+			run = consoleLog
+		}
+		if err := run(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var consoleFlags = struct {
+	dir    string
+	log    bool
+	follow bool
+	host   string
+}{}
+
+func init() {
+	rootCmd.AddCommand(consoleCmd)
+	consoleCmd.Flags().StringVarP(&consoleFlags.dir, "universe", "u", "", "directory containing the universe")
+	consoleCmd.Flags().BoolVar(&consoleFlags.log, "log", false, "print the VM's console log instead of attaching")
+	consoleCmd.Flags().BoolVarP(&consoleFlags.follow, "follow", "f", false, "print the console log and keep printing as it grows (implies --log)")
+	consoleCmd.Flags().StringVar(&consoleFlags.host, "host", "", "SSH destination of the remote host running the universe, e.g. user@lab")
+	consoleCmd.MarkFlagRequired("universe")
+}
+
+// consoleEscape is ctrl+], which detaches from the console.
+const consoleEscape = 0x1d
+
+func console(vmName string) error {
+	r, err := virtuakube.Attach(consoleFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+	st, err := r.Status()
+	if err != nil {
+		return fmt.Errorf("Getting universe status: %v", err)
+	}
+
+	sock := ""
+	for _, vm := range st.VMs {
+		if vm.Name == vmName {
+			sock = vm.ConsoleSocket
+		}
+	}
+	if sock == "" {
+		return fmt.Errorf("universe doesn't have a VM named %q", vmName)
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return fmt.Errorf("Connecting to console: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Printf("Connected to console of %q, hit ctrl+] to detach.\r\n", vmName)
+
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		old, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return fmt.Errorf("Setting terminal to raw mode: %v", err)
+		}
+		defer terminal.Restore(int(os.Stdin.Fd()), old)
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(os.Stdout, conn)
+		if err == nil {
+			err = errors.New("console closed by VM")
+		}
+		errs <- err
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if i := bytes.IndexByte(buf[:n], consoleEscape); i != -1 {
+				conn.Write(buf[:i])
+				errs <- nil
+				return
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	err = <-errs
+	fmt.Print("\r\nDetached from console.\r\n")
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// remoteConsole runs vkube console on consoleFlags.host over SSH,
+// with a terminal for attaching. ctrl+] goes through to the remote
+// vkube, and detaches as usual.
+func remoteConsole(vmName string) error {
+	command := "vkube console -u " + shellQuote(consoleFlags.dir)
+	if consoleFlags.log {
+		command += " --log"
+	}
+	if consoleFlags.follow {
+		command += " --follow"
+	}
+	command += " " + shellQuote(vmName)
+
+	var args []string
+	if !consoleFlags.log && !consoleFlags.follow {
+		args = append(args, "-t")
+	}
+	cmd := exec.Command("ssh", append(args, consoleFlags.host, command)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Running console on %s: %v", consoleFlags.host, err)
+	}
+	return nil
+}
+
+// consoleLogPoll is how often consoleLog checks for new console
+// output when following.
+const consoleLogPoll = 200 * time.Millisecond
+
+func consoleLog(vmName string) error {
+	f, err := os.Open(virtuakube.ConsoleLog(consoleFlags.dir, vmName))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("No console log for VM %q, has it ever run?", vmName)
+	} else if err != nil {
+		return fmt.Errorf("Opening console log: %v", err)
+	}
+	defer f.Close()
+
+	for {
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return fmt.Errorf("Reading console log: %v", err)
+		}
+		if !consoleFlags.follow {
+			return nil
+		}
+		time.Sleep(consoleLogPoll)
+	}
+}
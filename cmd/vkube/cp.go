@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <src> <dst>",
+	Short: "Copy files between the host and a VM",
+	Long: `Copy files between the host and a VM, over SFTP. One of src and dst
+is a host path, the other is a VM path of the form
+<universe dir>:<vm>:<path>, e.g.:
+
+  vkube cp ./fixtures.tar ./u:node1:/tmp/fixtures.tar
+  vkube cp -r ./u:node1:/var/log/pods ./logs
+
+If the universe is already running in another vkube process, files
+are copied to or from that universe. Otherwise, the universe is
+opened and the files are copied, then the universe is saved if files
+were copied to the VM, or closed again.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := cp(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var cpFlags = struct {
+	recursive bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(cpCmd)
+	cpCmd.Flags().BoolVarP(&cpFlags.recursive, "recursive", "r", false, "copy directories recursively")
+}
+
+// vmPath is a path on a VM in a universe.
+type vmPath struct {
+	dir, vm, path string
+}
+
+// parseVMPath parses "<universe dir>:<vm>:<path>". It returns nil if s
+// is a host path.
+func parseVMPath(s string) *vmPath {
+	fs := strings.SplitN(s, ":", 3)
+	if len(fs) != 3 || fs[0] == "" || fs[1] == "" || fs[2] == "" {
+		return nil
+	}
+	return &vmPath{fs[0], fs[1], fs[2]}
+}
+
+func cp(src, dst string) error {
+	rsrc, rdst := parseVMPath(src), parseVMPath(dst)
+	switch {
+	case rsrc != nil && rdst != nil:
+		return errors.New("Can't copy between VMs, one of src and dst must be a host path")
+	case rsrc == nil && rdst == nil:
+		return errors.New("One of src and dst must be a VM path, <universe dir>:<vm>:<path>")
+	}
+	push := rdst != nil
+	remote, local := rsrc, dst
+	if push {
+		remote, local = rdst, src
+	}
+
+	if r, err := virtuakube.Attach(remote.dir); err == nil {
+		switch {
+		case push && cpFlags.recursive:
+			err = r.PushDir(remote.vm, local, remote.path)
+		case push:
+			err = r.PushFile(remote.vm, local, remote.path)
+		case cpFlags.recursive:
+			err = r.PullDir(remote.vm, remote.path, local)
+		default:
+			err = r.PullFile(remote.vm, remote.path, local)
+		}
+		if err != nil {
+			return fmt.Errorf("Copying: %v", err)
+		}
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	if _, err := os.Stat(remote.dir); err != nil {
+		return fmt.Errorf("Reading universe: %v", err)
+	}
+	flags := &universeFlags{
+		dir:          remote.dir,
+		acceleration: true,
+		save:         push,
+		grace:        5 * time.Minute,
+	}
+	return runDoWithUniverse(flags, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(remote.vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", remote.vm)
+		}
+		var err error
+		switch {
+		case push && cpFlags.recursive:
+			err = v.PushDir(local, remote.path)
+		case push:
+			err = v.PushFile(local, remote.path)
+		case cpFlags.recursive:
+			err = v.PullDir(remote.path, local)
+		default:
+			err = v.PullFile(remote.path, local)
+		}
+		if err != nil {
+			return fmt.Errorf("Copying: %v", err)
+		}
+		return nil
+	})
+}
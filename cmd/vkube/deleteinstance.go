@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var deleteInstanceCmd = &cobra.Command{
+	Use:   "delete-instance <node>",
+	Short: "Simulate a cloud instance vanishing from under a fake cloud cluster",
+	Long: `Simulate the deletion of a node's instance in a cluster's fake cloud.
+
+The node's VM is destroyed without draining or deleting the Node
+object, and the fake cloud controller then removes the Node, like a
+real cloud-controller-manager would. The universe must be running in
+another vkube process.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := deleteInstance(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var deleteInstanceFlags = struct {
+	dir string
+}{}
+
+func init() {
+	rootCmd.AddCommand(deleteInstanceCmd)
+	deleteInstanceCmd.Flags().StringVarP(&deleteInstanceFlags.dir, "universe", "u", "", "directory containing the universe")
+	deleteInstanceCmd.MarkFlagRequired("universe")
+}
+
+func deleteInstance(node string) error {
+	r, err := virtuakube.Attach(deleteInstanceFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+	return r.DeleteCloudInstance(node)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var detachNICCmd = &cobra.Command{
+	Use:   "detach-nic <vm> <nic>",
+	Short: "Hot-unplug a NIC from a running VM",
+	Long: `Hot-unplug a NIC that attach-nic plugged into a running VM, and wait
+for the guest to release it.
+
+If the universe is already running in another vkube process, the NIC
+is unplugged from that universe's VM. Otherwise, the universe is
+opened, the NIC is unplugged, and the universe is saved.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := detachNIC(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var detachNICFlags = struct {
+	universe universeFlags
+}{}
+
+func init() {
+	rootCmd.AddCommand(detachNICCmd)
+	addUniverseFlags(detachNICCmd, &detachNICFlags.universe, false, true)
+}
+
+func detachNIC(vm, id string) error {
+	if r, err := virtuakube.Attach(detachNICFlags.universe.dir); err == nil {
+		if err := r.DetachNIC(vm, id); err != nil {
+			return fmt.Errorf("Detaching NIC: %v", err)
+		}
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&detachNICFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		if err := v.DetachNIC(id); err != nil {
+			return fmt.Errorf("Detaching NIC: %v", err)
+		}
+		return nil
+	})
+}
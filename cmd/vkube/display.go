@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var displayCmd = &cobra.Command{
+	Use:   "display <vm>",
+	Short: "Show how to connect to a VM's remote display",
+	Long: `Print the address of a VM's VNC or SPICE display, for a universe
+running in another vkube process with --display=vnc or --display=spice.
+This is useful for debugging VMs that don't boot far enough for SSH
+or the serial console, on hosts that can't open windows.
+
+Displays are served on localhost without a password, so reach them
+from other machines through an SSH tunnel. Each VM's display keeps
+its port across runs of the universe when possible.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := display(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var displayFlags = struct {
+	dir string
+}{}
+
+func init() {
+	rootCmd.AddCommand(displayCmd)
+	displayCmd.Flags().StringVarP(&displayFlags.dir, "universe", "u", "", "directory containing the universe")
+	displayCmd.MarkFlagRequired("universe")
+}
+
+func display(vm string) error {
+	r, err := virtuakube.Attach(displayFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+	st, err := r.Status()
+	if err != nil {
+		return fmt.Errorf("Getting universe status: %v", err)
+	}
+	for _, v := range st.VMs {
+		if v.Name != vm {
+			continue
+		}
+		if v.Display == "" {
+			return fmt.Errorf("VM %q has no remote display, resume the universe with --display=vnc or --display=spice", vm)
+		}
+		u, err := url.Parse(v.Display)
+		if err != nil {
+			return fmt.Errorf("Parsing display URL %q: %v", v.Display, err)
+		}
+		return printResult(struct{ URL, Protocol, Addr string }{v.Display, u.Scheme, u.Host}, func() {
+			fmt.Println(v.Display)
+			if u.Scheme == virtuakube.DisplayVNC {
+				fmt.Printf("  e.g. vncviewer %s::%s\n", u.Hostname(), u.Port())
+			} else {
+				fmt.Printf("  e.g. remote-viewer %s\n", v.Display)
+			}
+		})
+	}
+	return fmt.Errorf("Universe doesn't have a VM named %q", vm)
+}
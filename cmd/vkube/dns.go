@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Show or install the DNS stub config of a running universe",
+	Long: `Show the address of a running universe's DNS server, or the resolver
+config that sends queries for the universe zone to it.
+
+The universe must be running with --dns. Its DNS server resolves
+<vm>.universe and <cluster>.universe to the host address of their
+forwarded ports, <vm>.<network>.universe to the VM's address on that
+network, and SRV records _<port>._tcp.<vm>.universe to the localhost
+port that forwards to the VM's port.
+
+With --stub, print configuration for systemd-resolved ("resolved",
+for /etc/systemd/resolved.conf.d) or dnsmasq ("dnsmasq", for
+/etc/dnsmasq.d), or write it to --out. The DNS server keeps its port
+across runs of the universe when possible, so the stub usually only
+needs installing once.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := dns(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var dnsFlags = struct {
+	dir  string
+	stub string
+	out  string
+}{}
+
+func init() {
+	rootCmd.AddCommand(dnsCmd)
+	dnsCmd.Flags().StringVarP(&dnsFlags.dir, "universe", "u", "", "directory containing the universe")
+	dnsCmd.Flags().StringVar(&dnsFlags.stub, "stub", "", "resolver to print a stub config for (resolved or dnsmasq)")
+	dnsCmd.Flags().StringVar(&dnsFlags.out, "out", "", "file to write the stub config to, instead of printing it")
+	dnsCmd.MarkFlagRequired("universe")
+}
+
+func dns() error {
+	r, err := virtuakube.Attach(dnsFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+	st, err := r.Status()
+	if err != nil {
+		return fmt.Errorf("Getting universe status: %v", err)
+	}
+	if st.DNS == "" {
+		return fmt.Errorf("Universe %q isn't serving DNS, resume it with --dns", dnsFlags.dir)
+	}
+
+	if dnsFlags.stub == "" {
+		return printResult(struct{ Addr string }{st.DNS}, func() { fmt.Println(st.DNS) })
+	}
+	stub, err := virtuakube.DNSStub(dnsFlags.stub, st.DNS)
+	if err != nil {
+		return err
+	}
+	if dnsFlags.out == "" {
+		fmt.Print(stub)
+		return nil
+	}
+	if err := ioutil.WriteFile(dnsFlags.out, []byte(stub), 0644); err != nil {
+		return fmt.Errorf("Writing stub config: %v", err)
+	}
+	fmt.Printf("Wrote %s, reload your resolver to use it.\n", dnsFlags.out)
+	return nil
+}
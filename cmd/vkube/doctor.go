@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that the host can run virtuakube universes",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := doctor(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var doctorFlags = struct {
+	dir string
+}{}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringVarP(&doctorFlags.dir, "universe", "u", "", "universe directory to check free disk space for")
+}
+
+func doctor() error {
+	res := struct {
+		Checks []virtuakube.CheckResult
+		// QEMU is nil if no usable QEMU was found.
+		QEMU *virtuakube.QEMUInfo
+	}{Checks: virtuakube.Preflight(doctorFlags.dir)}
+	if qemu, err := virtuakube.DetectQEMU(); err == nil {
+		res.QEMU = qemu
+	}
+
+	err := printResult(res, func() {
+		for _, res := range res.Checks {
+			fmt.Printf("[%s] %s: %s\n", res.Status, res.Name, res.Message)
+		}
+
+		if qemu := res.QEMU; qemu != nil {
+			fmt.Printf("\nQEMU %s (%s)\n", qemu.Version, qemu.Path)
+			fmt.Printf("  machine types: %s\n", strings.Join(qemu.MachineTypes, ", "))
+			for _, c := range []virtuakube.QEMUCapability{virtuakube.CapIOURing, virtuakube.CapHostMTU, virtuakube.CapVirtioFS} {
+				fmt.Printf("  %s: %v\n", c, qemu.Has(c))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, res := range res.Checks {
+		if res.Status == virtuakube.CheckFail {
+			return errors.New("\nSome required checks failed, universes will not work on this host")
+		}
+	}
+	return nil
+}
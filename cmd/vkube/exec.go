@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec <vm> -- <command...>",
+	Short: "Run a command on a VM",
+	Long: `Run a command on a VM.
+
+If the universe is already running in another vkube process, the
+command runs in that universe. Otherwise, the universe is opened,
+the command runs, and the universe is closed again.
+
+With --agent, the command runs through the VM's guest agent instead
+of SSH, so it works when the VM's networking is broken. Agent
+commands have no terminal, and their output only appears once they
+exit. VMs without a guest agent fall back to SSH.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := execute(args[0], strings.Join(args[1:], " ")); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var execFlags = struct {
+	universe universeFlags
+	agent    bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	addUniverseFlags(execCmd, &execFlags.universe, false, false)
+	execCmd.Flags().BoolVar(&execFlags.agent, "agent", false, "run the command through the VM's guest agent rather than SSH")
+}
+
+func execute(vm, command string) error {
+	if r, err := virtuakube.Attach(execFlags.universe.dir); err == nil {
+		run := r.Run
+		if execFlags.agent {
+			run = r.GuestExec
+		}
+		out, err := run(vm, command)
+		os.Stdout.Write(out)
+		return err
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&execFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		var (
+			out []byte
+			err error
+		)
+		if execFlags.agent {
+			out, err = v.GuestExec(ctx, command)
+		} else {
+			out, err = v.Run(command)
+		}
+		os.Stdout.Write(out)
+		return err
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Package a universe snapshot into a portable archive",
+	Long: `Package a universe snapshot (disks, VM memory state and metadata)
+into a single gzipped tarball, which vkube import can unpack elsewhere.
+
+The snapshot given by --snapshot is exported as saved. The universe
+must not be running in another vkube process.`,
+	Args: cobra.NoArgs,
+	Run:  withUniverse(&exportFlags.universe, export),
+}
+
+var exportFlags = struct {
+	universe universeFlags
+	out      string
+}{}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	addUniverseFlags(exportCmd, &exportFlags.universe, false, false)
+	exportCmd.Flags().StringVarP(&exportFlags.out, "file", "f", "", "file to write the archive to")
+	exportCmd.MarkFlagRequired("file")
+}
+
+func export(ctx context.Context, u *virtuakube.Universe) error {
+	f, err := os.Create(exportFlags.out)
+	if err != nil {
+		return fmt.Errorf("Creating archive: %v", err)
+	}
+	fmt.Printf("Exporting universe to %q...\n", exportFlags.out)
+	if err := u.Export(f); err != nil {
+		f.Close()
+		os.Remove(exportFlags.out)
+		return fmt.Errorf("Exporting universe: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Writing archive: %v", err)
+	}
+	fmt.Printf("Exported universe to %q\n", exportFlags.out)
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove files that crashed runs left in a universe",
+	Long: `Remove the disk files that no snapshot, image or VM of a universe uses,
+and the temporary directories and sockets of runs that crashed.
+
+If the universe is running in another vkube process, that process
+collects the garbage, and keeps files that changed in the last 10
+minutes in case they're part of an import in progress.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := gc(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var gcFlags = struct {
+	dir string
+}{}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+	gcCmd.Flags().StringVarP(&gcFlags.dir, "universe", "u", "", "directory containing the universe")
+	gcCmd.MarkFlagRequired("universe")
+}
+
+func gc() error {
+	var (
+		res *virtuakube.GCResult
+		err error
+	)
+	r, err := virtuakube.Attach(gcFlags.dir)
+	switch err {
+	case nil:
+		res, err = r.GC()
+	case virtuakube.ErrNotRunning:
+		res, err = virtuakube.GCSaved(gcFlags.dir)
+	}
+	if err != nil {
+		return fmt.Errorf("Collecting garbage: %v", err)
+	}
+
+	return printResult(res, func() {
+		for _, f := range res.Removed {
+			fmt.Printf("Removed %s\n", f)
+		}
+		fmt.Printf("Freed %s\n", formatBytes(res.Bytes))
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <archive> <dir>",
+	Short: "Unpack a universe archive made by vkube export",
+	Long: `Unpack a universe archive made by vkube export into a new universe
+directory, which must not exist yet. Use vkube resume to run it.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := importUniverse(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+}
+
+func importUniverse(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("Opening archive: %v", err)
+	}
+	defer f.Close()
+	if err := virtuakube.Import(f, dir); err != nil {
+		return fmt.Errorf("Importing universe: %v", err)
+	}
+	fmt.Printf("Imported universe into %q\n", dir)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var loadImageCmd = &cobra.Command{
+	Use:   "load-image <image>...",
+	Short: "Load host container images into a cluster's nodes",
+	Long: `Copy container images from the host's docker (or podman) into
+the container runtime of every node of a cluster, so that pods can
+use locally built images without a registry.
+
+With --archive, the arguments are image tarballs as written by
+docker save, rather than image references. If the universe is already
+running in another vkube process, the images are loaded into that
+universe's cluster. Otherwise, the universe is opened, the images are
+loaded, and the universe is saved.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := loadImage(args); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var loadImageFlags = struct {
+	universe universeFlags
+	cluster  string
+	archive  bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(loadImageCmd)
+	addUniverseFlags(loadImageCmd, &loadImageFlags.universe, false, true)
+	loadImageCmd.Flags().StringVar(&loadImageFlags.cluster, "cluster", "", "cluster to load the images into")
+	loadImageCmd.Flags().BoolVar(&loadImageFlags.archive, "archive", false, "arguments are image tarballs instead of image references")
+	loadImageCmd.MarkFlagRequired("cluster")
+}
+
+func loadImage(images []string) error {
+	if loadImageFlags.archive {
+		for i, img := range images {
+			abs, err := filepath.Abs(img)
+			if err != nil {
+				return err
+			}
+			images[i] = abs
+		}
+	}
+
+	r, err := virtuakube.Attach(loadImageFlags.universe.dir)
+	switch err {
+	case nil:
+		for _, img := range images {
+			if loadImageFlags.archive {
+				err = r.LoadImageArchive(loadImageFlags.cluster, img)
+			} else {
+				err = r.LoadImage(loadImageFlags.cluster, img)
+			}
+			if err != nil {
+				return fmt.Errorf("Loading %q: %v", img, err)
+			}
+			fmt.Printf("Loaded %q into cluster %q\n", img, loadImageFlags.cluster)
+		}
+		return nil
+	case virtuakube.ErrNotRunning:
+	default:
+		return err
+	}
+
+	return runDoWithUniverse(&loadImageFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		cluster := u.Cluster(loadImageFlags.cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", loadImageFlags.cluster)
+		}
+		for _, img := range images {
+			var err error
+			if loadImageFlags.archive {
+				err = cluster.LoadImageArchive(img)
+			} else {
+				err = cluster.LoadImage(img)
+			}
+			if err != nil {
+				return fmt.Errorf("Loading %q: %v", img, err)
+			}
+			fmt.Printf("Loaded %q into cluster %q\n", img, loadImageFlags.cluster)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [dir]",
+	Short: "List the universes in a directory",
+	Long: `List the universes in a directory (the current directory by default),
+and their snapshots. If dir is itself a universe, only it is listed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		if err := ls(dir); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+}
+
+func ls(dir string) error {
+	infos, err := virtuakube.ListUniverses(dir)
+	if err != nil {
+		return fmt.Errorf("Listing universes: %v", err)
+	}
+	if infos == nil {
+		infos = []*virtuakube.UniverseInfo{}
+	}
+	return printResult(infos, func() {
+		if len(infos) == 0 {
+			fmt.Printf("No universes in %q\n", dir)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "DIR\tSNAPSHOT\tVMS\tCLUSTERS\tSTATE")
+		for _, info := range infos {
+			state := "stopped"
+			if info.PID != 0 {
+				state = fmt.Sprintf("running (pid %d)", info.PID)
+			}
+			if len(info.Snapshots) == 0 {
+				fmt.Fprintf(w, "%s\t\t\t\t%s\n", info.Dir, state)
+			}
+			for _, snap := range info.Snapshots {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", info.Dir, snapshotName(snap.Name), len(snap.VMs), len(snap.Clusters), state)
+			}
+		}
+		w.Flush()
+	})
+}
+
+// snapshotName returns a printable name for the snapshot name.
+func snapshotName(name string) string {
+	if name == "" {
+		return "(default)"
+	}
+	return name
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var netemCmd = &cobra.Command{
+	Use:   "netem <vm> <peer>",
+	Short: "Impair the network link between two VMs",
+	Long: `Add latency, packet loss or a bandwidth cap to the traffic between two
+VMs, in both directions, on all the networks they share.
+
+If the universe is already running in another vkube process, the
+impairment applies immediately to that universe. Otherwise, the
+universe is opened, the impairment is set, and the universe is saved.
+Running netem with no impairment flags restores the link.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := netem(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var netemFlags = struct {
+	universe universeFlags
+	latency  time.Duration
+	jitter   time.Duration
+	loss     float64
+	rate     int64
+}{}
+
+func init() {
+	rootCmd.AddCommand(netemCmd)
+	addUniverseFlags(netemCmd, &netemFlags.universe, false, true)
+	netemCmd.Flags().DurationVar(&netemFlags.latency, "latency", 0, "latency to add in each direction")
+	netemCmd.Flags().DurationVar(&netemFlags.jitter, "jitter", 0, "random variation of the latency")
+	netemCmd.Flags().Float64Var(&netemFlags.loss, "loss", 0, "percentage of packets to drop")
+	netemCmd.Flags().Int64Var(&netemFlags.rate, "rate", 0, "maximum bits per second in each direction (0 for unlimited)")
+}
+
+func netem(a, b string) error {
+	spec := virtuakube.LinkSpec{
+		Latency: netemFlags.latency,
+		Jitter:  netemFlags.jitter,
+		Loss:    netemFlags.loss,
+		Rate:    netemFlags.rate,
+	}
+
+	if r, err := virtuakube.Attach(netemFlags.universe.dir); err == nil {
+		return r.ImpairLink(a, b, spec)
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&netemFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		return u.ImpairLink(a, b, spec)
+	})
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -20,11 +23,44 @@ var clusterFlags = struct {
 	universe   universeFlags
 	name       string
 	nodes      int
+	cps        int
+	version    string
 	image      string
 	memory     int
+	cpus       int
+	cpuModel   string
+	cpCPUs     int
+	cpMemory   int
+	nodeSizes  []string
+	nodeRoles  []string
 	addons     []string
 	networks   []string
 	pushimages []string
+	cni        string
+	cniOpts    map[string]string
+	cniFile    string
+	metrics    bool
+	minNodes   int
+	delFailed  bool
+	fakeCloud  bool
+	expose     bool
+	coredns    string
+	containerd string
+	kubeadm    string
+	runtimes   map[string]string
+	eviction   map[string]string
+	sysRes     map[string]string
+	kubeRes    map[string]string
+	encrypt    string
+	registries []string
+	ipFamily   string
+	runtime    string
+	parallel   int
+	connect    bool
+	caCert     string
+	caKey      string
+	certSANs   []string
+	trustCAs   []string
 }{}
 
 func init() {
@@ -33,33 +69,166 @@ func init() {
 	addVMFlags(newclusterCmd)
 	newclusterCmd.Flags().StringVar(&clusterFlags.name, "name", "", "name for the new cluster")
 	newclusterCmd.Flags().IntVar(&clusterFlags.nodes, "nodes", 1, "number of nodes in the cluster")
+	newclusterCmd.Flags().IntVar(&clusterFlags.cps, "control-planes", 1, "number of control plane nodes, more than one makes the cluster highly available")
+	newclusterCmd.Flags().StringVar(&clusterFlags.version, "kubernetes-version", "", "Kubernetes version to run (default 1.14.0)")
 	newclusterCmd.Flags().StringSliceVar(&clusterFlags.addons, "addons", nil, "addons to install")
 	newclusterCmd.Flags().StringVar(&clusterFlags.image, "image", "", "base disk image to use")
 	newclusterCmd.Flags().IntVar(&clusterFlags.memory, "memory", 1024, "amount of memory to give the VMs in GiB")
+	newclusterCmd.Flags().IntVar(&clusterFlags.cpus, "cpus", 1, "number of vCPUs to give the VMs")
+	newclusterCmd.Flags().StringVar(&clusterFlags.cpuModel, "cpu-model", "", "QEMU CPU model to emulate, e.g. host (default QEMU's)")
+	newclusterCmd.Flags().IntVar(&clusterFlags.cpCPUs, "control-plane-cpus", 0, "number of vCPUs to give control plane VMs (default --cpus)")
+	newclusterCmd.Flags().IntVar(&clusterFlags.cpMemory, "control-plane-memory", 0, "amount of memory to give control plane VMs in MiB (default --memory)")
+	newclusterCmd.Flags().StringSliceVar(&clusterFlags.nodeSizes, "node-sizes", nil, "sizes of the worker nodes in order, as cpus:memoryMiB, either of which can be empty for the default (e.g. 4:8192,:512)")
+	newclusterCmd.Flags().StringArrayVar(&clusterFlags.nodeRoles, "node-role", nil, "labels and taints of the next worker node, as label=value,...;taint=value:effect,... (e.g. node-role.kubernetes.io/infra=;dedicated=infra:NoSchedule), repeat for each node")
 	newclusterCmd.Flags().StringSliceVar(&clusterFlags.networks, "networks", []string{}, "networks to attach the VM to")
 	newclusterCmd.Flags().StringSliceVar(&clusterFlags.pushimages, "pushimages", []string{}, "docker images to push to cluster nodes")
+	newclusterCmd.Flags().StringVar(&clusterFlags.cni, "cni", "", "network addon to install (none, calico, cilium, flannel or weave)")
+	newclusterCmd.Flags().StringVar(&clusterFlags.cniFile, "cni-manifest", "", "file containing a network addon manifest to apply instead of --cni")
+	newclusterCmd.Flags().StringToStringVar(&clusterFlags.cniOpts, "cni-options", nil, "options for the network addon, e.g. encapsulation=none for calico or backend=host-gw for flannel")
+	newclusterCmd.Flags().BoolVar(&clusterFlags.metrics, "metrics-server", false, "install metrics-server")
+	newclusterCmd.Flags().IntVar(&clusterFlags.minNodes, "min-nodes", 0, "minimum number of nodes that must join for the cluster to be usable (default all)")
+	newclusterCmd.Flags().BoolVar(&clusterFlags.delFailed, "delete-failed-nodes", false, "delete the VMs of nodes that fail to join")
+	newclusterCmd.Flags().BoolVar(&clusterFlags.fakeCloud, "fake-cloud", false, "run the cluster on a simulated cloud provider")
+	newclusterCmd.Flags().BoolVar(&clusterFlags.expose, "expose-services", false, "forward localhost ports to NodePort and LoadBalancer Services, see vkube service-url")
+	newclusterCmd.Flags().StringVar(&clusterFlags.coredns, "coredns-config", "", "file containing extra Corefile server blocks for CoreDNS")
+	newclusterCmd.Flags().StringVar(&clusterFlags.containerd, "containerd-config", "", "file containing TOML to merge into each node's containerd config, requires --runtime containerd")
+	newclusterCmd.Flags().StringVar(&clusterFlags.kubeadm, "kubeadm-patches", "", "file containing YAML documents to merge into the kubeadm config, e.g. a ClusterConfiguration with API server flags")
+	newclusterCmd.Flags().StringToStringVar(&clusterFlags.runtimes, "runtime-classes", nil, "RuntimeClasses to create, as name=handler")
+	newclusterCmd.Flags().StringToStringVar(&clusterFlags.eviction, "eviction-hard", nil, "kubelet hard eviction thresholds, as signal=threshold")
+	newclusterCmd.Flags().StringToStringVar(&clusterFlags.sysRes, "system-reserved", nil, "kubelet system reservations, as resource=quantity")
+	newclusterCmd.Flags().StringToStringVar(&clusterFlags.kubeRes, "kube-reserved", nil, "kubelet Kubernetes daemon reservations, as resource=quantity")
+	newclusterCmd.Flags().StringSliceVar(&clusterFlags.registries, "registries", nil, "universe registries that nodes pull from over plain HTTP")
+	newclusterCmd.Flags().StringVar(&clusterFlags.encrypt, "secret-encryption", "", "encrypt secrets at rest with a random key, using this provider (aescbc or secretbox)")
+	newclusterCmd.Flags().StringVar(&clusterFlags.ipFamily, "ip-family", "", "IP family of pod and service networking (ipv4, dual or ipv6)")
+	newclusterCmd.Flags().StringVar(&clusterFlags.runtime, "runtime", "", "container runtime of the nodes (docker, containerd or cri-o)")
+	newclusterCmd.Flags().IntVar(&clusterFlags.parallel, "node-parallelism", 0, "how many nodes to bring up at once (default all)")
+	newclusterCmd.Flags().StringVar(&clusterFlags.caCert, "ca-cert", "", "file containing a PEM CA certificate to use as the cluster CA, with --ca-key")
+	newclusterCmd.Flags().StringVar(&clusterFlags.caKey, "ca-key", "", "file containing the PEM private key of --ca-cert")
+	newclusterCmd.Flags().StringSliceVar(&clusterFlags.certSANs, "apiserver-cert-sans", nil, "extra DNS names and IPs for the API server certificate")
+	newclusterCmd.Flags().StringSliceVar(&clusterFlags.trustCAs, "trusted-cas", nil, "files containing PEM CA certificates for the nodes to trust")
+	newclusterCmd.Flags().BoolVar(&clusterFlags.connect, "connect-pod-network", false, "route between this cluster's pods and those of other clusters created with this flag on the same network")
 }
 
-func newcluster(u *virtuakube.Universe) error {
+func newcluster(ctx context.Context, u *virtuakube.Universe) error {
 	cfg := &virtuakube.ClusterConfig{
 		Name:     clusterFlags.name,
 		NumNodes: clusterFlags.nodes,
+		CNI:      clusterFlags.cni,
+
+		ControlPlaneNodes:    clusterFlags.cps,
+		KubernetesVersion:    clusterFlags.version,
+		CNIOptions:           clusterFlags.cniOpts,
+		InstallMetricsServer: clusterFlags.metrics,
+		MinReadyNodes:        clusterFlags.minNodes,
+		DeleteFailedNodes:    clusterFlags.delFailed,
+		FakeCloud:            clusterFlags.fakeCloud,
+		ExposeServices:       clusterFlags.expose,
+		Registries:           clusterFlags.registries,
+		IPFamily:             clusterFlags.ipFamily,
+		Runtime:              clusterFlags.runtime,
+		NodeParallelism:      clusterFlags.parallel,
+		ConnectPodNetwork:    clusterFlags.connect,
+		APIServerCertSANs:    clusterFlags.certSANs,
 		VMConfig: &virtuakube.VMConfig{
 			Image:     clusterFlags.image,
 			MemoryMiB: clusterFlags.memory,
+			CPUs:      clusterFlags.cpus,
+			CPUModel:  clusterFlags.cpuModel,
 			Networks:  clusterFlags.networks,
 		},
+		ControlPlaneSize: virtuakube.VMSize{
+			CPUs:      clusterFlags.cpCPUs,
+			MemoryMiB: clusterFlags.cpMemory,
+		},
+	}
+	for _, s := range clusterFlags.nodeSizes {
+		size, err := parseVMSize(s)
+		if err != nil {
+			return err
+		}
+		cfg.NodeSizes = append(cfg.NodeSizes, size)
+	}
+	for _, s := range clusterFlags.nodeRoles {
+		role, err := parseNodeRole(s)
+		if err != nil {
+			return err
+		}
+		cfg.NodeRoles = append(cfg.NodeRoles, role)
+	}
+
+	if clusterFlags.coredns != "" {
+		bs, err := ioutil.ReadFile(clusterFlags.coredns)
+		if err != nil {
+			return fmt.Errorf("Reading CoreDNS config: %v", err)
+		}
+		cfg.CoreDNSConfig = string(bs)
+	}
+
+	if clusterFlags.cniFile != "" {
+		bs, err := ioutil.ReadFile(clusterFlags.cniFile)
+		if err != nil {
+			return fmt.Errorf("Reading CNI manifest: %v", err)
+		}
+		cfg.CNIManifest = bs
+	}
+
+	if clusterFlags.containerd != "" {
+		bs, err := ioutil.ReadFile(clusterFlags.containerd)
+		if err != nil {
+			return fmt.Errorf("Reading containerd config: %v", err)
+		}
+		cfg.ContainerdConfigSnippet = string(bs)
+	}
+	if clusterFlags.kubeadm != "" {
+		bs, err := ioutil.ReadFile(clusterFlags.kubeadm)
+		if err != nil {
+			return fmt.Errorf("Reading kubeadm config patches: %v", err)
+		}
+		cfg.KubeadmConfigPatches = string(bs)
+	}
+	if clusterFlags.caCert != "" || clusterFlags.caKey != "" {
+		if clusterFlags.caCert == "" || clusterFlags.caKey == "" {
+			return errors.New("--ca-cert and --ca-key must be given together")
+		}
+		cert, err := ioutil.ReadFile(clusterFlags.caCert)
+		if err != nil {
+			return fmt.Errorf("Reading CA certificate: %v", err)
+		}
+		key, err := ioutil.ReadFile(clusterFlags.caKey)
+		if err != nil {
+			return fmt.Errorf("Reading CA key: %v", err)
+		}
+		cfg.CA = &virtuakube.CertificateAuthority{Cert: cert, Key: key}
+	}
+	for _, f := range clusterFlags.trustCAs {
+		bs, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("Reading trusted CA: %v", err)
+		}
+		cfg.TrustedCAs = append(cfg.TrustedCAs, bs)
+	}
+	cfg.RuntimeClasses = clusterFlags.runtimes
+	cfg.KubeletConfig = &virtuakube.KubeletConfig{
+		EvictionHard:   clusterFlags.eviction,
+		SystemReserved: clusterFlags.sysRes,
+		KubeReserved:   clusterFlags.kubeRes,
+	}
+	if clusterFlags.encrypt != "" {
+		cfg.SecretEncryption = &virtuakube.SecretEncryption{Provider: clusterFlags.encrypt}
 	}
 
 	fmt.Printf("Creating cluster %q...\n", clusterFlags.name)
 
-	cluster, err := u.NewCluster(cfg)
+	cluster, err := u.NewCluster(ctx, cfg)
 	if err != nil {
 		return fmt.Errorf("Creating cluster: %v", err)
 	}
-	if err = cluster.Start(); err != nil {
+	if err = cluster.Start(ctx); err != nil {
 		return fmt.Errorf("Starting cluster: %v", err)
 	}
+	for node, err := range cluster.FailedNodes() {
+		fmt.Printf("Node %q failed to join: %v\n", node, err)
+	}
 
 	if len(clusterFlags.addons) != 0 {
 		fmt.Printf("Installing addons %s...\n", strings.Join(clusterFlags.addons, ", "))
@@ -86,3 +255,47 @@ func newcluster(u *virtuakube.Universe) error {
 
 	return nil
 }
+
+// parseNodeRole parses a --node-role flag.
+func parseNodeRole(s string) (virtuakube.NodeRole, error) {
+	ret := virtuakube.NodeRole{Labels: map[string]string{}}
+	fs := strings.SplitN(s, ";", 2)
+	for _, label := range strings.Split(fs[0], ",") {
+		if label == "" {
+			continue
+		}
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 {
+			return ret, fmt.Errorf("Invalid node label %q, want label=value", label)
+		}
+		ret.Labels[kv[0]] = kv[1]
+	}
+	if len(fs) == 2 {
+		for _, taint := range strings.Split(fs[1], ",") {
+			if taint != "" {
+				ret.Taints = append(ret.Taints, taint)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// parseVMSize parses a --node-sizes entry.
+func parseVMSize(s string) (virtuakube.VMSize, error) {
+	var ret virtuakube.VMSize
+	fs := strings.Split(s, ":")
+	if len(fs) != 2 {
+		return ret, fmt.Errorf("Invalid node size %q, want cpus:memoryMiB", s)
+	}
+	for i, dst := range []*int{&ret.CPUs, &ret.MemoryMiB} {
+		if fs[i] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(fs[i])
+		if err != nil {
+			return ret, fmt.Errorf("Invalid node size %q: %v", s, err)
+		}
+		*dst = n
+	}
+	return ret, nil
+}
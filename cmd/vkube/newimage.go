@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/ioutil"
 
 	"github.com/spf13/cobra"
 	"go.universe.tf/virtuakube"
@@ -18,9 +22,16 @@ var newimageCmd = &cobra.Command{
 var imageFlags = struct {
 	universe universeFlags
 	name     string
+	arch     string
 	script   string
 	k8s      bool
 	prepull  bool
+	bench    bool
+	cinit    bool
+	runtime  string
+	noCache  bool
+	url      string
+	sha256   string
 }{}
 
 func init() {
@@ -28,18 +39,39 @@ func init() {
 
 	addUniverseFlags(newimageCmd, &imageFlags.universe, false, true)
 	newimageCmd.Flags().StringVar(&imageFlags.name, "name", "", "name of the new disk image")
+	newimageCmd.Flags().StringVar(&imageFlags.arch, "arch", virtuakube.ArchAMD64, "CPU architecture of the disk image, amd64 or arm64")
 	newimageCmd.Flags().StringVar(&imageFlags.script, "script", "", "path to a shell script to customize the disk image")
 	newimageCmd.Flags().BoolVar(&imageFlags.k8s, "install-k8s", true, "install prerequisites for Kubernetes cluster setup")
-	newimageCmd.Flags().BoolVar(&imageFlags.prepull, "prepull-k8s", true, "pre-pull docker images required to run Kubernetes")
+	newimageCmd.Flags().StringVar(&imageFlags.runtime, "runtime", "", "container runtime to install for Kubernetes (docker, containerd or cri-o)")
+	newimageCmd.Flags().BoolVar(&imageFlags.prepull, "prepull-k8s", true, "pre-pull container images required to run Kubernetes")
+	newimageCmd.Flags().BoolVar(&imageFlags.bench, "install-bench-tools", false, "install fio and iperf3 for VM benchmarks")
+	newimageCmd.Flags().StringVar(&imageFlags.url, "url", "", "download a prebuilt amd64 disk image from this URL instead of building one, resuming if interrupted")
+	newimageCmd.Flags().StringVar(&imageFlags.sha256, "sha256", "", "hex SHA-256 that the image downloaded from --url must have")
+	newimageCmd.Flags().BoolVar(&imageFlags.noCache, "no-cache", false, "build the image even if the image cache has it")
+	newimageCmd.Flags().BoolVar(&imageFlags.cinit, "install-cloud-init", false, "install cloud-init for VM customization at boot")
 }
 
-func newimage(u *virtuakube.Universe) error {
+func newimage(ctx context.Context, u *virtuakube.Universe) error {
+	if imageFlags.url != "" {
+		fmt.Printf("Downloading VM base image %q...\n", imageFlags.name)
+		if err := u.ImportImageURL(ctx, imageFlags.name, imageFlags.url, imageFlags.sha256); err != nil {
+			return fmt.Errorf("Downloading image: %v", err)
+		}
+		fmt.Printf("Downloaded VM base image %q\n", imageFlags.name)
+		return nil
+	}
+	if imageFlags.sha256 != "" {
+		return errors.New("--sha256 requires --url")
+	}
 	if imageFlags.prepull && !imageFlags.k8s {
 		return errors.New("Cannot prepull k8s images if I'm not installing k8s")
 	}
 
 	cfg := &virtuakube.ImageConfig{
-		Name: imageFlags.name,
+		Name:    imageFlags.name,
+		Arch:    imageFlags.arch,
+		Runtime: imageFlags.runtime,
+		NoCache: imageFlags.noCache,
 	}
 	if imageFlags.k8s {
 		cfg.CustomizeFuncs = append(cfg.CustomizeFuncs, virtuakube.CustomizeInstallK8s)
@@ -47,8 +79,22 @@ func newimage(u *virtuakube.Universe) error {
 	if imageFlags.prepull {
 		cfg.CustomizeFuncs = append(cfg.CustomizeFuncs, virtuakube.CustomizePreloadK8sImages)
 	}
+	if imageFlags.bench {
+		cfg.CustomizeFuncs = append(cfg.CustomizeFuncs, virtuakube.CustomizeInstallBenchTools)
+	}
+	if imageFlags.cinit {
+		cfg.CustomizeFuncs = append(cfg.CustomizeFuncs, virtuakube.CustomizeInstallCloudInit)
+	}
 	if imageFlags.script != "" {
 		cfg.CustomizeFuncs = append(cfg.CustomizeFuncs, virtuakube.CustomizeScript(imageFlags.script))
+		// Images built with the same script can share a cached
+		// build.
+		bs, err := ioutil.ReadFile(imageFlags.script)
+		if err != nil {
+			return fmt.Errorf("Reading script: %v", err)
+		}
+		sum := sha256.Sum256(bs)
+		cfg.CacheKey = hex.EncodeToString(sum[:])
 	}
 
 	fmt.Printf("Creating VM base image %q...\n", imageFlags.name)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/spf13/cobra"
@@ -17,17 +18,20 @@ var newnetworkCmd = &cobra.Command{
 var networkFlags = struct {
 	universe universeFlags
 	name     string
+	mtu      int
 }{}
 
 func init() {
 	rootCmd.AddCommand(newnetworkCmd)
 	addUniverseFlags(newnetworkCmd, &networkFlags.universe, false, true)
 	newnetworkCmd.Flags().StringVar(&networkFlags.name, "name", "", "name for the VM")
+	newnetworkCmd.Flags().IntVar(&networkFlags.mtu, "mtu", virtuakube.DefaultMTU, "link MTU of the network")
 }
 
-func newnetwork(u *virtuakube.Universe) error {
+func newnetwork(ctx context.Context, u *virtuakube.Universe) error {
 	cfg := &virtuakube.NetworkConfig{
 		Name: networkFlags.name,
+		MTU:  networkFlags.mtu,
 	}
 
 	fmt.Printf("Creating network %q...\n", networkFlags.name)
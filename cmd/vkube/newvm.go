@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"go.universe.tf/virtuakube"
@@ -19,7 +23,29 @@ var vmFlags = struct {
 	image    string
 	name     string
 	memory   int
+	cpus     int
+	cpuModel string
 	networks []string
+	disk     string
+	iops     int
+	bw       int64
+	machine  string
+	tmpfs    bool
+	firmware string
+	secure   bool
+	tpm      bool
+	nested   bool
+	hotplug  int
+	macs     []string
+	ipv4s    []string
+	ipv6s    []string
+	pci      []string
+	mdevs    []string
+	keys     []string
+	userData string
+	metaData string
+	disks    []int
+	mounts   []string
 }{}
 
 func addVMFlags(cmd *cobra.Command) {
@@ -31,24 +57,123 @@ func init() {
 	newvmCmd.Flags().StringVar(&vmFlags.image, "image", "", "base disk image to use")
 	newvmCmd.Flags().StringVar(&vmFlags.name, "name", "", "name for the VM")
 	newvmCmd.Flags().IntVar(&vmFlags.memory, "memory", 1024, "amount of memory to give the VM in GiB")
+	newvmCmd.Flags().IntVar(&vmFlags.cpus, "cpus", 1, "number of vCPUs to give the VM")
+	newvmCmd.Flags().StringVar(&vmFlags.cpuModel, "cpu-model", "", "QEMU CPU model to emulate, e.g. host (default QEMU's)")
 	newvmCmd.Flags().StringSliceVar(&vmFlags.networks, "networks", []string{}, "networks to attach the VM to")
+	newvmCmd.Flags().StringSliceVar(&vmFlags.macs, "mac", nil, "pin the VM's MAC address on a network, as network=mac (repeatable)")
+	newvmCmd.Flags().StringSliceVar(&vmFlags.ipv4s, "ipv4", nil, "pin the VM's IPv4 address on a network, as network=ip (repeatable)")
+	newvmCmd.Flags().StringSliceVar(&vmFlags.ipv6s, "ipv6", nil, "pin the VM's IPv6 address on a network, as network=ip (repeatable)")
+	newvmCmd.Flags().StringVar(&vmFlags.disk, "disk", "", "boot from this externally built disk instead of --image")
+	newvmCmd.Flags().IntVar(&vmFlags.iops, "disk-iops", 0, "limit the VM's disk to this many IOPS (0 for unlimited)")
+	newvmCmd.Flags().Int64Var(&vmFlags.bw, "disk-bandwidth", 0, "limit the VM's disk to this many bytes per second (0 for unlimited)")
+	newvmCmd.Flags().StringVar(&vmFlags.machine, "machine", "", "QEMU machine type to emulate (default q35)")
+	newvmCmd.Flags().BoolVar(&vmFlags.tmpfs, "tmpfs-disk", false, "keep the VM's disk in host RAM (faster, but the universe can't be saved)")
+	newvmCmd.Flags().StringVar(&vmFlags.firmware, "firmware", "bios", "firmware to boot the VM with, bios or uefi")
+	newvmCmd.Flags().BoolVar(&vmFlags.secure, "secure-boot", false, "enable UEFI secure boot (needs --firmware=uefi)")
+	newvmCmd.Flags().BoolVar(&vmFlags.tpm, "tpm", false, "give the VM an emulated TPM 2.0 (needs swtpm)")
+	newvmCmd.Flags().BoolVar(&vmFlags.nested, "nested-virt", false, "let the VM run KVM guests itself (needs nested KVM on the host)")
+	newvmCmd.Flags().IntVar(&vmFlags.hotplug, "hotplug-nics", 0, "number of NICs that attach-nic can hot-plug into the VM at once")
+	newvmCmd.Flags().StringSliceVar(&vmFlags.pci, "pci-device", nil, "pass the host PCI device at this address through to the VM, bound to vfio-pci (repeatable)")
+	newvmCmd.Flags().StringSliceVar(&vmFlags.mdevs, "mdev", nil, "pass the host mediated device (e.g. vGPU) with this UUID through to the VM (repeatable)")
+	newvmCmd.Flags().StringSliceVar(&vmFlags.keys, "authorized-keys", nil, "also let the SSH public keys in this authorized_keys file log in as root (repeatable)")
+	newvmCmd.Flags().IntSliceVar(&vmFlags.disks, "extra-disk", nil, "attach a blank qcow2 disk of this many MiB, with serial vkube<N> (repeatable)")
+	newvmCmd.Flags().StringSliceVar(&vmFlags.mounts, "mount", nil, "share a host directory with the VM, as host-dir:guest-dir[:ro] (repeatable)")
+	newvmCmd.Flags().StringVar(&vmFlags.userData, "user-data", "", "cloud-init user-data file to apply at first boot")
+	newvmCmd.Flags().StringVar(&vmFlags.metaData, "meta-data", "", "cloud-init meta-data file (default sets the instance ID and hostname)")
 }
 
-func newvm(u *virtuakube.Universe) error {
+func newvm(ctx context.Context, u *virtuakube.Universe) error {
 	cfg := &virtuakube.VMConfig{
-		Name:      vmFlags.name,
-		Image:     vmFlags.image,
-		MemoryMiB: vmFlags.memory,
-		Networks:  vmFlags.networks,
+		Name:        vmFlags.name,
+		Image:       vmFlags.image,
+		MemoryMiB:   vmFlags.memory,
+		CPUs:        vmFlags.cpus,
+		CPUModel:    vmFlags.cpuModel,
+		Networks:    vmFlags.networks,
+		MachineType: vmFlags.machine,
+		TmpfsDisk:   vmFlags.tmpfs,
+		Firmware:    vmFlags.firmware,
+		SecureBoot:  vmFlags.secure,
+		TPM:         vmFlags.tpm,
+		NestedVirt:  vmFlags.nested,
+		HotplugNICs: vmFlags.hotplug,
+		Disk: virtuakube.DiskSpec{
+			IOPSLimit:      vmFlags.iops,
+			BandwidthLimit: vmFlags.bw,
+		},
+	}
+
+	addrs, err := pinnedAddresses()
+	if err != nil {
+		return err
+	}
+	cfg.Addresses = addrs
+
+	for i, size := range vmFlags.disks {
+		cfg.Disks = append(cfg.Disks, virtuakube.DiskConfig{
+			SizeMiB: size,
+			Serial:  fmt.Sprintf("vkube%d", i+1),
+		})
+	}
+	for _, addr := range vmFlags.pci {
+		cfg.Devices = append(cfg.Devices, virtuakube.DeviceConfig{PCIAddress: addr})
+	}
+	for _, uuid := range vmFlags.mdevs {
+		cfg.Devices = append(cfg.Devices, virtuakube.DeviceConfig{MdevUUID: uuid})
+	}
+	for _, path := range vmFlags.keys {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Reading authorized keys: %v", err)
+		}
+		for _, line := range strings.Split(string(bs), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				cfg.AuthorizedKeys = append(cfg.AuthorizedKeys, line)
+			}
+		}
+	}
+	for _, m := range vmFlags.mounts {
+		fs := strings.Split(m, ":")
+		if len(fs) < 2 || len(fs) > 3 || (len(fs) == 3 && fs[2] != "ro") {
+			return fmt.Errorf("Invalid mount %q, must be host-dir:guest-dir[:ro]", m)
+		}
+		cfg.Mounts = append(cfg.Mounts, virtuakube.MountConfig{
+			HostPath:  fs[0],
+			GuestPath: fs[1],
+			ReadOnly:  len(fs) == 3,
+		})
+	}
+	if vmFlags.userData != "" || vmFlags.metaData != "" {
+		cfg.CloudInit = &virtuakube.CloudInitConfig{}
+		if vmFlags.userData != "" {
+			bs, err := ioutil.ReadFile(vmFlags.userData)
+			if err != nil {
+				return fmt.Errorf("Reading user-data: %v", err)
+			}
+			cfg.CloudInit.UserData = string(bs)
+		}
+		if vmFlags.metaData != "" {
+			bs, err := ioutil.ReadFile(vmFlags.metaData)
+			if err != nil {
+				return fmt.Errorf("Reading meta-data: %v", err)
+			}
+			cfg.CloudInit.MetaData = string(bs)
+		}
 	}
 
 	fmt.Printf("Creating VM %q...\n", vmFlags.name)
 
-	vm, err := u.NewVM(cfg)
+	var vm *virtuakube.VM
+	if vmFlags.disk != "" {
+		vm, err = u.ImportVM(ctx, vmFlags.disk, cfg)
+	} else {
+		vm, err = u.NewVM(ctx, cfg)
+	}
 	if err != nil {
 		return fmt.Errorf("Creating VM: %v", err)
 	}
-	if err = vm.Start(); err != nil {
+	if err = vm.Start(ctx); err != nil {
 		return fmt.Errorf("Starting VM: %v", err)
 	}
 
@@ -56,3 +181,50 @@ func newvm(u *virtuakube.Universe) error {
 
 	return nil
 }
+
+// pinnedAddresses returns the addresses pinned by --mac, --ipv4 and
+// --ipv6.
+func pinnedAddresses() (map[string]virtuakube.AddressConfig, error) {
+	ret := map[string]virtuakube.AddressConfig{}
+	pin := func(flag string, vals []string, set func(*virtuakube.AddressConfig, string) error) error {
+		for _, val := range vals {
+			i := strings.Index(val, "=")
+			if i < 0 {
+				return fmt.Errorf("Invalid --%s %q, must be network=address", flag, val)
+			}
+			a := ret[val[:i]]
+			if err := set(&a, val[i+1:]); err != nil {
+				return fmt.Errorf("Invalid --%s %q: %v", flag, val, err)
+			}
+			ret[val[:i]] = a
+		}
+		return nil
+	}
+	parseIP := func(ip string) (net.IP, error) {
+		ret := net.ParseIP(ip)
+		if ret == nil {
+			return nil, fmt.Errorf("invalid IP address %q", ip)
+		}
+		return ret, nil
+	}
+
+	if err := pin("mac", vmFlags.macs, func(a *virtuakube.AddressConfig, mac string) error {
+		a.MAC = mac
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := pin("ipv4", vmFlags.ipv4s, func(a *virtuakube.AddressConfig, ip string) (err error) {
+		a.IPv4, err = parseIP(ip)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if err := pin("ipv6", vmFlags.ipv6s, func(a *virtuakube.AddressConfig, ip string) (err error) {
+		a.IPv6, err = parseIP(ip)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// outputFormat is the format that commands print their results in,
+// "text", "json" or "yaml".
+var outputFormat string
+
+// resultOut is where commands print their results. With structured
+// output, os.Stdout is pointed at stderr, so that progress messages
+// and command logs don't get mixed into the JSON or YAML that scripts
+// parse from stdout.
+var resultOut = os.Stdout
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", "format of command results: text, json or yaml")
+	rootCmd.PersistentPreRun = func(_ *cobra.Command, _ []string) {
+		switch outputFormat {
+		case "text":
+		case "json", "yaml":
+			os.Stdout = os.Stderr
+		default:
+			fmt.Printf("Invalid output format %q, must be text, json or yaml\n", outputFormat)
+			os.Exit(1)
+		}
+	}
+}
+
+// structuredOutput returns whether commands print their results as
+// JSON or YAML.
+func structuredOutput() bool {
+	return outputFormat == "json" || outputFormat == "yaml"
+}
+
+// printResult prints v in the structured output format, or calls text
+// to print it for humans.
+func printResult(v interface{}, text func()) error {
+	var (
+		bs  []byte
+		err error
+	)
+	switch outputFormat {
+	case "json":
+		bs, err = json.MarshalIndent(v, "", "  ")
+		bs = append(bs, '\n')
+	case "yaml":
+		bs, err = yaml.Marshal(v)
+	default:
+		text()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Formatting result: %v", err)
+	}
+	_, err = resultOut.Write(bs)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause [vm...]",
+	Short: "Pause VMs of a universe running in another vkube process",
+	Long: `Stop the CPUs of VMs in a running universe, freezing them in place
+until vkube resume --vm. Pausing a whole cluster with --cluster makes its
+API server unreachable. Paused VMs keep their memory, but a saved
+universe always resumes with its VMs running.`,
+	Run: func(_ *cobra.Command, args []string) {
+		if err := pauseOrResume(pauseFlags.dir, pauseFlags.cluster, args, true); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var pauseFlags = struct {
+	dir     string
+	cluster string
+}{}
+
+func init() {
+	rootCmd.AddCommand(pauseCmd)
+	pauseCmd.Flags().StringVarP(&pauseFlags.dir, "universe", "u", "", "directory containing the universe")
+	pauseCmd.Flags().StringVar(&pauseFlags.cluster, "cluster", "", "pause all the VMs of this cluster")
+	pauseCmd.MarkFlagRequired("universe")
+}
+
+// pauseOrResume pauses or resumes the named VMs and cluster of the
+// universe running in dir.
+func pauseOrResume(dir, cluster string, vms []string, pause bool) error {
+	if cluster == "" && len(vms) == 0 {
+		return errors.New("Nothing to do, specify VMs or --cluster")
+	}
+	r, err := virtuakube.Attach(dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+
+	verb := "Resuming"
+	if pause {
+		verb = "Pausing"
+	}
+	if cluster != "" {
+		fmt.Printf("%s cluster %q...\n", verb, cluster)
+		if pause {
+			err = r.PauseCluster(cluster)
+		} else {
+			err = r.ResumeCluster(cluster)
+		}
+		if err != nil {
+			return fmt.Errorf("%s cluster: %v", verb, err)
+		}
+	}
+	for _, vm := range vms {
+		fmt.Printf("%s VM %q...\n", verb, vm)
+		if pause {
+			err = r.Pause(vm)
+		} else {
+			err = r.Resume(vm)
+		}
+		if err != nil {
+			return fmt.Errorf("%s VM: %v", verb, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var prefetchCmd = &cobra.Command{
+	Use:   "prefetch -f <spec>",
+	Short: "Populate the image cache for offline use",
+	Long: `Build and download everything that a YAML universe spec file needs
+into the image cache, so that it can later be applied with --offline,
+without internet access:
+
+  vkube prefetch -f spec.yaml
+  vkube apply -f spec.yaml --offline
+
+Images that clusters use must install Kubernetes with its images
+preloaded. Images imported from local files are left alone.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := prefetch(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var prefetchFlags = struct {
+	file         string
+	imageCache   string
+	acceleration bool
+	verbose      bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(prefetchCmd)
+	prefetchCmd.Flags().StringVarP(&prefetchFlags.file, "file", "f", "", "universe spec file to prefetch for")
+	prefetchCmd.Flags().StringVar(&prefetchFlags.imageCache, "image-cache", "", "directory of images and downloads shared between universes (default ~/.cache/virtuakube)")
+	prefetchCmd.Flags().BoolVar(&prefetchFlags.acceleration, "acceleration", true, "use KVM to accelerate VMs")
+	prefetchCmd.Flags().BoolVarP(&prefetchFlags.verbose, "verbose", "v", false, "show commands being executed under the hood")
+	prefetchCmd.MarkFlagRequired("file")
+}
+
+func prefetch() error {
+	spec, err := virtuakube.ReadSpec(prefetchFlags.file)
+	if err != nil {
+		return fmt.Errorf("Reading spec: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	cfg := &virtuakube.UniverseConfig{
+		Interactive:    true,
+		NoAcceleration: !prefetchFlags.acceleration,
+		ImageCache:     prefetchFlags.imageCache,
+	}
+	if prefetchFlags.verbose {
+		cfg.CommandLog = os.Stdout
+	}
+	fmt.Printf("Prefetching for %q...\n", prefetchFlags.file)
+	if err := virtuakube.Prefetch(ctx, spec, cfg); err != nil {
+		return fmt.Errorf("Prefetching: %v", err)
+	}
+	fmt.Printf("Prefetched for %q\n", prefetchFlags.file)
+	return nil
+}
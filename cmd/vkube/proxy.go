@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Run a SOCKS5 proxy into a universe",
+	Long: `Run a SOCKS5 proxy that gives the host access to all the addresses of
+a universe: VM addresses on universe networks, and Kubernetes
+ClusterIPs and pod IPs through the first cluster's controller. VM
+names, optionally with a .universe suffix, work as hostnames.
+
+If the universe is already running in another vkube process, the
+proxy connects to its VMs. Otherwise, the universe is opened for as
+long as the proxy runs. The proxy runs until ctrl+C. Point clients at
+it, e.g.:
+
+  curl --socks5-hostname 127.0.0.1:1080 http://10.96.0.1
+  kubectl config set-cluster <cluster> --proxy-url=socks5://127.0.0.1:1080`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := proxy(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var proxyFlags = struct {
+	universe universeFlags
+	listen   string
+}{}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+	addUniverseFlags(proxyCmd, &proxyFlags.universe, true, false)
+	proxyCmd.Flags().StringVar(&proxyFlags.listen, "listen", "127.0.0.1:1080", "address to listen on for SOCKS5 clients")
+}
+
+func proxy() error {
+	if r, err := virtuakube.Attach(proxyFlags.universe.dir); err == nil {
+		p, err := r.Proxy(proxyFlags.listen)
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+		fmt.Printf("SOCKS5 proxy listening on %s, hit ctrl+C to stop\n", p.Addr())
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt)
+		<-stop
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&proxyFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		p, err := u.Proxy(proxyFlags.listen)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("SOCKS5 proxy listening on %s\n", p.Addr())
+		return nil
+	})
+}
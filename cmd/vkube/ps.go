@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List universes running on this host",
+	Long: `List universes running on this host, including universes whose vkube
+process died and left VMs running. Use vkube kill to clean those up.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := ps(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var killCmd = &cobra.Command{
+	Use:   "kill <dir>",
+	Short: "Forcibly shut down a running universe",
+	Long: `Forcibly shut down a running universe, discarding any unsaved changes.
+
+This also cleans up the VMs and networks of a universe whose vkube
+process died without shutting them down.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := virtuakube.KillRunning(args[0]); err != nil {
+			fmt.Printf("Killing universe %q: %v\n", args[0], err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(psCmd)
+	rootCmd.AddCommand(killCmd)
+}
+
+func ps() error {
+	running, err := virtuakube.ListRunning()
+	if err != nil {
+		return fmt.Errorf("Listing running universes: %v", err)
+	}
+	if running == nil {
+		running = []virtuakube.RunningUniverse{}
+	}
+	return printResult(running, func() {
+		if len(running) == 0 {
+			fmt.Println("No universes running")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "PID\tDIR\tVMS\tUPTIME\tSTATE")
+		for _, r := range running {
+			vms, state := "?", "running"
+			if r.VMs >= 0 {
+				vms = fmt.Sprint(r.VMs)
+			}
+			if !r.Alive {
+				state = fmt.Sprintf("dead, %d leftover processes", len(r.Orphans))
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.PID, r.Dir, vms, time.Since(r.Started).Truncate(time.Second), state)
+		}
+		w.Flush()
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var rebootCmd = &cobra.Command{
+	Use:   "reboot <vm>...",
+	Short: "Reboot VMs of a universe running in another vkube process",
+	Long: `Reboot VMs of a running universe, and wait for them to come back up.
+By default, the guest OS reboots cleanly. With --hard, the VM is reset
+like a machine whose reset button was pressed, losing the writes the
+guest hadn't flushed to disk.
+
+Rebooted VMs keep their disks, networks and forwarded ports, and
+virtuakube reapplies their addresses, link conditions and mounts. VMs
+that are cluster nodes are named after the node.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := reboot(args); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var rebootFlags = struct {
+	dir  string
+	hard bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(rebootCmd)
+	rebootCmd.Flags().StringVarP(&rebootFlags.dir, "universe", "u", "", "directory containing the universe")
+	rebootCmd.Flags().BoolVar(&rebootFlags.hard, "hard", false, "reset the VMs without shutting down their guest OS")
+	rebootCmd.MarkFlagRequired("universe")
+}
+
+func reboot(vms []string) error {
+	r, err := virtuakube.Attach(rebootFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+	for _, vm := range vms {
+		fmt.Printf("Rebooting VM %q...\n", vm)
+		if err := r.Reboot(vm, rebootFlags.hard); err != nil {
+			return fmt.Errorf("Rebooting VM: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Create a container registry that clusters can pull from",
+	Args:  cobra.NoArgs,
+	Run:   withUniverse(&registryFlags.universe, registry),
+}
+
+var registryFlags = struct {
+	universe universeFlags
+	name     string
+	image    string
+	network  string
+	memory   int
+	push     []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(registryCmd)
+	addUniverseFlags(registryCmd, &registryFlags.universe, true, false)
+	registryCmd.Flags().StringVar(&registryFlags.name, "name", "registry", "name for the registry and its VM")
+	registryCmd.Flags().StringVar(&registryFlags.image, "image", "", "base disk image to use, must have docker")
+	registryCmd.Flags().StringVar(&registryFlags.network, "network", "", "network to serve the registry on")
+	registryCmd.Flags().IntVar(&registryFlags.memory, "memory", 512, "amount of memory to give the registry VM in MiB")
+	registryCmd.Flags().StringSliceVar(&registryFlags.push, "push", nil, "host docker images to push to the registry")
+}
+
+func registry(ctx context.Context, u *virtuakube.Universe) error {
+	fmt.Printf("Creating registry %q...\n", registryFlags.name)
+
+	r, err := u.NewRegistry(&virtuakube.RegistryConfig{
+		Name:      registryFlags.name,
+		Image:     registryFlags.image,
+		Network:   registryFlags.network,
+		MemoryMiB: registryFlags.memory,
+	})
+	if err != nil {
+		return fmt.Errorf("Creating registry: %v", err)
+	}
+
+	for _, img := range registryFlags.push {
+		ref, err := r.Push(img)
+		if err != nil {
+			return fmt.Errorf("Pushing %q: %v", img, err)
+		}
+		fmt.Printf("Pushed %q as %q\n", img, ref)
+	}
+
+	fmt.Printf("Created registry %q: push to %s, clusters pull from %s\n", r.Name(), r.HostAddress(), r.Address())
+
+	return nil
+}
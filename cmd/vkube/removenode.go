@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var removeNodeCmd = &cobra.Command{
+	Use:   "remove-node <node>",
+	Short: "Remove a worker node from a running cluster",
+	Long: `Drain a worker node, delete its Node object, and destroy its VM.
+
+If the universe is already running in another vkube process, the node
+is removed from that universe's cluster. Otherwise, the universe is
+opened, the node is removed, and the universe is saved.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := removeNode(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var removeNodeFlags = struct {
+	universe universeFlags
+	cluster  string
+}{}
+
+func init() {
+	rootCmd.AddCommand(removeNodeCmd)
+	addUniverseFlags(removeNodeCmd, &removeNodeFlags.universe, false, true)
+	removeNodeCmd.Flags().StringVar(&removeNodeFlags.cluster, "cluster", "", "cluster to remove the node from")
+	removeNodeCmd.MarkFlagRequired("cluster")
+}
+
+func removeNode(node string) error {
+	if r, err := virtuakube.Attach(removeNodeFlags.universe.dir); err == nil {
+		if err := r.RemoveNode(removeNodeFlags.cluster, node); err != nil {
+			return fmt.Errorf("Removing node: %v", err)
+		}
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&removeNodeFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		cluster := u.Cluster(removeNodeFlags.cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", removeNodeFlags.cluster)
+		}
+		if err := cluster.RemoveNode(node); err != nil {
+			return fmt.Errorf("Removing node: %v", err)
+		}
+		return nil
+	})
+}
@@ -1,24 +1,48 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+
 	"github.com/spf13/cobra"
 	"go.universe.tf/virtuakube"
 )
 
 var resumeCmd = &cobra.Command{
 	Use:   "resume",
-	Short: "Resume the universe with no other changes",
-	Args:  cobra.NoArgs,
-	Run:   withUniverse(&resumeFlags, resume),
+	Short: "Resume the universe with no other changes, or resume paused VMs",
+	Long: `Open the universe and wait, with no other changes.
+
+With --vm or --cluster, resume VMs or clusters paused by vkube pause
+instead, in a universe running in another vkube process. Resumed VMs
+have their clocks stepped forward to make up for the pause.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(resumeFlags.vms) == 0 && resumeFlags.cluster == "" {
+			withUniverse(&resumeFlags.universe, resume)(cmd, args)
+			return
+		}
+		if err := pauseOrResume(resumeFlags.universe.dir, resumeFlags.cluster, resumeFlags.vms, false); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
 }
 
-var resumeFlags universeFlags
+var resumeFlags = struct {
+	universe universeFlags
+	vms      []string
+	cluster  string
+}{}
 
 func init() {
 	rootCmd.AddCommand(resumeCmd)
-	addUniverseFlags(resumeCmd, &resumeFlags, true, false)
+	addUniverseFlags(resumeCmd, &resumeFlags.universe, true, false)
+	resumeCmd.Flags().StringSliceVar(&resumeFlags.vms, "vm", nil, "resume this paused VM instead of opening the universe (repeatable)")
+	resumeCmd.Flags().StringVar(&resumeFlags.cluster, "cluster", "", "resume all the VMs of this paused cluster instead of opening the universe")
 }
 
-func resume(u *virtuakube.Universe) error {
+func resume(ctx context.Context, u *virtuakube.Universe) error {
 	return nil
 }
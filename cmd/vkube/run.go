@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run -- <command...>",
+	Short: "Run a command against a universe, then tear it down",
+	Long: `Run a command on the host against a universe, then tear it down.
+
+The universe is opened (or created), the command runs with
+KUBECONFIG, VKUBE_UNIVERSE and VKUBE_SSH_PORT_<VM> set in its
+environment, and the universe is closed, discarding all changes. With
+--save-on-error, a universe whose command failed is saved instead, for
+inspection.
+
+The command's exit code becomes vkube's exit code, which makes this
+the natural shape for CI jobs.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		code, err := run(args)
+		if err != nil {
+			fmt.Println(err)
+		}
+		os.Exit(code)
+	},
+}
+
+var runFlags = struct {
+	universe universeFlags
+	test     bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	f := runCmd.Flags()
+	f.StringVarP(&runFlags.universe.dir, "universe", "u", "", "directory containing the universe")
+	f.StringVarP(&runFlags.universe.snapshot, "snapshot", "s", "", "snapshot to resume in the universe")
+	f.BoolVarP(&runFlags.universe.verbose, "verbose", "v", false, "show commands being executed under the hood")
+	f.BoolVar(&runFlags.universe.acceleration, "acceleration", true, "use KVM to accelerate VMs")
+	f.DurationVar(&runFlags.universe.grace, "grace-period", 5*time.Minute, "how long to wait for in-flight operations after ctrl+C, before killing the universe")
+	f.StringVar(&runFlags.universe.saveOnError, "save-on-error", "", "if the command fails, save the universe to this snapshot instead of discarding it")
+	f.BoolVar(&runFlags.test, "test", false, "print a PASS/FAIL summary for the command")
+	runCmd.MarkFlagRequired("universe")
+}
+
+// run runs the command in args against the universe, and returns the
+// exit code vkube should exit with.
+func run(args []string) (int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sd := &shutdown{grace: runFlags.universe.grace, phase: "opening universe"}
+	sd.handle(ctx, cancel)
+
+	u, err := openOrCreateUniverse(ctx, &runFlags.universe)
+	if err != nil {
+		return 1, fmt.Errorf("Getting universe: %v", err)
+	}
+	defer u.Close()
+	sd.setUniverse(u)
+	printEvents(u.Events())
+
+	start := time.Now()
+	code := 0
+	sd.setPhase("running command")
+	err = u.Run(ctx, func(u *virtuakube.Universe) error {
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), u.Env()...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		sd.setPhase("tearing down universe")
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		return err
+	})
+	d := time.Since(start).Truncate(time.Second)
+	summary := func() error {
+		res := struct {
+			Command  []string
+			ExitCode int
+			Duration time.Duration
+		}{args, code, time.Since(start)}
+		return printResult(res, func() {
+			switch {
+			case !runFlags.test:
+			case code != 0:
+				fmt.Printf("FAIL: %q exited with code %d after %s\n", args[0], code, d)
+			default:
+				fmt.Printf("PASS: %q succeeded after %s\n", args[0], d)
+			}
+		})
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		return 130, errors.New("Interrupted")
+	case code != 0:
+		if err := summary(); err != nil {
+			return code, err
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			// Run replaced the command's error with the save's.
+			fmt.Printf("Saving universe: %v\n", err)
+		} else if runFlags.universe.saveOnError != "" {
+			fmt.Printf("Universe saved to snapshot %q for inspection.\n", runFlags.universe.saveOnError)
+		}
+		return code, nil
+	case err != nil:
+		return 1, err
+	}
+
+	return 0, summary()
+}
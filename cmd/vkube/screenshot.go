@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var screenshotCmd = &cobra.Command{
+	Use:   "screenshot <vm>",
+	Short: "Capture a VM's screen to a PNG file",
+	Long: `Capture the screen of a VM in a universe running in another vkube
+process, whatever its display mode, e.g. to see why a VM doesn't come
+up on SSH.
+
+With --record, record the screen to an animated GIF instead, until
+ctrl+C. Recording opens the universe itself, so the universe must not
+be running elsewhere, and the recording is of the VM resuming from a
+snapshot or booting.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := screenshot(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var screenshotFlags = struct {
+	universe universeFlags
+	file     string
+	record   bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(screenshotCmd)
+	addUniverseFlags(screenshotCmd, &screenshotFlags.universe, false, false)
+	screenshotCmd.Flags().StringVarP(&screenshotFlags.file, "file", "f", "", "file to write the screenshot or recording to (default <vm>.png or <vm>.gif)")
+	screenshotCmd.Flags().BoolVar(&screenshotFlags.record, "record", false, "record the screen to an animated GIF until ctrl+C")
+}
+
+func screenshot(vm string) error {
+	if screenshotFlags.record {
+		return recordScreen(vm)
+	}
+
+	file := screenshotFlags.file
+	if file == "" {
+		file = vm + ".png"
+	}
+	if r, err := virtuakube.Attach(screenshotFlags.universe.dir); err == nil {
+		bs, err := r.Screenshot(vm)
+		if err != nil {
+			return fmt.Errorf("Capturing screen: %v", err)
+		}
+		if err := ioutil.WriteFile(file, bs, 0644); err != nil {
+			return fmt.Errorf("Writing screenshot: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", file)
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&screenshotFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		img, err := v.Screenshot()
+		if err != nil {
+			return fmt.Errorf("Capturing screen: %v", err)
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return fmt.Errorf("Writing screenshot: %v", err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, img); err != nil {
+			return fmt.Errorf("Writing screenshot: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", file)
+		return nil
+	})
+}
+
+func recordScreen(vm string) error {
+	file := screenshotFlags.file
+	if file == "" {
+		file = vm + ".gif"
+	}
+	return runDoWithUniverse(&screenshotFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		fmt.Printf("Recording the screen of %q to %s, ctrl+C to stop...\n", vm, file)
+		if err := v.RecordScreen(ctx, file); err != nil {
+			return fmt.Errorf("Recording screen: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", file)
+		return nil
+	})
+}
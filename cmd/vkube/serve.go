@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve an API for driving the universes in a directory",
+	Long: `Run a daemon that opens, creates and drives the universes in a root
+directory on behalf of API clients, such as CI controllers and web
+frontends. The API is served over gRPC on --grpc-addr, see
+api/virtuakube.proto, and as JSON over HTTP on --addr, see the
+documentation of virtuakube.Server. Clients authenticate with the
+token in the root directory's server.token file, e.g.:
+
+  curl -H "Authorization: Bearer $(cat <root>/server.token)" http://127.0.0.1:8470/v1/universes
+
+On ctrl+C, all open universes are closed, and their changes since
+they were last saved through the API are discarded.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := serve(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var serveFlags = struct {
+	root         string
+	addr         string
+	grpcAddr     string
+	verbose      bool
+	acceleration bool
+	imageCache   string
+	noImageCache bool
+	kubeHost     string
+	display      string
+}{}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVarP(&serveFlags.root, "root", "r", "", "directory containing the universes to serve")
+	serveCmd.Flags().StringVar(&serveFlags.addr, "addr", "127.0.0.1:8470", "address to serve the JSON API on")
+	serveCmd.Flags().StringVar(&serveFlags.grpcAddr, "grpc-addr", "127.0.0.1:8471", "address to serve the gRPC API on")
+	serveCmd.Flags().BoolVarP(&serveFlags.verbose, "verbose", "v", false, "show commands being executed under the hood")
+	serveCmd.Flags().BoolVar(&serveFlags.acceleration, "acceleration", true, "use KVM to accelerate VMs")
+	serveCmd.Flags().StringVar(&serveFlags.imageCache, "image-cache", "", "directory of images and downloads shared between universes, on the same filesystem (default ~/.cache/virtuakube)")
+	serveCmd.Flags().BoolVar(&serveFlags.noImageCache, "no-image-cache", false, "don't share images and downloads with other universes")
+	serveCmd.Flags().StringVar(&serveFlags.kubeHost, "kubeconfig-host", "", "IP address of this host that the kubeconfigs exported on save reach the API server at (default 127.0.0.1)")
+	serveCmd.Flags().StringVar(&serveFlags.display, "display", "none", "how VMs show their screen: none, vnc or spice (on localhost ports)")
+	serveCmd.MarkFlagRequired("root")
+}
+
+func serve() error {
+	// The server does its own interrupt handling, so subprocesses
+	// need to be immune to ^C.
+	cfg := &virtuakube.UniverseConfig{
+		Interactive:    true,
+		NoAcceleration: !serveFlags.acceleration,
+		ImageCache:     serveFlags.imageCache,
+		NoImageCache:   serveFlags.noImageCache,
+		KubeconfigHost: serveFlags.kubeHost,
+		Display:        serveFlags.display,
+	}
+	if serveFlags.verbose {
+		cfg.CommandLog = os.Stdout
+	}
+	srv, err := virtuakube.NewServer(serveFlags.root, cfg)
+	if err != nil {
+		return fmt.Errorf("Creating server: %v", err)
+	}
+
+	l, err := net.Listen("tcp", serveFlags.addr)
+	if err != nil {
+		return fmt.Errorf("Listening on %q: %v", serveFlags.addr, err)
+	}
+	gl, err := net.Listen("tcp", serveFlags.grpcAddr)
+	if err != nil {
+		l.Close()
+		return fmt.Errorf("Listening on %q: %v", serveFlags.grpcAddr, err)
+	}
+	hs := &http.Server{Handler: srv.Handler()}
+	gs := srv.GRPCServer()
+	go gs.Serve(gl)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		fmt.Println("\nInterrupted, closing universes...")
+		// Closing the universes makes the requests in progress on
+		// them fail, so they don't hold up the shutdown, and they're
+		// all closed by the time Serve returns.
+		srv.Close()
+		gs.Stop()
+		hs.Shutdown(context.Background())
+	}()
+
+	fmt.Printf("Serving universes in %q over gRPC on %s and on http://%s/v1/universes, with the API token in %q\n", serveFlags.root, gl.Addr(), l.Addr(), srv.TokenFile())
+	if err := hs.Serve(l); err != http.ErrServerClosed {
+		return fmt.Errorf("Serving API: %v", err)
+	}
+	return nil
+}
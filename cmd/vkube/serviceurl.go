@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var serviceURLCmd = &cobra.Command{
+	Use:   "service-url <service>",
+	Short: "Print the localhost URL of a cluster Service",
+	Long: `Print the localhost URL that reaches the first TCP port of a NodePort
+or LoadBalancer Service, e.g.:
+
+  curl $(vkube service-url -u <dir> --cluster <cluster> my-service)
+
+The cluster must have been created with --expose-services, and the
+universe must be running in another vkube process, which keeps the
+Service's port forwarded for as long as it runs.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := serviceURL(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var serviceURLFlags = struct {
+	dir       string
+	cluster   string
+	namespace string
+}{}
+
+func init() {
+	rootCmd.AddCommand(serviceURLCmd)
+	serviceURLCmd.Flags().StringVarP(&serviceURLFlags.dir, "universe", "u", "", "directory containing the universe")
+	serviceURLCmd.Flags().StringVar(&serviceURLFlags.cluster, "cluster", "", "cluster running the Service")
+	serviceURLCmd.Flags().StringVarP(&serviceURLFlags.namespace, "namespace", "n", "default", "namespace of the Service")
+	serviceURLCmd.MarkFlagRequired("universe")
+	serviceURLCmd.MarkFlagRequired("cluster")
+}
+
+func serviceURL(name string) error {
+	r, err := virtuakube.Attach(serviceURLFlags.dir)
+	if err == virtuakube.ErrNotRunning {
+		return fmt.Errorf("Universe %q isn't running, Services are only exposed while it runs in another vkube process", serviceURLFlags.dir)
+	} else if err != nil {
+		return err
+	}
+	url, err := r.ServiceURL(serviceURLFlags.cluster, serviceURLFlags.namespace, name)
+	if err != nil {
+		return fmt.Errorf("Getting URL of service %q: %v", name, err)
+	}
+	fmt.Println(url)
+	return nil
+}
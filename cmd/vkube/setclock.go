@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var setClockCmd = &cobra.Command{
+	Use:   "set-clock <vm> <offset>",
+	Short: "Skew a running VM's clock",
+	Long: `Set a VM's clock to run offset (e.g. 90m, or -24h) ahead of the
+universe's clock, to test certificate expiry, token TTLs or lease
+renewal under clock drift. An offset of 0 puts the clock back in sync.
+VMs that are cluster nodes are named after the node. Negative offsets
+must come after --, e.g. vkube set-clock -u dir node1 -- -24h.
+
+If the universe is already running in another vkube process, the
+clock is set immediately. Otherwise, the universe is opened, the clock
+is set, and the universe is saved.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := setClock(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var setClockFlags = struct {
+	universe universeFlags
+}{}
+
+func init() {
+	rootCmd.AddCommand(setClockCmd)
+	addUniverseFlags(setClockCmd, &setClockFlags.universe, false, true)
+}
+
+func setClock(vm, offset string) error {
+	d, err := time.ParseDuration(offset)
+	if err != nil {
+		return fmt.Errorf("Invalid clock offset %q: %v", offset, err)
+	}
+
+	if r, err := virtuakube.Attach(setClockFlags.universe.dir); err == nil {
+		return r.SetClockOffset(vm, d)
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&setClockFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		return v.SetClockOffset(d)
+	})
+}
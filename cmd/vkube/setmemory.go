@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var setMemoryCmd = &cobra.Command{
+	Use:   "set-memory <vm> <MiB>",
+	Short: "Resize a running VM's memory",
+	Long: `Resize a VM's memory by inflating or deflating its memory balloon,
+returning memory the guest gives up to the host. The VM can't grow
+past the memory it was created with.
+
+If the universe is already running in another vkube process, the VM
+is resized immediately. Otherwise, the universe is opened, the VM is
+resized, and the universe is saved.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := setMemory(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var setMemoryFlags = struct {
+	universe universeFlags
+}{}
+
+func init() {
+	rootCmd.AddCommand(setMemoryCmd)
+	addUniverseFlags(setMemoryCmd, &setMemoryFlags.universe, false, true)
+}
+
+func setMemory(vm, mib string) error {
+	n, err := strconv.ParseInt(mib, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid memory size %q: %v", mib, err)
+	}
+	size := n << 20
+
+	if r, err := virtuakube.Attach(setMemoryFlags.universe.dir); err == nil {
+		return r.SetMemory(vm, size)
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&setMemoryFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		return v.SetMemory(size)
+	})
+}
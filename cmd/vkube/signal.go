@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"go.universe.tf/virtuakube"
+)
+
+// shutdown implements two-stage interrupt handling. The first ctrl+C
+// cancels the command's context, which lets the command wind down
+// gracefully (finish a save, close the universe). A second ctrl+C,
+// or the grace period running out, kills the universe outright.
+type shutdown struct {
+	grace time.Duration
+
+	mu    sync.Mutex
+	u     *virtuakube.Universe
+	phase string
+}
+
+func (s *shutdown) setUniverse(u *virtuakube.Universe) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.u = u
+}
+
+// setPhase records what the command is currently doing, so that
+// interrupts can say what they're waiting on.
+func (s *shutdown) setPhase(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phase
+}
+
+func (s *shutdown) status() (*virtuakube.Universe, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.u, s.phase
+}
+
+// handle waits for interrupts until ctx is done.
+func (s *shutdown) handle(ctx context.Context, cancel context.CancelFunc) {
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, os.Interrupt)
+
+	go func() {
+		defer cancel()
+		select {
+		case <-stop:
+		case <-ctx.Done():
+			return
+		}
+
+		_, phase := s.status()
+		fmt.Printf("\nInterrupted, shutting down gracefully (currently %s, waiting up to %s). Hit ctrl+C again to force.\n", phase, s.grace)
+		cancel()
+
+		grace := time.NewTimer(s.grace)
+		defer grace.Stop()
+		status := time.NewTicker(10 * time.Second)
+		defer status.Stop()
+		for {
+			select {
+			case <-stop:
+				s.kill("second interrupt")
+			case <-grace.C:
+				s.kill("grace period expired")
+			case <-status.C:
+				_, phase := s.status()
+				fmt.Printf("Still %s...\n", phase)
+			}
+		}
+	}()
+}
+
+func (s *shutdown) kill(reason string) {
+	u, phase := s.status()
+	fmt.Printf("%s while %s, killing universe immediately.\n", reason, phase)
+	if u != nil {
+		u.Kill()
+	}
+	os.Exit(130)
+}
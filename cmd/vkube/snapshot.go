@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage a universe's snapshots",
+	Long: `Manage a universe's saved snapshots.
+
+Snapshots can be changed whether or not the universe is running. If it
+is running in another vkube process, changes go through that process,
+and the snapshot it's running from can't be deleted or renamed. The
+default snapshot is named "".`,
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List a universe's snapshots",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := snapshotList(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var snapshotInfoCmd = &cobra.Command{
+	Use:   "info <snapshot>",
+	Short: "Show the resources saved in a snapshot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := snapshotInfo(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete <snapshot>...",
+	Short: "Delete snapshots, and the disk files only they use",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		for _, name := range args {
+			if err := snapshotDelete(name); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var snapshotRenameCmd = &cobra.Command{
+	Use:   "rename <old> <new>",
+	Short: "Rename a snapshot",
+	Args:  cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := snapshotRename(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var snapshotDiffCmd = &cobra.Command{
+	Use:   "diff <a> <b>",
+	Short: "Show what changed from one snapshot to another",
+	Long: `Show the resources that were added, removed or changed from snapshot a
+to snapshot b, with the configuration fields that differ, the disk
+space of files that only one of the snapshots uses, and for each VM
+the size of its disk data and saved memory.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := snapshotDiff(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var snapshotFlags = struct {
+	dir string
+}{}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotListCmd, snapshotInfoCmd, snapshotDeleteCmd, snapshotRenameCmd, snapshotDiffCmd)
+	snapshotCmd.PersistentFlags().StringVarP(&snapshotFlags.dir, "universe", "u", "", "directory containing the universe")
+	snapshotCmd.MarkPersistentFlagRequired("universe")
+}
+
+func snapshotList() error {
+	info, err := virtuakube.ReadUniverseInfo(snapshotFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Reading universe: %v", err)
+	}
+	return printResult(info.Snapshots, func() { printSnapshots(info) })
+}
+
+func snapshotInfo(name string) error {
+	info, err := virtuakube.ReadUniverseInfo(snapshotFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Reading universe: %v", err)
+	}
+	for _, snap := range info.Snapshots {
+		if snap.Name != name {
+			continue
+		}
+		return printResult(snap, func() {
+			fmt.Printf("Snapshot %s, saved at universe time %s\n", snapshotName(snap.Name), snap.Clock.Format(time.RFC3339))
+			fmt.Printf("  Images: %s\n", strings.Join(snap.Images, ", "))
+			fmt.Printf("  Networks: %s\n", strings.Join(snap.Networks, ", "))
+			fmt.Printf("  VMs: %s\n", strings.Join(snap.VMs, ", "))
+			fmt.Printf("  Clusters: %s\n", strings.Join(snap.Clusters, ", "))
+		})
+	}
+	return fmt.Errorf("No snapshot %q in universe %q", name, snapshotFlags.dir)
+}
+
+func snapshotDelete(name string) error {
+	r, err := virtuakube.Attach(snapshotFlags.dir)
+	switch err {
+	case nil:
+		err = r.DeleteSnapshot(name)
+	case virtuakube.ErrNotRunning:
+		err = virtuakube.DeleteSavedSnapshot(snapshotFlags.dir, name)
+	}
+	if err != nil {
+		return fmt.Errorf("Deleting snapshot %s: %v", snapshotName(name), err)
+	}
+	fmt.Printf("Deleted snapshot %s\n", snapshotName(name))
+	return nil
+}
+
+func snapshotRename(oldName, newName string) error {
+	r, err := virtuakube.Attach(snapshotFlags.dir)
+	switch err {
+	case nil:
+		err = r.RenameSnapshot(oldName, newName)
+	case virtuakube.ErrNotRunning:
+		err = virtuakube.RenameSavedSnapshot(snapshotFlags.dir, oldName, newName)
+	}
+	if err != nil {
+		return fmt.Errorf("Renaming snapshot %s: %v", snapshotName(oldName), err)
+	}
+	fmt.Printf("Renamed snapshot %s to %s\n", snapshotName(oldName), snapshotName(newName))
+	return nil
+}
+
+func snapshotDiff(a, b string) error {
+	diff, err := virtuakube.DiffSnapshots(snapshotFlags.dir, a, b)
+	if err != nil {
+		return fmt.Errorf("Comparing snapshots: %v", err)
+	}
+
+	return printResult(diff, func() {
+		fmt.Printf("Snapshot %s to %s, %s of universe time later\n", snapshotName(a), snapshotName(b), diff.Clock)
+		if len(diff.Fields) > 0 {
+			fmt.Printf("  Settings changed: %s\n", strings.Join(diff.Fields, ", "))
+		}
+		for _, d := range diff.Resources {
+			var details []string
+			if len(d.Fields) > 0 {
+				details = append(details, strings.Join(d.Fields, ", "))
+			}
+			if d.DiskAdded > 0 || d.DiskRemoved > 0 {
+				details = append(details, fmt.Sprintf("disk +%s -%s", formatBytes(d.DiskAdded), formatBytes(d.DiskRemoved)))
+			}
+			if d.Kind == "vm" && d.DataA != d.DataB {
+				details = append(details, fmt.Sprintf("disk data %s -> %s", formatBytes(d.DataA), formatBytes(d.DataB)))
+			}
+			if d.Kind == "vm" && d.StateA != d.StateB {
+				details = append(details, fmt.Sprintf("memory state %s -> %s", formatBytes(d.StateA), formatBytes(d.StateB)))
+			}
+			line := fmt.Sprintf("  %s %s: %s", d.Kind, d.Name, d.Change)
+			if len(details) > 0 {
+				line += " (" + strings.Join(details, "; ") + ")"
+			}
+			fmt.Println(line)
+		}
+		if len(diff.Resources) == 0 && len(diff.Fields) == 0 {
+			fmt.Println("  No changes")
+		}
+		fmt.Printf("Disk files: %s only in %s, %s only in %s\n", formatBytes(diff.AddedBytes), snapshotName(b), formatBytes(diff.RemovedBytes), snapshotName(a))
+	})
+}
+
+// formatBytes formats n bytes with a binary unit, e.g. "1.5GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var sshCmd = &cobra.Command{
+	Use:   "ssh <vm> [-- <command...>]",
+	Short: "Open a shell on a VM",
+	Long: `Open an interactive shell as root on a VM, or run a single command,
+e.g.:
+
+  vkube ssh -u ./u node1
+  vkube ssh -u ./u node1 -- journalctl -u kubelet
+
+The command's arguments are passed to it as is, quoted for the VM's
+shell, so use sh -c to run shell syntax like pipes:
+
+  vkube ssh -u ./u node1 -- sh -c 'journalctl -u kubelet | grep -i error'
+
+vkube exits with the exit code of the shell or command.
+
+If the universe is already running in another vkube process, the
+shell runs in that universe. Otherwise, the universe is opened, the
+shell runs, and the universe is closed again.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		quoted := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			quoted = append(quoted, shellQuote(arg))
+		}
+		code, err := sshVM(args[0], strings.Join(quoted, " "))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(code)
+	},
+}
+
+var sshFlags = struct {
+	universe universeFlags
+	tty      bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(sshCmd)
+	addUniverseFlags(sshCmd, &sshFlags.universe, false, false)
+	sshCmd.Flags().BoolVarP(&sshFlags.tty, "tty", "t", false, "allocate a terminal for the command, like an interactive shell gets")
+}
+
+func sshVM(vm, command string) (int, error) {
+	if r, err := virtuakube.Attach(sshFlags.universe.dir); err == nil {
+		client, err := r.SSH(vm)
+		if err != nil {
+			return 0, fmt.Errorf("Connecting to VM: %v", err)
+		}
+		defer client.Close()
+		return sshSession(client, command)
+	} else if err != virtuakube.ErrNotRunning {
+		return 0, err
+	}
+
+	code := 0
+	err := runDoWithUniverse(&sshFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		client, err := v.SSH()
+		if err != nil {
+			return fmt.Errorf("Connecting to VM: %v", err)
+		}
+		defer client.Close()
+		// Don't exit here, the universe has to be closed first.
+		code, err = sshSession(client, command)
+		return err
+	})
+	return code, err
+}
+
+// sshSession runs command, or a login shell if command is empty, on
+// client with the terminal's stdio, and returns its exit code.
+func sshSession(client *ssh.Client, command string) (int, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return 0, fmt.Errorf("Starting SSH session: %v", err)
+	}
+	defer sess.Close()
+
+	sess.Stdin, sess.Stdout, sess.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	if (command == "" || sshFlags.tty) && terminal.IsTerminal(fd) {
+		w, h, err := terminal.GetSize(fd)
+		if err != nil {
+			w, h = 80, 24
+		}
+		term := os.Getenv("TERM")
+		if term == "" {
+			term = "xterm"
+		}
+		if err := sess.RequestPty(term, h, w, ssh.TerminalModes{}); err != nil {
+			return 0, fmt.Errorf("Requesting terminal: %v", err)
+		}
+
+		old, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return 0, fmt.Errorf("Setting terminal to raw mode: %v", err)
+		}
+		defer terminal.Restore(fd, old)
+
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				if w, h, err := terminal.GetSize(fd); err == nil {
+					sess.WindowChange(h, w)
+				}
+			}
+		}()
+	}
+
+	if command == "" {
+		err = sess.Shell()
+		if err == nil {
+			err = sess.Wait()
+		}
+	} else {
+		err = sess.Run(command)
+	}
+	if exit, ok := err.(*ssh.ExitError); ok {
+		return exit.ExitStatus(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Running command: %v", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var sshConfigCmd = &cobra.Command{
+	Use:   "ssh-config [<vm>...]",
+	Short: "Print ssh_config entries for VMs",
+	Long: `Print ssh_config entries for the named VMs, or all VMs, of a
+universe running in another vkube process, e.g.:
+
+  vkube ssh-config -u ./u >>~/.ssh/config
+  ssh node1
+
+The entries log in as root with the universe's SSH key, on the VMs'
+current forwarded SSH ports, which are kept across runs of the
+universe when possible.`,
+	Run: func(_ *cobra.Command, args []string) {
+		if err := sshConfig(args); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var sshConfigFlags = struct {
+	dir string
+}{}
+
+func init() {
+	rootCmd.AddCommand(sshConfigCmd)
+	sshConfigCmd.Flags().StringVarP(&sshConfigFlags.dir, "universe", "u", "", "directory containing the universe")
+	sshConfigCmd.MarkFlagRequired("universe")
+}
+
+func sshConfig(vms []string) error {
+	r, err := virtuakube.Attach(sshConfigFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+	if len(vms) == 0 {
+		st, err := r.Status()
+		if err != nil {
+			return fmt.Errorf("Getting universe status: %v", err)
+		}
+		for _, v := range st.VMs {
+			vms = append(vms, v.Name)
+		}
+	}
+
+	type entry struct{ VM, Entry string }
+	var entries []entry
+	for _, vm := range vms {
+		e, err := r.SSHConfigEntry(vm)
+		if err != nil {
+			return fmt.Errorf("Getting SSH config of %q: %v", vm, err)
+		}
+		entries = append(entries, entry{vm, e})
+	}
+	return printResult(entries, func() {
+		for i, e := range entries {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(e.Entry)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show a universe's snapshots, and the resources of a running universe",
+	Long: `Show a universe's saved snapshots. If the universe is running, also
+show its VMs, clusters, forwarded ports and host resource usage.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := status(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var statusFlags = struct {
+	dir  string
+	json bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVarP(&statusFlags.dir, "universe", "u", "", "directory containing the universe")
+	statusCmd.Flags().BoolVar(&statusFlags.json, "json", false, "print only the running universe's status as JSON, failing if it isn't running")
+	statusCmd.MarkFlagRequired("universe")
+}
+
+func status() error {
+	if statusFlags.json {
+		return statusJSON()
+	}
+
+	info, err := virtuakube.ReadUniverseInfo(statusFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Reading universe: %v", err)
+	}
+
+	var st *virtuakube.UniverseStatus
+	r, err := virtuakube.Attach(statusFlags.dir)
+	if err != nil && err != virtuakube.ErrNotRunning {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	} else if err == nil {
+		if st, err = r.Status(); err != nil {
+			return fmt.Errorf("Getting universe status: %v", err)
+		}
+	}
+
+	res := struct {
+		Info *virtuakube.UniverseInfo
+		// Status is nil if the universe isn't running.
+		Status *virtuakube.UniverseStatus
+	}{info, st}
+	return printResult(res, func() {
+		printSnapshots(info)
+		if st == nil {
+			fmt.Printf("Universe %q is not running\n", statusFlags.dir)
+			return
+		}
+		printStatus(st)
+	})
+}
+
+// statusJSON prints the running universe's UniverseStatus as JSON.
+func statusJSON() error {
+	r, err := virtuakube.Attach(statusFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+	st, err := r.Status()
+	if err != nil {
+		return fmt.Errorf("Getting universe status: %v", err)
+	}
+	bs, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(resultOut, string(bs))
+	return nil
+}
+
+func printStatus(st *virtuakube.UniverseStatus) {
+	fmt.Printf("Universe %q running in process %d (snapshot %q, up %s)\n", st.Dir, st.PID, st.Snapshot, uptime(st))
+	if st.DNS != "" {
+		fmt.Printf("  DNS: %s\n", st.DNS)
+	}
+	if st.Metrics != "" {
+		fmt.Printf("  Metrics: http://%s/metrics\n", st.Metrics)
+	}
+	for _, n := range st.Networks {
+		fmt.Printf("  Network %q: %s, %s, MTU %d\n", n.Name, n.IPv4Subnet, n.IPv6Subnet, n.MTU)
+	}
+	for _, cluster := range st.Clusters {
+		fmt.Printf("  Cluster %q: export KUBECONFIG=%q\n", cluster.Name, cluster.Kubeconfig)
+		fmt.Printf("    controller %s, nodes %s\n", cluster.Controller, strings.Join(cluster.Nodes, ", "))
+	}
+	for _, vm := range st.VMs {
+		fmt.Printf("  VM %q: ssh -p%d root@localhost\n", vm.Name, vm.Ports[22])
+		fmt.Printf("    %d vCPUs, memory %dMiB (%dMiB resident), CPU time %s\n", vm.CPUs, vm.MemoryMiB, vm.RSSBytes>>20, vm.CPUTime.Truncate(time.Second))
+		if vm.BalloonMiB != 0 {
+			fmt.Printf("    ballooned to %dMiB, see vkube set-memory\n", vm.BalloonMiB)
+		}
+		if vm.ClockOffset != 0 {
+			fmt.Printf("    clock offset %s, see vkube set-clock\n", vm.ClockOffset)
+		}
+		if vm.Paused {
+			fmt.Printf("    paused, see vkube resume --vm\n")
+		}
+		for _, net := range vm.Networks {
+			fmt.Printf("    network %q: %s, %s\n", net, vm.IPv4[net], vm.IPv6[net])
+		}
+		if vm.Display != "" {
+			fmt.Printf("    display: %s\n", vm.Display)
+		}
+		if vm.LAN != "" {
+			fmt.Printf("    LAN: %s\n", vm.LAN)
+		}
+		var ports []int
+		for port := range vm.Ports {
+			ports = append(ports, port)
+		}
+		sort.Ints(ports)
+		for _, port := range ports {
+			fmt.Printf("    port %d -> localhost:%d\n", port, vm.Ports[port])
+		}
+	}
+	printEvents(st.Events)
+}
+
+// printSnapshots prints the universe's saved snapshots.
+func printSnapshots(info *virtuakube.UniverseInfo) {
+	fmt.Printf("Universe %q has %d snapshots\n", info.Dir, len(info.Snapshots))
+	for _, snap := range info.Snapshots {
+		fmt.Printf("  Snapshot %s: %d VMs, %d clusters, saved at universe time %s\n", snapshotName(snap.Name), len(snap.VMs), len(snap.Clusters), snap.Clock.Format(time.RFC3339))
+	}
+}
+
+// printEvents prints events that the user should know about.
+func printEvents(events []virtuakube.Event) {
+	for _, ev := range events {
+		if ev.Kind == virtuakube.EventPortReassigned {
+			fmt.Printf("  Warning: VM %q: %s\n", ev.Target, ev.Message)
+		}
+	}
+}
+
+// progressInterval is how often printProgress reports on each phase.
+const progressInterval = 5 * time.Second
+
+// printProgress prints how far through its long phases of work u is,
+// such as downloads and image builds, and how long they have left,
+// until ctx is done.
+func printProgress(ctx context.Context, u *virtuakube.Universe) {
+	if structuredOutput() {
+		return
+	}
+	ch := u.Subscribe(ctx, false)
+	go func() {
+		last := map[string]time.Time{}
+		for ev := range ch {
+			if ev.Kind != virtuakube.EventProgress || ev.Percent() < 0 {
+				continue
+			}
+			key := ev.Target + "\x00" + ev.Phase
+			if ev.Current != ev.Total && time.Since(last[key]) < progressInterval {
+				continue
+			}
+			last[key] = time.Now()
+			line := fmt.Sprintf("  %s: %s %d%%", ev.Target, ev.Phase, ev.Percent())
+			if eta := ev.ETA(); eta > 0 {
+				line += fmt.Sprintf(", about %s left", eta.Round(time.Second))
+			}
+			fmt.Println(line)
+		}
+	}()
+}
+
+func uptime(st *virtuakube.UniverseStatus) time.Duration {
+	return time.Since(st.Started).Truncate(time.Second)
+}
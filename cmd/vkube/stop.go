@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Shut down a universe running in another vkube process",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := stop(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var stopFlags = struct {
+	dir      string
+	save     bool
+	saveName string
+}{}
+
+func init() {
+	rootCmd.AddCommand(stopCmd)
+	stopCmd.Flags().StringVarP(&stopFlags.dir, "universe", "u", "", "directory containing the universe")
+	stopCmd.Flags().BoolVar(&stopFlags.save, "save", false, "save the universe instead of reverting it")
+	stopCmd.Flags().StringVar(&stopFlags.saveName, "save-snapshot", "", "snapshot to save to, if different from the running snapshot")
+	stopCmd.MarkFlagRequired("universe")
+}
+
+func stop() error {
+	r, err := virtuakube.Attach(stopFlags.dir)
+	if err != nil {
+		return fmt.Errorf("Attaching to universe: %v", err)
+	}
+
+	if !stopFlags.save {
+		fmt.Println("Closing (and reverting) universe...")
+		return r.Close()
+	}
+
+	st, err := r.Status()
+	if err != nil {
+		return fmt.Errorf("Getting universe status: %v", err)
+	}
+	saveName := stopFlags.saveName
+	if saveName == "" {
+		saveName = st.Snapshot
+	}
+	fmt.Printf("Saving universe to snapshot %q...\n", saveName)
+	return r.Save(saveName)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var throttleCmd = &cobra.Command{
+	Use:   "throttle <vm>",
+	Short: "Set I/O limits on a VM's disk",
+	Long: `Set I/O limits on a VM's disk, e.g. to reproduce slow storage. The
+root disk is throttled unless --disk selects one of the VM's extra
+disks, counting from 1 in the order they were added.
+
+If the universe is already running in another vkube process, the
+limits apply immediately to that universe. Otherwise, the universe is
+opened, the limits are set, and the universe is saved. Limits of 0
+remove throttling.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := throttle(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var throttleFlags = struct {
+	universe universeFlags
+	iops     int
+	bw       int64
+	disk     int
+}{}
+
+func init() {
+	rootCmd.AddCommand(throttleCmd)
+	addUniverseFlags(throttleCmd, &throttleFlags.universe, false, true)
+	throttleCmd.Flags().IntVar(&throttleFlags.iops, "iops", 0, "maximum disk operations per second (0 for unlimited)")
+	throttleCmd.Flags().Int64Var(&throttleFlags.bw, "bandwidth", 0, "maximum disk bytes per second (0 for unlimited)")
+	throttleCmd.Flags().IntVar(&throttleFlags.disk, "disk", 0, "extra disk to throttle, counting from 1 (0 for the root disk)")
+}
+
+func throttle(vm string) error {
+	spec := virtuakube.DiskSpec{
+		IOPSLimit:      throttleFlags.iops,
+		BandwidthLimit: throttleFlags.bw,
+	}
+
+	if r, err := virtuakube.Attach(throttleFlags.universe.dir); err == nil {
+		if throttleFlags.disk != 0 {
+			return r.SetExtraDiskLimits(vm, throttleFlags.disk, spec)
+		}
+		return r.SetDiskLimits(vm, spec)
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&throttleFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		if throttleFlags.disk != 0 {
+			return v.SetExtraDiskLimits(throttleFlags.disk, spec)
+		}
+		return v.SetDiskLimits(spec)
+	})
+}
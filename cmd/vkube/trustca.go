@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var trustCACmd = &cobra.Command{
+	Use:   "trust-ca <cert-file>",
+	Short: "Make a cluster's nodes trust a CA certificate",
+	Long: `Add a PEM CA certificate to the trust store of all of a cluster's nodes,
+and of nodes added later, e.g. for a registry with a private CA. The
+nodes' container runtimes restart to pick it up.
+
+If the universe is already running in another vkube process, that
+universe's cluster trusts the CA. Otherwise, the universe is opened,
+the CA is installed, and the universe is saved.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := trustCA(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var trustCAFlags = struct {
+	universe universeFlags
+	cluster  string
+}{}
+
+func init() {
+	rootCmd.AddCommand(trustCACmd)
+	addUniverseFlags(trustCACmd, &trustCAFlags.universe, false, true)
+	trustCACmd.Flags().StringVar(&trustCAFlags.cluster, "cluster", "", "cluster whose nodes trust the CA")
+	trustCACmd.MarkFlagRequired("cluster")
+}
+
+func trustCA(path string) error {
+	cert, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Reading CA certificate: %v", err)
+	}
+
+	if r, err := virtuakube.Attach(trustCAFlags.universe.dir); err == nil {
+		if err := r.TrustCA(trustCAFlags.cluster, cert); err != nil {
+			return fmt.Errorf("Installing CA: %v", err)
+		}
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&trustCAFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		cluster := u.Cluster(trustCAFlags.cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", trustCAFlags.cluster)
+		}
+		if err := cluster.TrustCA(cert); err != nil {
+			return fmt.Errorf("Installing CA: %v", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel",
+	Short: "Forward the ports of a universe running on a remote host",
+	Long: `Forward the ports of a universe running on a remote host to this one.
+
+Run the universe on the remote host with vkube as usual (e.g. ssh into
+it and run vkube resume -w), then run vkube tunnel locally. It fetches
+the universe's status over SSH, forwards every VM port that the
+universe exposes on the remote host to the same port on localhost, and
+copies the clusters' kubeconfigs locally, so kubectl, ssh and
+Kubernetes clients work from here as if the universe were local. The
+VMs' serial consoles are reached with vkube console --host.
+
+vkube must be in the remote host's PATH. The tunnel runs until
+ctrl+C, and doesn't pick up VMs created after it started.`,
+	Args: cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := tunnel(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var tunnelFlags = struct {
+	host       string
+	dir        string
+	kubeconfig string
+}{}
+
+func init() {
+	rootCmd.AddCommand(tunnelCmd)
+	tunnelCmd.Flags().StringVar(&tunnelFlags.host, "host", "", "SSH destination of the remote host, e.g. user@lab")
+	tunnelCmd.Flags().StringVarP(&tunnelFlags.dir, "universe", "u", "", "directory containing the universe on the remote host")
+	tunnelCmd.Flags().StringVar(&tunnelFlags.kubeconfig, "kubeconfig-dir", ".", "local directory to copy cluster kubeconfigs to")
+	tunnelCmd.MarkFlagRequired("host")
+	tunnelCmd.MarkFlagRequired("universe")
+}
+
+func tunnel() error {
+	out, err := exec.Command("ssh", tunnelFlags.host, "vkube status --json -u "+shellQuote(tunnelFlags.dir)).Output()
+	if err != nil {
+		return fmt.Errorf("Getting remote universe status: %v (%s)", err, strings.TrimSpace(string(out)))
+	}
+	var st virtuakube.UniverseStatus
+	if err := json.Unmarshal(out, &st); err != nil {
+		return fmt.Errorf("Parsing remote universe status: %v", err)
+	}
+
+	for _, cluster := range st.Clusters {
+		kubeconfig, err := exec.Command("ssh", tunnelFlags.host, "cat "+shellQuote(cluster.Kubeconfig)).Output()
+		if err != nil {
+			return fmt.Errorf("Fetching kubeconfig of cluster %q: %v", cluster.Name, err)
+		}
+		path := filepath.Join(tunnelFlags.kubeconfig, cluster.Name+".kubeconfig")
+		if err := ioutil.WriteFile(path, kubeconfig, 0600); err != nil {
+			return fmt.Errorf("Writing kubeconfig of cluster %q: %v", cluster.Name, err)
+		}
+		fmt.Printf("  Cluster %q: export KUBECONFIG=%q\n", cluster.Name, path)
+	}
+
+	var ports []int
+	for _, vm := range st.VMs {
+		for _, port := range vm.Ports {
+			ports = append(ports, port)
+		}
+		if port, ok := vm.Ports[22]; ok {
+			fmt.Printf("  VM %q: ssh -p%d root@localhost\n", vm.Name, port)
+		}
+		fmt.Printf("  VM %q console: vkube console --host %s -u %s %s\n", vm.Name, shellQuote(tunnelFlags.host), shellQuote(tunnelFlags.dir), shellQuote(vm.Name))
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("Remote universe %q has no forwarded ports", tunnelFlags.dir)
+	}
+	sort.Ints(ports)
+
+	args := []string{"-N", "-o", "ExitOnForwardFailure=yes"}
+	for _, port := range ports {
+		args = append(args, "-L", fmt.Sprintf("127.0.0.1:%d:127.0.0.1:%d", port, port))
+	}
+	args = append(args, tunnelFlags.host)
+	fmt.Printf("\nForwarding %d ports from %s, hit ctrl+C to stop\n", len(ports), tunnelFlags.host)
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Forwarding ports: %v", err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for the remote shell that ssh runs commands
+// with.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.universe.tf/virtuakube"
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade <version>",
+	Short: "Upgrade a running cluster to a new Kubernetes version",
+	Long: `Upgrade a cluster's control plane with kubeadm, then upgrade its worker
+nodes one at a time, draining each while its kubelet is upgraded.
+
+The version can be a newer patch release, or a release of the next
+minor version. If the universe is already running in another vkube
+process, that universe's cluster is upgraded. Otherwise, the universe
+is opened, the cluster is upgraded, and the universe is saved.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if err := upgrade(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var upgradeFlags = struct {
+	universe universeFlags
+	cluster  string
+}{}
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+	addUniverseFlags(upgradeCmd, &upgradeFlags.universe, false, true)
+	upgradeCmd.Flags().StringVar(&upgradeFlags.cluster, "cluster", "", "cluster to upgrade")
+	upgradeCmd.MarkFlagRequired("cluster")
+}
+
+func upgrade(version string) error {
+	if r, err := virtuakube.Attach(upgradeFlags.universe.dir); err == nil {
+		if err := r.Upgrade(upgradeFlags.cluster, version); err != nil {
+			return fmt.Errorf("Upgrading cluster: %v", err)
+		}
+		fmt.Printf("Upgraded cluster %q to Kubernetes %s\n", upgradeFlags.cluster, version)
+		return nil
+	} else if err != virtuakube.ErrNotRunning {
+		return err
+	}
+
+	return runDoWithUniverse(&upgradeFlags.universe, func(ctx context.Context, u *virtuakube.Universe) error {
+		cluster := u.Cluster(upgradeFlags.cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", upgradeFlags.cluster)
+		}
+		if err := cluster.Upgrade(ctx, version); err != nil {
+			return fmt.Errorf("Upgrading cluster: %v", err)
+		}
+		fmt.Printf("Upgraded cluster %q to Kubernetes %s\n", upgradeFlags.cluster, version)
+		return nil
+	})
+}
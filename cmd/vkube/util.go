@@ -4,38 +4,83 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"go.universe.tf/virtuakube"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 type universeFlags struct {
 	dir          string
 	snapshot     string
 	verbose      bool
+	logJSON      bool
 	vmgraphics   bool
+	display      string
 	acceleration bool
 	wait         bool
 	save         bool
 	saveName     string
+	autoSnapshot time.Duration
+	autoBalloon  time.Duration
+	grace        time.Duration
+	kubeHost     string
+	saveOnError  string
+	dns          bool
+	bridge       string
+	metricsAddr  string
+	imageCache   string
+	noImageCache bool
+	offline      bool
+	httpProxy    string
+	httpsProxy   string
+	noProxy      string
+	aptMirror    string
+	regMirrors   []string
+	kubeconfig   string
+	force        bool
 }
 
 func addUniverseFlags(cmd *cobra.Command, flags *universeFlags, wait, save bool) {
 	cmd.Flags().StringVarP(&flags.dir, "universe", "u", "", "directory containing the universe")
 	cmd.Flags().StringVarP(&flags.snapshot, "snapshot", "s", "", "snapshot to resume in the universe")
 	cmd.Flags().BoolVarP(&flags.verbose, "verbose", "v", false, "show commands being executed under the hood")
+	cmd.Flags().BoolVar(&flags.logJSON, "log-json", false, "with --verbose, log as JSON lines with structured fields")
+	cmd.Flags().StringVar(&flags.display, "display", "none", "how VMs show their screen: none, local (a window each), vnc or spice (on localhost ports, see vkube display)")
 	cmd.Flags().BoolVar(&flags.vmgraphics, "graphics", false, "show a GUI for each running VM")
+	cmd.Flags().MarkDeprecated("graphics", "use --display=local")
 	cmd.Flags().BoolVar(&flags.acceleration, "acceleration", true, "use KVM to accelerate VMs")
 	cmd.Flags().BoolVarP(&flags.wait, "wait", "w", wait, "wait for ctrl+C before exiting")
 	cmd.Flags().BoolVar(&flags.save, "save", save, "save the universe on exit")
 	cmd.Flags().StringVar(&flags.saveName, "save-snapshot", "", "snapshot to save to, if different from --snapshot")
+	cmd.Flags().DurationVar(&flags.grace, "grace-period", 5*time.Minute, "how long to wait for in-flight operations after ctrl+C, before killing the universe")
+	cmd.Flags().DurationVar(&flags.autoSnapshot, "auto-snapshot", 0, "save a rolling auto snapshot at this interval while running")
+	cmd.Flags().DurationVar(&flags.autoBalloon, "auto-balloon", 0, "resize VM memory to fit what guests use at this interval while running")
+	cmd.Flags().StringVar(&flags.kubeHost, "kubeconfig-host", "", "IP address of this host that the kubeconfigs exported on save reach the API server at (default 127.0.0.1)")
+	cmd.Flags().BoolVar(&flags.dns, "dns", false, "serve DNS for VM and cluster names on a localhost port, see vkube dns")
+	cmd.Flags().StringVar(&flags.bridge, "bridge", "", "host bridge to attach new VMs to, so they get addresses on the LAN")
+	cmd.Flags().StringVar(&flags.metricsAddr, "metrics-addr", "", "serve Prometheus metrics for the universe on this address, e.g. 127.0.0.1:9100")
+	cmd.Flags().StringVar(&flags.imageCache, "image-cache", "", "directory of images and downloads shared between universes, on the same filesystem (default ~/.cache/virtuakube)")
+	cmd.Flags().BoolVar(&flags.noImageCache, "no-image-cache", false, "don't share images and downloads with other universes")
+	cmd.Flags().BoolVar(&flags.offline, "offline", false, "work without internet access, from the image cache and VM images only (see vkube prefetch)")
+	cmd.Flags().StringVar(&flags.httpProxy, "http-proxy", "", "HTTP proxy URL for guests to reach the internet through")
+	cmd.Flags().StringVar(&flags.httpsProxy, "https-proxy", "", "HTTPS proxy URL for guests to reach the internet through")
+	cmd.Flags().StringVar(&flags.noProxy, "no-proxy", "", "comma-separated hosts, domains and CIDRs that guests reach without the proxies")
+	cmd.Flags().StringVar(&flags.aptMirror, "apt-mirror", "", "Debian mirror URL for guests to use instead of deb.debian.org")
+	cmd.Flags().StringSliceVar(&flags.regMirrors, "registry-mirror", nil, "container registry mirrors for guests, as registry=url (e.g. docker.io=https://mirror.example.com)")
+	cmd.Flags().StringVar(&flags.kubeconfig, "merge-kubeconfig", "", "add cluster contexts to this kubeconfig while running (default ~/.kube/config if given without a value)")
+	cmd.Flags().Lookup("merge-kubeconfig").NoOptDefVal = clientcmd.RecommendedHomeFile
+	cmd.Flags().BoolVar(&flags.force, "force", false, "take over a universe whose vkube process died, killing the VMs it left running")
 	cmd.MarkFlagRequired("universe")
 }
 
-type universeFunc func(*virtuakube.Universe) error
+// universeFunc is a command's work on an open universe. ctx is
+// canceled by ctrl+C.
+type universeFunc func(ctx context.Context, u *virtuakube.Universe) error
 
 func withUniverse(flags *universeFlags, do universeFunc) func(*cobra.Command, []string) {
 	return func(_ *cobra.Command, _ []string) {
@@ -52,27 +97,25 @@ func runDoWithUniverse(flags *universeFlags, do universeFunc) error {
 
 	// Handle ctrl+C by cancelling the context, which will shut down
 	// everything in the universe.
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	go func() {
-		defer cancel()
-		select {
-		case <-stop:
-		case <-ctx.Done():
-		}
-	}()
+	sd := &shutdown{grace: flags.grace, phase: "opening universe"}
+	sd.handle(ctx, cancel)
 
 	start := time.Now()
 
-	u, err := openOrCreateUniverse(flags.dir, flags.snapshot, flags.verbose, flags.vmgraphics, flags.wait, flags.acceleration)
+	u, err := openOrCreateUniverse(ctx, flags)
 	if err != nil {
 		return fmt.Errorf("Getting universe: %v", err)
 	}
 	defer u.Close()
+	sd.setUniverse(u)
+	printEvents(u.Events())
+	printProgress(ctx, u)
 
-	if err := do(u); err != nil {
+	sd.setPhase("running command")
+	if err := do(ctx, u); err != nil {
 		return err
 	}
+	interrupted := ctx.Err() != nil
 
 	d := time.Since(start)
 	switch {
@@ -84,6 +127,17 @@ func runDoWithUniverse(flags *universeFlags, do universeFunc) error {
 		d = d.Truncate(time.Second)
 	}
 	fmt.Printf("Operation took %s.\n", d)
+	if structuredOutput() {
+		// Printed before waiting or saving, so that scripts can use
+		// the universe while it's up.
+		res := struct {
+			Duration time.Duration
+			Status   *virtuakube.UniverseStatus
+		}{time.Since(start), u.Status()}
+		if err := printResult(res, nil); err != nil {
+			return err
+		}
+	}
 
 	if flags.wait {
 		fmt.Printf("Resources available:\n\n")
@@ -93,21 +147,41 @@ func runDoWithUniverse(flags *universeFlags, do universeFunc) error {
 		for _, vm := range u.VMs() {
 			fmt.Printf("  VM %q: ssh -p%d root@localhost\n", vm.Hostname(), vm.ForwardedPort(22))
 		}
+		for _, r := range u.Registries() {
+			fmt.Printf("  Registry %q: docker push %s/<image>\n", r.Name(), r.HostAddress())
+		}
+		if addr := u.DNSAddr(); addr != "" {
+			fmt.Printf("  DNS: %s, see vkube dns\n", addr)
+		}
+		if addr := u.MetricsAddr(); addr != "" {
+			fmt.Printf("  Metrics: http://%s/metrics\n", addr)
+		}
 
 		fmt.Println("\nHit ctrl+C to shut down")
-		<-ctx.Done()
+		sd.setPhase("waiting for ctrl+C")
+		if err := u.Wait(ctx); err == nil {
+			fmt.Println("Universe was shut down by another vkube process.")
+			return nil
+		}
 	}
 
-	if flags.save {
+	if flags.save && interrupted {
+		fmt.Println("Interrupted before setup finished, not saving.")
+	}
+	if flags.save && !interrupted {
+		sd.setPhase("saving universe")
 		fmt.Println("Saving universe...")
 		saveName := flags.saveName
 		if saveName == "" && saveName != flags.snapshot {
 			saveName = flags.snapshot
 		}
-		if err := u.Save(saveName); err != nil {
+		// The save mustn't be canceled by the ctrl+C that the
+		// shutdown handler lets it finish after.
+		if err := u.Save(context.Background(), saveName); err != nil {
 			return fmt.Errorf("Saving universe: %v", err)
 		}
 	} else {
+		sd.setPhase("closing universe")
 		fmt.Println("Closing (and reverting) universe...")
 		if err := u.Close(); err != nil {
 			return fmt.Errorf("Closing universe: %v", err)
@@ -119,32 +193,62 @@ func runDoWithUniverse(flags *universeFlags, do universeFunc) error {
 
 // openOrCreateUniverse sets up a universe, either by creating it from
 // scratch, or by opening an existing one.
-func openOrCreateUniverse(dir, snapshot string, verbose, vmgraphics, interactive, acceleration bool) (*virtuakube.Universe, error) {
+func openOrCreateUniverse(ctx context.Context, flags *universeFlags) (*virtuakube.Universe, error) {
+	dir := flags.dir
 	if dir == "" {
 		return nil, errors.New("universe directory not specified")
 	}
 
+	if r, err := virtuakube.Attach(dir); err == nil {
+		return nil, fmt.Errorf("universe %q is already running in process %d, use vkube exec, status or stop to control it", dir, r.PID())
+	}
+
 	var (
 		universe *virtuakube.Universe
 		err      error
 	)
 
+	// vkube does its own interrupt handling, so subprocesses always
+	// need to be immune to ^C.
 	cfg := &virtuakube.UniverseConfig{
-		VMGraphics:     vmgraphics,
-		Interactive:    interactive,
-		NoAcceleration: !acceleration,
+		Display:              flags.display,
+		Interactive:          true,
+		NoAcceleration:       !flags.acceleration,
+		AutoSnapshotInterval: flags.autoSnapshot,
+		AutoBalloonInterval:  flags.autoBalloon,
+		KubeconfigHost:       flags.kubeHost,
+		SaveOnError:          flags.saveOnError,
+		DNS:                  flags.dns,
+		Bridge:               flags.bridge,
+		MetricsAddr:          flags.metricsAddr,
+		ImageCache:           flags.imageCache,
+		NoImageCache:         flags.noImageCache,
+		Offline:              flags.offline,
+		MergeKubeconfig:      flags.kubeconfig,
+		Force:                flags.force,
+	}
+	if flags.vmgraphics {
+		cfg.Display = virtuakube.DisplayLocal
 	}
-	if verbose {
+	if cfg.Upstream, err = upstreamConfig(flags); err != nil {
+		return nil, err
+	}
+	if flags.bridge != "" {
+		cfg.Network = virtuakube.NetworkBridged
+	}
+	if flags.verbose && flags.logJSON {
+		cfg.Logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	} else if flags.verbose {
 		cfg.CommandLog = os.Stdout
 	}
 
 	_, err = os.Stat(dir)
 	if os.IsNotExist(err) {
-		universe, err = virtuakube.Create(dir, cfg)
+		universe, err = virtuakube.Create(ctx, dir, cfg)
 	} else if err != nil {
 		return nil, err
 	} else {
-		universe, err = virtuakube.Open(dir, snapshot, cfg)
+		universe, err = virtuakube.Open(ctx, dir, flags.snapshot, cfg)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("getting universe: %v", err)
@@ -152,3 +256,28 @@ func openOrCreateUniverse(dir, snapshot string, verbose, vmgraphics, interactive
 
 	return universe, nil
 }
+
+// upstreamConfig returns the proxies and mirrors given by flags, or
+// nil if there are none.
+func upstreamConfig(flags *universeFlags) (*virtuakube.UpstreamConfig, error) {
+	if flags.httpProxy == "" && flags.httpsProxy == "" && flags.aptMirror == "" && len(flags.regMirrors) == 0 {
+		return nil, nil
+	}
+	ret := &virtuakube.UpstreamConfig{
+		HTTPProxy:  flags.httpProxy,
+		HTTPSProxy: flags.httpsProxy,
+		NoProxy:    flags.noProxy,
+		AptMirror:  flags.aptMirror,
+	}
+	for _, m := range flags.regMirrors {
+		i := strings.Index(m, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid registry mirror %q, must be registry=url", m)
+		}
+		if ret.RegistryMirrors == nil {
+			ret.RegistryMirrors = map[string]string{}
+		}
+		ret.RegistryMirrors[m[:i]] = m[i+1:]
+	}
+	return ret, nil
+}
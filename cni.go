@@ -0,0 +1,218 @@
+package virtuakube
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.universe.tf/virtuakube/internal/assets"
+)
+
+// CNI addons for use in ClusterConfig.CNI. Calico, Flannel and Weave
+// are bundled with virtuakube. Cilium 1.6.12 is fetched from upstream
+// when the cluster starts, so nodes need internet access, and checked
+// against a pinned SHA-256.
+const (
+	CNINone    = "none"
+	CNICalico  = "calico"
+	CNICilium  = "cilium"
+	CNIFlannel = "flannel"
+	CNIWeave   = "weave"
+)
+
+// cniCustom is recorded as the CNI of clusters created with
+// ClusterConfig.CNIManifest.
+const cniCustom = "custom"
+
+// ciliumManifestURL is the Cilium release installed for CNICilium,
+// and ciliumManifestSHA256 its hex SHA-256. The release is pinned, so
+// that upstream changes to the manifest can't break the MTU patch or
+// change the installed Cilium under existing users.
+const (
+	ciliumManifestURL    = "https://raw.githubusercontent.com/cilium/cilium/v1.6.12/install/kubernetes/quick-install.yaml"
+	ciliumManifestSHA256 = "f4a082a3c0b6076024f3ae255305812fba066bd52c8b2d60d0e685a19ec008f0"
+)
+
+// cniOverhead is the per-packet encapsulation overhead of each CNI's
+// default dataplane, in bytes. The pod MTU is the network MTU minus
+// this overhead.
+var cniOverhead = map[string]int{
+	CNICalico:  20,  // IP-in-IP
+	CNICilium:  50,  // VXLAN
+	CNIFlannel: 50,  // VXLAN
+	CNIWeave:   124, // fastdp, matches weave's stock 1376 MTU on a 1500 link
+	// Custom manifests configure their own MTU.
+	cniCustom: 0,
+}
+
+// cniOptions lists the ClusterConfig.CNIOptions keys that each
+// bundled CNI understands, and their allowed values. The first value
+// of each key is the default.
+var cniOptions = map[string]map[string][]string{
+	CNICalico:  {"encapsulation": {"ipip", "cross-subnet", "none"}},
+	CNIFlannel: {"backend": {"vxlan", "host-gw"}},
+	CNICilium:  {},
+	CNIWeave:   {},
+}
+
+// validateCNIOptions checks that opts only sets keys and values that
+// cni understands.
+func validateCNIOptions(cni string, opts map[string]string) error {
+	known := cniOptions[cni]
+	for k, v := range opts {
+		vals, ok := known[k]
+		if !ok {
+			var keys []string
+			for k := range known {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if len(keys) == 0 {
+				return fmt.Errorf("%s doesn't have any options, got %q", cni, k)
+			}
+			return fmt.Errorf("unknown %s option %q (known options: %s)", cni, k, strings.Join(keys, ", "))
+		}
+		found := false
+		for _, val := range vals {
+			if v == val {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid value %q for %s option %q (want one of %s)", v, cni, k, strings.Join(vals, ", "))
+		}
+	}
+	return nil
+}
+
+// cniOption returns the value of the option key in opts, or its
+// default.
+func cniOption(cni string, opts map[string]string, key string) string {
+	if v := opts[key]; v != "" {
+		return v
+	}
+	return cniOptions[cni][key][0]
+}
+
+// networkAddonManifest returns the manifest of the cluster's network
+// addon.
+func (c *Cluster) networkAddonManifest() ([]byte, error) {
+	switch c.cfg.CNI {
+	case cniCustom:
+		return c.cniRaw, nil
+	case CNICilium:
+		// Fetch from the controller, since it needs internet access
+		// to pull Cilium's images anyway.
+		bs, err := c.universe.cachedDownload(ciliumManifestURL, func() ([]byte, error) {
+			bs, err := c.controller.Run("curl -fsSL " + ciliumManifestURL)
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(bs)
+			if got := hex.EncodeToString(sum[:]); got != ciliumManifestSHA256 {
+				return nil, fmt.Errorf("downloading %s: got SHA-256 %s, want %s", ciliumManifestURL, got, ciliumManifestSHA256)
+			}
+			return bs, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("fetching Cilium manifest: %v", err)
+		}
+		return ciliumManifest(bs, c.linkMTU())
+	default:
+		return cniManifest(c.cfg.CNI, c.cfg.CNIOptions, c.PodMTU(), c.podNetwork())
+	}
+}
+
+// podMTU returns the MTU pods should use with cni and opts on a
+// network with the given link MTU.
+func podMTU(cni string, opts map[string]string, linkMTU int) int {
+	switch {
+	case cni == CNICalico && cniOption(cni, opts, "encapsulation") == "none":
+		return linkMTU
+	case cni == CNIFlannel && cniOption(cni, opts, "backend") == "host-gw":
+		return linkMTU
+	}
+	return linkMTU - cniOverhead[cni]
+}
+
+var (
+	calicoMTURe     = regexp.MustCompile(`veth_mtu: "\d+"`)
+	calicoIPIPRe    = regexp.MustCompile(`(- name: CALICO_IPV4POOL_IPIP\n +value: )"Always"`)
+	flannelTypeRe   = regexp.MustCompile(`"Type": "vxlan"`)
+	weaveEnvRe      = regexp.MustCompile(`(?m)^( +)env:\n( +)- name: HOSTNAME\n`)
+	flannelSubnetRe = regexp.MustCompile(`(?m)^( +)- --kube-subnet-mgr\n`)
+	flannelNetRe    = regexp.MustCompile(`"Network": "[0-9./]+"`)
+	calicoPoolRe    = regexp.MustCompile(`(- name: CALICO_IPV4POOL_CIDR\n +value: )"[0-9./]+"`)
+	ciliumTunnelRe  = regexp.MustCompile(`(?m)^( +)tunnel: vxlan\n`)
+)
+
+// ciliumManifest patches the Cilium manifest bs to run over a network
+// with the given link MTU. Cilium detects the MTU from the interface
+// of the default route, which is the NATed internet interface rather
+// than the cluster network, and subtracts its VXLAN overhead itself.
+func ciliumManifest(bs []byte, linkMTU int) ([]byte, error) {
+	if !ciliumTunnelRe.Match(bs) {
+		return nil, fmt.Errorf("can't find cilium tunnel setting in manifest")
+	}
+	return ciliumTunnelRe.ReplaceAll(bs, []byte(`$0${1}mtu: "`+strconv.Itoa(linkMTU)+`"`+"\n")), nil
+}
+
+// cniManifest returns the manifest for the bundled CNI addon, patched
+// to use the given options, pod MTU and IPv4 pod network.
+func cniManifest(cni string, opts map[string]string, mtu int, pods string) ([]byte, error) {
+	if _, ok := cniOverhead[cni]; !ok {
+		return nil, fmt.Errorf("unknown CNI %q", cni)
+	}
+	bs, err := assets.Asset("net/" + cni + ".yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	switch cni {
+	case CNICalico:
+		bs = calicoMTURe.ReplaceAll(bs, []byte(`veth_mtu: "`+strconv.Itoa(mtu)+`"`))
+		mode := map[string]string{
+			"ipip":         "Always",
+			"cross-subnet": "CrossSubnet",
+			"none":         "Never",
+		}[cniOption(cni, opts, "encapsulation")]
+		if !calicoIPIPRe.Match(bs) {
+			return nil, fmt.Errorf("can't find calico IPIP setting in manifest")
+		}
+		bs = calicoIPIPRe.ReplaceAll(bs, []byte(`${1}"`+mode+`"`))
+		if !calicoPoolRe.Match(bs) {
+			return nil, fmt.Errorf("can't find calico IP pool in manifest")
+		}
+		bs = calicoPoolRe.ReplaceAll(bs, []byte(`${1}"`+pods+`"`))
+	case CNIWeave:
+		// Only the first env block belongs to the weave router
+		// container, the second is weave-npc.
+		loc := weaveEnvRe.FindSubmatchIndex(bs)
+		if loc == nil {
+			return nil, fmt.Errorf("can't find weave container env in manifest")
+		}
+		at, indent := loc[4], string(bs[loc[4]:loc[5]])
+		patch := fmt.Sprintf("%s- name: WEAVE_MTU\n%s  value: \"%d\"\n", indent, indent, mtu)
+		bs = append(bs[:at:at], append([]byte(patch), bs[at:]...)...)
+	case CNIFlannel:
+		// Flannel computes its MTU from the interface it runs over,
+		// so point it at the cluster network (the first LAN NIC)
+		// rather than the NATed internet interface.
+		bs = flannelSubnetRe.ReplaceAll(bs, []byte("$0$1- --iface="+nicInterface(0)+"\n"))
+		if !flannelTypeRe.Match(bs) {
+			return nil, fmt.Errorf("can't find flannel backend in manifest")
+		}
+		bs = flannelTypeRe.ReplaceAll(bs, []byte(`"Type": "`+cniOption(cni, opts, "backend")+`"`))
+		if !flannelNetRe.Match(bs) {
+			return nil, fmt.Errorf("can't find flannel network in manifest")
+		}
+		bs = flannelNetRe.ReplaceAll(bs, []byte(`"Network": "`+pods+`"`))
+	}
+
+	return bs, nil
+}
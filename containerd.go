@@ -0,0 +1,106 @@
+package virtuakube
+
+import (
+	"fmt"
+	"regexp"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// containerdConfig is where nodes' containerd reads its config.
+const containerdConfig = "/etc/containerd/config.toml"
+
+// validateContainerdSnippet checks that snippet is parseable TOML.
+func validateContainerdSnippet(snippet string) error {
+	if _, err := toml.Load(snippet); err != nil {
+		return fmt.Errorf("parsing containerd config snippet: %v", err)
+	}
+	return nil
+}
+
+// mergeTOML merges overlay into base. Tables are merged recursively,
+// any other value in overlay replaces the one in base.
+func mergeTOML(base, overlay *toml.Tree) {
+	for _, key := range overlay.Keys() {
+		// Keys can contain dots (e.g. plugin names), so always use
+		// the Path variants to avoid splitting them.
+		path := []string{key}
+		ov := overlay.GetPath(path)
+		if ot, ok := ov.(*toml.Tree); ok {
+			if bt, ok := base.GetPath(path).(*toml.Tree); ok {
+				mergeTOML(bt, ot)
+				continue
+			}
+		}
+		base.SetPath(path, ov)
+	}
+}
+
+// installContainerdConfig merges the cluster's containerd config
+// snippet into node's containerd config, and restarts containerd.
+func (c *Cluster) installContainerdConfig(node *VM, snippet string) error {
+	return mergeContainerdConfig(node, snippet)
+}
+
+// mergeContainerdConfig merges the containerd config snippet into
+// node's containerd config, and restarts containerd.
+func mergeContainerdConfig(node *VM, snippet string) error {
+	overlay, err := toml.Load(snippet)
+	if err != nil {
+		return fmt.Errorf("parsing containerd config snippet: %v", err)
+	}
+
+	// The stock config may not exist, or be empty.
+	cur, err := node.Run("cat " + containerdConfig + " 2>/dev/null || true")
+	if err != nil {
+		return err
+	}
+	base, err := toml.Load(string(cur))
+	if err != nil {
+		return fmt.Errorf("parsing %s on %q: %v", containerdConfig, node.Hostname(), err)
+	}
+	mergeTOML(base, overlay)
+	merged, err := base.ToTomlString()
+	if err != nil {
+		return err
+	}
+
+	if _, err := node.Run("mkdir -p /etc/containerd"); err != nil {
+		return err
+	}
+	if err := node.WriteFile(containerdConfig, []byte(merged)); err != nil {
+		return err
+	}
+	if _, err := node.Run("systemctl restart containerd"); err != nil {
+		return fmt.Errorf("restarting containerd on %q: %v", node.Hostname(), err)
+	}
+	return nil
+}
+
+// runtimeClassNameRe matches valid RuntimeClass names, which are DNS
+// subdomains.
+var runtimeClassNameRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// installRuntimeClasses registers a RuntimeClass for each name ->
+// containerd handler in classes.
+func (c *Cluster) installRuntimeClasses(classes map[string]string) error {
+	manifest := ""
+	for name, handler := range classes {
+		manifest += fmt.Sprintf(`---
+apiVersion: node.k8s.io/v1beta1
+kind: RuntimeClass
+metadata:
+  name: %s
+handler: %s
+`, name, handler)
+	}
+	// Not applyManifest, there's nothing to wait for and our client
+	// doesn't know about RuntimeClasses.
+	if err := c.controller.WriteFile("/tmp/runtimeclasses.yaml", []byte(manifest)); err != nil {
+		return err
+	}
+	if _, err := c.controller.Run("KUBECONFIG=/etc/kubernetes/admin.conf kubectl apply -f /tmp/runtimeclasses.yaml"); err != nil {
+		return fmt.Errorf("creating RuntimeClasses: %v", err)
+	}
+	return nil
+}
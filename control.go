@@ -0,0 +1,761 @@
+package virtuakube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// A running universe serves a control socket in its directory, so
+// that other processes can inspect and drive it without opening the
+// universe a second time (which would start a second set of VMs on
+// the same disks).
+const (
+	controlSocket = "control.sock"
+	runningFile   = "running.json"
+)
+
+// runningInfo is written to the universe directory while the
+// universe is open.
+type runningInfo struct {
+	PID      int
+	Snapshot string
+	Started  time.Time
+}
+
+// controlRequest is a single request on the control socket. Each
+// connection carries one request and one response.
+type controlRequest struct {
+	Op       string
+	VM       string
+	Command  string
+	Snapshot string
+	Disk     DiskSpec
+	// For throttle, the extra disk to throttle, counting from 1, or
+	// 0 for the root disk.
+	ExtraDisk int
+	// For rename-snapshot.
+	NewSnapshot string
+	// For clone, the new VM's name.
+	NewVM string
+	// For attach-nic, the network to attach the NIC to. For
+	// detach-nic, the NIC's ID.
+	Network string
+	NIC     string
+	// For apply, the spec to reconcile the universe to, and whether
+	// to prune what it doesn't describe.
+	Spec  *Spec
+	Prune bool
+	// For add-node, remove-node, load-image, upgrade, trust-ca,
+	// service-url, pause and resume.
+	Cluster   string
+	Image     string
+	MemoryMiB int
+	CPUs      int
+	// For load-image, the host path of an image archive to load
+	// instead of Image.
+	Path string
+	// For set-memory, the VM's new memory in bytes.
+	Memory int64
+	// For set-clock, the VM's new clock offset.
+	ClockOffset time.Duration
+	// For reboot, whether to reset the VM rather than reboot it
+	// cleanly.
+	Hard bool
+	// For fill-disk, the disk usage to fill to.
+	Percent int
+	// For stress-cpu and stress-memory, how long to stress for. The
+	// number of CPU workers is in CPUs, the memory size in Memory.
+	Duration time.Duration
+	// For upgrade, the cluster's new Kubernetes version.
+	Version string
+	// For trust-ca, the PEM CA certificate.
+	CA []byte
+	// For service-url, the Service's namespace and name.
+	Namespace string
+	Service   string
+	// For impair, the VM at the other end of the link.
+	Peer string
+	Link LinkSpec
+}
+
+type controlResponse struct {
+	Error string
+	// ErrorKinds names the exported errors that Error matches, see
+	// remoteErrors.
+	ErrorKinds []string
+	Output     []byte
+	Status     *UniverseStatus
+	// For gc.
+	GC *GCResult
+}
+
+// serveControl starts serving the control socket for u.
+func (u *Universe) serveControl() error {
+	bs, err := json.Marshal(runningInfo{
+		PID:      os.Getpid(),
+		Snapshot: u.activeSnapshot,
+		Started:  u.startTime,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(u.dir, runningFile), bs, 0600); err != nil {
+		return fmt.Errorf("writing running universe info: %v", err)
+	}
+
+	sock := filepath.Join(u.dir, controlSocket)
+	// A stale socket from a crashed run would make Listen fail.
+	os.Remove(sock)
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return fmt.Errorf("listening on control socket: %v", err)
+	}
+	u.control = l
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go u.handleControl(conn)
+		}
+	}()
+
+	return nil
+}
+
+// stopControl stops serving the control socket, and removes the
+// universe's running markers.
+func (u *Universe) stopControl() {
+	if u.control == nil {
+		return
+	}
+	u.control.Close()
+	os.Remove(filepath.Join(u.dir, controlSocket))
+	os.Remove(filepath.Join(u.dir, runningFile))
+}
+
+func (u *Universe) handleControl(conn net.Conn) {
+	defer conn.Close()
+
+	var req controlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	var resp controlResponse
+	if err := u.doControl(&req, &resp); err != nil {
+		resp.Error = err.Error()
+		resp.ErrorKinds = errorKinds(err)
+	}
+	json.NewEncoder(conn).Encode(&resp)
+}
+
+func (u *Universe) doControl(req *controlRequest, resp *controlResponse) error {
+	switch req.Op {
+	case "status":
+		resp.Status = u.Status()
+	case "gc":
+		ret, err := u.GC()
+		if err != nil {
+			return err
+		}
+		resp.GC = ret
+	case "run":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		out, err := vm.Run(req.Command)
+		resp.Output = out
+		return err
+	case "guest-exec":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		out, err := vm.GuestExec(context.Background(), req.Command)
+		resp.Output = out
+		return err
+	case "throttle":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		if req.ExtraDisk != 0 {
+			return vm.SetExtraDiskLimits(req.ExtraDisk, req.Disk)
+		}
+		return vm.SetDiskLimits(req.Disk)
+	case "set-memory":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		return vm.SetMemory(req.Memory)
+	case "set-clock":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		return vm.SetClockOffset(req.ClockOffset)
+	case "screenshot":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		img, err := vm.Screenshot()
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		resp.Output = buf.Bytes()
+	case "reboot":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		if req.Hard {
+			return vm.Reset(context.Background())
+		}
+		return vm.Reboot(context.Background())
+	case "kill-vm", "fill-disk", "stress-cpu", "stress-memory", "stop-stress":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		switch req.Op {
+		case "kill-vm":
+			return vm.Kill()
+		case "fill-disk":
+			return vm.FillDisk(req.Percent)
+		case "stress-cpu":
+			return vm.StressCPU(req.CPUs, req.Duration)
+		case "stress-memory":
+			return vm.StressMemory(req.Memory, req.Duration)
+		default:
+			return vm.StopStress()
+		}
+	case "kill-random-node":
+		cluster := u.Cluster(req.Cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", req.Cluster)
+		}
+		node, err := cluster.KillRandomNode()
+		if err != nil {
+			return err
+		}
+		resp.Output = []byte(node)
+	case "impair":
+		return u.ImpairLink(req.VM, req.Peer, req.Link)
+	case "pause", "resume":
+		return u.pauseOrResume(req.Op == "pause", req.VM, req.Cluster)
+	case "delete-instance":
+		for _, cluster := range u.Clusters() {
+			if cloud := cluster.Cloud(); cloud != nil && cloud.InstanceExists(cloud.ProviderID(req.VM)) {
+				return cloud.DeleteInstance(req.VM)
+			}
+		}
+		return fmt.Errorf("no fake cloud cluster has a node named %q", req.VM)
+	case "add-node":
+		cluster := u.Cluster(req.Cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", req.Cluster)
+		}
+		node, err := cluster.AddNode(context.Background(), &VMConfig{
+			Name:      req.VM,
+			Image:     req.Image,
+			MemoryMiB: req.MemoryMiB,
+			CPUs:      req.CPUs,
+		})
+		if err != nil {
+			return err
+		}
+		resp.Output = []byte(node.Hostname())
+	case "apply":
+		return u.Reconcile(context.Background(), req.Spec, req.Prune)
+	case "attach-nic", "detach-nic":
+		vm := u.VM(req.VM)
+		if vm == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		if req.Op == "detach-nic" {
+			return vm.DetachNIC(req.NIC)
+		}
+		nic, err := vm.AttachNIC(req.Network)
+		if err != nil {
+			return err
+		}
+		resp.Output = []byte(nic.ID)
+	case "clone":
+		src := u.VM(req.VM)
+		if src == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", req.VM)
+		}
+		vm, err := u.CloneVM(context.Background(), src, req.NewVM)
+		if err != nil {
+			return err
+		}
+		resp.Output = []byte(vm.Hostname())
+	case "remove-node":
+		cluster := u.Cluster(req.Cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", req.Cluster)
+		}
+		return cluster.RemoveNode(req.VM)
+	case "upgrade":
+		cluster := u.Cluster(req.Cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", req.Cluster)
+		}
+		return cluster.Upgrade(context.Background(), req.Version)
+	case "trust-ca":
+		cluster := u.Cluster(req.Cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", req.Cluster)
+		}
+		return cluster.TrustCA(req.CA)
+	case "service-url":
+		cluster := u.Cluster(req.Cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", req.Cluster)
+		}
+		url, err := cluster.ServiceURL(req.Namespace, req.Service)
+		if err != nil {
+			return err
+		}
+		resp.Output = []byte(url)
+	case "load-image":
+		cluster := u.Cluster(req.Cluster)
+		if cluster == nil {
+			return fmt.Errorf("universe doesn't have a cluster named %q", req.Cluster)
+		}
+		if req.Path != "" {
+			return cluster.LoadImageArchive(req.Path)
+		}
+		return cluster.LoadImage(req.Image)
+	case "delete-snapshot":
+		return u.DeleteSnapshot(req.Snapshot)
+	case "rename-snapshot":
+		return u.RenameSnapshot(req.Snapshot, req.NewSnapshot)
+	case "save":
+		return u.Save(context.Background(), req.Snapshot)
+	case "close":
+		return u.Close()
+	case "kill":
+		u.Kill()
+		// The kill makes in-flight operations fail and release the
+		// universe, but don't hold up the response on it.
+		go u.Close()
+	default:
+		return fmt.Errorf("unknown control operation %q", req.Op)
+	}
+	return nil
+}
+
+// pauseOrResume pauses or resumes the named VM, or if vm is empty,
+// the named cluster.
+func (u *Universe) pauseOrResume(pause bool, vm, cluster string) error {
+	if vm != "" {
+		v := u.VM(vm)
+		if v == nil {
+			return fmt.Errorf("universe doesn't have a VM named %q", vm)
+		}
+		if pause {
+			return v.Pause()
+		}
+		return v.Resume()
+	}
+	c := u.Cluster(cluster)
+	if c == nil {
+		return fmt.Errorf("universe doesn't have a cluster named %q", cluster)
+	}
+	if pause {
+		return c.Pause()
+	}
+	return c.Resume()
+}
+
+// ErrNotRunning is returned by Attach when no process has the
+// universe open.
+var ErrNotRunning = errors.New("universe is not running")
+
+// RemoteUniverse is a handle on a universe that is open in another
+// process.
+type RemoteUniverse struct {
+	dir  string
+	info runningInfo
+}
+
+// Attach connects to the universe in dir, which must be open in
+// another process. It returns ErrNotRunning if the universe isn't
+// currently open.
+func Attach(dir string) (*RemoteUniverse, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, runningFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNotRunning
+	} else if err != nil {
+		return nil, err
+	}
+
+	ret := &RemoteUniverse{dir: dir}
+	if err := json.Unmarshal(bs, &ret.info); err != nil {
+		return nil, fmt.Errorf("parsing running universe info: %v", err)
+	}
+
+	// The running file might be left over from a crashed process,
+	// so make sure someone's actually answering.
+	if _, err := ret.Status(); err != nil {
+		return nil, ErrNotRunning
+	}
+
+	return ret, nil
+}
+
+// PID returns the process ID of the process running the universe.
+func (r *RemoteUniverse) PID() int {
+	return r.info.PID
+}
+
+// Status returns a summary of the remote universe's resources.
+func (r *RemoteUniverse) Status() (*UniverseStatus, error) {
+	resp, err := r.call(&controlRequest{Op: "status"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Status, nil
+}
+
+// Run runs command as root on the named VM, and returns its output.
+func (r *RemoteUniverse) Run(vm, command string) ([]byte, error) {
+	resp, err := r.call(&controlRequest{Op: "run", VM: vm, Command: command})
+	if resp != nil {
+		return resp.Output, err
+	}
+	return nil, err
+}
+
+// GuestExec runs command on the named VM through its guest agent, as
+// VM.GuestExec does, and returns its output.
+func (r *RemoteUniverse) GuestExec(vm, command string) ([]byte, error) {
+	resp, err := r.call(&controlRequest{Op: "guest-exec", VM: vm, Command: command})
+	if resp != nil {
+		return resp.Output, err
+	}
+	return nil, err
+}
+
+// SetDiskLimits changes the I/O limits of the named VM's root disk.
+func (r *RemoteUniverse) SetDiskLimits(vm string, spec DiskSpec) error {
+	_, err := r.call(&controlRequest{Op: "throttle", VM: vm, Disk: spec})
+	return err
+}
+
+// SetExtraDiskLimits changes the I/O limits of the named VM's extra
+// disk n, as VM.SetExtraDiskLimits does.
+func (r *RemoteUniverse) SetExtraDiskLimits(vm string, n int, spec DiskSpec) error {
+	_, err := r.call(&controlRequest{Op: "throttle", VM: vm, Disk: spec, ExtraDisk: n})
+	return err
+}
+
+// SetMemory resizes the memory of the named VM to size bytes, as
+// VM.SetMemory does.
+func (r *RemoteUniverse) SetMemory(vm string, size int64) error {
+	_, err := r.call(&controlRequest{Op: "set-memory", VM: vm, Memory: size})
+	return err
+}
+
+// SetClockOffset sets the clock offset of the named VM to d, as
+// VM.SetClockOffset does.
+func (r *RemoteUniverse) SetClockOffset(vm string, d time.Duration) error {
+	_, err := r.call(&controlRequest{Op: "set-clock", VM: vm, ClockOffset: d})
+	return err
+}
+
+// Screenshot captures the named VM's screen, as VM.Screenshot does,
+// and returns it as a PNG image.
+func (r *RemoteUniverse) Screenshot(vm string) ([]byte, error) {
+	resp, err := r.call(&controlRequest{Op: "screenshot", VM: vm})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Output, nil
+}
+
+// Reboot reboots the named VM, as VM.Reboot does, or resets it as
+// VM.Reset does if hard is set.
+func (r *RemoteUniverse) Reboot(vm string, hard bool) error {
+	_, err := r.call(&controlRequest{Op: "reboot", VM: vm, Hard: hard})
+	return err
+}
+
+// KillVM kills the named VM, as VM.Kill does.
+func (r *RemoteUniverse) KillVM(vm string) error {
+	_, err := r.call(&controlRequest{Op: "kill-vm", VM: vm})
+	return err
+}
+
+// FillDisk fills the root filesystem of the named VM to percent, as
+// VM.FillDisk does.
+func (r *RemoteUniverse) FillDisk(vm string, percent int) error {
+	_, err := r.call(&controlRequest{Op: "fill-disk", VM: vm, Percent: percent})
+	return err
+}
+
+// StressCPU stresses the named VM's CPUs, as VM.StressCPU does.
+func (r *RemoteUniverse) StressCPU(vm string, workers int, d time.Duration) error {
+	_, err := r.call(&controlRequest{Op: "stress-cpu", VM: vm, CPUs: workers, Duration: d})
+	return err
+}
+
+// StressMemory stresses the named VM's memory, as VM.StressMemory
+// does.
+func (r *RemoteUniverse) StressMemory(vm string, size int64, d time.Duration) error {
+	_, err := r.call(&controlRequest{Op: "stress-memory", VM: vm, Memory: size, Duration: d})
+	return err
+}
+
+// StopStress stops the stress on the named VM, as VM.StopStress does.
+func (r *RemoteUniverse) StopStress(vm string) error {
+	_, err := r.call(&controlRequest{Op: "stop-stress", VM: vm})
+	return err
+}
+
+// KillRandomNode kills a random worker node of the named cluster, as
+// Cluster.KillRandomNode does, and returns its name.
+func (r *RemoteUniverse) KillRandomNode(cluster string) (string, error) {
+	resp, err := r.call(&controlRequest{Op: "kill-random-node", Cluster: cluster})
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Output), nil
+}
+
+// SSH opens a new SSH connection as root to the named VM, over its
+// forwarded SSH port. The caller must close it.
+func (r *RemoteUniverse) SSH(vm string) (*ssh.Client, error) {
+	st, err := r.Status()
+	if err != nil {
+		return nil, err
+	}
+	key, err := r.sshKey()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range st.VMs {
+		if v.Name == vm {
+			return dialVM(v.Ports[22], key)
+		}
+	}
+	return nil, fmt.Errorf("universe doesn't have a VM named %q", vm)
+}
+
+// ImpairLink applies spec to the link between the VMs a and b.
+func (r *RemoteUniverse) ImpairLink(a, b string, spec LinkSpec) error {
+	_, err := r.call(&controlRequest{Op: "impair", VM: a, Peer: b, Link: spec})
+	return err
+}
+
+// Pause pauses the named VM.
+func (r *RemoteUniverse) Pause(vm string) error {
+	_, err := r.call(&controlRequest{Op: "pause", VM: vm})
+	return err
+}
+
+// Resume resumes the named VM.
+func (r *RemoteUniverse) Resume(vm string) error {
+	_, err := r.call(&controlRequest{Op: "resume", VM: vm})
+	return err
+}
+
+// PauseCluster pauses all the VMs of the named cluster.
+func (r *RemoteUniverse) PauseCluster(cluster string) error {
+	_, err := r.call(&controlRequest{Op: "pause", Cluster: cluster})
+	return err
+}
+
+// ResumeCluster resumes all the VMs of the named cluster.
+func (r *RemoteUniverse) ResumeCluster(cluster string) error {
+	_, err := r.call(&controlRequest{Op: "resume", Cluster: cluster})
+	return err
+}
+
+// DeleteCloudInstance simulates the deletion of node's cloud
+// instance, in whichever fake cloud cluster it belongs to.
+func (r *RemoteUniverse) DeleteCloudInstance(node string) error {
+	_, err := r.call(&controlRequest{Op: "delete-instance", VM: node})
+	return err
+}
+
+// AddNode adds a worker node to the named cluster, and returns the
+// new node's name. Empty name, image and zero memoryMiB and cpus
+// default to the cluster's node template.
+func (r *RemoteUniverse) AddNode(cluster, name, image string, memoryMiB, cpus int) (string, error) {
+	resp, err := r.call(&controlRequest{Op: "add-node", Cluster: cluster, VM: name, Image: image, MemoryMiB: memoryMiB, CPUs: cpus})
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Output), nil
+}
+
+// Reconcile brings the universe towards spec, as Universe.Reconcile
+// does. Relative paths in spec are resolved by the process running
+// the universe, use the absolute ones that ReadSpec makes.
+func (r *RemoteUniverse) Reconcile(spec *Spec, prune bool) error {
+	_, err := r.call(&controlRequest{Op: "apply", Spec: spec, Prune: prune})
+	return err
+}
+
+// AttachNIC hot-plugs a NIC on network into the named VM, as
+// VM.AttachNIC does, and returns the NIC's ID.
+func (r *RemoteUniverse) AttachNIC(vm, network string) (string, error) {
+	resp, err := r.call(&controlRequest{Op: "attach-nic", VM: vm, Network: network})
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Output), nil
+}
+
+// DetachNIC hot-unplugs the NIC with the given ID from the named VM,
+// as VM.DetachNIC does.
+func (r *RemoteUniverse) DetachNIC(vm, id string) error {
+	_, err := r.call(&controlRequest{Op: "detach-nic", VM: vm, NIC: id})
+	return err
+}
+
+// CloneVM clones the named VM, as Universe.CloneVM does, and returns
+// the clone's name. An empty name gets the clone a random name.
+func (r *RemoteUniverse) CloneVM(vm, name string) (string, error) {
+	resp, err := r.call(&controlRequest{Op: "clone", VM: vm, NewVM: name})
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Output), nil
+}
+
+// RemoveNode drains and removes the named worker node from cluster.
+func (r *RemoteUniverse) RemoveNode(cluster, node string) error {
+	_, err := r.call(&controlRequest{Op: "remove-node", Cluster: cluster, VM: node})
+	return err
+}
+
+// Upgrade upgrades the named cluster to Kubernetes version, as
+// Cluster.Upgrade does.
+func (r *RemoteUniverse) Upgrade(cluster, version string) error {
+	_, err := r.call(&controlRequest{Op: "upgrade", Cluster: cluster, Version: version})
+	return err
+}
+
+// TrustCA adds the PEM CA certificate to the trust store of the
+// named cluster's nodes, as Cluster.TrustCA does.
+func (r *RemoteUniverse) TrustCA(cluster string, cert []byte) error {
+	_, err := r.call(&controlRequest{Op: "trust-ca", Cluster: cluster, CA: cert})
+	return err
+}
+
+// ServiceURL returns the localhost URL of the Service name in
+// namespace ns of the named cluster, as Cluster.ServiceURL does.
+func (r *RemoteUniverse) ServiceURL(cluster, ns, name string) (string, error) {
+	resp, err := r.call(&controlRequest{Op: "service-url", Cluster: cluster, Namespace: ns, Service: name})
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Output), nil
+}
+
+// LoadImage loads the host container image ref into the nodes of
+// the named cluster.
+func (r *RemoteUniverse) LoadImage(cluster, ref string) error {
+	_, err := r.call(&controlRequest{Op: "load-image", Cluster: cluster, Image: ref})
+	return err
+}
+
+// LoadImageArchive loads the container image archive at path, which
+// the remote universe's process must be able to read, into the nodes
+// of the named cluster.
+func (r *RemoteUniverse) LoadImageArchive(cluster, path string) error {
+	_, err := r.call(&controlRequest{Op: "load-image", Cluster: cluster, Path: path})
+	return err
+}
+
+// GC removes the files that crashed runs left in the remote
+// universe's directory, as Universe.GC does.
+func (r *RemoteUniverse) GC() (*GCResult, error) {
+	resp, err := r.call(&controlRequest{Op: "gc"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GC, nil
+}
+
+// DeleteSnapshot deletes a snapshot of the remote universe.
+func (r *RemoteUniverse) DeleteSnapshot(name string) error {
+	_, err := r.call(&controlRequest{Op: "delete-snapshot", Snapshot: name})
+	return err
+}
+
+// RenameSnapshot renames a snapshot of the remote universe.
+func (r *RemoteUniverse) RenameSnapshot(oldName, newName string) error {
+	_, err := r.call(&controlRequest{Op: "rename-snapshot", Snapshot: oldName, NewSnapshot: newName})
+	return err
+}
+
+// Save asks the running process to save the universe to snapshot
+// and close it.
+func (r *RemoteUniverse) Save(snapshot string) error {
+	_, err := r.call(&controlRequest{Op: "save", Snapshot: snapshot})
+	return err
+}
+
+// Close asks the running process to close the universe, discarding
+// changes since the last save.
+func (r *RemoteUniverse) Close() error {
+	_, err := r.call(&controlRequest{Op: "close"})
+	return err
+}
+
+// Kill asks the running process to kill the universe's VMs and
+// networks immediately, discarding changes since the last save.
+func (r *RemoteUniverse) Kill() error {
+	_, err := r.call(&controlRequest{Op: "kill"})
+	return err
+}
+
+func (r *RemoteUniverse) call(req *controlRequest) (*controlResponse, error) {
+	conn, err := net.Dial("unix", filepath.Join(r.dir, controlSocket))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return &resp, newRemoteError(resp.Error, resp.ErrorKinds)
+	}
+	return &resp, nil
+}
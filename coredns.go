@@ -0,0 +1,162 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validateCorefile checks that s is a well-formed Corefile fragment:
+// a sequence of server blocks, each one or more zone keys followed by
+// a brace-delimited body. The fragment is added alongside kubeadm's
+// stock server block, so it must not redefine the root zone.
+func validateCorefile(s string) error {
+	toks := corefileTokens(s)
+	if len(toks) == 0 {
+		return errors.New("empty Corefile fragment")
+	}
+
+	for i := 0; i < len(toks); {
+		var keys []string
+		for i < len(toks) && toks[i] != "{" {
+			if toks[i] == "}" {
+				return errors.New("unexpected '}' outside a server block")
+			}
+			keys = append(keys, toks[i])
+			i++
+		}
+		if len(keys) == 0 {
+			return errors.New("server block has no zone")
+		}
+		if i == len(toks) {
+			return fmt.Errorf("server block for %s has no body", strings.Join(keys, " "))
+		}
+		for _, key := range keys {
+			if err := validateServerKey(key); err != nil {
+				return err
+			}
+		}
+
+		depth := 0
+		for ; i < len(toks); i++ {
+			if toks[i] == "{" {
+				depth++
+			} else if toks[i] == "}" {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
+		}
+		if depth != 0 {
+			return fmt.Errorf("unclosed server block for %s", strings.Join(keys, " "))
+		}
+		i++
+	}
+
+	return nil
+}
+
+func validateServerKey(key string) error {
+	zone := key
+	for _, scheme := range []string{"dns://", "tls://", "grpc://", "https://"} {
+		zone = strings.TrimPrefix(zone, scheme)
+	}
+	if idx := strings.LastIndex(zone, ":"); idx != -1 {
+		port := zone[idx+1:]
+		if port == "" || strings.IndexFunc(port, func(r rune) bool { return !unicode.IsDigit(r) }) != -1 {
+			return fmt.Errorf("invalid port in server key %q", key)
+		}
+		zone = zone[:idx]
+	}
+	if zone == "" || zone == "." {
+		return fmt.Errorf("server key %q redefines the root zone, which kubeadm's Corefile already serves", key)
+	}
+	if strings.ContainsAny(zone, "{}\"") {
+		return fmt.Errorf("invalid zone in server key %q", key)
+	}
+	return nil
+}
+
+// corefileTokens splits a Corefile into tokens, dropping comments.
+// Braces are always tokens of their own. Quoted strings are kept
+// whole.
+func corefileTokens(s string) []string {
+	var (
+		ret []string
+		cur strings.Builder
+	)
+	flush := func() {
+		if cur.Len() > 0 {
+			ret = append(ret, cur.String())
+			cur.Reset()
+		}
+	}
+
+	inQuote, inComment := false, false
+	for _, r := range s {
+		switch {
+		case inComment:
+			if r == '\n' {
+				inComment = false
+			}
+		case inQuote:
+			cur.WriteRune(r)
+			if r == '"' {
+				inQuote = false
+			}
+		case r == '"':
+			cur.WriteRune(r)
+			inQuote = true
+		case r == '#':
+			flush()
+			inComment = true
+		case r == '{' || r == '}':
+			flush()
+			ret = append(ret, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+
+	return ret
+}
+
+// installCoreDNSConfig adds the cluster's custom Corefile fragment to
+// the CoreDNS ConfigMap, and restarts CoreDNS to pick it up.
+func (c *Cluster) installCoreDNSConfig(fragment string) error {
+	cms := c.client.CoreV1().ConfigMaps("kube-system")
+	cm, err := cms.Get("coredns", metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting CoreDNS config: %v", err)
+	}
+	cm.Data["Corefile"] = strings.TrimRight(cm.Data["Corefile"], "\n") + "\n" + strings.TrimRight(fragment, "\n") + "\n"
+	if _, err := cms.Update(cm); err != nil {
+		return fmt.Errorf("updating CoreDNS config: %v", err)
+	}
+
+	if c.cfg.CNI == "" {
+		// CoreDNS can't be running yet, and will pick up the config
+		// whenever it starts.
+		return nil
+	}
+
+	pods := c.client.CoreV1().Pods("kube-system")
+	if err := pods.DeleteCollection(&metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: "k8s-app=kube-dns"}); err != nil {
+		return fmt.Errorf("restarting CoreDNS: %v", err)
+	}
+	return c.WaitFor(context.Background(), func() (bool, error) {
+		deploy, err := c.client.AppsV1().Deployments("kube-system").Get("coredns", metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return deploy.Status.UpdatedReplicas == deploy.Status.Replicas && deploy.Status.AvailableReplicas == deploy.Status.Replicas, nil
+	})
+}
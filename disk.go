@@ -0,0 +1,218 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// rootDiskID is the QEMU drive ID of a VM's boot disk.
+const rootDiskID = "disk0"
+
+// diskID returns the QEMU drive ID of a VM's extra disk n, counting
+// from 1, or of its boot disk for 0.
+func diskID(n int) string {
+	return fmt.Sprintf("disk%d", n)
+}
+
+// maxDisks is the number of extra disks a VM can have. They sit in
+// the PCI slots below the cloud-init seed's.
+const maxDisks = 8
+
+// DiskConfig is the configuration of an extra blank disk for a VM,
+// e.g. to give CSI drivers or LVM something to manage.
+type DiskConfig struct {
+	SizeMiB int
+	// Format is the disk image format, "qcow2" (the default) or
+	// "raw". Raw disks can't hold snapshots, so universes with raw
+	// disks can't be saved.
+	Format string
+	// Serial is the disk's serial number, at most 20 characters.
+	// Disks with a serial show up in the guest as
+	// /dev/disk/by-id/virtio-<Serial>.
+	Serial string
+	// DiskSpec sets I/O limits for the disk. They can be changed
+	// at runtime with VM.SetExtraDiskLimits.
+	DiskSpec
+}
+
+func (d *DiskConfig) validate() error {
+	if d.SizeMiB <= 0 {
+		return fmt.Errorf("invalid disk size %dMiB", d.SizeMiB)
+	}
+	switch d.Format {
+	case "", "qcow2", "raw":
+	default:
+		return fmt.Errorf("unsupported disk format %q, must be qcow2 or raw", d.Format)
+	}
+	if len(d.Serial) > 20 || strings.ContainsAny(d.Serial, ", =") {
+		return fmt.Errorf("invalid disk serial %q, must be at most 20 characters with no commas, spaces or equal signs", d.Serial)
+	}
+	return d.DiskSpec.validate()
+}
+
+func (d *DiskConfig) toConfig() config.Disk {
+	ret := config.Disk{
+		Format:  d.Format,
+		SizeMiB: d.SizeMiB,
+		Serial:  d.Serial,
+		Limits:  d.DiskSpec.toConfig(),
+	}
+	if ret.Format == "" {
+		ret.Format = "qcow2"
+	}
+	return ret
+}
+
+func diskConfigFromConfig(c config.Disk) DiskConfig {
+	return DiskConfig{
+		SizeMiB:  c.SizeMiB,
+		Format:   c.Format,
+		Serial:   c.Serial,
+		DiskSpec: diskSpecFromConfig(c.Limits),
+	}
+}
+
+// validateDisks checks a VM's extra disks.
+func validateDisks(disks []DiskConfig) error {
+	if len(disks) > maxDisks {
+		return fmt.Errorf("too many disks, VMs can have %d extra disks at most", maxDisks)
+	}
+	for i := range disks {
+		if err := disks[i].validate(); err != nil {
+			return fmt.Errorf("disk %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// diskArgs returns the qemu arguments that attach a VM's extra disks.
+func diskArgs(disks []config.Disk) []string {
+	var ret []string
+	for i, d := range disks {
+		id := diskID(i + 1)
+		dev := fmt.Sprintf("virtio-blk-pci,drive=%s,addr=0x%x", id, seedPCISlot-1-i)
+		if d.Serial != "" {
+			dev += ",serial=" + d.Serial
+		}
+		ret = append(ret,
+			"-drive", fmt.Sprintf("if=none,file=%s,format=%s,id=%s%s", d.File, d.Format, id, throttleOptions(d.Limits)),
+			"-device", dev,
+		)
+	}
+	return ret
+}
+
+// DiskSpec configures I/O throttling for a VM disk, e.g. to reproduce
+// the behavior of etcd on slow storage. Zero values mean unthrottled.
+type DiskSpec struct {
+	// IOPSLimit caps the disk's combined read and write operations
+	// per second.
+	IOPSLimit int
+	// BandwidthLimit caps the disk's combined read and write
+	// throughput, in bytes per second.
+	BandwidthLimit int64
+}
+
+func (d DiskSpec) validate() error {
+	if d.IOPSLimit < 0 {
+		return fmt.Errorf("invalid disk IOPS limit %d", d.IOPSLimit)
+	}
+	if d.BandwidthLimit < 0 {
+		return fmt.Errorf("invalid disk bandwidth limit %d", d.BandwidthLimit)
+	}
+	return nil
+}
+
+func (d DiskSpec) toConfig() config.DiskLimits {
+	return config.DiskLimits{
+		IOPS:      d.IOPSLimit,
+		Bandwidth: d.BandwidthLimit,
+	}
+}
+
+func diskSpecFromConfig(c config.DiskLimits) DiskSpec {
+	return DiskSpec{
+		IOPSLimit:      c.IOPS,
+		BandwidthLimit: c.Bandwidth,
+	}
+}
+
+// throttleOptions returns the -drive options that apply limits.
+func throttleOptions(limits config.DiskLimits) string {
+	ret := ""
+	if limits.IOPS > 0 {
+		ret += fmt.Sprintf(",throttling.iops-total=%d", limits.IOPS)
+	}
+	if limits.Bandwidth > 0 {
+		ret += fmt.Sprintf(",throttling.bps-total=%d", limits.Bandwidth)
+	}
+	return ret
+}
+
+// DiskLimits returns the current I/O limits of the VM's root disk.
+func (v *VM) DiskLimits() DiskSpec {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return diskSpecFromConfig(v.cfg.DiskLimits)
+}
+
+// SetDiskLimits changes the I/O limits of the VM's root disk. It
+// takes effect immediately, and persists if the universe is saved.
+// Extra disks are throttled with SetExtraDiskLimits.
+func (v *VM) SetDiskLimits(spec DiskSpec) error {
+	return v.setDiskLimits(0, spec)
+}
+
+// ExtraDiskLimits returns the current I/O limits of the VM's extra
+// disk n, counting from 1 in the order of VMConfig.Disks.
+func (v *VM) ExtraDiskLimits(n int) (DiskSpec, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if n < 1 || n > len(v.cfg.Disks) {
+		return DiskSpec{}, fmt.Errorf("VM has no disk %d, it has %d extra disks", n, len(v.cfg.Disks))
+	}
+	return diskSpecFromConfig(v.cfg.Disks[n-1].Limits), nil
+}
+
+// SetExtraDiskLimits changes the I/O limits of the VM's extra disk n,
+// counting from 1 in the order of VMConfig.Disks, like
+// SetDiskLimits.
+func (v *VM) SetExtraDiskLimits(n int, spec DiskSpec) error {
+	if n < 1 {
+		return fmt.Errorf("invalid disk number %d, extra disks count from 1", n)
+	}
+	return v.setDiskLimits(n, spec)
+}
+
+// setDiskLimits changes the I/O limits of the VM's root disk if n is
+// 0, or of its extra disk n otherwise.
+func (v *VM) setDiskLimits(n int, spec DiskSpec) error {
+	if err := spec.validate(); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("VM is closed")
+	}
+	if n > len(v.cfg.Disks) {
+		return fmt.Errorf("VM has no disk %d, it has %d extra disks", n, len(v.cfg.Disks))
+	}
+
+	// Arguments are total, read and write bandwidth, then total,
+	// read and write IOPS. Zero disables the limit.
+	cmd := fmt.Sprintf("block_set_io_throttle %s %d 0 0 %d 0 0", diskID(n), spec.BandwidthLimit, spec.IOPSLimit)
+	if _, err := v.monitorWithLock(cmd); err != nil {
+		return err
+	}
+	if n == 0 {
+		v.cfg.DiskLimits = spec.toConfig()
+	} else {
+		v.cfg.Disks[n-1].Limits = spec.toConfig()
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package virtuakube
+
+import (
+	"fmt"
+)
+
+// Ways that VMs can show their display, see UniverseConfig.Display.
+const (
+	// DisplayNone gives VMs no display, only their serial console.
+	DisplayNone = "none"
+	// DisplayLocal opens a window on the host for each VM.
+	DisplayLocal = "local"
+	// DisplayVNC serves each VM's display over VNC, on a localhost
+	// port.
+	DisplayVNC = "vnc"
+	// DisplaySPICE serves each VM's display over SPICE, on a
+	// localhost port.
+	DisplaySPICE = "spice"
+)
+
+// vncBasePort is the port of VNC display 0. QEMU only takes VNC
+// display numbers, so remote displays get ports from here up.
+const vncBasePort = 5900
+
+// displayPortKey is the port history key of the named VM's remote
+// display, which is sticky across runs like its port forwards.
+func displayPortKey(vm string) string {
+	return vm + ":display"
+}
+
+// validateDisplay checks the display mode of cfg.
+func (cfg *UniverseConfig) validateDisplay() error {
+	switch cfg.Display {
+	case "", DisplayNone, DisplayVNC, DisplaySPICE:
+		if cfg.VMGraphics && cfg.Display != "" {
+			return fmt.Errorf("UniverseConfig.VMGraphics conflicts with display mode %q", cfg.Display)
+		}
+	case DisplayLocal:
+	default:
+		return fmt.Errorf("unknown display mode %q, must be %s, %s, %s or %s", cfg.Display, DisplayNone, DisplayLocal, DisplayVNC, DisplaySPICE)
+	}
+	return nil
+}
+
+// displayMode returns the universe's display mode.
+func (u *Universe) displayMode() string {
+	switch {
+	case u.runtimecfg.Display != "":
+		return u.runtimecfg.Display
+	case u.runtimecfg.VMGraphics:
+		return DisplayLocal
+	default:
+		return DisplayNone
+	}
+}
+
+// displayArgsWithLock returns the qemu arguments that set up vm's
+// display, allocating a localhost port for remote displays.
+func (u *Universe) displayArgsWithLock(vm *VM) []string {
+	switch mode := u.displayMode(); mode {
+	case DisplayLocal:
+		return nil
+	case DisplayVNC, DisplaySPICE:
+		port := u.displayPortWithLock(vm.cfg.Name)
+		vm.display = fmt.Sprintf("%s://127.0.0.1:%d", mode, port)
+		if mode == DisplayVNC {
+			return []string{"-vnc", fmt.Sprintf("127.0.0.1:%d", port-vncBasePort)}
+		}
+		return []string{"-spice", fmt.Sprintf("addr=127.0.0.1,port=%d,disable-ticketing=on", port)}
+	default:
+		return []string{"-nographic"}
+	}
+}
+
+// displayPortWithLock returns a free localhost port for the named
+// VM's remote display, the one it had before if possible.
+func (u *Universe) displayPortWithLock(vm string) int {
+	key := displayPortKey(vm)
+	if prev, ok := u.ports[key]; ok && prev >= vncBasePort && u.portAvailableWithLock(prev, key) {
+		return prev
+	}
+	for port := vncBasePort; ; port++ {
+		if u.portAvailableWithLock(port, key) {
+			u.ports[key] = port
+			return port
+		}
+	}
+}
+
+// Display returns the URL of the VM's remote display, e.g.
+// "vnc://127.0.0.1:5900", or "" if the universe doesn't serve remote
+// displays. The display is only reachable from the host, and has no
+// password.
+func (v *VM) Display() string {
+	return v.display
+}
@@ -0,0 +1,299 @@
+package virtuakube
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsZone is the DNS zone that the universe's DNS server is
+// authoritative for.
+const dnsZone = "universe."
+
+// dnsPortKey is the port allocation key of the DNS server. It can't
+// collide with VM port keys, which always have a colon.
+const dnsPortKey = "dns"
+
+// dnsTTL is the TTL of DNS answers. It's short because VMs and
+// clusters come and go, and their ports can move between runs.
+const dnsTTL = 5
+
+// serveDNS starts the universe's DNS server on a localhost port,
+// which is reused across runs of the universe if possible.
+//
+// The server answers for the universe. zone:
+//
+//	<vm>.universe            the host address of the VM's forwarded ports
+//	<cluster>.universe       the host address of the cluster's API server
+//	<vm>.<network>.universe  the VM's IPv4 and IPv6 addresses on network
+//
+// and SRV records _<port>._tcp.<vm or cluster>.universe give the
+// localhost port that forwards to each VM port.
+func (u *Universe) serveDNS() error {
+	u.mu.Lock()
+	port, ok := u.ports[dnsPortKey]
+	if !ok || !u.portAvailableWithLock(port, dnsPortKey) {
+		port = u.freePortWithLock()
+		u.ports[dnsPortKey] = port
+	}
+	u.mu.Unlock()
+
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	pc, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("listening for DNS: %v", err)
+	}
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: addr.IP, Port: port})
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("listening for DNS: %v", err)
+	}
+	u.dnsUDP, u.dnsTCP = pc, l
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := u.answerDNS(buf[:n], 512); resp != nil {
+				pc.WriteTo(resp, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go u.serveDNSConn(conn)
+		}
+	}()
+	return nil
+}
+
+// serveDNSConn answers DNS queries on a TCP connection.
+func (u *Universe) serveDNSConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var sz uint16
+		if err := binary.Read(conn, binary.BigEndian, &sz); err != nil {
+			return
+		}
+		msg := make([]byte, sz)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		resp := u.answerDNS(msg, 65535)
+		if resp == nil {
+			return
+		}
+		if err := binary.Write(conn, binary.BigEndian, uint16(len(resp))); err != nil {
+			return
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// stopDNS stops the universe's DNS server, if any.
+func (u *Universe) stopDNS() {
+	if u.dnsUDP != nil {
+		u.dnsUDP.Close()
+		u.dnsTCP.Close()
+	}
+}
+
+// DNSAddr returns the localhost host:port of the universe's DNS
+// server, or "" if UniverseConfig.DNS is off.
+func (u *Universe) DNSAddr() string {
+	if u.dnsUDP == nil {
+		return ""
+	}
+	return u.dnsUDP.LocalAddr().String()
+}
+
+// DNS stub formats, for DNSStub.
+const (
+	DNSStubResolved = "resolved"
+	DNSStubDnsmasq  = "dnsmasq"
+)
+
+// DNSStub returns configuration that makes the host's resolver send
+// queries for the universe zone to the DNS server at addr. The
+// format is DNSStubResolved, for a systemd-resolved drop-in in
+// /etc/systemd/resolved.conf.d (systemd 246 or later), or
+// DNSStubDnsmasq, for a dnsmasq config file in /etc/dnsmasq.d.
+func DNSStub(format, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	zone := strings.TrimSuffix(dnsZone, ".")
+	switch format {
+	case DNSStubResolved:
+		return fmt.Sprintf("# Universe DNS, written by virtuakube.\n[Resolve]\nDNS=%s\nDomains=~%s\n", addr, zone), nil
+	case DNSStubDnsmasq:
+		return fmt.Sprintf("# Universe DNS, written by virtuakube.\nserver=/%s/%s#%s\n", zone, host, port), nil
+	default:
+		return "", fmt.Errorf("unknown DNS stub format %q", format)
+	}
+}
+
+// answerDNS returns the response to the DNS query msg, or nil if msg
+// isn't a query.
+func (u *Universe) answerDNS(msg []byte, maxLen int) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || h.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+
+	rh := dnsmessage.Header{
+		ID:               h.ID,
+		Response:         true,
+		Authoritative:    true,
+		RecursionDesired: h.RecursionDesired,
+		RCode:            dnsmessage.RCodeSuccess,
+	}
+	name := strings.ToLower(q.Name.String())
+	if h.OpCode != 0 {
+		rh.RCode = dnsmessage.RCodeNotImplemented
+	} else if name != dnsZone && !strings.HasSuffix(name, "."+dnsZone) {
+		rh.RCode = dnsmessage.RCodeRefused
+		rh.Authoritative = false
+	}
+
+	var answers []dnsmessage.Resource
+	if rh.RCode == dnsmessage.RCodeSuccess {
+		var found bool
+		answers, found = u.dnsRecords(strings.TrimSuffix(name, dnsZone), q)
+		if !found {
+			rh.RCode = dnsmessage.RCodeNameError
+		}
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), rh)
+	b.EnableCompression()
+	if b.StartQuestions() != nil || b.Question(q) != nil || b.StartAnswers() != nil {
+		return nil
+	}
+	for _, a := range answers {
+		var err error
+		switch body := a.Body.(type) {
+		case *dnsmessage.AResource:
+			err = b.AResource(a.Header, *body)
+		case *dnsmessage.AAAAResource:
+			err = b.AAAAResource(a.Header, *body)
+		case *dnsmessage.SRVResource:
+			err = b.SRVResource(a.Header, *body)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+	ret, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	if len(ret) > maxLen {
+		// Tell the client to retry over TCP.
+		rh.Truncated = true
+		b := dnsmessage.NewBuilder(make([]byte, 0, 512), rh)
+		if b.StartQuestions() != nil || b.Question(q) != nil {
+			return nil
+		}
+		if ret, err = b.Finish(); err != nil {
+			return nil
+		}
+	}
+	return ret
+}
+
+// dnsRecords returns the records of type q.Type for name, relative to
+// the universe zone and with a trailing dot, and whether name exists.
+func (u *Universe) dnsRecords(name string, q dnsmessage.Question) ([]dnsmessage.Resource, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	hdr := func(typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: q.Name, Type: typ, Class: dnsmessage.ClassINET, TTL: dnsTTL}
+	}
+	addrs := func(ips ...net.IP) []dnsmessage.Resource {
+		var ret []dnsmessage.Resource
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil && q.Type == dnsmessage.TypeA {
+				r := &dnsmessage.AResource{}
+				copy(r.A[:], ip4)
+				ret = append(ret, dnsmessage.Resource{Header: hdr(dnsmessage.TypeA), Body: r})
+			} else if ip4 == nil && ip != nil && q.Type == dnsmessage.TypeAAAA {
+				r := &dnsmessage.AAAAResource{}
+				copy(r.AAAA[:], ip)
+				ret = append(ret, dnsmessage.Resource{Header: hdr(dnsmessage.TypeAAAA), Body: r})
+			}
+		}
+		return ret
+	}
+
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	host := net.ParseIP(u.runtimecfg.KubeconfigHost)
+	if host == nil {
+		host = net.IPv4(127, 0, 0, 1)
+	}
+
+	switch {
+	case len(labels) == 1 && labels[0] == "":
+		// The zone apex.
+		return nil, true
+	case len(labels) == 1:
+		if u.vms[labels[0]] == nil && u.clusters[labels[0]] == nil {
+			return nil, false
+		}
+		return addrs(host), true
+	case len(labels) == 2 && u.vms[labels[0]] != nil:
+		vm := u.vms[labels[0]]
+		if vm.IPv4(labels[1]) == nil {
+			return nil, false
+		}
+		return addrs(vm.IPv4(labels[1]), vm.IPv6(labels[1])), true
+	case len(labels) == 3 && strings.HasPrefix(labels[0], "_") && labels[1] == "_tcp":
+		port, err := strconv.Atoi(labels[0][1:])
+		if err != nil {
+			return nil, false
+		}
+		vm, target := u.vms[labels[2]], labels[2]
+		if c := u.clusters[labels[2]]; c != nil && vm == nil {
+			if port != 6443 {
+				return nil, false
+			}
+			vm = c.apiServerVM()
+		}
+		if vm == nil || vm.ForwardedPort(port) == 0 {
+			return nil, false
+		}
+		if q.Type != dnsmessage.TypeSRV {
+			return nil, true
+		}
+		return []dnsmessage.Resource{{
+			Header: hdr(dnsmessage.TypeSRV),
+			Body: &dnsmessage.SRVResource{
+				Port:   uint16(vm.ForwardedPort(port)),
+				Target: dnsmessage.MustNewName(target + "." + dnsZone),
+			},
+		}}, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,268 @@
+package virtuakube
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// downloadAttempts is how many times a download is attempted
+	// before giving up. Each attempt resumes where the last one
+	// stopped.
+	downloadAttempts = 10
+	// maxDownloadBackoff caps the wait between download attempts.
+	maxDownloadBackoff = 30 * time.Second
+)
+
+// download downloads url to dst, reporting progress on target. The
+// download is kept in dst.partial until it's complete, and resumes
+// from there if it's interrupted, whether within this call or by a
+// previous one. If sum is set, the download must have that hex
+// SHA-256.
+//
+// Resumed downloads only continue if the file is unchanged since the
+// download started, according to its ETag or Last-Modified time,
+// which are kept in dst.partial.validator. Files that have neither
+// are only resumed with a sum, which catches a changed file.
+func (u *Universe) download(ctx context.Context, target, url, sum, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	partial := dst + ".partial"
+	prog := u.progress(target, "downloading "+path.Base(url), 0)
+
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		err = u.downloadOnce(ctx, url, partial, sum != "", prog)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return prog.done(ctx.Err())
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			break
+		}
+		backoff := time.Duration(attempt) * time.Second
+		if backoff > maxDownloadBackoff {
+			backoff = maxDownloadBackoff
+		}
+		u.log.Warn("download interrupted, resuming", "url", url, "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return prog.done(ctx.Err())
+		case <-time.After(backoff):
+		}
+	}
+	if err != nil {
+		return prog.done(fmt.Errorf("downloading %s: %v", url, err))
+	}
+
+	if sum != "" {
+		got, err := hashFile(partial)
+		if err != nil {
+			return prog.done(err)
+		}
+		if !strings.EqualFold(got, sum) {
+			// The partial file is no good to resume from either.
+			os.Remove(partial)
+			os.Remove(partial + ".validator")
+			return prog.done(fmt.Errorf("downloading %s: got SHA-256 %s, want %s", url, got, sum))
+		}
+	}
+	os.Remove(partial + ".validator")
+	return prog.done(os.Rename(partial, dst))
+}
+
+// downloadOnce makes one attempt at downloading url into partial,
+// continuing from what partial already has if the file hasn't
+// changed since, or if verified says that the download's sum is
+// checked afterwards.
+func (u *Universe) downloadOnce(ctx context.Context, url, partial string, verified bool, prog *progress) error {
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Universes sharing an image cache must not append to the same
+	// partial download.
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return &permanentError{errors.New("another process is downloading the same file")}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	have := fi.Size()
+	validatorFile := partial + ".validator"
+	validator := ""
+	if bs, err := ioutil.ReadFile(validatorFile); err == nil {
+		validator = string(bs)
+	}
+	if have > 0 && validator == "" && !verified {
+		// Nothing can tell whether the file changed since, so
+		// resuming could splice two different files.
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		have = 0
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if have > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", have))
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The server ignored the range, or the file changed, start
+		// over.
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		have = 0
+		prog.total = resp.ContentLength
+		if err := ioutil.WriteFile(validatorFile, []byte(rangeValidator(resp.Header)), 0644); err != nil {
+			return err
+		}
+	case http.StatusPartialContent:
+		prog.total = contentRangeSize(resp.Header.Get("Content-Range"))
+	case http.StatusRequestedRangeNotSatisfiable:
+		// partial already has the whole file.
+		if size := contentRangeSize(resp.Header.Get("Content-Range")); size == have {
+			return nil
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		return errors.New("partial download is longer than the file, starting over")
+	default:
+		err := fmt.Errorf("HTTP status %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			// Retrying won't fix the URL.
+			return &permanentError{err}
+		}
+		return err
+	}
+
+	w := &progressWriter{f, prog, have}
+	prog.update(have)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+	if prog.total > 0 && w.n != prog.total {
+		return fmt.Errorf("download ended after %d of %d bytes", w.n, prog.total)
+	}
+	return nil
+}
+
+// rangeValidator returns the value of an If-Range header that resumes
+// the download of the response with header only if the file is
+// unchanged, or "" if the response has no validator. If-Range takes
+// strong ETags only, so weak ones fall back to Last-Modified.
+func rangeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// contentRangeSize returns the complete length from a Content-Range
+// header like "bytes 100-199/200", or -1 if it's unknown.
+func contentRangeSize(header string) int64 {
+	i := strings.LastIndex(header, "/")
+	if i < 0 {
+		return -1
+	}
+	n, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// validateSHA256 checks that sum is empty or a hex SHA-256.
+func validateSHA256(sum string) error {
+	if sum == "" {
+		return nil
+	}
+	if bs, err := hex.DecodeString(sum); err != nil || len(bs) != sha256.Size {
+		return fmt.Errorf("invalid SHA-256 %q, must be 64 hex digits", sum)
+	}
+	return nil
+}
+
+// permanentError is a download error that retrying can't fix.
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+// ImportImageURL downloads the amd64 disk image at url, and imports
+// it as a new image like ImportImage. If sum is set, the image must
+// have that hex SHA-256.
+//
+// Interrupted downloads resume where they stopped, both within
+// ImportImageURL, which retries failures, and across calls, so that
+// a flaky network doesn't restart multi-gigabyte downloads from
+// scratch. Partial downloads are kept in the image cache, so
+// universes without one only resume within a call. With an image
+// cache and a sum, images that the cache already has aren't
+// downloaded at all, which is the only way offline universes can
+// import from URLs.
+func (u *Universe) ImportImageURL(ctx context.Context, name, url, sum string) error {
+	if err := validateSHA256(sum); err != nil {
+		return err
+	}
+	cache := u.imageCache()
+	dir := u.tmpdir
+	if cache != "" {
+		// Imported images are cached by their SHA-256.
+		cached := filepath.Join(cache, strings.ToLower(sum)+".qcow2")
+		if _, err := os.Stat(cached); err == nil && sum != "" {
+			return u.importCachedImage(name, cached, ArchAMD64)
+		}
+		dir = filepath.Join(cache, "downloads")
+	}
+	if u.runtimecfg.Offline {
+		return &MissingArtifactsError{[]string{fmt.Sprintf("image %q downloaded from %s with SHA-256 %q", name, url, sum)}}
+	}
+
+	// Once imported, the image is in the cache by content, so the
+	// download itself isn't kept.
+	key := sha256.Sum256([]byte(url))
+	dst := filepath.Join(dir, "image-"+hex.EncodeToString(key[:]))
+	if err := u.download(ctx, name, url, sum, dst); err != nil {
+		return err
+	}
+	defer os.Remove(dst)
+	return u.ImportImage(name, dst)
+}
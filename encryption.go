@@ -0,0 +1,259 @@
+package virtuakube
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// Secret encryption providers, for use in SecretEncryption.
+const (
+	EncryptionAESCBC    = "aescbc"
+	EncryptionSecretbox = "secretbox"
+)
+
+const (
+	// encryptionDir is where the EncryptionConfiguration lives on
+	// the controller. It's mounted into the kube-apiserver static
+	// pod.
+	encryptionDir = "/etc/kubernetes/encryption"
+	// apiServerRestartTimeout bounds how long the API server may
+	// take to come back after a key rotation.
+	apiServerRestartTimeout = 2 * time.Minute
+)
+
+// SecretEncryption configures encryption at rest of Secrets in the
+// cluster's etcd.
+type SecretEncryption struct {
+	// Provider is the encryption provider, EncryptionAESCBC or
+	// EncryptionSecretbox.
+	Provider string
+	// Key is the encryption key, 32 bytes long (aescbc also accepts
+	// 16 or 24 bytes). If empty, a random key is generated.
+	Key []byte
+}
+
+func (s *SecretEncryption) validate() error {
+	return validateEncryptionKey(s.Provider, s.Key, true)
+}
+
+func validateEncryptionKey(provider string, key []byte, allowEmpty bool) error {
+	if allowEmpty && len(key) == 0 {
+		if provider != EncryptionAESCBC && provider != EncryptionSecretbox {
+			return fmt.Errorf("unknown encryption provider %q", provider)
+		}
+		return nil
+	}
+	switch provider {
+	case EncryptionAESCBC:
+		if n := len(key); n != 16 && n != 24 && n != 32 {
+			return fmt.Errorf("aescbc key must be 16, 24 or 32 bytes, got %d", n)
+		}
+	case EncryptionSecretbox:
+		if len(key) != 32 {
+			return fmt.Errorf("secretbox key must be 32 bytes, got %d", len(key))
+		}
+	default:
+		return fmt.Errorf("unknown encryption provider %q", provider)
+	}
+	return nil
+}
+
+// toConfig returns the SecretEncryption's persistent config,
+// generating a key if needed.
+func (s *SecretEncryption) toConfig() (*config.SecretEncryption, error) {
+	key := s.Key
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generating encryption key: %v", err)
+		}
+	}
+	return &config.SecretEncryption{
+		Provider: s.Provider,
+		Keys:     [][]byte{key},
+	}, nil
+}
+
+// encryptionKeyName returns the name of key in the
+// EncryptionConfiguration. It's derived from the key, so that
+// rotations get distinct names.
+func encryptionKeyName(key []byte) string {
+	sum := sha256.Sum256(key)
+	return fmt.Sprintf("key-%x", sum[:4])
+}
+
+// encryptionConfiguration returns the apiserver
+// EncryptionConfiguration for cfg. The first key encrypts, the
+// others and the identity provider only decrypt.
+func encryptionConfiguration(cfg *config.SecretEncryption) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, `apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- resources:
+  - secrets
+  providers:
+  - %s:
+      keys:
+`, cfg.Provider)
+	for _, key := range cfg.Keys {
+		fmt.Fprintf(&b, "      - name: %s\n        secret: %s\n", encryptionKeyName(key), base64.StdEncoding.EncodeToString(key))
+	}
+	b.WriteString("  - identity: {}\n")
+	return []byte(b.String())
+}
+
+// apiServerEncryptionArgs returns the kubeadm ClusterConfiguration
+// apiServer settings that enable secret encryption.
+func (c *Cluster) apiServerEncryptionArgs() string {
+	if c.cfg.SecretEncryption == nil {
+		return ""
+	}
+	return fmt.Sprintf(`
+  extraArgs:
+    encryption-provider-config: %s/config.yaml
+  extraVolumes:
+  - name: encryption
+    hostPath: %s
+    mountPath: %s
+    readOnly: true
+    pathType: DirectoryOrCreate`, encryptionDir, encryptionDir, encryptionDir)
+}
+
+// writeEncryptionConfigTo writes the cluster's
+// EncryptionConfiguration to the control plane node vm.
+func (c *Cluster) writeEncryptionConfigTo(vm *VM) error {
+	if _, err := vm.Run("mkdir -p " + encryptionDir + " && chmod 700 " + encryptionDir); err != nil {
+		return err
+	}
+	return vm.WriteFile(encryptionDir+"/config.yaml", encryptionConfiguration(c.cfg.SecretEncryption))
+}
+
+// RotateSecretEncryptionKey switches the cluster's secret encryption
+// to key, and restarts the API server to pick it up. New and updated
+// secrets are encrypted with key.
+//
+// If reencrypt is true, all existing secrets are rewritten with the
+// new key, and the old keys are then dropped. Otherwise the old keys
+// are kept for decryption only, and existing secrets stay encrypted
+// with them until they're next written.
+func (c *Cluster) RotateSecretEncryptionKey(key []byte, reencrypt bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	enc := c.cfg.SecretEncryption
+	if enc == nil {
+		return errors.New("cluster doesn't have secret encryption enabled")
+	}
+	if err := validateEncryptionKey(enc.Provider, key, false); err != nil {
+		return err
+	}
+
+	keys := [][]byte{key}
+	for _, old := range enc.Keys {
+		if !bytes.Equal(old, key) {
+			keys = append(keys, old)
+		}
+	}
+	enc.Keys = keys
+	if err := c.restartAPIServerWithLock(); err != nil {
+		return err
+	}
+	if !reencrypt {
+		return nil
+	}
+
+	if err := c.reencryptSecretsWithLock(); err != nil {
+		return err
+	}
+	enc.Keys = keys[:1]
+	return c.restartAPIServerWithLock()
+}
+
+// ReencryptSecrets rewrites all of the cluster's secrets, so that
+// they're stored encrypted with the current key.
+func (c *Cluster) ReencryptSecrets() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg.SecretEncryption == nil {
+		return errors.New("cluster doesn't have secret encryption enabled")
+	}
+	return c.reencryptSecretsWithLock()
+}
+
+func (c *Cluster) reencryptSecretsWithLock() error {
+	_, err := c.controller.Run("KUBECONFIG=/etc/kubernetes/admin.conf kubectl get secrets --all-namespaces -o json | KUBECONFIG=/etc/kubernetes/admin.conf kubectl replace -f -")
+	if err != nil {
+		return fmt.Errorf("re-encrypting secrets: %v", err)
+	}
+	return nil
+}
+
+// restartAPIServerWithLock rewrites the EncryptionConfiguration on
+// all control plane nodes, and restarts their API servers so that
+// they reread it.
+func (c *Cluster) restartAPIServerWithLock() error {
+	for _, vm := range append([]*VM{c.controller}, c.controlPlanes...) {
+		if err := c.writeEncryptionConfigTo(vm); err != nil {
+			return err
+		}
+		// The kubelet restarts the static pod's container as soon
+		// as it dies.
+		if _, err := vm.Run("docker ps -q -f name=k8s_kube-apiserver | xargs -r docker kill"); err != nil {
+			return fmt.Errorf("restarting kube-apiserver on %s: %v", vm.Hostname(), err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiServerRestartTimeout)
+	defer cancel()
+	return c.WaitFor(ctx, func() (bool, error) {
+		_, err := c.client.CoreV1().Secrets("kube-system").List(metav1.ListOptions{Limit: 1})
+		return err == nil, nil
+	})
+}
+
+// SecretsEncrypted returns true if every secret in the cluster's etcd
+// is encrypted with the current encryption key. It returns false if
+// some secrets are still stored in plaintext or with an older key.
+func (c *Cluster) SecretsEncrypted() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	enc := c.cfg.SecretEncryption
+	if enc == nil {
+		return false, nil
+	}
+
+	out, err := c.controller.Run("docker exec -e ETCDCTL_API=3 $(docker ps -q -f name=k8s_etcd) etcdctl --endpoints=https://127.0.0.1:2379 --cacert=/etc/kubernetes/pki/etcd/ca.crt --cert=/etc/kubernetes/pki/etcd/healthcheck-client.crt --key=/etc/kubernetes/pki/etcd/healthcheck-client.key get /registry/secrets/ --prefix -w json")
+	if err != nil {
+		return false, fmt.Errorf("reading secrets from etcd: %v", err)
+	}
+	var resp struct {
+		KVs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return false, fmt.Errorf("parsing etcdctl output: %v", err)
+	}
+
+	prefix := []byte(fmt.Sprintf("k8s:enc:%s:v1:%s:", enc.Provider, encryptionKeyName(enc.Keys[0])))
+	for _, kv := range resp.KVs {
+		if !bytes.HasPrefix(kv.Value, prefix) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
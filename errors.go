@@ -0,0 +1,176 @@
+package virtuakube
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Errors that callers can test for with errors.Is, rather than by
+// matching error text. Errors from a RemoteUniverse match them too.
+var (
+	// ErrSnapshotNotFound means that the universe has no snapshot of
+	// the given name.
+	ErrSnapshotNotFound = errors.New("no such snapshot")
+	// ErrKVMUnavailable means that a VM needs KVM acceleration, but
+	// /dev/kvm can't be used. See UniverseConfig.NoAcceleration.
+	ErrKVMUnavailable = errors.New("KVM is unavailable")
+	// ErrBootTimeout means that a VM didn't finish booting within
+	// UniverseConfig.BootTimeout.
+	ErrBootTimeout = errors.New("timed out waiting for VM to boot")
+	// ErrSSHUnreachable means that virtuakube couldn't establish an
+	// SSH connection to a VM.
+	ErrSSHUnreachable = errors.New("VM is unreachable over SSH")
+	// ErrAptLocked means that apt-get kept failing because another
+	// process held the apt or dpkg lock on the VM.
+	ErrAptLocked = errors.New("apt is locked by another process")
+	// ErrUniverseLocked means that another process has the universe
+	// open.
+	ErrUniverseLocked = errors.New("universe is already open")
+	// ErrToolsMissing means that commands virtuakube needs aren't
+	// installed on the host.
+	ErrToolsMissing = errors.New("required tools missing")
+)
+
+// remoteErrors are the errors that RemoteUniverse errors can match,
+// by name on the control socket.
+var remoteErrors = map[string]error{
+	"snapshot-not-found": ErrSnapshotNotFound,
+	"kvm-unavailable":    ErrKVMUnavailable,
+	"boot-timeout":       ErrBootTimeout,
+	"ssh-unreachable":    ErrSSHUnreachable,
+	"apt-locked":         ErrAptLocked,
+	"universe-locked":    ErrUniverseLocked,
+	"tools-missing":      ErrToolsMissing,
+}
+
+// errorKinds returns the names in remoteErrors of the errors that err
+// matches.
+func errorKinds(err error) []string {
+	var ret []string
+	for name, kind := range remoteErrors {
+		if errors.Is(err, kind) {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// remoteError is an error from a RemoteUniverse, which matches the
+// errors that the universe's error matched.
+type remoteError struct {
+	msg   string
+	kinds []error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() []error {
+	return e.kinds
+}
+
+// newRemoteError returns the error for a control response error msg,
+// matching the named kinds.
+func newRemoteError(msg string, kinds []string) error {
+	ret := &remoteError{msg: msg}
+	for _, name := range kinds {
+		if kind := remoteErrors[name]; kind != nil {
+			ret.kinds = append(ret.kinds, kind)
+		}
+	}
+	return ret
+}
+
+const (
+	// defaultBootTimeout is the default UniverseConfig.BootTimeout.
+	// Emulated VMs, e.g. arm64 ones on amd64 hosts, boot slowly,
+	// hence the generous default.
+	defaultBootTimeout = 10 * time.Minute
+	// sshDialAttempts is how many times VM.SSH and friends dial a
+	// VM before giving up with ErrSSHUnreachable.
+	sshDialAttempts = 5
+	// aptLockAttempts is how many times VM.aptGet runs apt-get when
+	// it fails because apt is locked. apt-daily and
+	// unattended-upgrades take the lock shortly after boot, for a
+	// few minutes at most.
+	aptLockAttempts = 10
+)
+
+// bootTimeout returns how long VMs get to boot.
+func (u *Universe) bootTimeout() time.Duration {
+	if u.runtimecfg.BootTimeout == 0 {
+		return defaultBootTimeout
+	}
+	return u.runtimecfg.BootTimeout
+}
+
+// backoff returns how long to wait before retry attempt n, counting
+// from 1, of a transiently failing operation: initial, doubling with
+// each attempt, up to max.
+func backoff(n int, initial, max time.Duration) time.Duration {
+	d := initial
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// dialVM connects to the SSH server on the local port with key,
+// retrying transient failures, like an sshd that's restarting.
+func dialVM(port int, key ssh.Signer) (*ssh.Client, error) {
+	var err error
+	for attempt := 1; attempt <= sshDialAttempts; attempt++ {
+		var client *ssh.Client
+		client, err = ssh.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), vmSSHConfig(key))
+		if err == nil {
+			return client, nil
+		}
+		if !transientDialError(err) {
+			break
+		}
+		time.Sleep(backoff(attempt, 100*time.Millisecond, 2*time.Second))
+	}
+	return nil, fmt.Errorf("%w: %v", ErrSSHUnreachable, err)
+}
+
+// transientDialError reports whether the SSH dial error err may go
+// away by itself. Network errors and connections dropped during the
+// handshake do, authentication failures don't.
+func transientDialError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// x/crypto/ssh doesn't wrap its handshake errors.
+	msg := err.Error()
+	return strings.HasPrefix(msg, "ssh: handshake failed") && !strings.Contains(msg, "unable to authenticate")
+}
+
+// aptLockMessages are what apt and dpkg print when another process
+// holds their locks.
+var aptLockMessages = [][]byte{
+	[]byte("Could not get lock"),
+	[]byte("Unable to acquire the dpkg frontend lock"),
+	[]byte("Unable to lock the administration directory"),
+}
+
+// aptLocked reports whether the output of a failed command says that
+// apt or dpkg was locked.
+func aptLocked(out []byte) bool {
+	for _, msg := range aptLockMessages {
+		if bytes.Contains(out, msg) {
+			return true
+		}
+	}
+	return false
+}
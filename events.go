@@ -0,0 +1,325 @@
+package virtuakube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Kinds of Event.
+const (
+	// EventPortReassigned means that a VM's port forward could not
+	// get the localhost port it had before, and was moved to a new
+	// one.
+	EventPortReassigned = "port-reassigned"
+	// EventPhaseStarted means that a long-running phase of work on a
+	// resource began.
+	EventPhaseStarted = "phase-started"
+	// EventProgress reports progress through a phase, in
+	// Current/Total.
+	EventProgress = "progress"
+	// EventPhaseDone means that a phase finished, successfully if
+	// Message is empty. Duration is how long the phase took.
+	EventPhaseDone = "phase-done"
+	// EventVMStarted means that a VM booted, or resumed from a
+	// snapshot, and is reachable over SSH.
+	EventVMStarted = "vm-started"
+	// EventVMReady means that a VM finished Start, or resumed from a
+	// snapshot, and is ready to use.
+	EventVMReady = "vm-ready"
+	// EventVMCrashed means that a VM's QEMU process exited without
+	// the VM being closed. Message is the exit status.
+	EventVMCrashed = "vm-crashed"
+	// EventClusterReady means that a cluster finished Start. Duration
+	// is how long it took.
+	EventClusterReady = "cluster-ready"
+	// EventClusterUpgraded means that a cluster finished Upgrade.
+	// Message is the new Kubernetes version, and Duration is how
+	// long the upgrade took.
+	EventClusterUpgraded = "cluster-upgraded"
+	// EventSnapshotSaved means that the running universe was saved,
+	// to the snapshot named by Target. Duration is how long the save
+	// took.
+	EventSnapshotSaved = "snapshot-saved"
+	// EventVMPaused means that a VM's CPUs were stopped by Pause.
+	EventVMPaused = "vm-paused"
+	// EventVMResumed means that a paused VM was resumed.
+	EventVMResumed = "vm-resumed"
+)
+
+// maxEvents is how many past events a universe remembers, for Events
+// and for replay to late subscribers.
+const maxEvents = 1000
+
+// Event is a notable occurrence in a running universe.
+type Event struct {
+	Time time.Time
+	Kind string
+	// Target is the name of the resource the event is about.
+	Target  string
+	Message string
+
+	// Progress events describe a phase of work on Target, e.g.
+	// "joining nodes", with how far through it is. Total is zero if
+	// the amount of work isn't known.
+	Phase    string
+	Current  int64
+	Total    int64
+	Duration time.Duration
+}
+
+// Percent returns how complete the event's phase is, from 0 to 100,
+// or -1 if the event doesn't know.
+func (e Event) Percent() int {
+	if e.Kind == EventPhaseDone {
+		return 100
+	}
+	if e.Total <= 0 {
+		return -1
+	}
+	return int(e.Current * 100 / e.Total)
+}
+
+// ETA estimates how much longer the event's phase will take, from
+// its rate of progress so far, or returns -1 if the event doesn't
+// know.
+func (e Event) ETA() time.Duration {
+	if e.Kind != EventProgress || e.Total <= 0 || e.Current <= 0 {
+		return -1
+	}
+	return time.Duration(float64(e.Duration) * float64(e.Total-e.Current) / float64(e.Current))
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case EventPhaseStarted:
+		return fmt.Sprintf("%s: %s...", e.Target, e.Phase)
+	case EventProgress:
+		return fmt.Sprintf("%s: %s %d/%d", e.Target, e.Phase, e.Current, e.Total)
+	case EventPhaseDone:
+		if e.Message != "" {
+			return fmt.Sprintf("%s: %s failed after %s: %s", e.Target, e.Phase, e.Duration, e.Message)
+		}
+		return fmt.Sprintf("%s: %s done in %s", e.Target, e.Phase, e.Duration)
+	case EventVMStarted:
+		return fmt.Sprintf("VM %s started", e.Target)
+	case EventVMReady:
+		return fmt.Sprintf("VM %s ready", e.Target)
+	case EventVMCrashed:
+		return fmt.Sprintf("VM %s crashed: %s", e.Target, e.Message)
+	case EventClusterReady:
+		return fmt.Sprintf("cluster %s ready in %s", e.Target, e.Duration)
+	case EventSnapshotSaved:
+		return fmt.Sprintf("snapshot %s saved in %s", e.Target, e.Duration)
+	case EventVMPaused:
+		return fmt.Sprintf("VM %s paused", e.Target)
+	case EventVMResumed:
+		return fmt.Sprintf("VM %s resumed", e.Target)
+	default:
+		return fmt.Sprintf("%s %s: %s", e.Kind, e.Target, e.Message)
+	}
+}
+
+// emit records an event. It may be called with or without u.mu held.
+func (u *Universe) emit(kind, target, format string, args ...interface{}) {
+	u.emitEvent(Event{
+		Kind:    kind,
+		Target:  target,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (u *Universe) emitEvent(ev Event) {
+	ev.Time = time.Now()
+
+	u.eventsMu.Lock()
+	u.events = append(u.events, ev)
+	if len(u.events) > maxEvents {
+		u.events = append([]Event(nil), u.events[len(u.events)-maxEvents:]...)
+	}
+	for ch := range u.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// Slow subscribers miss events rather than stall the
+			// universe.
+		}
+	}
+	u.eventsMu.Unlock()
+
+	if ev.Kind != EventProgress {
+		level := slog.LevelInfo
+		if ev.Kind == EventPhaseDone && ev.Message != "" {
+			level = slog.LevelWarn
+		}
+		attrs := []interface{}{"event", ev.String(), "kind", ev.Kind, "target", ev.Target}
+		if ev.Phase != "" {
+			attrs = append(attrs, "phase", ev.Phase)
+		}
+		if ev.Kind == EventPhaseDone {
+			attrs = append(attrs, "duration", ev.Duration)
+		}
+		u.log.Log(context.Background(), level, logEvent, attrs...)
+	}
+}
+
+// Events returns the recent events that have occurred since the
+// universe was opened, oldest first.
+func (u *Universe) Events() []Event {
+	u.eventsMu.Lock()
+	defer u.eventsMu.Unlock()
+	ret := make([]Event, len(u.events))
+	copy(ret, u.events)
+	return ret
+}
+
+// Subscribe returns a channel that receives the universe's events as
+// they happen, until ctx is canceled or the universe is closed. If
+// replay is true, the channel first receives the recent events that
+// happened before the call, so that e.g. a UI started after Open can
+// still show how the universe came up.
+//
+// The channel is buffered, but a subscriber that falls far behind
+// will miss events.
+func (u *Universe) Subscribe(ctx context.Context, replay bool) <-chan Event {
+	u.eventsMu.Lock()
+	defer u.eventsMu.Unlock()
+
+	var ch chan Event
+	if replay {
+		ch = make(chan Event, len(u.events)+100)
+		for _, ev := range u.events {
+			ch <- ev
+		}
+	} else {
+		ch = make(chan Event, 100)
+	}
+	u.subscribers[ch] = true
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-u.closedCh:
+		}
+		u.eventsMu.Lock()
+		defer u.eventsMu.Unlock()
+		delete(u.subscribers, ch)
+		close(ch)
+	}()
+
+	return ch
+}
+
+// Hook calls fn for each new event of the given kind about target,
+// until the returned function is called or the universe is closed.
+// An empty kind or target matches any. fn runs on its own goroutine,
+// one event at a time and in order, so it can call back into the
+// universe. Like a subscriber, a hook that falls far behind will miss
+// events.
+func (u *Universe) Hook(kind, target string, fn func(Event)) (remove func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := u.Subscribe(ctx, false)
+	go func() {
+		for ev := range ch {
+			if (kind == "" || ev.Kind == kind) && (target == "" || ev.Target == target) {
+				fn(ev)
+			}
+		}
+	}()
+	return cancel
+}
+
+// Hook calls fn for each new event of the given kind about the VM,
+// like Universe.Hook.
+func (v *VM) Hook(kind string, fn func(Event)) (remove func()) {
+	return v.universe.Hook(kind, v.cfg.Name, fn)
+}
+
+// Hook calls fn for each new event of the given kind about the
+// cluster, like Universe.Hook.
+func (c *Cluster) Hook(kind string, fn func(Event)) (remove func()) {
+	return c.universe.Hook(kind, c.Name(), fn)
+}
+
+// progress reports on a long-running phase of work on a resource.
+type progress struct {
+	u      *Universe
+	target string
+	phase  string
+	total  int64
+	start  time.Time
+	// Time of the last progress event, to rate limit updates.
+	last time.Time
+}
+
+// progress starts a new phase of work on target, with total units of
+// work to do (or zero if unknown).
+func (u *Universe) progress(target, phase string, total int64) *progress {
+	p := &progress{
+		u:      u,
+		target: target,
+		phase:  phase,
+		total:  total,
+		start:  time.Now(),
+	}
+	u.emitEvent(Event{
+		Kind:   EventPhaseStarted,
+		Target: target,
+		Phase:  phase,
+		Total:  total,
+	})
+	return p
+}
+
+// update reports that current units of work are done. Updates are
+// rate limited, except for the final one.
+func (p *progress) update(current int64) {
+	now := time.Now()
+	if current != p.total && now.Sub(p.last) < 250*time.Millisecond {
+		return
+	}
+	p.last = now
+	p.u.emitEvent(Event{
+		Kind:     EventProgress,
+		Target:   p.target,
+		Phase:    p.phase,
+		Current:  current,
+		Total:    p.total,
+		Duration: now.Sub(p.start),
+	})
+}
+
+// done reports that the phase finished, having failed if err is
+// non-nil. It returns err.
+func (p *progress) done(err error) error {
+	ev := Event{
+		Kind:     EventPhaseDone,
+		Target:   p.target,
+		Phase:    p.phase,
+		Current:  p.total,
+		Total:    p.total,
+		Duration: time.Since(p.start),
+	}
+	if err != nil {
+		ev.Message = err.Error()
+	}
+	p.u.emitEvent(ev)
+	return err
+}
+
+// progressWriter reports the number of bytes written through it as
+// progress.
+type progressWriter struct {
+	w    io.Writer
+	prog *progress
+	n    int64
+}
+
+func (p *progressWriter) Write(bs []byte) (int, error) {
+	n, err := p.w.Write(bs)
+	p.n += int64(n)
+	p.prog.update(p.n)
+	return n, err
+}
@@ -0,0 +1,136 @@
+package virtuakube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecOptions configures a command run with VM.Exec.
+type ExecOptions struct {
+	// Stdin, if non-nil, is copied to the command's standard input.
+	Stdin io.Reader
+	// Stdout and Stderr, if non-nil, receive the command's output as
+	// it's produced.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Env are extra environment variables for the command.
+	Env map[string]string
+	// Timeout, if non-zero, kills the command if it runs longer. The
+	// command runs under timeout(1) in the guest, so it's killed
+	// even if the guest's sshd doesn't deliver signals.
+	Timeout time.Duration
+}
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Exec runs the given shell command as root on the VM, streaming its
+// output to opts' writers, and returns its exit code.
+//
+// A command that runs and fails isn't an error: Exec returns its
+// non-zero exit code and a nil error. Exec returns an error if the
+// command couldn't be run or its exit status was lost, e.g. because
+// the SSH connection to the VM broke, or if ctx is done or the
+// timeout expires first, in which case the command is killed with
+// SIGKILL. Only the timeout is sure to kill it, the guest's sshd
+// may not deliver the signal for ctx.
+func (v *VM) Exec(ctx context.Context, command string, opts ExecOptions) (int, error) {
+	var env []string
+	for k := range opts.Env {
+		if !envNameRe.MatchString(k) {
+			return -1, fmt.Errorf("invalid environment variable name %q", k)
+		}
+		env = append(env, k)
+	}
+	sort.Strings(env)
+	if len(env) > 0 {
+		// sshd usually refuses to set variables itself, so the shell
+		// does it.
+		var b strings.Builder
+		b.WriteString("export")
+		for _, k := range env {
+			fmt.Fprintf(&b, " %s=%s", k, shellQuote(opts.Env[k]))
+		}
+		command = b.String() + "; " + command
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+		secs := strconv.FormatFloat(opts.Timeout.Seconds(), 'f', -1, 64)
+		command = fmt.Sprintf("timeout -s KILL %ss sh -c %s", secs, shellQuote(command))
+	}
+
+	v.mu.Lock()
+	sess, err := v.ssh.NewSession()
+	v.mu.Unlock()
+	if err != nil {
+		return -1, err
+	}
+	defer sess.Close()
+
+	sess.Stdin = opts.Stdin
+	sess.Stdout, sess.Stderr = opts.Stdout, opts.Stderr
+	v.log.Info(logCommand, "command", command)
+	logOut, flush := logOutputWriter(v.log)
+	defer flush()
+	if logOut != nil {
+		sess.Stdout = teeWriter(opts.Stdout, logOut)
+		sess.Stderr = teeWriter(opts.Stderr, logOut)
+	}
+
+	start := time.Now()
+	if err := sess.Start(command); err != nil {
+		logCommandResult(v.log, command, start, err)
+		return -1, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- sess.Wait() }()
+
+	select {
+	case err = <-done:
+		logCommandResult(v.log, command, start, err)
+	case <-ctx.Done():
+		// Without a pty, closing the session doesn't stop the
+		// command, and not all sshds deliver signals. Commands with
+		// a Timeout are also killed by timeout(1) in the guest.
+		sess.Signal(ssh.SIGKILL)
+		sess.Close()
+		<-done
+		logCommandResult(v.log, command, start, ctx.Err())
+		return -1, ctx.Err()
+	}
+
+	if err == nil {
+		return 0, nil
+	}
+	if exit, ok := err.(*ssh.ExitError); ok {
+		if opts.Timeout > 0 && exit.ExitStatus() == timeoutKilledStatus && time.Since(start) >= opts.Timeout {
+			// timeout(1) in the guest beat ctx to it.
+			return -1, context.DeadlineExceeded
+		}
+		return exit.ExitStatus(), nil
+	}
+	return -1, err
+}
+
+// timeoutKilledStatus is the exit status of timeout -s KILL when the
+// command times out.
+const timeoutKilledStatus = 128 + 9
+
+// teeWriter returns a writer that writes to both w and log. w can be
+// nil.
+func teeWriter(w, log io.Writer) io.Writer {
+	if w == nil {
+		return log
+	}
+	return io.MultiWriter(w, log)
+}
@@ -0,0 +1,305 @@
+package virtuakube
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// exportManifest is the archive entry that describes the exported
+// disk files.
+const exportManifest = "virtuakube-export.json"
+
+// exportInfo describes an exported universe's disk files.
+type exportInfo struct {
+	Version int
+	// Backing maps disk files to the files that back them, relative
+	// to the universe directory. Backing paths are absolute in qcow2
+	// headers, so they have to be fixed up on import.
+	Backing       map[string]string
+	BackingFormat map[string]string
+}
+
+// Export writes the snapshot the universe was opened from, as last
+// saved, to w as a gzipped tarball that Import can unpack into a new
+// universe directory. Unsaved changes aren't exported. The archive
+// holds the disks of the snapshot's images and VMs (including the
+// VMs' memory state), and the snapshot's metadata.
+//
+// Running VMs are paused while their disks are copied. VMs
+// imported from disks outside the universe can't be exported.
+func (u *Universe) Export(w io.Writer) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return errors.New("universe is closed")
+	}
+
+	snap := u.cfg.Snapshots[u.activeSnapshot]
+	var files []string
+	for _, img := range snap.Images {
+		files = append(files, img.Files()...)
+	}
+	for _, vm := range snap.VMs {
+		if filepath.IsAbs(vm.DiskFile) {
+			return fmt.Errorf("VM %q has a disk outside the universe, it can't be exported", vm.Name)
+		}
+		files = append(files, vm.Files()...)
+	}
+	sort.Strings(files)
+
+	// qemu only flushes disks to a consistent state when the VM is
+	// stopped.
+	var paused []*VM
+	defer func() {
+		for _, vm := range paused {
+			vm.unpause()
+		}
+	}()
+	for name, vm := range u.vms {
+		wasRunning, err := vm.pause()
+		if err != nil {
+			return fmt.Errorf("pausing %q: %v", name, err)
+		}
+		if wasRunning {
+			paused = append(paused, vm)
+		}
+	}
+
+	info := exportInfo{
+		Version:       1,
+		Backing:       map[string]string{},
+		BackingFormat: map[string]string{},
+	}
+	for _, file := range files {
+		backing, format, err := diskBacking(filepath.Join(u.dir, file))
+		if err != nil {
+			return err
+		}
+		if backing == "" {
+			continue
+		}
+		rel, err := filepath.Rel(u.dir, backing)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("disk %q is backed by %q, outside the universe, it can't be exported", file, backing)
+		}
+		info.Backing[file] = rel
+		info.BackingFormat[file] = format
+	}
+
+	cfg := &config.Universe{
+		ID:        u.cfg.ID,
+		Snapshots: map[string]*config.Snapshot{u.activeSnapshot: snap},
+	}
+	cfgBytes, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	infoBytes, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeTarFile(tw, "config.json", cfgBytes); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, exportManifest, infoBytes); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := copyToTar(tw, file, filepath.Join(u.dir, file)); err != nil {
+			return fmt.Errorf("exporting %q: %v", file, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// pause stops the VM's CPUs, and reports whether they were running.
+func (v *VM) pause() (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return false, nil
+	}
+	out, err := v.monitorWithLock("info status")
+	if err != nil {
+		return false, err
+	}
+	if !strings.Contains(out, "running") {
+		return false, nil
+	}
+	if err := v.universe.backend.stopCPUs(v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// unpause restarts the CPUs of a VM stopped by pause.
+func (v *VM) unpause() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return nil
+	}
+	return v.universe.backend.startCPUs(v)
+}
+
+// diskBacking returns the backing file of the disk at path, and its
+// format, or "" if the disk has no backing file.
+func diskBacking(path string) (string, string, error) {
+	// -U lets qemu-img read disks that a paused VM holds open.
+	out, err := exec.Command("qemu-img", "info", "-U", "--output=json", path).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("inspecting disk %q: %v", path, err)
+	}
+	var info struct {
+		Backing string `json:"full-backing-filename"`
+		Format  string `json:"backing-filename-format"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return "", "", fmt.Errorf("parsing qemu-img info for %q: %v", path, err)
+	}
+	return info.Backing, info.Format, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, bs []byte) error {
+	hdr := &tar.Header{
+		Name: name,
+		Mode: 0600,
+		Size: int64(len(bs)),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(bs)
+	return err
+}
+
+func copyToTar(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Import unpacks a universe archive written by Export into dir, which
+// must not exist yet. The result is a universe with the single
+// exported snapshot, ready to Open.
+func Import(r io.Reader, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	if err := importArchive(r, dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func importArchive(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("reading archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var info *exportInfo
+	seen := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading archive: %v", err)
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || filepath.IsAbs(name) || strings.HasPrefix(name, "..") || seen[name] {
+			return fmt.Errorf("invalid archive entry %q", hdr.Name)
+		}
+		seen[name] = true
+
+		if name == exportManifest {
+			info = &exportInfo{}
+			if err := json.NewDecoder(tr).Decode(info); err != nil {
+				return fmt.Errorf("parsing archive manifest: %v", err)
+			}
+			if info.Version != 1 {
+				return fmt.Errorf("unsupported archive version %d", info.Version)
+			}
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return fmt.Errorf("extracting %q: %v", name, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	if info == nil || !seen["config.json"] {
+		return errors.New("not a virtuakube universe archive")
+	}
+	if _, err := config.Read(filepath.Join(dir, "config.json")); err != nil {
+		return fmt.Errorf("reading universe config: %v", err)
+	}
+
+	for file, backing := range info.Backing {
+		if !seen[file] || !seen[backing] {
+			return fmt.Errorf("archive is missing disk %q or its backing file %q", file, backing)
+		}
+		// The disk contents are unchanged, only the path in the
+		// qcow2 header moves.
+		out, err := exec.Command("qemu-img", "rebase", "-u", "-F", info.BackingFormat[file], "-b", filepath.Join(dir, backing), filepath.Join(dir, file)).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("rebasing %q onto %q: %v (%s)", file, backing, err, out)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// PushFile copies the host file local to remote on the VM, creating
+// or replacing it with local's permissions.
+func (v *VM) PushFile(local, remote string) error {
+	return v.withSFTP(func(c *sftp.Client) error { return pushFile(c, local, remote) })
+}
+
+// PullFile copies the file remote on the VM to local on the host.
+func (v *VM) PullFile(remote, local string) error {
+	return v.withSFTP(func(c *sftp.Client) error { return pullFile(c, remote, local) })
+}
+
+// PushDir recursively copies the host directory local to remote on
+// the VM. Existing files in remote are replaced, others are left
+// alone.
+func (v *VM) PushDir(local, remote string) error {
+	return v.withSFTP(func(c *sftp.Client) error { return pushDir(c, local, remote) })
+}
+
+// PullDir recursively copies the directory remote on the VM to local
+// on the host.
+func (v *VM) PullDir(remote, local string) error {
+	return v.withSFTP(func(c *sftp.Client) error { return pullDir(c, remote, local) })
+}
+
+func (v *VM) withSFTP(do func(*sftp.Client) error) error {
+	v.mu.Lock()
+	client := v.ssh
+	v.mu.Unlock()
+	if client == nil {
+		return errors.New("VM isn't running")
+	}
+	v.log.Info("sftp")
+	return withSFTP(client, do)
+}
+
+func withSFTP(client *ssh.Client, do func(*sftp.Client) error) error {
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("starting sftp: %v", err)
+	}
+	defer c.Close()
+	return do(c)
+}
+
+func pushFile(c *sftp.Client, local, remote string) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", local)
+	}
+
+	dst, err := c.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("creating %q: %v", remote, err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("writing %q: %v", remote, err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("writing %q: %v", remote, err)
+	}
+	return c.Chmod(remote, fi.Mode().Perm())
+}
+
+func pullFile(c *sftp.Client, remote, local string) error {
+	src, err := c.Open(remote)
+	if err != nil {
+		return fmt.Errorf("opening %q: %v", remote, err)
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", remote)
+	}
+
+	dst, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("reading %q: %v", remote, err)
+	}
+	return dst.Close()
+}
+
+func pushDir(c *sftp.Client, local, remote string) error {
+	return filepath.Walk(local, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(local, p)
+		if err != nil {
+			return err
+		}
+		dst := path.Join(remote, filepath.ToSlash(rel))
+		switch {
+		case fi.IsDir():
+			if err := c.MkdirAll(dst); err != nil {
+				return fmt.Errorf("creating %q: %v", dst, err)
+			}
+			return c.Chmod(dst, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			c.Remove(dst)
+			return c.Symlink(target, dst)
+		case fi.Mode().IsRegular():
+			return pushFile(c, p, dst)
+		default:
+			// Devices, sockets and pipes can't be copied.
+			return nil
+		}
+	})
+}
+
+func pullDir(c *sftp.Client, remote, local string) error {
+	w := c.Walk(remote)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(remote, w.Path())
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(local, rel)
+		fi := w.Stat()
+		switch {
+		case fi.IsDir():
+			if err := os.MkdirAll(dst, fi.Mode().Perm()|0700); err != nil {
+				return err
+			}
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := c.ReadLink(w.Path())
+			if err != nil {
+				return err
+			}
+			os.Remove(dst)
+			if err := os.Symlink(target, dst); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if err := pullFile(c, w.Path(), dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RemoteUniverse) withSFTP(vm string, do func(*sftp.Client) error) error {
+	client, err := r.SSH(vm)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return withSFTP(client, do)
+}
+
+// PushFile copies the host file local to remote on the named VM, like
+// VM.PushFile.
+func (r *RemoteUniverse) PushFile(vm, local, remote string) error {
+	return r.withSFTP(vm, func(c *sftp.Client) error { return pushFile(c, local, remote) })
+}
+
+// PullFile copies the file remote on the named VM to local, like
+// VM.PullFile.
+func (r *RemoteUniverse) PullFile(vm, remote, local string) error {
+	return r.withSFTP(vm, func(c *sftp.Client) error { return pullFile(c, remote, local) })
+}
+
+// PushDir recursively copies the host directory local to remote on the
+// named VM, like VM.PushDir.
+func (r *RemoteUniverse) PushDir(vm, local, remote string) error {
+	return r.withSFTP(vm, func(c *sftp.Client) error { return pushDir(c, local, remote) })
+}
+
+// PullDir recursively copies the directory remote on the named VM to
+// local, like VM.PullDir.
+func (r *RemoteUniverse) PullDir(vm, remote, local string) error {
+	return r.withSFTP(vm, func(c *sftp.Client) error { return pullDir(c, remote, local) })
+}
@@ -0,0 +1,208 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// Firmwares that VMs can boot with.
+const (
+	// FirmwareBIOS is QEMU's default SeaBIOS.
+	FirmwareBIOS = "bios"
+	// FirmwareUEFI is OVMF, the UEFI firmware for VMs from EDK II.
+	FirmwareUEFI = "uefi"
+)
+
+// tpmTools are the host tools needed to give VMs a TPM.
+var tpmTools = []string{"swtpm"}
+
+// ovmfFiles are the files of an OVMF build: the firmware code, and
+// the template of the variable store that each VM gets a copy of.
+type ovmfFiles struct {
+	code, vars string
+}
+
+// ovmfBuilds are where distros install OVMF, as plain and secure boot
+// builds. Secure boot variable templates have Microsoft's keys
+// enrolled, so that shim-signed distro bootloaders boot.
+var ovmfBuilds = []struct {
+	plain, secure ovmfFiles
+}{
+	// Debian 12 and later, Ubuntu 22.04 and later.
+	{
+		ovmfFiles{"/usr/share/OVMF/OVMF_CODE_4M.fd", "/usr/share/OVMF/OVMF_VARS_4M.fd"},
+		ovmfFiles{"/usr/share/OVMF/OVMF_CODE_4M.secboot.fd", "/usr/share/OVMF/OVMF_VARS_4M.ms.fd"},
+	},
+	// Older Debian and Ubuntu.
+	{
+		ovmfFiles{"/usr/share/OVMF/OVMF_CODE.fd", "/usr/share/OVMF/OVMF_VARS.fd"},
+		ovmfFiles{"/usr/share/OVMF/OVMF_CODE.secboot.fd", "/usr/share/OVMF/OVMF_VARS.ms.fd"},
+	},
+	// Fedora and RHEL.
+	{
+		ovmfFiles{"/usr/share/edk2/ovmf/OVMF_CODE.fd", "/usr/share/edk2/ovmf/OVMF_VARS.fd"},
+		ovmfFiles{"/usr/share/edk2/ovmf/OVMF_CODE.secboot.fd", "/usr/share/edk2/ovmf/OVMF_VARS.secboot.fd"},
+	},
+}
+
+// findOVMF returns the host's OVMF build, with secure boot support if
+// secure is set.
+func findOVMF(secure bool) (ovmfFiles, error) {
+	for _, build := range ovmfBuilds {
+		files := build.plain
+		if secure {
+			files = build.secure
+		}
+		if _, err := os.Stat(files.code); err != nil {
+			continue
+		}
+		if _, err := os.Stat(files.vars); err != nil {
+			continue
+		}
+		return files, nil
+	}
+	if secure {
+		return ovmfFiles{}, errors.New("no OVMF build with secure boot found, install the ovmf (Debian) or edk2-ovmf (Fedora) package")
+	}
+	return ovmfFiles{}, errors.New("no OVMF build found, install the ovmf (Debian) or edk2-ovmf (Fedora) package")
+}
+
+// validateFirmware checks the firmware options of cfg, for a VM of
+// arch running machine.
+func validateFirmware(cfg *VMConfig, arch, machine string) error {
+	switch cfg.Firmware {
+	case "", FirmwareBIOS:
+		if cfg.SecureBoot {
+			return errors.New("SecureBoot requires UEFI firmware")
+		}
+	case FirmwareUEFI:
+		if normalizeArch(arch) != ArchAMD64 {
+			return fmt.Errorf("UEFI firmware is only supported on %s", ArchAMD64)
+		}
+		if cfg.SecureBoot && !strings.Contains(machine, "q35") {
+			return fmt.Errorf("SecureBoot requires a q35 machine type, not %q", machine)
+		}
+		if _, err := findOVMF(cfg.SecureBoot); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown firmware %q, must be %s or %s", cfg.Firmware, FirmwareBIOS, FirmwareUEFI)
+	}
+	if cfg.TPM {
+		if normalizeArch(arch) != ArchAMD64 {
+			return fmt.Errorf("TPM is only supported on %s", ArchAMD64)
+		}
+		if err := checkTools(tpmTools); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createVarStore creates the UEFI variable store of the VM in cfg,
+// from the host's OVMF template. The store is qcow2, so that it's
+// saved in the VM's snapshots along with its disks.
+func (u *Universe) createVarStore(ctx context.Context, cfg *config.VM) error {
+	ovmf, err := findOVMF(cfg.SecureBoot)
+	if err != nil {
+		return err
+	}
+	cfg.VarsFile = randomDiskName()
+	out, err := exec.CommandContext(ctx, "qemu-img", "convert", "-f", "raw", "-O", "qcow2", ovmf.vars, u.diskPath(cfg.VarsFile)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("creating UEFI variable store: %v\n%s", err, string(out))
+	}
+	return nil
+}
+
+// firmwareArgs returns the qemu arguments that give vm its firmware
+// and TPM, starting the VM's swtpm if it has a TPM.
+func (u *Universe) firmwareArgs(vm *VM) ([]string, error) {
+	cfg := vm.cfg
+	var ret []string
+	if cfg.Firmware == FirmwareUEFI {
+		// The OVMF build is looked up again each time the VM starts,
+		// its variable store only works with builds of the same
+		// flash size as the one it was created from.
+		ovmf, err := findOVMF(cfg.SecureBoot)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.SecureBoot {
+			// Secure boot is only secure if the guest OS can't write
+			// the variable store behind the firmware's back.
+			ret = append(ret,
+				"-global", "driver=cfi.pflash01,property=secure,value=on",
+				"-global", "ICH9-LPC.disable_s3=1",
+			)
+		}
+		ret = append(ret,
+			"-drive", fmt.Sprintf("if=pflash,format=raw,unit=0,readonly=on,file=%s", ovmf.code),
+			"-drive", fmt.Sprintf("if=pflash,format=qcow2,unit=1,file=%s", cfg.VarsFile),
+		)
+	}
+	if cfg.TPM {
+		sock := filepath.Join(u.tmpdir, "tpm-"+cfg.Name)
+		if err := u.startSwtpm(sock, cfg.Name); err != nil {
+			return nil, err
+		}
+		ret = append(ret,
+			"-chardev", fmt.Sprintf("socket,id=tpm,path=%s", sock),
+			"-tpmdev", "emulator,id=tpm0,chardev=tpm",
+			"-device", "tpm-tis,tpmdev=tpm0",
+		)
+	}
+	return ret, nil
+}
+
+// startSwtpm starts a TPM 2.0 emulator for the named VM, controlled
+// over the Unix socket sock, and waits for it to listen. QEMU saves
+// the TPM's state in the VM's snapshots, and restores it into a fresh
+// swtpm on resume, so the emulator's own state is disposable.
+func (u *Universe) startSwtpm(sock, vm string) error {
+	state := filepath.Join(u.tmpdir, "tpm-state-"+vm)
+	if err := os.MkdirAll(state, 0700); err != nil {
+		return err
+	}
+	cmd := exec.Command("swtpm", "socket", "--tpm2", "--terminate",
+		"--tpmstate", "dir="+state,
+		"--ctrl", "type=unixio,path="+sock,
+	)
+	u.log.Info(logCommand, "command", strings.Join(cmd.Args, " "))
+	out, flush := logOutputWriter(u.log)
+	if out != nil {
+		cmd.Stdout, cmd.Stderr = out, out
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting swtpm: %v", err)
+	}
+	// swtpm exits by itself when qemu disconnects.
+	done := make(chan bool)
+	go func() {
+		cmd.Wait()
+		flush()
+		close(done)
+	}()
+	u.trackProcess(cmd.Process, done)
+
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+		select {
+		case <-done:
+			return fmt.Errorf("swtpm for %q exited on startup", vm)
+		default:
+		}
+		if _, err := os.Stat(sock); err == nil {
+			return nil
+		}
+	}
+	cmd.Process.Kill()
+	return fmt.Errorf("timed out waiting for swtpm for %q", vm)
+}
@@ -0,0 +1,159 @@
+package virtuakube
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// gcGracePeriod is how long files must have been left untouched by
+// an open universe before GC removes them. Imports and image builds
+// write their disk before the universe records it.
+const gcGracePeriod = 10 * time.Minute
+
+var (
+	// Disk files, and the kernels of arm64 images, as named by
+	// randomDiskName.
+	gcDiskRe = regexp.MustCompile(`^disk-[0-9a-f]{32}(\.vmlinuz|\.initrd)?$`)
+	// Temporary directories of universe runs, from Open.
+	gcTmpRe = regexp.MustCompile(`^tmp[0-9]+$`)
+)
+
+// GCResult describes the files that a garbage collection removed.
+type GCResult struct {
+	// Removed are the removed files and directories, relative to
+	// the universe directory, sorted.
+	Removed []string
+	// Bytes is the disk space freed.
+	Bytes int64
+}
+
+// GC removes the files that crashed runs left behind in the
+// universe's directory: disk files that no snapshot, image or VM
+// uses, and the temporary directories of other runs, with their
+// sockets. Files that changed in the last 10 minutes are kept, in
+// case they're part of an import or image build in progress.
+func (u *Universe) GC() (*GCResult, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return nil, errors.New("universe is closed")
+	}
+
+	live := liveFiles(u.cfg)
+	for _, img := range u.images {
+		for _, f := range img.Files() {
+			live[f] = true
+		}
+	}
+	for _, vm := range u.vms {
+		for _, f := range vm.cfg.Files() {
+			live[f] = true
+		}
+	}
+	live[filepath.Base(u.tmpdir)] = true
+	return gcDir(u.dir, live, time.Now().Add(-gcGracePeriod))
+}
+
+// GCSaved removes the files that crashed runs left behind in the
+// universe in dir, like Universe.GC, without opening the universe.
+// It also removes the control socket of a universe process that
+// died. The universe must not be open, and must have no orphaned
+// VMs still running, see ListRunning.
+func GCSaved(dir string) (*GCResult, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Attach(dir); err == nil {
+		return nil, errors.New("universe is open, garbage collect through the running universe")
+	}
+	lock, err := lockUniverse(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	cfg, err := config.Read(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := gcDir(dir, liveFiles(cfg), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []string{controlSocket, runningFile} {
+		if err := os.Remove(filepath.Join(dir, f)); err == nil {
+			ret.Removed = append(ret.Removed, f)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	sort.Strings(ret.Removed)
+	return ret, nil
+}
+
+// liveFiles returns the set of disk files that cfg's snapshots use.
+func liveFiles(cfg *config.Universe) map[string]bool {
+	ret := map[string]bool{}
+	for _, snap := range cfg.Snapshots {
+		for f := range snapshotFiles(snap) {
+			ret[f] = true
+		}
+	}
+	return ret
+}
+
+// gcDir removes the disk files and temporary directories in dir that
+// aren't live, and haven't been modified since before.
+func gcDir(dir string, live map[string]bool, before time.Time) (*GCResult, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ret := &GCResult{}
+	for _, fi := range fis {
+		name := fi.Name()
+		if live[name] || fi.ModTime().After(before) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		switch {
+		case fi.Mode().IsRegular() && gcDiskRe.MatchString(name):
+			ret.Bytes += diskUsage(fi)
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		case fi.IsDir() && gcTmpRe.MatchString(name):
+			filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+				if err == nil {
+					ret.Bytes += diskUsage(fi)
+				}
+				return nil
+			})
+			if err := os.RemoveAll(path); err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+		ret.Removed = append(ret.Removed, name)
+	}
+	return ret, nil
+}
+
+// diskUsage returns the disk space that the file takes up, which is
+// less than its size for sparse disk images.
+func diskUsage(fi os.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return fi.Size()
+}
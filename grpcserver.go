@@ -0,0 +1,286 @@
+package virtuakube
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"go.universe.tf/virtuakube/api"
+)
+
+// GRPCServer returns a gRPC server that serves the server's gRPC API,
+// the api.Universes service. Stop it after closing the server.
+func (s *Server) GRPCServer() *grpc.Server {
+	ret := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+			if err := s.authorizeGRPC(ctx); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := s.authorizeGRPC(ss.Context()); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	)
+	api.RegisterUniversesServer(ret, &grpcService{s: s})
+	return ret
+}
+
+// authorizeGRPC checks that the gRPC call of ctx carries the server's
+// token.
+func (s *Server) authorizeGRPC(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if s.authorized(auth) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong API token")
+}
+
+// grpcError returns the gRPC status error that reports err.
+func grpcError(err error) error {
+	code := codes.Internal
+	switch errorCode(err) {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+// grpcService implements api.Universes on top of a Server.
+type grpcService struct {
+	api.UnimplementedUniversesServer
+	s *Server
+}
+
+func (g *grpcService) ListUniverses(ctx context.Context, req *api.ListUniversesRequest) (*api.ListUniversesResponse, error) {
+	ret := &api.ListUniversesResponse{}
+	for _, st := range g.s.list() {
+		ret.Universes = append(ret.Universes, universeStatusProto(st))
+	}
+	return ret, nil
+}
+
+func (g *grpcService) OpenUniverse(ctx context.Context, req *api.OpenUniverseRequest) (*api.UniverseStatus, error) {
+	su, err := g.s.open(ctx, req.Name, req.Snapshot)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return universeStatusProto(su.u.Status()), nil
+}
+
+func (g *grpcService) GetUniverse(ctx context.Context, req *api.GetUniverseRequest) (*api.UniverseStatus, error) {
+	su, err := g.s.lookup(req.Name)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return universeStatusProto(su.u.Status()), nil
+}
+
+func (g *grpcService) CloseUniverse(ctx context.Context, req *api.CloseUniverseRequest) (*api.CloseUniverseResponse, error) {
+	su, err := g.s.lookup(req.Name)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := g.s.closeUniverse(ctx, su, req.Save, req.SaveSnapshot); err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.CloseUniverseResponse{}, nil
+}
+
+func (g *grpcService) Checkpoint(ctx context.Context, req *api.CheckpointRequest) (*api.CheckpointResponse, error) {
+	su, err := g.s.lookup(req.Universe)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := su.u.checkpoint(req.Name); err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.CheckpointResponse{}, nil
+}
+
+func (g *grpcService) CreateVM(ctx context.Context, req *api.CreateVMRequest) (*api.VMStatus, error) {
+	su, err := g.s.lookup(req.Universe)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	var cfg VMConfig
+	if err := json.Unmarshal(req.ConfigJson, &cfg); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "parsing VM config: %v", err)
+	}
+	st, err := g.s.createVM(ctx, su, &cfg)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return vmStatusProto(st), nil
+}
+
+func (g *grpcService) CreateCluster(ctx context.Context, req *api.CreateClusterRequest) (*api.ClusterStatus, error) {
+	su, err := g.s.lookup(req.Universe)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	var cfg ClusterConfig
+	if err := json.Unmarshal(req.ConfigJson, &cfg); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "parsing cluster config: %v", err)
+	}
+	st, err := g.s.createCluster(ctx, su, &cfg)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return clusterStatusProto(st), nil
+}
+
+func (g *grpcService) Run(ctx context.Context, req *api.RunRequest) (*api.RunResponse, error) {
+	vm, err := g.s.lookupVM(req.Universe, req.Vm)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	out, exit, err := g.s.runCommand(ctx, vm, req.Command)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.RunResponse{Output: out, ExitStatus: int32(exit)}, nil
+}
+
+func (g *grpcService) ForwardPort(ctx context.Context, req *api.ForwardPortRequest) (*api.ForwardPortResponse, error) {
+	vm, err := g.s.lookupVM(req.Universe, req.Vm)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	hostPort, err := vm.ForwardPort(int(req.Port))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.ForwardPortResponse{Port: req.Port, HostPort: int32(hostPort)}, nil
+}
+
+func (g *grpcService) StopForward(ctx context.Context, req *api.StopForwardRequest) (*api.StopForwardResponse, error) {
+	vm, err := g.s.lookupVM(req.Universe, req.Vm)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := vm.StopForward(int(req.Port)); err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.StopForwardResponse{}, nil
+}
+
+func (g *grpcService) StreamEvents(req *api.StreamEventsRequest, stream grpc.ServerStreamingServer[api.Event]) error {
+	su, err := g.s.lookup(req.Universe)
+	if err != nil {
+		return grpcError(err)
+	}
+	for ev := range su.u.Subscribe(stream.Context(), req.Replay) {
+		if err := stream.Send(eventProto(ev)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *grpcService) StreamLogs(req *api.StreamLogsRequest, stream grpc.ServerStreamingServer[api.LogRecord]) error {
+	su, err := g.s.lookup(req.Universe)
+	if err != nil {
+		return grpcError(err)
+	}
+	su.streamLogs(stream.Context(), func(bs []byte) error {
+		return stream.Send(&api.LogRecord{Json: bs})
+	})
+	return nil
+}
+
+func universeStatusProto(st *UniverseStatus) *api.UniverseStatus {
+	ret := &api.UniverseStatus{
+		Dir:      st.Dir,
+		Pid:      int64(st.PID),
+		Snapshot: st.Snapshot,
+		Started:  timestamppb.New(st.Started),
+		Dns:      st.DNS,
+		Metrics:  st.Metrics,
+	}
+	for _, n := range st.Networks {
+		ret.Networks = append(ret.Networks, &api.NetworkStatus{
+			Name:       n.Name,
+			Ipv4Subnet: n.IPv4Subnet,
+			Ipv6Subnet: n.IPv6Subnet,
+			Mtu:        int32(n.MTU),
+		})
+	}
+	for i := range st.VMs {
+		ret.Vms = append(ret.Vms, vmStatusProto(&st.VMs[i]))
+	}
+	for i := range st.Clusters {
+		ret.Clusters = append(ret.Clusters, clusterStatusProto(&st.Clusters[i]))
+	}
+	for _, ev := range st.Events {
+		ret.Events = append(ret.Events, eventProto(ev))
+	}
+	return ret
+}
+
+func vmStatusProto(st *VMStatus) *api.VMStatus {
+	ret := &api.VMStatus{
+		Name:          st.Name,
+		Networks:      st.Networks,
+		Ipv4:          st.IPv4,
+		Ipv6:          st.IPv6,
+		Lan:           st.LAN,
+		Ports:         map[int32]int32{},
+		ConsoleSocket: st.ConsoleSocket,
+		ConsoleLog:    st.ConsoleLog,
+		MemoryMib:     int32(st.MemoryMiB),
+		RssBytes:      st.RSSBytes,
+		Cpus:          int32(st.CPUs),
+		CpuTime:       durationpb.New(st.CPUTime),
+		Paused:        st.Paused,
+		BalloonMib:    int32(st.BalloonMiB),
+		ClockOffset:   durationpb.New(st.ClockOffset),
+		Display:       st.Display,
+	}
+	for dst, src := range st.Ports {
+		ret.Ports[int32(dst)] = int32(src)
+	}
+	return ret
+}
+
+func clusterStatusProto(st *ClusterStatus) *api.ClusterStatus {
+	return &api.ClusterStatus{
+		Name:          st.Name,
+		Kubeconfig:    st.Kubeconfig,
+		Controller:    st.Controller,
+		Nodes:         st.Nodes,
+		ControlPlanes: st.ControlPlanes,
+		LoadBalancer:  st.LoadBalancer,
+	}
+}
+
+func eventProto(ev Event) *api.Event {
+	return &api.Event{
+		Time:     timestamppb.New(ev.Time),
+		Kind:     ev.Kind,
+		Target:   ev.Target,
+		Message:  ev.Message,
+		Phase:    ev.Phase,
+		Current:  ev.Current,
+		Total:    ev.Total,
+		Duration: durationpb.New(ev.Duration),
+	}
+}
@@ -0,0 +1,212 @@
+package virtuakube
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// controlPlanePKI are the files under /etc/kubernetes/pki that
+// additional control plane nodes need to share with the first one.
+var controlPlanePKI = []string{
+	"ca.crt",
+	"ca.key",
+	"sa.key",
+	"sa.pub",
+	"front-proxy-ca.crt",
+	"front-proxy-ca.key",
+	"etcd/ca.crt",
+	"etcd/ca.key",
+}
+
+// numControlPlanes returns the number of control plane nodes in the
+// cluster.
+func (c *Cluster) numControlPlanes() int {
+	return 1 + len(c.cfg.ControlPlanes)
+}
+
+// apiServerIP returns the IP that nodes should use to reach the API
+// server: the load balancer for HA clusters, or the controller.
+func (c *Cluster) apiServerIP() net.IP {
+	vm := c.controller
+	if c.lb != nil {
+		vm = c.lb
+	}
+	return c.clusterIP(vm)
+}
+
+// apiServerVM returns the VM whose forwarded port 6443 reaches the
+// API server.
+func (c *Cluster) apiServerVM() *VM {
+	if c.lb != nil {
+		return c.lb
+	}
+	return c.controller
+}
+
+// controlPlaneEndpoint returns the kubeadm ClusterConfiguration
+// setting that puts the API server behind the load balancer, for HA
+// clusters.
+func (c *Cluster) controlPlaneEndpoint() string {
+	if c.lb == nil {
+		return ""
+	}
+	return fmt.Sprintf("\ncontrolPlaneEndpoint: %q", (&net.TCPAddr{IP: c.apiServerIP(), Port: 6443}).String())
+}
+
+// haproxyConfig returns an haproxy config that balances API server
+// connections across backends, taking failed control planes out of
+// rotation within a couple of seconds.
+func haproxyConfig(backends []net.IP) []byte {
+	var b strings.Builder
+	b.WriteString(`global
+  daemon
+
+defaults
+  mode tcp
+  timeout connect 5s
+  timeout client 1h
+  timeout server 1h
+
+frontend apiserver
+  bind :::6443 v4v6
+  default_backend controlplanes
+
+backend controlplanes
+  balance roundrobin
+`)
+	for i, ip := range backends {
+		fmt.Fprintf(&b, "  server cp%d %s check inter 1s fall 2 rise 2\n", i+1, &net.TCPAddr{IP: ip, Port: 6443})
+	}
+	return []byte(b.String())
+}
+
+// startLoadBalancer starts the HA cluster's load balancer VM, and
+// configures haproxy to balance across all control planes.
+func (c *Cluster) startLoadBalancer(ctx context.Context) error {
+	if err := c.lb.Start(ctx); err != nil {
+		return err
+	}
+
+	var backends []net.IP
+	for _, vm := range append([]*VM{c.controller}, c.controlPlanes...) {
+		backends = append(backends, c.clusterIP(vm))
+	}
+
+	// Images built before haproxy was part of CustomizeInstallK8s
+	// have to fetch it.
+	if c.universe.runtimecfg.Offline {
+		if _, err := c.lb.Run("command -v haproxy"); err != nil {
+			return &MissingArtifactsError{[]string{fmt.Sprintf("haproxy in the image of %q", c.lb.Hostname())}}
+		}
+	}
+	if _, err := c.lb.Run("command -v haproxy"); err != nil {
+		if err := c.lb.aptGet("update", "install --no-install-recommends haproxy"); err != nil {
+			return fmt.Errorf("installing haproxy: %v", err)
+		}
+	}
+	if err := c.lb.WriteFile("/etc/haproxy/haproxy.cfg", haproxyConfig(backends)); err != nil {
+		return err
+	}
+	return c.lb.RunMultiple(
+		"systemctl enable haproxy",
+		"systemctl restart haproxy",
+	)
+}
+
+// joinControlPlane starts vm and joins it to the cluster as an
+// additional control plane node, with its own API server and etcd
+// member.
+func (c *Cluster) joinControlPlane(ctx context.Context, vm *VM) error {
+	if err := vm.Start(ctx); err != nil {
+		return err
+	}
+
+	if err := c.checkOffline(vm); err != nil {
+		return err
+	}
+	if err := installRuntime(vm, c.Runtime(), c.KubernetesVersion()); err != nil {
+		return err
+	}
+	if c.containerdConfig != "" {
+		if err := c.installContainerdConfig(vm, c.containerdConfig); err != nil {
+			return err
+		}
+	}
+	if err := c.trustRegistries(vm); err != nil {
+		return err
+	}
+	if err := c.installTrustedCAs(vm, c.cfg.TrustedCAs); err != nil {
+		return err
+	}
+	if err := c.setupIPFamily(vm); err != nil {
+		return err
+	}
+	if err := installKubernetesVersion(vm, c.KubernetesVersion()); err != nil {
+		return err
+	}
+
+	if _, err := vm.Run("mkdir -p /etc/kubernetes/pki/etcd"); err != nil {
+		return err
+	}
+	for _, f := range append(controlPlanePKI, "../admin.conf") {
+		path := "/etc/kubernetes/pki/" + f
+		bs, err := c.controller.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := vm.WriteFile(path, bs); err != nil {
+			return err
+		}
+	}
+
+	joinConfig := fmt.Sprintf(`
+apiVersion: kubeadm.k8s.io/%s
+kind: JoinConfiguration
+discovery:
+  bootstrapToken:
+    token: "000000.0000000000000000"
+    unsafeSkipCAVerification: true
+    apiServerEndpoint: %s
+controlPlane:
+  localAPIEndpoint:
+    advertiseAddress: %s
+nodeRegistration:
+  kubeletExtraArgs:
+    node-ip: %s%s%s
+`, kubeadmAPIVersion(c.KubernetesVersion()), &net.TCPAddr{IP: c.apiServerIP(), Port: 6443}, c.clusterIP(vm), c.nodeIPs(vm), c.kubeletCloudArgs(), c.kubeadmCRISocket())
+	joinConfig, err := c.kubeadmConfig(joinConfig)
+	if err != nil {
+		return err
+	}
+	if err := vm.WriteFile("/tmp/k8s.conf", []byte(joinConfig)); err != nil {
+		return err
+	}
+	if c.cfg.SecretEncryption != nil {
+		if err := c.writeEncryptionConfigTo(vm); err != nil {
+			return err
+		}
+	}
+
+	return vm.RunMultiple(
+		"kubeadm join --config=/tmp/k8s.conf --ignore-preflight-errors=NumCPU",
+		"KUBECONFIG=/etc/kubernetes/admin.conf kubectl taint nodes "+vm.Hostname()+" node-role.kubernetes.io/master-",
+	)
+}
+
+// ControlPlanes returns the VMs of all the cluster's control plane
+// nodes. The first one is Controller.
+func (c *Cluster) ControlPlanes() []*VM {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*VM{c.controller}, c.controlPlanes...)
+}
+
+// LoadBalancer returns the VM that load balances the API servers of
+// an HA cluster, or nil if the cluster has a single control plane.
+func (c *Cluster) LoadBalancer() *VM {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lb
+}
@@ -11,28 +11,33 @@ import (
 	"strings"
 
 	"go.universe.tf/virtuakube/internal/assets"
+	"go.universe.tf/virtuakube/internal/config"
 )
 
 var buildTools = []string{
-	"qemu-system-x86_64",
 	"qemu-img",
 	"docker",
 	"virt-make-fs",
 }
 
 const (
+	// dockerfile is formatted with the image's kernel and bootloader
+	// packages.
 	dockerfile = `
 FROM debian:stretch
+ARG apt_mirror
+RUN echo 'Acquire::Retries "10";' >/etc/apt/apt.conf.d/80retries
+RUN if [ -n "$apt_mirror" ]; then cp /etc/apt/sources.list /etc/apt/sources.list.upstream && sed -i "s|https\\?://deb.debian.org/debian/\\?|$apt_mirror/|" /etc/apt/sources.list; fi
 RUN apt-get -y update
 RUN DEBIAN_FRONTEND=noninteractive apt-get -y install --no-install-recommends \
   ca-certificates \
   dbus \
-  grub2 \
+  %s \
   ifupdown \
   isc-dhcp-client \
   isc-dhcp-common \
-  linux-image-amd64 \
   openssh-server \
+  qemu-guest-agent \
   systemd-sysv
 RUN DEBIAN_FRONTEND=noninteractive apt-get -y upgrade --no-install-recommends
 RUN echo "root:root" | chpasswd
@@ -58,9 +63,43 @@ type ImageCustomizeFunc func(*VM) error
 
 // ImageConfig is the build configuration for an Image.
 type ImageConfig struct {
-	Name           string
+	Name string
+	// Arch is the CPU architecture of the image, ArchAMD64 (the
+	// default) or ArchARM64. VMs run the architecture of their
+	// image. arm64 images have no bootloader, their VMs boot the
+	// image's kernel directly.
+	//
+	// Building a foreign image needs a docker that can run its
+	// containers (e.g. with qemu-user binfmt handlers) and its
+	// qemu-system binary, and its VMs are emulated without KVM,
+	// which is much slower. The bundled CNI and addon manifests
+	// may reference images that are only published for amd64.
+	Arch           string
 	CustomizeFuncs []ImageCustomizeFunc
 	NoKVM          bool
+	// Runtime is the container runtime that CustomizeInstallK8s
+	// installs for Kubernetes, one of the Runtime* constants.
+	// Defaults to RuntimeDocker. Clusters whose Runtime matches
+	// their image's don't have to install it at bring-up.
+	Runtime string
+	// CacheKey identifies what CustomizeFuncs do, for the image
+	// cache. Built images are cached by their configuration,
+	// including the names of their CustomizeFuncs. Only virtuakube's
+	// own Customize* functions are identified by their names, since
+	// virtuakube versions them: any other function, like
+	// CustomizeScript's closures, can change what it does without
+	// its name changing. So images with other functions are only
+	// cached if CacheKey is set, e.g. to a hash of their scripts, and
+	// CacheKey must change whenever what they do does.
+	CacheKey string
+	// NoCache makes NewImage build the image even if the image cache
+	// has it, and leave it out of the cache.
+	NoCache bool
+	// Upstream overrides the universe's UniverseConfig.Upstream for
+	// the build. Built images don't keep the proxies and mirrors
+	// they were built with, so images are cached regardless of
+	// Upstream, and VMs get their universe's when they start.
+	Upstream *UpstreamConfig
 }
 
 // Image is a VM disk base image.
@@ -68,55 +107,157 @@ type Image struct {
 	path string
 }
 
+// ImportImage imports the amd64 disk image at path as a new image.
+//
+// The universe stores each image once, and VMs get thin qcow2
+// overlays of it, so VMs cost only the disk space they write. With an
+// ImageCache, universes share a single copy of each image too.
 func (u *Universe) ImportImage(name, path string) error {
+	if u.imageCache() != "" {
+		return u.importCachedImage(name, path, ArchAMD64)
+	}
+	return u.importImage(name, path, ArchAMD64)
+}
+
+func (u *Universe) importImage(name, path, arch string) error {
 	disk := randomDiskName()
 	src, err := os.Open(path)
 	if err != nil {
 		return fmt.Errorf("opening %q: %v", path, err)
 	}
 
+	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %q: %v", path, err)
+	}
+
 	dst, err := os.Create(filepath.Join(u.dir, disk))
 	if err != nil {
 		return fmt.Errorf("creating %q: %v", disk, err)
 	}
+	defer dst.Close()
 
-	if _, err := io.Copy(dst, src); err != nil {
-		return fmt.Errorf("writing disk: %v", err)
+	prog := u.progress(name, "importing image", st.Size())
+	if _, err := io.Copy(&progressWriter{dst, prog, 0}, src); err != nil {
+		return prog.done(fmt.Errorf("writing disk: %v", err))
 	}
+	prog.done(nil)
 
 	u.mu.Lock()
 	defer u.mu.Unlock()
-	if u.images[name] != "" {
+	if u.images[name] != nil {
 		panic("what")
 	}
-	u.images[name] = disk
+	u.images[name] = &config.Image{
+		Name: name,
+		File: disk,
+		Arch: arch,
+	}
 
 	return nil
 }
 
+// imageDockerfile returns the Dockerfile of the base system of
+// images for arch.
+func imageDockerfile(arch string) string {
+	bootPkgs := "grub2 linux-image-amd64"
+	if arch == ArchARM64 {
+		bootPkgs = "linux-image-arm64"
+	}
+	return fmt.Sprintf(dockerfile, bootPkgs)
+}
+
+// imageBuildSteps is the number of steps in building an image,
+// excluding customize funcs.
+const imageBuildSteps = 7
+
 // NewImage builds a VM disk image using the given config.
 func (u *Universe) NewImage(cfg *ImageConfig) error {
-	if err := checkTools(buildTools); err != nil {
+	if err := validateArch(cfg.Arch); err != nil {
+		return err
+	}
+	if err := validateRuntime(cfg.Runtime); err != nil {
+		return err
+	}
+	if err := cfg.Upstream.validate(); err != nil {
 		return err
 	}
 
+	key, cacheable := imageBuildKey(cfg)
+	cached := ""
+	if cache := u.imageCache(); cache != "" && cacheable {
+		cached = filepath.Join(cache, "built", key)
+		ok, err := u.useCachedBuild(cfg.Name, normalizeArch(cfg.Arch), cached)
+		if err != nil || ok {
+			return err
+		}
+	}
+	if u.runtimecfg.Offline {
+		// Builds install packages from the internet.
+		if !cacheable {
+			return &MissingArtifactsError{[]string{fmt.Sprintf("built image %q, which can't be cached without ImageConfig.CacheKey", cfg.Name)}}
+		}
+		return &MissingArtifactsError{[]string{fmt.Sprintf("built image %q (cache key %s)", cfg.Name, key)}}
+	}
+
+	if err := checkTools(append(buildTools, qemuBinary(cfg.Arch))); err != nil {
+		return err
+	}
+
+	prog := u.progress(cfg.Name, "building image", int64(imageBuildSteps+len(cfg.CustomizeFuncs)))
+	if err := prog.done(u.newImage(cfg, prog)); err != nil {
+		return err
+	}
+	if cached != "" {
+		// The image is built, failing to share it with other
+		// universes is no reason to throw it away.
+		if err := u.cacheBuild(cfg.Name, cached); err != nil {
+			u.log.Warn("caching built image failed", "image", cfg.Name, "error", err)
+		}
+	}
+	return nil
+}
+
+func (u *Universe) newImage(cfg *ImageConfig, prog *progress) error {
+	step := int64(0)
+	next := func() {
+		step++
+		prog.update(step)
+	}
+
 	tmp, err := ioutil.TempDir(u.tmpdir, "b")
 	if err != nil {
 		return fmt.Errorf("creating tempdir in %q: %v", u.dir, err)
 	}
 	defer os.RemoveAll(tmp)
 
-	if err := ioutil.WriteFile(filepath.Join(tmp, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+	arch := normalizeArch(cfg.Arch)
+	arm := arch == ArchARM64
+	if err := ioutil.WriteFile(filepath.Join(tmp, "Dockerfile"), []byte(imageDockerfile(arch)), 0644); err != nil {
 		return fmt.Errorf("writing dockerfile: %v", err)
 	}
 
+	// Only pass --platform for foreign images, so that docker
+	// versions without multi-platform support keep working.
+	var platform []string
+	if arch != ArchAMD64 {
+		platform = []string{"--platform", "linux/" + arch}
+	}
+
+	upstream := u.runtimecfg.Upstream
+	if cfg.Upstream != nil {
+		upstream = cfg.Upstream
+	}
+
 	iidPath := filepath.Join(tmp, "iid")
-	cmd := exec.Command("docker", "build", "--iidfile", iidPath, tmp)
-	cmd.Stdout = u.runtimecfg.CommandLog
-	cmd.Stderr = u.runtimecfg.CommandLog
-	if err := cmd.Run(); err != nil {
+	args := append([]string{"build"}, platform...)
+	args = append(args, upstream.buildArgs()...)
+	cmd := exec.Command("docker", append(args, "--iidfile", iidPath, tmp)...)
+	if err := u.runLogged(cmd); err != nil {
 		return fmt.Errorf("running docker build: %v", err)
 	}
+	next()
 
 	iid, err := ioutil.ReadFile(iidPath)
 	if err != nil {
@@ -124,18 +265,18 @@ func (u *Universe) NewImage(cfg *ImageConfig) error {
 	}
 
 	cidPath := filepath.Join(tmp, "cid")
-	cmd = exec.Command(
-		"docker", "run",
+	args = append([]string{"run"}, platform...)
+	args = append(args,
 		"--cidfile", cidPath,
 		fmt.Sprintf("--mount=type=bind,source=%s,destination=/tmp/ctx", tmp),
 		string(iid),
 		"cp", "/vmlinuz", "/initrd.img", "/tmp/ctx",
 	)
-	cmd.Stdout = u.runtimecfg.CommandLog
-	cmd.Stderr = u.runtimecfg.CommandLog
-	if err := cmd.Run(); err != nil {
+	cmd = exec.Command("docker", args...)
+	if err := u.runLogged(cmd); err != nil {
 		return fmt.Errorf("extracting kernel from container: %v", err)
 	}
+	next()
 
 	cid, err := ioutil.ReadFile(cidPath)
 	if err != nil {
@@ -148,11 +289,10 @@ func (u *Universe) NewImage(cfg *ImageConfig) error {
 		"-o", tarPath,
 		string(cid),
 	)
-	cmd.Stdout = u.runtimecfg.CommandLog
-	cmd.Stderr = u.runtimecfg.CommandLog
-	if err := cmd.Run(); err != nil {
+	if err := u.runLogged(cmd); err != nil {
 		return fmt.Errorf("exporting image tarball: %v", err)
 	}
+	next()
 
 	imgPath := filepath.Join(tmp, "fs.img")
 	cmd = exec.Command(
@@ -161,41 +301,44 @@ func (u *Universe) NewImage(cfg *ImageConfig) error {
 		"--type=ext4", "--size=10G",
 		tarPath, imgPath,
 	)
-	cmd.Stdout = u.runtimecfg.CommandLog
-	cmd.Stderr = u.runtimecfg.CommandLog
-	if err := cmd.Run(); err != nil {
+	if err := u.runLogged(cmd); err != nil {
 		return fmt.Errorf("creating image file: %v", err)
 	}
+	next()
 
 	if err := os.Remove(tarPath); err != nil {
 		return fmt.Errorf("removing image tarball: %v", err)
 	}
 
-	tmpu, err := Create(filepath.Join(tmp, "u"), u.runtimecfg)
+	tmpcfg := *u.runtimecfg
+	tmpcfg.Upstream = upstream
+	tmpu, err := Create(context.Background(), filepath.Join(tmp, "u"), &tmpcfg)
 	if err != nil {
 		return fmt.Errorf("creating virtuakube instance: %v", err)
 	}
 	defer tmpu.Destroy()
 
-	if err := tmpu.ImportImage("build", imgPath); err != nil {
+	if err := tmpu.importImage("build", imgPath, arch); err != nil {
 		return fmt.Errorf("importing half-built image: %v", err)
 	}
 
-	v, err := tmpu.NewVM(&VMConfig{
+	v, err := tmpu.NewVM(context.Background(), &VMConfig{
 		Image:     "build",
 		MemoryMiB: 2048,
 		kernelConfig: &kernelConfig{
 			kernelPath: filepath.Join(tmp, "vmlinuz"),
 			initrdPath: filepath.Join(tmp, "initrd.img"),
-			cmdline:    "root=/dev/vda1 rw",
+			cmdline:    "root=/dev/vda1 rw console=tty0 console=" + consoleDevice(arch),
 		},
 	})
 	if err != nil {
 		return fmt.Errorf("creating image VM: %v", err)
 	}
-	if err := v.Start(); err != nil {
+	if err := v.Start(context.Background()); err != nil {
 		return fmt.Errorf("starting image VM: %v", err)
 	}
+	v.buildRuntime = cfg.Runtime
+	next()
 
 	if err := v.WriteFile("/etc/hosts", []byte(hosts)); err != nil {
 		return fmt.Errorf("install /etc/hosts: %v", err)
@@ -205,25 +348,51 @@ func (u *Universe) NewImage(cfg *ImageConfig) error {
 		return fmt.Errorf("install /etc/fstab: %v", err)
 	}
 
-	err = v.RunMultiple(
-		"update-initramfs -u",
-
-		"grub-install /dev/vda",
-		"perl -pi -e 's/GRUB_TIMEOUT=.*/GRUB_TIMEOUT=0/' /etc/default/grub",
-		"update-grub2",
+	cmds := []string{"update-initramfs -u"}
+	if !arm {
+		cmds = append(cmds,
+			"grub-install /dev/vda",
+			"perl -pi -e 's/GRUB_TIMEOUT=.*/GRUB_TIMEOUT=0/' /etc/default/grub",
+			"perl -pi -e 's/GRUB_CMDLINE_LINUX_DEFAULT=.*/GRUB_CMDLINE_LINUX_DEFAULT=\"console=tty0 console=ttyS0\"/' /etc/default/grub",
+			"update-grub2",
+		)
+	}
+	cmds = append(cmds,
+		"systemctl enable serial-getty@"+consoleDevice(arch)+".service",
 
 		"rm /etc/machine-id /var/lib/dbus/machine-id",
 		"touch /etc/machine-id",
 		"chattr +i /etc/machine-id",
 	)
+	err = v.RunMultiple(cmds...)
 	if err != nil {
 		return fmt.Errorf("finalize base image configuration: %v", err)
 	}
+	next()
 
 	for _, f := range cfg.CustomizeFuncs {
 		if err = f(v); err != nil {
 			return fmt.Errorf("applying customize func: %v", err)
 		}
+		next()
+	}
+	if cfg.Runtime != "" && cfg.Runtime != RuntimeDocker && guestRuntimeName(v) != cfg.Runtime {
+		return fmt.Errorf("Runtime %q requires CustomizeInstallK8s, which installs it", cfg.Runtime)
+	}
+	if err := v.removeUpstream(); err != nil {
+		return err
+	}
+
+	// arm64 VMs boot the image's kernel directly, customizations
+	// may have upgraded it.
+	var kernel, initrd []byte
+	if arm {
+		if kernel, err = v.ReadFile("/vmlinuz"); err != nil {
+			return fmt.Errorf("reading image kernel: %v", err)
+		}
+		if initrd, err = v.ReadFile("/initrd.img"); err != nil {
+			return fmt.Errorf("reading image initrd: %v", err)
+		}
 	}
 
 	if _, err := v.Run("sync"); err != nil {
@@ -243,29 +412,43 @@ func (u *Universe) NewImage(cfg *ImageConfig) error {
 		filepath.Join(tmp, "u", tmpu.image("build")),
 		filepath.Join(u.dir, ret),
 	)
-	cmd.Stdout = u.runtimecfg.CommandLog
-	cmd.Stderr = u.runtimecfg.CommandLog
-	if err := cmd.Run(); err != nil {
+	if err := u.runLogged(cmd); err != nil {
 		os.Remove(ret)
 		return fmt.Errorf("running qemu-img convert: %v", err)
 	}
 
+	img := &config.Image{
+		Name: cfg.Name,
+		File: ret,
+		Arch: arch,
+	}
+	if arm {
+		img.Kernel, img.Initrd = ret+".vmlinuz", ret+".initrd"
+		if err := ioutil.WriteFile(filepath.Join(u.dir, img.Kernel), kernel, 0600); err != nil {
+			return fmt.Errorf("writing image kernel: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(u.dir, img.Initrd), initrd, 0600); err != nil {
+			return fmt.Errorf("writing image initrd: %v", err)
+		}
+	}
+	next()
+
 	u.mu.Lock()
 	defer u.mu.Unlock()
-	if u.images[cfg.Name] != "" {
+	if u.images[cfg.Name] != nil {
 		panic("I don't know what to do about this yet")
 	}
-	u.images[cfg.Name] = ret
+	u.images[cfg.Name] = img
 
 	return nil
 }
 
 // CustomizeInstallK8s is a build customization function that installs
 // Docker and Kubernetes prerequisites, as required for NewCluster to
-// function.
+// function, and the image's container runtime if it isn't Docker.
 func CustomizeInstallK8s(v *VM) error {
 	repos := []byte(`
-deb [arch=amd64] https://download.docker.com/linux/debian stretch stable
+deb [arch=` + v.Arch() + `] https://download.docker.com/linux/debian stretch stable
 deb http://apt.kubernetes.io/ kubernetes-xenial main
 `)
 	if err := v.WriteFile("/etc/apt/sources.list.d/k8s.list", repos); err != nil {
@@ -279,6 +462,7 @@ deb http://apt.kubernetes.io/ kubernetes-xenial main
 		"ethtool",
 		"gpg",
 		"gpg-agent",
+		"haproxy",
 	}
 	k8sPkgs := []string{
 		"docker-ce=18.06.*",
@@ -286,12 +470,20 @@ deb http://apt.kubernetes.io/ kubernetes-xenial main
 		"kubeadm",
 		"kubectl",
 	}
+	if err := v.aptGet("install --no-install-recommends " + strings.Join(pkgs, " ")); err != nil {
+		return err
+	}
 	err := v.RunMultiple(
-		"DEBIAN_FRONTEND=noninteractive apt-get -y install --no-install-recommends "+strings.Join(pkgs, " "),
-		"curl -fsSL https://download.docker.com/linux/debian/gpg | apt-key add -",
-		"curl -fsSL https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -",
-		"apt-get -y update",
-		"DEBIAN_FRONTEND=noninteractive apt-get -y install --no-install-recommends "+strings.Join(k8sPkgs, " "),
+		"curl -fsSL --retry 10 https://download.docker.com/linux/debian/gpg | apt-key add -",
+		"curl -fsSL --retry 10 https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -",
+	)
+	if err != nil {
+		return err
+	}
+	if err := v.aptGet("update", "install --no-install-recommends "+strings.Join(k8sPkgs, " ")); err != nil {
+		return err
+	}
+	err = v.RunMultiple(
 		"echo br_netfilter >>/etc/modules",
 		"echo export KUBECONFIG=/etc/kubernetes/admin.conf >>/etc/profile.d/k8s.sh",
 	)
@@ -299,17 +491,37 @@ deb http://apt.kubernetes.io/ kubernetes-xenial main
 		return err
 	}
 
-	return nil
+	if v.buildRuntime == "" || v.buildRuntime == RuntimeDocker {
+		return v.installRegistryMirrors()
+	}
+	out, err := v.Run("kubeadm version -o short")
+	if err != nil {
+		return err
+	}
+	return installRuntime(v, v.buildRuntime, strings.TrimSpace(string(out)))
+}
+
+// CustomizeInstallBenchTools is a build customization function that
+// installs fio and iperf3, as required by VM.BenchDisk and
+// VM.BenchNet.
+func CustomizeInstallBenchTools(v *VM) error {
+	return v.aptGet("update", "install --no-install-recommends fio iperf3")
 }
 
 // CustomizePreloadK8sImages is a build customization function that
-// pre-pulls all the Docker images needed to fully initialize a
-// Kubernetes cluster.
+// pre-pulls all the container images needed to fully initialize a
+// Kubernetes cluster, into the image's container runtime.
 func CustomizePreloadK8sImages(v *VM) error {
-	err := v.RunMultiple(
-		"systemctl start docker",
-		"kubeadm config images pull",
-	)
+	runtime := guestRuntimeName(v)
+	var err error
+	if runtime == RuntimeDocker {
+		err = v.RunMultiple(
+			"systemctl start docker",
+			"kubeadm config images pull",
+		)
+	} else {
+		_, err = v.Run("kubeadm config images pull --cri-socket " + criSocket(runtime))
+	}
 	if err != nil {
 		return err
 	}
@@ -319,7 +531,7 @@ func CustomizePreloadK8sImages(v *VM) error {
 		if img == "" {
 			continue
 		}
-		if _, err := v.Run("docker pull " + img); err != nil {
+		if _, err := v.Run(pullImageCommand(runtime, img)); err != nil {
 			return err
 		}
 	}
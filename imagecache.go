@@ -0,0 +1,329 @@
+package virtuakube
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// imageBuildVersion is part of the cache key of built images. Bump
+// it when a change to the build process, outside of the Dockerfile,
+// or to a builtinCustomizeFuncs function makes previously built
+// images stale.
+const imageBuildVersion = 2
+
+// builtinCustomizeFuncs are the names of virtuakube's own
+// ImageCustomizeFuncs, which imageBuildVersion versions. Other
+// functions can change what they do without their names changing,
+// so images built with them are only cached with a CacheKey.
+var builtinCustomizeFuncs = map[string]bool{
+	funcName(CustomizeInstallK8s):        true,
+	funcName(CustomizePreloadK8sImages):  true,
+	funcName(CustomizeInstallBenchTools): true,
+	funcName(CustomizeInstallCloudInit):  true,
+}
+
+// funcName returns the name of the customize function f.
+func funcName(f ImageCustomizeFunc) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+}
+
+// imageCache returns the universe's image cache directory, or "" if
+// it doesn't use one.
+func (u *Universe) imageCache() string {
+	return u.runtimecfg.imageCacheDir()
+}
+
+// imageCacheDir returns the image cache directory of cfg, or "" if it
+// has none.
+func (cfg *UniverseConfig) imageCacheDir() string {
+	switch {
+	case cfg.NoImageCache:
+		return ""
+	case cfg.ImageCache != "":
+		return cfg.ImageCache
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "virtuakube")
+}
+
+// importCachedImage imports the disk image at path like importImage,
+// but through the universe's image cache: the image is stored in the
+// cache once, and every universe that imports it gets a hard link to
+// the cached copy instead of its own.
+func (u *Universe) importCachedImage(name, path, arch string) error {
+	prog := u.progress(name, "hashing image", 0)
+	sum, err := hashFile(path)
+	if err := prog.done(err); err != nil {
+		return fmt.Errorf("hashing %q: %v", path, err)
+	}
+	cached := filepath.Join(u.imageCache(), sum+".qcow2")
+
+	if _, err := os.Stat(cached); os.IsNotExist(err) {
+		if err := addToImageCache(path, cached); err != nil {
+			return fmt.Errorf("adding %q to image cache: %v", path, err)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	disk := randomDiskName()
+	if err := linkOrCopy(cached, filepath.Join(u.dir, disk)); err != nil {
+		return fmt.Errorf("linking cached image: %v", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.images[name] != nil {
+		os.Remove(filepath.Join(u.dir, disk))
+		return fmt.Errorf("universe already has an image named %q", name)
+	}
+	u.images[name] = &config.Image{
+		Name: name,
+		File: disk,
+		Arch: arch,
+	}
+	return nil
+}
+
+// addToImageCache copies the image at path into the cache as cached.
+// Cached images are read-only, since VMs of many universes are backed
+// by them.
+func addToImageCache(path, cached string) error {
+	dir := filepath.Dir(cached)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Copy to a temporary name first, so that other universes
+	// importing the same image never see a partial file.
+	tmp, err := ioutil.TempFile(dir, "import")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if out, err := exec.Command("cp", "--reflink=auto", "--sparse=always", path, tmp.Name()).CombinedOutput(); err != nil {
+		return fmt.Errorf("copying: %v (%s)", err, out)
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cached)
+}
+
+// linkOrCopy hard links src to dst. If they're on different
+// filesystems, dst is a copy of src instead, sharing its blocks where
+// the filesystem supports reflinks.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	if out, err := exec.Command("cp", "--reflink=auto", "--sparse=always", src, dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("copying %q: %v (%s)", src, err, out)
+	}
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// imageBuildKey returns the image cache key of the image that cfg
+// builds, a hash of everything that goes into it, or false if the
+// image can't be cached.
+func imageBuildKey(cfg *ImageConfig) (string, bool) {
+	if cfg.NoCache {
+		return "", false
+	}
+	var funcs []string
+	for _, f := range cfg.CustomizeFuncs {
+		name := funcName(f)
+		if !builtinCustomizeFuncs[name] && cfg.CacheKey == "" {
+			return "", false
+		}
+		funcs = append(funcs, name)
+	}
+	arch := normalizeArch(cfg.Arch)
+	bs, err := json.Marshal(struct {
+		Version                  int
+		Dockerfile, Hosts, Fstab string
+		Arch, Runtime, CacheKey  string
+		Customize                []string
+	}{imageBuildVersion, imageDockerfile(arch), hosts, fstab, arch, cfg.Runtime, cfg.CacheKey, funcs})
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// Extensions of the files of a built image in the image cache, and
+// of the image's files in the universe after its disk file name.
+const (
+	cachedDiskExt   = ".qcow2"
+	cachedKernelExt = ".vmlinuz"
+	cachedInitrdExt = ".initrd"
+)
+
+// useCachedBuild adds the built image cached at the base path
+// cached, if there is one, to the universe as name.
+func (u *Universe) useCachedBuild(name, arch, cached string) (bool, error) {
+	if _, err := os.Stat(cached + cachedDiskExt); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	prog := u.progress(name, "using cached image", 0)
+	disk := randomDiskName()
+	img := &config.Image{
+		Name: name,
+		File: disk,
+		Arch: arch,
+	}
+	files := map[string]string{disk: cached + cachedDiskExt}
+	if arch == ArchARM64 {
+		img.Kernel, img.Initrd = disk+cachedKernelExt, disk+cachedInitrdExt
+		files[img.Kernel] = cached + cachedKernelExt
+		files[img.Initrd] = cached + cachedInitrdExt
+	}
+	for f, src := range files {
+		if err := linkOrCopy(src, filepath.Join(u.dir, f)); err != nil {
+			for f := range files {
+				os.Remove(filepath.Join(u.dir, f))
+			}
+			return false, prog.done(fmt.Errorf("linking cached image: %v", err))
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.images[name] != nil {
+		for f := range files {
+			os.Remove(filepath.Join(u.dir, f))
+		}
+		return false, prog.done(fmt.Errorf("universe already has an image named %q", name))
+	}
+	u.images[name] = img
+	prog.done(nil)
+	return true, nil
+}
+
+// cacheBuild adds the universe's newly built image name to the image
+// cache at the base path cached, and replaces the universe's copy
+// with a link to the cached one.
+func (u *Universe) cacheBuild(name, cached string) error {
+	u.mu.Lock()
+	img := *u.images[name]
+	u.mu.Unlock()
+
+	files := map[string]string{img.File: cached + cachedDiskExt}
+	if img.Kernel != "" {
+		files[img.Kernel] = cached + cachedKernelExt
+		files[img.Initrd] = cached + cachedInitrdExt
+	}
+	for f, dst := range files {
+		if err := addToImageCache(filepath.Join(u.dir, f), dst); err != nil {
+			return err
+		}
+	}
+	for f, src := range files {
+		// Only a hard link saves space, a copy would be no better
+		// than the original.
+		path := filepath.Join(u.dir, f)
+		if err := os.Link(src, path+".link"); err != nil {
+			continue
+		}
+		if err := os.Rename(path+".link", path); err != nil {
+			os.Remove(path + ".link")
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedDownload returns the file at url from the image cache, or
+// fetches it with fetch and caches it if the cache doesn't have it.
+// Downloads are cached forever, so url must name something that
+// doesn't change, such as a release.
+func (u *Universe) cachedDownload(url string, fetch func() ([]byte, error)) ([]byte, error) {
+	path := u.downloadCachePath(url)
+	if path == "" {
+		if u.runtimecfg.Offline {
+			return nil, &MissingArtifactsError{[]string{"download of " + url}}
+		}
+		return fetch()
+	}
+	if bs, err := ioutil.ReadFile(path); err == nil {
+		return bs, nil
+	}
+	if u.runtimecfg.Offline {
+		return nil, &MissingArtifactsError{[]string{"download of " + url}}
+	}
+
+	bs, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCacheFile(path, bs); err != nil {
+		u.log.Warn("caching download failed", "url", url, "error", err)
+	}
+	return bs, nil
+}
+
+// downloadCachePath returns the path where the image cache keeps the
+// download of url, or "" if the universe has no image cache.
+func (u *Universe) downloadCachePath(url string) string {
+	cache := u.imageCache()
+	if cache == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(cache, "downloads", hex.EncodeToString(sum[:]))
+}
+
+// writeCacheFile writes bs to path in the image cache, so that other
+// universes never see a partial file.
+func writeCacheFile(path string, bs []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
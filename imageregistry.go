@@ -0,0 +1,271 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// registryPort is the port container registries listen on in their
+// VM.
+const registryPort = 5000
+
+// defaultRegistryImage is the container image that registry VMs run.
+const defaultRegistryImage = "registry:2"
+
+// RegistryConfig is the configuration for a container registry.
+type RegistryConfig struct {
+	Name string
+	// Image is the VM image to run the registry on. It must have
+	// Docker, as images built with CustomizeInstallK8s do.
+	Image string
+	// Network is the network the registry serves on. Clusters that
+	// use the registry must be on it.
+	Network   string
+	MemoryMiB int
+	// RegistryImage is the registry container image to run. It
+	// defaults to registry:2, which the VM pulls from Docker Hub if
+	// the image doesn't have it.
+	RegistryImage string
+}
+
+// Registry is a plain HTTP container registry running on a VM in the
+// universe. Clusters created with the registry in
+// ClusterConfig.Registries trust it, and the host can push to it
+// through a forwarded port.
+type Registry struct {
+	cfg *config.Registry
+	vm  *VM
+}
+
+// NewRegistry creates a VM running a container registry, and waits
+// for the registry to serve.
+func (u *Universe) NewRegistry(cfg *RegistryConfig) (*Registry, error) {
+	if cfg == nil || cfg.Name == "" {
+		return nil, errors.New("registry must have a name")
+	}
+	if cfg.Network == "" {
+		return nil, errors.New("registry must have a network")
+	}
+	u.mu.Lock()
+	exists := u.registries[cfg.Name] != nil
+	u.mu.Unlock()
+	if exists {
+		return nil, fmt.Errorf("universe already has a registry named %q", cfg.Name)
+	}
+	image := cfg.RegistryImage
+	if image == "" {
+		image = defaultRegistryImage
+	}
+	mem := cfg.MemoryMiB
+	if mem == 0 {
+		mem = 512
+	}
+
+	vm, err := u.NewVM(context.Background(), &VMConfig{
+		Name:         cfg.Name,
+		Image:        cfg.Image,
+		MemoryMiB:    mem,
+		Networks:     []string{cfg.Network},
+		PortForwards: map[int]bool{registryPort: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating registry VM: %v", err)
+	}
+
+	prog := u.progress(cfg.Name, "starting registry", 0)
+	err = vm.Start(context.Background())
+	if err == nil {
+		_, err = vm.Run(fmt.Sprintf("docker run -d --restart=always --name registry -p %d:5000 %s", registryPort, shellQuote(image)))
+	}
+	if err == nil {
+		err = waitRegistry(vm)
+	}
+	if err := prog.done(err); err != nil {
+		if derr := u.destroyVM(vm.Hostname()); derr != nil {
+			return nil, fmt.Errorf("%v (and destroying the registry VM failed: %v)", err, derr)
+		}
+		return nil, err
+	}
+
+	ret := &Registry{
+		cfg: &config.Registry{
+			Name:    cfg.Name,
+			VM:      vm.Hostname(),
+			Network: cfg.Network,
+		},
+		vm: vm,
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.registries[cfg.Name] = ret
+	return ret, nil
+}
+
+// waitRegistry waits for the registry on vm to answer API requests.
+func waitRegistry(vm *VM) error {
+	for i := 0; i < 120; i++ {
+		if _, err := vm.Run(fmt.Sprintf("curl -sf http://127.0.0.1:%d/v2/", registryPort)); err == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return errors.New("timed out waiting for the registry to serve")
+}
+
+// Registry returns the Registry with the given name, or nil if no
+// such Registry exists in the universe.
+func (u *Universe) Registry(name string) *Registry {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.registries[name]
+}
+
+// Registries returns a list of all Registries in the universe.
+func (u *Universe) Registries() []*Registry {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ret := make([]*Registry, 0, len(u.registries))
+	for _, r := range u.registries {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name() < ret[j].Name() })
+	return ret
+}
+
+// Name returns the name of the registry.
+func (r *Registry) Name() string {
+	return r.cfg.Name
+}
+
+// VM returns the VM that runs the registry.
+func (r *Registry) VM() *VM {
+	return r.vm
+}
+
+// Address returns the host:port that VMs on the registry's network
+// reach the registry at. Images pushed to the registry are pulled in
+// clusters as Address()/repository:tag.
+func (r *Registry) Address() string {
+	return (&net.TCPAddr{IP: r.vm.IPv4(r.cfg.Network), Port: registryPort}).String()
+}
+
+// HostAddress returns the localhost host:port that the host reaches
+// the registry at. Docker trusts localhost registries without TLS,
+// so `docker push` works out of the box.
+func (r *Registry) HostAddress() string {
+	return fmt.Sprintf("127.0.0.1:%d", r.vm.ForwardedPort(registryPort))
+}
+
+// Push pushes the host docker image ref to the registry, and returns
+// the reference that clusters pull it by.
+func (r *Registry) Push(ref string) (string, error) {
+	if err := checkTools([]string{"docker"}); err != nil {
+		return "", err
+	}
+
+	repo := ref
+	// Drop the source registry, if any. The first path component
+	// names a registry if it looks like a hostname.
+	if i := strings.Index(repo, "/"); i >= 0 {
+		if host := repo[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			repo = repo[i+1:]
+		}
+	}
+	hostRef := r.HostAddress() + "/" + repo
+
+	for _, args := range [][]string{
+		{"tag", ref, hostRef},
+		{"push", hostRef},
+		{"rmi", hostRef},
+	} {
+		cmd := exec.Command("docker", args...)
+		if err := r.vm.universe.runLogged(cmd); err != nil {
+			return "", fmt.Errorf("running docker %s: %v", strings.Join(args, " "), err)
+		}
+	}
+	return r.Address() + "/" + repo, nil
+}
+
+func (u *Universe) resumeRegistry(cfg *config.Registry) error {
+	vm := u.vms[cfg.VM]
+	if vm == nil {
+		return fmt.Errorf("registry %q: universe doesn't have its VM %q", cfg.Name, cfg.VM)
+	}
+	u.registries[cfg.Name] = &Registry{cfg: cfg, vm: vm}
+	return nil
+}
+
+// withRegistryMirrors merges containerd config that makes the CRI
+// plugin pull from registries over plain HTTP into snippet.
+func withRegistryMirrors(snippet string, addrs []string) (string, error) {
+	base, err := toml.Load(snippet)
+	if err != nil {
+		return "", fmt.Errorf("parsing containerd config snippet: %v", err)
+	}
+	var b strings.Builder
+	for _, addr := range addrs {
+		fmt.Fprintf(&b, "[plugins.\"io.containerd.grpc.v1.cri\".registry.mirrors.%q]\nendpoint = [%q]\n", addr, "http://"+addr)
+	}
+	mirrors, err := toml.Load(b.String())
+	if err != nil {
+		return "", err
+	}
+	mergeTOML(base, mirrors)
+	return base.ToTomlString()
+}
+
+// trustRegistries configures the container runtime on node to pull
+// from the cluster's registries over plain HTTP. containerd gets its
+// config from the cluster's containerd config snippet instead, see
+// withRegistryMirrors.
+func (c *Cluster) trustRegistries(node *VM) error {
+	if len(c.cfg.Registries) == 0 {
+		return nil
+	}
+	switch c.Runtime() {
+	case RuntimeContainerd:
+		return nil
+	case RuntimeCRIO:
+		return c.trustRegistriesCRIO(node)
+	}
+	return updateDockerDaemon(node, func(daemon map[string]interface{}) {
+		daemon["insecure-registries"] = c.cfg.Registries
+	})
+}
+
+// trustRegistriesCRIO configures CRI-O on node to pull from the
+// cluster's registries over plain HTTP, keeping its registry mirrors.
+func (c *Cluster) trustRegistriesCRIO(node *VM) error {
+	var mirrors map[string]string
+	if cfg := node.upstream(); cfg != nil {
+		mirrors = cfg.RegistryMirrors
+	}
+	return installCRIORegistries(node, c.cfg.Registries, mirrors)
+}
+
+// registryAddrsWithLock returns the addresses of the named
+// registries, which must serve on network.
+func (u *Universe) registryAddrsWithLock(names []string, network string) ([]string, error) {
+	var ret []string
+	for _, name := range names {
+		r := u.registries[name]
+		if r == nil {
+			return nil, fmt.Errorf("universe doesn't have a registry named %q", name)
+		}
+		if r.cfg.Network != network {
+			return nil, fmt.Errorf("registry %q is on network %q, not the cluster network %q", name, r.cfg.Network, network)
+		}
+		ret = append(ret, r.Address())
+	}
+	return ret, nil
+}
@@ -0,0 +1,69 @@
+package virtuakube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ImportVM creates an unstarted VM that boots from an externally
+// built disk image at diskPath, in any format that qemu-img
+// understands (typically qcow2 or raw). The VM runs on a
+// copy-on-write overlay, so the original disk is never modified, but
+// it must stay in place for as long as the universe references it.
+//
+// The disk needs to follow the same conventions as virtuakube's own
+// images (see the package documentation), in particular it must
+// accept root SSH logins. cfg.Image is ignored.
+//
+// Once imported, the VM behaves like any other VM in the universe,
+// and is included in snapshots.
+func (u *Universe) ImportVM(ctx context.Context, diskPath string, cfg *VMConfig) (*VM, error) {
+	if cfg == nil {
+		return nil, errors.New("no VMConfig specified")
+	}
+
+	diskPath, err := filepath.Abs(diskPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(diskPath); err != nil {
+		return nil, err
+	}
+
+	format, err := diskFormat(ctx, diskPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.newVMFromDiskWithLock(ctx, cfg, diskPath, format)
+}
+
+// diskFormat returns the disk format of the image at path, as
+// detected by qemu-img.
+func diskFormat(ctx context.Context, path string) (string, error) {
+	out, err := exec.CommandContext(ctx, "qemu-img", "info", "--output=json", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("inspecting disk %q: %v", path, err)
+	}
+	var info struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return "", fmt.Errorf("parsing qemu-img info for %q: %v", path, err)
+	}
+	if info.Format == "" {
+		return "", fmt.Errorf("qemu-img couldn't determine the format of %q", path)
+	}
+	return info.Format, nil
+}
@@ -0,0 +1,120 @@
+package virtuakube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// UniverseInfo describes a universe on disk, whether or not it's
+// open.
+type UniverseInfo struct {
+	Dir string
+	ID  string
+	// Snapshots are the universe's saved snapshots, sorted by name.
+	// The default snapshot is named "".
+	Snapshots []SnapshotInfo
+	// PID is the process that has the universe open, or 0 if it
+	// isn't running.
+	PID int
+}
+
+// SnapshotInfo summarizes the resources saved in a snapshot.
+type SnapshotInfo struct {
+	Name string
+	// Clock is the universe's clock when the snapshot was saved.
+	Clock    time.Time
+	Images   []string
+	Networks []string
+	VMs      []string
+	Clusters []string
+}
+
+// ReadUniverseInfo describes the universe in dir, without opening
+// it.
+func ReadUniverseInfo(dir string) (*UniverseInfo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Read(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &UniverseInfo{
+		Dir: dir,
+		ID:  cfg.ID,
+	}
+	for name, snap := range cfg.Snapshots {
+		ret.Snapshots = append(ret.Snapshots, snapshotInfo(name, snap))
+	}
+	sort.Slice(ret.Snapshots, func(i, j int) bool { return ret.Snapshots[i].Name < ret.Snapshots[j].Name })
+	if r, err := Attach(dir); err == nil {
+		ret.PID = r.PID()
+	}
+	return ret, nil
+}
+
+// ListUniverses describes the universes in dir: dir itself if it's a
+// universe, otherwise its immediate subdirectories that are.
+func ListUniverses(dir string) ([]*UniverseInfo, error) {
+	if isUniverseDir(dir) {
+		info, err := ReadUniverseInfo(dir)
+		if err != nil {
+			return nil, err
+		}
+		return []*UniverseInfo{info}, nil
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*UniverseInfo
+	for _, fi := range fis {
+		sub := filepath.Join(dir, fi.Name())
+		if !fi.IsDir() || !isUniverseDir(sub) {
+			continue
+		}
+		info, err := ReadUniverseInfo(sub)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, info)
+	}
+	return ret, nil
+}
+
+func isUniverseDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "config.json"))
+	return err == nil
+}
+
+func snapshotInfo(name string, snap *config.Snapshot) SnapshotInfo {
+	ret := SnapshotInfo{
+		Name:  name,
+		Clock: snap.Clock,
+	}
+	for name := range snap.Images {
+		ret.Images = append(ret.Images, name)
+	}
+	for name := range snap.Networks {
+		ret.Networks = append(ret.Networks, name)
+	}
+	for name := range snap.VMs {
+		ret.VMs = append(ret.VMs, name)
+	}
+	for name := range snap.Clusters {
+		ret.Clusters = append(ret.Clusters, name)
+	}
+	sort.Strings(ret.Images)
+	sort.Strings(ret.Networks)
+	sort.Strings(ret.VMs)
+	sort.Strings(ret.Clusters)
+	return ret
+}
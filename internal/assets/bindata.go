@@ -1,6 +1,7 @@
 // Code generated by go-bindata. DO NOT EDIT.
 // sources:
-// addon-images (513B)
+// addon-images (552B)
+// metrics-server.yaml (3.381kB)
 // registry.yaml (1.01kB)
 // net/calico.yaml (16.713kB)
 // net/flannel.yaml (10.598kB)
@@ -74,7 +75,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _addonImages = "\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xac\xca\x49\x0e\xc2\x30\x0c\x40\xd1\xab\x70\x81\xba\xe9\x40\x25\x72\x1b\xe3\x1a\x88\x9a\xc6\xc1\xe9\x40\x6f\x8f\x10\xc3\x82\x05\x62\x91\xdd\xd7\xd7\x53\x3e\xbb\x34\xe9\x66\xeb\x1d\xa1\x77\x24\xe5\xb4\xc5\x0b\xda\xa5\x81\x06\x3e\x2f\x48\xcf\x5f\x8b\x82\x7b\x9f\xeb\x8c\x1b\x38\x29\x49\x94\x25\x95\x27\x8f\x21\xb0\xb7\x8b\x81\xca\x80\x29\x70\xec\xbb\x36\x0f\xd2\x31\x1f\xca\x40\x62\xa4\xae\xf5\x9c\x89\xa5\xe6\x60\x6e\xff\xa1\x5e\x68\x60\x7d\xb0\x95\x71\xe1\x55\x74\x48\xcf\x2c\x86\xf9\xc8\xb6\x86\x3d\x54\xbf\x54\x88\xf4\x42\xf7\x00\x00\x00\xff\xff\x82\xdb\x99\x45\x01\x02\x00\x00"
+var _addonImages = "\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xac\xd1\xc1\x6e\x84\x20\x10\xc6\xf1\x57\xf1\x05\x1c\x51\xd4\xb4\xbc\x0d\x1d\xa7\x96\x80\x40\x07\xc5\xfa\xf6\x8d\x6b\x76\x0f\x7b\xd8\xec\x81\xdb\xe4\xcb\x2f\xf9\x1f\xc6\x7e\x24\x98\x91\xc1\x84\x66\xa1\x95\x0d\xa6\x3a\x11\x67\xe2\x5a\x2f\xd3\xd8\xab\x2c\x40\xc2\x58\x31\xcd\x26\xad\x7c\xa8\xae\x42\xed\x0c\x86\x66\x3d\xe2\x8f\x56\x59\x82\x84\xc7\xe6\xc3\x44\x4f\x13\x7a\x73\x5f\x7e\x37\x7d\x9c\x1d\x0c\x4c\x21\x35\xdf\x4e\x7b\x4f\xee\x2c\xb4\x02\xc4\xd5\x2b\x83\x78\x29\x87\x0a\x90\x18\x71\xec\x1d\x15\x62\x49\x7e\x8a\xbf\xf7\xd0\x14\xd0\xd2\xed\xb7\x3b\xe9\x4c\x7b\x60\x9b\xae\xb3\xb6\xdb\x17\xa9\x0e\x06\x68\x5f\x29\x1f\x51\x75\x30\x40\x5b\xfd\x0f\x00\x64\x3f\xe0\x93\x28\x02\x00\x00"
 
 func addonImagesBytes() ([]byte, error) {
 	return bindataRead(
@@ -90,7 +91,27 @@ func addonImages() (*asset, error) {
 	}
 
 	info := bindataFileInfo{name: "addon-images", size: 0, mode: os.FileMode(0), modTime: time.Unix(0, 0)}
-	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xb9, 0xdd, 0x66, 0xe7, 0x15, 0xf8, 0xbc, 0x7b, 0xa2, 0xd8, 0x67, 0x41, 0x79, 0x90, 0x1f, 0xeb, 0x5, 0x9e, 0xfa, 0x24, 0x9, 0x7f, 0x6b, 0x16, 0xfd, 0x9, 0xb4, 0xe3, 0x24, 0xa4, 0xc6, 0xa5}}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xc0, 0xd6, 0xb6, 0x10, 0x87, 0x97, 0x53, 0x46, 0x11, 0x65, 0x2c, 0x6a, 0x2a, 0xe3, 0xb1, 0x49, 0x6b, 0x72, 0x82, 0x9, 0x50, 0xa4, 0x4d, 0x77, 0x75, 0x63, 0x26, 0xe8, 0xdb, 0xca, 0x9f, 0x8b}}
+	return a, nil
+}
+
+var _metricsServerYaml = "\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xb4\x56\x51\x6f\xe3\x36\x0c\x7e\xf7\xaf\x20\xbc\xd7\x29\xed\xe1\x8a\xc3\x41\x40\x1f\xba\x1e\x36\x14\xb8\x76\x41\x7b\xbb\x97\xe1\x1e\x14\x99\x71\xb5\xc8\x92\x40\xd1\x69\xbd\x61\xff\x7d\x90\x63\x3b\xb1\x1b\xf7\x70\x6d\x57\x04\x88\x6b\x8a\xe4\xc7\x8f\x9f\x18\x0a\x21\x32\x15\xcc\x57\xa4\x68\xbc\x93\x40\x2b\xa5\x17\xaa\xe6\x7b\x4f\xe6\x6f\xc5\xc6\xbb\xc5\xe6\x63\x5c\x18\x7f\xb2\x7d\x97\x6d\x8c\x2b\x24\x5c\xda\x3a\x32\xd2\xad\xb7\x98\x55\xc8\xaa\x50\xac\x64\x06\xe0\x54\x85\x12\x62\x13\x19\x2b\xa9\xca\x92\xb0\x54\x8c\x85\xa8\x90\xc9\xe8\x28\x08\x55\x81\x94\x01\x58\xb5\x42\x1b\x93\x0b\x3c\x93\x6f\x88\x20\xd8\x8b\xad\xc1\x07\x09\x39\x53\x8d\xf9\x8f\xf8\x61\x61\xf8\x25\x7e\xaa\xa8\x8c\x1b\x1c\xa9\xb6\x18\x65\x26\x40\x05\xf3\x1b\xf9\x3a\x44\x09\x7f\xe6\x5d\x5d\x9d\x7f\xfe\x2d\x03\x20\x8c\xbe\x26\x8d\xad\x3d\xf8\x22\xe6\x3f\x43\xee\x7c\x81\xb1\x35\x6f\x91\x56\xad\xa9\x44\x4e\x16\x6b\x62\xfb\xfd\xa0\x58\xdf\xe7\xdf\xb2\xd7\x35\xe3\x17\xe3\x0a\xe3\xca\x23\x3d\xe9\x3b\x10\x91\xb6\x48\xb2\x6f\x51\xcd\xf7\xa2\x40\x9b\x48\xf6\x94\x91\xb7\x78\x8b\xeb\xd4\x96\xbe\xcc\x67\x10\x64\x00\x4f\xd5\x30\xd5\xc0\x38\x41\xac\x57\x7f\xa1\xe6\x96\xc8\x9d\xef\x1d\xd2\xd6\x68\xbc\xd0\xda\xd7\x8e\x67\xe0\x76\xaf\x63\x50\x1a\x25\x6c\xea\x15\x8a\x5d\xfc\x97\xd0\xf5\x23\x3c\x89\x14\x6a\xaf\xda\x19\x10\x2f\xa4\x6d\xc4\x17\x3e\x32\xba\xd4\x72\xa1\x82\x39\x48\x8e\x8e\x8d\x6e\xdd\x7b\x18\xff\x3b\x87\x2a\x18\xc2\xd2\x44\xa6\x09\x81\x2b\x64\xd5\xb3\x78\xb1\xbc\xea\xd2\x1e\x21\xb1\x3b\xba\xe8\x10\xf4\x85\xc7\x80\x3a\x31\x9d\xca\x33\x1a\xd3\xe3\x2c\xd6\x59\xb4\x00\xe5\x4e\x97\x93\xe0\x00\xdb\xbe\x80\x1e\x29\x80\x71\x11\x75\x4d\x78\xb7\x31\xe1\xcb\xe7\xbb\xaf\x48\x66\xdd\x48\x48\x33\xa4\x0f\xb4\x24\xe3\xc9\x70\x73\x6d\x9c\xa9\xea\x4a\xc2\xbb\xd3\xd3\x7d\xb0\xde\xba\x7b\x3d\x65\x6a\xd0\xd4\xa4\x03\xdf\x93\x55\x36\x5b\xdd\x34\x83\x0a\x21\xee\xa5\xfb\x09\x83\xf5\x4d\x85\xaf\x4a\x31\x1e\xbe\x9b\x8f\x51\xa8\x10\x9e\xb8\xef\x7b\x65\x51\xb3\xa7\xf4\x0c\x50\xa5\x29\xf5\xf9\xc0\x7d\x3e\x00\x00\x63\x15\xac\xe2\xae\xcf\x87\x80\x9f\xed\xfb\x18\xdf\xf3\x29\x00\x7a\x9c\x00\x83\xae\xba\x2e\xdc\xcc\x27\xd8\x7a\x5b\x57\x38\x64\xf8\x09\xaa\xd4\x36\x30\x0e\xb8\x0a\x10\x3d\x3c\x20\x68\xe5\x20\xaa\x35\xda\x06\xea\x88\xb0\x26\x5f\x89\xa8\x29\x11\x00\xa6\x52\x25\x46\x50\xae\x38\xf1\x04\xe9\x5e\x0a\xef\x6c\x03\xda\x3b\x56\xc6\x21\xc5\x2e\xb2\xe8\xca\xe4\x2a\x88\xc2\xf4\xe9\x01\xb0\x0a\xdc\x7c\x32\x24\xe1\x9f\x7f\xbb\x97\x7b\x5f\x39\x71\x3e\x5a\x02\xec\x40\xc8\xc4\xff\xa2\xd4\x94\x7e\x11\xc6\x07\x85\xaa\x8a\x0f\x67\x72\x7b\xba\x78\xbf\xf8\x30\xf6\x5a\xd6\xd6\x2e\xbd\x35\xba\x91\x70\xb5\xbe\xf1\xbc\x24\x8c\x49\x55\xfd\x29\x45\xe5\x00\x23\x7d\x04\x08\xa1\x91\x38\x15\x71\x7e\xc2\x55\x98\xd8\x76\xb7\x4c\x04\x4f\x7c\x7e\x76\x76\xf6\x7e\x62\x4e\x0a\xb7\xc8\xa2\xbf\x8e\x82\x6d\x9c\x39\x12\x08\xd7\x48\x84\x85\x50\x45\x41\x18\xa3\xe0\x26\x60\x3c\xbf\x72\x8c\xe4\x94\xbd\x5a\x0e\x8e\x29\xdb\x01\xca\x81\x2e\x65\x5c\x8b\x64\xb0\x1c\x90\xbb\xf4\xc4\x12\x26\x10\x03\x79\xf6\xda\x5b\x09\x5f\x2e\xf7\xe1\x5b\xa8\x86\x9b\x4b\xef\x18\x1f\xf9\x90\x8e\xd4\xf1\xdf\x9d\x6d\x6e\xbd\xe7\x5f\x8d\xc5\xee\xc7\xae\x9f\x2b\xfd\x1f\xd5\xee\x22\xde\x78\x97\x8e\x1d\x37\xfe\x11\x91\xda\xd1\x72\x3a\x58\x76\xda\xbc\x4e\x82\x3c\x52\xdc\x54\x48\xb0\x93\xee\x52\xf1\xbd\x84\x83\xc6\xa4\x85\xe3\x6e\x74\x75\xd3\x27\x8d\xc5\x45\x62\x9a\x1c\x32\xa6\xb1\x79\xe2\xa3\x04\x6b\x5c\xfd\xf8\xdd\xe1\xf6\x76\x23\x67\x04\x20\xb9\x48\xc8\xaf\x47\xa1\xf2\x23\x07\xf5\x6e\xcd\x11\xfd\xaf\x47\xbf\x99\x1d\x9f\x54\xf3\x33\x63\x90\x8d\x80\xd0\xa9\xe1\x7d\x76\x5c\x06\xac\xa8\x44\xde\x69\x66\x2f\xab\x17\xec\x1c\x87\x1b\xd2\x53\x1a\x3b\xf9\x4c\x90\x1e\xd9\x38\x5b\xd0\x79\x3e\xda\x32\xbb\x42\x8a\x74\xa1\x44\xdb\xf7\x83\xa7\x93\xc8\x8a\xbb\xff\xfb\xd6\xc4\x61\x0b\x6d\xdf\x97\xc8\xed\x77\x5a\x44\xdb\x87\x76\x13\x7d\x65\x91\xf3\xfb\xd5\x4c\xad\x6f\xbe\x78\x4e\x12\xbc\xf9\xd2\xf4\xdf\x00\xcb\x0f\x5f\x04\x35\x0d\x00\x00"
+
+func metricsServerYamlBytes() ([]byte, error) {
+	return bindataRead(
+		_metricsServerYaml,
+		"metrics-server.yaml",
+	)
+}
+
+func metricsServerYaml() (*asset, error) {
+	bytes, err := metricsServerYamlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "metrics-server.yaml", size: 0, mode: os.FileMode(0), modTime: time.Unix(0, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xfe, 0xa8, 0x6a, 0x45, 0x7c, 0x7e, 0x5c, 0x99, 0xf8, 0x3f, 0x88, 0xbf, 0xf1, 0x9c, 0xa2, 0x12, 0xe0, 0xb2, 0xfe, 0xd3, 0xcd, 0x54, 0xc2, 0x4c, 0x24, 0x78, 0x72, 0xa0, 0xf, 0xc7, 0x37, 0x55}}
 	return a, nil
 }
 
@@ -267,6 +288,8 @@ func AssetNames() []string {
 var _bindata = map[string]func() (*asset, error){
 	"addon-images": addonImages,
 
+	"metrics-server.yaml": metricsServerYaml,
+
 	"registry.yaml": registryYaml,
 
 	"net/calico.yaml": netCalicoYaml,
@@ -317,7 +340,8 @@ type bintree struct {
 }
 
 var _bintree = &bintree{nil, map[string]*bintree{
-	"addon-images": &bintree{addonImages, map[string]*bintree{}},
+	"addon-images":        &bintree{addonImages, map[string]*bintree{}},
+	"metrics-server.yaml": &bintree{metricsServerYaml, map[string]*bintree{}},
 	"net": &bintree{nil, map[string]*bintree{
 		"calico.yaml":  &bintree{netCalicoYaml, map[string]*bintree{}},
 		"flannel.yaml": &bintree{netFlannelYaml, map[string]*bintree{}},
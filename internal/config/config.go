@@ -8,6 +8,7 @@ import (
 )
 
 type Universe struct {
+	ID        string
 	Snapshots map[string]*Snapshot
 }
 
@@ -17,15 +18,27 @@ type Snapshot struct {
 	NextPort int
 	NextNet  int
 	Clock    time.Time
+	// Ports records every localhost port ever allocated to a VM port
+	// forward, keyed by "vm:port".
+	Ports map[string]int
 
 	Networks map[string]*Network
 	Images   map[string]*Image
 	VMs      map[string]*VM
 	Clusters map[string]*Cluster
+	// Nil for snapshots saved before registries existed.
+	Registries map[string]*Registry
+}
+
+type Registry struct {
+	Name    string
+	VM      string
+	Network string
 }
 
 type Network struct {
 	Name     string
+	MTU      int
 	NextIPv4 net.IP
 	NextIPv6 net.IP
 }
@@ -33,23 +46,219 @@ type Network struct {
 type Image struct {
 	Name string
 	File string
+	// Empty for images saved before arm64 support, which are amd64.
+	Arch string
+	// Kernel and initrd that arm64 VMs boot directly, as arm64
+	// images have no bootloader.
+	Kernel string
+	Initrd string
+}
+
+// Files returns the files of the image, relative to the universe
+// directory.
+func (i *Image) Files() []string {
+	ret := []string{i.File}
+	if i.Kernel != "" {
+		ret = append(ret, i.Kernel, i.Initrd)
+	}
+	return ret
 }
 
 type VM struct {
 	Name         string
 	DiskFile     string
 	MemoryMiB    int
+	CPUs         int // zero for VMs saved before CPUs were configurable, which have one
+	CPUModel     string
 	PortForwards map[int]int
 	Networks     []string
 	MAC          map[string]string // network name -> MAC in that network
 	IPv4         map[string]net.IP // network name -> IP in that network
 	IPv6         map[string]net.IP
+	MTU          map[string]int
+	DiskLimits   DiskLimits
+	MachineType  string
+	TmpfsDisk    bool
+	// Firmware is "uefi" for UEFI VMs, which have their own variable
+	// store in VarsFile, and "" or "bios" for BIOS VMs.
+	Firmware   string
+	SecureBoot bool
+	VarsFile   string
+	TPM        bool
+	NestedVirt bool
+	// Whether the VM has a memory balloon device, which VMs saved
+	// before ballooning was supported don't. BalloonMiB is the
+	// memory the VM is ballooned down to, zero if it isn't.
+	Balloon    bool
+	BalloonMiB int
+	// Whether the VM has a guest agent port, which VMs saved before
+	// guest agent support don't.
+	GuestAgent bool
+	// Copied from the VM's image. Arch is empty for VMs saved
+	// before arm64 support, which are amd64.
+	Arch      string
+	Kernel    string
+	Initrd    string
+	CloudInit *CloudInit
+	Disks     []Disk
+	Mounts    []Mount
+	Devices   []Device
+	// Extra SSH keys that can log in as root.
+	AuthorizedKeys []string
+	// Impaired links, by peer VM name.
+	Links map[string]Link
+	// MACs of the VMs this VM is partitioned from, by VM name then
+	// network name.
+	Partitioned map[string]map[string]string
+	// Host bridge that the VM's LAN NIC is attached to, if any.
+	Bridge    string
+	BridgeMAC string
+	// How far ahead of the universe's clock the guest's clock runs.
+	ClockOffset time.Duration
+	// Number of PCIe root ports for hot-plugged NICs, and the NICs
+	// currently plugged into them.
+	HotplugNICs int
+	NICs        []NIC
+}
+
+// NIC is a NIC hot-plugged into a VM, on a universe network.
+type NIC struct {
+	ID      string
+	Network string
+	// Index of the root port the NIC is plugged into.
+	Slot int
+	MAC  string
+	IPv4 net.IP
+	IPv6 net.IP
+}
+
+// Files returns the disk files of the VM.
+func (v *VM) Files() []string {
+	ret := []string{v.DiskFile}
+	for _, d := range v.Disks {
+		ret = append(ret, d.File)
+	}
+	if v.VarsFile != "" {
+		ret = append(ret, v.VarsFile)
+	}
+	return ret
+}
+
+type Link struct {
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64
+	Rate    int64
+	// The peer's addresses, by network name.
+	IPv4 map[string]net.IP
+	IPv6 map[string]net.IP
+}
+
+type Mount struct {
+	HostPath  string
+	GuestPath string
+	ReadOnly  bool
+}
+
+// Device is a host device passed through to a VM, by PCI address or
+// mediated device UUID.
+type Device struct {
+	PCIAddress string
+	MdevUUID   string
+}
+
+type Disk struct {
+	File    string
+	Format  string
+	SizeMiB int
+	Serial  string
+	Limits  DiskLimits
+}
+
+type CloudInit struct {
+	UserData string
+	MetaData string
+}
+
+type DiskLimits struct {
+	IOPS      int
+	Bandwidth int64
 }
 
 type Cluster struct {
-	Name       string
-	NumNodes   int
-	Kubeconfig []byte
+	Name     string
+	NumNodes int
+	Nodes    []string
+	// Additional control planes and their load balancer, for HA
+	// clusters.
+	ControlPlanes []string
+	LoadBalancer  string
+	CNI           string
+	CNIOptions    map[string]string
+	// Empty for clusters saved before versions were configurable.
+	KubernetesVersion string
+	MTU               int
+	Kubeconfig        []byte
+	// Name of the cluster's context in Kubeconfig.
+	KubeContext string
+
+	MetricsServer    bool
+	Cloud            *Cloud
+	SecretEncryption *SecretEncryption
+	// Template for worker nodes added to the running cluster. Nil for
+	// clusters saved before nodes could be added.
+	NodeTemplate *NodeTemplate
+	// Addresses of the registries that nodes trust.
+	Registries []string
+	// Empty for clusters saved before IPv6 support, which are IPv4.
+	IPFamily string
+	// Empty for clusters saved before the container runtime was
+	// configurable, which run Docker.
+	Runtime string
+	// YAML patches of the kubeadm config of the cluster's nodes.
+	KubeadmPatches string
+	// IPv4 pod network of clusters with connected pod networks, and
+	// the routes to their pods that other connected clusters have.
+	// Empty for other clusters, which use the default pod network.
+	PodNetwork string
+	PodRoutes  []string
+	// PEM CA certificates that the nodes trust.
+	TrustedCAs [][]byte
+	// Whether Services are exposed on localhost, and the NodePorts
+	// that are forwarded from the controller for them.
+	ExposeServices   bool
+	ExposedNodePorts []int
+}
+
+type NodeTemplate struct {
+	Image          string
+	MemoryMiB      int
+	CPUs           int
+	CPUModel       string
+	Networks       []string
+	PortForwards   []int
+	DiskLimits     DiskLimits
+	MachineType    string
+	Firmware       string
+	SecureBoot     bool
+	TPM            bool
+	NestedVirt     bool
+	HotplugNICs    int
+	AuthorizedKeys []string
+	// Disks have no File.
+	Disks  []Disk
+	Mounts []Mount
+}
+
+type SecretEncryption struct {
+	Provider string
+	// Keys[0] encrypts, the others only decrypt.
+	Keys [][]byte
+}
+
+type Cloud struct {
+	LoadBalancers map[string]string // namespace/name -> IP
+	Deleted       map[string]bool   // nodes deleted from the cloud
 }
 
 func Read(path string) (*Universe, error) {
@@ -75,3 +284,16 @@ func Write(path string, cfg *Universe) error {
 	}
 	return nil
 }
+
+// Copy returns a deep copy of snap.
+func Copy(snap *Snapshot) (*Snapshot, error) {
+	bs, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	var ret Snapshot
+	if err := json.Unmarshal(bs, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
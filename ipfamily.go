@@ -0,0 +1,101 @@
+package virtuakube
+
+import (
+	"fmt"
+	"net"
+)
+
+// IP families of cluster networking, for ClusterConfig.IPFamily.
+const (
+	// IPFamilyIPv4 gives pods and services IPv4 addresses only.
+	IPFamilyIPv4 = "ipv4"
+	// IPFamilyDual gives pods and services both IPv4 and IPv6
+	// addresses. Requires Kubernetes 1.21 or later.
+	IPFamilyDual = "dual"
+	// IPFamilyIPv6 gives pods and services IPv6 addresses only, and
+	// nodes talk to each other over IPv6. Requires Kubernetes 1.18
+	// or later.
+	IPFamilyIPv6 = "ipv6"
+)
+
+const (
+	podNetwork6  = "fd01:32::/48"
+	serviceCIDR6 = "fd01:96::/112"
+)
+
+// validateIPFamily checks that clusters can run cfg's IP family.
+func (cfg *ClusterConfig) validateIPFamily() error {
+	minMinor := 0
+	switch cfg.IPFamily {
+	case "", IPFamilyIPv4:
+		return nil
+	case IPFamilyDual:
+		minMinor = 21
+	case IPFamilyIPv6:
+		minMinor = 18
+	default:
+		return fmt.Errorf("unknown IPFamily %q", cfg.IPFamily)
+	}
+	version := cfg.KubernetesVersion
+	if version == "" {
+		version = defaultKubernetesVersion
+	}
+	minor, err := parseKubeVersion(version)
+	if err != nil {
+		return err
+	}
+	if minor < minMinor {
+		return fmt.Errorf("IPFamily %q requires Kubernetes 1.%d or later", cfg.IPFamily, minMinor)
+	}
+	return nil
+}
+
+// ipFamily returns the cluster's IP family.
+func (c *Cluster) ipFamily() string {
+	if c.cfg.IPFamily == "" {
+		return IPFamilyIPv4
+	}
+	return c.cfg.IPFamily
+}
+
+// clusterIP returns the address that vm serves cluster traffic on.
+func (c *Cluster) clusterIP(vm *VM) net.IP {
+	if c.ipFamily() == IPFamilyIPv6 {
+		return vm.IPv6(vm.Networks()[0])
+	}
+	return vm.IPv4(vm.Networks()[0])
+}
+
+// nodeIPs returns the kubelet --node-ip value for vm.
+func (c *Cluster) nodeIPs(vm *VM) string {
+	if c.ipFamily() == IPFamilyDual {
+		return fmt.Sprintf("%s,%s", vm.IPv4(vm.Networks()[0]), vm.IPv6(vm.Networks()[0]))
+	}
+	return c.clusterIP(vm).String()
+}
+
+// kubeadmNetworking returns the networking section of kubeadm's
+// ClusterConfiguration.
+func (c *Cluster) kubeadmNetworking() string {
+	pods, services := c.podNetwork(), serviceCIDR
+	switch c.ipFamily() {
+	case IPFamilyDual:
+		pods += "," + podNetwork6
+		services += "," + serviceCIDR6
+	case IPFamilyIPv6:
+		pods, services = podNetwork6, serviceCIDR6
+	}
+	return fmt.Sprintf("networking:\n  podSubnet: %q\n  serviceSubnet: %q", pods, services)
+}
+
+// setupIPFamily prepares node's networking for the cluster's IP
+// family. Kubernetes components expect a default route for each IP
+// family they use, and universe networks have no IPv6 router, so
+// IPv6 clusters get an on-link default route on the cluster network.
+func (c *Cluster) setupIPFamily(node *VM) error {
+	if c.ipFamily() == IPFamilyIPv4 {
+		return nil
+	}
+	_, err := node.Run("ip -6 route replace default dev " + nicInterface(0))
+	return err
+}
@@ -0,0 +1,122 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// kubeadmPatchKinds are the config kinds that KubeadmConfigPatches
+// can patch, with the API version of the documents that patches of a
+// kind kubeadm's config doesn't have yet create.
+var kubeadmPatchKinds = map[string]string{
+	"InitConfiguration":      "",
+	"ClusterConfiguration":   "",
+	"JoinConfiguration":      "",
+	"KubeletConfiguration":   "kubelet.config.k8s.io/v1beta1",
+	"KubeProxyConfiguration": "kubeproxy.config.k8s.io/v1alpha1",
+}
+
+var yamlDocSepRe = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// parseKubeadmPatches parses patches into documents, checking that
+// each has a kind that can be patched.
+func parseKubeadmPatches(patches string) ([]map[string]interface{}, error) {
+	var ret []map[string]interface{}
+	for _, doc := range yamlDocSepRe.Split(patches, -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		m := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(doc), &m); err != nil {
+			return nil, err
+		}
+		if len(m) == 0 {
+			continue
+		}
+		kind, _ := m["kind"].(string)
+		if kind == "" {
+			return nil, errors.New("patch has no kind")
+		}
+		if _, ok := kubeadmPatchKinds[kind]; !ok {
+			return nil, fmt.Errorf("can't patch kind %q", kind)
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+// kubeadmConfig returns the kubeadm config file cfg, with the
+// cluster's KubeadmConfigPatches applied. Patches of kinds that cfg
+// doesn't have are dropped, except that KubeletConfiguration and
+// KubeProxyConfiguration patches are added to kubeadm init's config.
+func (c *Cluster) kubeadmConfig(cfg string) (string, error) {
+	if c.cfg.KubeadmPatches == "" {
+		return cfg, nil
+	}
+	patches, err := parseKubeadmPatches(c.cfg.KubeadmPatches)
+	if err != nil {
+		return "", fmt.Errorf("parsing kubeadm config patches: %v", err)
+	}
+
+	var docs []map[string]interface{}
+	byKind := map[string]map[string]interface{}{}
+	for _, doc := range yamlDocSepRe.Split(cfg, -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		m := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(doc), &m); err != nil {
+			return "", fmt.Errorf("parsing kubeadm config: %v", err)
+		}
+		docs = append(docs, m)
+		if kind, ok := m["kind"].(string); ok {
+			byKind[kind] = m
+		}
+	}
+
+	for _, patch := range patches {
+		kind := patch["kind"].(string)
+		if doc := byKind[kind]; doc != nil {
+			mergeYAML(doc, patch)
+			continue
+		}
+		apiVersion := kubeadmPatchKinds[kind]
+		if apiVersion == "" || byKind["InitConfiguration"] == nil {
+			continue
+		}
+		doc := map[string]interface{}{"apiVersion": apiVersion}
+		mergeYAML(doc, patch)
+		docs = append(docs, doc)
+		byKind[kind] = doc
+	}
+
+	var b strings.Builder
+	for _, doc := range docs {
+		bs, err := yaml.Marshal(doc)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString("---\n")
+		b.Write(bs)
+	}
+	return b.String(), nil
+}
+
+// mergeYAML merges overlay into base. Maps are merged recursively,
+// any other value in overlay, including lists, replaces the one in
+// base.
+func mergeYAML(base, overlay map[string]interface{}) {
+	for k, ov := range overlay {
+		if om, ok := ov.(map[string]interface{}); ok {
+			if bm, ok := base[k].(map[string]interface{}); ok {
+				mergeYAML(bm, om)
+				continue
+			}
+		}
+		base[k] = ov
+	}
+}
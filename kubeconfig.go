@@ -0,0 +1,276 @@
+package virtuakube
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// randomUniverseID returns a short identifier for a universe, used to
+// make names that must be unique across universes.
+func randomUniverseID() string {
+	rnd := make([]byte, 3)
+	if _, err := rand.Read(rnd); err != nil {
+		panic("system ran out of randomness")
+	}
+	return fmt.Sprintf("%x", rnd)
+}
+
+// renameKubeconfig renames the cluster, user and context in a
+// kubeadm-generated kubeconfig, which has the same generic names for
+// every cluster, so that it can be merged with other kubeconfigs
+// without collisions.
+func renameKubeconfig(bs []byte, name string) ([]byte, error) {
+	cfg, err := clientcmd.Load(bs)
+	if err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig: %v", err)
+	}
+	ctx := cfg.Contexts[cfg.CurrentContext]
+	if ctx == nil {
+		return nil, fmt.Errorf("kubeconfig has no context %q", cfg.CurrentContext)
+	}
+	cluster, user := cfg.Clusters[ctx.Cluster], cfg.AuthInfos[ctx.AuthInfo]
+	if cluster == nil || user == nil {
+		return nil, fmt.Errorf("kubeconfig context %q is incomplete", cfg.CurrentContext)
+	}
+
+	userName := name + "-admin"
+	cfg.Clusters = map[string]*clientcmdapi.Cluster{name: cluster}
+	cfg.AuthInfos = map[string]*clientcmdapi.AuthInfo{userName: user}
+	ctx.Cluster, ctx.AuthInfo = name, userName
+	cfg.Contexts = map[string]*clientcmdapi.Context{name: ctx}
+	cfg.CurrentContext = name
+
+	return clientcmd.Write(*cfg)
+}
+
+// KubeContext returns the name of the cluster's context in its
+// kubeconfig.
+func (c *Cluster) KubeContext() string {
+	return c.cfg.KubeContext
+}
+
+// ExportKubeconfig returns a copy of the cluster's kubeconfig that
+// reaches the API server at host, through the API server's port
+// forward. Host defaults to 127.0.0.1. The forward only listens on
+// 127.0.0.1 and the universe's KubeconfigHost, so other hosts must
+// relay it themselves.
+func (c *Cluster) ExportKubeconfig(host string) ([]byte, error) {
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	cfg, err := clientcmd.Load(c.cfg.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig: %v", err)
+	}
+	server := "https://" + net.JoinHostPort(host, strconv.Itoa(c.apiServerVM().ForwardedPort(6443)))
+	for _, cluster := range cfg.Clusters {
+		cluster.Server = server
+	}
+	return clientcmd.Write(*cfg)
+}
+
+// relayAPIServer makes the API server reachable at the universe's
+// KubeconfigHost, which the exported and merged kubeconfigs point
+// at. QEMU only binds port forwards to 127.0.0.1, so for other hosts
+// the API server's forward is relayed from the same port on
+// KubeconfigHost, until the universe closes.
+func (c *Cluster) relayAPIServer() error {
+	host := c.universe.runtimecfg.KubeconfigHost
+	if host == "" || net.ParseIP(host).Equal(net.IPv4(127, 0, 0, 1)) || c.apiRelay != nil {
+		return nil
+	}
+	port := strconv.Itoa(c.apiServerVM().ForwardedPort(6443))
+	l, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("relaying API server of cluster %q to KubeconfigHost: %v", c.Name(), err)
+	}
+	c.apiRelay = l
+	go func() {
+		<-c.universe.closedCh
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go relay(conn, net.JoinHostPort("127.0.0.1", port))
+		}
+	}()
+	return nil
+}
+
+// relay copies between conn and a new connection to target.
+func relay(conn net.Conn, target string) {
+	defer conn.Close()
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- true
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- true
+	}()
+	<-done
+}
+
+// exportedKubeconfig returns the path in the universe dir where Save
+// exports the named cluster's kubeconfig.
+func (u *Universe) exportedKubeconfig(cluster string) string {
+	return filepath.Join(u.dir, cluster+".kubeconfig")
+}
+
+// exportKubeconfigsWithLock writes the kubeconfigs of all clusters to
+// the universe dir, for use by whoever opens the universe next.
+func (u *Universe) exportKubeconfigsWithLock() error {
+	for name, cluster := range u.clusters {
+		bs, err := cluster.ExportKubeconfig(u.runtimecfg.KubeconfigHost)
+		if err != nil {
+			return fmt.Errorf("exporting kubeconfig of cluster %q: %v", name, err)
+		}
+		if err := ioutil.WriteFile(u.exportedKubeconfig(name), bs, 0600); err != nil {
+			return fmt.Errorf("exporting kubeconfig of cluster %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// WriteKubeconfigContext adds the cluster to the kubeconfig file at
+// path, e.g. ~/.kube/config, creating it if needed. The cluster gets
+// a context, cluster and user named contextName (KubeContext if
+// empty), replacing any existing entries of those names. Other
+// entries are kept, and the current context only changes if the file
+// has none.
+//
+// The context reaches the API server through the controller's port
+// forward, which can change when the universe is resumed, so it must
+// be written again after each Open. See also
+// UniverseConfig.MergeKubeconfig.
+func (c *Cluster) WriteKubeconfigContext(path, contextName string) error {
+	if contextName == "" {
+		contextName = c.KubeContext()
+	}
+	bs, err := c.ExportKubeconfig(c.universe.runtimecfg.KubeconfigHost)
+	if err != nil {
+		return err
+	}
+	// The exported kubeconfig has just the cluster's context.
+	bs, err = renameKubeconfig(bs, contextName)
+	if err != nil {
+		return err
+	}
+	src, err := clientcmd.Load(bs)
+	if err != nil {
+		return fmt.Errorf("parsing kubeconfig: %v", err)
+	}
+
+	dst, err := loadKubeconfigFile(path)
+	if err != nil {
+		return err
+	}
+	for name, cluster := range src.Clusters {
+		dst.Clusters[name] = cluster
+	}
+	for name, user := range src.AuthInfos {
+		dst.AuthInfos[name] = user
+	}
+	for name, ctx := range src.Contexts {
+		dst.Contexts[name] = ctx
+	}
+	if dst.CurrentContext == "" {
+		dst.CurrentContext = contextName
+	}
+	return clientcmd.WriteToFile(*dst, path)
+}
+
+// RemoveKubeconfigContext removes the context contextName, and the
+// cluster and user that Cluster.WriteKubeconfigContext added with it,
+// from the kubeconfig file at path. Removing a context that isn't
+// there does nothing.
+func RemoveKubeconfigContext(path, contextName string) error {
+	cfg, err := loadKubeconfigFile(path)
+	if err != nil {
+		return err
+	}
+	ctx := cfg.Contexts[contextName]
+	if ctx == nil {
+		return nil
+	}
+	delete(cfg.Contexts, contextName)
+	// Leave the cluster and user if something else still uses them.
+	clusterUsed, userUsed := false, false
+	for _, other := range cfg.Contexts {
+		clusterUsed = clusterUsed || other.Cluster == ctx.Cluster
+		userUsed = userUsed || other.AuthInfo == ctx.AuthInfo
+	}
+	if !clusterUsed {
+		delete(cfg.Clusters, ctx.Cluster)
+	}
+	if !userUsed {
+		delete(cfg.AuthInfos, ctx.AuthInfo)
+	}
+	if cfg.CurrentContext == contextName {
+		cfg.CurrentContext = ""
+	}
+	return clientcmd.WriteToFile(*cfg, path)
+}
+
+// loadKubeconfigFile reads the kubeconfig at path, or returns an
+// empty one if it doesn't exist.
+func loadKubeconfigFile(path string) (*clientcmdapi.Config, error) {
+	cfg, err := clientcmd.LoadFromFile(path)
+	if os.IsNotExist(err) {
+		return clientcmdapi.NewConfig(), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading kubeconfig %q: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// mergeKubeconfig adds c to the universe's MergeKubeconfig, if it has
+// one.
+func (u *Universe) mergeKubeconfig(c *Cluster) error {
+	path := u.runtimecfg.MergeKubeconfig
+	if path == "" {
+		return nil
+	}
+	u.kubeconfigMu.Lock()
+	defer u.kubeconfigMu.Unlock()
+	if err := c.WriteKubeconfigContext(path, ""); err != nil {
+		return fmt.Errorf("merging kubeconfig of cluster %q into %q: %v", c.Name(), path, err)
+	}
+	u.mergedContexts[c.KubeContext()] = true
+	return nil
+}
+
+// unmergeKubeconfigs removes the contexts that mergeKubeconfig added
+// from the universe's MergeKubeconfig.
+func (u *Universe) unmergeKubeconfigs() error {
+	u.kubeconfigMu.Lock()
+	defer u.kubeconfigMu.Unlock()
+	var retErr error
+	for name := range u.mergedContexts {
+		if err := RemoveKubeconfigContext(u.runtimecfg.MergeKubeconfig, name); err != nil {
+			retErr = err
+		}
+		delete(u.mergedContexts, name)
+	}
+	return retErr
+}
@@ -0,0 +1,128 @@
+package virtuakube
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// KubeletConfig tunes the kubelets of a cluster's nodes, e.g. to
+// trigger evictions reliably on small VMs. Unset fields keep
+// kubeadm's kubelet defaults.
+type KubeletConfig struct {
+	// EvictionHard maps eviction signals (e.g. "memory.available")
+	// to thresholds, either a quantity ("200Mi") or a percentage
+	// ("10%"). Signals not mentioned keep the kubelet's default
+	// thresholds.
+	EvictionHard map[string]string
+	// SystemReserved and KubeReserved map resource names (cpu,
+	// memory, ephemeral-storage, pid) to quantities reserved for
+	// the OS and for Kubernetes daemons respectively.
+	SystemReserved map[string]string
+	KubeReserved   map[string]string
+}
+
+// defaultEvictionHard are the kubelet's default hard eviction
+// thresholds. Setting any threshold in a KubeletConfiguration drops
+// all the defaults, so they must be restated.
+var defaultEvictionHard = map[string]string{
+	"memory.available":  "100Mi",
+	"nodefs.available":  "10%",
+	"nodefs.inodesFree": "5%",
+	"imagefs.available": "15%",
+}
+
+var (
+	evictionSignals = map[string]bool{
+		"memory.available":   true,
+		"nodefs.available":   true,
+		"nodefs.inodesFree":  true,
+		"imagefs.available":  true,
+		"imagefs.inodesFree": true,
+		"pid.available":      true,
+	}
+	reservableResources = map[string]bool{
+		"cpu":               true,
+		"memory":            true,
+		"ephemeral-storage": true,
+		"pid":               true,
+	}
+)
+
+func (k *KubeletConfig) validate() error {
+	for signal, threshold := range k.EvictionHard {
+		if !evictionSignals[signal] {
+			return fmt.Errorf("unknown eviction signal %q", signal)
+		}
+		if err := validateThreshold(threshold); err != nil {
+			return fmt.Errorf("invalid eviction threshold for %q: %v", signal, err)
+		}
+	}
+	for _, reserved := range []map[string]string{k.SystemReserved, k.KubeReserved} {
+		for res, qty := range reserved {
+			if !reservableResources[res] {
+				return fmt.Errorf("unknown reservable resource %q", res)
+			}
+			if _, err := resource.ParseQuantity(qty); err != nil {
+				return fmt.Errorf("invalid reservation %q for %q: %v", qty, res, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateThreshold(threshold string) error {
+	if strings.HasSuffix(threshold, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return fmt.Errorf("%q is not a percentage between 0 and 100", threshold)
+		}
+		return nil
+	}
+	if _, err := resource.ParseQuantity(threshold); err != nil {
+		return err
+	}
+	return nil
+}
+
+// kubeadmDocument returns a KubeletConfiguration document for
+// kubeadm's config file, or "" if k changes nothing.
+func (k *KubeletConfig) kubeadmDocument() string {
+	if k == nil || (len(k.EvictionHard) == 0 && len(k.SystemReserved) == 0 && len(k.KubeReserved) == 0) {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("---\napiVersion: kubelet.config.k8s.io/v1beta1\nkind: KubeletConfiguration\n")
+	if len(k.EvictionHard) > 0 {
+		eviction := map[string]string{}
+		for signal, threshold := range defaultEvictionHard {
+			eviction[signal] = threshold
+		}
+		for signal, threshold := range k.EvictionHard {
+			eviction[signal] = threshold
+		}
+		writeYAMLMap(&b, "evictionHard", eviction)
+	}
+	writeYAMLMap(&b, "systemReserved", k.SystemReserved)
+	writeYAMLMap(&b, "kubeReserved", k.KubeReserved)
+	return b.String()
+}
+
+func writeYAMLMap(b *strings.Builder, name string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(b, "%s:\n", name)
+	for _, k := range keys {
+		fmt.Fprintf(b, "  %s: %q\n", k, m[k])
+	}
+}
@@ -0,0 +1,173 @@
+package virtuakube
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultKubernetesVersion is the Kubernetes version of clusters that
+// don't specify one.
+const defaultKubernetesVersion = "1.14.0"
+
+// Supported Kubernetes minor versions. Clusters running Docker can't
+// work with 1.24, which removed dockershim, or later. The bundled
+// addon manifests use workload APIs that 1.16 removed.
+const (
+	minKubeMinor       = 13
+	maxDockerKubeMinor = 23
+	maxKubeAddonsMinor = 15
+)
+
+// maxKubeMinor returns the newest Kubernetes minor version that
+// clusters running the container runtime runtime support, or 0 if
+// there's no limit.
+func maxKubeMinor(runtime string) int {
+	if runtime == "" || runtime == RuntimeDocker {
+		return maxDockerKubeMinor
+	}
+	return 0
+}
+
+var kubeVersionRe = regexp.MustCompile(`^v?1\.(\d+)\.(\d+)$`)
+
+// parseKubeVersion parses a Kubernetes version like "1.14.3", and
+// returns its minor version.
+func parseKubeVersion(version string) (int, error) {
+	m := kubeVersionRe.FindStringSubmatch(version)
+	if m == nil {
+		return 0, fmt.Errorf("invalid Kubernetes version %q, must be 1.<minor>.<patch>", version)
+	}
+	return strconv.Atoi(m[1])
+}
+
+// validateKubeVersion checks that clusters can run Kubernetes
+// version with cfg's addons.
+func (cfg *ClusterConfig) validateKubeVersion() error {
+	if cfg.KubernetesVersion == "" {
+		return nil
+	}
+	minor, err := parseKubeVersion(cfg.KubernetesVersion)
+	if err != nil {
+		return err
+	}
+	if minor < minKubeMinor {
+		return fmt.Errorf("Kubernetes version %s is not supported, must be 1.%d or later", cfg.KubernetesVersion, minKubeMinor)
+	}
+	if max := maxKubeMinor(cfg.Runtime); max != 0 && minor > max {
+		return fmt.Errorf("Kubernetes version %s is not supported with the Docker runtime, must be 1.%d at most, use the containerd or CRI-O runtime for newer versions", cfg.KubernetesVersion, max)
+	}
+	if minor > maxKubeAddonsMinor {
+		if cfg.CNI != "" && cfg.CNI != CNINone {
+			return fmt.Errorf("bundled CNI %q doesn't support Kubernetes %s (1.%d at most), apply your own CNI instead", cfg.CNI, cfg.KubernetesVersion, maxKubeAddonsMinor)
+		}
+		if cfg.InstallMetricsServer {
+			return fmt.Errorf("bundled metrics-server doesn't support Kubernetes %s (1.%d at most)", cfg.KubernetesVersion, maxKubeAddonsMinor)
+		}
+	}
+	if minor < 14 && len(cfg.RuntimeClasses) > 0 {
+		return fmt.Errorf("RuntimeClasses require Kubernetes 1.14 or later")
+	}
+	return nil
+}
+
+// kubeadmAPIVersion returns the kubeadm config API version to use
+// with the given Kubernetes version.
+func kubeadmAPIVersion(version string) string {
+	minor, err := parseKubeVersion(version)
+	switch {
+	case err != nil || minor < 15:
+		return "v1beta1"
+	case minor < 22:
+		return "v1beta2"
+	default:
+		return "v1beta3"
+	}
+}
+
+// installKubernetesVersion installs and pins the given version of
+// the kubelet, kubeadm and kubectl on vm, unless the image already
+// has it.
+func installKubernetesVersion(vm *VM, version string) error {
+	version = strings.TrimPrefix(version, "v")
+	out, err := vm.Run("kubeadm version -o short")
+	if err == nil && strings.TrimSpace(string(out)) == "v"+version {
+		return nil
+	}
+	return installKubePackages(vm, version, "kubelet", "kubeadm", "kubectl")
+}
+
+// minPkgsKubeMinor is the oldest Kubernetes minor version that
+// pkgs.k8s.io has packages for. Older releases were only packaged on
+// apt.kubernetes.io, which has shut down.
+const minPkgsKubeMinor = 24
+
+// installKubePackages installs and pins the given version of the
+// Kubernetes packages pkgs on vm, from pkgs.k8s.io's repository for
+// its minor version. Versions older than pkgs.k8s.io get their
+// release binaries installed over the image's packaged ones instead.
+func installKubePackages(vm *VM, version string, pkgs ...string) error {
+	minor, err := parseKubeVersion(version)
+	if err != nil {
+		return err
+	}
+	if minor < minPkgsKubeMinor {
+		err = installKubeBinaries(vm, version, pkgs...)
+	} else {
+		err = installKubeRepoPackages(vm, version, minor, pkgs...)
+	}
+	if err == nil {
+		// Keeps apt upgrades from replacing them.
+		_, err = vm.Run("apt-mark hold " + strings.Join(pkgs, " "))
+	}
+	if err != nil {
+		return fmt.Errorf("installing Kubernetes %s: %v", version, err)
+	}
+	return nil
+}
+
+// installKubeRepoPackages installs version of pkgs from pkgs.k8s.io.
+func installKubeRepoPackages(vm *VM, version string, minor int, pkgs ...string) error {
+	repo := fmt.Sprintf("https://pkgs.k8s.io/core:/stable:/v1.%d/deb/", minor)
+	err := vm.RunMultiple(
+		// Images list apt.kubernetes.io, whose shutdown fails apt-get
+		// update.
+		`test ! -f /etc/apt/sources.list.d/k8s.list || sed -i '/apt\.kubernetes\.io/d' /etc/apt/sources.list.d/k8s.list`,
+		"mkdir -p /etc/apt/keyrings",
+		fmt.Sprintf("curl -fsSL --retry 10 %sRelease.key | gpg --dearmor --yes -o /etc/apt/keyrings/kubernetes.gpg", repo),
+		fmt.Sprintf("echo 'deb [signed-by=/etc/apt/keyrings/kubernetes.gpg] %s /' >/etc/apt/sources.list.d/kubernetes.list", repo),
+	)
+	if err != nil {
+		return err
+	}
+	var specs []string
+	for _, pkg := range pkgs {
+		// Package versions look like 1.27.3-1.1.
+		specs = append(specs, fmt.Sprintf("%s=%s-*", pkg, version))
+	}
+	return vm.aptGet("update", "install --no-install-recommends --allow-downgrades --allow-change-held-packages "+strings.Join(specs, " "))
+}
+
+// installKubeBinaries installs the release binaries of version of
+// pkgs from dl.k8s.io, over the image's packaged ones. The image's
+// packages still provide the kubelet's systemd unit and CNI plugins.
+func installKubeBinaries(vm *VM, version string, pkgs ...string) error {
+	var cmds []string
+	for _, pkg := range pkgs {
+		url := fmt.Sprintf("https://dl.k8s.io/release/v%s/bin/linux/%s/%s", version, vm.Arch(), pkg)
+		cmds = append(cmds, fmt.Sprintf("curl -fsSL --retry 10 -o /usr/bin/%[1]s.new %[2]s && chmod 755 /usr/bin/%[1]s.new && mv /usr/bin/%[1]s.new /usr/bin/%[1]s", pkg, url))
+	}
+	return vm.RunMultiple(cmds...)
+}
+
+// KubernetesVersion returns the version of Kubernetes the cluster
+// runs.
+func (c *Cluster) KubernetesVersion() string {
+	// Clusters saved before the version was configurable all ran
+	// the default.
+	if c.cfg.KubernetesVersion == "" {
+		return defaultKubernetesVersion
+	}
+	return c.cfg.KubernetesVersion
+}
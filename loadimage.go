@@ -0,0 +1,86 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// hostImageTool returns the container tool that LoadImage exports
+// host images with.
+func hostImageTool() (string, error) {
+	for _, tool := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(tool); err == nil {
+			return tool, nil
+		}
+	}
+	return "", errors.New("loading host images requires docker or podman")
+}
+
+// LoadImage copies the container image ref from the host's docker
+// (or podman, if there's no docker) into the container runtime of
+// every node of the cluster, so that pods can use it without a
+// registry. Pods must not use imagePullPolicy Always with it.
+func (c *Cluster) LoadImage(ref string) error {
+	tool, err := hostImageTool()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(c.tmpdir, "image")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	archive := filepath.Join(tmp, "image.tar")
+
+	// Save once, rather than once per node: docker save is slow
+	// for large images.
+	cmd := exec.Command(tool, "save", "-o", archive, ref)
+	if err := c.universe.runLogged(cmd); err != nil {
+		return fmt.Errorf("running %s save %s: %v", tool, ref, err)
+	}
+	return c.LoadImageArchive(archive)
+}
+
+// LoadImageArchive loads the container images in the tarball at path
+// into the container runtime of every node of the cluster. The
+// tarball must be in the format written by `docker save` or `podman
+// save`, the nodes' Docker can't read OCI layout archives.
+func (c *Cluster) LoadImageArchive(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	nodes := append(c.ControlPlanes(), c.Nodes()...)
+	load := loadImagesCommand(c.Runtime())
+
+	prog := c.universe.progress(c.Name(), "loading "+filepath.Base(path), int64(len(nodes)))
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(node *VM) {
+			f, err := os.Open(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer f.Close()
+			if _, err := node.RunWithInput(load, f); err != nil {
+				errs <- fmt.Errorf("loading images on %q: %v", node.Hostname(), err)
+				return
+			}
+			errs <- nil
+		}(node)
+	}
+
+	var retErr error
+	for i := range nodes {
+		if err := <-errs; err != nil {
+			retErr = err
+		}
+		prog.update(int64(i + 1))
+	}
+	return prog.done(retErr)
+}
@@ -0,0 +1,116 @@
+package virtuakube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// lockFile is the file in a universe directory that the process with
+// the universe open holds an advisory lock on, so that two processes
+// can't resume the same snapshot and write to the same disks. It
+// contains the PID of the lock holder.
+const lockFile = "lock"
+
+// lockUniverse takes the lock of the universe in dir, for a process
+// that's about to open or modify it.
+//
+// The kernel releases the lock when its holder dies, but the VMs of a
+// universe whose process died can keep running and writing to their
+// disks. If the registry shows such orphans, lockUniverse fails,
+// unless force is set, in which case it kills them and takes over the
+// universe.
+func lockUniverse(dir string, force bool) (*os.File, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, lockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening universe lock: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			if pid := lockHolder(path); pid > 0 {
+				return nil, fmt.Errorf("%w in process %d", ErrUniverseLocked, pid)
+			}
+			return nil, fmt.Errorf("%w in another process", ErrUniverseLocked)
+		}
+		return nil, fmt.Errorf("locking universe: %v", err)
+	}
+
+	if err := checkDeadOwner(dir, force); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return f, nil
+}
+
+// lockHolder returns the PID recorded in the lock file at path, or 0
+// if there's none.
+func lockHolder(path string) int {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(bs)))
+	return pid
+}
+
+// checkDeadOwner checks that a previous process of the universe in
+// dir didn't leave VMs or networks behind. With force, it kills them
+// instead.
+func checkDeadOwner(dir string, force bool) error {
+	ent, err := readRegistryEntry(registryPath(dir))
+	if err != nil {
+		return nil
+	}
+	if ent.alive(ent.PID) {
+		// Lockless processes are from virtuakube versions that
+		// didn't lock universes. Nothing can tell whether they're
+		// done with the disks, so they're never stolen from.
+		return fmt.Errorf("universe is already open in process %d, which doesn't hold the universe lock", ent.PID)
+	}
+	orphans := ent.orphans()
+	if len(orphans) == 0 {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("universe's process %d died, leaving %d VM and network processes running that would corrupt its disks, kill them first or open the universe with force", ent.PID, len(orphans))
+	}
+
+	if err := KillRunning(dir); err != nil {
+		return fmt.Errorf("killing processes left by dead process %d: %v", ent.PID, err)
+	}
+	// SIGKILL is asynchronous, and the VMs must be gone before their
+	// disks get reused.
+	deadline := time.Now().Add(10 * time.Second)
+	for len(ent.orphans()) > 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("processes left by dead process %d didn't die", ent.PID)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// unlock releases the universe lock, after the universe is done with
+// its disks and config.
+func (u *Universe) unlock() {
+	if u.lock == nil {
+		return
+	}
+	u.lock.Close()
+	u.lock = nil
+}
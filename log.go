@@ -0,0 +1,236 @@
+package virtuakube
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log messages. Records about a VM or cluster carry a "vm" or
+// "cluster" attribute.
+const (
+	// logCommand is logged at LevelInfo when a command starts, with
+	// the command in the "command" attribute.
+	logCommand = "command"
+	// logCommandDone is logged at LevelDebug when a command exits,
+	// with "command", "duration", and "error" if it failed.
+	logCommandDone = "command done"
+	// logOutput is logged at LevelDebug for each line of a command's
+	// output, in the "line" attribute.
+	logOutput = "output"
+	// logEvent is logged for each universe Event, with the event's
+	// fields as attributes and its description in "event".
+	logEvent = "event"
+)
+
+// newLogger returns the logger configured by cfg.
+func newLogger(cfg *UniverseConfig) *slog.Logger {
+	switch {
+	case cfg.Logger != nil:
+		return cfg.Logger
+	case cfg.CommandLog != nil:
+		return slog.New(&writerHandler{mu: &sync.Mutex{}, w: cfg.CommandLog})
+	default:
+		return slog.New(slog.DiscardHandler)
+	}
+}
+
+// logOutputWriter returns a writer that logs each line written to it
+// as logOutput to l, or nil if l discards command output. The caller
+// must call the returned flush function after the last write.
+func logOutputWriter(l *slog.Logger) (io.Writer, func()) {
+	if !l.Enabled(context.Background(), slog.LevelDebug) {
+		return nil, func() {}
+	}
+	w := &lineLogger{log: l}
+	return w, w.flush
+}
+
+// idleFlushDelay is how long the writers of idleLogOutputWriter wait
+// for the rest of a partial line before logging it.
+const idleFlushDelay = time.Second
+
+// idleLogOutputWriter is like logOutputWriter, for commands that are
+// waited for by someone else, so that nothing can flush after they
+// exit. Instead, a partial line is logged once no more output has
+// arrived for idleFlushDelay.
+func idleLogOutputWriter(l *slog.Logger) io.Writer {
+	if !l.Enabled(context.Background(), slog.LevelDebug) {
+		return nil
+	}
+	w := &lineLogger{log: l}
+	w.idle = time.AfterFunc(idleFlushDelay, w.flush)
+	w.idle.Stop()
+	return w
+}
+
+// lineLogger is an io.Writer that logs its input line by line. The
+// stdout and stderr of a command can share one.
+type lineLogger struct {
+	log *slog.Logger
+	// If non-nil, flushes the last partial line once output stops.
+	idle *time.Timer
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineLogger) Write(bs []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, bs...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.Debug(logOutput, "line", string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+	if w.idle != nil && len(w.buf) > 0 {
+		w.idle.Reset(idleFlushDelay)
+	}
+	return len(bs), nil
+}
+
+// flush logs the last line written, if it didn't end in a newline.
+func (w *lineLogger) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.log.Debug(logOutput, "line", string(w.buf))
+		w.buf = nil
+	}
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(bs []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(bs)
+}
+
+// runLogged runs cmd on the host, logging it and its output to
+// u.log.
+func (u *Universe) runLogged(cmd *exec.Cmd) error {
+	command := strings.Join(cmd.Args, " ")
+	u.log.Info(logCommand, "command", command)
+	out, flush := logOutputWriter(u.log)
+	if out != nil {
+		cmd.Stdout, cmd.Stderr = out, out
+	}
+	start := time.Now()
+	err := cmd.Run()
+	flush()
+	logCommandResult(u.log, command, start, err)
+	return err
+}
+
+// logCommandResult logs the logCommandDone record of a command that
+// started at start and exited with err.
+func logCommandResult(l *slog.Logger, command string, start time.Time, err error) {
+	if err != nil {
+		l.Debug(logCommandDone, "command", command, "duration", time.Since(start), "error", err)
+	} else {
+		l.Debug(logCommandDone, "command", command, "duration", time.Since(start))
+	}
+}
+
+// writerHandler is the slog.Handler behind UniverseConfig.CommandLog.
+// It writes records as plain lines, in the format CommandLog had
+// before virtuakube had structured logging: commands and their
+// output prefixed with the VM's name, and events as "event: ...".
+type writerHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *writerHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *writerHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+		return true
+	})
+
+	var scope string
+	get := func(key string) string {
+		for _, a := range attrs {
+			if a.Key == key {
+				return a.Value.String()
+			}
+		}
+		return ""
+	}
+	if vm := get("vm"); vm != "" {
+		scope = "[" + vm + "] "
+	} else if cluster := get("cluster"); cluster != "" {
+		scope = "[" + cluster + "] "
+	}
+
+	var line string
+	switch r.Message {
+	case logCommandDone:
+		// CommandLog never had these.
+		return nil
+	case logCommand:
+		line = scope + get("command")
+	case logOutput:
+		// Unprefixed, like before, so that output is exactly what
+		// the command printed.
+		line = get("line")
+	case logEvent:
+		line = "event: " + get("event")
+	default:
+		var b strings.Builder
+		b.WriteString(scope)
+		b.WriteString(r.Message)
+		for _, a := range attrs {
+			if a.Key == "vm" || a.Key == "cluster" {
+				continue
+			}
+			v := a.Value.String()
+			if strings.ContainsAny(v, " \t\"=") {
+				v = fmt.Sprintf("%q", v)
+			}
+			fmt.Fprintf(&b, " %s=%s", a.Key, v)
+		}
+		line = b.String()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintln(h.w, line)
+	return err
+}
+
+func (h *writerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	ret := *h
+	ret.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		ret.attrs = append(ret.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &ret
+}
+
+func (h *writerHandler) WithGroup(name string) slog.Handler {
+	ret := *h
+	ret.prefix = h.prefix + name + "."
+	return &ret
+}
@@ -0,0 +1,125 @@
+package virtuakube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"go.universe.tf/virtuakube/internal/assets"
+)
+
+// NodeMetrics is the resource usage of a node, as reported by
+// metrics-server.
+type NodeMetrics struct {
+	Name string
+	// CPU usage in millicores.
+	CPUMilli int64
+	// Memory usage in bytes.
+	MemoryBytes int64
+}
+
+// PodMetrics is the resource usage of a pod, summed across its
+// containers, as reported by metrics-server.
+type PodMetrics struct {
+	Namespace string
+	Name      string
+	// CPU usage in millicores.
+	CPUMilli int64
+	// Memory usage in bytes.
+	MemoryBytes int64
+}
+
+// rawMetrics is the subset of the metrics.k8s.io NodeMetricsList and
+// PodMetricsList types that we care about.
+type rawMetrics struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Usage      map[string]resource.Quantity `json:"usage"`
+		Containers []struct {
+			Usage map[string]resource.Quantity `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+func (c *Cluster) getMetrics(ctx context.Context, path string) (*rawMetrics, error) {
+	bs, err := c.client.CoreV1().RESTClient().Get().AbsPath("/apis/metrics.k8s.io/v1beta1", path).Context(ctx).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	var ret rawMetrics
+	if err := json.Unmarshal(bs, &ret); err != nil {
+		return nil, fmt.Errorf("parsing %s metrics: %v", path, err)
+	}
+	return &ret, nil
+}
+
+// TopNodes returns the current resource usage of all cluster
+// nodes. The cluster must have been created with
+// InstallMetricsServer.
+func (c *Cluster) TopNodes(ctx context.Context) ([]NodeMetrics, error) {
+	m, err := c.getMetrics(ctx, "nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []NodeMetrics
+	for _, item := range m.Items {
+		cpu, mem := item.Usage["cpu"], item.Usage["memory"]
+		ret = append(ret, NodeMetrics{
+			Name:        item.Metadata.Name,
+			CPUMilli:    cpu.MilliValue(),
+			MemoryBytes: mem.Value(),
+		})
+	}
+	return ret, nil
+}
+
+// TopPods returns the current resource usage of all pods in the
+// cluster. The cluster must have been created with
+// InstallMetricsServer.
+func (c *Cluster) TopPods(ctx context.Context) ([]PodMetrics, error) {
+	m, err := c.getMetrics(ctx, "pods")
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []PodMetrics
+	for _, item := range m.Items {
+		pm := PodMetrics{
+			Namespace: item.Metadata.Namespace,
+			Name:      item.Metadata.Name,
+		}
+		for _, container := range item.Containers {
+			cpu, mem := container.Usage["cpu"], container.Usage["memory"]
+			pm.CPUMilli += cpu.MilliValue()
+			pm.MemoryBytes += mem.Value()
+		}
+		ret = append(ret, pm)
+	}
+	return ret, nil
+}
+
+// installMetricsServer installs metrics-server, and waits for it to
+// report metrics for every node.
+func (c *Cluster) installMetricsServer() error {
+	if err := c.applyManifest(assets.MustAsset("metrics-server.yaml")); err != nil {
+		return fmt.Errorf("installing metrics-server: %v", err)
+	}
+
+	ctx := context.Background()
+	return c.WaitFor(ctx, func() (bool, error) {
+		nodes, err := c.TopNodes(ctx)
+		if err != nil {
+			// metrics-server takes a while to register the API and
+			// scrape its first round of metrics, and errors until
+			// then.
+			return false, nil
+		}
+		return len(nodes) == c.cfg.NumNodes+1, nil
+	})
+}
@@ -0,0 +1,195 @@
+package virtuakube
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// MountConfig shares a host directory with a VM.
+//
+// Mounts use virtiofs when the host has virtiofsd (the Rust
+// implementation) and QEMU supports it, and the guest kernel does
+// (Linux 5.4+). Otherwise they fall back to 9p, which is slower but
+// works with the stock image kernel. Either way, the VM's memory
+// state can't be snapshotted, so universes with mounts can't be
+// saved.
+type MountConfig struct {
+	// HostPath is the host directory to share.
+	HostPath string
+	// GuestPath is where the directory is mounted in the guest. It's
+	// created if needed.
+	GuestPath string
+	ReadOnly  bool
+}
+
+func (m *MountConfig) validate() error {
+	if !filepath.IsAbs(m.GuestPath) {
+		return fmt.Errorf("guest path %q must be absolute", m.GuestPath)
+	}
+	if strings.ContainsAny(m.HostPath, ",") {
+		return fmt.Errorf("host path %q can't contain commas", m.HostPath)
+	}
+	fi, err := os.Stat(m.HostPath)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("host path %q is not a directory", m.HostPath)
+	}
+	return nil
+}
+
+// validateMounts checks a VM's mounts against qemu.
+func validateMounts(mounts []MountConfig, qemu *QEMUInfo) error {
+	if len(mounts) == 0 {
+		return nil
+	}
+	if err := qemu.require(Cap9P, "VMConfig.Mounts"); err != nil {
+		return err
+	}
+	for i := range mounts {
+		if err := mounts[i].validate(); err != nil {
+			return fmt.Errorf("mount %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (m *MountConfig) toConfig() (config.Mount, error) {
+	host, err := filepath.Abs(m.HostPath)
+	if err != nil {
+		return config.Mount{}, err
+	}
+	return config.Mount{
+		HostPath:  host,
+		GuestPath: m.GuestPath,
+		ReadOnly:  m.ReadOnly,
+	}, nil
+}
+
+// virtiofsd returns the path of the host's virtiofsd, or "" if it
+// has none. Distros often install it outside of $PATH.
+func virtiofsd() string {
+	if path, err := exec.LookPath("virtiofsd"); err == nil {
+		return path
+	}
+	for _, path := range []string{"/usr/libexec/virtiofsd", "/usr/lib/qemu/virtiofsd"} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// startVirtiofsd starts a virtiofsd sharing m on a vhost-user socket
+// at sock, and waits for it to listen.
+func (u *Universe) startVirtiofsd(path, sock string, m config.Mount) (*exec.Cmd, error) {
+	cmd := exec.Command(path, "--socket-path", sock, "--shared-dir", m.HostPath, "--sandbox", "none")
+	if m.ReadOnly {
+		cmd.Args = append(cmd.Args, "--readonly")
+	}
+	u.log.Info(logCommand, "command", strings.Join(cmd.Args, " "))
+	out, flush := logOutputWriter(u.log)
+	if out != nil {
+		cmd.Stdout, cmd.Stderr = out, out
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting virtiofsd: %v", err)
+	}
+	// virtiofsd exits by itself when qemu disconnects.
+	done := make(chan bool)
+	go func() {
+		cmd.Wait()
+		flush()
+		close(done)
+	}()
+	u.trackProcess(cmd.Process, done)
+
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+		select {
+		case <-done:
+			return nil, fmt.Errorf("virtiofsd for %q exited on startup", m.HostPath)
+		default:
+		}
+		if _, err := os.Stat(sock); err == nil {
+			return cmd, nil
+		}
+	}
+	cmd.Process.Kill()
+	return nil, fmt.Errorf("timed out waiting for virtiofsd for %q", m.HostPath)
+}
+
+// mountArgs returns the qemu arguments that share mounts with the
+// VM. Every mount gets a 9p device, and a virtiofs device if the
+// host can serve one, so that the guest can use whichever its kernel
+// supports.
+func (u *Universe) mountArgs(vm *VM, qemu *QEMUInfo) ([]string, error) {
+	cfg := vm.cfg
+	if len(cfg.Mounts) == 0 {
+		return nil, nil
+	}
+	var ret []string
+	daemon := ""
+	if qemu.Has(CapVirtioFS) {
+		daemon = virtiofsd()
+	}
+	if daemon != "" {
+		// vhost-user devices need the guest memory to be shareable
+		// with virtiofsd.
+		ret = append(ret,
+			"-object", fmt.Sprintf("memory-backend-memfd,id=mem,size=%dM,share=on", cfg.MemoryMiB),
+			"-numa", "node,memdev=mem",
+		)
+		vm.virtiofs = true
+	}
+
+	for i, m := range cfg.Mounts {
+		readonly := ""
+		if m.ReadOnly {
+			readonly = ",readonly=on"
+		}
+		ret = append(ret,
+			"-fsdev", fmt.Sprintf("local,id=fs%d,path=%s,security_model=none%s", i, m.HostPath, readonly),
+			"-device", fmt.Sprintf("virtio-9p-pci,fsdev=fs%d,mount_tag=vk9p%d", i, i),
+		)
+		if daemon == "" {
+			continue
+		}
+		sock := filepath.Join(u.tmpdir, fmt.Sprintf("fs-%s-%d", cfg.Name, i))
+		if _, err := u.startVirtiofsd(daemon, sock, m); err != nil {
+			return nil, err
+		}
+		ret = append(ret,
+			"-chardev", fmt.Sprintf("socket,id=vfs%d,path=%s", i, sock),
+			"-device", fmt.Sprintf("vhost-user-fs-pci,chardev=vfs%d,tag=vkfs%d", i, i),
+		)
+	}
+	return ret, nil
+}
+
+// mountShares mounts the VM's shared directories in the guest.
+func (v *VM) mountShares() error {
+	for i, m := range v.cfg.Mounts {
+		mode := "rw"
+		if m.ReadOnly {
+			mode = "ro"
+		}
+		ninep := fmt.Sprintf("mount -t 9p -o %s,trans=virtio,version=9p2000.L,msize=262144 vk9p%d %s", mode, i, shellQuote(m.GuestPath))
+		cmd := "mkdir -p " + shellQuote(m.GuestPath) + " && "
+		if v.virtiofs {
+			cmd += fmt.Sprintf("if modprobe virtiofs 2>/dev/null || grep -qw virtiofs /proc/filesystems; then mount -t virtiofs -o %s vkfs%d %s; else %s; fi", mode, i, shellQuote(m.GuestPath), ninep)
+		} else {
+			cmd += ninep
+		}
+		if _, err := v.Run(cmd); err != nil {
+			return fmt.Errorf("mounting %q at %q: %v", m.HostPath, m.GuestPath, err)
+		}
+	}
+	return nil
+}
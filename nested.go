@@ -0,0 +1,52 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// nestedKVM returns the CPU feature that exposes virtualization to
+// guests of the host's KVM, "vmx" on Intel or "svm" on AMD, if the
+// host allows nested KVM.
+func nestedKVM() (string, error) {
+	for _, mod := range []struct{ name, feature string }{
+		{"kvm_intel", "vmx"},
+		{"kvm_amd", "svm"},
+	} {
+		bs, err := ioutil.ReadFile(filepath.Join("/sys/module", mod.name, "parameters/nested"))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(string(bs)) {
+		case "Y", "1":
+			return mod.feature, nil
+		default:
+			return "", fmt.Errorf("%s has nested KVM disabled, set its nested=1 module parameter", mod.name)
+		}
+	}
+	return "", errors.New("KVM module not loaded, cannot determine nested virtualization support")
+}
+
+// validateNestedVirt checks that cfg can run with nested
+// virtualization on the host, for a VM of arch.
+func (u *Universe) validateNestedVirt(cfg *VMConfig, arch string) error {
+	if !cfg.NestedVirt {
+		return nil
+	}
+	if normalizeArch(arch) != ArchAMD64 {
+		return fmt.Errorf("NestedVirt is only supported on %s", ArchAMD64)
+	}
+	if u.runtimecfg.NoAcceleration || !canAccelerate(arch) {
+		return errors.New("NestedVirt requires KVM acceleration")
+	}
+	if cfg.CPUModel != "" && cfg.CPUModel != "host" {
+		return fmt.Errorf("NestedVirt needs the host CPU model, not %q", cfg.CPUModel)
+	}
+	if _, err := nestedKVM(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// LinkSpec describes impairments of the traffic between two VMs. Zero
+// values mean unimpaired.
+type LinkSpec struct {
+	// Latency is added to every packet.
+	Latency time.Duration
+	// Jitter randomly varies Latency by up to this much. Jitter
+	// reorders packets.
+	Jitter time.Duration
+	// Loss is the percentage of packets dropped, from 0 to 100.
+	Loss float64
+	// Rate caps the throughput, in bits per second.
+	Rate int64
+}
+
+func (l LinkSpec) validate() error {
+	if l.Latency < 0 || l.Jitter < 0 {
+		return errors.New("link latency and jitter can't be negative")
+	}
+	if l.Jitter > 0 && l.Latency == 0 {
+		return errors.New("link jitter requires a latency")
+	}
+	if l.Loss < 0 || l.Loss > 100 {
+		return fmt.Errorf("invalid link loss %v%%, must be between 0 and 100", l.Loss)
+	}
+	if l.Rate < 0 {
+		return fmt.Errorf("invalid link rate %d", l.Rate)
+	}
+	return nil
+}
+
+func (l LinkSpec) toConfig(peer *VM) config.Link {
+	ret := config.Link{
+		Latency: l.Latency,
+		Jitter:  l.Jitter,
+		Loss:    l.Loss,
+		Rate:    l.Rate,
+		IPv4:    map[string]net.IP{},
+		IPv6:    map[string]net.IP{},
+	}
+	for _, n := range peer.Networks() {
+		ret.IPv4[n] = peer.IPv4(n)
+		ret.IPv6[n] = peer.IPv6(n)
+	}
+	return ret
+}
+
+func linkSpecFromConfig(c config.Link) LinkSpec {
+	return LinkSpec{
+		Latency: c.Latency,
+		Jitter:  c.Jitter,
+		Loss:    c.Loss,
+		Rate:    c.Rate,
+	}
+}
+
+// netemArgs returns the tc netem arguments that apply spec.
+func netemArgs(spec config.Link) string {
+	ret := "netem limit 10000"
+	if spec.Latency > 0 {
+		ret += fmt.Sprintf(" delay %dus", spec.Latency/time.Microsecond)
+		if spec.Jitter > 0 {
+			ret += fmt.Sprintf(" %dus", spec.Jitter/time.Microsecond)
+		}
+	}
+	if spec.Loss > 0 {
+		ret += fmt.Sprintf(" loss %v%%", spec.Loss)
+	}
+	if spec.Rate > 0 {
+		ret += fmt.Sprintf(" rate %dbit", spec.Rate)
+	}
+	return ret
+}
+
+// ImpairLink applies spec to the traffic between the VMs a and b, on
+// all the networks they share, replacing any previous impairment of
+// the link. Each direction is impaired separately, so the round trip
+// time grows by twice spec.Latency. A zero spec removes the
+// impairment.
+//
+// Impairments are implemented with tc netem in the guests. They
+// persist if the universe is saved, and across VM restarts.
+func (u *Universe) ImpairLink(a, b string, spec LinkSpec) error {
+	if err := spec.validate(); err != nil {
+		return err
+	}
+	if a == b {
+		return errors.New("can't impair a VM's link to itself")
+	}
+
+	u.mu.Lock()
+	va, vb := u.vms[a], u.vms[b]
+	u.mu.Unlock()
+	if va == nil {
+		return fmt.Errorf("universe doesn't have a VM named %q", a)
+	}
+	if vb == nil {
+		return fmt.Errorf("universe doesn't have a VM named %q", b)
+	}
+	if len(sharedNetworks(va, vb)) == 0 {
+		return fmt.Errorf("VMs %q and %q don't share a network", a, b)
+	}
+
+	for _, pair := range [][2]*VM{{va, vb}, {vb, va}} {
+		vm, peer := pair[0], pair[1]
+		vm.mu.Lock()
+		if vm.cfg.Links == nil {
+			vm.cfg.Links = map[string]config.Link{}
+		}
+		if spec == (LinkSpec{}) {
+			delete(vm.cfg.Links, peer.Hostname())
+		} else {
+			vm.cfg.Links[peer.Hostname()] = spec.toConfig(peer)
+		}
+		vm.mu.Unlock()
+		if err := vm.applyLinks(); err != nil {
+			return fmt.Errorf("impairing link on %q: %v", vm.Hostname(), err)
+		}
+	}
+	return nil
+}
+
+// Links returns the impairments of the VM's links, keyed by peer VM.
+func (v *VM) Links() map[string]LinkSpec {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ret := map[string]LinkSpec{}
+	for peer, spec := range v.cfg.Links {
+		ret[peer] = linkSpecFromConfig(spec)
+	}
+	return ret
+}
+
+// sharedNetworks returns the networks that a and b are both attached
+// to.
+func sharedNetworks(a, b *VM) []string {
+	var ret []string
+	for _, na := range a.Networks() {
+		for _, nb := range b.Networks() {
+			if na == nb {
+				ret = append(ret, na)
+			}
+		}
+	}
+	return ret
+}
+
+// applyLinks replaces the tc configuration of the VM's network
+// interfaces with its current link impairments. Each impaired peer
+// gets an HTB class with a netem leaf, selected by destination
+// address. Other traffic goes to the unimpaired default class.
+func (v *VM) applyLinks() error {
+	v.mu.Lock()
+	links := map[string]config.Link{}
+	for peer, link := range v.cfg.Links {
+		links[peer] = link
+	}
+	v.mu.Unlock()
+
+	var peers []string
+	for peer := range links {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+
+	for i, net := range v.Networks() {
+		dev := nicInterface(i)
+		cmds := []string{
+			fmt.Sprintf("tc qdisc del dev %s root 2>/dev/null || true", dev),
+		}
+		class := 2
+		for _, peer := range peers {
+			link := links[peer]
+			if link.IPv4[net] == nil {
+				continue
+			}
+			if class == 2 {
+				cmds = append(cmds,
+					fmt.Sprintf("tc qdisc add dev %s root handle 1: htb default 1", dev),
+					fmt.Sprintf("tc class add dev %s parent 1: classid 1:1 htb rate 100gbit", dev),
+				)
+			}
+			cmds = append(cmds,
+				fmt.Sprintf("tc class add dev %s parent 1: classid 1:%d htb rate 100gbit", dev, class),
+				fmt.Sprintf("tc qdisc add dev %s parent 1:%d handle %d: %s", dev, class, class, netemArgs(link)),
+				fmt.Sprintf("tc filter add dev %s parent 1: protocol ip prio 1 u32 match ip dst %s/32 flowid 1:%d", dev, link.IPv4[net], class),
+				fmt.Sprintf("tc filter add dev %s parent 1: protocol ipv6 prio 2 u32 match ip6 dst %s/128 flowid 1:%d", dev, link.IPv6[net], class),
+			)
+			class++
+		}
+		if err := v.RunMultiple(cmds...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
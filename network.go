@@ -11,10 +11,29 @@ import (
 	"go.universe.tf/virtuakube/internal/config"
 )
 
+const (
+	// DefaultMTU is the MTU of networks that don't specify one.
+	DefaultMTU = 1500
+
+	// MTU bounds. The minimum is the smallest MTU that IPv6 allows,
+	// since VMs get IPv6 addresses on every network.
+	minMTU = 1280
+	maxMTU = 9000
+)
+
+// NetworkConfig is the configuration for a virtual network.
 type NetworkConfig struct {
 	Name string
+	// MTU is the link MTU of the network. Zero means DefaultMTU.
+	MTU int
 }
 
+// Network is a virtual ethernet segment, isolated from the host and
+// from the universe's other networks. Network N of a universe has
+// the subnets 10.248.N.0/24 and fd00:N::/64, and VMs attached to it
+// get consecutive addresses from .1 up, skipping those pinned with
+// VMConfig.Addresses. VMs attached to several networks can route
+// between them.
 type Network struct {
 	stopped chan bool
 
@@ -23,10 +42,21 @@ type Network struct {
 	mu  sync.Mutex
 	cfg *config.Network
 	cmd *exec.Cmd
+	// Addresses in use on the network, and what uses them. ip skips
+	// them.
+	claims map[string]string
 
 	closed bool
 }
 
+func (cfg *NetworkConfig) validate() error {
+	if cfg.MTU != 0 && (cfg.MTU < minMTU || cfg.MTU > maxMTU) {
+		return fmt.Errorf("network MTU %d out of range, must be between %d and %d", cfg.MTU, minMTU, maxMTU)
+	}
+	return nil
+}
+
+// NewNetwork creates a new virtual network.
 func (u *Universe) NewNetwork(cfg *NetworkConfig) error {
 	u.mu.Lock()
 	defer u.mu.Unlock()
@@ -35,9 +65,18 @@ func (u *Universe) NewNetwork(cfg *NetworkConfig) error {
 		return fmt.Errorf("universe already has a network named %q", cfg.Name)
 	}
 
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	mtu := cfg.MTU
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+
 	netID := u.net()
 	return u.mkNetwork(&config.Network{
 		Name:     cfg.Name,
+		MTU:      mtu,
 		NextIPv4: net.ParseIP(fmt.Sprintf("10.248.%d.1", netID)),
 		NextIPv6: net.ParseIP(fmt.Sprintf("fd00:%d::1", netID)),
 	})
@@ -57,7 +96,8 @@ func (u *Universe) mkNetwork(cfg *config.Network) error {
 		stopped: make(chan bool),
 		cfg:     cfg,
 		sock:    sock,
-		cmd:     exec.Command("vde_switch", "--sock", sock, "-m", "0600"),
+		claims:  map[string]string{},
+		cmd:     u.backend.networkCommand(sock),
 	}
 	if u.runtimecfg.Interactive {
 		ret.cmd.SysProcAttr = &syscall.SysProcAttr{
@@ -72,6 +112,7 @@ func (u *Universe) mkNetwork(cfg *config.Network) error {
 		ret.cmd.Wait()
 		close(ret.stopped)
 	}()
+	u.trackProcess(ret.cmd.Process, ret.stopped)
 
 	u.networks[cfg.Name] = ret
 	return nil
@@ -89,14 +130,106 @@ func (n *Network) Close() error {
 	return nil
 }
 
-func (n *Network) ip() (net.IP, net.IP) {
+// Name returns the name of the network.
+func (n *Network) Name() string {
+	return n.cfg.Name
+}
+
+// IPv4Subnet returns the network's IPv4 subnet.
+func (n *Network) IPv4Subnet() *net.IPNet {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	mask := net.CIDRMask(24, 32)
+	return &net.IPNet{IP: n.cfg.NextIPv4.Mask(mask), Mask: mask}
+}
+
+// IPv6Subnet returns the network's IPv6 subnet.
+func (n *Network) IPv6Subnet() *net.IPNet {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	mask := net.CIDRMask(64, 128)
+	return &net.IPNet{IP: n.cfg.NextIPv6.Mask(mask), Mask: mask}
+}
+
+// MTU returns the link MTU of the network.
+func (n *Network) MTU() int {
+	// Networks saved before MTUs were configurable have no MTU.
+	if n.cfg.MTU == 0 {
+		return DefaultMTU
+	}
+	return n.cfg.MTU
+}
+
+// ip allocates the next IPv4 and IPv6 addresses of the network,
+// skipping claimed ones.
+func (n *Network) ip() (net.IP, net.IP, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for {
+		ret4, ret6 := n.cfg.NextIPv4, n.cfg.NextIPv6
+		if ret4[3] == 255 {
+			return nil, nil, fmt.Errorf("network %q is out of addresses", n.cfg.Name)
+		}
+		n.cfg.NextIPv4, n.cfg.NextIPv6 = make(net.IP, 4), make(net.IP, 16)
+		copy(n.cfg.NextIPv4, ret4)
+		copy(n.cfg.NextIPv6, ret6)
+		n.cfg.NextIPv4[3]++
+		n.cfg.NextIPv6[15]++
+		if n.claims[ret4.String()] == "" && n.claims[ret6.String()] == "" {
+			return ret4, ret6, nil
+		}
+	}
+}
+
+// claim records that owner, e.g. `VM "foo"`, uses the MAC and IP
+// addresses addrs on the network. It fails if something else already
+// uses one of them.
+func (n *Network) claim(owner string, addrs ...string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, addr := range addrs {
+		if other := n.claims[addr]; other != "" && other != owner {
+			return fmt.Errorf("%s is already used by %s on network %q", addr, other, n.cfg.Name)
+		}
+	}
+	for _, addr := range addrs {
+		n.claims[addr] = owner
+	}
+	return nil
+}
+
+// claimant returns what uses addr on the network, or "" if nothing
+// does.
+func (n *Network) claimant(addr string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.claims[addr]
+}
+
+// unclaim forgets that addrs are in use on the network.
+func (n *Network) unclaim(addrs ...string) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	ret4, ret6 := n.cfg.NextIPv4, n.cfg.NextIPv6
-	n.cfg.NextIPv4, n.cfg.NextIPv6 = make(net.IP, 4), make(net.IP, 16)
-	copy(n.cfg.NextIPv4, ret4)
-	copy(n.cfg.NextIPv6, ret6)
-	n.cfg.NextIPv4[3]++
-	n.cfg.NextIPv6[15]++
-	return ret4, ret6
+	for _, addr := range addrs {
+		delete(n.claims, addr)
+	}
+}
+
+// Network returns the Network with the given name, or nil if no such
+// Network exists in the universe.
+func (u *Universe) Network(name string) *Network {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.networks[name]
+}
+
+// Networks returns a list of all Networks in the universe.
+func (u *Universe) Networks() []*Network {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ret := make([]*Network, 0, len(u.networks))
+	for _, n := range u.networks {
+		ret = append(ret, n)
+	}
+	return ret
 }
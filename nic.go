@@ -0,0 +1,287 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+const (
+	// maxHotplugNICs is the most root ports for hot-plugged NICs a
+	// VM can have. Each takes a slot of the VM's root PCI bus.
+	maxHotplugNICs = 8
+	// nicAttempts is how many times AttachNIC and DetachNIC check
+	// whether the guest has noticed a NIC coming or going. Linux
+	// waits 5s before acting on a PCIe hot-unplug request.
+	nicAttempts = 15
+)
+
+// NIC is a network interface that was hot-plugged into a VM with
+// AttachNIC.
+type NIC struct {
+	// ID identifies the NIC to DetachNIC.
+	ID string
+	// Network is the universe network the NIC is attached to.
+	Network string
+	MAC     string
+	IPv4    net.IP
+	IPv6    net.IP
+}
+
+// validateHotplugNICs checks that a VM of machine type machine can
+// have n root ports for hot-plugged NICs.
+func validateHotplugNICs(n int, machine string) error {
+	if n < 0 || n > maxHotplugNICs {
+		return fmt.Errorf("invalid HotplugNICs %d, must be between 0 and %d", n, maxHotplugNICs)
+	}
+	if n > 0 && !strings.Contains(machine, "q35") && !strings.HasPrefix(machine, "virt") {
+		return fmt.Errorf("HotplugNICs requires a PCI Express machine type, q35 or virt, not %q", machine)
+	}
+	return nil
+}
+
+// hotplugArgsWithLock returns the qemu arguments for the root ports
+// of the VM in cfg, and the NICs plugged into them.
+func (u *Universe) hotplugArgsWithLock(cfg *config.VM, qemu *QEMUInfo) []string {
+	var ret []string
+	for i := 0; i < cfg.HotplugNICs; i++ {
+		ret = append(ret, "-device", fmt.Sprintf("pcie-root-port,id=%s,chassis=%d", hotplugPort(i), i+1))
+	}
+	for _, nic := range cfg.NICs {
+		nw := u.networks[nic.Network]
+		ret = append(ret,
+			"-netdev", nicNetdev(nic, nw),
+			"-device", nicDevice(nic, nw, qemu),
+		)
+	}
+	return ret
+}
+
+// hotplugPort returns the ID of the VM's root port for hot-plugged
+// NICs at slot.
+func hotplugPort(slot int) string {
+	return fmt.Sprintf("hotplug%d", slot)
+}
+
+// nicNetdev returns the qemu netdev specification of nic, on nw.
+func nicNetdev(nic config.NIC, nw *Network) string {
+	return fmt.Sprintf("vde,id=%s-net,sock=%s", nic.ID, nw.sock)
+}
+
+// nicDevice returns the qemu device specification of nic, on nw.
+func nicDevice(nic config.NIC, nw *Network, qemu *QEMUInfo) string {
+	ret := fmt.Sprintf("virtio-net-pci,netdev=%s-net,id=%s,bus=%s,mac=%s", nic.ID, nic.ID, hotplugPort(nic.Slot), nic.MAC)
+	if mtu := nw.MTU(); mtu != DefaultMTU && qemu.Has(CapHostMTU) {
+		ret += fmt.Sprintf(",host_mtu=%d", mtu)
+	}
+	return ret
+}
+
+// NICs returns the NICs currently hot-plugged into the VM.
+func (v *VM) NICs() []NIC {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var ret []NIC
+	for _, nic := range v.cfg.NICs {
+		ret = append(ret, NIC{
+			ID:      nic.ID,
+			Network: nic.Network,
+			MAC:     nic.MAC,
+			IPv4:    nic.IPv4,
+			IPv6:    nic.IPv6,
+		})
+	}
+	return ret
+}
+
+// AttachNIC hot-plugs a new NIC into the running VM, attached to the
+// named universe network, and configures its addresses in the guest
+// once the guest has noticed it. The NIC gets new addresses on
+// network, it may be on the same network as the VM's other NICs.
+//
+// The VM must have been created with a free root port for the NIC,
+// see VMConfig.HotplugNICs. Hot-plugged NICs stay plugged in across
+// reboots and snapshots, until DetachNIC unplugs them.
+func (v *VM) AttachNIC(network string) (*NIC, error) {
+	u := v.universe
+	u.mu.Lock()
+	nw := u.networks[network]
+	if nw == nil {
+		u.mu.Unlock()
+		return nil, fmt.Errorf("universe doesn't have a network named %q", network)
+	}
+	qemu, err := u.qemuFor(v.cfg.Arch)
+	if err != nil {
+		u.mu.Unlock()
+		return nil, err
+	}
+
+	v.mu.Lock()
+	nic, err := v.plugNICWithLock(nw, qemu)
+	v.mu.Unlock()
+	u.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("attaching NIC to VM %q: %v", v.cfg.Name, err)
+	}
+
+	ret := &NIC{
+		ID:      nic.ID,
+		Network: nic.Network,
+		MAC:     nic.MAC,
+		IPv4:    nic.IPv4,
+		IPv6:    nic.IPv6,
+	}
+	if err := v.configureNIC(*ret); err != nil {
+		if derr := v.DetachNIC(ret.ID); derr != nil {
+			return nil, fmt.Errorf("%v (and detaching the NIC failed: %v)", err, derr)
+		}
+		return nil, err
+	}
+	return ret, nil
+}
+
+// plugNICWithLock plugs a new NIC on nw into the VM's first free root
+// port, and records it. Assumes u.mu and v.mu are held.
+func (v *VM) plugNICWithLock(nw *Network, qemu *QEMUInfo) (config.NIC, error) {
+	if v.closed || v.ssh == nil {
+		return config.NIC{}, errors.New("VM isn't running")
+	}
+	if v.paused {
+		return config.NIC{}, errors.New("VM is paused")
+	}
+
+	used := map[int]bool{}
+	for _, nic := range v.cfg.NICs {
+		used[nic.Slot] = true
+	}
+	slot := 0
+	for used[slot] {
+		slot++
+	}
+	if slot >= v.cfg.HotplugNICs {
+		return config.NIC{}, fmt.Errorf("all %d hot-plug slots are in use, see VMConfig.HotplugNICs", v.cfg.HotplugNICs)
+	}
+
+	ip4, ip6, err := nw.ip()
+	if err != nil {
+		return config.NIC{}, err
+	}
+	nic := config.NIC{
+		ID:      fmt.Sprintf("nic%d", slot+1),
+		Network: nw.Name(),
+		Slot:    slot,
+		MAC:     randomMAC(),
+		IPv4:    ip4,
+		IPv6:    ip6,
+	}
+	addrs := []string{nic.MAC, ip4.String(), ip6.String()}
+	if err := nw.claim(vmOwner(v.cfg.Name), addrs...); err != nil {
+		return config.NIC{}, err
+	}
+	if _, err := v.monitorWithLock("netdev_add " + nicNetdev(nic, nw)); err != nil {
+		nw.unclaim(addrs...)
+		return config.NIC{}, err
+	}
+	if _, err := v.monitorWithLock("device_add " + nicDevice(nic, nw, qemu)); err != nil {
+		v.monitorWithLock("netdev_del " + nic.ID + "-net")
+		nw.unclaim(addrs...)
+		return config.NIC{}, err
+	}
+	v.cfg.NICs = append(v.cfg.NICs, nic)
+	return nic, nil
+}
+
+// configureNIC waits for the guest to notice the hot-plugged nic, and
+// sets its addresses and MTU.
+func (v *VM) configureNIC(nic NIC) error {
+	var dev string
+	for attempt := 1; ; attempt++ {
+		out, err := v.Run(fmt.Sprintf("grep -lx %s /sys/class/net/*/address", strings.ToLower(nic.MAC)))
+		if err == nil {
+			dev = filepath.Base(filepath.Dir(strings.TrimSpace(string(out))))
+			break
+		}
+		if attempt == nicAttempts {
+			return fmt.Errorf("NIC %s didn't show up in the guest", nic.ID)
+		}
+		time.Sleep(backoff(attempt, 100*time.Millisecond, 2*time.Second))
+	}
+
+	return v.RunMultiple(
+		fmt.Sprintf("ip addr add %s/24 dev %s", nic.IPv4, dev),
+		fmt.Sprintf("ip addr add %s/64 dev %s", nic.IPv6, dev),
+		fmt.Sprintf("ip link set dev %s mtu %d", dev, v.universe.Network(nic.Network).MTU()),
+		fmt.Sprintf("ip link set dev %s up", dev),
+	)
+}
+
+// DetachNIC hot-unplugs the NIC with the given ID, which AttachNIC
+// returned, from the running VM. It waits for the guest to release
+// the NIC, which takes a few seconds. The VM's other NICs can't be
+// unplugged, use Universe.ImpairLink or Universe.Partition to break
+// their links instead.
+func (v *VM) DetachNIC(id string) error {
+	v.mu.Lock()
+	err := v.unplugNICWithLock(id)
+	v.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("detaching NIC %q from VM %q: %v", id, v.cfg.Name, err)
+	}
+
+	// Unplugging needs the guest's cooperation, and is done when the
+	// device is gone from qemu.
+	for attempt := 1; ; attempt++ {
+		v.mu.Lock()
+		out, err := v.monitorWithLock("info pci")
+		v.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(out, fmt.Sprintf("id %q", id)) {
+			break
+		}
+		if attempt == nicAttempts {
+			return fmt.Errorf("guest of VM %q didn't release NIC %q", v.cfg.Name, id)
+		}
+		time.Sleep(backoff(attempt, 100*time.Millisecond, 2*time.Second))
+	}
+
+	// The snapshot config is read under the universe lock.
+	v.universe.mu.Lock()
+	defer v.universe.mu.Unlock()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, err := v.monitorWithLock("netdev_del " + id + "-net"); err != nil {
+		return err
+	}
+	var nics []config.NIC
+	for _, nic := range v.cfg.NICs {
+		if nic.ID != id {
+			nics = append(nics, nic)
+		} else if nw := v.universe.networks[nic.Network]; nw != nil {
+			nw.unclaim(nic.MAC, nic.IPv4.String(), nic.IPv6.String())
+		}
+	}
+	v.cfg.NICs = nics
+	return nil
+}
+
+// unplugNICWithLock asks the guest to release the hot-plugged NIC
+// with the given ID.
+func (v *VM) unplugNICWithLock(id string) error {
+	if v.closed || v.ssh == nil {
+		return errors.New("VM isn't running")
+	}
+	for _, nic := range v.cfg.NICs {
+		if nic.ID == id {
+			_, err := v.monitorWithLock("device_del " + id)
+			return err
+		}
+	}
+	return errors.New("no such hot-plugged NIC")
+}
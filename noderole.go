@@ -0,0 +1,121 @@
+package virtuakube
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NodeRole is the labels and taints of a cluster worker node.
+type NodeRole struct {
+	// Labels are added to the node once it joins, e.g.
+	// "node-role.kubernetes.io/infra": "".
+	Labels map[string]string
+	// Taints are applied to the node as it joins, so that no pods
+	// land on it that don't tolerate them. Each is
+	// "key[=value]:effect", e.g. "dedicated=gpu:NoSchedule".
+	Taints []string
+}
+
+func (r *NodeRole) validate() error {
+	for k, v := range r.Labels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", k, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("invalid value for label %q: %s", k, strings.Join(errs, ", "))
+		}
+	}
+	for _, t := range r.Taints {
+		if _, err := parseTaint(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTaint parses a taint in kubectl's "key[=value]:effect" form.
+func parseTaint(s string) (corev1.Taint, error) {
+	idx := strings.LastIndex(s, ":")
+	if idx < 0 {
+		return corev1.Taint{}, fmt.Errorf("invalid taint %q, must be key[=value]:effect", s)
+	}
+	ret := corev1.Taint{Effect: corev1.TaintEffect(s[idx+1:])}
+	kv := strings.SplitN(s[:idx], "=", 2)
+	ret.Key = kv[0]
+	if len(kv) == 2 {
+		ret.Value = kv[1]
+	}
+
+	switch ret.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return corev1.Taint{}, fmt.Errorf("invalid taint %q, effect must be NoSchedule, PreferNoSchedule or NoExecute", s)
+	}
+	if errs := validation.IsQualifiedName(ret.Key); len(errs) > 0 {
+		return corev1.Taint{}, fmt.Errorf("invalid taint key %q: %s", ret.Key, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(ret.Value); len(errs) > 0 {
+		return corev1.Taint{}, fmt.Errorf("invalid taint value %q: %s", ret.Value, strings.Join(errs, ", "))
+	}
+	return ret, nil
+}
+
+// kubeadmTaints returns the nodeRegistration taints for node in
+// kubeadm's JoinConfiguration, or "" if it has none.
+func (c *Cluster) kubeadmTaints(node *VM) string {
+	role := c.nodeRoles[node.Hostname()]
+	if len(role.Taints) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n  taints:")
+	for _, s := range role.Taints {
+		// Validated by NewCluster.
+		t, _ := parseTaint(s)
+		fmt.Fprintf(&b, "\n  - key: %q\n    value: %q\n    effect: %q", t.Key, t.Value, t.Effect)
+	}
+	return b.String()
+}
+
+// labelNodes adds the labels of the worker nodes' roles to their
+// Kubernetes nodes. Labels are set through the API rather than the
+// kubelet's --node-labels, which the NodeRestriction admission plugin
+// rejects for node-role.kubernetes.io and other reserved prefixes.
+func (c *Cluster) labelNodes() error {
+	names := make([]string, 0, len(c.nodeRoles))
+	for name := range c.nodeRoles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	client := c.client.CoreV1().Nodes()
+	for _, name := range names {
+		labels := c.nodeRoles[name].Labels
+		if len(labels) == 0 {
+			continue
+		}
+		node, err := client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			// Failed nodes never joined.
+			if c.failed[name] != nil {
+				continue
+			}
+			return fmt.Errorf("getting node %q: %v", name, err)
+		}
+		if node.Labels == nil {
+			node.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			node.Labels[k] = v
+		}
+		if _, err := client.Update(node); err != nil {
+			return fmt.Errorf("labeling node %q: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.universe.tf/virtuakube/internal/assets"
+)
+
+// MissingArtifactsError is returned in offline mode, see
+// UniverseConfig.Offline, when something needs artifacts that the
+// image cache or the VM images don't have.
+type MissingArtifactsError struct {
+	// Artifacts describes each missing artifact.
+	Artifacts []string
+}
+
+func (e *MissingArtifactsError) Error() string {
+	var b strings.Builder
+	b.WriteString("offline mode is missing:")
+	for _, a := range e.Artifacts {
+		b.WriteString("\n  - " + a)
+	}
+	b.WriteString("\nrun vkube prefetch while online to populate the image cache")
+	return b.String()
+}
+
+// checkOffline checks that vm, once started, has everything it needs
+// to become a node of the cluster without internet access: its
+// container runtime, the cluster's Kubernetes version and the
+// container images of the control plane and addons. It does nothing
+// unless the universe is offline.
+func (c *Cluster) checkOffline(vm *VM) error {
+	if !c.universe.runtimecfg.Offline {
+		return nil
+	}
+
+	var missing []string
+	runtime, version := c.Runtime(), c.KubernetesVersion()
+	want, err := runtimeID(runtime, version)
+	if err != nil {
+		return err
+	}
+	// Every image has Docker, installRuntime only starts it.
+	if runtime != RuntimeDocker && guestRuntime(vm) != want {
+		missing = append(missing, fmt.Sprintf("container runtime %s in the image of %q", want, vm.Hostname()))
+	}
+	out, err := vm.Run("kubeadm version -o short")
+	if err != nil || strings.TrimSpace(string(out)) != "v"+strings.TrimPrefix(version, "v") {
+		missing = append(missing, fmt.Sprintf("Kubernetes %s in the image of %q", version, vm.Hostname()))
+	}
+	if len(missing) > 0 {
+		// Without the runtime or kubeadm, images can't be checked.
+		return &MissingArtifactsError{missing}
+	}
+
+	out, err = vm.Run(fmt.Sprintf("systemctl start %s && kubeadm config images list --kubernetes-version v%s", runtimeService(runtime), strings.TrimPrefix(version, "v")))
+	if err != nil {
+		return fmt.Errorf("listing Kubernetes images: %v", err)
+	}
+	imgs := strings.Fields(string(out))
+	imgs = append(imgs, strings.Fields(string(assets.MustAsset("addon-images")))...)
+	var cmds []string
+	for _, img := range imgs {
+		cmds = append(cmds, fmt.Sprintf("%s >/dev/null 2>&1 || echo %s", imagePresentCommand(runtime, img), img))
+	}
+	out, err = vm.Run(strings.Join(cmds, "; "))
+	if err != nil {
+		return fmt.Errorf("checking container images: %v", err)
+	}
+	for _, img := range strings.Fields(string(out)) {
+		missing = append(missing, fmt.Sprintf("container image %s in the image of %q", img, vm.Hostname()))
+	}
+	if c.cfg.CNI == CNICilium {
+		missing = append(missing, "Cilium's container images, which CNICilium always pulls from upstream")
+	}
+	if len(missing) > 0 {
+		return &MissingArtifactsError{missing}
+	}
+	return nil
+}
+
+// Prefetch populates the image cache, see UniverseConfig.ImageCache,
+// with everything that realizing spec needs, so that Apply can later
+// realize it in offline mode. Images that the spec builds or
+// downloads end up in the cache. Those that clusters use must install
+// Kubernetes with its container images preloaded, since offline nodes
+// can't fetch them. Images that the spec imports from local files
+// are left alone, since they're available offline already.
+//
+// Prefetch builds the images in a temporary universe under the image
+// cache, which it destroys when done. runtimecfg configures that
+// universe, except that it's always online.
+func Prefetch(ctx context.Context, spec *Spec, runtimecfg *UniverseConfig) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	if err := spec.validateOffline(); err != nil {
+		return err
+	}
+
+	cfg := UniverseConfig{}
+	if runtimecfg != nil {
+		cfg = *runtimecfg
+	}
+	cfg.Offline = false
+	cache := cfg.imageCacheDir()
+	if cache == "" {
+		return errors.New("prefetching requires an image cache")
+	}
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(cache, "prefetch")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	u, err := Create(ctx, filepath.Join(tmp, "u"), &cfg)
+	if err != nil {
+		return err
+	}
+	defer u.Destroy()
+
+	for _, img := range spec.Images {
+		switch {
+		case img.Import != "":
+			continue
+		case img.URL != "":
+			err = u.ImportImageURL(ctx, img.Name, img.URL, img.SHA256)
+		default:
+			err = u.NewImage(img.imageConfig())
+		}
+		if err != nil {
+			return fmt.Errorf("prefetching image %q: %v", img.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateOffline checks that the spec's clusters can be realized
+// offline from images the spec builds.
+func (s *Spec) validateOffline() error {
+	images := map[string]*ImageSpec{}
+	for i := range s.Images {
+		images[s.Images[i].Name] = &s.Images[i]
+	}
+	for _, cluster := range s.Clusters {
+		if cluster.CNI == CNICilium {
+			return fmt.Errorf("cluster %q: CNI %s pulls its images from upstream, and can't be used offline", cluster.Name, CNICilium)
+		}
+		if cluster.VMConfig == nil {
+			continue
+		}
+		img := images[cluster.VMConfig.Image]
+		if img == nil || img.Import != "" || img.URL != "" {
+			// Not built by the spec, offline mode checks the
+			// image when the cluster starts.
+			continue
+		}
+		if !img.InstallK8s || !img.PreloadK8sImages {
+			return fmt.Errorf("cluster %q: image %q must set InstallK8s and PreloadK8sImages to be usable offline", cluster.Name, img.Name)
+		}
+		if runtime := cluster.Runtime; runtime != "" && runtime != RuntimeDocker && runtime != img.Runtime {
+			return fmt.Errorf("cluster %q: image %q must install container runtime %s to be usable offline", cluster.Name, img.Name, runtime)
+		}
+		if version := strings.TrimPrefix(cluster.KubernetesVersion, "v"); version != "" && version != defaultKubernetesVersion {
+			return fmt.Errorf("cluster %q: images install Kubernetes %s, so Kubernetes %s can't be used offline", cluster.Name, defaultKubernetesVersion, cluster.KubernetesVersion)
+		}
+	}
+	return nil
+}
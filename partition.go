@@ -0,0 +1,110 @@
+package virtuakube
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Partition splits the universe's networks into isolated islands:
+// VMs in different groups can no longer exchange any traffic, while
+// VMs in the same group, and VMs in no group, are unaffected. The
+// partition replaces any previous one.
+//
+// Partitions are implemented by dropping frames from the other
+// groups' MAC addresses as they enter each VM, so they also cut
+// encapsulated and directly routed pod traffic between cluster
+// nodes. Like link impairments, partitions persist if the universe is
+// saved.
+func (u *Universe) Partition(groups [][]*VM) error {
+	group := map[*VM]int{}
+	for i, vms := range groups {
+		for _, vm := range vms {
+			if vm.universe != u {
+				return fmt.Errorf("VM %q isn't in this universe", vm.Hostname())
+			}
+			if g, ok := group[vm]; ok && g != i {
+				return fmt.Errorf("VM %q is in more than one group", vm.Hostname())
+			}
+			group[vm] = i
+		}
+	}
+
+	for _, vm := range u.VMs() {
+		blocked := map[string]map[string]string{}
+		if g, ok := group[vm]; ok {
+			for peer, pg := range group {
+				if pg == g {
+					continue
+				}
+				macs := map[string]string{}
+				for _, net := range sharedNetworks(vm, peer) {
+					macs[net] = peer.cfg.MAC[net]
+				}
+				if len(macs) > 0 {
+					blocked[peer.Hostname()] = macs
+				}
+			}
+		}
+		if err := vm.setPartitioned(blocked); err != nil {
+			return fmt.Errorf("partitioning %q: %v", vm.Hostname(), err)
+		}
+	}
+	return nil
+}
+
+// Heal removes the universe's partition, if any.
+func (u *Universe) Heal() error {
+	return u.Partition(nil)
+}
+
+// setPartitioned makes the VM drop traffic from blocked, and
+// reconfigures the guest if that changes anything.
+func (v *VM) setPartitioned(blocked map[string]map[string]string) error {
+	v.mu.Lock()
+	if len(v.cfg.Partitioned) == 0 && len(blocked) == 0 {
+		v.mu.Unlock()
+		return nil
+	}
+	if len(blocked) == 0 {
+		blocked = nil
+	}
+	v.cfg.Partitioned = blocked
+	v.mu.Unlock()
+	return v.applyPartition()
+}
+
+// applyPartition replaces the ingress filters of the VM's network
+// interfaces with ones that drop traffic from the VMs it's
+// partitioned from.
+func (v *VM) applyPartition() error {
+	v.mu.Lock()
+	var peers []string
+	for peer := range v.cfg.Partitioned {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	macs := map[string][]string{}
+	for _, peer := range peers {
+		for net, mac := range v.cfg.Partitioned[peer] {
+			macs[net] = append(macs[net], mac)
+		}
+	}
+	v.mu.Unlock()
+
+	for i, net := range v.Networks() {
+		dev := nicInterface(i)
+		cmds := []string{
+			fmt.Sprintf("tc qdisc del dev %s clsact 2>/dev/null || true", dev),
+		}
+		if len(macs[net]) > 0 {
+			cmds = append(cmds, fmt.Sprintf("tc qdisc add dev %s clsact", dev))
+		}
+		for _, mac := range macs[net] {
+			cmds = append(cmds, fmt.Sprintf("tc filter add dev %s ingress protocol all prio 1 flower src_mac %s action drop", dev, mac))
+		}
+		if err := v.RunMultiple(cmds...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
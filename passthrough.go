@@ -0,0 +1,141 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+var (
+	pciAddressRE = regexp.MustCompile(`^([0-9a-f]{4}:)?[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+	mdevUUIDRE   = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// DeviceConfig is a host device to pass through to a VM with VFIO,
+// e.g. a GPU for a Kubernetes device plugin to find. Exactly one of
+// PCIAddress and MdevUUID must be set.
+//
+// The VM owns the device exclusively while it runs, and QEMU pins
+// all of the VM's memory, so memory ballooning has no effect. VMs
+// with passthrough devices need KVM acceleration, and universes with
+// them can't be saved.
+type DeviceConfig struct {
+	// PCIAddress is the host PCI address of a whole device, e.g.
+	// "0000:01:00.0", or "01:00.0" in PCI domain 0. The device must
+	// be bound to the vfio-pci driver, with every other device in
+	// its IOMMU group.
+	PCIAddress string
+	// MdevUUID is the UUID of a mediated device, such as a vGPU
+	// slice of a GPU, already created in /sys/bus/mdev/devices.
+	MdevUUID string
+}
+
+func (d *DeviceConfig) validate() error {
+	switch {
+	case d.PCIAddress != "" && d.MdevUUID != "":
+		return errors.New("only one of PCIAddress and MdevUUID can be set")
+	case d.PCIAddress != "":
+		if !pciAddressRE.MatchString(d.PCIAddress) {
+			return fmt.Errorf("invalid PCI address %q, must be like 0000:01:00.0", d.PCIAddress)
+		}
+		dev := filepath.Join("/sys/bus/pci/devices", d.toConfig().PCIAddress)
+		if _, err := os.Stat(dev); err != nil {
+			return fmt.Errorf("host has no PCI device %s", d.PCIAddress)
+		}
+		driver, err := os.Readlink(filepath.Join(dev, "driver"))
+		if err != nil || filepath.Base(driver) != "vfio-pci" {
+			return fmt.Errorf("PCI device %s isn't bound to the vfio-pci driver", d.PCIAddress)
+		}
+		group, err := os.Readlink(filepath.Join(dev, "iommu_group"))
+		if err != nil {
+			return fmt.Errorf("PCI device %s has no IOMMU group, is the IOMMU enabled?", d.PCIAddress)
+		}
+		if _, err := os.Stat(filepath.Join("/dev/vfio", filepath.Base(group))); err != nil {
+			return fmt.Errorf("PCI device %s: %v", d.PCIAddress, err)
+		}
+	case d.MdevUUID != "":
+		if !mdevUUIDRE.MatchString(d.MdevUUID) {
+			return fmt.Errorf("invalid mediated device UUID %q", d.MdevUUID)
+		}
+		if _, err := os.Stat(filepath.Join("/sys/bus/mdev/devices", d.MdevUUID)); err != nil {
+			return fmt.Errorf("host has no mediated device %s", d.MdevUUID)
+		}
+	default:
+		return errors.New("one of PCIAddress and MdevUUID must be set")
+	}
+	return nil
+}
+
+func (d *DeviceConfig) toConfig() config.Device {
+	ret := config.Device{
+		PCIAddress: d.PCIAddress,
+		MdevUUID:   d.MdevUUID,
+	}
+	if ret.PCIAddress != "" && len(ret.PCIAddress) == len("01:00.0") {
+		ret.PCIAddress = "0000:" + ret.PCIAddress
+	}
+	return ret
+}
+
+// validateDevices checks a VM's passthrough devices, for a VM of
+// arch.
+func (u *Universe) validateDevices(devices []DeviceConfig, arch string) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	if u.runtimecfg.NoAcceleration || !canAccelerate(arch) {
+		return errors.New("passthrough devices require KVM acceleration")
+	}
+	seen := map[config.Device]bool{}
+	for i := range devices {
+		if err := devices[i].validate(); err != nil {
+			return fmt.Errorf("device %d: %v", i+1, err)
+		}
+		d := devices[i].toConfig()
+		if seen[d] {
+			return fmt.Errorf("device %d is listed twice", i+1)
+		}
+		seen[d] = true
+	}
+	return nil
+}
+
+// checkDevicesFreeWithLock checks that no VM of the universe already
+// owns one of devices.
+func (u *Universe) checkDevicesFreeWithLock(devices []config.Device) error {
+	for name, vm := range u.vms {
+		for _, owned := range vm.cfg.Devices {
+			for _, d := range devices {
+				if d == owned {
+					return fmt.Errorf("VM %q already owns device %s", name, deviceName(d))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func deviceName(d config.Device) string {
+	if d.PCIAddress != "" {
+		return d.PCIAddress
+	}
+	return "mdev " + d.MdevUUID
+}
+
+// deviceArgs returns the qemu arguments that pass devices through to
+// a VM.
+func deviceArgs(devices []config.Device) []string {
+	var ret []string
+	for _, d := range devices {
+		if d.PCIAddress != "" {
+			ret = append(ret, "-device", "vfio-pci,host="+d.PCIAddress)
+		} else {
+			ret = append(ret, "-device", "vfio-pci,sysfsdev="+filepath.Join("/sys/bus/mdev/devices", d.MdevUUID))
+		}
+	}
+	return ret
+}
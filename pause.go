@@ -0,0 +1,89 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Pause stops the VM's CPUs, freezing it in place. The VM keeps its
+// memory and host resources, but does nothing until Resume: commands
+// run on it block, and to other VMs it looks like a hung machine.
+// Pausing a paused VM does nothing.
+//
+// Pausing doesn't survive Save, VMs always resume from a snapshot
+// running.
+func (v *VM) Pause() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("VM is closed")
+	}
+	if v.paused {
+		return nil
+	}
+	if err := v.universe.backend.stopCPUs(v); err != nil {
+		return err
+	}
+	v.paused = true
+	v.universe.emit(EventVMPaused, v.cfg.Name, "")
+	return nil
+}
+
+// Resume restarts the CPUs of a VM stopped by Pause, and steps the
+// guest's clock back to the universe's clock, which it fell behind
+// while paused. Resuming a VM that isn't paused does nothing.
+func (v *VM) Resume() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("VM is closed")
+	}
+	if !v.paused {
+		return nil
+	}
+	if err := v.universe.backend.startCPUs(v); err != nil {
+		return err
+	}
+	v.paused = false
+	// The VM runs again either way, so a clock left behind isn't
+	// worth failing the resume over.
+	if err := v.syncClockWithLock(); err != nil {
+		v.log.Warn("syncing clock after resume failed", "error", err)
+	}
+	v.universe.emit(EventVMResumed, v.cfg.Name, "")
+	return nil
+}
+
+// Paused returns whether the VM is paused.
+func (v *VM) Paused() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.paused
+}
+
+// Pause pauses all of the cluster's VMs, as VM.Pause does. The API
+// server becomes unreachable until Resume.
+func (c *Cluster) Pause() error {
+	c.mu.Lock()
+	vms := c.vmsWithLock()
+	c.mu.Unlock()
+	for _, vm := range vms {
+		if err := vm.Pause(); err != nil {
+			return fmt.Errorf("pausing %q: %v", vm.Hostname(), err)
+		}
+	}
+	return nil
+}
+
+// Resume resumes all of the cluster's VMs, as VM.Resume does.
+func (c *Cluster) Resume() error {
+	c.mu.Lock()
+	vms := c.vmsWithLock()
+	c.mu.Unlock()
+	for _, vm := range vms {
+		if err := vm.Resume(); err != nil {
+			return fmt.Errorf("resuming %q: %v", vm.Hostname(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxConnectedClusters is the number of clusters in a universe that
+// can connect their pod networks. Connected clusters get distinct
+// /16s of podNetwork, instead of all of it.
+const maxConnectedClusters = 16
+
+// podPeer is a cluster whose pod network is connected to those of
+// the other connected clusters on its cluster network.
+type podPeer struct {
+	network string
+	// VMs that host the cluster's pods.
+	vms []*VM
+	// Routes to the cluster's pods, as "podCIDR via nodeIP".
+	routes []string
+}
+
+// validatePodNetwork checks that clusters can connect cfg's pod
+// network to other clusters'.
+func (cfg *ClusterConfig) validatePodNetwork() error {
+	if !cfg.ConnectPodNetwork {
+		return nil
+	}
+	if cfg.IPFamily != "" && cfg.IPFamily != IPFamilyIPv4 {
+		return errors.New("ConnectPodNetwork requires IPFamily ipv4")
+	}
+	switch cfg.CNI {
+	case CNICilium, CNIWeave:
+		return fmt.Errorf("ConnectPodNetwork doesn't work with %s, which doesn't allocate pod addresses from the nodes' pod CIDRs", cfg.CNI)
+	}
+	return nil
+}
+
+// allocPodNetworkWithLock returns a pod network for a new connected
+// cluster that doesn't overlap with any other connected cluster's.
+func (u *Universe) allocPodNetworkWithLock() (string, error) {
+	used := map[string]bool{}
+	for _, c := range u.clusters {
+		used[c.cfg.PodNetwork] = true
+	}
+	_, base, _ := net.ParseCIDR(podNetwork)
+	for i := 0; i < maxConnectedClusters; i++ {
+		ip := base.IP.To4()
+		cidr := fmt.Sprintf("%d.%d.0.0/16", ip[0], int(ip[1])+i)
+		if !used[cidr] {
+			return cidr, nil
+		}
+	}
+	return "", fmt.Errorf("universe already has %d clusters with connected pod networks", maxConnectedClusters)
+}
+
+// podNetwork returns the IPv4 pod network of the cluster.
+func (c *Cluster) podNetwork() string {
+	if c.cfg.PodNetwork == "" {
+		return podNetwork
+	}
+	return c.cfg.PodNetwork
+}
+
+// podVMsWithLock returns the VMs that host the cluster's pods.
+func (c *Cluster) podVMsWithLock() []*VM {
+	ret := append([]*VM{c.controller}, c.controlPlanes...)
+	return append(ret, c.nodes...)
+}
+
+// connectPodNetwork routes between the pods of vms, the cluster's pod
+// hosts, and the pods of the other connected clusters on the cluster
+// network, and returns the routes to the cluster's pods. Each node of
+// a connected cluster gets a route to every other cluster's per-node
+// pod CIDRs, via the node that has it. Traffic leaving a cluster's
+// pods for another cluster is still masqueraded by its CNI, as for
+// any destination outside its pod network.
+func (c *Cluster) connectPodNetwork(ctx context.Context, vms []*VM) ([]string, error) {
+	routes, err := c.podRoutes(ctx, vms)
+	if err != nil {
+		return nil, err
+	}
+	peer := &podPeer{
+		network: c.controller.Networks()[0],
+		vms:     vms,
+		routes:  routes,
+	}
+	if err := c.universe.updatePodPeer(c.Name(), peer); err != nil {
+		return nil, fmt.Errorf("connecting pod network: %v", err)
+	}
+	return routes, nil
+}
+
+// reconnectPodNetwork updates the routes to the cluster's pods after
+// its pod hosts changed to vms, if its pod network is connected.
+func (c *Cluster) reconnectPodNetwork(ctx context.Context, vms []*VM) error {
+	if c.cfg.PodNetwork == "" {
+		return nil
+	}
+	routes, err := c.connectPodNetwork(ctx, vms)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg.PodRoutes = routes
+	return nil
+}
+
+// podRoutes returns the routes to the pods of vms, once Kubernetes has
+// allocated a pod CIDR to each of them.
+func (c *Cluster) podRoutes(ctx context.Context, vms []*VM) ([]string, error) {
+	byName := map[string]*VM{}
+	for _, vm := range vms {
+		byName[vm.Hostname()] = vm
+	}
+
+	var routes []string
+	err := c.WaitFor(ctx, func() (bool, error) {
+		routes = nil
+		nodes, err := c.client.CoreV1().Nodes().List(metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, node := range nodes.Items {
+			vm := byName[node.Name]
+			if vm == nil {
+				continue
+			}
+			if node.Spec.PodCIDR == "" {
+				return false, nil
+			}
+			routes = append(routes, fmt.Sprintf("%s via %s", node.Spec.PodCIDR, c.clusterIP(vm)))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting node pod CIDRs: %v", err)
+	}
+	sort.Strings(routes)
+	return routes, nil
+}
+
+// updatePodPeer records peer as the connected cluster name, and makes
+// the routes between it and the other connected clusters on its
+// network match.
+func (u *Universe) updatePodPeer(name string, peer *podPeer) error {
+	u.podPeersMu.Lock()
+	defer u.podPeersMu.Unlock()
+
+	var stale []string
+	if old := u.podPeers[name]; old != nil {
+		current := map[string]bool{}
+		for _, r := range peer.routes {
+			current[r] = true
+		}
+		for _, r := range old.routes {
+			if !current[r] {
+				stale = append(stale, r)
+			}
+		}
+	}
+
+	for other, p := range u.podPeers {
+		if other == name || p.network != peer.network {
+			continue
+		}
+		for _, vm := range peer.vms {
+			if err := addRoutes(vm, p.routes, nil); err != nil {
+				return err
+			}
+		}
+		for _, vm := range p.vms {
+			if err := addRoutes(vm, peer.routes, stale); err != nil {
+				return err
+			}
+		}
+	}
+
+	u.podPeers[name] = peer
+	return nil
+}
+
+// restorePodRoutes adds back the routes to other connected clusters'
+// pods that vm lost by rebooting, if it hosts pods of a connected
+// cluster.
+func (u *Universe) restorePodRoutes(vm *VM) error {
+	u.podPeersMu.Lock()
+	defer u.podPeersMu.Unlock()
+	for name, peer := range u.podPeers {
+		if !containsVM(peer.vms, vm) {
+			continue
+		}
+		for other, p := range u.podPeers {
+			if other == name || p.network != peer.network {
+				continue
+			}
+			if err := addRoutes(vm, p.routes, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func containsVM(vms []*VM, vm *VM) bool {
+	for _, v := range vms {
+		if v == vm {
+			return true
+		}
+	}
+	return false
+}
+
+// addRoutes adds routes to vm, replacing any routes for the same pod
+// CIDRs, after deleting the stale ones.
+func addRoutes(vm *VM, routes, stale []string) error {
+	var cmds []string
+	for _, r := range stale {
+		// The stale CIDR may have been reused by a route in routes.
+		cmds = append(cmds, fmt.Sprintf("ip route del %s || true", strings.Fields(r)[0]))
+	}
+	for _, r := range routes {
+		cmds = append(cmds, "ip route replace "+r)
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	if _, err := vm.Run(strings.Join(cmds, " && ")); err != nil {
+		return fmt.Errorf("setting pod routes on %q: %v", vm.Hostname(), err)
+	}
+	return nil
+}
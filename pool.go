@@ -0,0 +1,262 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// PoolConfig is the configuration for a Pool.
+type PoolConfig struct {
+	// Dir is the universe that the pool runs copies of. It must not
+	// be open while the pool copies it.
+	Dir string
+	// Snapshot is the snapshot of Dir that copies resume from.
+	Snapshot string
+	// Size is the number of copies to keep booted.
+	Size int
+	// WorkDir is where the copies live. If empty, they go in a
+	// temporary directory that Close removes.
+	WorkDir string
+	// UniverseConfig is the runtime configuration of the copies.
+	UniverseConfig *UniverseConfig
+}
+
+// Pool keeps copies of a universe snapshot booted, so that parallel
+// tests can each get a running universe without paying the cost of
+// resuming it, or sharing a universe directory.
+//
+// Copies share Dir's images, and get their own copy of the
+// snapshot's VM disks, made with reflinks where the filesystem
+// supports them. A copy that's handed back with Put is closed,
+// reverting it to the snapshot, and resumed again in the background.
+// Each copy has a universe ID of its own, which its clusters' kube
+// contexts are renamed after, so that they can be merged into one
+// kubeconfig.
+// Tests must not Save or Destroy universes from a pool.
+type Pool struct {
+	cfg     PoolConfig
+	workDir string
+	ownDir  bool
+
+	// Opening universes concurrently can race for localhost ports,
+	// so copies are resumed one at a time.
+	openMu sync.Mutex
+	// Canceled by Close, to abort resumes in progress.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	ready chan *Universe
+	errs  chan error
+
+	mu     sync.Mutex
+	out    map[*Universe]string
+	dirs   map[string]bool
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// NewPool copies cfg.Snapshot of cfg.Dir cfg.Size times, and starts
+// resuming the copies in the background.
+func NewPool(cfg *PoolConfig) (*Pool, error) {
+	if cfg.Size <= 0 {
+		return nil, errors.New("pool Size must be positive")
+	}
+	src, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Pool{
+		cfg:     *cfg,
+		workDir: cfg.WorkDir,
+		ready:   make(chan *Universe, cfg.Size),
+		errs:    make(chan error, cfg.Size),
+		out:     map[*Universe]string{},
+		dirs:    map[string]bool{},
+	}
+	ret.ctx, ret.cancel = context.WithCancel(context.Background())
+	ret.cfg.Dir = src
+	if ret.workDir == "" {
+		if ret.workDir, err = ioutil.TempDir("", "virtuakube-pool"); err != nil {
+			return nil, err
+		}
+		ret.ownDir = true
+	}
+
+	for i := 0; i < cfg.Size; i++ {
+		dir := filepath.Join(ret.workDir, fmt.Sprintf("universe%d", i))
+		// Close deletes the pool's dirs, so dir only becomes one once
+		// the pool has made it, never if it was already there.
+		if err := os.Mkdir(dir, 0700); err != nil {
+			ret.Close()
+			return nil, err
+		}
+		ret.dirs[dir] = true
+		if err := copySnapshot(src, cfg.Snapshot, dir); err != nil {
+			ret.Close()
+			return nil, fmt.Errorf("copying universe: %v", err)
+		}
+	}
+	for dir := range ret.dirs {
+		ret.resume(dir)
+	}
+	return ret, nil
+}
+
+// resume opens the copy in dir in the background, and makes it
+// available to Get.
+func (p *Pool) resume(dir string) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.openMu.Lock()
+		u, err := Open(p.ctx, dir, p.cfg.Snapshot, p.cfg.UniverseConfig)
+		p.openMu.Unlock()
+		if err != nil {
+			p.errs <- fmt.Errorf("resuming %q: %v", dir, err)
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.closed {
+			u.Close()
+			return
+		}
+		p.out[u] = dir
+		p.ready <- u
+	}()
+}
+
+// Get returns a running copy of the snapshot, waiting for one to be
+// available if necessary. The caller must return it with Put.
+func (p *Pool) Get(ctx context.Context) (*Universe, error) {
+	select {
+	case u := <-p.ready:
+		return u, nil
+	case err := <-p.errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Put hands u, which must have come from Get, back to the pool. u is
+// closed, discarding the changes made to it, and resumed from the
+// snapshot again to serve a later Get.
+func (p *Pool) Put(u *Universe) error {
+	p.mu.Lock()
+	dir, ok := p.out[u]
+	delete(p.out, u)
+	closed := p.closed
+	p.mu.Unlock()
+	if !ok {
+		return errors.New("universe doesn't belong to the pool")
+	}
+
+	if err := u.Close(); err != nil {
+		return err
+	}
+	if !closed {
+		p.resume(dir)
+	}
+	return nil
+}
+
+// Close closes all the pool's universes, including ones that haven't
+// been returned with Put, and deletes their directories.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.cancel()
+	var us []*Universe
+	for u := range p.out {
+		us = append(us, u)
+	}
+	p.out = map[*Universe]string{}
+	p.mu.Unlock()
+
+	var retErr error
+	for _, u := range us {
+		if err := u.Close(); err != nil {
+			retErr = err
+		}
+	}
+	p.wg.Wait()
+	for dir := range p.dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			retErr = err
+		}
+	}
+	if p.ownDir {
+		if err := os.RemoveAll(p.workDir); err != nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
+
+// copySnapshot makes a new universe in the empty directory dst that
+// contains only snapshot of the universe in src. Images are symlinked, since VMs
+// never write to them, and VM disks are copied. The copy gets a
+// universe ID of its own.
+func copySnapshot(src, snapshot, dst string) error {
+	cfg, err := config.Read(filepath.Join(src, "config.json"))
+	if err != nil {
+		return err
+	}
+	snap := cfg.Snapshots[snapshot]
+	if snap == nil {
+		return fmt.Errorf("%w: %q", ErrSnapshotNotFound, snapshot)
+	}
+
+	for _, img := range snap.Images {
+		for _, file := range img.Files() {
+			if err := os.Symlink(filepath.Join(src, file), filepath.Join(dst, file)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, vm := range snap.VMs {
+		for _, file := range vm.Files() {
+			if filepath.IsAbs(file) {
+				return fmt.Errorf("VM %q has a disk outside the universe, it can't be copied", vm.Name)
+			}
+			// VM disks are backed by images in src by absolute path,
+			// so the copies stay valid as they are.
+			out, err := exec.Command("cp", "--reflink=auto", "--sparse=always", filepath.Join(src, file), filepath.Join(dst, file)).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("copying disk of %q: %v (%s)", vm.Name, err, out)
+			}
+		}
+	}
+
+	// Each copy is a universe of its own, whose clusters' kube
+	// contexts must not collide with the other copies' when they're
+	// merged into one kubeconfig. Default contexts get the copy's ID
+	// in place of the original's, and custom ones get it appended.
+	id := randomUniverseID()
+	for _, cluster := range snap.Clusters {
+		if cluster.KubeContext == "" || cluster.KubeContext == cluster.Name+"-"+cfg.ID {
+			cluster.KubeContext = cluster.Name + "-" + id
+		} else {
+			cluster.KubeContext += "-" + id
+		}
+		kubeconfig, err := renameKubeconfig(cluster.Kubeconfig, cluster.KubeContext)
+		if err != nil {
+			return fmt.Errorf("renaming kube context of cluster %q: %v", cluster.Name, err)
+		}
+		cluster.Kubeconfig = kubeconfig
+	}
+
+	return config.Write(filepath.Join(dst, "config.json"), &config.Universe{
+		ID:        id,
+		Snapshots: map[string]*config.Snapshot{snapshot: snap},
+	})
+}
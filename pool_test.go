@@ -0,0 +1,60 @@
+package virtuakube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// TestNewPoolKeepsExistingDirs checks that a NewPool that fails on a
+// copy directory that's already in WorkDir deletes the copies that it
+// made, and leaves the existing directory alone.
+func TestNewPoolKeepsExistingDirs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "virtuakube-pool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	if err := os.Mkdir(src, 0700); err != nil {
+		t.Fatal(err)
+	}
+	err = config.Write(filepath.Join(src, "config.json"), &config.Universe{
+		ID:        randomUniverseID(),
+		Snapshots: map[string]*config.Snapshot{"snap": {Name: "snap"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := filepath.Join(tmp, "work")
+	existing := filepath.Join(work, "universe1")
+	if err := os.MkdirAll(existing, 0700); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(existing, "keep")
+	if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPool(&PoolConfig{
+		Dir:      src,
+		Snapshot: "snap",
+		Size:     2,
+		WorkDir:  work,
+	})
+	if err == nil {
+		p.Close()
+		t.Fatal("NewPool succeeded with universe1 already in WorkDir")
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("existing universe1 was deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(work, "universe0")); !os.IsNotExist(err) {
+		t.Errorf("universe0 wasn't cleaned up: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// External scripts tend to cache the localhost ports that reach into
+// a universe, so port forwards are sticky: every allocation is
+// recorded in snapshot metadata, and a VM port that has had a host
+// port before gets the same one again (if it's free), even when the
+// snapshot that allocated it was reverted.
+
+func portKey(vm string, port int) string {
+	return vm + ":" + strconv.Itoa(port)
+}
+
+// loadPortHistory builds the universe's port allocation history from
+// the saved snapshots. Each host port belongs to at most one VM port,
+// and the snapshot being opened wins any conflicts.
+func loadPortHistory(cfg *config.Universe, active *config.Snapshot) map[string]int {
+	ret := map[string]int{}
+	used := map[int]bool{}
+	add := func(key string, port int) {
+		if _, ok := ret[key]; ok || used[port] {
+			return
+		}
+		ret[key] = port
+		used[port] = true
+	}
+
+	for _, vm := range active.VMs {
+		for dst, src := range vm.PortForwards {
+			add(portKey(vm.Name, dst), src)
+		}
+	}
+	for k, v := range active.Ports {
+		add(k, v)
+	}
+	names := make([]string, 0, len(cfg.Snapshots))
+	for name := range cfg.Snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for k, v := range cfg.Snapshots[name].Ports {
+			add(k, v)
+		}
+	}
+
+	return ret
+}
+
+// allocPortsWithLock assigns localhost ports for the VM port forwards
+// in cfg. For a new VM, forwards that have no host port yet get their
+// historical port if possible. For resumed VMs, forwards keep their
+// snapshotted port if it's still free.
+func (u *Universe) allocPortsWithLock(cfg *config.VM, want []int) {
+	sort.Ints(want)
+	for _, dst := range want {
+		key := portKey(cfg.Name, dst)
+		prev, ok := cfg.PortForwards[dst]
+		if !ok {
+			prev, ok = u.ports[key]
+		}
+		if ok && u.portAvailableWithLock(prev, key) {
+			cfg.PortForwards[dst] = prev
+			u.ports[key] = prev
+			continue
+		}
+
+		port := u.freePortWithLock()
+		if ok {
+			u.emit(EventPortReassigned, cfg.Name, "localhost:%d is in use, port %d is now forwarded from localhost:%d", prev, dst, port)
+		}
+		cfg.PortForwards[dst] = port
+		u.ports[key] = port
+	}
+}
+
+// freePortWithLock returns a fresh localhost port which isn't
+// reserved for another VM, in use by the universe, or in use on the
+// host.
+func (u *Universe) freePortWithLock() int {
+	for {
+		port := u.port()
+		if u.portAvailableWithLock(port, "") {
+			return port
+		}
+	}
+}
+
+// portAvailableWithLock reports whether port can be given to the
+// port forward identified by key.
+func (u *Universe) portAvailableWithLock(port int, key string) bool {
+	for k, v := range u.ports {
+		if v == port && k != key {
+			// Reserved in history for another forward, unless that
+			// forward has since moved elsewhere.
+			return false
+		}
+	}
+	for _, vm := range u.vms {
+		for _, src := range vm.cfg.PortForwards {
+			if src == port {
+				return false
+			}
+		}
+	}
+	return hostPortFree(port)
+}
+
+// hostPortFree reports whether port is free on the host's localhost,
+// where QEMU binds its port forwards.
+func hostPortFree(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// portHistoryWithLock returns a copy of the port allocation history,
+// for saving in a snapshot.
+func (u *Universe) portHistoryWithLock() map[string]int {
+	ret := make(map[string]int, len(u.ports))
+	for k, v := range u.ports {
+		ret[k] = v
+	}
+	return ret
+}
+
+// vmForwards returns the VM ports of cfg's port forwards.
+func vmForwards(cfg *config.VM) []int {
+	ret := make([]int, 0, len(cfg.PortForwards))
+	for dst := range cfg.PortForwards {
+		ret = append(ret, dst)
+	}
+	return ret
+}
+
+// ForwardPort forwards a localhost port to guestPort on the running
+// VM, and returns the localhost port. If guestPort is already
+// forwarded, its existing host port is returned. Like the forwards in
+// VMConfig.PortForwards, the forward persists if the universe is
+// saved, and its host port is reused across runs when possible.
+func (v *VM) ForwardPort(guestPort int) (int, error) {
+	if guestPort <= 0 || guestPort > 65535 {
+		return 0, fmt.Errorf("invalid port %d", guestPort)
+	}
+
+	u := v.universe
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return 0, errors.New("VM is closed")
+	}
+	if port, ok := v.cfg.PortForwards[guestPort]; ok {
+		return port, nil
+	}
+
+	u.allocPortsWithLock(v.cfg, []int{guestPort})
+	port := v.cfg.PortForwards[guestPort]
+	out, err := v.monitorWithLock(fmt.Sprintf("hostfwd_add net0 tcp:127.0.0.1:%d-:%d", port, guestPort))
+	if err == nil && strings.TrimSpace(out) != "" {
+		err = fmt.Errorf("forwarding localhost:%d: %s", port, strings.TrimSpace(out))
+	}
+	if err != nil {
+		delete(v.cfg.PortForwards, guestPort)
+		return 0, err
+	}
+	return port, nil
+}
+
+// StopForward removes the forward of guestPort on the running VM. The
+// SSH port can't be unforwarded, virtuakube needs it to control the
+// VM.
+func (v *VM) StopForward(guestPort int) error {
+	if guestPort == 22 {
+		return errors.New("can't stop forwarding the SSH port")
+	}
+
+	// The VM's port forwards are saved under the universe's lock.
+	u := v.universe
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("VM is closed")
+	}
+	port, ok := v.cfg.PortForwards[guestPort]
+	if !ok {
+		return fmt.Errorf("port %d isn't forwarded", guestPort)
+	}
+	out, err := v.monitorWithLock(fmt.Sprintf("hostfwd_remove net0 tcp:127.0.0.1:%d", port))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "removed") {
+		return fmt.Errorf("removing forward of localhost:%d: %s", port, strings.TrimSpace(out))
+	}
+	delete(v.cfg.PortForwards, guestPort)
+	return nil
+}
@@ -0,0 +1,166 @@
+package virtuakube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// CheckStatus is the outcome of a preflight check.
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+)
+
+// CheckResult is the result of a single preflight check.
+type CheckResult struct {
+	Name    string
+	Status  CheckStatus
+	Message string
+}
+
+// Preflight checks whether the host can run universes, and returns
+// the result of each check. If dir is non-empty, it also checks the
+// free disk space available to a universe in dir (which need not
+// exist yet).
+//
+// Any CheckFail result means that Create or Open will fail. CheckWarn
+// results flag things that will make some features unavailable.
+func Preflight(dir string) []CheckResult {
+	ret := []CheckResult{
+		checkToolsResult("universe tools", universeTools, CheckFail),
+		checkToolsResult("image build tools", buildTools, CheckWarn),
+		checkToolsResult("host kubectl", []string{"kubectl"}, CheckWarn),
+		checkToolsResult("cloud-init seed tools", cloudInitTools, CheckWarn),
+		checkToolsResult("TPM emulator", tpmTools, CheckWarn),
+		checkOVMF(),
+		checkKVM(),
+		checkNestedVirt(),
+		checkQEMU(),
+		checkTUN(),
+	}
+	if dir != "" {
+		ret = append(ret, checkDiskSpace(dir))
+	}
+	return ret
+}
+
+func checkToolsResult(name string, tools []string, severity CheckStatus) CheckResult {
+	if err := checkTools(tools); err != nil {
+		return CheckResult{name, severity, err.Error()}
+	}
+	return CheckResult{name, CheckPass, strings.Join(tools, ", ")}
+}
+
+func checkKVM() CheckResult {
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return CheckResult{"kvm", CheckWarn, "/dev/kvm does not exist, VMs will only run with acceleration disabled (very slow)"}
+	} else if os.IsPermission(err) {
+		return CheckResult{"kvm", CheckWarn, "no permission to open /dev/kvm, add yourself to the kvm group or disable acceleration"}
+	} else if err != nil {
+		return CheckResult{"kvm", CheckWarn, fmt.Sprintf("opening /dev/kvm: %v", err)}
+	}
+	f.Close()
+	return CheckResult{"kvm", CheckPass, "/dev/kvm is usable"}
+}
+
+// kvmUsable returns an ErrKVMUnavailable error if /dev/kvm can't be
+// used.
+func kvmUsable() error {
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("%w: %v, disable acceleration to run VMs without it", ErrKVMUnavailable, err)
+	}
+	f.Close()
+	return nil
+}
+
+func checkNestedVirt() CheckResult {
+	feature, err := nestedKVM()
+	if err != nil {
+		return CheckResult{"nested virtualization", CheckWarn, err.Error() + ", VMs can't use NestedVirt"}
+	}
+	return CheckResult{"nested virtualization", CheckPass, "host allows nested KVM, VMs with NestedVirt get " + feature}
+}
+
+func checkOVMF() CheckResult {
+	ovmf, err := findOVMF(false)
+	if err != nil {
+		return CheckResult{"uefi firmware", CheckWarn, err.Error() + ", VMs can only boot with BIOS"}
+	}
+	if _, err := findOVMF(true); err != nil {
+		return CheckResult{"uefi firmware", CheckWarn, "OVMF at " + ovmf.code + " has no secure boot build"}
+	}
+	return CheckResult{"uefi firmware", CheckPass, "OVMF at " + ovmf.code}
+}
+
+func checkQEMU() CheckResult {
+	qemu, err := DetectQEMU()
+	if err != nil {
+		return CheckResult{"qemu", CheckFail, err.Error()}
+	}
+	if !qemu.HasMachineType(defaultMachineType) {
+		return CheckResult{"qemu", CheckFail, fmt.Sprintf("QEMU %s does not support machine type %q", qemu.Version, defaultMachineType)}
+	}
+
+	var missing []string
+	for _, c := range []QEMUCapability{CapIOURing, CapHostMTU, CapVirtioFS} {
+		if !qemu.Has(c) {
+			missing = append(missing, string(c))
+		}
+	}
+	if len(missing) > 0 {
+		return CheckResult{"qemu", CheckWarn, fmt.Sprintf("QEMU %s lacks %s", qemu.Version, strings.Join(missing, ", "))}
+	}
+	return CheckResult{"qemu", CheckPass, "QEMU " + qemu.Version}
+}
+
+func checkTUN() CheckResult {
+	f, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		return CheckResult{"tun/tap", CheckWarn, fmt.Sprintf("can't open /dev/net/tun (%v), bridged networking will not work", err)}
+	}
+	f.Close()
+	return CheckResult{"tun/tap", CheckPass, "/dev/net/tun is usable"}
+}
+
+const (
+	minFreeDisk  = 2 << 30
+	wantFreeDisk = 20 << 30
+)
+
+func checkDiskSpace(dir string) CheckResult {
+	// The universe dir may not exist yet, in which case check the
+	// closest existing parent.
+	path, err := filepath.Abs(dir)
+	if err != nil {
+		return CheckResult{"disk space", CheckFail, err.Error()}
+	}
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return CheckResult{"disk space", CheckFail, fmt.Sprintf("statfs %q: %v", path, err)}
+	}
+	free := st.Bavail * uint64(st.Bsize)
+	msg := fmt.Sprintf("%.1fGiB free in %s", float64(free)/(1<<30), path)
+	switch {
+	case free < minFreeDisk:
+		return CheckResult{"disk space", CheckFail, msg}
+	case free < wantFreeDisk:
+		return CheckResult{"disk space", CheckWarn, msg}
+	default:
+		return CheckResult{"disk space", CheckPass, msg}
+	}
+}
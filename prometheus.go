@@ -0,0 +1,331 @@
+package virtuakube
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsScrapeTimeout is how long a scrape waits for each VM's
+// usage. VMs that don't answer in time, e.g. because they're frozen
+// for a snapshot, are left out of the scrape.
+const metricsScrapeTimeout = 5 * time.Second
+
+// timings records how long the universe's slow operations took, for
+// the metrics endpoint.
+type timings struct {
+	mu           sync.Mutex
+	boot         map[string]time.Duration
+	clusterReady map[string]time.Duration
+	saves        int
+	saveTotal    time.Duration
+	lastSave     time.Duration
+}
+
+func (t *timings) recordBoot(vm string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.boot == nil {
+		t.boot = map[string]time.Duration{}
+	}
+	t.boot[vm] = d
+}
+
+func (t *timings) recordClusterReady(cluster string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clusterReady == nil {
+		t.clusterReady = map[string]time.Duration{}
+	}
+	t.clusterReady[cluster] = d
+}
+
+func (t *timings) recordSave(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.saves++
+	t.saveTotal += d
+	t.lastSave = d
+}
+
+// serveMetrics starts serving Prometheus metrics for the universe on
+// addr, at /metrics.
+func (u *Universe) serveMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening for metrics: %v", err)
+	}
+	u.metrics = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		u.writeMetrics(w)
+	})
+	go http.Serve(l, mux)
+	return nil
+}
+
+// stopMetrics stops the metrics endpoint, if any.
+func (u *Universe) stopMetrics() {
+	if u.metrics != nil {
+		u.metrics.Close()
+	}
+}
+
+// MetricsAddr returns the address of the universe's Prometheus
+// metrics endpoint, or "" if UniverseConfig.MetricsAddr is unset.
+func (u *Universe) MetricsAddr() string {
+	if u.metrics == nil {
+		return ""
+	}
+	return u.metrics.Addr().String()
+}
+
+// vmUsage is the resource usage of a VM at scrape time.
+type vmUsage struct {
+	name    string
+	memory  int64
+	rss     int64
+	cpu     time.Duration
+	disks   map[string]map[string]int64
+	nics    map[string][2]int64
+	usageOK bool
+}
+
+// metricFamily is a Prometheus metric with all its samples.
+type metricFamily struct {
+	name, help, typ string
+	samples         []string
+}
+
+func (m *metricFamily) add(value interface{}, labels ...string) {
+	var ls []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		ls = append(ls, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	var v string
+	switch x := value.(type) {
+	case time.Duration:
+		v = strconv.FormatFloat(x.Seconds(), 'g', -1, 64)
+	default:
+		v = fmt.Sprint(x)
+	}
+	if len(ls) == 0 {
+		m.samples = append(m.samples, fmt.Sprintf("%s %s", m.name, v))
+	} else {
+		m.samples = append(m.samples, fmt.Sprintf("%s{%s} %s", m.name, strings.Join(ls, ","), v))
+	}
+}
+
+// writeMetrics writes the universe's current metrics to w, in the
+// Prometheus text format.
+func (u *Universe) writeMetrics(w io.Writer) {
+	u.mu.Lock()
+	var vms []*VM
+	for _, vm := range u.vms {
+		vms = append(vms, vm)
+	}
+	numClusters := len(u.clusters)
+	u.mu.Unlock()
+
+	// Gathering usage talks to every VM, so do it in parallel and
+	// give up on stragglers.
+	usage := make(chan *vmUsage, len(vms))
+	for _, vm := range vms {
+		go func(vm *VM) { usage <- vm.usage() }(vm)
+	}
+	var usages []*vmUsage
+	timeout := time.After(metricsScrapeTimeout)
+collect:
+	for range vms {
+		select {
+		case us := <-usage:
+			usages = append(usages, us)
+		case <-timeout:
+			break collect
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].name < usages[j].name })
+
+	var (
+		numVMs  = &metricFamily{name: "virtuakube_vms", help: "Number of VMs in the universe.", typ: "gauge"}
+		clust   = &metricFamily{name: "virtuakube_clusters", help: "Number of Kubernetes clusters in the universe.", typ: "gauge"}
+		cpu     = &metricFamily{name: "virtuakube_vm_cpu_seconds_total", help: "Host CPU time used by the VM.", typ: "counter"}
+		rss     = &metricFamily{name: "virtuakube_vm_memory_rss_bytes", help: "Host memory used by the VM.", typ: "gauge"}
+		mem     = &metricFamily{name: "virtuakube_vm_memory_bytes", help: "Memory configured for the VM.", typ: "gauge"}
+		rdBytes = &metricFamily{name: "virtuakube_vm_disk_read_bytes_total", help: "Bytes read by the VM from each disk.", typ: "counter"}
+		wrBytes = &metricFamily{name: "virtuakube_vm_disk_written_bytes_total", help: "Bytes written by the VM to each disk.", typ: "counter"}
+		rdOps   = &metricFamily{name: "virtuakube_vm_disk_reads_total", help: "Read operations by the VM on each disk.", typ: "counter"}
+		wrOps   = &metricFamily{name: "virtuakube_vm_disk_writes_total", help: "Write operations by the VM on each disk.", typ: "counter"}
+		rxBytes = &metricFamily{name: "virtuakube_vm_network_receive_bytes_total", help: "Bytes received by each network interface of the VM.", typ: "counter"}
+		txBytes = &metricFamily{name: "virtuakube_vm_network_transmit_bytes_total", help: "Bytes sent by each network interface of the VM.", typ: "counter"}
+		boot    = &metricFamily{name: "virtuakube_vm_boot_duration_seconds", help: "How long the VM took to boot.", typ: "gauge"}
+		ready   = &metricFamily{name: "virtuakube_cluster_ready_duration_seconds", help: "How long the cluster took to start and become ready.", typ: "gauge"}
+		saves   = &metricFamily{name: "virtuakube_snapshot_save_duration_seconds", help: "Time spent saving snapshots of the running universe.", typ: "summary"}
+		last    = &metricFamily{name: "virtuakube_snapshot_last_save_duration_seconds", help: "How long the last snapshot of the running universe took to save.", typ: "gauge"}
+	)
+
+	numVMs.add(len(vms))
+	clust.add(numClusters)
+	for _, us := range usages {
+		mem.add(us.memory, "vm", us.name)
+		if us.usageOK {
+			cpu.add(us.cpu, "vm", us.name)
+			rss.add(us.rss, "vm", us.name)
+		}
+		var disks []string
+		for disk := range us.disks {
+			disks = append(disks, disk)
+		}
+		sort.Strings(disks)
+		for _, disk := range disks {
+			st := us.disks[disk]
+			rdBytes.add(st["rd_bytes"], "vm", us.name, "disk", disk)
+			wrBytes.add(st["wr_bytes"], "vm", us.name, "disk", disk)
+			rdOps.add(st["rd_operations"], "vm", us.name, "disk", disk)
+			wrOps.add(st["wr_operations"], "vm", us.name, "disk", disk)
+		}
+		var nics []string
+		for nic := range us.nics {
+			nics = append(nics, nic)
+		}
+		sort.Strings(nics)
+		for _, nic := range nics {
+			rxBytes.add(us.nics[nic][0], "vm", us.name, "interface", nic)
+			txBytes.add(us.nics[nic][1], "vm", us.name, "interface", nic)
+		}
+	}
+
+	u.timings.mu.Lock()
+	for vm, d := range u.timings.boot {
+		boot.add(d, "vm", vm)
+	}
+	for c, d := range u.timings.clusterReady {
+		ready.add(d, "cluster", c)
+	}
+	sort.Strings(boot.samples)
+	sort.Strings(ready.samples)
+	saves.samples = append(saves.samples,
+		fmt.Sprintf("%s_sum %s", saves.name, strconv.FormatFloat(u.timings.saveTotal.Seconds(), 'g', -1, 64)),
+		fmt.Sprintf("%s_count %d", saves.name, u.timings.saves))
+	if u.timings.saves > 0 {
+		last.add(u.timings.lastSave)
+	}
+	u.timings.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range []*metricFamily{numVMs, clust, cpu, rss, mem, rdBytes, wrBytes, rdOps, wrOps, rxBytes, txBytes, boot, ready, saves, last} {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, s := range m.samples {
+			fmt.Fprintln(bw, s)
+		}
+	}
+	bw.Flush()
+}
+
+// usage returns the VM's current resource usage. Parts of it that
+// can't be gathered, e.g. because the VM isn't running, are left
+// out.
+func (v *VM) usage() *vmUsage {
+	ret := &vmUsage{
+		name:   v.cfg.Name,
+		memory: int64(v.cfg.MemoryMiB) << 20,
+	}
+
+	v.mu.Lock()
+	closed, client := v.closed, v.ssh
+	var blockstats string
+	if !closed {
+		blockstats, _ = v.monitorWithLock("info blockstats")
+	}
+	v.mu.Unlock()
+	if closed {
+		return ret
+	}
+
+	var err error
+	ret.rss, ret.cpu, err = procUsage(v.cmd.Process.Pid)
+	ret.usageOK = err == nil
+	ret.disks = parseBlockstats(blockstats)
+
+	if client != nil {
+		// Not v.Run, scrapes would flood the command log.
+		if sess, err := client.NewSession(); err == nil {
+			out, err := sess.Output("cat /proc/net/dev")
+			sess.Close()
+			if err == nil {
+				ret.nics = parseNetDev(out)
+			}
+		}
+	}
+
+	return ret
+}
+
+// parseBlockstats parses the output of the qemu monitor's "info
+// blockstats" into counters by drive, e.g. "root" -> "rd_bytes" ->
+// 1234.
+func parseBlockstats(out string) map[string]map[string]int64 {
+	ret := map[string]map[string]int64{}
+	var drive string
+	for _, line := range strings.Split(out, "\n") {
+		fs := strings.Fields(line)
+		if len(fs) == 0 {
+			continue
+		}
+		if line[0] != ' ' && strings.Contains(line, ":") {
+			// "root: rd_bytes=...", or "root (#block123): ..." on
+			// newer qemus.
+			drive = strings.SplitN(fs[0], ":", 2)[0]
+			ret[drive] = map[string]int64{}
+		}
+		if drive == "" {
+			continue
+		}
+		for _, f := range fs {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			if n, err := strconv.ParseInt(kv[1], 10, 64); err == nil {
+				ret[drive][kv[0]] = n
+			}
+		}
+	}
+	return ret
+}
+
+// parseNetDev parses /proc/net/dev into received and transmitted
+// bytes by interface, leaving out loopback.
+func parseNetDev(out []byte) map[string][2]int64 {
+	ret := map[string][2]int64{}
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		idx := bytes.IndexByte(line, ':')
+		if idx < 0 {
+			continue
+		}
+		name := string(bytes.TrimSpace(line[:idx]))
+		fs := strings.Fields(string(line[idx+1:]))
+		// Receive bytes is the first field, transmit bytes the
+		// ninth.
+		if name == "lo" || len(fs) < 9 {
+			continue
+		}
+		rx, err1 := strconv.ParseInt(fs[0], 10, 64)
+		tx, err2 := strconv.ParseInt(fs[8], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		ret[name] = [2]int64{rx, tx}
+	}
+	return ret
+}
@@ -0,0 +1,348 @@
+package virtuakube
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Proxy is a SOCKS5 proxy into a universe. Connections to universe
+// network addresses are made from a VM on that network. Connections
+// to other addresses, such as Kubernetes ClusterIPs and pod IPs, are
+// made from the controller of the universe's first cluster, or from
+// any VM if the universe has no cluster.
+//
+// Hostnames that are VM names, optionally with a ".universe" suffix,
+// resolve to the VM's address on its first network. Other hostnames
+// are resolved by the VM that makes the connection.
+type Proxy struct {
+	l    net.Listener
+	dial func(host string, port int) (net.Conn, error)
+}
+
+// Proxy starts a SOCKS5 proxy into the universe, listening on addr,
+// e.g. "127.0.0.1:1080". The proxy only supports CONNECT, without
+// authentication, so it should only listen on trusted addresses.
+func (u *Universe) Proxy(addr string) (*Proxy, error) {
+	return serveProxy(addr, func(host string, port int) (net.Conn, error) {
+		u.mu.Lock()
+		routes := u.proxyRoutesWithLock()
+		u.mu.Unlock()
+		vm, host := routes.route(host)
+		if vm == "" {
+			return nil, errors.New("universe has no VMs")
+		}
+		v := u.VM(vm)
+		if v == nil {
+			return nil, fmt.Errorf("VM %q went away", vm)
+		}
+		return v.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	})
+}
+
+// Proxy starts a SOCKS5 proxy into the remote universe, listening on
+// addr, like Universe.Proxy. It dials VMs over their forwarded SSH
+// ports, and doesn't pick up VMs created after it started.
+func (r *RemoteUniverse) Proxy(addr string) (*Proxy, error) {
+	st, err := r.Status()
+	if err != nil {
+		return nil, err
+	}
+	routes := proxyRoutesFromStatus(st)
+	key, err := r.sshKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		clients = map[string]*ssh.Client{}
+	)
+	return serveProxy(addr, func(host string, port int) (net.Conn, error) {
+		vm, host := routes.route(host)
+		if vm == "" {
+			return nil, errors.New("universe has no VMs")
+		}
+		mu.Lock()
+		client := clients[vm]
+		if client == nil {
+			c, err := dialVM(routes.sshPorts[vm], key)
+			if err != nil {
+				mu.Unlock()
+				return nil, fmt.Errorf("connecting to VM %q: %v", vm, err)
+			}
+			client = c
+			clients[vm] = client
+		}
+		mu.Unlock()
+		conn, err := client.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			// The VM may have restarted, reconnect next time.
+			mu.Lock()
+			if clients[vm] == client {
+				delete(clients, vm)
+				client.Close()
+			}
+			mu.Unlock()
+		}
+		return conn, err
+	})
+}
+
+// Addr returns the address the proxy listens on.
+func (p *Proxy) Addr() string {
+	return p.l.Addr().String()
+}
+
+// Close stops the proxy. Established connections stay up.
+func (p *Proxy) Close() error {
+	return p.l.Close()
+}
+
+// proxyRoutes decides which VM makes each proxied connection.
+type proxyRoutes struct {
+	// VM on each network, its address on the first network of each
+	// VM, and the subnets of each network.
+	onNetwork map[string]string
+	vmAddr    map[string]net.IP
+	subnets   map[string][]*net.IPNet
+	// VM for all other addresses.
+	fallback string
+	// Forwarded SSH ports, for remote proxies.
+	sshPorts map[string]int
+}
+
+func newProxyRoutes() *proxyRoutes {
+	return &proxyRoutes{
+		onNetwork: map[string]string{},
+		vmAddr:    map[string]net.IP{},
+		subnets:   map[string][]*net.IPNet{},
+		sshPorts:  map[string]int{},
+	}
+}
+
+func (u *Universe) proxyRoutesWithLock() *proxyRoutes {
+	ret := newProxyRoutes()
+	for name, n := range u.networks {
+		ret.subnets[name] = []*net.IPNet{n.IPv4Subnet(), n.IPv6Subnet()}
+	}
+	var names []string
+	for name := range u.vms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vm := u.vms[name]
+		for i, n := range vm.Networks() {
+			if i == 0 {
+				ret.vmAddr[name] = vm.IPv4(n)
+			}
+			if ret.onNetwork[n] == "" {
+				ret.onNetwork[n] = name
+			}
+		}
+		if ret.fallback == "" {
+			ret.fallback = name
+		}
+	}
+	var clusters []string
+	for name := range u.clusters {
+		clusters = append(clusters, name)
+	}
+	sort.Strings(clusters)
+	if len(clusters) > 0 {
+		ret.fallback = u.clusters[clusters[0]].Controller().Hostname()
+	}
+	return ret
+}
+
+func proxyRoutesFromStatus(st *UniverseStatus) *proxyRoutes {
+	ret := newProxyRoutes()
+	for _, n := range st.Networks {
+		for _, s := range []string{n.IPv4Subnet, n.IPv6Subnet} {
+			if _, subnet, err := net.ParseCIDR(s); err == nil {
+				ret.subnets[n.Name] = append(ret.subnets[n.Name], subnet)
+			}
+		}
+	}
+	// Status lists VMs and clusters sorted by name.
+	for _, vm := range st.VMs {
+		for i, n := range vm.Networks {
+			if i == 0 {
+				ret.vmAddr[vm.Name] = net.ParseIP(vm.IPv4[n])
+			}
+			if ret.onNetwork[n] == "" {
+				ret.onNetwork[n] = vm.Name
+			}
+		}
+		if ret.fallback == "" {
+			ret.fallback = vm.Name
+		}
+		ret.sshPorts[vm.Name] = vm.Ports[22]
+	}
+	if len(st.Clusters) > 0 {
+		ret.fallback = st.Clusters[0].Controller
+	}
+	return ret
+}
+
+// route returns the VM that should connect to host, and the host it
+// should connect to.
+func (r *proxyRoutes) route(host string) (string, string) {
+	name := strings.TrimSuffix(strings.TrimSuffix(host, "."), "."+strings.TrimSuffix(dnsZone, "."))
+	if ip := r.vmAddr[name]; ip != nil {
+		host = ip.String()
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		var nets []string
+		for n := range r.subnets {
+			nets = append(nets, n)
+		}
+		sort.Strings(nets)
+		for _, n := range nets {
+			for _, subnet := range r.subnets[n] {
+				if subnet.Contains(ip) && r.onNetwork[n] != "" {
+					return r.onNetwork[n], host
+				}
+			}
+		}
+	}
+	return r.fallback, host
+}
+
+// SOCKS5 protocol constants, from RFC 1928.
+const (
+	socksVersion       = 5
+	socksNoAuth        = 0
+	socksNoAcceptable  = 0xff
+	socksConnect       = 1
+	socksAddrIPv4      = 1
+	socksAddrDomain    = 3
+	socksAddrIPv6      = 4
+	socksSucceeded     = 0
+	socksRefused       = 5
+	socksCmdNotSupp    = 7
+	socksAddrNotSupp   = 8
+	socksHandshakeTime = 10 * time.Second
+)
+
+func serveProxy(addr string, dial func(host string, port int) (net.Conn, error)) (*Proxy, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening for proxy connections: %v", err)
+	}
+	ret := &Proxy{l: l, dial: dial}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ret.serve(conn)
+		}
+	}()
+	return ret, nil
+}
+
+// serve handles one SOCKS5 client connection.
+func (p *Proxy) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socksHandshakeTime))
+
+	// Greeting: version, then the client's auth methods.
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil || hdr[0] != socksVersion {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil || method != socksNoAuth {
+		return
+	}
+
+	// Request: version, command, reserved, address type.
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil || req[0] != socksVersion {
+		return
+	}
+	var host string
+	switch req[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, 4)
+		if req[3] == socksAddrIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		socksReply(conn, socksAddrNotSupp)
+		return
+	}
+	var port uint16
+	if err := binary.Read(conn, binary.BigEndian, &port); err != nil {
+		return
+	}
+	if req[1] != socksConnect {
+		socksReply(conn, socksCmdNotSupp)
+		return
+	}
+
+	target, err := p.dial(host, int(port))
+	if err != nil {
+		socksReply(conn, socksRefused)
+		return
+	}
+	defer target.Close()
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(target, conn)
+		done <- true
+	}()
+	go func() {
+		io.Copy(conn, target)
+		done <- true
+	}()
+	<-done
+}
+
+// socksReply sends a SOCKS5 reply with the given status. The bound
+// address isn't meaningful for proxied connections, so it's always
+// 0.0.0.0:0.
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,171 @@
+package virtuakube
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// QEMUCapability is an optional QEMU feature that some virtuakube
+// options depend on.
+type QEMUCapability string
+
+const (
+	// CapIOURing is the io_uring disk AIO backend (QEMU 5.0+).
+	CapIOURing QEMUCapability = "io_uring"
+	// CapHostMTU is the host_mtu property of virtio-net devices,
+	// which advertises the link MTU to the guest (QEMU 2.9+).
+	CapHostMTU QEMUCapability = "host_mtu"
+	// CapVirtioFS is the vhost-user-fs device for virtiofs host
+	// directory sharing (QEMU 4.2+).
+	CapVirtioFS QEMUCapability = "virtiofs"
+	// Cap9P is the virtio-9p device for 9p host directory sharing.
+	Cap9P QEMUCapability = "9p"
+	// CapBalloon is the virtio-balloon device, which lets VM memory
+	// be resized while running.
+	CapBalloon QEMUCapability = "balloon"
+)
+
+// QEMUInfo describes the QEMU installation that virtuakube uses to
+// run VMs.
+type QEMUInfo struct {
+	// Path is the resolved path of the qemu-system binary.
+	Path string
+	// Version is the QEMU version, e.g. "3.1.0".
+	Version string
+	Major   int
+	Minor   int
+	Micro   int
+	// MachineTypes lists the machine types that the binary
+	// supports, sorted by name.
+	MachineTypes []string
+	// Capabilities is the set of optional features available.
+	Capabilities map[QEMUCapability]bool
+}
+
+// AtLeast returns true if the QEMU version is at least major.minor.
+func (q *QEMUInfo) AtLeast(major, minor int) bool {
+	if q.Major != major {
+		return q.Major > major
+	}
+	return q.Minor >= minor
+}
+
+// Has returns true if QEMU supports the given capability.
+func (q *QEMUInfo) Has(c QEMUCapability) bool {
+	return q.Capabilities[c]
+}
+
+// HasMachineType returns true if QEMU supports the given machine
+// type.
+func (q *QEMUInfo) HasMachineType(machine string) bool {
+	for _, m := range q.MachineTypes {
+		if m == machine {
+			return true
+		}
+	}
+	return false
+}
+
+// require returns an error explaining why feature is unavailable, if
+// QEMU lacks capability c.
+func (q *QEMUInfo) require(c QEMUCapability, feature string) error {
+	if q.Has(c) {
+		return nil
+	}
+	return fmt.Errorf("%s requires QEMU capability %q, which QEMU %s at %s does not have", feature, c, q.Version, q.Path)
+}
+
+var (
+	qemuMu    sync.Mutex
+	qemuInfos = map[string]*QEMUInfo{}
+
+	qemuVersionRe = regexp.MustCompile(`version (\d+)\.(\d+)(?:\.(\d+))?`)
+)
+
+// DetectQEMU returns information about the QEMU installation that
+// virtuakube will use for amd64 VMs. The result is cached for the
+// life of the process.
+func DetectQEMU() (*QEMUInfo, error) {
+	return DetectQEMUArch(ArchAMD64)
+}
+
+// DetectQEMUArch returns information about the QEMU installation that
+// virtuakube will use for VMs of the given architecture.
+func DetectQEMUArch(arch string) (*QEMUInfo, error) {
+	if err := validateArch(arch); err != nil {
+		return nil, err
+	}
+	qemuMu.Lock()
+	defer qemuMu.Unlock()
+	if info := qemuInfos[normalizeArch(arch)]; info != nil {
+		return info, nil
+	}
+	info, err := detectQEMU(qemuBinary(arch))
+	if err != nil {
+		return nil, err
+	}
+	qemuInfos[normalizeArch(arch)] = info
+	return info, nil
+}
+
+func detectQEMU(binary string) (*QEMUInfo, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &QEMUInfo{
+		Path:         path,
+		Capabilities: map[QEMUCapability]bool{},
+	}
+
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("running %s --version: %v\n%s", path, err, string(out))
+	}
+	m := qemuVersionRe.FindStringSubmatch(string(out))
+	if m == nil {
+		return nil, fmt.Errorf("can't parse QEMU version from %q", strings.TrimSpace(string(out)))
+	}
+	ret.Major, _ = strconv.Atoi(m[1])
+	ret.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		ret.Micro, _ = strconv.Atoi(m[3])
+	}
+	ret.Version = fmt.Sprintf("%d.%d.%d", ret.Major, ret.Minor, ret.Micro)
+
+	out, err = exec.Command(path, "-machine", "help").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("listing QEMU machine types: %v\n%s", err, string(out))
+	}
+	for _, line := range strings.Split(string(out), "\n")[1:] {
+		fs := strings.Fields(line)
+		if len(fs) == 0 {
+			continue
+		}
+		ret.MachineTypes = append(ret.MachineTypes, fs[0])
+	}
+	sort.Strings(ret.MachineTypes)
+
+	devices, err := exec.Command(path, "-machine", "none", "-device", "help").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("listing QEMU devices: %v\n%s", err, string(devices))
+	}
+	netProps, err := exec.Command(path, "-machine", "none", "-device", "virtio-net-pci,help").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("listing virtio-net properties: %v\n%s", err, string(netProps))
+	}
+
+	ret.Capabilities[CapIOURing] = ret.AtLeast(5, 0)
+	ret.Capabilities[CapHostMTU] = strings.Contains(string(netProps), "host_mtu")
+	ret.Capabilities[CapVirtioFS] = strings.Contains(string(devices), `"vhost-user-fs-pci"`)
+	ret.Capabilities[Cap9P] = strings.Contains(string(devices), `"virtio-9p-pci"`)
+	ret.Capabilities[CapBalloon] = strings.Contains(string(devices), `"virtio-balloon-pci"`)
+
+	return ret, nil
+}
@@ -0,0 +1,66 @@
+package virtuakube
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const qemuCommandsFile = "qemu-commands.txt"
+
+// QEMUCommands returns the exact qemu command line of each VM in the
+// universe, keyed by VM name. Relative paths in the command lines are
+// relative to the universe directory.
+//
+// The same command lines are kept up to date in qemu-commands.txt in
+// the universe directory, in a form that can be pasted into a shell
+// to run a VM by hand. Note that VMs resumed from a snapshot start
+// with their CPUs stopped (-S), and must be resumed with "cont" on
+// the qemu monitor.
+func (u *Universe) QEMUCommands() map[string][]string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.qemuCommandsWithLock()
+}
+
+func (u *Universe) qemuCommandsWithLock() map[string][]string {
+	ret := map[string][]string{}
+	for name, vm := range u.vms {
+		ret[name] = append([]string(nil), vm.cmd.Args...)
+	}
+	return ret
+}
+
+// writeQEMUCommandsWithLock rewrites qemu-commands.txt to reflect the
+// universe's current VMs.
+func (u *Universe) writeQEMUCommandsWithLock() error {
+	cmds := u.qemuCommandsWithLock()
+	var names []string
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# qemu command lines for universe %s\n", u.dir)
+	for _, name := range names {
+		var args []string
+		for _, arg := range cmds[name] {
+			args = append(args, shellQuote(arg))
+		}
+		fmt.Fprintf(&b, "\n# VM %s\n(cd %s && %s)\n", name, shellQuote(u.dir), strings.Join(args, " "))
+	}
+
+	return ioutil.WriteFile(filepath.Join(u.dir, qemuCommandsFile), b.Bytes(), 0600)
+}
+
+// shellQuote quotes s for safe use as a single POSIX shell word.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=,.:/@+") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
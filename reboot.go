@@ -0,0 +1,138 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Reboot reboots the VM's guest OS cleanly, by asking systemd in the
+// guest to reboot, and waits for it to come back up, e.g. to test
+// that kubelet and other services recover from a node reboot.
+//
+// The VM's QEMU process keeps running, so its disks, networks and
+// forwarded ports are unchanged. The runtime configuration that
+// virtuakube applies when the VM starts, such as its addresses, link
+// conditions and mounts, is reapplied once the guest is back. If ctx
+// is done first, Reboot returns ctx.Err(), and the guest finishes
+// rebooting without it.
+func (v *VM) Reboot(ctx context.Context) error {
+	return v.restart(ctx, "rebooting", func() error {
+		sess, err := v.ssh.NewSession()
+		if err != nil {
+			return err
+		}
+		// The guest drops the connection as it shuts down, so don't
+		// wait for the command to finish.
+		return sess.Start("systemctl reboot")
+	})
+}
+
+// Reset resets the VM's virtual hardware, as the reset button of a
+// real machine does, without the guest OS shutting down first. Writes
+// that the guest hadn't flushed to disk are lost. Otherwise, Reset is
+// like Reboot.
+func (v *VM) Reset(ctx context.Context) error {
+	return v.restart(ctx, "resetting", func() error {
+		_, err := v.monitorWithLock("system_reset")
+		return err
+	})
+}
+
+// restart restarts the guest with trigger, which is called with v.mu
+// held, then reconfigures it.
+func (v *VM) restart(ctx context.Context, phase string, trigger func() error) error {
+	prog := v.universe.progress(v.cfg.Name, phase, 0)
+	if err := prog.done(v.restartGuest(ctx, trigger)); err != nil {
+		return fmt.Errorf("%s %q: %v", phase, v.cfg.Name, err)
+	}
+	if err := v.configure(); err != nil {
+		return fmt.Errorf("reconfiguring %q after restart: %v", v.cfg.Name, err)
+	}
+	if err := v.universe.restorePodRoutes(v); err != nil {
+		return err
+	}
+	v.universe.emit(EventVMReady, v.cfg.Name, "")
+	return nil
+}
+
+// restartGuest restarts the guest with trigger, and waits until SSH
+// reaches the new boot of the guest.
+func (v *VM) restartGuest(ctx context.Context, trigger func() error) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("VM is closed")
+	}
+	if v.ssh == nil {
+		return errors.New("VM isn't started")
+	}
+	if v.paused {
+		return errors.New("VM is paused")
+	}
+
+	// The boot ID tells the guest's new boot apart from the old one,
+	// whose sshd may still accept connections for a while.
+	oldBoot, err := bootID(v.ssh)
+	if err != nil {
+		return fmt.Errorf("getting boot ID: %v", err)
+	}
+	if err := trigger(); err != nil {
+		return err
+	}
+	v.ssh.Close()
+
+	deadline := time.Now().Add(v.universe.bootTimeout())
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-v.stopped:
+			return errors.New("VM stopped")
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w after %s", ErrBootTimeout, v.universe.bootTimeout())
+		}
+
+		client, err := ssh.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", v.forwardedPortWithLock(22)), vmSSHConfig(v.universe.sshKey))
+		if err != nil {
+			time.Sleep(backoff(attempt, 100*time.Millisecond, 2*time.Second))
+			continue
+		}
+		boot, err := bootID(client)
+		if err != nil || boot == oldBoot {
+			client.Close()
+			time.Sleep(backoff(attempt, 100*time.Millisecond, 2*time.Second))
+			continue
+		}
+
+		v.ssh = client
+		break
+	}
+
+	if err := v.syncClockWithLock(); err != nil {
+		return err
+	}
+	v.universe.emit(EventVMStarted, v.cfg.Name, "")
+	return nil
+}
+
+// bootID returns the kernel's boot ID, which changes each time the
+// guest boots.
+func bootID(client *ssh.Client) (string, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+	out, err := sess.Output("cat /proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,245 @@
+package virtuakube
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Every open universe registers itself in a host-wide directory, so
+// that universes whose processes died without cleaning up (e.g. a CI
+// job that got SIGKILLed, leaving its VMs running) can be found and
+// reaped.
+
+// registryEntry is the registration of an open universe.
+type registryEntry struct {
+	Dir      string
+	PID      int
+	Snapshot string
+	Started  time.Time
+	// Start times of the universe's processes, keyed by PID, to
+	// avoid killing unrelated processes that reused a dead PID.
+	StartTimes map[int]string
+	// PIDs of the universe's VM and network processes.
+	Children []int
+}
+
+// RunningUniverse describes a universe that is open somewhere on the
+// host, or was until its process died.
+type RunningUniverse struct {
+	Dir      string
+	PID      int
+	Snapshot string
+	Started  time.Time
+	// VMs is the number of VMs in the universe, or -1 if the
+	// universe's process isn't answering.
+	VMs int
+	// Alive is false if the process that opened the universe is
+	// gone, leaving Orphans behind.
+	Alive bool
+	// Orphans are the PIDs of still running VM and network
+	// processes of a dead universe.
+	Orphans []int
+}
+
+func registryDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("virtuakube-%d", os.Getuid()))
+}
+
+func registryPath(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(registryDir(), fmt.Sprintf("%x.json", sum[:8]))
+}
+
+// updateRegistry writes u's current registration.
+func (u *Universe) updateRegistry() {
+	ent := registryEntry{
+		Dir:        u.dir,
+		PID:        os.Getpid(),
+		Snapshot:   u.activeSnapshot,
+		Started:    u.startTime,
+		StartTimes: map[int]string{},
+	}
+	if st, err := procStartTime(ent.PID); err == nil {
+		ent.StartTimes[ent.PID] = st
+	}
+
+	u.procsMu.Lock()
+	defer u.procsMu.Unlock()
+	if u.unregistered {
+		return
+	}
+	for proc := range u.procs {
+		ent.Children = append(ent.Children, proc.Pid)
+		if st, err := procStartTime(proc.Pid); err == nil {
+			ent.StartTimes[proc.Pid] = st
+		}
+	}
+
+	// Registration is best effort, it only matters for cleaning up
+	// after crashes.
+	bs, err := json.Marshal(ent)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(registryDir(), 0700); err != nil {
+		return
+	}
+	path := registryPath(u.dir)
+	if err := ioutil.WriteFile(path+".tmp", bs, 0600); err != nil {
+		return
+	}
+	os.Rename(path+".tmp", path)
+}
+
+func (u *Universe) unregister() {
+	u.procsMu.Lock()
+	defer u.procsMu.Unlock()
+	u.unregistered = true
+	os.Remove(registryPath(u.dir))
+}
+
+func readRegistryEntry(path string) (*registryEntry, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret registryEntry
+	if err := json.Unmarshal(bs, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// alive reports whether pid is still the process that e recorded.
+func (e *registryEntry) alive(pid int) bool {
+	st, err := procStartTime(pid)
+	if err != nil {
+		return false
+	}
+	if want, ok := e.StartTimes[pid]; ok && want != st {
+		return false
+	}
+	return true
+}
+
+func (e *registryEntry) orphans() []int {
+	var ret []int
+	for _, pid := range e.Children {
+		if e.alive(pid) {
+			ret = append(ret, pid)
+		}
+	}
+	return ret
+}
+
+// ListRunning returns the universes that are open on the host, along
+// with universes whose processes died but left VMs or networks
+// running. Registrations of universes that are completely gone are
+// cleaned up.
+func ListRunning() ([]RunningUniverse, error) {
+	paths, err := filepath.Glob(filepath.Join(registryDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []RunningUniverse
+	for _, path := range paths {
+		ent, err := readRegistryEntry(path)
+		if err != nil {
+			continue
+		}
+		r := RunningUniverse{
+			Dir:      ent.Dir,
+			PID:      ent.PID,
+			Snapshot: ent.Snapshot,
+			Started:  ent.Started,
+			VMs:      -1,
+			Alive:    ent.alive(ent.PID),
+		}
+		if r.Alive {
+			if remote, err := Attach(ent.Dir); err == nil {
+				if st, err := remote.Status(); err == nil {
+					r.VMs = len(st.VMs)
+				}
+			}
+		} else {
+			r.Orphans = ent.orphans()
+			if len(r.Orphans) == 0 {
+				os.Remove(path)
+				continue
+			}
+		}
+		ret = append(ret, r)
+	}
+
+	return ret, nil
+}
+
+// KillRunning forcibly shuts down the universe in dir, which must
+// appear in ListRunning. If its process is answering, it's asked to
+// kill the universe's VMs and networks. Otherwise, the process and
+// its VMs and networks are killed with SIGKILL. Changes since the last
+// save are lost.
+func KillRunning(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	path := registryPath(dir)
+	ent, err := readRegistryEntry(path)
+	if os.IsNotExist(err) {
+		return ErrNotRunning
+	} else if err != nil {
+		return err
+	}
+
+	if remote, err := Attach(dir); err == nil {
+		if err := remote.Kill(); err == nil {
+			return nil
+		}
+	}
+
+	for _, pid := range append([]int{ent.PID}, ent.Children...) {
+		if pid == os.Getpid() || !ent.alive(pid) {
+			continue
+		}
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("killing process %d: %v", pid, err)
+		}
+	}
+
+	os.Remove(filepath.Join(dir, controlSocket))
+	os.Remove(filepath.Join(dir, runningFile))
+	os.Remove(path)
+	return nil
+}
+
+// procStartTime returns the start time of pid, in clock ticks since
+// boot, as an opaque string.
+func procStartTime(pid int) (string, error) {
+	bs, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return "", err
+	}
+	// The command name can contain spaces and parens, so skip past
+	// the last paren before splitting fields.
+	s := string(bs)
+	idx := strings.LastIndexByte(s, ')')
+	if idx < 0 {
+		return "", fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fs := strings.Fields(s[idx+1:])
+	// starttime is field 22 of stat, and s[idx+1:] starts at field 3.
+	if len(fs) < 20 {
+		return "", fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return fs[19], nil
+}
@@ -0,0 +1,85 @@
+package virtuakube
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Run runs fn against the universe, then tears the universe down. If
+// fn fails and UniverseConfig.SaveOnError is set, the universe is
+// saved to that snapshot for post-mortem inspection. Otherwise, it is
+// closed and all changes are discarded.
+//
+// If ctx is canceled before fn returns, the universe is closed
+// immediately, which makes fn's remaining operations on it fail, and
+// Run returns ctx.Err(). Otherwise, Run returns fn's error.
+func (u *Universe) Run(ctx context.Context, fn func(*Universe) error) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- fn(u)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		u.Close()
+		return ctx.Err()
+	}
+
+	if err != nil && u.runtimecfg.SaveOnError != "" {
+		if serr := u.Save(ctx, u.runtimecfg.SaveOnError); serr != nil {
+			return serr
+		}
+		return err
+	}
+	if cerr := u.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Env returns environment variables that describe the universe, for
+// running commands against it:
+//
+//	VKUBE_UNIVERSE: the universe directory.
+//	KUBECONFIG: the kubeconfigs of all clusters.
+//	VKUBE_SSH_PORT_<VM>: the localhost SSH port of each VM, with the
+//	VM name uppercased and non-alphanumerics replaced by underscores.
+func (u *Universe) Env() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	ret := []string{"VKUBE_UNIVERSE=" + u.dir}
+
+	var kubeconfigs []string
+	for _, cluster := range u.clusters {
+		kubeconfigs = append(kubeconfigs, cluster.Kubeconfig())
+	}
+	if len(kubeconfigs) > 0 {
+		sort.Strings(kubeconfigs)
+		ret = append(ret, "KUBECONFIG="+strings.Join(kubeconfigs, ":"))
+	}
+
+	var vms []string
+	for _, vm := range u.vms {
+		vms = append(vms, "VKUBE_SSH_PORT_"+envName(vm.Hostname())+"="+strconv.Itoa(vm.ForwardedPort(22)))
+	}
+	sort.Strings(vms)
+
+	return append(ret, vms...)
+}
+
+// envName turns s into something usable in an environment variable
+// name.
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, s)
+}
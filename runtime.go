@@ -0,0 +1,240 @@
+package virtuakube
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Container runtimes, for ClusterConfig.Runtime and
+// ImageConfig.Runtime.
+const (
+	// RuntimeDocker runs pods in Docker, through the kubelet's
+	// dockershim. It's what CustomizeInstallK8s installs.
+	RuntimeDocker = "docker"
+	// RuntimeContainerd runs pods in containerd, through its CRI
+	// plugin.
+	RuntimeContainerd = "containerd"
+	// RuntimeCRIO runs pods in CRI-O. CRI-O releases track
+	// Kubernetes minor versions, so nodes get the CRI-O matching
+	// their Kubernetes, which must be 1.17 or later.
+	RuntimeCRIO = "cri-o"
+)
+
+// minCRIOMinor is the oldest Kubernetes minor version that has CRI-O
+// packages for the images' Debian.
+const minCRIOMinor = 17
+
+// runtimeMarker is the file in which images and nodes record the
+// container runtime virtuakube installed. Images without it run
+// Docker.
+const runtimeMarker = "/etc/virtuakube-runtime"
+
+// validateRuntime checks that runtime is a known container runtime.
+func validateRuntime(runtime string) error {
+	switch runtime {
+	case "", RuntimeDocker, RuntimeContainerd, RuntimeCRIO:
+		return nil
+	default:
+		return fmt.Errorf("unknown container runtime %q", runtime)
+	}
+}
+
+// validateRuntime checks that clusters can run cfg's container
+// runtime.
+func (cfg *ClusterConfig) validateRuntime() error {
+	if err := validateRuntime(cfg.Runtime); err != nil {
+		return err
+	}
+	if cfg.ContainerdConfigSnippet != "" && cfg.Runtime != RuntimeContainerd {
+		return fmt.Errorf("ContainerdConfigSnippet requires the %s runtime", RuntimeContainerd)
+	}
+	if cfg.Runtime != RuntimeCRIO {
+		return nil
+	}
+	version := cfg.KubernetesVersion
+	if version == "" {
+		version = defaultKubernetesVersion
+	}
+	minor, err := parseKubeVersion(version)
+	if err != nil {
+		return err
+	}
+	if minor < minCRIOMinor {
+		return fmt.Errorf("the CRI-O runtime requires Kubernetes 1.%d or later", minCRIOMinor)
+	}
+	return nil
+}
+
+// Runtime returns the container runtime of the cluster's nodes.
+func (c *Cluster) Runtime() string {
+	// Clusters saved before the runtime was configurable all ran
+	// Docker.
+	if c.cfg.Runtime == "" {
+		return RuntimeDocker
+	}
+	return c.cfg.Runtime
+}
+
+// criSocket returns the CRI socket of runtime.
+func criSocket(runtime string) string {
+	switch runtime {
+	case RuntimeContainerd:
+		return "/run/containerd/containerd.sock"
+	case RuntimeCRIO:
+		return "/var/run/crio/crio.sock"
+	default:
+		return "/var/run/dockershim.sock"
+	}
+}
+
+// runtimeService returns the systemd unit of runtime.
+func runtimeService(runtime string) string {
+	if runtime == RuntimeCRIO {
+		return "crio"
+	}
+	return runtime
+}
+
+// kubeadmCRISocket returns the CRI socket setting for kubeadm's
+// nodeRegistration. Images have Docker installed even when they run
+// another runtime, so kubeadm can't be left to autodetect it.
+func (c *Cluster) kubeadmCRISocket() string {
+	if c.Runtime() == RuntimeDocker {
+		return ""
+	}
+	return "\n  criSocket: " + criSocket(c.Runtime())
+}
+
+// runtimeID returns the contents of runtimeMarker for runtime, on
+// nodes running Kubernetes version.
+func runtimeID(runtime, version string) (string, error) {
+	if runtime != RuntimeCRIO {
+		return runtime, nil
+	}
+	minor, err := parseKubeVersion(version)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s 1.%d", runtime, minor), nil
+}
+
+// guestRuntime returns the runtimeMarker of vm.
+func guestRuntime(vm *VM) string {
+	out, err := vm.Run("cat " + runtimeMarker + " 2>/dev/null || true")
+	if err != nil || strings.TrimSpace(string(out)) == "" {
+		return RuntimeDocker
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// installRuntime installs and starts the given container runtime on
+// vm, for Kubernetes version, unless it already has it, and points it
+// at the VM's registry mirrors. Docker stays installed, but stops
+// running.
+func installRuntime(vm *VM, runtime, version string) error {
+	want, err := runtimeID(runtime, version)
+	if err != nil {
+		return err
+	}
+	if guestRuntime(vm) == want {
+		return vm.installRegistryMirrors()
+	}
+
+	// Both containerd and CRI-O need the bridge netfilter and
+	// forwarding setup that Docker does for itself. setup runs
+	// before pkgs are installed, cmds after.
+	setup := []string{
+		"modprobe overlay",
+		"modprobe br_netfilter",
+		"echo overlay >>/etc/modules",
+		"printf 'net.bridge.bridge-nf-call-iptables = 1\\nnet.bridge.bridge-nf-call-ip6tables = 1\\nnet.ipv4.ip_forward = 1\\n' >/etc/sysctl.d/99-kubernetes-cri.conf",
+		"sysctl --system",
+	}
+	var pkgs, cmds []string
+	switch runtime {
+	case RuntimeDocker:
+		setup = nil
+		cmds = []string{"systemctl enable --now docker"}
+	case RuntimeContainerd:
+		// containerd.io comes from Docker's repository, which
+		// CustomizeInstallK8s sets up.
+		pkgs = []string{"containerd.io"}
+		cmds = []string{
+			"mkdir -p /etc/containerd",
+			"containerd config default >" + containerdConfig,
+			"systemctl disable --now docker",
+			"systemctl enable containerd",
+			"systemctl restart containerd",
+		}
+	case RuntimeCRIO:
+		stream := strings.TrimPrefix(want, RuntimeCRIO+" ")
+		repo := "https://download.opensuse.org/repositories/devel:/kubic:/libcontainers:/stable"
+		setup = append(setup,
+			fmt.Sprintf("echo 'deb %s/Debian_9/ /' >/etc/apt/sources.list.d/libcontainers.list", repo),
+			fmt.Sprintf("echo 'deb %s:/cri-o:/%s/Debian_9/ /' >/etc/apt/sources.list.d/cri-o.list", repo, stream),
+			fmt.Sprintf("curl -fsSL --retry 10 %s/Debian_9/Release.key | apt-key add -", repo),
+			fmt.Sprintf("curl -fsSL --retry 10 %s:/cri-o:/%s/Debian_9/Release.key | apt-key add -", repo, stream),
+		)
+		pkgs = []string{"cri-o", "cri-o-runc", "podman"}
+		cmds = []string{
+			// The kubelet's default cgroup driver is cgroupfs.
+			`sed -i 's/^#\? *cgroup_manager = .*/cgroup_manager = "cgroupfs"/' /etc/crio/crio.conf`,
+			"systemctl disable --now docker",
+			"systemctl enable crio",
+			"systemctl restart crio",
+		}
+	default:
+		return fmt.Errorf("unknown container runtime %q", runtime)
+	}
+	cmds = append(cmds, fmt.Sprintf("echo %s >%s", shellQuote(want), runtimeMarker))
+	err = vm.RunMultiple(setup...)
+	if err == nil && len(pkgs) > 0 {
+		err = vm.aptGet("update", "install --no-install-recommends "+strings.Join(pkgs, " "))
+	}
+	if err == nil {
+		err = vm.RunMultiple(cmds...)
+	}
+	if err != nil {
+		return fmt.Errorf("installing container runtime %s: %v", runtime, err)
+	}
+	return vm.installRegistryMirrors()
+}
+
+// loadImagesCommand returns the command that loads a `docker save`
+// archive from stdin into runtime.
+func loadImagesCommand(runtime string) string {
+	switch runtime {
+	case RuntimeContainerd:
+		// ctr can't import from a pipe.
+		return "cat >/tmp/images.tar && ctr -n k8s.io images import /tmp/images.tar && rm /tmp/images.tar"
+	case RuntimeCRIO:
+		// podman and CRI-O share container storage.
+		return "podman load"
+	default:
+		return "docker load"
+	}
+}
+
+// guestRuntimeName returns the container runtime that vm runs, with
+// no version.
+func guestRuntimeName(vm *VM) string {
+	return strings.Fields(guestRuntime(vm))[0]
+}
+
+// pullImageCommand returns the command that pulls image into
+// runtime, which needn't have a kubelet running.
+func pullImageCommand(runtime, image string) string {
+	if runtime == RuntimeDocker {
+		return "docker pull " + image
+	}
+	return fmt.Sprintf("crictl --runtime-endpoint unix://%s pull %s", criSocket(runtime), image)
+}
+
+// imagePresentCommand returns the command that succeeds if runtime
+// has image.
+func imagePresentCommand(runtime, image string) string {
+	if runtime == RuntimeDocker {
+		return "docker image inspect " + image
+	}
+	return fmt.Sprintf("crictl --runtime-endpoint unix://%s inspecti %s", criSocket(runtime), image)
+}
@@ -0,0 +1,289 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// nodeTemplate returns the persistent template for worker nodes
+// created from cfg.
+func nodeTemplate(cfg *VMConfig) *config.NodeTemplate {
+	ret := &config.NodeTemplate{
+		Image:          cfg.Image,
+		MemoryMiB:      cfg.MemoryMiB,
+		CPUs:           cfg.CPUs,
+		CPUModel:       cfg.CPUModel,
+		Networks:       cfg.Networks,
+		DiskLimits:     cfg.Disk.toConfig(),
+		MachineType:    cfg.MachineType,
+		Firmware:       cfg.Firmware,
+		SecureBoot:     cfg.SecureBoot,
+		TPM:            cfg.TPM,
+		NestedVirt:     cfg.NestedVirt,
+		HotplugNICs:    cfg.HotplugNICs,
+		AuthorizedKeys: cfg.AuthorizedKeys,
+	}
+	for i := range cfg.Disks {
+		ret.Disks = append(ret.Disks, cfg.Disks[i].toConfig())
+	}
+	for i := range cfg.Mounts {
+		m, err := cfg.Mounts[i].toConfig()
+		if err == nil {
+			ret.Mounts = append(ret.Mounts, m)
+		}
+	}
+	for fwd := range cfg.PortForwards {
+		ret.PortForwards = append(ret.PortForwards, fwd)
+	}
+	sort.Ints(ret.PortForwards)
+	return ret
+}
+
+// nodeConfigWithLock returns the VMConfig for a new worker node,
+// filling in cfg's unset fields other than Name from the cluster's
+// node template.
+func (c *Cluster) nodeConfigWithLock(cfg *VMConfig) (*VMConfig, error) {
+	ret := &VMConfig{}
+	if cfg != nil {
+		*ret = *cfg
+	}
+
+	tmpl := c.cfg.NodeTemplate
+	if tmpl == nil {
+		// Clusters saved before nodes could be added didn't record
+		// their template, but all their VMs were cut from it.
+		ctrl := c.controller.cfg
+		tmpl = &config.NodeTemplate{
+			MemoryMiB:      ctrl.MemoryMiB,
+			Networks:       ctrl.Networks,
+			DiskLimits:     ctrl.DiskLimits,
+			MachineType:    ctrl.MachineType,
+			Firmware:       ctrl.Firmware,
+			SecureBoot:     ctrl.SecureBoot,
+			TPM:            ctrl.TPM,
+			NestedVirt:     ctrl.NestedVirt,
+			HotplugNICs:    ctrl.HotplugNICs,
+			AuthorizedKeys: ctrl.AuthorizedKeys,
+		}
+	}
+
+	if ret.Image == "" {
+		if tmpl.Image == "" {
+			return nil, errors.New("cluster doesn't record its node image, VMConfig must specify one")
+		}
+		ret.Image = tmpl.Image
+	}
+	if ret.MemoryMiB == 0 {
+		ret.MemoryMiB = tmpl.MemoryMiB
+	}
+	if ret.CPUs == 0 {
+		ret.CPUs = tmpl.CPUs
+	}
+	if ret.CPUModel == "" {
+		ret.CPUModel = tmpl.CPUModel
+	}
+	if len(ret.Networks) == 0 {
+		ret.Networks = tmpl.Networks
+	} else if ret.Networks[0] != tmpl.Networks[0] {
+		return nil, fmt.Errorf("node's first network must be the cluster network %q", tmpl.Networks[0])
+	}
+	if ret.PortForwards == nil {
+		ret.PortForwards = map[int]bool{}
+		for _, fwd := range tmpl.PortForwards {
+			ret.PortForwards[fwd] = true
+		}
+	}
+	if ret.Disk == (DiskSpec{}) {
+		ret.Disk = DiskSpec{
+			IOPSLimit:      tmpl.DiskLimits.IOPS,
+			BandwidthLimit: tmpl.DiskLimits.Bandwidth,
+		}
+	}
+	if ret.MachineType == "" {
+		ret.MachineType = tmpl.MachineType
+	}
+	if ret.Firmware == "" {
+		ret.Firmware, ret.SecureBoot = tmpl.Firmware, tmpl.SecureBoot
+	}
+	ret.TPM = ret.TPM || tmpl.TPM
+	ret.NestedVirt = ret.NestedVirt || tmpl.NestedVirt
+	if ret.HotplugNICs == 0 {
+		ret.HotplugNICs = tmpl.HotplugNICs
+	}
+	if ret.AuthorizedKeys == nil {
+		ret.AuthorizedKeys = tmpl.AuthorizedKeys
+	}
+	if ret.Mounts == nil {
+		for _, m := range tmpl.Mounts {
+			ret.Mounts = append(ret.Mounts, MountConfig{
+				HostPath:  m.HostPath,
+				GuestPath: m.GuestPath,
+				ReadOnly:  m.ReadOnly,
+			})
+		}
+	}
+	if ret.Disks == nil {
+		for _, d := range tmpl.Disks {
+			ret.Disks = append(ret.Disks, diskConfigFromConfig(d))
+		}
+	}
+
+	return ret, nil
+}
+
+// AddNode creates a new worker node, boots it and joins it to the
+// running cluster. Unset fields of cfg, or all of them if cfg is nil,
+// come from the VMConfig template the cluster was created with. If
+// the cluster has a CNI, AddNode waits for the node to become Ready.
+// If the cluster's pod network is connected and routing to the new
+// node's pods fails, AddNode returns the joined node and the error.
+func (c *Cluster) AddNode(ctx context.Context, cfg *VMConfig) (*VM, error) {
+	// Booting and joining takes a while, so c.mu is only held to read
+	// and update the cluster's records. The universe lock must not be
+	// taken while holding c.mu, Status takes them in the other order.
+	c.mu.Lock()
+	if !c.started {
+		c.mu.Unlock()
+		return nil, errors.New("cluster isn't started")
+	}
+	nodeCfg, err := c.nodeConfigWithLock(cfg)
+	numNodes := len(c.nodes)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if nodeCfg.Name == "" {
+		for i := numNodes + 1; ; i++ {
+			name := fmt.Sprintf("%s-node%d", c.Name(), i)
+			if c.universe.VM(name) == nil {
+				nodeCfg.Name = name
+				break
+			}
+		}
+	}
+	node, err := c.universe.NewVM(ctx, nodeCfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating node %q: %v", nodeCfg.Name, err)
+	}
+
+	prog := c.universe.progress(c.Name(), "adding node "+node.Hostname(), 0)
+	if err := prog.done(c.joinNewNode(ctx, node)); err != nil {
+		c.client.CoreV1().Nodes().Delete(node.Hostname(), &metav1.DeleteOptions{})
+		if derr := c.universe.destroyVM(node.Hostname()); derr != nil {
+			return nil, fmt.Errorf("%v (and destroying the node's VM failed: %v)", err, derr)
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.nodes = append(c.nodes, node)
+	c.cfg.Nodes = append(c.cfg.Nodes, node.Hostname())
+	c.cfg.NumNodes = len(c.nodes)
+	vms := c.podVMsWithLock()
+	c.mu.Unlock()
+
+	if err := c.reconnectPodNetwork(ctx, vms); err != nil {
+		return node, err
+	}
+	return node, nil
+}
+
+// joinNewNode starts node, joins it to the cluster and waits for it
+// to register.
+func (c *Cluster) joinNewNode(ctx context.Context, node *VM) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.startNode(ctx, node); err != nil {
+		return fmt.Errorf("joining node %q: %v", node.Hostname(), err)
+	}
+
+	return c.WaitFor(ctx, func() (bool, error) {
+		n, err := c.client.CoreV1().Nodes().Get(node.Hostname(), metav1.GetOptions{})
+		if err != nil {
+			// Not registered yet.
+			return false, nil
+		}
+		// Without a CNI, nodes stay NotReady until the user applies
+		// one.
+		return c.cfg.CNI == "" || nodeReady(*n), nil
+	})
+}
+
+// RemoveNode drains the named worker node, deletes its Node object,
+// and destroys its VM. Control plane nodes can't be removed.
+func (c *Cluster) RemoveNode(name string) error {
+	c.mu.Lock()
+	err := c.checkWorkerWithLock(name)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	prog := c.universe.progress(c.Name(), "removing node "+name, 0)
+	if err := c.drain(name); err != nil {
+		return prog.done(err)
+	}
+	if err := c.client.CoreV1().Nodes().Delete(name, &metav1.DeleteOptions{}); err != nil {
+		return prog.done(fmt.Errorf("deleting Node %q: %v", name, err))
+	}
+
+	c.mu.Lock()
+	c.forgetNodeWithLock(name)
+	vms := c.podVMsWithLock()
+	c.mu.Unlock()
+	if err := c.reconnectPodNetwork(context.Background(), vms); err != nil {
+		return prog.done(err)
+	}
+	return prog.done(c.universe.destroyVM(name))
+}
+
+// drain evicts all pods but DaemonSets' from the named node, and
+// cordons it.
+func (c *Cluster) drain(name string) error {
+	if _, err := c.controller.Run("KUBECONFIG=/etc/kubernetes/admin.conf kubectl drain " + name + " --ignore-daemonsets --delete-local-data --force --timeout=2m"); err != nil {
+		return fmt.Errorf("draining node %q: %v", name, err)
+	}
+	return nil
+}
+
+// checkWorkerWithLock returns an error if name isn't one of the
+// cluster's worker nodes.
+func (c *Cluster) checkWorkerWithLock(name string) error {
+	if name == c.controller.Hostname() || name == c.cfg.LoadBalancer {
+		return fmt.Errorf("%q is not a worker node", name)
+	}
+	for _, cp := range c.cfg.ControlPlanes {
+		if name == cp {
+			return fmt.Errorf("%q is not a worker node", name)
+		}
+	}
+	for _, node := range c.nodes {
+		if node.Hostname() == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("cluster %q doesn't have a node named %q", c.Name(), name)
+}
+
+// forgetNodeWithLock removes the named worker node from the
+// cluster's records, without touching its VM.
+func (c *Cluster) forgetNodeWithLock(name string) {
+	var nodes []*VM
+	c.cfg.Nodes = nil
+	for _, node := range c.nodes {
+		if node.Hostname() != name {
+			nodes = append(nodes, node)
+			c.cfg.Nodes = append(c.cfg.Nodes, node.Hostname())
+		}
+	}
+	c.nodes = nodes
+	c.cfg.NumNodes = len(c.nodes)
+}
@@ -0,0 +1,177 @@
+package virtuakube
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// recordInterval is how often RecordScreen captures the screen.
+const recordInterval = time.Second
+
+// Screenshot captures the VM's screen, as its emulated graphics card
+// shows it. It works whatever the universe's display mode, including
+// DisplayNone, e.g. to see how far a VM without SSH got in booting.
+func (v *VM) Screenshot() (image.Image, error) {
+	f, err := ioutil.TempFile(v.universe.tmpdir, "screen-"+v.cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	v.mu.Lock()
+	if v.closed {
+		v.mu.Unlock()
+		return nil, errors.New("VM is closed")
+	}
+	_, err = v.monitorWithLock("screendump " + f.Name())
+	v.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("capturing screen of %q: %v", v.cfg.Name, err)
+	}
+
+	bs, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodePPM(bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("decoding screenshot of %q: %v", v.cfg.Name, err)
+	}
+	return img, nil
+}
+
+// RecordScreen records the VM's screen to an animated GIF at path,
+// one frame a second, until ctx is done. Frames that don't change
+// the screen aren't stored, so recording a mostly static screen, such
+// as a boot console, is cheap. RecordScreen returns an error if
+// capturing fails before ctx is done, e.g. because the VM closed, and
+// writes the frames captured so far either way.
+func (v *VM) RecordScreen(ctx context.Context, path string) error {
+	anim := &gif.GIF{}
+	var (
+		last   image.Image
+		lastAt time.Time
+		capErr error
+	)
+	t := time.NewTicker(recordInterval)
+	defer t.Stop()
+record:
+	for {
+		img, err := v.Screenshot()
+		if err != nil {
+			capErr = err
+			break
+		}
+		now := time.Now()
+		if last == nil || !sameImage(img, last) {
+			if last != nil {
+				anim.Delay[len(anim.Delay)-1] = gifDelay(now.Sub(lastAt))
+			}
+			anim.Image = append(anim.Image, paletted(img))
+			anim.Delay = append(anim.Delay, 0)
+			last, lastAt = img, now
+		}
+
+		select {
+		case <-ctx.Done():
+			break record
+		case <-t.C:
+		}
+	}
+	if len(anim.Image) == 0 {
+		return capErr
+	}
+	anim.Delay[len(anim.Delay)-1] = gifDelay(time.Since(lastAt))
+	for _, img := range anim.Image {
+		b := img.Bounds()
+		if b.Dx() > anim.Config.Width {
+			anim.Config.Width = b.Dx()
+		}
+		if b.Dy() > anim.Config.Height {
+			anim.Config.Height = b.Dy()
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, anim); err != nil {
+		f.Close()
+		return fmt.Errorf("writing recording of %q: %v", v.cfg.Name, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return capErr
+}
+
+// gifDelay returns d in the hundredths of a second of GIF frame
+// delays.
+func gifDelay(d time.Duration) int {
+	return int(d / (10 * time.Millisecond))
+}
+
+// paletted returns img in the web-safe palette, for GIF encoding.
+func paletted(img image.Image) *image.Paletted {
+	ret := image.NewPaletted(img.Bounds(), palette.WebSafe)
+	draw.Draw(ret, ret.Rect, img, img.Bounds().Min, draw.Src)
+	return ret
+}
+
+// sameImage reports whether a and b have the same pixels.
+func sameImage(a, b image.Image) bool {
+	ra, ok1 := a.(*image.RGBA)
+	rb, ok2 := b.(*image.RGBA)
+	if !ok1 || !ok2 {
+		return false
+	}
+	return ra.Rect == rb.Rect && bytes.Equal(ra.Pix, rb.Pix)
+}
+
+// decodePPM decodes the binary PPM images that QEMU's screendump
+// writes.
+func decodePPM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	var magic string
+	var w, h, max int
+	if _, err := fmt.Fscan(br, &magic, &w, &h, &max); err != nil {
+		return nil, fmt.Errorf("reading PPM header: %v", err)
+	}
+	if magic != "P6" || w <= 0 || h <= 0 || max != 255 {
+		return nil, fmt.Errorf("unsupported PPM image %s %dx%d with maximum %d", magic, w, h, max)
+	}
+	// A single whitespace byte separates the header from the pixels.
+	if _, err := br.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	px := make([]byte, 3*w)
+	for y := 0; y < h; y++ {
+		if _, err := io.ReadFull(br, px); err != nil {
+			return nil, fmt.Errorf("reading PPM pixels: %v", err)
+		}
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{px[3*x], px[3*x+1], px[3*x+2], 255})
+		}
+	}
+	return img, nil
+}
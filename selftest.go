@@ -0,0 +1,355 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// selfTestImage is used for the self-test's server and client
+	// pods. It's preloaded on cluster images (it's the registry
+	// addon), and being Alpine based it has wget and nslookup.
+	selfTestImage = "registry:2"
+	// selfTestPullImage is pulled fresh from Docker Hub to check
+	// that nodes can pull images.
+	selfTestPullImage = "busybox:latest"
+	// selfTestTimeout bounds each individual check.
+	selfTestTimeout = 2 * time.Minute
+)
+
+// Self-test check names, in the order SelfTest runs them.
+const (
+	SelfTestSchedule     = "pod scheduling"
+	SelfTestPodToPod     = "pod to pod"
+	SelfTestPodToService = "pod to service"
+	SelfTestDNS          = "service DNS"
+	SelfTestImagePull    = "image pull"
+)
+
+// SelfTestReport is the outcome of Cluster.SelfTest.
+type SelfTestReport struct {
+	Checks []SelfTestCheck
+}
+
+// SelfTestCheck is the outcome of a single self-test check.
+type SelfTestCheck struct {
+	Name   string
+	Passed bool
+	// Error describes why the check failed.
+	Error    string
+	Duration time.Duration
+}
+
+// Passed returns true if all checks in the report passed.
+func (r *SelfTestReport) Passed() bool {
+	for _, check := range r.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the checks that failed.
+func (r *SelfTestReport) Failed() []SelfTestCheck {
+	var ret []SelfTestCheck
+	for _, check := range r.Checks {
+		if !check.Passed {
+			ret = append(ret, check)
+		}
+	}
+	return ret
+}
+
+// SelfTest runs a quick smoke test of the cluster: it schedules
+// pods and waits for them to run, checks pod-to-pod and
+// pod-to-service connectivity, resolves a service name through the
+// cluster DNS, and pulls an image from Docker Hub (which requires
+// internet access from the nodes).
+//
+// The test runs in a temporary namespace, which is deleted
+// afterwards. Failed checks are reported in the returned report,
+// the error is only for failures to run the test at all.
+func (c *Cluster) SelfTest(ctx context.Context) (*SelfTestReport, error) {
+	c.mu.Lock()
+	started, client := c.started, c.client
+	c.mu.Unlock()
+	if !started {
+		return nil, errors.New("cluster not started yet")
+	}
+
+	ns, err := client.CoreV1().Namespaces().Create(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "virtuakube-selftest-"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating self-test namespace: %v", err)
+	}
+	defer client.CoreV1().Namespaces().Delete(ns.Name, &metav1.DeleteOptions{})
+
+	t := &selfTest{
+		cluster: c,
+		ns:      ns.Name,
+		report:  &SelfTestReport{},
+	}
+
+	if err := t.setup(); err != nil {
+		return nil, err
+	}
+
+	t.check(ctx, SelfTestSchedule, t.schedule)
+	t.check(ctx, SelfTestPodToPod, func(ctx context.Context) error {
+		return t.fetch(ctx, fmt.Sprintf("http://%s:5000/v2/", t.server.Status.PodIP))
+	})
+	t.check(ctx, SelfTestPodToService, func(ctx context.Context) error {
+		return t.fetch(ctx, fmt.Sprintf("http://%s/v2/", t.svc.Spec.ClusterIP))
+	})
+	t.check(ctx, SelfTestDNS, t.dns)
+	t.check(ctx, SelfTestImagePull, t.pull)
+
+	if ctx.Err() != nil {
+		return t.report, ctx.Err()
+	}
+	return t.report, nil
+}
+
+// selfTest is the state of a running Cluster.SelfTest.
+type selfTest struct {
+	cluster *Cluster
+	ns      string
+	report  *SelfTestReport
+
+	// Set up front, server and client are updated once running.
+	server *corev1.Pod
+	client *corev1.Pod
+	svc    *corev1.Service
+}
+
+// check runs fn as the check called name, and records the result.
+func (t *selfTest) check(ctx context.Context, name string, fn func(context.Context) error) {
+	res := SelfTestCheck{Name: name}
+	start := time.Now()
+
+	var err error
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if name != SelfTestSchedule && name != SelfTestImagePull && !t.podsRunning() {
+		err = errors.New("skipped, test pods aren't running")
+	} else {
+		checkCtx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		err = fn(checkCtx)
+		cancel()
+	}
+
+	res.Duration = time.Since(start)
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Passed = true
+	}
+	t.report.Checks = append(t.report.Checks, res)
+}
+
+func (t *selfTest) podsRunning() bool {
+	return t.server.Status.Phase == corev1.PodRunning && t.client.Status.Phase == corev1.PodRunning
+}
+
+// setup creates the server and client pods, and a service in front
+// of the server.
+func (t *selfTest) setup() error {
+	client := t.cluster.KubernetesClient()
+	labels := map[string]string{"app": "selftest-server"}
+
+	server, err := client.CoreV1().Pods(t.ns).Create(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "server", Labels: labels},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:            "registry",
+				Image:           selfTestImage,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Ports:           []corev1.ContainerPort{{ContainerPort: 5000}},
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating self-test server pod: %v", err)
+	}
+	t.server = server
+
+	svc, err := client.CoreV1().Services(t.ns).Create(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "server"},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{{
+				Port:       80,
+				TargetPort: intstr.FromInt(5000),
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating self-test service: %v", err)
+	}
+	t.svc = svc
+
+	// Prefer putting the client on a different node from the
+	// server, so that pod-to-pod traffic crosses the cluster
+	// network.
+	clientPod, err := client.CoreV1().Pods(t.ns).Create(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "client"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:            "client",
+				Image:           selfTestImage,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Command:         []string{"sleep", "3600"},
+			}},
+			Affinity: &corev1.Affinity{
+				PodAntiAffinity: &corev1.PodAntiAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+						Weight: 100,
+						PodAffinityTerm: corev1.PodAffinityTerm{
+							LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+							TopologyKey:   "kubernetes.io/hostname",
+						},
+					}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating self-test client pod: %v", err)
+	}
+	t.client = clientPod
+
+	return nil
+}
+
+// schedule waits for the server and client pods to be running.
+func (t *selfTest) schedule(ctx context.Context) error {
+	client := t.cluster.KubernetesClient()
+	err := t.cluster.WaitFor(ctx, func() (bool, error) {
+		for _, pod := range []**corev1.Pod{&t.server, &t.client} {
+			p, err := client.CoreV1().Pods(t.ns).Get((*pod).Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			*pod = p
+		}
+		return t.podsRunning() && t.server.Status.PodIP != "", nil
+	})
+	if err != nil {
+		return fmt.Errorf("%v (server %s, client %s)", err, podState(t.server), podState(t.client))
+	}
+	return nil
+}
+
+// fetch retries fetching url from the client pod until it succeeds
+// or ctx expires. Retrying gives the CNI and kube-proxy a moment to
+// program the new pods and service.
+func (t *selfTest) fetch(ctx context.Context, url string) error {
+	return t.retry(ctx, func() error {
+		_, err := t.exec(ctx, "wget -q -O- -T 5 "+url)
+		return err
+	})
+}
+
+// dns resolves the test service's name from the client pod, and
+// checks that it resolves to the service's IP.
+func (t *selfTest) dns(ctx context.Context) error {
+	name := fmt.Sprintf("server.%s.svc.cluster.local", t.ns)
+	return t.retry(ctx, func() error {
+		out, err := t.exec(ctx, "nslookup "+name)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(out), t.svc.Spec.ClusterIP) {
+			return fmt.Errorf("%s didn't resolve to %s: %s", name, t.svc.Spec.ClusterIP, strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+}
+
+// pull runs a pod with an image that must be pulled from a remote
+// registry, and waits for it to complete.
+func (t *selfTest) pull(ctx context.Context) error {
+	client := t.cluster.KubernetesClient()
+	pod, err := client.CoreV1().Pods(t.ns).Create(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull"},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:            "pull",
+				Image:           selfTestPullImage,
+				ImagePullPolicy: corev1.PullAlways,
+				Command:         []string{"true"},
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = t.cluster.WaitFor(ctx, func() (bool, error) {
+		p, err := client.CoreV1().Pods(t.ns).Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		pod = p
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded:
+			return true, nil
+		case corev1.PodFailed:
+			return false, fmt.Errorf("pod failed: %s", podState(pod))
+		}
+		return false, nil
+	})
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("pulling %s: %v (%s)", selfTestPullImage, err, podState(pod))
+	}
+	return err
+}
+
+// exec runs command in the client pod.
+func (t *selfTest) exec(ctx context.Context, command string) ([]byte, error) {
+	return t.cluster.Controller().runContext(ctx, fmt.Sprintf("KUBECONFIG=/etc/kubernetes/admin.conf kubectl exec -n %s %s -- %s", t.ns, t.client.Name, command))
+}
+
+// retry calls fn every second until it succeeds or ctx expires, and
+// returns the last error.
+func (t *selfTest) retry(ctx context.Context, fn func() error) error {
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// podState describes why pod isn't running, for error messages.
+func podState(pod *corev1.Pod) string {
+	for _, st := range pod.Status.ContainerStatuses {
+		if st.State.Waiting != nil && st.State.Waiting.Reason != "" {
+			return fmt.Sprintf("%s: %s", st.State.Waiting.Reason, st.State.Waiting.Message)
+		}
+		if st.State.Terminated != nil {
+			return fmt.Sprintf("exited %d: %s", st.State.Terminated.ExitCode, st.State.Terminated.Reason)
+		}
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue && cond.Message != "" {
+			return cond.Message
+		}
+	}
+	return string(pod.Status.Phase)
+}
@@ -0,0 +1,632 @@
+package virtuakube
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Server is a long-running owner of universes in a root directory,
+// which it exposes as a gRPC API (see GRPCServer and the api package)
+// and as the equivalent JSON API over HTTP (see Handler), so that
+// programs that aren't written in Go (CI controllers, web frontends)
+// can drive virtuakube without shelling out to vkube.
+//
+// Universes are named by their directory in the root. The JSON API
+// is:
+//
+//	GET    /v1/universes                            list open universes
+//	POST   /v1/universes                            open or create one: {"Name", "Snapshot"}
+//	GET    /v1/universes/{name}                     universe status
+//	DELETE /v1/universes/{name}[?save=<snapshot>]   close, or save and close
+//	POST   /v1/universes/{name}/snapshots           checkpoint without closing: {"Name"}
+//	POST   /v1/universes/{name}/vms                 create and start a VM: VMConfig
+//	POST   /v1/universes/{name}/clusters            create and start a cluster: ClusterConfig
+//	POST   /v1/universes/{name}/vms/{vm}/run        run a command: {"Command"}
+//	POST   /v1/universes/{name}/vms/{vm}/forwards   forward a port: {"Port"}
+//	DELETE /v1/universes/{name}/vms/{vm}/forwards/{port}
+//	GET    /v1/universes/{name}/events[?replay=1]   stream events, one JSON Event per line
+//	GET    /v1/universes/{name}/logs                stream slog JSON log records
+//
+// Every request must carry the server's token, as an "Authorization:
+// Bearer <token>" header (or metadata entry, for gRPC). The token is in the server.token file of the
+// root directory, readable only by its owner. Requests with a body
+// must have the Content-Type application/json, which browsers don't
+// send across origins without asking first, so web pages can't drive
+// the server behind its users' backs.
+//
+// Errors are reported as {"Error": "..."}, with a 4xx or 5xx status.
+// Commands return {"Output", "ExitStatus"}, a command that runs and
+// fails isn't an error. Requests that create VMs and clusters take as
+// long as the creation, and cancel it if the client goes away.
+type Server struct {
+	root  string
+	cfg   UniverseConfig
+	token string
+
+	mu        sync.Mutex
+	universes map[string]*servedUniverse
+	closed    bool
+}
+
+// servedUniverse is a universe that a Server has open.
+type servedUniverse struct {
+	u    *Universe
+	logs *logFanout
+}
+
+// NewServer returns a server for the universes in root. Each universe
+// is opened with a copy of cfg, whose Logger or CommandLog also gets
+// the records that the API streams. A nil cfg is equivalent to the
+// zero value.
+func NewServer(root string, cfg *UniverseConfig) (*Server, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	token, err := serverToken(filepath.Join(root, serverTokenFile))
+	if err != nil {
+		return nil, fmt.Errorf("getting API token: %v", err)
+	}
+	ret := &Server{
+		root:      root,
+		token:     token,
+		universes: map[string]*servedUniverse{},
+	}
+	if cfg != nil {
+		ret.cfg = *cfg
+	}
+	return ret, nil
+}
+
+// serverTokenFile is the file in a server's root directory that holds
+// its API token.
+const serverTokenFile = "server.token"
+
+// serverToken returns the API token in path, creating a random one if
+// path doesn't exist.
+func serverToken(path string) (string, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err == nil {
+		if token := string(bytes.TrimSpace(bs)); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("%q is empty", path)
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	rnd := make([]byte, 32)
+	if _, err := rand.Read(rnd); err != nil {
+		panic("system ran out of randomness")
+	}
+	token := hex.EncodeToString(rnd)
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// TokenFile returns the path of the file that holds the server's API
+// token.
+func (s *Server) TokenFile() string {
+	return filepath.Join(s.root, serverTokenFile)
+}
+
+// authorized reports whether the Authorization header value auth
+// carries the server's token.
+func (s *Server) authorized(auth string) bool {
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || auth[:len(prefix)] != prefix {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(s.token)) == 1
+}
+
+// Close closes all the server's universes, discarding their changes
+// since they were last saved. Universes that are still opening are
+// closed when they finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var us []*Universe
+	for _, su := range s.universes {
+		if su != nil {
+			us = append(us, su.u)
+		}
+	}
+	s.mu.Unlock()
+
+	var ret error
+	for _, u := range us {
+		if err := u.Close(); err != nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// Handler returns the HTTP handler for the server's JSON API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/universes", s.handleList)
+	mux.HandleFunc("POST /v1/universes", s.handleOpen)
+	mux.HandleFunc("GET /v1/universes/{name}", s.universe(s.handleStatus))
+	mux.HandleFunc("DELETE /v1/universes/{name}", s.universe(s.handleClose))
+	mux.HandleFunc("POST /v1/universes/{name}/snapshots", s.universe(s.handleCheckpoint))
+	mux.HandleFunc("POST /v1/universes/{name}/vms", s.universe(s.handleNewVM))
+	mux.HandleFunc("POST /v1/universes/{name}/clusters", s.universe(s.handleNewCluster))
+	mux.HandleFunc("POST /v1/universes/{name}/vms/{vm}/run", s.vm(s.handleRun))
+	mux.HandleFunc("POST /v1/universes/{name}/vms/{vm}/forwards", s.vm(s.handleForward))
+	mux.HandleFunc("DELETE /v1/universes/{name}/vms/{vm}/forwards/{port}", s.vm(s.handleStopForward))
+	mux.HandleFunc("GET /v1/universes/{name}/events", s.universe(s.handleEvents))
+	mux.HandleFunc("GET /v1/universes/{name}/logs", s.universe(s.handleLogs))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, apiErrorf(http.StatusUnauthorized, "missing or wrong API token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// apiError is an error of a Server call, with the HTTP status that
+// reports it. Other errors are reported as internal errors.
+type apiError struct {
+	code int
+	err  error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+// apiErrorf returns an apiError with the HTTP status code.
+func apiErrorf(code int, format string, args ...interface{}) error {
+	return &apiError{code, fmt.Errorf(format, args...)}
+}
+
+// errorCode returns the HTTP status that reports err.
+func errorCode(err error) int {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		return ae.code
+	}
+	return http.StatusInternalServerError
+}
+
+// The server's operations, shared by its JSON and gRPC APIs.
+
+// list returns the status of the open universes, by name.
+func (s *Server) list() []*UniverseStatus {
+	s.mu.Lock()
+	var names []string
+	for name, su := range s.universes {
+		// Universes that are still opening aren't listed yet.
+		if su != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var us []*Universe
+	for _, name := range names {
+		us = append(us, s.universes[name].u)
+	}
+	s.mu.Unlock()
+
+	ret := []*UniverseStatus{}
+	for _, u := range us {
+		ret = append(ret, u.Status())
+	}
+	return ret
+}
+
+// open opens the universe name at snapshot, or creates it if it
+// doesn't exist.
+func (s *Server) open(ctx context.Context, name, snapshot string) (*servedUniverse, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." || name == serverTokenFile {
+		return nil, apiErrorf(http.StatusBadRequest, "invalid universe name %q", name)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, apiErrorf(http.StatusServiceUnavailable, "server is shutting down")
+	}
+	if _, ok := s.universes[name]; ok {
+		s.mu.Unlock()
+		return nil, apiErrorf(http.StatusConflict, "universe %q is already open", name)
+	}
+	// Reserve the name while the universe opens, which can take a
+	// while.
+	s.universes[name] = nil
+	s.mu.Unlock()
+
+	su := &servedUniverse{logs: &logFanout{subs: map[chan []byte]bool{}}}
+	cfg := s.cfg
+	streamed := slog.NewJSONHandler(su.logs, &slog.HandlerOptions{Level: slog.LevelDebug})
+	cfg.Logger = slog.New(slog.NewMultiHandler(newLogger(&s.cfg).Handler(), streamed))
+
+	dir := filepath.Join(s.root, name)
+	var err error
+	if _, statErr := os.Stat(dir); os.IsNotExist(statErr) {
+		su.u, err = Create(ctx, dir, &cfg)
+	} else {
+		su.u, err = Open(ctx, dir, snapshot, &cfg)
+	}
+
+	s.mu.Lock()
+	if err != nil {
+		delete(s.universes, name)
+		s.mu.Unlock()
+		return nil, fmt.Errorf("opening universe %q: %v", name, err)
+	}
+	if s.closed {
+		delete(s.universes, name)
+		s.mu.Unlock()
+		su.u.Close()
+		return nil, apiErrorf(http.StatusServiceUnavailable, "server is shutting down")
+	}
+	s.universes[name] = su
+	s.mu.Unlock()
+
+	// The universe can also be closed through its control socket,
+	// e.g. by vkube stop.
+	go func() {
+		su.u.Wait(context.Background())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.universes[name] == su {
+			delete(s.universes, name)
+		}
+	}()
+
+	return su, nil
+}
+
+// lookup returns the open universe name.
+func (s *Server) lookup(name string) (*servedUniverse, error) {
+	s.mu.Lock()
+	su := s.universes[name]
+	s.mu.Unlock()
+	if su == nil {
+		return nil, apiErrorf(http.StatusNotFound, "universe %q isn't open", name)
+	}
+	return su, nil
+}
+
+// lookupVM returns the VM name of the open universe.
+func (s *Server) lookupVM(universe, name string) (*VM, error) {
+	su, err := s.lookup(universe)
+	if err != nil {
+		return nil, err
+	}
+	vm := su.u.VM(name)
+	if vm == nil {
+		return nil, apiErrorf(http.StatusNotFound, "universe doesn't have a VM named %q", name)
+	}
+	return vm, nil
+}
+
+// closeUniverse closes su, saving it to snapshot first if save is
+// set.
+func (s *Server) closeUniverse(ctx context.Context, su *servedUniverse, save bool, snapshot string) error {
+	if save {
+		return su.u.Save(ctx, snapshot)
+	}
+	return su.u.Close()
+}
+
+// createVM creates and starts a VM in su.
+func (s *Server) createVM(ctx context.Context, su *servedUniverse, cfg *VMConfig) (*VMStatus, error) {
+	vm, err := su.u.NewVM(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating VM: %v", err)
+	}
+	if err := vm.Start(ctx); err != nil {
+		return nil, fmt.Errorf("starting VM %q: %v", vm.Hostname(), err)
+	}
+	for _, st := range su.u.Status().VMs {
+		if st.Name == vm.Hostname() {
+			return &st, nil
+		}
+	}
+	return nil, fmt.Errorf("VM %q went away", vm.Hostname())
+}
+
+// createCluster creates and starts a cluster in su.
+func (s *Server) createCluster(ctx context.Context, su *servedUniverse, cfg *ClusterConfig) (*ClusterStatus, error) {
+	c, err := su.u.NewCluster(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating cluster: %v", err)
+	}
+	if err := c.Start(ctx); err != nil {
+		return nil, fmt.Errorf("starting cluster %q: %v", c.Name(), err)
+	}
+	for _, st := range su.u.Status().Clusters {
+		if st.Name == c.Name() {
+			return &st, nil
+		}
+	}
+	return nil, fmt.Errorf("cluster %q went away", c.Name())
+}
+
+// runCommand runs command on vm, and returns its interleaved stdout
+// and stderr, and its exit status.
+func (s *Server) runCommand(ctx context.Context, vm *VM, command string) ([]byte, int, error) {
+	// Stdout and Stderr are written concurrently, so they share a
+	// writer with a lock.
+	var out bytes.Buffer
+	lw := &lockedWriter{w: &out}
+	status, err := vm.Exec(ctx, command, ExecOptions{Stdout: lw, Stderr: lw})
+	if err != nil {
+		return nil, 0, fmt.Errorf("running command: %v", err)
+	}
+	return out.Bytes(), status, nil
+}
+
+// The JSON API.
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errorCode(err))
+	json.NewEncoder(w).Encode(struct{ Error string }{err.Error()})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeError(w, apiErrorf(http.StatusUnsupportedMediaType, "request body must be application/json"))
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, apiErrorf(http.StatusBadRequest, "parsing request: %v", err))
+		return false
+	}
+	return true
+}
+
+// universe wraps a handler of requests about the open universe named
+// by the request path.
+func (s *Server) universe(h func(http.ResponseWriter, *http.Request, *servedUniverse)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		su, err := s.lookup(r.PathValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		h(w, r, su)
+	}
+}
+
+// vm wraps a handler of requests about the VM named by the request
+// path.
+func (s *Server) vm(h func(http.ResponseWriter, *http.Request, *VM)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vm, err := s.lookupVM(r.PathValue("name"), r.PathValue("vm"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		h(w, r, vm)
+	}
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.list())
+}
+
+func (s *Server) handleOpen(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string
+		Snapshot string
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	su, err := s.open(r.Context(), req.Name, req.Snapshot)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, su.u.Status())
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request, su *servedUniverse) {
+	writeJSON(w, su.u.Status())
+}
+
+func (s *Server) handleClose(w http.ResponseWriter, r *http.Request, su *servedUniverse) {
+	q := r.URL.Query()
+	if err := s.closeUniverse(r.Context(), su, q.Has("save"), q.Get("save")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCheckpoint(w http.ResponseWriter, r *http.Request, su *servedUniverse) {
+	var req struct{ Name string }
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := su.u.checkpoint(req.Name); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleNewVM(w http.ResponseWriter, r *http.Request, su *servedUniverse) {
+	var cfg VMConfig
+	if !readJSON(w, r, &cfg) {
+		return
+	}
+	st, err := s.createVM(r.Context(), su, &cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, st)
+}
+
+func (s *Server) handleNewCluster(w http.ResponseWriter, r *http.Request, su *servedUniverse) {
+	var cfg ClusterConfig
+	if !readJSON(w, r, &cfg) {
+		return
+	}
+	st, err := s.createCluster(r.Context(), su, &cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, st)
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request, vm *VM) {
+	var req struct{ Command string }
+	if !readJSON(w, r, &req) {
+		return
+	}
+	out, status, err := s.runCommand(r.Context(), vm, req.Command)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, struct {
+		Output     []byte
+		ExitStatus int
+	}{out, status})
+}
+
+func (s *Server) handleForward(w http.ResponseWriter, r *http.Request, vm *VM) {
+	var req struct{ Port int }
+	if !readJSON(w, r, &req) {
+		return
+	}
+	hostPort, err := vm.ForwardPort(req.Port)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, struct{ Port, HostPort int }{req.Port, hostPort})
+}
+
+func (s *Server) handleStopForward(w http.ResponseWriter, r *http.Request, vm *VM) {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil {
+		writeError(w, apiErrorf(http.StatusBadRequest, "invalid port %q", r.PathValue("port")))
+		return
+	}
+	if err := vm.StopForward(port); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request, su *servedUniverse) {
+	replay, _ := strconv.ParseBool(r.URL.Query().Get("replay"))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for ev := range su.u.Subscribe(r.Context(), replay) {
+		if err := enc.Encode(ev); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+}
+
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request, su *servedUniverse) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	su.streamLogs(r.Context(), func(bs []byte) error {
+		if _, err := w.Write(bs); err != nil {
+			return err
+		}
+		rc.Flush()
+		return nil
+	})
+}
+
+// streamLogs calls send with the universe's log records, until ctx is
+// done, the universe closes, or send fails.
+func (su *servedUniverse) streamLogs(ctx context.Context, send func([]byte) error) {
+	logs := su.logs.subscribe()
+	defer su.logs.unsubscribe(logs)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-su.u.closedCh:
+			return
+		case bs := <-logs:
+			if err := send(bs); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// logFanout copies the log records written to it to its subscribers.
+// Subscribers that fall behind miss records, rather than holding up
+// the universe.
+type logFanout struct {
+	mu   sync.Mutex
+	subs map[chan []byte]bool
+}
+
+func (l *logFanout) Write(bs []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ch := range l.subs {
+		select {
+		case ch <- append([]byte(nil), bs...):
+		default:
+		}
+	}
+	return len(bs), nil
+}
+
+func (l *logFanout) subscribe() chan []byte {
+	ch := make(chan []byte, 1000)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subs[ch] = true
+	return ch
+}
+
+func (l *logFanout) unsubscribe(ch chan []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.subs, ch)
+}
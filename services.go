@@ -0,0 +1,278 @@
+package virtuakube
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// serviceExposer forwards localhost ports to a cluster's NodePort and
+// LoadBalancer Services, see ClusterConfig.ExposeServices.
+//
+// NodePorts are forwarded by qemu from the controller, like any other
+// VM port. Load balancer addresses aren't reachable through qemu's
+// user networking, so connections to their forwards are tunneled over
+// the controller's SSH connection, and kube-proxy on the controller
+// routes them.
+type serviceExposer struct {
+	cluster *Cluster
+
+	mu sync.Mutex
+	// Localhost ports of the exposed Services' TCP ports, by
+	// Service port, keyed by "namespace/name".
+	ports map[string]map[int32]int
+	// Tunnels to load balancer addresses, keyed by "ip:port".
+	tunnels map[string]*lbTunnel
+}
+
+// lbTunnel tunnels connections to a localhost port to a load
+// balancer address.
+type lbTunnel struct {
+	port     int
+	listener net.Listener
+}
+
+func newServiceExposer(c *Cluster) *serviceExposer {
+	return &serviceExposer{
+		cluster: c,
+		ports:   map[string]map[int32]int{},
+		tunnels: map[string]*lbTunnel{},
+	}
+}
+
+// run keeps the cluster's Services exposed until the universe closes.
+func (e *serviceExposer) run() {
+	u := e.cluster.universe
+	for {
+		if err := e.reconcile(); err != nil {
+			u.log.Warn("exposing services failed", "cluster", e.cluster.Name(), "error", err)
+		}
+		select {
+		case <-u.closedCh:
+			e.mu.Lock()
+			for target, t := range e.tunnels {
+				t.listener.Close()
+				delete(e.tunnels, target)
+			}
+			e.mu.Unlock()
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// reconcile forwards localhost ports to the cluster's current NodePort
+// and LoadBalancer Services, and stops the forwards of Services that
+// are gone. Ports that fail to be forwarded are logged, and retried at
+// the next reconcile.
+func (e *serviceExposer) reconcile() error {
+	c := e.cluster
+	log := c.universe.log
+	svcs, err := c.client.CoreV1().Services("").List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	owned := map[int]bool{}
+	for _, np := range c.cfg.ExposedNodePorts {
+		owned[np] = true
+	}
+	nodePorts := map[int]bool{}
+	targets := map[string]bool{}
+	ports := map[string]map[int32]int{}
+	for i := range svcs.Items {
+		svc := &svcs.Items[i]
+		if svc.Spec.Type != corev1.ServiceTypeNodePort && svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		key := svc.Namespace + "/" + svc.Name
+
+		var lbIP string
+		if ingress := svc.Status.LoadBalancer.Ingress; svc.Spec.Type == corev1.ServiceTypeLoadBalancer && len(ingress) > 0 {
+			lbIP = ingress[0].IP
+		}
+		for _, p := range svc.Spec.Ports {
+			if p.Protocol != corev1.ProtocolTCP && p.Protocol != "" {
+				continue
+			}
+			var (
+				port int
+				err  error
+			)
+			switch {
+			case lbIP != "":
+				target := net.JoinHostPort(lbIP, strconv.Itoa(int(p.Port)))
+				targets[target] = true
+				port, err = e.tunnelWithLock(target)
+			case p.NodePort != 0:
+				np := int(p.NodePort)
+				if port = c.controller.ForwardedPort(np); port != 0 && !owned[np] {
+					// Forwarded by someone else, leave it be.
+					break
+				}
+				nodePorts[np] = true
+				port, err = c.controller.ForwardPort(np)
+			default:
+				continue
+			}
+			if err != nil {
+				log.Warn("exposing service port failed", "cluster", c.Name(), "service", key, "port", p.Port, "error", err)
+				continue
+			}
+			if ports[key] == nil {
+				ports[key] = map[int32]int{}
+			}
+			ports[key][p.Port] = port
+		}
+	}
+	e.ports = ports
+
+	// Stop the forwards of Services that are gone.
+	var exposed []int
+	for np := range owned {
+		if nodePorts[np] {
+			continue
+		}
+		if err := c.controller.StopForward(np); err != nil && c.controller.ForwardedPort(np) != 0 {
+			log.Warn("stopping NodePort forward failed", "cluster", c.Name(), "port", np, "error", err)
+			nodePorts[np] = true
+		}
+	}
+	for np := range nodePorts {
+		exposed = append(exposed, np)
+	}
+	sort.Ints(exposed)
+	// The cluster's config is saved under the universe's lock.
+	c.universe.mu.Lock()
+	c.cfg.ExposedNodePorts = exposed
+	c.universe.mu.Unlock()
+	for target, t := range e.tunnels {
+		if !targets[target] {
+			t.listener.Close()
+			delete(e.tunnels, target)
+		}
+	}
+
+	return nil
+}
+
+// tunnelWithLock returns the localhost port that tunnels to the load
+// balancer address target, opening the tunnel if needed. Like VM port
+// forwards, the tunnel's port is recorded in the universe's port
+// history, so it's reused across runs when possible.
+func (e *serviceExposer) tunnelWithLock(target string) (int, error) {
+	if t := e.tunnels[target]; t != nil {
+		return t.port, nil
+	}
+
+	u := e.cluster.universe
+	key := e.cluster.Name() + "/lb/" + target
+	u.mu.Lock()
+	prev, ok := u.ports[key]
+	port := prev
+	if !ok || !u.portAvailableWithLock(prev, key) {
+		port = u.freePortWithLock()
+		if ok {
+			u.emit(EventPortReassigned, e.cluster.Name(), "localhost:%d is in use, load balancer %s is now forwarded from localhost:%d", prev, target, port)
+		}
+		u.ports[key] = port
+	}
+	u.mu.Unlock()
+
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return 0, err
+	}
+	e.tunnels[target] = &lbTunnel{port: port, listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go e.pipe(conn, target)
+		}
+	}()
+	return port, nil
+}
+
+// pipe copies between conn and a new connection from the controller
+// to target.
+func (e *serviceExposer) pipe(conn net.Conn, target string) {
+	defer conn.Close()
+	remote, err := e.cluster.controller.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- true
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- true
+	}()
+	<-done
+}
+
+// ServiceURL returns the localhost URL, e.g. http://127.0.0.1:34567,
+// that reaches the first TCP port of the NodePort or LoadBalancer
+// Service name in namespace ns. LoadBalancer Services are reached at
+// their load balancer address once they have one, and at their
+// NodePort until then.
+//
+// The cluster must have been created with
+// ClusterConfig.ExposeServices. Services created since the last time
+// the cluster's Services were checked are exposed on demand.
+func (c *Cluster) ServiceURL(ns, name string) (string, error) {
+	if !c.cfg.ExposeServices {
+		return "", fmt.Errorf("cluster %q doesn't expose services, see ClusterConfig.ExposeServices", c.Name())
+	}
+	if c.services == nil {
+		return "", fmt.Errorf("cluster %q isn't started", c.Name())
+	}
+	key := ns + "/" + name
+	svc, err := c.client.CoreV1().Services(ns).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("getting service %q: %v", key, err)
+	}
+	var svcPort int32
+	for _, p := range svc.Spec.Ports {
+		if p.Protocol == corev1.ProtocolTCP || p.Protocol == "" {
+			svcPort = p.Port
+			break
+		}
+	}
+	if svcPort == 0 || (svc.Spec.Type != corev1.ServiceTypeNodePort && svc.Spec.Type != corev1.ServiceTypeLoadBalancer) {
+		return "", fmt.Errorf("service %q has no exposed TCP ports, only NodePort and LoadBalancer Services are exposed", key)
+	}
+
+	c.services.mu.Lock()
+	port := c.services.ports[key][svcPort]
+	c.services.mu.Unlock()
+	if port == 0 {
+		if err := c.services.reconcile(); err != nil {
+			return "", fmt.Errorf("exposing services: %v", err)
+		}
+		c.services.mu.Lock()
+		port = c.services.ports[key][svcPort]
+		c.services.mu.Unlock()
+	}
+	if port == 0 {
+		return "", fmt.Errorf("port %d of service %q isn't exposed yet, see the universe's log for why", svcPort, key)
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d", port), nil
+}
@@ -0,0 +1,197 @@
+package virtuakube
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// SnapshotInfo returns a summary of each of the universe's saved
+// snapshots, sorted by name.
+func (u *Universe) SnapshotInfo() []SnapshotInfo {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var ret []SnapshotInfo
+	for name, snap := range u.cfg.Snapshots {
+		ret = append(ret, snapshotInfo(name, snap))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// DeleteSnapshot deletes the named snapshot, along with the disk
+// files that no other snapshot or running VM uses. The snapshot the
+// universe was opened from can't be deleted.
+func (u *Universe) DeleteSnapshot(name string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.cfg.Snapshots[name] == nil {
+		return fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+	}
+	if name == u.activeSnapshot {
+		return fmt.Errorf("can't delete snapshot %q, the universe is running from it", name)
+	}
+	if err := u.deleteSnapshotWithLock(name); err != nil {
+		return err
+	}
+	return config.Write(filepath.Join(u.dir, "config.json"), u.cfg)
+}
+
+func (u *Universe) deleteSnapshotWithLock(name string) error {
+	snap := u.cfg.Snapshots[name]
+	live := map[string]bool{}
+	for _, img := range u.images {
+		for _, file := range img.Files() {
+			live[file] = true
+		}
+	}
+	for vmName, vm := range u.vms {
+		for _, file := range vm.cfg.Files() {
+			live[file] = true
+		}
+		// Running VMs hold their disk open, so qemu has to delete
+		// the snapshot's state from it.
+		if vmcfg := snap.VMs[vmName]; vmcfg != nil && vmcfg.DiskFile == vm.cfg.DiskFile {
+			if err := vm.deleteSnapshot(snap.ID); err != nil {
+				return fmt.Errorf("deleting snapshot %q from %q: %v", name, vmName, err)
+			}
+		}
+	}
+
+	delete(u.cfg.Snapshots, name)
+	return removeSnapshotFiles(u.dir, u.cfg, snap, live)
+}
+
+// removeSnapshotFiles cleans up after the deletion of snap from cfg:
+// disk files that nothing else uses are removed, and snap's state is
+// deleted from the others. live are the files in use by the running
+// universe, whose snapshot state has already been dealt with.
+func removeSnapshotFiles(dir string, cfg *config.Universe, snap *config.Snapshot, live map[string]bool) error {
+	used := map[string]bool{}
+	for _, other := range cfg.Snapshots {
+		for _, img := range other.Images {
+			for _, file := range img.Files() {
+				used[file] = true
+			}
+		}
+		for _, vm := range other.VMs {
+			for _, file := range vm.Files() {
+				used[file] = true
+			}
+		}
+	}
+
+	for _, vm := range snap.VMs {
+		for _, file := range vm.Files() {
+			path := file
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			switch {
+			case live[file]:
+			case used[file]:
+				if out, err := exec.Command("qemu-img", "snapshot", "-d", snap.ID, path).CombinedOutput(); err != nil {
+					return fmt.Errorf("deleting snapshot %q from disk of %q: %v (%s)", snap.Name, vm.Name, err, out)
+				}
+			default:
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+	}
+	for _, img := range snap.Images {
+		for _, file := range img.Files() {
+			if live[file] || used[file] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RenameSnapshot renames the snapshot oldName to newName. The
+// snapshot the universe was opened from can't be renamed.
+func (u *Universe) RenameSnapshot(oldName, newName string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if oldName == u.activeSnapshot {
+		return fmt.Errorf("can't rename snapshot %q, the universe is running from it", oldName)
+	}
+	if err := renameSnapshot(u.cfg, oldName, newName); err != nil {
+		return err
+	}
+	return config.Write(filepath.Join(u.dir, "config.json"), u.cfg)
+}
+
+func renameSnapshot(cfg *config.Universe, oldName, newName string) error {
+	snap := cfg.Snapshots[oldName]
+	if snap == nil {
+		return fmt.Errorf("%w: %q", ErrSnapshotNotFound, oldName)
+	}
+	if cfg.Snapshots[newName] != nil {
+		return fmt.Errorf("universe already has a snapshot %q", newName)
+	}
+	// VM disks know snapshots by ID, so only the config changes.
+	delete(cfg.Snapshots, oldName)
+	snap.Name = newName
+	cfg.Snapshots[newName] = snap
+	return nil
+}
+
+// errUniverseOpen is returned by the offline snapshot functions when
+// the universe is open, and must be changed through its process
+// instead.
+var errUniverseOpen = errors.New("universe is open, change its snapshots through the running universe")
+
+// DeleteSavedSnapshot deletes the named snapshot from the universe in
+// dir, like Universe.DeleteSnapshot, without opening the universe.
+// The universe must not be open.
+func DeleteSavedSnapshot(dir, name string) error {
+	return editSavedSnapshots(dir, func(cfg *config.Universe) error {
+		snap := cfg.Snapshots[name]
+		if snap == nil {
+			return fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+		}
+		delete(cfg.Snapshots, name)
+		return removeSnapshotFiles(dir, cfg, snap, nil)
+	})
+}
+
+// RenameSavedSnapshot renames a snapshot of the universe in dir, like
+// Universe.RenameSnapshot, without opening the universe. The
+// universe must not be open.
+func RenameSavedSnapshot(dir, oldName, newName string) error {
+	return editSavedSnapshots(dir, func(cfg *config.Universe) error {
+		return renameSnapshot(cfg, oldName, newName)
+	})
+}
+
+func editSavedSnapshots(dir string, edit func(*config.Universe) error) error {
+	if _, err := Attach(dir); err == nil {
+		return errUniverseOpen
+	}
+	lock, err := lockUniverse(dir, false)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	path := filepath.Join(dir, "config.json")
+	cfg, err := config.Read(path)
+	if err != nil {
+		return fmt.Errorf("reading universe config: %v", err)
+	}
+	if err := edit(cfg); err != nil {
+		return err
+	}
+	return config.Write(path, cfg)
+}
@@ -0,0 +1,374 @@
+package virtuakube
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.universe.tf/virtuakube/internal/config"
+)
+
+// SnapshotDiff describes how snapshot B of a universe differs from
+// snapshot A.
+type SnapshotDiff struct {
+	A, B string
+	// Clock is how much universe time passed from A to B.
+	Clock time.Duration
+	// Fields are the snapshot-wide settings that differ, e.g.
+	// "NextPort".
+	Fields []string
+	// Resources are the resources that were added, removed or
+	// changed, sorted by kind and name.
+	Resources []ResourceDiff
+	// AddedBytes is the host disk space of the files that B uses and
+	// A doesn't, RemovedBytes that of the files that only A uses.
+	AddedBytes   int64
+	RemovedBytes int64
+}
+
+// ResourceDiff describes how a resource differs between two
+// snapshots.
+type ResourceDiff struct {
+	// Kind is "image", "network", "vm", "cluster" or "registry".
+	Kind string
+	Name string
+	// Change is "added", "removed" or "changed".
+	Change string
+	// Fields are the configuration fields that differ, for changed
+	// resources.
+	Fields []string
+	// For images and VMs, the host disk space of the resource's
+	// files that only B uses, and that only A uses. When both
+	// snapshots share a VM's disk file, blocks the VM wrote between
+	// them are in that file, and don't show up here, see DataA and
+	// DataB.
+	DiskAdded   int64
+	DiskRemoved int64
+	// For VMs, the size of the memory state saved in A and in B,
+	// zero if the snapshot doesn't have the VM.
+	StateA int64
+	StateB int64
+	// For VMs, the size of the data allocated in the VM's disk as
+	// of A and as of B, base image included, zero if the snapshot
+	// doesn't have the VM. The difference is how much the VM's disk
+	// grew from A to B, whether or not they share its file.
+	DataA int64
+	DataB int64
+}
+
+// DiffSnapshots compares the snapshots a and b of the universe in
+// dir, whether or not it's open.
+func DiffSnapshots(dir, a, b string) (*SnapshotDiff, error) {
+	cfg, err := config.Read(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("reading universe config: %v", err)
+	}
+	snapA, snapB := cfg.Snapshots[a], cfg.Snapshots[b]
+	if snapA == nil {
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, a)
+	}
+	if snapB == nil {
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, b)
+	}
+
+	ret := &SnapshotDiff{
+		A:     a,
+		B:     b,
+		Clock: snapB.Clock.Sub(snapA.Clock),
+	}
+	settings := func(s *config.Snapshot) *config.Snapshot {
+		return &config.Snapshot{NextPort: s.NextPort, NextNet: s.NextNet, Ports: s.Ports}
+	}
+	if ret.Fields, err = diffFields(settings(snapA), settings(snapB)); err != nil {
+		return nil, err
+	}
+
+	disks := &diskInfoCache{dir: dir, infos: map[string]*qemuImgInfo{}}
+	filesA, filesB := snapshotFiles(snapA), snapshotFiles(snapB)
+
+	diff := func(kind string, names []string, lookup func(*config.Snapshot, string) (interface{}, []string)) error {
+		for _, name := range names {
+			cfgA, fsA := lookup(snapA, name)
+			cfgB, fsB := lookup(snapB, name)
+			d := ResourceDiff{Kind: kind, Name: name}
+			switch {
+			case cfgA == nil:
+				d.Change = "added"
+			case cfgB == nil:
+				d.Change = "removed"
+			default:
+				d.Change = "changed"
+				if d.Fields, err = diffFields(cfgA, cfgB); err != nil {
+					return err
+				}
+			}
+			for _, f := range fsB {
+				if !filesA[f] {
+					size, err := disks.size(f)
+					if err != nil {
+						return err
+					}
+					d.DiskAdded += size
+				}
+			}
+			for _, f := range fsA {
+				if !filesB[f] {
+					size, err := disks.size(f)
+					if err != nil {
+						return err
+					}
+					d.DiskRemoved += size
+				}
+			}
+			if kind == "vm" {
+				if d.StateA, err = disks.stateSize(snapA, name); err != nil {
+					return err
+				}
+				if d.StateB, err = disks.stateSize(snapB, name); err != nil {
+					return err
+				}
+				if d.DataA, err = disks.dataSize(snapA, name); err != nil {
+					return err
+				}
+				if d.DataB, err = disks.dataSize(snapB, name); err != nil {
+					return err
+				}
+			}
+			if d.Change == "changed" && len(d.Fields) == 0 && d.DiskAdded == 0 && d.DiskRemoved == 0 && d.StateA == d.StateB && d.DataA == d.DataB {
+				continue
+			}
+			ret.Resources = append(ret.Resources, d)
+		}
+		return nil
+	}
+
+	err = diff("image", unionKeys(snapA.Images, snapB.Images), func(s *config.Snapshot, name string) (interface{}, []string) {
+		if img := s.Images[name]; img != nil {
+			return img, img.Files()
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = diff("network", unionKeys(snapA.Networks, snapB.Networks), func(s *config.Snapshot, name string) (interface{}, []string) {
+		if net := s.Networks[name]; net != nil {
+			return net, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = diff("vm", unionKeys(snapA.VMs, snapB.VMs), func(s *config.Snapshot, name string) (interface{}, []string) {
+		if vm := s.VMs[name]; vm != nil {
+			return vm, vm.Files()
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = diff("cluster", unionKeys(snapA.Clusters, snapB.Clusters), func(s *config.Snapshot, name string) (interface{}, []string) {
+		if c := s.Clusters[name]; c != nil {
+			return c, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = diff("registry", unionKeys(snapA.Registries, snapB.Registries), func(s *config.Snapshot, name string) (interface{}, []string) {
+		if r := s.Registries[name]; r != nil {
+			return r, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range ret.Resources {
+		ret.AddedBytes += d.DiskAdded
+		ret.RemovedBytes += d.DiskRemoved
+	}
+	return ret, nil
+}
+
+// snapshotFiles returns the set of disk files that snap uses.
+func snapshotFiles(snap *config.Snapshot) map[string]bool {
+	ret := map[string]bool{}
+	for _, img := range snap.Images {
+		for _, f := range img.Files() {
+			ret[f] = true
+		}
+	}
+	for _, vm := range snap.VMs {
+		for _, f := range vm.Files() {
+			ret[f] = true
+		}
+	}
+	return ret
+}
+
+// unionKeys returns the sorted keys of the string-keyed maps a and b,
+// which must have the same type.
+func unionKeys(a, b interface{}) []string {
+	seen := map[string]bool{}
+	for _, m := range []interface{}{a, b} {
+		bs, _ := json.Marshal(m)
+		var keys map[string]json.RawMessage
+		json.Unmarshal(bs, &keys)
+		for k := range keys {
+			seen[k] = true
+		}
+	}
+	var ret []string
+	for k := range seen {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// diffFields returns the sorted names of the fields whose serialized
+// values differ between the structs a and b.
+func diffFields(a, b interface{}) ([]string, error) {
+	fields := func(v interface{}) (map[string]json.RawMessage, error) {
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		ret := map[string]json.RawMessage{}
+		return ret, json.Unmarshal(bs, &ret)
+	}
+	fa, err := fields(a)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for k, va := range fa {
+		if !bytes.Equal(va, fb[k]) {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// qemuImgInfo is the part of `qemu-img info` that diffs use.
+type qemuImgInfo struct {
+	ActualSize int64 `json:"actual-size"`
+	Snapshots  []struct {
+		Name        string `json:"name"`
+		VMStateSize int64  `json:"vm-state-size"`
+	} `json:"snapshots"`
+}
+
+// diskInfoCache inspects the disk files of a universe, each at most
+// once.
+type diskInfoCache struct {
+	dir   string
+	infos map[string]*qemuImgInfo
+}
+
+func (d *diskInfoCache) info(file string) (*qemuImgInfo, error) {
+	if info := d.infos[file]; info != nil {
+		return info, nil
+	}
+	path := d.path(file)
+	// -U lets qemu-img read disks that running VMs hold open.
+	out, err := exec.Command("qemu-img", "info", "-U", "--output=json", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("inspecting disk %q: %v", path, err)
+	}
+	info := &qemuImgInfo{}
+	if err := json.Unmarshal(out, info); err != nil {
+		return nil, fmt.Errorf("parsing qemu-img info for %q: %v", path, err)
+	}
+	d.infos[file] = info
+	return info, nil
+}
+
+// path returns the path of the disk file.
+func (d *diskInfoCache) path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(d.dir, file)
+}
+
+// size returns the host disk space that file takes up.
+func (d *diskInfoCache) size(file string) (int64, error) {
+	info, err := d.info(file)
+	if err != nil {
+		return 0, err
+	}
+	return info.ActualSize, nil
+}
+
+// stateSize returns the size of the memory state of the VM name saved
+// in snap, or zero if snap doesn't have the VM.
+func (d *diskInfoCache) stateSize(snap *config.Snapshot, name string) (int64, error) {
+	vm := snap.VMs[name]
+	if vm == nil {
+		return 0, nil
+	}
+	info, err := d.info(vm.DiskFile)
+	if err != nil {
+		return 0, err
+	}
+	// VMs are saved under the snapshot's ID.
+	for _, s := range info.Snapshots {
+		if s.Name == snap.ID {
+			return s.VMStateSize, nil
+		}
+	}
+	return 0, nil
+}
+
+// dataSize returns the size of the data allocated in the disk of the
+// VM name as of snap, or zero if snap doesn't have the VM.
+func (d *diskInfoCache) dataSize(snap *config.Snapshot, name string) (int64, error) {
+	vm := snap.VMs[name]
+	if vm == nil {
+		return 0, nil
+	}
+	info, err := d.info(vm.DiskFile)
+	if err != nil {
+		return 0, err
+	}
+	// VMs are saved under the snapshot's ID.
+	found := false
+	for _, s := range info.Snapshots {
+		if s.Name == snap.ID {
+			found = true
+		}
+	}
+	if !found {
+		return 0, nil
+	}
+	// qemu-img measure counts the clusters that the snapshot's view
+	// of the disk has allocated, down its backing chain, and adds
+	// the qcow2 metadata they'd need.
+	path := d.path(vm.DiskFile)
+	out, err := exec.Command("qemu-img", "measure", "-U", "--output=json", "-O", "qcow2", "-l", "snapshot.name="+snap.ID, path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("measuring snapshot %q of disk %q: %v", snap.Name, path, err)
+	}
+	var measure struct {
+		Required int64 `json:"required"`
+	}
+	if err := json.Unmarshal(out, &measure); err != nil {
+		return 0, fmt.Errorf("parsing qemu-img measure for %q: %v", path, err)
+	}
+	return measure.Required, nil
+}
@@ -0,0 +1,665 @@
+package virtuakube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	toml "github.com/pelletier/go-toml"
+	"sigs.k8s.io/yaml"
+)
+
+// maxNetworks is the number of networks a universe can hold. Network
+// N gets 10.248.N.0/24 and fd00:N::/64.
+const maxNetworks = 256
+
+// Spec is a declarative description of a universe's resources. It's
+// usually read from a YAML or TOML file with ReadSpec, whose keys are
+// the field names of Spec and of the config types it embeds:
+//
+//	dir: ./universe
+//	images:
+//	- name: k8s
+//	  installK8s: true
+//	  runtime: containerd
+//	  preloadK8sImages: true
+//	networks:
+//	- name: net0
+//	vms:
+//	- name: router
+//	  image: k8s
+//	  memoryMiB: 512
+//	  networks: [net0]
+//	clusters:
+//	- name: c1
+//	  numNodes: 2
+//	  cni: calico
+//	  runtime: containerd
+//	  vmConfig:
+//	    image: k8s
+//	    memoryMiB: 2048
+//	    cpus: 2
+//	    networks: [net0]
+//	  nodeSizes:
+//	  - cpus: 4
+//	    memoryMiB: 4096
+//	  nodeRoles:
+//	  - labels:
+//	      node-role.kubernetes.io/infra: ""
+//	    taints: ["dedicated=infra:NoSchedule"]
+//
+// Network addresses are allocated by virtuakube, so networks can't
+// overlap.
+type Spec struct {
+	// Dir is the universe directory, for FromSpec. Relative paths
+	// are relative to the spec file.
+	Dir      string
+	Images   []ImageSpec
+	Networks []NetworkConfig
+	// VMs are created and started after the networks.
+	VMs []VMConfig
+	// Clusters are created and started last, in order.
+	Clusters []ClusterConfig
+}
+
+// ImageSpec describes a base VM image, either imported from a disk
+// file or built with the bundled customizations.
+type ImageSpec struct {
+	Name string
+	// Import is the path of a disk image to import. If set, the
+	// other fields must be empty.
+	Import string
+	// URL is the URL of a disk image to download and import, see
+	// ImportImageURL. If set, the other fields except SHA256 must be
+	// empty.
+	URL string
+	// SHA256 is the hex SHA-256 that the image at URL must have.
+	SHA256 string
+
+	// Arch is the image's CPU architecture, ArchAMD64 (the
+	// default) or ArchARM64. Imported images must be amd64.
+	Arch string
+
+	InstallK8s bool
+	// Runtime is the container runtime that InstallK8s installs,
+	// one of the Runtime* constants. Defaults to RuntimeDocker.
+	Runtime           string
+	PreloadK8sImages  bool
+	InstallBenchTools bool
+	InstallCloudInit  bool
+	// Script is the path of a shell script to run in the image
+	// after the other customizations.
+	Script string
+}
+
+// ReadSpec reads the universe spec at path. The spec is YAML, or
+// TOML if path ends in .toml. Unknown keys are an error. Relative
+// paths in the spec are made absolute, relative to the spec file's
+// directory.
+func ReadSpec(path string) (*Spec, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(path) == ".toml" {
+		// TOML specs go through JSON, which is YAML, so that both
+		// formats have the same keys and strictness.
+		tree, err := toml.LoadBytes(bs)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %q: %v", path, err)
+		}
+		if bs, err = json.Marshal(tree.ToMap()); err != nil {
+			return nil, fmt.Errorf("parsing %q: %v", path, err)
+		}
+	}
+	var ret Spec
+	if err := yaml.UnmarshalStrict(bs, &ret); err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", path, err)
+	}
+
+	// Absolute, so that the spec can be applied by a universe running
+	// in another directory.
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	rel := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+	ret.Dir = rel(ret.Dir)
+	for i := range ret.Images {
+		ret.Images[i].Import = rel(ret.Images[i].Import)
+		ret.Images[i].Script = rel(ret.Images[i].Script)
+	}
+
+	return &ret, nil
+}
+
+// FromSpec creates a new universe in the directory named by the spec
+// at path, and creates and starts all the resources the spec
+// describes. The whole spec is validated before anything is created.
+// If realizing the spec fails, the new universe is destroyed.
+func FromSpec(path string) (*Universe, error) {
+	spec, err := ReadSpec(path)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Dir == "" {
+		return nil, errors.New("spec doesn't specify a universe dir")
+	}
+	if _, err := os.Stat(spec.Dir); err == nil {
+		return nil, fmt.Errorf("universe dir %q already exists", spec.Dir)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	u, err := Create(context.Background(), spec.Dir, &UniverseConfig{})
+	if err != nil {
+		return nil, err
+	}
+	if err := u.Apply(spec); err != nil {
+		u.Destroy()
+		return nil, err
+	}
+	return u, nil
+}
+
+// Validate checks that the spec is consistent and would realize
+// cleanly in an empty universe.
+func (s *Spec) Validate() error {
+	return s.validate(nil)
+}
+
+// Apply creates and starts the resources in spec that the universe
+// doesn't have yet, as Reconcile does without pruning.
+func (u *Universe) Apply(spec *Spec) error {
+	return u.Reconcile(context.Background(), spec, false)
+}
+
+// Reconcile validates spec against the universe's existing
+// resources, then brings the universe towards it. Images, networks,
+// VMs and clusters that the universe is missing are created and
+// started, in that order, with the new VMs' pinned addresses claimed
+// before the first VM is created. Resources that the universe already has
+// are left as they are, except that clusters with fewer worker nodes
+// than the spec's NumNodes get more, see Cluster.AddNode. Existing
+// VMs whose memory or CPUs differ from the spec are only warned
+// about.
+//
+// If prune is set, Reconcile then destroys the VMs that the spec
+// doesn't describe, other than cluster members, and removes worker
+// nodes beyond NumNodes from the spec's clusters, newest first, see
+// Cluster.RemoveNode. Images, networks and clusters can't be deleted
+// from a universe, so extra ones are only warned about.
+func (u *Universe) Reconcile(ctx context.Context, spec *Spec, prune bool) error {
+	if err := spec.validate(u); err != nil {
+		return err
+	}
+	have := u.resources()
+
+	for _, img := range spec.Images {
+		if have.images[img.Name] {
+			continue
+		}
+		var err error
+		if img.Import != "" {
+			err = u.ImportImage(img.Name, img.Import)
+		} else if img.URL != "" {
+			err = u.ImportImageURL(ctx, img.Name, img.URL, img.SHA256)
+		} else {
+			err = u.NewImage(img.imageConfig())
+		}
+		if err != nil {
+			return fmt.Errorf("creating image %q: %v", img.Name, err)
+		}
+	}
+
+	for i := range spec.Networks {
+		if have.networks[spec.Networks[i].Name] {
+			continue
+		}
+		if err := u.NewNetwork(&spec.Networks[i]); err != nil {
+			return fmt.Errorf("creating network %q: %v", spec.Networks[i].Name, err)
+		}
+	}
+
+	release, err := u.claimPins(spec, have)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for i := range spec.VMs {
+		cfg := &spec.VMs[i]
+		if vm := u.VM(cfg.Name); vm != nil {
+			u.warnVMDrift(vm, cfg)
+			continue
+		}
+		vm, err := u.NewVM(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("creating VM %q: %v", cfg.Name, err)
+		}
+		if err := vm.Start(ctx); err != nil {
+			return fmt.Errorf("starting VM %q: %v", cfg.Name, err)
+		}
+	}
+
+	for i := range spec.Clusters {
+		cfg := &spec.Clusters[i]
+		if cluster := u.Cluster(cfg.Name); cluster != nil {
+			if err := cluster.scale(ctx, cfg.NumNodes, prune); err != nil {
+				return fmt.Errorf("scaling cluster %q: %v", cfg.Name, err)
+			}
+			continue
+		}
+		cluster, err := u.NewCluster(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("creating cluster %q: %v", cfg.Name, err)
+		}
+		if err := cluster.Start(ctx); err != nil {
+			return fmt.Errorf("starting cluster %q: %v", cfg.Name, err)
+		}
+	}
+
+	if prune {
+		return u.prune(spec, have)
+	}
+	return nil
+}
+
+// claimPins claims the pinned addresses of the spec's new VMs on their
+// behalf, before any VM is created, so that the VMs created first
+// can't be allocated addresses that later ones pin. It returns a
+// function that releases the claims of the VMs that still don't
+// exist.
+func (u *Universe) claimPins(spec *Spec, have *universeResources) (func(), error) {
+	type pin struct {
+		nw    *Network
+		vm    string
+		addrs []string
+	}
+	var pins []pin
+	release := func() {
+		for _, p := range pins {
+			if u.VM(p.vm) == nil {
+				p.nw.unclaim(p.addrs...)
+			}
+		}
+	}
+	for i := range spec.VMs {
+		vm := &spec.VMs[i]
+		if have.vms[vm.Name] {
+			continue
+		}
+		for net, a := range vm.Addresses {
+			nw := u.Network(net)
+			if nw == nil {
+				continue
+			}
+			addrs := a.addrs()
+			if err := nw.claim(vmOwner(vm.Name), addrs...); err != nil {
+				release()
+				return nil, fmt.Errorf("VM %q: %v", vm.Name, err)
+			}
+			pins = append(pins, pin{nw, vm.Name, addrs})
+		}
+	}
+	return release, nil
+}
+
+// warnVMDrift warns about the ways in which the existing vm differs
+// from its spec cfg.
+func (u *Universe) warnVMDrift(vm *VM, cfg *VMConfig) {
+	if cfg.MemoryMiB != 0 && cfg.MemoryMiB != vm.cfg.MemoryMiB {
+		u.log.Warn("existing VM's memory differs from spec", "vm", cfg.Name, "memoryMiB", vm.cfg.MemoryMiB, "specMemoryMiB", cfg.MemoryMiB)
+	}
+	if cfg.CPUs != 0 && cfg.CPUs != vm.cfg.CPUs {
+		u.log.Warn("existing VM's CPUs differ from spec", "vm", cfg.Name, "cpus", vm.cfg.CPUs, "specCPUs", cfg.CPUs)
+	}
+}
+
+// scale adds worker nodes to the cluster until it has numNodes. If
+// prune is set, it also removes the newest nodes beyond numNodes.
+func (c *Cluster) scale(ctx context.Context, numNodes int, prune bool) error {
+	nodes := c.Nodes()
+	for i := len(nodes); i < numNodes; i++ {
+		if _, err := c.AddNode(ctx, nil); err != nil {
+			return err
+		}
+	}
+	if len(nodes) > numNodes && !prune {
+		c.universe.log.Warn("cluster has more nodes than spec", "cluster", c.Name(), "nodes", len(nodes), "specNodes", numNodes)
+	}
+	for i := len(nodes) - 1; prune && i >= numNodes; i-- {
+		if err := c.RemoveNode(nodes[i].Hostname()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune destroys the VMs that spec doesn't describe and warns about
+// other extra resources. have is what the universe had before spec
+// was applied.
+func (u *Universe) prune(spec *Spec, have *universeResources) error {
+	want := map[string]bool{}
+	for _, vm := range spec.VMs {
+		want[vm.Name] = true
+	}
+	for name := range have.vms {
+		if want[name] || have.members[name] != "" {
+			continue
+		}
+		prog := u.progress(name, "pruning", 0)
+		if err := prog.done(u.destroyVM(name)); err != nil {
+			return fmt.Errorf("pruning VM %q: %v", name, err)
+		}
+	}
+
+	want = map[string]bool{}
+	for _, cluster := range spec.Clusters {
+		want[cluster.Name] = true
+	}
+	for name := range have.clusters {
+		if !want[name] {
+			u.log.Warn("can't prune cluster", "cluster", name)
+		}
+	}
+	want = map[string]bool{}
+	for _, net := range spec.Networks {
+		want[net.Name] = true
+	}
+	for name := range have.networks {
+		if !want[name] {
+			u.log.Warn("can't prune network", "network", name)
+		}
+	}
+	want = map[string]bool{}
+	for _, img := range spec.Images {
+		want[img.Name] = true
+	}
+	for name := range have.images {
+		if !want[name] {
+			u.log.Warn("can't prune image", "image", name)
+		}
+	}
+	return nil
+}
+
+// universeResources are the names of a universe's existing resources,
+// which specs are checked against.
+type universeResources struct {
+	images    map[string]bool
+	imageArch map[string]string
+	networks  map[string]bool
+	// vms are all the universe's VMs, members the names of the
+	// clusters that cluster members belong to.
+	vms      map[string]bool
+	members  map[string]string
+	clusters map[string]bool
+	nextNet  int
+}
+
+// resources returns the universe's existing resources. A nil u has
+// none.
+func (u *Universe) resources() *universeResources {
+	ret := &universeResources{
+		images:    map[string]bool{},
+		imageArch: map[string]string{},
+		networks:  map[string]bool{},
+		vms:       map[string]bool{},
+		members:   map[string]string{},
+		clusters:  map[string]bool{},
+	}
+	if u == nil {
+		return ret
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for name, img := range u.images {
+		ret.images[name] = true
+		ret.imageArch[name] = img.Arch
+	}
+	for name := range u.networks {
+		ret.networks[name] = true
+	}
+	for name := range u.vms {
+		ret.vms[name] = true
+	}
+	for name, cluster := range u.clusters {
+		ret.clusters[name] = true
+		for _, vm := range cluster.members() {
+			ret.members[vm.Hostname()] = name
+		}
+	}
+	ret.nextNet = u.nextNet
+	return ret
+}
+
+func (img *ImageSpec) imageConfig() *ImageConfig {
+	ret := &ImageConfig{Name: img.Name, Arch: img.Arch, Runtime: img.Runtime}
+	if img.InstallK8s {
+		ret.CustomizeFuncs = append(ret.CustomizeFuncs, CustomizeInstallK8s)
+	}
+	if img.PreloadK8sImages {
+		ret.CustomizeFuncs = append(ret.CustomizeFuncs, CustomizePreloadK8sImages)
+	}
+	if img.InstallBenchTools {
+		ret.CustomizeFuncs = append(ret.CustomizeFuncs, CustomizeInstallBenchTools)
+	}
+	if img.InstallCloudInit {
+		ret.CustomizeFuncs = append(ret.CustomizeFuncs, CustomizeInstallCloudInit)
+	}
+	if img.Script != "" {
+		ret.CustomizeFuncs = append(ret.CustomizeFuncs, CustomizeScript(img.Script))
+		// If the script can't be read, the image isn't cached, and
+		// the build fails anyway.
+		ret.CacheKey, _ = hashFile(img.Script)
+	}
+	return ret
+}
+
+// validate checks the spec, against u's existing resources if u is
+// non-nil. Spec resources that u already has are only checked for
+// consistency with the rest of the spec.
+func (s *Spec) validate(u *Universe) error {
+	have := u.resources()
+	images, networks, vms, clusters := map[string]bool{}, map[string]bool{}, map[string]bool{}, map[string]bool{}
+	imageArch := have.imageArch
+	// Names of the VMs that exist or will, to check that new ones
+	// don't collide.
+	taken := have.vms
+	newNets := 0
+
+	for _, img := range s.Images {
+		if img.Name == "" {
+			return errors.New("image with no name")
+		}
+		if images[img.Name] {
+			return fmt.Errorf("duplicate image %q", img.Name)
+		}
+		images[img.Name] = true
+		if have.images[img.Name] {
+			continue
+		}
+		imageArch[img.Name] = img.Arch
+		if err := validateArch(img.Arch); err != nil {
+			return fmt.Errorf("image %q: %v", img.Name, err)
+		}
+		if img.URL != "" {
+			if img.Import != "" || img.InstallK8s || img.PreloadK8sImages || img.InstallBenchTools || img.InstallCloudInit || img.Script != "" || img.Arch != "" {
+				return fmt.Errorf("image %q: downloaded images can't be customized", img.Name)
+			}
+			if err := validateSHA256(img.SHA256); err != nil {
+				return fmt.Errorf("image %q: %v", img.Name, err)
+			}
+		} else if img.SHA256 != "" {
+			return fmt.Errorf("image %q: SHA256 requires URL", img.Name)
+		}
+		if img.Import != "" {
+			if img.InstallK8s || img.PreloadK8sImages || img.InstallBenchTools || img.InstallCloudInit || img.Script != "" || img.Arch != "" {
+				return fmt.Errorf("image %q: imported images can't be customized", img.Name)
+			}
+			if _, err := os.Stat(img.Import); err != nil {
+				return fmt.Errorf("image %q: %v", img.Name, err)
+			}
+		}
+		if img.PreloadK8sImages && !img.InstallK8s {
+			return fmt.Errorf("image %q: PreloadK8sImages requires InstallK8s", img.Name)
+		}
+		if err := validateRuntime(img.Runtime); err != nil {
+			return fmt.Errorf("image %q: %v", img.Name, err)
+		}
+		if img.Runtime != "" && !img.InstallK8s {
+			return fmt.Errorf("image %q: Runtime requires InstallK8s", img.Name)
+		}
+		if img.Script != "" {
+			if _, err := os.Stat(img.Script); err != nil {
+				return fmt.Errorf("image %q: %v", img.Name, err)
+			}
+		}
+	}
+
+	for i := range s.Networks {
+		net := &s.Networks[i]
+		if net.Name == "" {
+			return errors.New("network with no name")
+		}
+		if networks[net.Name] {
+			return fmt.Errorf("duplicate network %q", net.Name)
+		}
+		networks[net.Name] = true
+		if have.networks[net.Name] {
+			continue
+		}
+		newNets++
+		if err := net.validate(); err != nil {
+			return fmt.Errorf("network %q: %v", net.Name, err)
+		}
+	}
+	if have.nextNet+newNets > maxNetworks {
+		return fmt.Errorf("too many networks, a universe can only hold %d", maxNetworks)
+	}
+
+	checkVM := func(cfg *VMConfig) error {
+		if cfg.Image == "" {
+			return errors.New("no image specified")
+		}
+		if !images[cfg.Image] && !have.images[cfg.Image] {
+			return fmt.Errorf("unknown image %q", cfg.Image)
+		}
+		for _, net := range cfg.Networks {
+			if !networks[net] && !have.networks[net] {
+				return fmt.Errorf("unknown network %q", net)
+			}
+		}
+		if u != nil {
+			return u.validateVMConfig(cfg, imageArch[cfg.Image])
+		}
+		if err := cfg.Disk.validate(); err != nil {
+			return err
+		}
+		return validateDisks(cfg.Disks)
+	}
+	addVM := func(name string) error {
+		if taken[name] {
+			return fmt.Errorf("duplicate VM %q", name)
+		}
+		taken[name] = true
+		return nil
+	}
+
+	// VMs that pinned addresses, by network and address.
+	pinned := map[string]string{}
+	for i := range s.VMs {
+		vm := &s.VMs[i]
+		if vm.Name == "" {
+			return errors.New("VM with no name")
+		}
+		if vms[vm.Name] {
+			return fmt.Errorf("duplicate VM %q", vm.Name)
+		}
+		vms[vm.Name] = true
+		if cluster := have.members[vm.Name]; cluster != "" {
+			return fmt.Errorf("VM %q is a member of cluster %q", vm.Name, cluster)
+		}
+		if have.vms[vm.Name] {
+			continue
+		}
+		if err := addVM(vm.Name); err != nil {
+			return err
+		}
+		if err := checkVM(vm); err != nil {
+			return fmt.Errorf("VM %q: %v", vm.Name, err)
+		}
+		for net, a := range vm.Addresses {
+			for _, addr := range a.addrs() {
+				if other := pinned[net+" "+addr]; other != "" {
+					return fmt.Errorf("VM %q: %s on network %q is already pinned by VM %q", vm.Name, addr, net, other)
+				}
+				pinned[net+" "+addr] = vm.Name
+				if u == nil {
+					continue
+				}
+				if nw := u.Network(net); nw != nil {
+					if owner := nw.claimant(addr); owner != "" {
+						return fmt.Errorf("VM %q: %s is already used by %s on network %q", vm.Name, addr, owner, net)
+					}
+				}
+			}
+		}
+	}
+
+	for i := range s.Clusters {
+		cluster := &s.Clusters[i]
+		if cluster.Name == "" {
+			return errors.New("cluster with no name")
+		}
+		if clusters[cluster.Name] {
+			return fmt.Errorf("duplicate cluster %q", cluster.Name)
+		}
+		clusters[cluster.Name] = true
+		if err := cluster.validate(); err != nil {
+			return fmt.Errorf("cluster %q: %v", cluster.Name, err)
+		}
+		if have.clusters[cluster.Name] {
+			// Scaled with the cluster's own node template, and
+			// new nodes get free names.
+			continue
+		}
+		if err := checkVM(cluster.VMConfig); err != nil {
+			return fmt.Errorf("cluster %q: %v", cluster.Name, err)
+		}
+		// Cluster VM names are derived from the cluster name, and
+		// must not collide with other VMs.
+		if err := addVM(cluster.Name + "-controller"); err != nil {
+			return fmt.Errorf("cluster %q: %v", cluster.Name, err)
+		}
+		if cluster.ControlPlaneNodes > 1 {
+			if err := addVM(cluster.Name + "-lb"); err != nil {
+				return fmt.Errorf("cluster %q: %v", cluster.Name, err)
+			}
+			for n := 2; n <= cluster.ControlPlaneNodes; n++ {
+				if err := addVM(fmt.Sprintf("%s-controller%d", cluster.Name, n)); err != nil {
+					return fmt.Errorf("cluster %q: %v", cluster.Name, err)
+				}
+			}
+		}
+		for n := 1; n <= cluster.NumNodes; n++ {
+			if err := addVM(fmt.Sprintf("%s-node%d", cluster.Name, n)); err != nil {
+				return fmt.Errorf("cluster %q: %v", cluster.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package virtuakube
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeyFile is the universe's SSH private key, in OpenSSH format so
+// that ssh -i can use it. The public key is next to it, with a .pub
+// suffix.
+const sshKeyFile = "id_ed25519"
+
+// ensureSSHKey returns the SSH key of the universe in dir, generating
+// it if the universe doesn't have one yet.
+func ensureSSHKey(dir string) (ssh.Signer, error) {
+	signer, err := readSSHKey(dir)
+	if err == nil || !os.IsNotExist(err) {
+		return signer, err
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err = ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	comment := "virtuakube@" + filepath.Base(dir)
+	path := filepath.Join(dir, sshKeyFile)
+	if err := ioutil.WriteFile(path, marshalOpenSSHKey(pub, priv, comment), 0600); err != nil {
+		return nil, fmt.Errorf("writing SSH key: %v", err)
+	}
+	authorized := bytes.TrimSpace(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	authorized = append(authorized, []byte(" "+comment+"\n")...)
+	if err := ioutil.WriteFile(path+".pub", authorized, 0644); err != nil {
+		return nil, fmt.Errorf("writing SSH key: %v", err)
+	}
+	return signer, nil
+}
+
+// readSSHKey reads the SSH key of the universe in dir.
+func readSSHKey(dir string) (ssh.Signer, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, sshKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(bs)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH key: %v", err)
+	}
+	return signer, nil
+}
+
+// marshalOpenSSHKey encodes an unencrypted ed25519 key in OpenSSH's
+// private key format, see PROTOCOL.key in OpenSSH.
+func marshalOpenSSHKey(pub ed25519.PublicKey, priv ed25519.PrivateKey, comment string) []byte {
+	var check [4]byte
+	rand.Read(check[:])
+	checkint := binary.BigEndian.Uint32(check[:])
+
+	block := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+	}{checkint, checkint, ssh.KeyAlgoED25519, pub, priv, comment})
+	for i := byte(1); len(block)%8 != 0; i++ {
+		block = append(block, i)
+	}
+
+	wirePub := ssh.Marshal(struct {
+		Keytype string
+		Pub     []byte
+	}{ssh.KeyAlgoED25519, pub})
+	key := append([]byte("openssh-key-v1\x00"), ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, wirePub, block})...)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: key})
+}
+
+// vmSSHConfig returns the SSH client configuration for VMs, which
+// authenticates with the universe's key. VMs only learn the key once
+// they first boot in the universe, so images' root password remains a
+// fallback.
+func vmSSHConfig(key ssh.Signer) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key), ssh.Password("root")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	}
+}
+
+// validateAuthorizedKeys checks that keys are SSH public keys in
+// authorized_keys format.
+func validateAuthorizedKeys(keys []string) error {
+	for i, key := range keys {
+		if strings.Contains(strings.TrimSpace(key), "\n") {
+			return fmt.Errorf("authorized key %d has more than one line", i+1)
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+			return fmt.Errorf("authorized key %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// installAuthorizedKeys makes the universe's key and the VM's
+// configured keys the ones that can log in as root.
+func (v *VM) installAuthorizedKeys() error {
+	var buf bytes.Buffer
+	buf.Write(ssh.MarshalAuthorizedKey(v.universe.sshKey.PublicKey()))
+	for _, key := range v.cfg.AuthorizedKeys {
+		buf.WriteString(strings.TrimSpace(key) + "\n")
+	}
+	if _, err := v.Run("mkdir -p -m 0700 /root/.ssh"); err != nil {
+		return err
+	}
+	return v.WriteFile("/root/.ssh/authorized_keys", buf.Bytes())
+}
+
+// SSHConfigEntry returns an ssh_config block for the VM, to append to
+// ~/.ssh/config so that plain ssh, scp and rsync reach the VM by name,
+// e.g. "ssh node1". The entry uses the VM's current forwarded SSH port
+// and the universe's key, and turns off host key checking, since the
+// VM's host key changes whenever the VM is recreated.
+func (v *VM) SSHConfigEntry() string {
+	return sshConfigEntry(v.cfg.Name, v.ForwardedPort(22), v.universe.dir)
+}
+
+func sshConfigEntry(vm string, port int, dir string) string {
+	return fmt.Sprintf(`Host %s
+  HostName 127.0.0.1
+  Port %d
+  User root
+  IdentityFile %s
+  IdentitiesOnly yes
+  StrictHostKeyChecking no
+  UserKnownHostsFile /dev/null
+  LogLevel ERROR
+`, vm, port, filepath.Join(dir, sshKeyFile))
+}
+
+// SSHConfigEntry returns an ssh_config block for the named VM, as
+// VM.SSHConfigEntry does.
+func (r *RemoteUniverse) SSHConfigEntry(vm string) (string, error) {
+	st, err := r.Status()
+	if err != nil {
+		return "", err
+	}
+	for _, v := range st.VMs {
+		if v.Name == vm {
+			return sshConfigEntry(vm, v.Ports[22], r.dir), nil
+		}
+	}
+	return "", fmt.Errorf("universe doesn't have a VM named %q", vm)
+}
+
+// sshKey returns the SSH key of the remote universe.
+func (r *RemoteUniverse) sshKey() (ssh.Signer, error) {
+	key, err := readSSHKey(r.dir)
+	if os.IsNotExist(err) {
+		return nil, errors.New("universe has no SSH key")
+	}
+	return key, err
+}
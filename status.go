@@ -0,0 +1,208 @@
+package virtuakube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UniverseStatus is a point-in-time summary of a running universe.
+type UniverseStatus struct {
+	Dir      string
+	PID      int
+	Snapshot string
+	Started  time.Time
+	// DNS is the localhost address of the universe's DNS server, if
+	// it has one.
+	DNS string
+	// Metrics is the address of the universe's Prometheus metrics
+	// endpoint, if it has one.
+	Metrics  string
+	Networks []NetworkStatus
+	VMs      []VMStatus
+	Clusters []ClusterStatus
+	Events   []Event
+}
+
+// NetworkStatus is a point-in-time summary of a network.
+type NetworkStatus struct {
+	Name       string
+	IPv4Subnet string
+	IPv6Subnet string
+	MTU        int
+}
+
+// VMStatus is a point-in-time summary of a VM.
+type VMStatus struct {
+	Name     string
+	Networks []string
+	IPv4     map[string]string
+	IPv6     map[string]string
+	// LAN is the VM's address on the host's LAN, for bridged VMs.
+	LAN string
+	// Ports maps VM ports to the localhost ports that forward to
+	// them.
+	Ports map[int]int
+	// ConsoleSocket is the path of the Unix socket connected to the
+	// VM's serial console.
+	ConsoleSocket string
+	// ConsoleLog is the path of the file that captures the VM's
+	// serial console output.
+	ConsoleLog string
+	// MemoryMiB is the VM's configured memory, and RSSBytes the host
+	// memory its QEMU process actually uses.
+	MemoryMiB int
+	RSSBytes  int64
+	// CPUs is the VM's number of vCPUs.
+	CPUs int
+	// CPUTime is the host CPU time used by the VM's QEMU process.
+	CPUTime time.Duration
+	// Paused is whether the VM was paused with Pause.
+	Paused bool
+	// BalloonMiB is the memory the VM has been ballooned down to with
+	// SetMemory, or zero if it has all of MemoryMiB.
+	BalloonMiB int
+	// ClockOffset is how far ahead of the universe's clock the VM's
+	// clock runs, see VM.SetClockOffset.
+	ClockOffset time.Duration
+	// Display is the URL of the VM's remote display, if the universe
+	// serves them, see VM.Display.
+	Display string
+}
+
+// ClusterStatus is a point-in-time summary of a cluster.
+type ClusterStatus struct {
+	Name       string
+	Kubeconfig string
+	Controller string
+	Nodes      []string
+	// ControlPlanes and LoadBalancer are only set for HA clusters.
+	ControlPlanes []string
+	LoadBalancer  string
+}
+
+// Status returns a summary of the universe's current resources.
+func (u *Universe) Status() *UniverseStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.statusWithLock()
+}
+
+func (u *Universe) statusWithLock() *UniverseStatus {
+	ret := &UniverseStatus{
+		Dir:      u.dir,
+		PID:      os.Getpid(),
+		Snapshot: u.activeSnapshot,
+		Started:  u.startTime,
+		Events:   u.Events(),
+		DNS:      u.DNSAddr(),
+		Metrics:  u.MetricsAddr(),
+	}
+
+	for _, n := range u.networks {
+		ret.Networks = append(ret.Networks, NetworkStatus{
+			Name:       n.Name(),
+			IPv4Subnet: n.IPv4Subnet().String(),
+			IPv6Subnet: n.IPv6Subnet().String(),
+			MTU:        n.MTU(),
+		})
+	}
+	sort.Slice(ret.Networks, func(i, j int) bool { return ret.Networks[i].Name < ret.Networks[j].Name })
+
+	for _, vm := range u.vms {
+		st := VMStatus{
+			Name:     vm.Hostname(),
+			Networks: vm.Networks(),
+			IPv4:     map[string]string{},
+			IPv6:     map[string]string{},
+			Ports:    map[int]int{},
+
+			ConsoleSocket: vm.ConsoleSocket(),
+			ConsoleLog:    vm.ConsoleLog(),
+			MemoryMiB:     vm.cfg.MemoryMiB,
+			CPUs:          vm.CPUs(),
+			Paused:        vm.Paused(),
+			BalloonMiB:    vm.balloonMiB(),
+			ClockOffset:   vm.ClockOffset(),
+			Display:       vm.Display(),
+		}
+		// Usage is best effort, the VM may be exiting.
+		st.RSSBytes, st.CPUTime, _ = procUsage(vm.cmd.Process.Pid)
+		for _, net := range st.Networks {
+			st.IPv4[net] = vm.IPv4(net).String()
+			st.IPv6[net] = vm.IPv6(net).String()
+		}
+		vm.mu.Lock()
+		for dst, src := range vm.cfg.PortForwards {
+			st.Ports[dst] = src
+		}
+		vm.mu.Unlock()
+		if ip := vm.LANAddress(); ip != nil {
+			st.LAN = ip.String()
+		}
+		ret.VMs = append(ret.VMs, st)
+	}
+	sort.Slice(ret.VMs, func(i, j int) bool { return ret.VMs[i].Name < ret.VMs[j].Name })
+
+	for _, cluster := range u.clusters {
+		st := ClusterStatus{
+			Name:       cluster.Name(),
+			Kubeconfig: cluster.Kubeconfig(),
+			Controller: cluster.Controller().Hostname(),
+		}
+		for _, node := range cluster.Nodes() {
+			st.Nodes = append(st.Nodes, node.Hostname())
+		}
+		if lb := cluster.LoadBalancer(); lb != nil {
+			st.LoadBalancer = lb.Hostname()
+			for _, cp := range cluster.ControlPlanes() {
+				st.ControlPlanes = append(st.ControlPlanes, cp.Hostname())
+			}
+		}
+		ret.Clusters = append(ret.Clusters, st)
+	}
+	sort.Slice(ret.Clusters, func(i, j int) bool { return ret.Clusters[i].Name < ret.Clusters[j].Name })
+
+	return ret
+}
+
+// userHZ is the unit of CPU times in /proc, which Linux fixes at 100
+// for userspace.
+const userHZ = 100
+
+// procUsage returns the resident memory and the CPU time used by pid.
+func procUsage(pid int) (rss int64, cpu time.Duration, err error) {
+	bs, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	// As in procStartTime, skip past the command name.
+	s := string(bs)
+	idx := strings.LastIndexByte(s, ')')
+	if idx < 0 {
+		return 0, 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fs := strings.Fields(s[idx+1:])
+	// utime, stime and rss are fields 14, 15 and 24 of stat, and
+	// s[idx+1:] starts at field 3.
+	if len(fs) < 22 {
+		return 0, 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	var ticks int64
+	for _, f := range fs[11:13] {
+		n, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("malformed /proc/%d/stat: %v", pid, err)
+		}
+		ticks += n
+	}
+	pages, err := strconv.ParseInt(fs[21], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed /proc/%d/stat: %v", pid, err)
+	}
+	return pages * int64(os.Getpagesize()), time.Duration(ticks) * time.Second / userHZ, nil
+}
@@ -0,0 +1,64 @@
+package virtuakube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// tmpfsRoot is where tmpfs-backed VM disks live. /dev/shm is a tmpfs
+// on all mainstream Linux distros.
+const tmpfsRoot = "/dev/shm"
+
+// diskPath returns the host path of a VM disk file. Disks normally
+// live in the universe directory, but tmpfs disks have absolute
+// paths.
+func (u *Universe) diskPath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(u.dir, file)
+}
+
+// tmpfsDiskWithLock returns a path for a new tmpfs-backed disk.
+func (u *Universe) tmpfsDiskWithLock() (string, error) {
+	if u.tmpfsDir == "" {
+		if _, err := os.Stat(tmpfsRoot); err != nil {
+			return "", fmt.Errorf("tmpfs disks need %s: %v", tmpfsRoot, err)
+		}
+		dir, err := ioutil.TempDir(tmpfsRoot, "virtuakube")
+		if err != nil {
+			return "", fmt.Errorf("creating tmpfs disk directory: %v", err)
+		}
+		u.tmpfsDir = dir
+	}
+	return filepath.Join(u.tmpfsDir, randomDiskName()), nil
+}
+
+// checkSnapshottableWithLock returns an error if the universe
+// contains resources that can't be saved.
+func (u *Universe) checkSnapshottableWithLock() error {
+	for name, vm := range u.vms {
+		select {
+		case <-vm.stopped:
+			return fmt.Errorf("VM %q isn't running, universes with crashed or killed VMs can't be saved", name)
+		default:
+		}
+		if vm.cfg.TmpfsDisk {
+			return fmt.Errorf("VM %q has a tmpfs disk, universes with tmpfs-backed VMs can't be saved", name)
+		}
+		if len(vm.cfg.Mounts) > 0 {
+			return fmt.Errorf("VM %q has host mounts, universes with VMs that have host mounts can't be saved", name)
+		}
+		if len(vm.cfg.Devices) > 0 {
+			return fmt.Errorf("VM %q has passthrough devices, universes with passthrough devices can't be saved", name)
+		}
+		for _, d := range vm.cfg.Disks {
+			if d.Format == "raw" {
+				return fmt.Errorf("VM %q has a raw disk, universes with raw disks can't be saved", name)
+			}
+		}
+	}
+	return nil
+}
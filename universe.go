@@ -3,11 +3,12 @@ package virtuakube
 import (
 	"context"
 	"crypto/rand"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 	"time"
 
 	"go.universe.tf/virtuakube/internal/config"
+	"golang.org/x/crypto/ssh"
 )
 
 var universeTools = []string{
@@ -39,7 +41,7 @@ func checkTools(tools []string) error {
 		}
 	}
 	if len(missing) > 0 {
-		return fmt.Errorf("required tools missing: %s", strings.Join(missing, ", "))
+		return fmt.Errorf("%w: %s", ErrToolsMissing, strings.Join(missing, ", "))
 	}
 	return nil
 }
@@ -47,18 +49,129 @@ func checkTools(tools []string) error {
 // UniverseConfig contains the ephemeral runtime settings for the
 // universe. A nil UniverseConfig is equivalent to the zero value.
 type UniverseConfig struct {
-	// If non-nil, all commands executed on VMs and during image
-	// building are logged to this writer, along with their
-	// stdout/stderr.
+	// If non-nil, receives structured logs of the commands executed
+	// on VMs and on the host, their output, and the universe's
+	// events. Records about a VM or cluster have a "vm" or "cluster"
+	// attribute, commands have "command", and finished commands have
+	// "duration". Command output is logged line by line at
+	// slog.LevelDebug.
+	Logger *slog.Logger
+	// If non-nil and Logger is nil, all commands executed on VMs and
+	// during image building are logged to this writer as plain text,
+	// along with their stdout/stderr.
 	CommandLog io.Writer
 	// Whether VMs should have a GUI. Useful for debugging Virtuakube
 	// itself.
+	//
+	// Deprecated: set Display to DisplayLocal.
 	VMGraphics bool
+	// Display is how VMs show their screen: DisplayNone (the
+	// default), DisplayLocal for a window per VM on the host, or
+	// DisplayVNC or DisplaySPICE to serve each VM's display on a
+	// localhost port, which works on headless hosts too. See
+	// VM.Display.
+	Display string
 	// Make subprocesses immune to ^C, to enable interactive control.
 	Interactive bool
 	// Don't use any privileged hardware acceleration for VMs. Will
 	// run much slower, but 100% in userspace.
 	NoAcceleration bool
+	// If non-zero, save a rolling snapshot of the running universe
+	// at this interval, as a safety net for long sessions. Auto
+	// snapshots are named "auto-<timestamp>", and can be resumed
+	// with Open like any other snapshot. VMs stop for as long as
+	// they take to save, and VMs with a guest agent have their
+	// filesystems frozen, so that their disks are clean.
+	AutoSnapshotInterval time.Duration
+	// The number of auto snapshots to keep. Older auto snapshots are
+	// deleted as new ones are taken. Zero means 3.
+	AutoSnapshotKeep int
+	// ImageCache is a directory shared by all the universes that use
+	// it, where ImportImage keeps imported images by content,
+	// NewImage keeps built images by their ImageConfig, and
+	// downloads are kept by URL, so that each universe doesn't
+	// import, build or download them again. Universes hard link the
+	// cached images instead of copying them, so the cache should be
+	// on the same filesystem as universe directories. Cached files
+	// are never deleted by virtuakube, images with a link count of 1
+	// are unused.
+	//
+	// ImageCache defaults to virtuakube in the user's cache
+	// directory, e.g. ~/.cache/virtuakube.
+	ImageCache string
+	// NoImageCache turns off the image cache.
+	NoImageCache bool
+	// BootTimeout is how long VMs get to boot and answer SSH, after
+	// which starting them fails with ErrBootTimeout. Zero means 10
+	// minutes.
+	BootTimeout time.Duration
+	// Upstream configures the proxies and mirrors that guests use to
+	// reach the internet, when building images and setting up
+	// clusters. VMs are configured when they start, see
+	// UpstreamConfig.
+	Upstream *UpstreamConfig
+	// Offline makes the universe work without internet access, from
+	// the image cache and VM images only. Building or downloading
+	// images that aren't in the cache, and starting clusters on
+	// images that lack their Kubernetes version, container runtime
+	// or container images, fail with a MissingArtifactsError that
+	// lists what's missing. See Prefetch.
+	Offline bool
+	// If non-zero, resize the memory of VMs at this interval to fit
+	// what their guests are using, returning idle memory to the host
+	// so it can run more VMs. See VM.SetMemory.
+	AutoBalloonInterval time.Duration
+	// The API server host to write into the cluster kubeconfigs that
+	// Save exports to the universe directory, and to include in new
+	// clusters' API server certificates. Defaults to 127.0.0.1. It
+	// must be an IP address of the host: for anything but
+	// 127.0.0.1, the API server port forwards are relayed from the
+	// same ports on that address, so that the kubeconfigs work.
+	KubeconfigHost string
+	// If non-empty, the path of a kubeconfig, usually ~/.kube/config,
+	// that the contexts of the universe's clusters are merged into
+	// as they start or resume, and removed from when the universe
+	// closes. See Cluster.WriteKubeconfigContext.
+	MergeKubeconfig string
+	// If non-empty, Run saves the universe to this snapshot when its
+	// function fails, instead of discarding the universe.
+	SaveOnError string
+	// If true, serve DNS for the universe on a localhost port, so
+	// that the host can resolve VM and cluster names. See
+	// Universe.DNSAddr and DNSStub.
+	DNS bool
+	// Network is the networking mode of VMs created in this run of
+	// the universe, NetworkUser (the default) or NetworkBridged.
+	// Bridged VMs stay bridged when the universe is resumed.
+	Network string
+	// Bridge is the host bridge that bridged VMs attach to, e.g.
+	// "br0". qemu-bridge-helper must allow it in
+	// /etc/qemu/bridge.conf.
+	Bridge string
+	// MetricsAddr, if set, is the address to serve Prometheus metrics
+	// for the universe on, at /metrics, e.g. "127.0.0.1:9100". Use
+	// port 0 to pick a free port, and Universe.MetricsAddr to find
+	// it.
+	MetricsAddr string
+	// If true, and the universe's previous process died leaving its
+	// VMs and networks running, Open kills them and takes over the
+	// universe, instead of failing. A universe that's open in a live
+	// process is never taken over.
+	Force bool
+}
+
+// validate checks cfg for errors that don't depend on the universe.
+func (cfg *UniverseConfig) validate() error {
+	if err := cfg.validateNetwork(); err != nil {
+		return err
+	}
+	if err := cfg.validateDisplay(); err != nil {
+		return err
+	}
+	if cfg.KubeconfigHost != "" && net.ParseIP(cfg.KubeconfigHost) == nil {
+		return fmt.Errorf("KubeconfigHost %q must be an IP address", cfg.KubeconfigHost)
+	}
+	return cfg.Upstream.validate()
 }
 
 // A Universe is a virtual sandbox and its associated resources.
@@ -68,6 +181,14 @@ type Universe struct {
 
 	tmpdir string
 
+	// The universe lock, held until the universe is closed. See
+	// lockUniverse.
+	lock *os.File
+
+	// Directory in tmpfs for tmpfs-backed VM disks, created on
+	// demand.
+	tmpfsDir string
+
 	// Channel that gets closed right at the end of Close, Destroy, or
 	// Save. Wait waits for this channel to get closed.
 	closedCh chan bool
@@ -76,6 +197,32 @@ type Universe struct {
 	// universe. Not persisted after Close.
 	runtimecfg *UniverseConfig
 
+	// Where to log, from runtimecfg.Logger or runtimecfg.CommandLog.
+	log *slog.Logger
+
+	// The hypervisor that runs VMs and networks, and the QEMU
+	// installation used to run VMs.
+	backend backend
+	qemu    *QEMUInfo
+
+	// The universe's SSH key, which virtuakube logs in to VMs with.
+	sshKey ssh.Signer
+
+	// Listener for the control socket, which lets other processes
+	// attach to the running universe.
+	control net.Listener
+
+	// Listeners of the DNS server, if UniverseConfig.DNS is set.
+	dnsUDP net.PacketConn
+	dnsTCP net.Listener
+
+	// Listener of the metrics endpoint, if UniverseConfig.MetricsAddr
+	// is set.
+	metrics net.Listener
+	// Durations of slow operations, for the metrics endpoint. Has
+	// its own lock.
+	timings timings
+
 	// Must hold this mutex to touch any of the following.
 	mu sync.Mutex
 
@@ -89,6 +236,10 @@ type Universe struct {
 	nextPort int
 	nextNet  int
 
+	// Localhost ports allocated to VM port forwards, across all
+	// snapshots, keyed by portKey.
+	ports map[string]int
+
 	// Name of the currently running snapshot.
 	activeSnapshot string
 
@@ -100,20 +251,61 @@ type Universe struct {
 	// Resources in the universe: a virtual switch, some VMs, some k8s
 	// clusters.
 	networks map[string]*Network
-	images   map[string]string // name -> diskpath
+	images   map[string]*config.Image
 	vms      map[string]*VM
 	clusters map[string]*Cluster
+	// Container registries, by name.
+	registries map[string]*Registry
 
 	// Records any close errors, so we can do concurrent-safe
 	// shutdown.
 	closed   bool
 	closeErr error
+
+	// All subprocesses running VMs and networks. Has its own lock so
+	// that Kill can reach them while a long operation holds mu.
+	procsMu sync.Mutex
+	procs   map[*os.Process]bool
+	// Set by Kill, so that dying VMs aren't reported as crashes.
+	kill bool
+	// Set once the universe has removed its host-wide registration.
+	unregistered bool
+
+	// Recent events that happened in the universe since Open, and
+	// channels subscribed to new events.
+	eventsMu    sync.Mutex
+	events      []Event
+	subscribers map[chan Event]bool
+
+	// Contexts merged into runtimecfg.MergeKubeconfig, which must be
+	// removed on close. Has its own lock so that clusters can merge
+	// their contexts while holding only their own lock.
+	kubeconfigMu   sync.Mutex
+	mergedContexts map[string]bool
+
+	// Serializes checkpoints, which only hold u.mu while they read
+	// and record configs. See checkpoint.
+	checkpointMu sync.Mutex
+
+	// Clusters with connected pod networks, by name. Has its own
+	// lock so that clusters can connect while holding only their own
+	// lock.
+	podPeersMu sync.Mutex
+	podPeers   map[string]*podPeer
 }
 
 // Create creates a new empty Universe in dir. The directory must not
 // already exist.
-func Create(dir string, runtimecfg *UniverseConfig) (*Universe, error) {
+func Create(ctx context.Context, dir string, runtimecfg *UniverseConfig) (*Universe, error) {
+	// Open would catch bad configs too, but only after dir exists.
+	if runtimecfg != nil {
+		if err := runtimecfg.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	cfg := &config.Universe{
+		ID: randomUniverseID(),
 		Snapshots: map[string]*config.Snapshot{
 			"": {
 				Name:     "",
@@ -139,64 +331,162 @@ func Create(dir string, runtimecfg *UniverseConfig) (*Universe, error) {
 		return nil, err
 	}
 
-	return Open(dir, "", runtimecfg)
+	if _, err := ensureSSHKey(dir); err != nil {
+		return nil, err
+	}
+
+	return Open(ctx, dir, "", runtimecfg)
 }
 
 // Open opens the existing Universe in dir, and resumes from snapshot.
-func Open(dir string, snapshot string, runtimecfg *UniverseConfig) (*Universe, error) {
-	if err := checkTools(universeTools); err != nil {
+//
+// A universe can only be open in one process at a time. Open fails if
+// another process has it open, or if a process that had it open died
+// and left its VMs running, unless UniverseConfig.Force is set.
+//
+// If ctx is canceled before the universe is up, Open kills the VMs
+// and networks it started, closes the universe, and returns
+// ctx.Err().
+func Open(ctx context.Context, dir string, snapshot string, runtimecfg *UniverseConfig) (*Universe, error) {
+	if runtimecfg == nil {
+		runtimecfg = &UniverseConfig{}
+	}
+	if err := runtimecfg.validate(); err != nil {
 		return nil, err
 	}
+	hypervisor := runtimecfg.backend()
 
-	dir, err := filepath.Abs(dir)
+	if err := checkTools(hypervisor.tools()); err != nil {
+		return nil, err
+	}
+
+	qemu, err := DetectQEMU()
+	if err != nil {
+		return nil, fmt.Errorf("detecting QEMU: %v", err)
+	}
+
+	dir, err = filepath.Abs(dir)
 	if err != nil {
 		return nil, err
 	}
 
-	if runtimecfg == nil {
-		runtimecfg = &UniverseConfig{}
+	lock, err := lockUniverse(dir, runtimecfg.Force)
+	if err != nil {
+		return nil, err
 	}
 
 	cfgPath := filepath.Join(dir, "config.json")
 	cfg, err := config.Read(cfgPath)
 	if err != nil {
+		lock.Close()
 		return nil, fmt.Errorf("reading universe config: %v", err)
 	}
 
+	// Universes created before universe IDs existed get one now,
+	// which sticks once the universe is saved.
+	if cfg.ID == "" {
+		cfg.ID = randomUniverseID()
+	}
+
+	// Likewise for SSH keys. Their VMs learn the key when they boot.
+	sshKey, err := ensureSSHKey(dir)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
 	snap := cfg.Snapshots[snapshot]
 	if snap == nil {
-		return nil, fmt.Errorf("no snapshot %q in universe", snapshot)
+		lock.Close()
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, snapshot)
+	}
+	// Resources mutate their configs as the universe runs, and those
+	// changes must not leak back into the saved snapshot unless the
+	// universe gets saved.
+	snap, err = config.Copy(snap)
+	if err != nil {
+		lock.Close()
+		return nil, err
 	}
 
 	tmpdir, err := ioutil.TempDir(dir, "tmp")
 	if err != nil {
+		lock.Close()
 		return nil, fmt.Errorf("creating temporary directory: %v", err)
 	}
 
 	ret := &Universe{
 		dir:            dir,
 		tmpdir:         tmpdir,
+		lock:           lock,
 		closedCh:       make(chan bool),
 		cfg:            cfg,
 		runtimecfg:     runtimecfg,
+		log:            newLogger(runtimecfg),
+		backend:        hypervisor,
+		qemu:           qemu,
+		sshKey:         sshKey,
 		nextPort:       snap.NextPort,
 		nextNet:        snap.NextNet,
+		ports:          loadPortHistory(cfg, snap),
 		activeSnapshot: snapshot,
 		startTime:      time.Now(),
 		networks:       map[string]*Network{},
-		images:         map[string]string{},
+		images:         map[string]*config.Image{},
 		vms:            map[string]*VM{},
 		clusters:       map[string]*Cluster{},
+		registries:     map[string]*Registry{},
+		procs:          map[*os.Process]bool{},
+		subscribers:    map[chan Event]bool{},
+		mergedContexts: map[string]bool{},
+		podPeers:       map[string]*podPeer{},
 	}
 
+	ret.updateRegistry()
+
+	// Killing the universe makes whatever step of resuming is in
+	// progress fail.
+	stop := context.AfterFunc(ctx, ret.Kill)
+	err = ret.thaw(ctx, snap)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		ret.Close()
+		return nil, err
+	}
+
+	if runtimecfg.AutoSnapshotInterval > 0 {
+		go ret.autoSnapshot(runtimecfg.AutoSnapshotInterval)
+	}
+	if runtimecfg.AutoBalloonInterval > 0 {
+		go ret.autoBalloon(runtimecfg.AutoBalloonInterval)
+	}
+
+	for _, cluster := range ret.clusters {
+		if err := cluster.relayAPIServer(); err != nil {
+			ret.Close()
+			return nil, err
+		}
+		if err := ret.mergeKubeconfig(cluster); err != nil {
+			ret.Close()
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// thaw brings up the resources of snap in the newly opened universe.
+func (u *Universe) thaw(ctx context.Context, snap *config.Snapshot) error {
 	for _, img := range snap.Images {
-		ret.images[img.Name] = img.File
+		u.images[img.Name] = img
 	}
 
 	// thaw networks first, because VMs need them.
 	for _, net := range snap.Networks {
-		if err := ret.resumeNetwork(net); err != nil {
-			return nil, err
+		if err := u.resumeNetwork(net); err != nil {
+			return err
 		}
 	}
 
@@ -207,45 +497,77 @@ func Open(dir string, snapshot string, runtimecfg *UniverseConfig) (*Universe, e
 	// TODO: this isn't actually parallel because we lock the universe
 	// in each resumeVM call *headdesk*
 	vms := snap.VMs
+	prog := u.progress("universe", "resuming VMs", int64(len(vms)))
 	res := make(chan error, len(vms))
 	for _, vmcfg := range vms {
 		go func(vmcfg *config.VM) {
-			_, err := ret.resumeVM(vmcfg)
-			if err != nil {
+			if err := ctx.Err(); err != nil {
 				res <- err
 				return
 			}
-
-			res <- nil
+			_, err := u.resumeVM(vmcfg)
+			res <- err
 		}(vmcfg)
 	}
-	for range vms {
-		if err := <-res; err != nil {
-			return nil, err
+	// All resumes must finish before returning, even on failure, so
+	// that closing the universe catches every VM.
+	var resumeErr error
+	for i := 0; i < len(vms); i++ {
+		if err := <-res; err != nil && resumeErr == nil {
+			resumeErr = err
 		}
+		prog.update(int64(i + 1))
+	}
+	if err := prog.done(resumeErr); err != nil {
+		return err
 	}
 
 	// Now that the expensive load is done, blow through all VMs and
 	// restart their CPUs in rapid succession, to keep the clock skew
 	// between VMs minimal.
-	for _, vm := range ret.vms {
+	prog = u.progress("universe", "restarting VMs", int64(len(u.vms)))
+	booted := int64(0)
+	for _, vm := range u.vms {
 		if err := vm.boot(); err != nil {
-			return nil, err
+			return prog.done(err)
 		}
+		u.emit(EventVMReady, vm.cfg.Name, "")
+		booted++
+		prog.update(booted)
 	}
+	prog.done(nil)
 
 	// Thaw all cluster objects, now that the cluster VMs are running.
 	for _, clusterCfg := range snap.Clusters {
-		if err := ret.resumeCluster(clusterCfg); err != nil {
-			return nil, err
+		if err := u.resumeCluster(clusterCfg); err != nil {
+			return err
+		}
+	}
+	for _, registryCfg := range snap.Registries {
+		if err := u.resumeRegistry(registryCfg); err != nil {
+			return err
 		}
 	}
 
-	return ret, nil
+	if u.runtimecfg.DNS {
+		if err := u.serveDNS(); err != nil {
+			return err
+		}
+	}
+
+	if u.runtimecfg.MetricsAddr != "" {
+		if err := u.serveMetrics(u.runtimecfg.MetricsAddr); err != nil {
+			return err
+		}
+	}
+
+	return u.serveControl()
 }
 
 // Close closes the universe, discarding all changes since the last
-// call to Save.
+// call to Save. Unlike the universe's other long operations, Close
+// has no context: it only kills processes and removes files, and it
+// must run to completion to clean up after a canceled operation.
 func (u *Universe) Close() error {
 	u.mu.Lock()
 	defer u.mu.Unlock()
@@ -254,6 +576,7 @@ func (u *Universe) Close() error {
 	}
 
 	u.closeWithLock()
+	u.unlock()
 	close(u.closedCh)
 	return u.closeErr
 }
@@ -263,6 +586,15 @@ func (u *Universe) closeWithLock() {
 	// to check that.
 	u.closed = true
 
+	u.stopControl()
+	u.stopDNS()
+	u.stopMetrics()
+	defer u.unregister()
+
+	if err := u.unmergeKubeconfigs(); err != nil {
+		u.closeErr = err
+	}
+
 	for _, vm := range u.vms {
 		if err := vm.Close(); err != nil {
 			u.closeErr = err
@@ -277,17 +609,21 @@ func (u *Universe) closeWithLock() {
 
 	snap := u.cfg.Snapshots[u.activeSnapshot]
 
-	for name, path := range u.images {
+	for name, img := range u.images {
 		if snap.Images[name] == nil {
-			if err := os.Remove(filepath.Join(u.dir, path)); err != nil {
-				u.closeErr = err
+			for _, file := range img.Files() {
+				if err := os.Remove(filepath.Join(u.dir, file)); err != nil {
+					u.closeErr = err
+				}
 			}
 		}
 	}
 	for name, vm := range u.vms {
 		if snap.VMs[name] == nil {
-			if err := os.Remove(filepath.Join(u.dir, vm.cfg.DiskFile)); err != nil {
-				u.closeErr = err
+			for _, file := range vm.cfg.Files() {
+				if err := os.Remove(u.diskPath(file)); err != nil {
+					u.closeErr = err
+				}
 			}
 		}
 	}
@@ -295,6 +631,54 @@ func (u *Universe) closeWithLock() {
 	if err := os.RemoveAll(u.tmpdir); err != nil {
 		u.closeErr = err
 	}
+	if u.tmpfsDir != "" {
+		if err := os.RemoveAll(u.tmpfsDir); err != nil {
+			u.closeErr = err
+		}
+	}
+}
+
+// Kill immediately kills all VMs and networks in the universe,
+// without waiting for in-flight operations (such as a Save) to
+// finish. It's meant for emergency shutdown, e.g. when a user
+// insists on interrupting a stuck operation. The universe is unusable
+// afterwards, and an interrupted Save may leave the snapshot being
+// saved corrupted.
+func (u *Universe) Kill() {
+	u.procsMu.Lock()
+	defer u.procsMu.Unlock()
+	u.kill = true
+	for proc := range u.procs {
+		proc.Kill()
+	}
+}
+
+// killed returns whether Kill was called, or v was aborted.
+func (u *Universe) killed(v *VM) bool {
+	u.procsMu.Lock()
+	defer u.procsMu.Unlock()
+	return u.kill || v.aborted
+}
+
+// trackProcess records proc as a subprocess of the universe, until
+// done is closed.
+func (u *Universe) trackProcess(proc *os.Process, done chan bool) {
+	u.procsMu.Lock()
+	u.procs[proc] = true
+	if u.kill {
+		// Started while Kill was running, e.g. by a resume that
+		// Open is about to abort.
+		proc.Kill()
+	}
+	u.procsMu.Unlock()
+	u.updateRegistry()
+	go func() {
+		<-done
+		u.procsMu.Lock()
+		delete(u.procs, proc)
+		u.procsMu.Unlock()
+		u.updateRegistry()
+	}()
 }
 
 // Destroy closes the universe and recursively deletes the universe
@@ -311,6 +695,7 @@ func (u *Universe) Destroy() error {
 	if err := os.RemoveAll(u.dir); err != nil {
 		u.closeErr = err
 	}
+	u.unlock()
 
 	close(u.closedCh)
 	return u.closeErr
@@ -318,29 +703,45 @@ func (u *Universe) Destroy() error {
 
 // Save snapshots the current state of VMs and clusters, then closes
 // the universe.
-func (u *Universe) Save(snapshotName string) error {
+//
+// Snapshots are qcow2 internal snapshots in each VM's disk, which is
+// itself a copy-on-write overlay of the VM's base image. Unchanged
+// disk clusters are shared between snapshots and with the image, so
+// a save only writes the disk blocks changed since the previous one,
+// plus the VM's memory state. DeleteSnapshot frees a snapshot's
+// clusters for reuse by later writes, but doesn't shrink the disk
+// files.
+//
+// If ctx is canceled while VMs are being saved, Save kills and closes
+// the universe, and returns ctx.Err(). The snapshot isn't recorded
+// in that case, and if it overwrote an existing snapshot, the VMs'
+// disks may no longer have a usable copy of the old one.
+func (u *Universe) Save(ctx context.Context, snapshotName string) error {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 	if u.closed {
 		return u.closeErr
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	snap := &config.Snapshot{
-		Name:     snapshotName,
-		NextPort: u.nextPort,
-		NextNet:  u.nextNet,
-		Clock:    u.cfg.Snapshots[u.activeSnapshot].Clock.Add(time.Since(u.startTime)),
-		Networks: map[string]*config.Network{},
-		Images:   map[string]*config.Image{},
-		VMs:      map[string]*config.VM{},
-		Clusters: map[string]*config.Cluster{},
+	if err := u.checkSnapshottableWithLock(); err != nil {
+		return err
 	}
-	if oldSnap := u.cfg.Snapshots[snapshotName]; oldSnap != nil {
-		snap.ID = oldSnap.ID
-	} else {
-		snap.ID = randomSnapshotID()
+	start := time.Now()
+
+	snap := u.snapshotConfigWithLock(snapshotName)
+
+	if err := u.exportKubeconfigsWithLock(); err != nil {
+		u.closeErr = err
+		return u.closeErr
 	}
 
+	// Killing the universe makes the freezes in progress fail.
+	stop := context.AfterFunc(ctx, u.Kill)
+	defer stop()
+
 	// VM saving is slow, so parallelize it.
 	errs := make(chan error, len(u.vms))
 	for name, vm := range u.vms {
@@ -354,27 +755,17 @@ func (u *Universe) Save(snapshotName string) error {
 	}
 	for range u.vms {
 		if err := <-errs; err != nil {
+			if ctx.Err() != nil {
+				u.closeWithLock()
+				u.unlock()
+				close(u.closedCh)
+				err = ctx.Err()
+			}
 			u.closeErr = err
 			return u.closeErr
 		}
 	}
 
-	for _, network := range u.networks {
-		snap.Networks[network.cfg.Name] = network.cfg
-	}
-	for name, disk := range u.images {
-		snap.Images[name] = &config.Image{
-			Name: name,
-			File: disk,
-		}
-	}
-	for _, vm := range u.vms {
-		snap.VMs[vm.cfg.Name] = vm.cfg
-	}
-	for _, cluster := range u.clusters {
-		snap.Clusters[cluster.cfg.Name] = cluster.cfg
-	}
-
 	// By now all VMs should have shutdown during their freeze. Kill
 	// remaining things. But clear all the new* maps so that
 	// closeWithLock doesn't delete stuff we just saved.
@@ -383,23 +774,64 @@ func (u *Universe) Save(snapshotName string) error {
 	u.vms = nil
 	u.clusters = nil
 	u.closeWithLock()
+	// The snapshot must be recorded before another process can open
+	// the universe.
+	defer u.unlock()
 
 	u.cfg.Snapshots[snapshotName] = snap
 
-	bs, err := json.MarshalIndent(u.cfg, "", "  ")
-	if err != nil {
-		u.closeErr = err
-		return u.closeErr
-	}
-	if err := ioutil.WriteFile(filepath.Join(u.dir, "config.json"), bs, 0600); err != nil {
+	if err := config.Write(filepath.Join(u.dir, "config.json"), u.cfg); err != nil {
 		u.closeErr = err
 		return u.closeErr
 	}
 
+	u.emitEvent(Event{Kind: EventSnapshotSaved, Target: snapshotName, Duration: time.Since(start)})
 	close(u.closedCh)
 	return nil
 }
 
+// snapshotConfigWithLock returns the snapshot metadata for the
+// universe's current state. The returned snapshot shares resource
+// configs with the running universe.
+func (u *Universe) snapshotConfigWithLock(snapshotName string) *config.Snapshot {
+	snap := &config.Snapshot{
+		Name:     snapshotName,
+		NextPort: u.nextPort,
+		NextNet:  u.nextNet,
+		Ports:    u.portHistoryWithLock(),
+		Clock:    u.cfg.Snapshots[u.activeSnapshot].Clock.Add(time.Since(u.startTime)),
+		Networks: map[string]*config.Network{},
+		Images:   map[string]*config.Image{},
+		VMs:      map[string]*config.VM{},
+		Clusters: map[string]*config.Cluster{},
+
+		Registries: map[string]*config.Registry{},
+	}
+	if oldSnap := u.cfg.Snapshots[snapshotName]; oldSnap != nil {
+		snap.ID = oldSnap.ID
+	} else {
+		snap.ID = randomSnapshotID()
+	}
+
+	for _, network := range u.networks {
+		snap.Networks[network.cfg.Name] = network.cfg
+	}
+	for name, img := range u.images {
+		snap.Images[name] = img
+	}
+	for _, vm := range u.vms {
+		snap.VMs[vm.cfg.Name] = vm.cfg
+	}
+	for _, cluster := range u.clusters {
+		snap.Clusters[cluster.cfg.Name] = cluster.cfg
+	}
+	for name, r := range u.registries {
+		snap.Registries[name] = r.cfg
+	}
+
+	return snap
+}
+
 // Wait waits for the universe to be Closed, Saved or Destroyed.
 func (u *Universe) Wait(ctx context.Context) error {
 	select {
@@ -420,17 +852,31 @@ func (u *Universe) Snapshots() []string {
 	return ret
 }
 
+// QEMU returns information about the QEMU installation that the
+// universe uses to run VMs.
+func (u *Universe) QEMU() *QEMUInfo {
+	return u.qemu
+}
+
 func (u *Universe) image(name string) string {
 	u.mu.Lock()
 	defer u.mu.Unlock()
-	return u.images[name]
+	if img := u.images[name]; img != nil {
+		return img.File
+	}
+	return ""
 }
 
+// Command returns a command that runs on the host with its output
+// logged to the universe's log, like virtuakube's own commands.
 func (u *Universe) Command(command string, args ...string) *exec.Cmd {
 	cmd := exec.Command(command, args...)
-	if u.runtimecfg.CommandLog != nil {
-		cmd.Stdout = u.runtimecfg.CommandLog
-		cmd.Stderr = u.runtimecfg.CommandLog
+	u.log.Info(logCommand, "command", strings.Join(cmd.Args, " "))
+	// The caller runs the command, so there's no telling when it
+	// exits to flush its last line.
+	if out := idleLogOutputWriter(u.log); out != nil {
+		cmd.Stdout = out
+		cmd.Stderr = out
 	}
 	return cmd
 }
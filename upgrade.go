@@ -0,0 +1,172 @@
+package virtuakube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Upgrade upgrades the running cluster to Kubernetes version, as
+// kubeadm's upgrade procedure does: the first control plane applies
+// the upgrade, the other control planes follow, and then the worker
+// nodes are upgraded one at a time. Each node is drained while its
+// kubelet is upgraded, and uncordoned once it's back and Ready.
+//
+// version can be a newer patch release of the cluster's minor
+// version, or a release of the next minor version. Nodes need
+// internet access to fetch the new packages and control plane
+// images. If an upgrade fails partway, the cluster is left with a
+// mix of versions. Retrying the upgrade finishes it, because nodes
+// that are already upgraded are left alone.
+func (c *Cluster) Upgrade(ctx context.Context, version string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started {
+		return errors.New("cluster isn't started")
+	}
+	version = strings.TrimPrefix(version, "v")
+	if err := c.checkUpgradeWithLock(version); err != nil {
+		return err
+	}
+	start := time.Now()
+
+	prog := c.universe.progress(c.Name(), "upgrading control plane", int64(1+len(c.controlPlanes)))
+	apply := "kubeadm upgrade apply -y --ignore-preflight-errors=NumCPU v" + version
+	if err := c.upgradeNode(ctx, c.controller, version, apply); err != nil {
+		return prog.done(err)
+	}
+	prog.update(1)
+	for i, cp := range c.controlPlanes {
+		if err := c.upgradeNode(ctx, cp, version, upgradeNodeCommand(version, true)); err != nil {
+			return prog.done(err)
+		}
+		prog.update(int64(i + 2))
+	}
+	prog.done(nil)
+	// New nodes must join with the upgraded control plane's version.
+	c.cfg.KubernetesVersion = version
+
+	prog = c.universe.progress(c.Name(), "upgrading nodes", int64(len(c.nodes)))
+	for i, node := range c.nodes {
+		if err := c.upgradeNode(ctx, node, version, upgradeNodeCommand(version, false)); err != nil {
+			return prog.done(err)
+		}
+		prog.update(int64(i + 1))
+	}
+	prog.done(nil)
+
+	c.universe.emitEvent(Event{Kind: EventClusterUpgraded, Target: c.Name(), Message: version, Duration: time.Since(start)})
+	return nil
+}
+
+// checkUpgradeWithLock checks that the cluster can be upgraded to
+// version.
+func (c *Cluster) checkUpgradeWithLock(version string) error {
+	minor, err := parseKubeVersion(version)
+	if err != nil {
+		return err
+	}
+	if max := maxKubeMinor(c.Runtime()); max != 0 && minor > max {
+		return fmt.Errorf("Kubernetes version %s is not supported with the %s runtime, must be 1.%d at most", version, c.Runtime(), max)
+	}
+	curMinor, _ := parseKubeVersion(c.KubernetesVersion())
+	if minor > curMinor+1 {
+		return fmt.Errorf("can't upgrade from Kubernetes %s to %s, kubeadm upgrades one minor version at a time", c.KubernetesVersion(), version)
+	}
+	if minor < curMinor || (minor == curMinor && kubePatch(version) < kubePatch(c.KubernetesVersion())) {
+		return fmt.Errorf("can't downgrade from Kubernetes %s to %s", c.KubernetesVersion(), version)
+	}
+	if minor > maxKubeAddonsMinor {
+		if c.cfg.CNI != "" && c.cfg.CNI != cniCustom {
+			return fmt.Errorf("bundled CNI %q doesn't support Kubernetes %s (1.%d at most)", c.cfg.CNI, version, maxKubeAddonsMinor)
+		}
+		if c.cfg.MetricsServer {
+			return fmt.Errorf("bundled metrics-server doesn't support Kubernetes %s (1.%d at most)", version, maxKubeAddonsMinor)
+		}
+	}
+	return nil
+}
+
+// kubePatch returns the patch version of a valid Kubernetes version.
+func kubePatch(version string) int {
+	patch, _ := strconv.Atoi(kubeVersionRe.FindStringSubmatch(version)[2])
+	return patch
+}
+
+// upgradeNodeCommand returns the kubeadm command that upgrades the
+// configuration of a control plane or worker node, after the first
+// control plane applied the upgrade to version.
+func upgradeNodeCommand(version string, controlPlane bool) string {
+	// kubeadm 1.15 unified these into one command.
+	if minor, _ := parseKubeVersion(version); minor >= 15 {
+		return "kubeadm upgrade node"
+	}
+	if controlPlane {
+		return "kubeadm upgrade node experimental-control-plane"
+	}
+	return "kubeadm upgrade node config --kubelet-version v" + version
+}
+
+// upgradeNode upgrades node to Kubernetes version: it installs the new
+// kubeadm, runs upgrade, then drains the node while its container
+// runtime and kubelet are upgraded.
+func (c *Cluster) upgradeNode(ctx context.Context, node *VM, version, upgrade string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name := node.Hostname()
+	n, err := c.client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting node %q: %v", name, err)
+	}
+	if n.Status.NodeInfo.KubeletVersion == "v"+version {
+		return nil
+	}
+
+	if err := installKubePackages(node, version, "kubeadm"); err != nil {
+		return fmt.Errorf("upgrading node %q: %v", name, err)
+	}
+	if _, err := node.Run(upgrade); err != nil {
+		return fmt.Errorf("upgrading node %q: %v", name, err)
+	}
+
+	if err := c.drain(name); err != nil {
+		return err
+	}
+	// CRI-O releases track Kubernetes minor versions, so the runtime
+	// may need upgrading too.
+	if err := installRuntime(node, c.Runtime(), version); err != nil {
+		return fmt.Errorf("upgrading node %q: %v", name, err)
+	}
+	if err := installKubePackages(node, version, "kubelet", "kubectl"); err != nil {
+		return fmt.Errorf("upgrading node %q: %v", name, err)
+	}
+	if _, err := node.Run("systemctl daemon-reload && systemctl restart kubelet"); err != nil {
+		return fmt.Errorf("restarting kubelet on %q: %v", name, err)
+	}
+
+	err = c.WaitFor(ctx, func() (bool, error) {
+		n, err := c.client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+		if err != nil {
+			// The API server may be restarting.
+			return false, nil
+		}
+		if n.Status.NodeInfo.KubeletVersion != "v"+version {
+			return false, nil
+		}
+		return c.cfg.CNI == "" || nodeReady(*n), nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for node %q to come back: %v", name, err)
+	}
+	if _, err := c.controller.Run("KUBECONFIG=/etc/kubernetes/admin.conf kubectl uncordon " + name); err != nil {
+		return fmt.Errorf("uncordoning node %q: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package virtuakube
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UpstreamConfig configures how guests reach the internet, for hosts
+// that can't use the upstream package repositories and container
+// registries directly, e.g. behind a corporate proxy.
+type UpstreamConfig struct {
+	// HTTPProxy and HTTPSProxy are the URLs of the proxies that apt,
+	// curl and the container runtime use for plain and TLS
+	// connections.
+	HTTPProxy  string
+	HTTPSProxy string
+	// NoProxy is a comma-separated list of hosts, domains and CIDRs
+	// to reach without the proxies. Universe, pod and service
+	// networks are always reached directly.
+	NoProxy string
+	// AptMirror is the URL of a Debian mirror to use instead of
+	// deb.debian.org, e.g. http://mirror.example.com/debian.
+	AptMirror string
+	// RegistryMirrors maps container registry hosts, e.g. docker.io
+	// or k8s.gcr.io, to the URL of a mirror to pull their images
+	// from. Docker can only mirror docker.io.
+	RegistryMirrors map[string]string
+}
+
+// directNoProxy is what guests always reach without a proxy: the
+// loopback, universe networks (10.248.0.0/16, fd00::/8) and the
+// cluster pod and service networks, which all fall in 10.0.0.0/8.
+const directNoProxy = "localhost,127.0.0.1,10.0.0.0/8,fd00::/8,.svc,.cluster.local"
+
+const (
+	// upstreamSources keeps the image's own apt sources while an
+	// apt mirror replaces them.
+	upstreamSources = "/etc/apt/sources.list.upstream"
+	// upstreamAptConf is the apt configuration for the proxies.
+	upstreamAptConf = "/etc/apt/apt.conf.d/80virtuakube-proxy"
+	// upstreamDropIn is the systemd drop-in, in each container
+	// runtime's unit directory, that sets the runtime's proxies.
+	upstreamDropIn = "90-virtuakube-proxy.conf"
+)
+
+const (
+	// dockerDaemonConfig is where nodes' Docker reads its config.
+	dockerDaemonConfig = "/etc/docker/daemon.json"
+	// crioRegistriesConfig is where nodes' CRI-O reads its registry
+	// config.
+	crioRegistriesConfig = "/etc/containers/registries.conf"
+)
+
+// runtimeServices are the systemd units of all container runtimes.
+var runtimeServices = []string{"docker", "containerd", "crio"}
+
+// validate checks that the proxy and mirror URLs are valid.
+func (cfg *UpstreamConfig) validate() error {
+	if cfg == nil {
+		return nil
+	}
+	for name, u := range map[string]string{"HTTPProxy": cfg.HTTPProxy, "HTTPSProxy": cfg.HTTPSProxy, "AptMirror": cfg.AptMirror} {
+		if err := validateUpstreamURL(u); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	for host, u := range cfg.RegistryMirrors {
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			return fmt.Errorf("invalid registry host %q, must be a hostname like docker.io", host)
+		}
+		if u == "" {
+			return fmt.Errorf("registry mirror of %q has no URL", host)
+		}
+		if err := validateUpstreamURL(u); err != nil {
+			return fmt.Errorf("invalid mirror of registry %q: %v", host, err)
+		}
+	}
+	return nil
+}
+
+// validateUpstreamURL checks that s is empty or an http(s) URL.
+func validateUpstreamURL(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q isn't an http:// or https:// URL", s)
+	}
+	return nil
+}
+
+// buildArgs returns the docker build arguments that make the image
+// Dockerfile use the proxies and apt mirror. Docker doesn't keep the
+// proxy arguments in the built image.
+func (cfg *UpstreamConfig) buildArgs() []string {
+	if cfg == nil {
+		return nil
+	}
+	var ret []string
+	if cfg.HTTPProxy != "" {
+		ret = append(ret, "--build-arg", "http_proxy="+cfg.HTTPProxy)
+	}
+	if cfg.HTTPSProxy != "" {
+		ret = append(ret, "--build-arg", "https_proxy="+cfg.HTTPSProxy)
+	}
+	if len(ret) > 0 {
+		ret = append(ret, "--build-arg", "no_proxy="+cfg.noProxy())
+	}
+	if cfg.AptMirror != "" {
+		ret = append(ret, "--build-arg", "apt_mirror="+strings.TrimSuffix(cfg.AptMirror, "/"))
+	}
+	return ret
+}
+
+// noProxy returns the complete no_proxy list.
+func (cfg *UpstreamConfig) noProxy() string {
+	if cfg.NoProxy == "" {
+		return directNoProxy
+	}
+	return directNoProxy + "," + cfg.NoProxy
+}
+
+// upstream returns the upstream configuration of the VM's universe,
+// or nil if guests reach upstream directly.
+func (v *VM) upstream() *UpstreamConfig {
+	return v.universe.runtimecfg.Upstream
+}
+
+// installUpstream configures apt, curl and the container runtimes on
+// the VM to use its proxies, and apt to use its mirror.
+func (v *VM) installUpstream() error {
+	cfg := v.upstream()
+	if cfg == nil {
+		return nil
+	}
+
+	var cmds []string
+	if cfg.AptMirror != "" {
+		cmds = append(cmds,
+			fmt.Sprintf("[ -e %s ] || cp /etc/apt/sources.list %s", upstreamSources, upstreamSources),
+			fmt.Sprintf("sed 's|https\\?://deb.debian.org/debian/\\?|%s/|' %s >/etc/apt/sources.list", strings.TrimSuffix(cfg.AptMirror, "/"), upstreamSources),
+		)
+	}
+	if cfg.HTTPProxy != "" || cfg.HTTPSProxy != "" {
+		var apt, curlrc, env strings.Builder
+		if cfg.HTTPProxy != "" {
+			fmt.Fprintf(&apt, "Acquire::http::Proxy %q;\n", cfg.HTTPProxy)
+			fmt.Fprintf(&env, " \"HTTP_PROXY=%s\"", cfg.HTTPProxy)
+		}
+		if cfg.HTTPSProxy != "" {
+			fmt.Fprintf(&apt, "Acquire::https::Proxy %q;\n", cfg.HTTPSProxy)
+			fmt.Fprintf(&env, " \"HTTPS_PROXY=%s\"", cfg.HTTPSProxy)
+		}
+		// curl only takes one proxy, and fetches over https.
+		proxy := cfg.HTTPSProxy
+		if proxy == "" {
+			proxy = cfg.HTTPProxy
+		}
+		fmt.Fprintf(&curlrc, "proxy = %q\nnoproxy = %q\n", proxy, cfg.noProxy())
+		fmt.Fprintf(&env, " \"NO_PROXY=%s\"", cfg.noProxy())
+
+		if err := v.WriteFile(upstreamAptConf, []byte(apt.String())); err != nil {
+			return err
+		}
+		if err := v.WriteFile("/root/.curlrc", []byte(curlrc.String())); err != nil {
+			return err
+		}
+		dropIn := "[Service]\nEnvironment=" + strings.TrimSpace(env.String()) + "\n"
+		for _, svc := range runtimeServices {
+			dir := fmt.Sprintf("/etc/systemd/system/%s.service.d", svc)
+			if _, err := v.Run("mkdir -p " + dir); err != nil {
+				return err
+			}
+			if err := v.WriteFile(dir+"/"+upstreamDropIn, []byte(dropIn)); err != nil {
+				return err
+			}
+		}
+		// Runtimes that are already running pick up the proxies
+		// when they restart.
+		cmds = append(cmds, "systemctl daemon-reload", "systemctl try-restart "+strings.Join(runtimeServices, " "))
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	if err := v.RunMultiple(cmds...); err != nil {
+		return fmt.Errorf("configuring proxies and mirrors: %v", err)
+	}
+	return nil
+}
+
+// removeUpstream undoes installUpstream, and the Dockerfile's apt
+// mirror, so that built images don't depend on the network they were
+// built on.
+func (v *VM) removeUpstream() error {
+	var cmds []string
+	for _, svc := range runtimeServices {
+		cmds = append(cmds, fmt.Sprintf("rm -f /etc/systemd/system/%s.service.d/%s", svc, upstreamDropIn))
+	}
+	cmds = append(cmds,
+		fmt.Sprintf("rm -f %s /root/.curlrc", upstreamAptConf),
+		fmt.Sprintf("if [ -e %s ]; then mv %s /etc/apt/sources.list; fi", upstreamSources, upstreamSources),
+	)
+	for _, runtime := range []string{RuntimeDocker, RuntimeContainerd, RuntimeCRIO} {
+		path := registryMirrorConfig(runtime)
+		// Empty backups stand for files that didn't exist.
+		cmds = append(cmds, fmt.Sprintf("if [ -s %[1]s.upstream ]; then mv %[1]s.upstream %[1]s; elif [ -e %[1]s.upstream ]; then rm -f %[1]s %[1]s.upstream; fi", path))
+	}
+	if err := v.RunMultiple(cmds...); err != nil {
+		return fmt.Errorf("removing proxies and mirrors: %v", err)
+	}
+	return nil
+}
+
+// installRegistryMirrors configures the container runtime that vm
+// runs to pull through its registry mirrors.
+func (v *VM) installRegistryMirrors() error {
+	cfg := v.upstream()
+	if cfg == nil || len(cfg.RegistryMirrors) == 0 {
+		return nil
+	}
+
+	// removeUpstream restores the runtime's config.
+	runtime := guestRuntimeName(v)
+	path := registryMirrorConfig(runtime)
+	if _, err := v.Run(fmt.Sprintf("mkdir -p %[1]s && ([ -e %[2]s.upstream ] || cp %[2]s %[2]s.upstream 2>/dev/null || : >%[2]s.upstream)", filepath.Dir(path), path)); err != nil {
+		return err
+	}
+
+	switch runtime {
+	case RuntimeContainerd:
+		var b strings.Builder
+		for _, host := range sortedKeys(cfg.RegistryMirrors) {
+			fmt.Fprintf(&b, "[plugins.\"io.containerd.grpc.v1.cri\".registry.mirrors.%q]\nendpoint = [%q, %q]\n", host, cfg.RegistryMirrors[host], "https://"+registryEndpoint(host))
+		}
+		return mergeContainerdConfig(v, b.String())
+	case RuntimeCRIO:
+		return installCRIORegistries(v, nil, cfg.RegistryMirrors)
+	}
+
+	for host := range cfg.RegistryMirrors {
+		if host != "docker.io" {
+			v.universe.log.Warn("Docker can only mirror docker.io, ignoring registry mirror", "vm", v.cfg.Name, "registry", host)
+		}
+	}
+	mirror := cfg.RegistryMirrors["docker.io"]
+	if mirror == "" {
+		return nil
+	}
+	return updateDockerDaemon(v, func(daemon map[string]interface{}) {
+		daemon["registry-mirrors"] = []string{mirror}
+	})
+}
+
+// registryMirrorConfig returns the config file in which runtime
+// keeps registry mirrors.
+func registryMirrorConfig(runtime string) string {
+	switch runtime {
+	case RuntimeContainerd:
+		return containerdConfig
+	case RuntimeCRIO:
+		return crioRegistriesConfig
+	default:
+		return dockerDaemonConfig
+	}
+}
+
+// registryEndpoint returns the host that serves the registry host,
+// which differs for Docker Hub.
+func registryEndpoint(host string) string {
+	if host == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return host
+}
+
+// updateDockerDaemon applies update to the Docker daemon config of
+// node, and restarts Docker.
+func updateDockerDaemon(node *VM, update func(map[string]interface{})) error {
+	cur, err := node.Run("cat " + dockerDaemonConfig + " 2>/dev/null || true")
+	if err != nil {
+		return err
+	}
+	daemon := map[string]interface{}{}
+	if strings.TrimSpace(string(cur)) != "" {
+		if err := json.Unmarshal(cur, &daemon); err != nil {
+			return fmt.Errorf("parsing %s on %q: %v", dockerDaemonConfig, node.Hostname(), err)
+		}
+	}
+	update(daemon)
+	bs, err := json.MarshalIndent(daemon, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := node.Run("mkdir -p /etc/docker"); err != nil {
+		return err
+	}
+	if err := node.WriteFile(dockerDaemonConfig, bs); err != nil {
+		return err
+	}
+	if _, err := node.Run("systemctl restart docker"); err != nil {
+		return fmt.Errorf("restarting docker on %q: %v", node.Hostname(), err)
+	}
+	return nil
+}
+
+// installCRIORegistries configures CRI-O on node to pull from
+// insecure over plain HTTP, and through mirrors.
+func installCRIORegistries(node *VM, insecure []string, mirrors map[string]string) error {
+	var b strings.Builder
+	b.WriteString("unqualified-search-registries = [\"docker.io\"]\n")
+	for _, addr := range insecure {
+		fmt.Fprintf(&b, "\n[[registry]]\nlocation = %q\ninsecure = true\n", addr)
+	}
+	for _, host := range sortedKeys(mirrors) {
+		u, err := url.Parse(mirrors[host])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "\n[[registry]]\nprefix = %q\nlocation = %q\n\n[[registry.mirror]]\nlocation = %q\ninsecure = %t\n", host, host, u.Host+u.Path, u.Scheme == "http")
+	}
+	if err := node.WriteFile(crioRegistriesConfig, []byte(b.String())); err != nil {
+		return err
+	}
+	if _, err := node.Run("systemctl restart crio"); err != nil {
+		return fmt.Errorf("restarting crio on %q: %v", node.Hostname(), err)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
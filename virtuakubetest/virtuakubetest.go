@@ -0,0 +1,261 @@
+// Package virtuakubetest runs Go tests against virtuakube universes.
+//
+// A test binary's TestMain hands its snapshot to Run, which builds
+// the snapshot if needed and keeps a pool of booted copies of it:
+//
+//	func TestMain(m *testing.M) {
+//		virtuakubetest.Run(m, virtuakubetest.SnapshotSpec{
+//			Dir:      "testdata/universe",
+//			Snapshot: "cluster",
+//			Build:    buildCluster,
+//		})
+//	}
+//
+// Each test then checks out its own universe, or one of its
+// clusters, which goes back to the pool when the test ends:
+//
+//	func TestSomething(t *testing.T) {
+//		t.Parallel()
+//		c := virtuakubetest.Cluster(t, "k8s")
+//		...
+//	}
+//
+// When a test fails, the state of its universe is saved to an
+// artifacts directory before the universe is recycled.
+package virtuakubetest
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.universe.tf/virtuakube"
+)
+
+// SnapshotSpec describes the snapshot that tests run against.
+type SnapshotSpec struct {
+	// Dir is the universe directory.
+	Dir string
+	// Snapshot is the snapshot of Dir that tests get copies of.
+	Snapshot string
+	// Build, if non-nil, sets up the universe's resources when Dir
+	// doesn't have Snapshot yet. Run then saves the result as
+	// Snapshot. If Dir doesn't exist, Build gets a new universe,
+	// otherwise it gets Dir's default snapshot.
+	Build func(*virtuakube.Universe) error
+	// PoolSize is the number of universes kept booted for tests.
+	// Zero means the -test.parallel setting.
+	PoolSize int
+	// ArtifactsDir is where the state of failed tests' universes is
+	// saved. If empty, $VIRTUAKUBE_ARTIFACTS is used, or a temporary
+	// directory if that's unset too.
+	ArtifactsDir string
+	// UniverseConfig is the runtime configuration of the universes.
+	UniverseConfig *virtuakube.UniverseConfig
+}
+
+var (
+	pool      *virtuakube.Pool
+	artifacts string
+)
+
+// Run prepares spec's snapshot, runs the tests in m, and exits with
+// their result. It's meant to be called from TestMain.
+func Run(m *testing.M, spec SnapshotSpec) {
+	os.Exit(run(m, spec))
+}
+
+func run(m *testing.M, spec SnapshotSpec) int {
+	// m.Run would parse the flags, but the pool's size depends on
+	// -test.parallel.
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+
+	if err := ensureSnapshot(spec); err != nil {
+		fmt.Fprintf(os.Stderr, "virtuakubetest: preparing snapshot %q: %v\n", spec.Snapshot, err)
+		return 1
+	}
+
+	artifacts = spec.ArtifactsDir
+	if artifacts == "" {
+		artifacts = os.Getenv("VIRTUAKUBE_ARTIFACTS")
+	}
+	if artifacts == "" {
+		dir, err := ioutil.TempDir("", "virtuakube-artifacts")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "virtuakubetest: creating artifacts directory: %v\n", err)
+			return 1
+		}
+		artifacts = dir
+	}
+
+	size := spec.PoolSize
+	if size <= 0 {
+		size = parallelism()
+	}
+	p, err := virtuakube.NewPool(&virtuakube.PoolConfig{
+		Dir:            spec.Dir,
+		Snapshot:       spec.Snapshot,
+		Size:           size,
+		UniverseConfig: spec.UniverseConfig,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "virtuakubetest: starting universe pool: %v\n", err)
+		return 1
+	}
+	pool = p
+	defer pool.Close()
+
+	return m.Run()
+}
+
+// ensureSnapshot builds spec's snapshot if its universe doesn't have
+// it.
+func ensureSnapshot(spec SnapshotSpec) error {
+	info, err := virtuakube.ReadUniverseInfo(spec.Dir)
+	if err == nil {
+		for _, snap := range info.Snapshots {
+			if snap.Name == spec.Snapshot {
+				return nil
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if spec.Build == nil {
+		return fmt.Errorf("universe %q has no snapshot %q, and SnapshotSpec has no Build func", spec.Dir, spec.Snapshot)
+	}
+
+	ctx := context.Background()
+	var u *virtuakube.Universe
+	if info == nil {
+		u, err = virtuakube.Create(ctx, spec.Dir, spec.UniverseConfig)
+	} else {
+		u, err = virtuakube.Open(ctx, spec.Dir, "", spec.UniverseConfig)
+	}
+	if err != nil {
+		return err
+	}
+	if err := spec.Build(u); err != nil {
+		u.Close()
+		return err
+	}
+	return u.Save(ctx, spec.Snapshot)
+}
+
+// Universe checks out a running universe for t, which goes back to
+// the pool when t and its subtests finish. If t failed, the
+// universe's state is saved to the artifacts directory first.
+func Universe(t testing.TB) *virtuakube.Universe {
+	t.Helper()
+	if pool == nil {
+		t.Fatal("virtuakubetest: no universe pool, call virtuakubetest.Run from TestMain")
+	}
+
+	ctx := context.Background()
+	if d, ok := t.(interface{ Deadline() (time.Time, bool) }); ok {
+		if deadline, ok := d.Deadline(); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+	}
+	u, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("virtuakubetest: getting a universe: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if t.Failed() {
+			dir := filepath.Join(artifacts, artifactName(t.Name()))
+			if err := CollectArtifacts(u, dir); err != nil {
+				t.Logf("virtuakubetest: collecting artifacts: %v", err)
+			} else {
+				t.Logf("virtuakubetest: universe state saved to %s", dir)
+			}
+		}
+		if err := pool.Put(u); err != nil {
+			t.Logf("virtuakubetest: returning universe to pool: %v", err)
+		}
+	})
+	return u
+}
+
+// Cluster checks out a running universe for t like Universe, and
+// returns its cluster called name.
+func Cluster(t testing.TB, name string) *virtuakube.Cluster {
+	t.Helper()
+	c := Universe(t).Cluster(name)
+	if c == nil {
+		t.Fatalf("virtuakubetest: universe has no cluster %q", name)
+	}
+	return c
+}
+
+// parallelism returns the -test.parallel setting, which defaults to
+// GOMAXPROCS.
+func parallelism() int {
+	if f := flag.Lookup("test.parallel"); f != nil {
+		if n, err := strconv.Atoi(f.Value.String()); err == nil && n > 0 {
+			return n
+		}
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+var unsafeNameRe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// artifactName turns a test name, which can contain slashes and
+// spaces, into a directory name.
+func artifactName(test string) string {
+	return unsafeNameRe.ReplaceAllString(test, "_")
+}
+
+// CollectArtifacts saves the state of u to dir, for debugging: the
+// universe's status, each VM's console log and journal, and the
+// state of each cluster's nodes and pods.
+func CollectArtifacts(u *virtuakube.Universe, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	st, err := json.MarshalIndent(u.Status(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "status.json"), st, 0600); err != nil {
+		return err
+	}
+
+	// Artifacts are best effort past this point: a broken VM is
+	// the likeliest reason for collecting them.
+	for _, vm := range u.VMs() {
+		name := vm.Hostname()
+		if bs, err := ioutil.ReadFile(vm.ConsoleLog()); err == nil {
+			ioutil.WriteFile(filepath.Join(dir, name+".console.log"), bs, 0600)
+		}
+		out, err := vm.Run("journalctl -b --no-pager")
+		if err != nil {
+			out = append(out, fmt.Sprintf("\n(journalctl failed: %v)\n", err)...)
+		}
+		ioutil.WriteFile(filepath.Join(dir, name+".journal.log"), out, 0600)
+	}
+	for _, c := range u.Clusters() {
+		out, err := c.Controller().Run("kubectl --kubeconfig=/etc/kubernetes/admin.conf get nodes,pods --all-namespaces -o wide; kubectl --kubeconfig=/etc/kubernetes/admin.conf get events --all-namespaces --sort-by=.lastTimestamp")
+		if err != nil {
+			out = append(out, fmt.Sprintf("\n(kubectl failed: %v)\n", err)...)
+		}
+		ioutil.WriteFile(filepath.Join(dir, c.Name()+".cluster.txt"), out, 0600)
+	}
+	return nil
+}
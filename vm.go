@@ -7,11 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,13 +23,106 @@ import (
 	"go.universe.tf/virtuakube/internal/config"
 )
 
+// defaultMachineType is the QEMU machine type for VMs.
+const defaultMachineType = "q35"
+
+// seedPCISlot is the PCI slot of the cloud-init seed disk, out of the
+// way of the extra NICs, which count up from slot 5.
+const seedPCISlot = 29
+
+// firstNICPCISlot is the PCI slot of the NIC of a VM's first network.
+// The NICs of its other networks take the slots after it, up to the
+// balloon's.
+const firstNICPCISlot = 5
+
+// maxVMNetworks is the number of networks a VM can be attached to at
+// creation, which is what fits below balloonPCISlot.
+const maxVMNetworks = balloonPCISlot - firstNICPCISlot
+
+// nicPCISlot returns the PCI slot of the NIC of a VM's network number
+// i, counting from 0 in VMConfig.Networks order.
+func nicPCISlot(i int) int {
+	return firstNICPCISlot + i
+}
+
+// nicInterface returns the guest interface name of the NIC of a VM's
+// network number i. Guests name PCI NICs after their slot in decimal.
+func nicInterface(i int) string {
+	return fmt.Sprintf("enp0s%d", nicPCISlot(i))
+}
+
 // VMConfig is the configuration for a virtual machine.
 type VMConfig struct {
-	Name         string
-	Image        string
-	MemoryMiB    int
+	Name  string
+	Image string
+	// MemoryMiB is the VM's memory. It defaults to 1024.
+	MemoryMiB int
+	// CPUs is the number of vCPUs the VM has. It defaults to 1.
+	CPUs int
+	// CPUModel is the QEMU CPU model to emulate, e.g. "host" or
+	// "Skylake-Server". It defaults to QEMU's default for the
+	// machine, or on arm64 to "host" with KVM and "max" without.
+	CPUModel string
+	// Networks are the universe networks to attach the VM to, one
+	// NIC each, in order. Each network is an isolated L2 segment, and
+	// the VM gets an IPv4 and IPv6 address on each.
 	Networks     []string
 	PortForwards map[int]bool
+	// Disk sets I/O limits for the VM's root disk. Extra disks have
+	// their own, in DiskConfig.
+	Disk DiskSpec
+	// MachineType is the QEMU machine type to emulate. It defaults to
+	// q35. Pin a versioned machine type (e.g. pc-q35-4.2) to keep
+	// snapshots loadable across QEMU upgrades.
+	MachineType string
+	// TmpfsDisk puts the VM's copy-on-write disk in host RAM, for
+	// faster disposable VMs. The disk is lost when the universe
+	// closes (or the host reboots), and universes containing such
+	// VMs can't be saved or snapshotted.
+	TmpfsDisk bool
+	// Firmware is the firmware the VM boots with, FirmwareBIOS (the
+	// default) or FirmwareUEFI. UEFI VMs whose image has an external
+	// kernel boot it directly, others boot from the EFI system
+	// partition of their disk. UEFI needs OVMF on the host, and is
+	// only supported on amd64.
+	Firmware string
+	// SecureBoot enables UEFI secure boot, with Microsoft's keys
+	// enrolled. It needs a q35 machine type, and a disk with a
+	// signed bootloader, such as an imported distro cloud image.
+	SecureBoot bool
+	// TPM gives the VM an emulated TPM 2.0 device, backed by swtpm on
+	// the host.
+	TPM bool
+	// NestedVirt exposes the host CPU's virtualization extensions to
+	// the VM, so that it can run KVM guests itself, e.g. for KubeVirt
+	// or Kata Containers. It needs KVM acceleration on an amd64 host
+	// whose kvm_intel or kvm_amd module allows nested KVM, and
+	// implies CPUModel "host".
+	NestedVirt bool
+	// Devices are host devices to pass through to the VM, e.g. GPUs.
+	// Clusters ignore VMConfig.Devices, give their nodes devices with
+	// ClusterConfig.NodeDevices instead.
+	Devices []DeviceConfig
+	// AuthorizedKeys are extra SSH public keys, in authorized_keys
+	// format, that can log in to the VM as root, alongside the
+	// universe's own key.
+	AuthorizedKeys []string
+	// CloudInit, if set, is applied by cloud-init on the VM's first
+	// boot. Start waits for it to finish.
+	CloudInit *CloudInitConfig
+	// Disks are extra blank disks to attach to the VM, after its
+	// root disk.
+	Disks []DiskConfig
+	// Mounts are host directories to share with the VM. Start
+	// mounts them in the guest.
+	Mounts []MountConfig
+	// HotplugNICs is how many NICs can be plugged into the VM at
+	// once with AttachNIC. Each takes a PCIe root port, so it needs
+	// a q35 or virt machine type. At most 8.
+	HotplugNICs int
+	// Addresses pins the VM's addresses on some of its Networks, by
+	// network name, instead of having them allocated.
+	Addresses map[string]AddressConfig
 
 	// Only available to image builder.
 	*kernelConfig
@@ -46,6 +139,8 @@ type kernelConfig struct {
 
 // VM is a virtual machine.
 type VM struct {
+	universe *Universe
+
 	cfg *config.VM
 
 	// Closed when the VM has exited.
@@ -54,10 +149,18 @@ type VM struct {
 	universeStartTime time.Time
 	universeOpenTime  time.Time
 
-	commandLog io.Writer
+	// Logger for the VM's commands, with a "vm" attribute.
+	log *slog.Logger
+
+	// Path to the Unix socket connected to the VM's serial console.
+	consoleSock string
 
 	mu sync.Mutex
 
+	// Serializes use of the guest agent, whose port takes one client
+	// at a time.
+	agentMu sync.Mutex
+
 	// Qemu subprocess that runs the VM.
 	cmd *exec.Cmd
 
@@ -68,123 +171,327 @@ type VM struct {
 	// SSH connection to the VM.
 	ssh *ssh.Client
 
+	// Whether the VM's mounts have virtiofs devices.
+	virtiofs bool
+
+	// URL of the VM's remote display, if it has one.
+	display string
+
+	// The VM's LAN address, if bridged and known.
+	lanIP net.IP
+
 	// Tracking the state of the VM to enable/disable parts of the
 	// API.
 	started bool
 	closed  bool
+	paused  bool
+	// Set by abort, guarded by universe.procsMu.
+	aborted bool
+
+	// For image builds, the container runtime that
+	// CustomizeInstallK8s installs.
+	buildRuntime string
 }
 
 func (u *Universe) mkVM(cfg *config.VM, kernel *kernelConfig, resume bool) (*VM, error) {
 	ret := &VM{
+		universe:          u,
 		cfg:               cfg,
 		stopped:           make(chan bool),
 		universeStartTime: u.cfg.Snapshots[u.activeSnapshot].Clock,
 		universeOpenTime:  u.startTime,
-		commandLog:        u.runtimecfg.CommandLog,
+		log:               u.log.With("vm", cfg.Name),
+		consoleSock:       filepath.Join(u.tmpdir, "console-"+cfg.Name),
+	}
+	if resume {
+		// The host ports this VM had when it was snapshotted may
+		// have been taken since.
+		u.allocPortsWithLock(cfg, vmForwards(cfg))
 	}
 
-	ret.cmd = exec.Command(
-		"qemu-system-x86_64",
-		"-machine", "q35",
+	cmd, err := u.backend.vmCommand(u, ret, kernel, resume)
+	if err != nil {
+		return nil, err
+	}
+	ret.cmd = cmd
+
+	monIn, err := ret.cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating stdin pipe: %v", err)
+	}
+	ret.monIn = monIn
+	monOut, err := ret.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating stdout pipe: %v", err)
+	}
+	ret.monOut = monOut
+
+	if err := ret.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting VM: %v", err)
+	}
+	go func() {
+		err := ret.cmd.Wait()
+		close(ret.stopped)
+		ret.mu.Lock()
+		crashed := !ret.closed
+		ret.mu.Unlock()
+		if crashed && !u.killed(ret) {
+			if err == nil {
+				err = errors.New("qemu exited")
+			}
+			u.emit(EventVMCrashed, cfg.Name, "%v", err)
+		}
+	}()
+	u.trackProcess(ret.cmd.Process, ret.stopped)
+
+	if err := u.backend.waitStarted(ret); err != nil {
+		ret.Close()
+		return nil, err
+	}
+
+	if err := u.claimAddressesWithLock(cfg); err != nil {
+		ret.Close()
+		return nil, err
+	}
+	u.vms[cfg.Name] = ret
+	if err := u.writeQEMUCommandsWithLock(); err != nil {
+		ret.Close()
+		delete(u.vms, cfg.Name)
+		u.unclaimAddressesWithLock(cfg)
+		return nil, fmt.Errorf("recording qemu command line: %v", err)
+	}
+	return ret, nil
+}
+
+// qemuCommandWithLock returns the qemu command that runs v, see
+// backend.vmCommand.
+func (u *Universe) qemuCommandWithLock(v *VM, kernel *kernelConfig, resume bool) (*exec.Cmd, error) {
+	cfg := v.cfg
+	// Unix socket paths are limited to ~100 bytes, so give qemu
+	// (which runs in the universe dir) the shortest path we can.
+	consoleSock, err := filepath.Rel(u.dir, v.consoleSock)
+	if err != nil {
+		return nil, err
+	}
+
+	qemu, err := u.qemuFor(cfg.Arch)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := machineType(cfg)
+	if cfg.SecureBoot {
+		// OVMF's secure boot build keeps its variable store safe
+		// from the guest OS in SMM.
+		machine += ",smm=on"
+	}
+	cmd := exec.Command(
+		qemuBinary(cfg.Arch),
+		"-machine", machine,
 		"-m", strconv.Itoa(cfg.MemoryMiB),
 		"-device", "virtio-net,netdev=net0,mac=52:54:00:12:34:56",
 		"-device", "virtio-rng-pci,rng=rng0",
 		"-device", "virtio-serial",
 		"-object", "rng-random,filename=/dev/urandom,id=rng0",
 		"-netdev", fmt.Sprintf("user,id=net0,%s", makeForwards(cfg.PortForwards)),
-		"-drive", fmt.Sprintf("if=virtio,file=%s,media=disk", cfg.DiskFile),
+		"-drive", fmt.Sprintf("if=virtio,file=%s,media=disk,id=%s%s", cfg.DiskFile, rootDiskID, throttleOptions(cfg.DiskLimits)),
 		"-rtc", "clock=vm",
-		"-serial", "null",
+		"-chardev", fmt.Sprintf("socket,id=console,path=%s,server,nowait,logfile=%s,logappend=on", consoleSock, consoleLogFile(cfg.Name)),
+		"-serial", "chardev:console",
 		"-monitor", "stdio",
 		"-S",
 	)
 
-	if !u.runtimecfg.VMGraphics {
-		ret.cmd.Args = append(ret.cmd.Args, "-nographic")
-	}
+	cmd.Args = append(cmd.Args, u.displayArgsWithLock(v)...)
 
-	if !u.runtimecfg.NoAcceleration {
-		ret.cmd.Args = append(ret.cmd.Args, "-enable-kvm")
+	accel := !u.runtimecfg.NoAcceleration && canAccelerate(cfg.Arch)
+	if accel {
+		if err := kvmUsable(); err != nil {
+			return nil, err
+		}
+		cmd.Args = append(cmd.Args, "-enable-kvm")
+	}
+	if cfg.NestedVirt {
+		// The VM may be resuming on a host that no longer allows
+		// nested KVM.
+		feature, err := nestedKVM()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Args = append(cmd.Args, "-cpu", "host,+"+feature)
+	} else if cfg.CPUModel != "" {
+		cmd.Args = append(cmd.Args, "-cpu", cfg.CPUModel)
+	} else if normalizeArch(cfg.Arch) == ArchARM64 {
+		// The virt machine has no default CPU worth running.
+		cpu := "max"
+		if accel {
+			cpu = "host"
+		}
+		cmd.Args = append(cmd.Args, "-cpu", cpu)
+	}
+	if cfg.CPUs > 1 {
+		cmd.Args = append(cmd.Args, "-smp", strconv.Itoa(cfg.CPUs))
 	}
 
 	for i, net := range cfg.Networks {
-		ret.cmd.Args = append(ret.cmd.Args,
-			"-device", fmt.Sprintf("virtio-net,netdev=net%d,addr=%d,mac=%s", i+1, i+5, cfg.MAC[net]),
+		dev := fmt.Sprintf("virtio-net,netdev=net%d,addr=0x%x,mac=%s", i+1, nicPCISlot(i), cfg.MAC[net])
+		if mtu := cfg.MTU[net]; mtu != 0 && mtu != DefaultMTU && qemu.Has(CapHostMTU) {
+			dev += fmt.Sprintf(",host_mtu=%d", mtu)
+		}
+		cmd.Args = append(cmd.Args,
+			"-device", dev,
 			"-netdev", fmt.Sprintf("vde,id=net%d,sock=%s", i+1, u.networks[net].sock),
 		)
 	}
 
 	if kernel != nil {
-		ret.cmd.Args = append(ret.cmd.Args,
+		cmd.Args = append(cmd.Args,
 			"-kernel", kernel.kernelPath,
 			"-initrd", kernel.initrdPath,
 			"-append", kernel.cmdline,
 		)
+	} else if cfg.Kernel != "" {
+		cmd.Args = append(cmd.Args,
+			"-kernel", filepath.Join(u.dir, cfg.Kernel),
+			"-initrd", filepath.Join(u.dir, cfg.Initrd),
+			"-append", "root=/dev/vda1 rw console=tty0 console="+consoleDevice(cfg.Arch),
+		)
 	}
-	if resume {
-		ret.cmd.Args = append(ret.cmd.Args, "-loadvm", u.cfg.Snapshots[u.activeSnapshot].ID)
-	}
-	ret.cmd.Dir = u.dir
-	if u.runtimecfg.Interactive {
-		ret.cmd.SysProcAttr = &syscall.SysProcAttr{
-			Setpgid: true,
-		}
+	firmware, err := u.firmwareArgs(v)
+	if err != nil {
+		return nil, err
 	}
-	ret.cmd.Stderr = os.Stderr
-
-	monIn, err := ret.cmd.StdinPipe()
+	cmd.Args = append(cmd.Args, firmware...)
+	cmd.Args = append(cmd.Args, lanArgs(cfg)...)
+	cmd.Args = append(cmd.Args, diskArgs(cfg.Disks)...)
+	cmd.Args = append(cmd.Args, deviceArgs(cfg.Devices)...)
+	agent, err := agentArgs(v)
 	if err != nil {
-		return nil, fmt.Errorf("creating stdin pipe: %v", err)
+		return nil, err
 	}
-	ret.monIn = monIn
-	monOut, err := ret.cmd.StdoutPipe()
+	cmd.Args = append(cmd.Args, agent...)
+	cmd.Args = append(cmd.Args, balloonArgs(v)...)
+	if cfg.CloudInit != nil {
+		// The seed is rebuilt every time the VM process starts, so
+		// it's never part of the universe's files. It's read-only,
+		// so snapshots skip it.
+		seed := filepath.Join(u.tmpdir, "seed-"+cfg.Name+".iso")
+		if err := writeSeedISO(seed, cfg.CloudInit); err != nil {
+			return nil, err
+		}
+		cmd.Args = append(cmd.Args,
+			"-drive", fmt.Sprintf("if=none,file=%s,format=raw,readonly=on,id=seed", seed),
+			"-device", fmt.Sprintf("virtio-blk-pci,drive=seed,addr=0x%x", seedPCISlot),
+		)
+	}
+	// Mount devices go last, their PCI slots are assigned
+	// automatically.
+	mounts, err := u.mountArgs(v, qemu)
 	if err != nil {
-		return nil, fmt.Errorf("creating stdout pipe: %v", err)
+		return nil, err
 	}
-	ret.monOut = monOut
-
-	if err := ret.cmd.Start(); err != nil {
-		return nil, fmt.Errorf("starting VM: %v", err)
+	cmd.Args = append(cmd.Args, mounts...)
+	// As are the root ports for hot-plugging NICs, which must come
+	// after every device with a fixed slot.
+	cmd.Args = append(cmd.Args, u.hotplugArgsWithLock(cfg, qemu)...)
+	if resume {
+		cmd.Args = append(cmd.Args, "-loadvm", u.cfg.Snapshots[u.activeSnapshot].ID)
 	}
-	go func() {
-		ret.cmd.Wait()
-		close(ret.stopped)
-	}()
-
-	if _, err := readToPrompt(ret.monOut); err != nil {
-		ret.Close()
-		return nil, fmt.Errorf("reading qemu monitor prompt: %v", err)
+	cmd.Dir = u.dir
+	if u.runtimecfg.Interactive {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setpgid: true,
+		}
 	}
-
-	u.vms[cfg.Name] = ret
-	return ret, nil
+	cmd.Stderr = os.Stderr
+	return cmd, nil
 }
 
 // NewVM creates an unstarted virtual machine with the given configuration.
-func (u *Universe) NewVM(cfg *VMConfig) (*VM, error) {
+func (u *Universe) NewVM(ctx context.Context, cfg *VMConfig) (*VM, error) {
 	u.mu.Lock()
 	defer u.mu.Unlock()
-	return u.newVMWithLock(cfg)
+	return u.newVMWithLock(ctx, cfg)
 }
 
-func (u *Universe) newVMWithLock(cfg *VMConfig) (*VM, error) {
+func (u *Universe) newVMWithLock(ctx context.Context, cfg *VMConfig) (*VM, error) {
+	return u.newVMFromDiskWithLock(ctx, cfg, "", "")
+}
+
+// newVMFromDiskWithLock creates a VM whose disk is a copy-on-write
+// overlay of backingPath, in backingFormat. If backingPath is empty,
+// the overlay is backed by the universe image named in cfg.Image.
+func (u *Universe) newVMFromDiskWithLock(ctx context.Context, cfg *VMConfig, backingPath, backingFormat string) (*VM, error) {
 	if cfg == nil {
 		return nil, errors.New("no VMConfig specified")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// VMs run the architecture of their image. Imported disks are
+	// assumed to be amd64.
+	var img *config.Image
+	if backingPath == "" {
+		img = u.images[cfg.Image]
+		if img == nil {
+			return nil, fmt.Errorf("universe doesn't have an image named %q", cfg.Image)
+		}
+	} else {
+		img = &config.Image{}
+	}
+
+	if err := u.validateVMConfig(cfg, img.Arch); err != nil {
+		return nil, err
+	}
+	if cfg.kernelConfig != nil && (cfg.Firmware != "" || cfg.TPM) {
+		return nil, errors.New("image builds can't set Firmware or TPM")
+	}
+	if cfg.SecureBoot && img.Kernel != "" {
+		return nil, fmt.Errorf("SecureBoot needs a VM that boots from its disk, image %q has an external kernel", cfg.Image)
+	}
+	if cfg.CloudInit != nil {
+		if cfg.kernelConfig != nil {
+			return nil, errors.New("image builds can't use CloudInit")
+		}
+		if err := checkTools(cloudInitTools); err != nil {
+			return nil, err
+		}
+	}
 
 	if u.vms[cfg.Name] != nil {
 		return nil, fmt.Errorf("universe already has a VM named %q", cfg.Name)
 	}
 
 	vmcfg := &config.VM{
-		Name:         cfg.Name,
-		DiskFile:     randomDiskName(),
-		MemoryMiB:    cfg.MemoryMiB,
-		PortForwards: map[int]int{},
-		Networks:     cfg.Networks,
-		MAC:          map[string]string{},
-		IPv4:         map[string]net.IP{},
-		IPv6:         map[string]net.IP{},
+		Name:           cfg.Name,
+		DiskFile:       randomDiskName(),
+		MemoryMiB:      cfg.MemoryMiB,
+		CPUs:           cfg.CPUs,
+		CPUModel:       cfg.CPUModel,
+		PortForwards:   map[int]int{},
+		Networks:       cfg.Networks,
+		MAC:            map[string]string{},
+		IPv4:           map[string]net.IP{},
+		IPv6:           map[string]net.IP{},
+		MTU:            map[string]int{},
+		DiskLimits:     cfg.Disk.toConfig(),
+		MachineType:    cfg.MachineType,
+		TmpfsDisk:      cfg.TmpfsDisk,
+		Firmware:       cfg.Firmware,
+		SecureBoot:     cfg.SecureBoot,
+		TPM:            cfg.TPM,
+		NestedVirt:     cfg.NestedVirt,
+		HotplugNICs:    cfg.HotplugNICs,
+		GuestAgent:     true,
+		AuthorizedKeys: cfg.AuthorizedKeys,
+		Arch:           img.Arch,
+	}
+	if cfg.kernelConfig == nil {
+		vmcfg.Kernel, vmcfg.Initrd = img.Kernel, img.Initrd
+		if u.runtimecfg.Network == NetworkBridged {
+			vmcfg.Bridge, vmcfg.BridgeMAC = u.runtimecfg.Bridge, randomMAC()
+		}
 	}
 	if vmcfg.Name == "" {
 		vmcfg.Name = randomHostname()
@@ -192,15 +499,47 @@ func (u *Universe) newVMWithLock(cfg *VMConfig) (*VM, error) {
 	if vmcfg.MemoryMiB == 0 {
 		vmcfg.MemoryMiB = 1024
 	}
+	if vmcfg.CPUs == 0 {
+		vmcfg.CPUs = 1
+	}
+	if qemu, err := u.qemuFor(vmcfg.Arch); err == nil && qemu.Has(CapBalloon) {
+		vmcfg.Balloon = true
+	}
+	vmcfg.CloudInit = cfg.CloudInit.toConfig(vmcfg.Name)
+	for i := range cfg.Devices {
+		vmcfg.Devices = append(vmcfg.Devices, cfg.Devices[i].toConfig())
+	}
+	if err := u.checkDevicesFreeWithLock(vmcfg.Devices); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Mounts {
+		m, err := cfg.Mounts[i].toConfig()
+		if err != nil {
+			return nil, err
+		}
+		vmcfg.Mounts = append(vmcfg.Mounts, m)
+	}
+	// Pinned addresses are claimed right away, and released if the
+	// VM isn't created after all.
+	created := false
+	defer func() {
+		if !created {
+			u.unclaimAddressesWithLock(vmcfg)
+		}
+	}()
 	for _, net := range vmcfg.Networks {
 		nw := u.networks[net]
 		if nw == nil {
 			return nil, fmt.Errorf("universe doesn't have a network named %q", net)
 		}
-		ip4, ip6 := nw.ip()
-		vmcfg.MAC[net] = randomMAC()
+		mac, ip4, ip6, err := nw.addresses(vmOwner(vmcfg.Name), cfg.Addresses[net])
+		if err != nil {
+			return nil, err
+		}
+		vmcfg.MAC[net] = mac
 		vmcfg.IPv4[net] = ip4
 		vmcfg.IPv6[net] = ip6
+		vmcfg.MTU[net] = nw.MTU()
 	}
 	wantPorts := []int{}
 	if cfg.PortForwards == nil {
@@ -210,68 +549,289 @@ func (u *Universe) newVMWithLock(cfg *VMConfig) (*VM, error) {
 	for fwd := range cfg.PortForwards {
 		wantPorts = append(wantPorts, fwd)
 	}
-	sort.Ints(wantPorts)
-	for _, fwd := range wantPorts {
-		vmcfg.PortForwards[fwd] = u.port()
+	u.allocPortsWithLock(vmcfg, wantPorts)
+
+	if backingPath == "" {
+		if cfg.kernelConfig != nil {
+			if cfg.TmpfsDisk {
+				return nil, errors.New("image builds can't use TmpfsDisk")
+			}
+			vmcfg.DiskFile = img.File
+		}
+		backingPath, backingFormat = filepath.Join(u.dir, img.File), "qcow2"
 	}
 
-	img := u.images[cfg.Image]
-	if img == "" {
-		return nil, fmt.Errorf("universe doesn't have an image named %q", cfg.Image)
+	if cfg.TmpfsDisk {
+		disk, err := u.tmpfsDiskWithLock()
+		if err != nil {
+			return nil, err
+		}
+		vmcfg.DiskFile = disk
 	}
 
 	if cfg.kernelConfig == nil {
-		disk := exec.Command(
+		disk := exec.CommandContext(
+			ctx,
 			"qemu-img",
 			"create",
 			"-f", "qcow2",
-			"-b", filepath.Join(u.dir, img),
-			"-f", "qcow2",
-			filepath.Join(u.dir, vmcfg.DiskFile),
+			"-b", backingPath,
+			"-F", backingFormat,
+			u.diskPath(vmcfg.DiskFile),
 		)
 		out, err := disk.CombinedOutput()
 		if err != nil {
 			return nil, fmt.Errorf("creating VM disk: %v\n%s", err, string(out))
 		}
-	} else {
-		vmcfg.DiskFile = img
 	}
 
+	if vmcfg.Firmware == FirmwareUEFI {
+		if err := u.createVarStore(ctx, vmcfg); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range cfg.Disks {
+		if cfg.kernelConfig != nil {
+			return nil, errors.New("image builds can't have extra disks")
+		}
+		d := cfg.Disks[i].toConfig()
+		d.File = randomDiskName()
+		out, err := exec.CommandContext(ctx, "qemu-img", "create", "-f", d.Format, u.diskPath(d.File), fmt.Sprintf("%dM", d.SizeMiB)).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("creating VM disk %d: %v\n%s", i+1, err, string(out))
+		}
+		vmcfg.Disks = append(vmcfg.Disks, d)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	vm, err := u.mkVM(vmcfg, cfg.kernelConfig, false)
 	if err != nil {
 		return nil, fmt.Errorf("creating VM: %v", err)
 	}
+	created = true
 
 	return vm, nil
 }
 
+// validateVMConfig checks that cfg can be run by the universe's QEMU
+// for arch.
+func (u *Universe) validateVMConfig(cfg *VMConfig, arch string) error {
+	if cfg.MemoryMiB < 0 {
+		return fmt.Errorf("invalid MemoryMiB %d", cfg.MemoryMiB)
+	}
+	if cfg.CPUs < 0 {
+		return fmt.Errorf("invalid CPUs %d", cfg.CPUs)
+	}
+	if cfg.CPUModel == "host" && (u.runtimecfg.NoAcceleration || !canAccelerate(arch)) {
+		return errors.New(`CPUModel "host" requires KVM acceleration`)
+	}
+	machine := cfg.MachineType
+	if machine == "" {
+		machine = defaultMachineTypeFor(arch)
+	}
+	if err := u.checkMachineType(machine, arch); err != nil {
+		return err
+	}
+	if err := validateFirmware(cfg, arch, machine); err != nil {
+		return err
+	}
+	if err := u.validateNestedVirt(cfg, arch); err != nil {
+		return err
+	}
+	if len(cfg.Networks) > maxVMNetworks {
+		// Their NICs would run into the balloon's and extra disks'
+		// PCI slots.
+		return fmt.Errorf("too many networks, VMs can be attached to %d networks at most", maxVMNetworks)
+	}
+	if err := validateAddresses(cfg.Addresses, cfg.Networks); err != nil {
+		return err
+	}
+	if err := validateHotplugNICs(cfg.HotplugNICs, machine); err != nil {
+		return err
+	}
+	if err := u.validateDevices(cfg.Devices, arch); err != nil {
+		return err
+	}
+	if err := validateAuthorizedKeys(cfg.AuthorizedKeys); err != nil {
+		return err
+	}
+	if err := cfg.Disk.validate(); err != nil {
+		return err
+	}
+	if err := validateDisks(cfg.Disks); err != nil {
+		return err
+	}
+	qemu, err := u.qemuFor(arch)
+	if err != nil {
+		return err
+	}
+	if err := validateMounts(cfg.Mounts, qemu); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkMachineType checks that the universe's QEMU for arch can
+// emulate machine.
+func (u *Universe) checkMachineType(machine, arch string) error {
+	qemu, err := u.qemuFor(arch)
+	if err != nil {
+		return fmt.Errorf("detecting QEMU for %s: %v", normalizeArch(arch), err)
+	}
+	if !qemu.HasMachineType(machine) {
+		return fmt.Errorf("QEMU %s at %s doesn't support machine type %q", qemu.Version, qemu.Path, machine)
+	}
+	return nil
+}
+
+// machineType returns the QEMU machine type of the VM in cfg.
+func machineType(cfg *config.VM) string {
+	// VMs saved before machine types were configurable have none.
+	if cfg.MachineType == "" {
+		return defaultMachineTypeFor(cfg.Arch)
+	}
+	return cfg.MachineType
+}
+
+// destroyVM shuts down the named VM and removes it from the
+// universe. The VM's disk is deleted, unless a saved snapshot still
+// needs it.
+func (u *Universe) destroyVM(name string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	vm := u.vms[name]
+	if vm == nil {
+		return fmt.Errorf("universe doesn't have a VM named %q", name)
+	}
+	if err := vm.Close(); err != nil {
+		return err
+	}
+	delete(u.vms, name)
+	u.unclaimAddressesWithLock(vm.cfg)
+
+	used := map[string]bool{}
+	for _, snap := range u.cfg.Snapshots {
+		for _, vmcfg := range snap.VMs {
+			for _, file := range vmcfg.Files() {
+				used[file] = true
+			}
+		}
+	}
+	for _, file := range vm.cfg.Files() {
+		if used[file] {
+			continue
+		}
+		if err := os.Remove(u.diskPath(file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (u *Universe) resumeVM(cfg *config.VM) (*VM, error) {
 	u.mu.Lock()
 	defer u.mu.Unlock()
+	if err := u.checkMachineType(machineType(cfg), cfg.Arch); err != nil {
+		return nil, fmt.Errorf("resuming VM %q: %v", cfg.Name, err)
+	}
 	return u.mkVM(cfg, nil, true)
 }
 
 // Start starts the virtual machine and waits for it to finish
-// booting.
-func (v *VM) Start() error {
-	if err := v.boot(); err != nil {
+// booting. If ctx is canceled first, the VM is killed and closed, and
+// Start returns ctx.Err().
+func (v *VM) Start(ctx context.Context) error {
+	// Killing the VM makes whatever step of booting is in progress
+	// fail.
+	stop := context.AfterFunc(ctx, v.abort)
+	err := v.start()
+	if !stop() {
+		v.Close()
+		return ctx.Err()
+	}
+	return err
+}
+
+func (v *VM) start() error {
+	start := time.Now()
+	prog := v.universe.progress(v.cfg.Name, "booting", 0)
+	if err := prog.done(v.boot()); err != nil {
 		return err
 	}
 
-	if _, err := v.Run("hostnamectl set-hostname " + v.cfg.Name); err != nil {
+	if err := v.configure(); err != nil {
 		v.Close()
 		return err
 	}
 
+	if v.cfg.CloudInit != nil {
+		prog := v.universe.progress(v.cfg.Name, "running cloud-init", 0)
+		if err := prog.done(v.waitCloudInit()); err != nil {
+			return err
+		}
+	}
+
+	v.universe.timings.recordBoot(v.cfg.Name, time.Since(start))
+	v.universe.emit(EventVMReady, v.cfg.Name, "")
+	return nil
+}
+
+// configure applies the VM's runtime configuration, which doesn't
+// survive the guest booting: its hostname, network addresses, mounts,
+// link conditions and partitions.
+func (v *VM) configure() error {
+	if _, err := v.Run("hostnamectl set-hostname " + v.cfg.Name); err != nil {
+		return err
+	}
+
+	if err := v.installAuthorizedKeys(); err != nil {
+		return err
+	}
+
+	if err := v.installUpstream(); err != nil {
+		return err
+	}
+
 	for i, net := range v.cfg.Networks {
-		interfaceID := i + 5 // the PCI slot layout on these VMs means the NICs start at ens4.
+		dev := nicInterface(i)
 		err := v.RunMultiple(
-			fmt.Sprintf("ip addr add %s/24 dev enp0s%d", v.cfg.IPv4[net], interfaceID),
-			fmt.Sprintf("ip addr add %s/24 dev enp0s%d", v.cfg.IPv6[net], interfaceID),
-			fmt.Sprintf("ip link set dev enp0s%d up", interfaceID),
+			fmt.Sprintf("ip addr add %s/24 dev %s", v.cfg.IPv4[net], dev),
+			fmt.Sprintf("ip addr add %s/64 dev %s", v.cfg.IPv6[net], dev),
+			fmt.Sprintf("ip link set dev %s mtu %d", dev, v.MTU(net)),
+			fmt.Sprintf("ip link set dev %s up", dev),
 		)
 		if err != nil {
-			v.Close()
+			return err
+		}
+	}
+	for _, nic := range v.NICs() {
+		if err := v.configureNIC(nic); err != nil {
+			return err
+		}
+	}
+
+	if v.cfg.Bridge != "" {
+		if err := v.configureLAN(); err != nil {
+			return err
+		}
+	}
+
+	if err := v.mountShares(); err != nil {
+		return err
+	}
+
+	if len(v.cfg.Links) > 0 {
+		if err := v.applyLinks(); err != nil {
+			return err
+		}
+	}
+
+	if len(v.cfg.Partitioned) > 0 {
+		if err := v.applyPartition(); err != nil {
 			return err
 		}
 	}
@@ -287,33 +847,26 @@ func (v *VM) boot() error {
 		return errors.New("already started")
 	}
 
-	if _, err := fmt.Fprintf(v.monIn, "cont\n"); err != nil {
-		v.closeWithLock()
-		return err
-	}
-	if _, err := readToPrompt(v.monOut); err != nil {
+	if err := v.universe.backend.startCPUs(v); err != nil {
 		v.closeWithLock()
 		return err
 	}
 
-	// Try dialing SSH
-	for {
+	// Try dialing SSH, which fails until the VM has booted.
+	deadline := time.Now().Add(v.universe.bootTimeout())
+	for attempt := 1; ; attempt++ {
 		select {
 		case <-v.stopped:
-			return errors.New("timeout")
+			return errors.New("VM stopped while booting")
 		default:
 		}
 
-		sshCfg := &ssh.ClientConfig{
-			User:            "root",
-			Auth:            []ssh.AuthMethod{ssh.Password("root")},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         time.Second,
-		}
-
-		client, err := ssh.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", v.ForwardedPort(22)), sshCfg)
+		client, err := ssh.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", v.forwardedPortWithLock(22)), vmSSHConfig(v.universe.sshKey))
 		if err != nil {
-			time.Sleep(100 * time.Millisecond)
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%w after %s: %w: %v", ErrBootTimeout, v.universe.bootTimeout(), ErrSSHUnreachable, err)
+			}
+			time.Sleep(backoff(attempt, 100*time.Millisecond, 2*time.Second))
 			continue
 		}
 
@@ -328,14 +881,11 @@ func (v *VM) boot() error {
 	if _, err := v.runWithSession(sess, "timedatectl set-ntp false", nil); err != nil {
 		return err
 	}
-	sess, err = v.ssh.NewSession()
-	if err != nil {
-		return err
-	}
-	if _, err := v.runWithSession(sess, fmt.Sprintf("timedatectl set-time %q", v.universeStartTime.Add(time.Since(v.universeOpenTime)).Format("2006-01-02 15:04:05")), nil); err != nil {
+	if err := v.syncClockWithLock(); err != nil {
 		return err
 	}
 
+	v.universe.emit(EventVMStarted, v.cfg.Name, "")
 	return nil
 }
 
@@ -373,21 +923,35 @@ func (v *VM) RunWithInput(command string, stdin io.Reader) ([]byte, error) {
 
 // does not hold v.mu, you can't access any protected members!
 func (v *VM) runWithSession(sess *ssh.Session, command string, stdin io.Reader) ([]byte, error) {
+	out, err := v.runWithSessionOutput(sess, command, stdin)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// runWithSessionOutput is runWithSession, but also returns the
+// output of failed commands.
+func (v *VM) runWithSessionOutput(sess *ssh.Session, command string, stdin io.Reader) ([]byte, error) {
 	defer sess.Close()
 	var out bytes.Buffer
 	sess.Stdin = stdin
 	sess.Stdout = &out
-	sess.Stderr = &out
-	if v.commandLog != nil {
-		sess.Stdout = io.MultiWriter(&out, v.commandLog)
-		sess.Stderr = sess.Stdout
-		fmt.Fprintf(v.commandLog, "[%s] %s\n", v.cfg.Name, command)
+	v.log.Info(logCommand, "command", command)
+	logOut, flush := logOutputWriter(v.log)
+	if logOut != nil {
+		sess.Stdout = io.MultiWriter(&out, logOut)
 	}
-
-	if err := sess.Run(command); err != nil {
-		return nil, err
-	}
-	return out.Bytes(), nil
+	// Stdout and Stderr are written concurrently, so they share a
+	// writer with a lock.
+	sess.Stdout = &lockedWriter{w: sess.Stdout}
+	sess.Stderr = sess.Stdout
+
+	start := time.Now()
+	err := sess.Run(command)
+	flush()
+	logCommandResult(v.log, command, start, err)
+	return out.Bytes(), err
 }
 
 // RunMultiple runs all given commands sequentially. It stops at the
@@ -401,6 +965,41 @@ func (v *VM) RunMultiple(commands ...string) error {
 	return nil
 }
 
+// aptGet runs apt-get non-interactively on the VM, once for each of
+// the given argument lists, e.g. aptGet("update", "install foo"). It
+// stops at the first unsuccessful run and returns its error.
+//
+// apt-get fails without changing anything when another process holds
+// the apt or dpkg lock, as apt-daily does for a while after boot, so
+// those failures are retried with backoff, and fail with ErrAptLocked
+// if the lock isn't released. Arbitrary commands aren't retried like
+// this, they may not be safe to run twice.
+func (v *VM) aptGet(args ...string) error {
+	for _, arg := range args {
+		command := "DEBIAN_FRONTEND=noninteractive apt-get -y " + arg
+		for attempt := 1; ; attempt++ {
+			v.mu.Lock()
+			sess, err := v.ssh.NewSession()
+			v.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			out, err := v.runWithSessionOutput(sess, command, nil)
+			if err == nil {
+				break
+			} else if !aptLocked(out) {
+				return err
+			} else if attempt == aptLockAttempts {
+				return fmt.Errorf("%w: %w", ErrAptLocked, err)
+			}
+			wait := backoff(attempt, time.Second, 30*time.Second)
+			v.log.Warn("apt is locked, retrying", "command", command, "attempt", attempt, "wait", wait)
+			time.Sleep(wait)
+		}
+	}
+	return nil
+}
+
 // WriteFile writes bs to the given path on the VM.
 func (v *VM) WriteFile(path string, bs []byte) error {
 	v.mu.Lock()
@@ -412,9 +1011,7 @@ func (v *VM) WriteFile(path string, bs []byte) error {
 	}
 	defer sess.Close()
 	sess.Stdin = bytes.NewBuffer(bs)
-	if v.commandLog != nil {
-		fmt.Fprintf(v.commandLog, "[%s] (write file %s)\n", v.cfg.Name, path)
-	}
+	v.log.Info("write file", "path", path)
 
 	return sess.Run("cat >" + path)
 }
@@ -429,9 +1026,7 @@ func (v *VM) ReadFile(path string) ([]byte, error) {
 		return nil, err
 	}
 	defer sess.Close()
-	if v.commandLog != nil {
-		fmt.Fprintf(v.commandLog, "[%s] (read file %s)\n", v.cfg.Name, path)
-	}
+	v.log.Info("read file", "path", path)
 	return sess.Output("cat " + path)
 }
 
@@ -444,6 +1039,12 @@ func (v *VM) Dial(network, addr string) (net.Conn, error) {
 	return sshCopy.Dial(network, addr)
 }
 
+// SSH opens a new SSH connection as root to the VM, separate from
+// the one virtuakube uses to control it. The caller must close it.
+func (v *VM) SSH() (*ssh.Client, error) {
+	return dialVM(v.ForwardedPort(22), v.universe.sshKey)
+}
+
 // Close shuts down the VM, reverting all changes since the universe
 // was last saved.
 func (v *VM) Close() error {
@@ -452,6 +1053,15 @@ func (v *VM) Close() error {
 	return v.closeWithLock()
 }
 
+// abort kills the VM's process without taking v.mu, to interrupt
+// the operation holding it. The VM must still be closed afterwards.
+func (v *VM) abort() {
+	v.universe.procsMu.Lock()
+	v.aborted = true
+	v.universe.procsMu.Unlock()
+	v.cmd.Process.Kill()
+}
+
 func (v *VM) closeWithLock() error {
 	if v.closed {
 		return nil
@@ -472,18 +1082,12 @@ func (v *VM) freeze(snapshot string) error {
 
 	// Stop the VM CPUs, so we're not competing with the VM while
 	// snapshotting.
-	if _, err := fmt.Fprintf(v.monIn, "stop\n"); err != nil {
-		return err
-	}
-	if _, err := readToPrompt(v.monOut); err != nil {
+	if err := v.universe.backend.stopCPUs(v); err != nil {
 		return err
 	}
 
 	// Write the snapshot.
-	if _, err := fmt.Fprintf(v.monIn, "savevm %s\n", snapshot); err != nil {
-		return err
-	}
-	if _, err := readToPrompt(v.monOut); err != nil {
+	if err := v.universe.backend.saveVM(v, snapshot); err != nil {
 		return err
 	}
 
@@ -491,7 +1095,7 @@ func (v *VM) freeze(snapshot string) error {
 	// instead we wait for the context to get canceled, which will get
 	// triggered by the goroutine that's waiting for the qemu process
 	// to exit.
-	if _, err := fmt.Fprintf(v.monIn, "quit\n"); err != nil {
+	if err := v.universe.backend.quit(v); err != nil {
 		return err
 	}
 	<-v.stopped
@@ -499,6 +1103,104 @@ func (v *VM) freeze(snapshot string) error {
 	return nil
 }
 
+// checkpoint writes a snapshot of the running VM's disk and memory
+// state. Unlike freeze, the VM keeps running afterwards: qemu pauses
+// the CPUs for the duration of the save, and leaves them as they
+// were when done.
+func (v *VM) checkpoint(snapshot string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("cannot checkpoint closed VM")
+	}
+	return v.universe.backend.saveVM(v, snapshot)
+}
+
+// stopForCheckpoint stops the VM's CPUs ahead of a checkpoint, and
+// reports whether it did. VMs paused by Pause are already stopped,
+// and are left for Resume to restart.
+func (v *VM) stopForCheckpoint() (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return false, errors.New("cannot checkpoint closed VM")
+	}
+	if v.paused {
+		return false, nil
+	}
+	if err := v.universe.backend.stopCPUs(v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// startAfterCheckpoint restarts the CPUs that stopForCheckpoint
+// stopped, unless the VM has since been paused or closed.
+func (v *VM) startAfterCheckpoint() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed || v.paused {
+		return nil
+	}
+	return v.universe.backend.startCPUs(v)
+}
+
+// deleteSnapshot deletes a snapshot from the running VM's disk.
+func (v *VM) deleteSnapshot(snapshot string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("cannot delete snapshot of closed VM")
+	}
+	return v.universe.backend.deleteSnapshot(v, snapshot)
+}
+
+// monitorWithLock runs command on the qemu monitor, and returns its
+// output.
+func (v *VM) monitorWithLock(command string) (string, error) {
+	if _, err := fmt.Fprintf(v.monIn, "%s\n", command); err != nil {
+		return "", err
+	}
+	out, err := readToPrompt(v.monOut)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(out, "Error") {
+		return out, fmt.Errorf("qemu monitor command %q: %s", command, out)
+	}
+	return out, nil
+}
+
+// ConsoleSocket returns the path of a Unix socket connected to the
+// VM's serial console. The console is a fallback for when SSH is
+// unreachable, e.g. because the VM's networking is broken. Only one
+// client can be connected at a time, and disconnecting doesn't
+// affect the VM.
+func (v *VM) ConsoleSocket() string {
+	return v.consoleSock
+}
+
+// ConsoleLog returns the path of the file that captures everything
+// the VM writes to its serial console, such as kernel messages. The
+// log survives the VM and the universe, and keeps growing across
+// runs.
+func (v *VM) ConsoleLog() string {
+	return ConsoleLog(v.universe.dir, v.cfg.Name)
+}
+
+// ConsoleLog returns the path of the console log of the named VM in
+// the universe in dir, like VM.ConsoleLog. The universe doesn't need
+// to be running.
+func ConsoleLog(dir, vm string) string {
+	return filepath.Join(dir, consoleLogFile(vm))
+}
+
+// consoleLogFile returns the console log file of the named VM,
+// relative to the universe directory.
+func consoleLogFile(vm string) string {
+	return "console-" + vm + ".log"
+}
+
 // Hostname returns the configured hostname of the VM. It might be
 // different from the VM's actual hostname if its hostname was changed
 // after boot by something other than virtuakube.
@@ -506,6 +1208,14 @@ func (v *VM) Hostname() string {
 	return v.cfg.Name
 }
 
+// CPUs returns the number of vCPUs the VM has.
+func (v *VM) CPUs() int {
+	if v.cfg.CPUs == 0 {
+		return 1
+	}
+	return v.cfg.CPUs
+}
+
 // Networks returns the networks to which the VM is connected.
 func (v *VM) Networks() []string {
 	ret := []string{}
@@ -518,6 +1228,12 @@ func (v *VM) Networks() []string {
 // ForwardedPort returns the port on localhost that maps to the given
 // port on the VM.
 func (v *VM) ForwardedPort(dst int) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.forwardedPortWithLock(dst)
+}
+
+func (v *VM) forwardedPortWithLock(dst int) int {
 	return v.cfg.PortForwards[dst]
 }
 
@@ -527,6 +1243,14 @@ func (v *VM) IPv4(network string) net.IP { return v.cfg.IPv4[network] }
 // IPv6 returns the LAN IPv6 address of the VM.
 func (v *VM) IPv6(network string) net.IP { return v.cfg.IPv6[network] }
 
+// MTU returns the MTU of the VM's interface on network.
+func (v *VM) MTU(network string) int {
+	if mtu := v.cfg.MTU[network]; mtu != 0 {
+		return mtu
+	}
+	return DefaultMTU
+}
+
 var (
 	qemuPrompt = []byte("\r\n(qemu) ")
 	ansiCSI_K  = []byte("\x1b[K")
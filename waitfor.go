@@ -0,0 +1,200 @@
+package virtuakube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// conditionInterval is how often WaitFor rechecks the conditions that
+// don't hold yet.
+const conditionInterval = time.Second
+
+// A Condition is a state of the universe that WaitFor waits for.
+type Condition struct {
+	// Name describes the condition in WaitFor's errors, e.g.
+	// "2 nodes of cluster c1 ready".
+	Name string
+	// Check reports whether the condition holds. An error stops the
+	// wait, so checks should return false rather than an error for
+	// failures that may go away, such as an API server that isn't
+	// answering yet.
+	Check func(ctx context.Context, u *Universe) (bool, error)
+}
+
+// WaitFor waits until all the conditions have held, checking those
+// that don't yet every second. A condition that held once isn't
+// checked again. WaitFor returns an error naming the conditions that
+// never held if ctx is done first, or if the universe is closed.
+func (u *Universe) WaitFor(ctx context.Context, conditions ...Condition) error {
+	pending := append([]Condition{}, conditions...)
+	prog := u.progress("universe", "waiting for conditions", int64(len(conditions)))
+	for {
+		var next []Condition
+		for _, cond := range pending {
+			ok, err := cond.Check(ctx, u)
+			if err != nil {
+				return prog.done(fmt.Errorf("checking %s: %v", cond.Name, err))
+			}
+			if !ok {
+				next = append(next, cond)
+			}
+		}
+		pending = next
+		prog.update(int64(len(conditions) - len(pending)))
+		if len(pending) == 0 {
+			return prog.done(nil)
+		}
+
+		t := time.NewTimer(conditionInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return prog.done(fmt.Errorf("waiting for %s: %v", conditionNames(pending), ctx.Err()))
+		case <-u.closedCh:
+			t.Stop()
+			return prog.done(fmt.Errorf("waiting for %s: universe closed", conditionNames(pending)))
+		case <-t.C:
+		}
+	}
+}
+
+func conditionNames(conds []Condition) string {
+	var names []string
+	for _, cond := range conds {
+		names = append(names, cond.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// VMSSHReady holds once the named VM accepts SSH connections and runs
+// commands. The VM need not exist yet.
+func VMSSHReady(vm string) Condition {
+	return Condition{
+		Name: fmt.Sprintf("SSH on VM %s", vm),
+		Check: func(ctx context.Context, u *Universe) (bool, error) {
+			v := u.VM(vm)
+			if v == nil {
+				return false, nil
+			}
+			client, err := v.SSH()
+			if err != nil {
+				return false, nil
+			}
+			defer client.Close()
+			sess, err := client.NewSession()
+			if err != nil {
+				return false, nil
+			}
+			defer sess.Close()
+			return sess.Run("true") == nil, nil
+		},
+	}
+}
+
+// ClusterNodesReady holds once at least n nodes of the named cluster,
+// control plane nodes included, are Ready.
+func ClusterNodesReady(cluster string, n int) Condition {
+	return Condition{
+		Name: fmt.Sprintf("%d nodes of cluster %s ready", n, cluster),
+		Check: func(ctx context.Context, u *Universe) (bool, error) {
+			client, ok := clusterClient(u, cluster)
+			if !ok {
+				return false, nil
+			}
+			nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+			if err != nil {
+				return false, nil
+			}
+			ready := 0
+			for _, node := range nodes.Items {
+				if nodeReady(node) {
+					ready++
+				}
+			}
+			return ready >= n, nil
+		},
+	}
+}
+
+// DeploymentAvailable holds once the deployment namespace/name in the
+// named cluster has rolled out its current spec, and all its replicas
+// are available.
+func DeploymentAvailable(cluster, namespace, name string) Condition {
+	return Condition{
+		Name: fmt.Sprintf("deployment %s/%s in cluster %s available", namespace, name, cluster),
+		Check: func(ctx context.Context, u *Universe) (bool, error) {
+			client, ok := clusterClient(u, cluster)
+			if !ok {
+				return false, nil
+			}
+			d, err := client.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			return deploymentAvailable(d), nil
+		},
+	}
+}
+
+func deploymentAvailable(d *appsv1.Deployment) bool {
+	if d.Status.ObservedGeneration < d.Generation {
+		return false
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	if d.Status.UpdatedReplicas < replicas || d.Status.AvailableReplicas < replicas {
+		return false
+	}
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentAvailable {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// URLReachable holds once an HTTP GET of url from the host gets a
+// response that isn't a server error, e.g. through a VM's forwarded
+// port.
+func URLReachable(url string) Condition {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return Condition{
+		Name: fmt.Sprintf("%s reachable", url),
+		Check: func(ctx context.Context, u *Universe) (bool, error) {
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+			if err != nil {
+				return false, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return false, nil
+			}
+			resp.Body.Close()
+			return resp.StatusCode < 500, nil
+		},
+	}
+}
+
+// clusterClient returns the Kubernetes client of the named cluster,
+// once the cluster exists and has an API server.
+func clusterClient(u *Universe, name string) (*kubernetes.Clientset, bool) {
+	c := u.Cluster(name)
+	if c == nil {
+		return nil, false
+	}
+	client := c.KubernetesClient()
+	if client == nil {
+		return nil, false
+	}
+	return client, true
+}